@@ -2,74 +2,457 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 	"kubeforge/internal/api"
+	"kubeforge/internal/audit"
+	"kubeforge/internal/auth"
 	"kubeforge/internal/config"
 	"kubeforge/internal/db"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/notify"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/retention"
+	"kubeforge/internal/secrets"
+	"kubeforge/internal/sigv4"
+	"kubeforge/internal/tracing"
+	"kubeforge/web"
 )
 
-func main() {
-	log.Println("Starting KubeForge server...")
+// version is reported by the health probes
+const version = "1.0.0"
 
+func main() {
 	// Load configuration
-	cfg := config.Load()
+	configFlag := flag.String("config", "", "YAML or TOML config file (default $KUBEFORGE_CONFIG or ./"+config.DefaultPath+")")
+	encryptSecrets := flag.Bool("encrypt-secrets", false, "encrypt stored secrets with the current key, e.g. after setting or rotating it, and exit")
+	flag.Parse()
+	configPath := config.ResolvePath(*configFlag)
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logging.Fatal("Failed to load configuration", "error", err)
+	}
+
+	// Structured logging, the stdlib log package writes through it as well
+	if err := logging.Setup(os.Stderr, cfg.Logger.Level, cfg.Logger.Format); err != nil {
+		logging.Fatal("Invalid logging configuration", "error", err)
+	}
+	slog.Info("Starting KubeForge server", "config", configPath)
+
+	// Export traces of requests, jobs and SSH commands
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logging.Fatal("Failed to configure tracing", "error", err)
+	}
+
+	// Configure encryption of secrets at rest
+	if err := configureSecrets(cfg.Secrets); err != nil {
+		logging.Fatal("Failed to configure encryption key", "error", err)
+	}
+	if !secrets.Enabled() {
+		slog.Warn("ENCRYPTION_KEY is not set: kubeconfigs, SSH keys and join tokens are stored unencrypted and addon credentials cannot be stored")
+	}
+
+	// Limit and retry SSH operations on hosts
+	provision.SetConcurrencyLimits(cfg.Provision.MaxParallelHosts, cfg.Provision.MaxConcurrentSSH)
+	applyProvisionSettings(cfg.Provision)
+
+	// Register out-of-tree provisioners
+	if cfg.Provision.PluginDir != "" {
+		loaded, err := provision.LoadPlugins(cfg.Provision.PluginDir)
+		for _, plugin := range loaded {
+			slog.Info("Loaded provisioner plugin", "name", plugin.Name, "version", plugin.Version, "path", plugin.Path)
+		}
+		if err != nil {
+			slog.Warn("Failed to load provisioner plugins", "dir", cfg.Provision.PluginDir, "error", err)
+		}
+	}
 
 	// Initialize database
-	if err := db.Init(db.Config{
-		Driver: cfg.Database.Driver,
-		DSN:    cfg.Database.DSN,
-	}); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	conn, err := db.Init(db.Config{
+		Driver:             cfg.Database.Driver,
+		DSN:                cfg.Database.DSN,
+		MaxOpenConns:       cfg.Database.MaxOpenConns,
+		MaxIdleConns:       cfg.Database.MaxIdleConns,
+		ConnMaxLifetime:    cfg.Database.ConnMaxLifetime,
+		LogLevel:           cfg.Database.LogLevel,
+		SlowQueryThreshold: cfg.Database.SlowQueryThreshold,
+	})
+	if err != nil {
+		logging.Fatal("Failed to initialize database", "error", err)
+	}
+	defer db.Close(conn)
+
+	// kubeforge-server migrate [status|copy] applies pending migrations, lists
+	// them or copies the database into another one, and exits
+	if flag.Arg(0) == "migrate" {
+		runMigrate(conn, flag.Arg(1), flag.Args()[min(2, flag.NArg()):], cfg.Database)
+		return
+	}
+	command := flag.Arg(0)
+	if command != "" && command != "export" && command != "import" {
+		logging.Fatal("Unknown command, expected migrate, export or import", "command", command)
+	}
+
+	// Bring the schema up to date, or refuse to run on an outdated one when
+	// migrations are applied separately
+	if cfg.Database.AutoMigrate {
+		if _, err := db.Migrate(conn); err != nil {
+			logging.Fatal("Failed to migrate database", "error", err)
+		}
+	} else if pending, err := db.PendingMigrations(conn); err != nil {
+		logging.Fatal("Failed to read migrations", "error", err)
+	} else if len(pending) > 0 {
+		logging.Fatal("Database has pending migrations, run kubeforge-server migrate", "pending", pending)
+	}
+
+	// Encrypt existing rows with the current key and exit
+	if *encryptSecrets {
+		count, err := db.EncryptSecrets(conn)
+		if err != nil {
+			logging.Fatal("Failed to encrypt secrets", "encrypted", count, "error", err)
+		}
+		slog.Info("Secrets encrypted with the current key", "values", count)
+		return
+	}
+
+	// kubeforge-server export|import move the state of the server between
+	// databases, and exit
+	if command != "" {
+		runState(conn, command, flag.Args()[1:])
+		return
+	}
+
+	// Stores of clusters, nodes, jobs and events
+	store := db.NewStore(conn)
+
+	// Hosts may reference SSH keys stored in the database
+	provision.SetKeyResolver(func(id uint, name string) ([]byte, error) {
+		key, err := db.FindSSHKey(conn, id, name)
+		if err != nil {
+			return nil, err
+		}
+		return key.PrivateKey, nil
+	})
+	// Host keys are trusted on first use and verified afterwards
+	provision.SetHostKeyStore(api.NewHostKeyStore(store))
+
+	// Machines of hosts with a machine spec are created on Proxmox VE, libvirt
+	// or vSphere
+	infra.SetProxmoxConfig(infra.ProxmoxConfig{
+		URL:                   cfg.Infra.ProxmoxURL,
+		TokenID:               cfg.Infra.ProxmoxTokenID,
+		TokenSecret:           cfg.Infra.ProxmoxTokenSecret,
+		InsecureSkipTLSVerify: cfg.Infra.ProxmoxInsecureSkipTLSVerify,
+	})
+	infra.SetLibvirtConfig(infra.LibvirtConfig{URI: cfg.Infra.LibvirtURI})
+	infra.SetVSphereConfig(infra.VSphereConfig{
+		URL:                   cfg.Infra.VSphereURL,
+		Username:              cfg.Infra.VSphereUsername,
+		Password:              cfg.Infra.VSpherePassword,
+		InsecureSkipTLSVerify: cfg.Infra.VSphereInsecureSkipTLSVerify,
+	})
+
+	// Configure authentication
+	jwtSecret := []byte(cfg.Auth.JWTSecret)
+	if len(jwtSecret) == 0 {
+		// Replicas sharing the database must sign tokens with the same key
+		if cfg.Database.Driver != "sqlite" {
+			logging.Fatal("JWT_SECRET must be set when replicas share the database: tokens signed with a random key are rejected by the other replicas", "driver", cfg.Database.Driver)
+		}
+		slog.Warn("JWT_SECRET is not set, using a random key: tokens will not survive restarts and are rejected by other replicas")
+		secret, err := auth.RandomSecret()
+		if err != nil {
+			logging.Fatal("Failed to generate JWT secret", "error", err)
+		}
+		jwtSecret = secret
+	}
+	tokens := auth.NewTokenManager(conn, jwtSecret, cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+
+	// Kubeconfig downloads may mint short-lived service account tokens
+	err = api.SetKubeconfigPolicy(api.KubeconfigPolicy{
+		Access: cfg.Auth.KubeconfigAccess,
+		TTL:    cfg.Auth.KubeconfigTTL,
+		MaxTTL: cfg.Auth.KubeconfigMaxTTL,
+		Role:   cfg.Auth.KubeconfigRole,
+	})
+	if err != nil {
+		logging.Fatal("Invalid kubeconfig access configuration", "error", err)
+	}
+
+	created, generated, err := auth.EnsureAdmin(conn, cfg.Auth.AdminUsername, cfg.Auth.AdminEmail, cfg.Auth.AdminPassword)
+	if err != nil {
+		logging.Fatal("Failed to create admin user", "error", err)
+	}
+	if created && generated != "" {
+		slog.Info("Created admin user with generated password", "username", cfg.Auth.AdminUsername, "password", generated)
+	} else if created {
+		slog.Info("Created admin user", "username", cfg.Auth.AdminUsername)
+	}
+
+	switch cfg.Audit.Sink {
+	case "":
+	case "file":
+		sink, err := audit.NewFileSink(cfg.Audit.File)
+		if err != nil {
+			logging.Fatal("Failed to open audit sink", "error", err)
+		}
+		defer sink.Close()
+		audit.SetSink(sink)
+	case "syslog":
+		sink, err := audit.NewSyslogSink("kubeforge")
+		if err != nil {
+			logging.Fatal("Failed to open audit sink", "error", err)
+		}
+		defer sink.Close()
+		audit.SetSink(sink)
+	default:
+		logging.Fatal("Unknown AUDIT_SINK, expected file or syslog", "sink", cfg.Audit.Sink)
+	}
+
+	// Configure notification channels
+	if cfg.Notify.SMTPHost != "" {
+		notify.SetSMTP(notify.SMTPConfig{
+			Host:     cfg.Notify.SMTPHost,
+			Port:     cfg.Notify.SMTPPort,
+			Username: cfg.Notify.SMTPUsername,
+			Password: cfg.Notify.SMTPPassword,
+			From:     cfg.Notify.SMTPFrom,
+		})
+	}
+	for channelType, config := range map[string]notify.ChannelConfig{
+		notify.TypeSlack: {WebhookURL: cfg.Notify.SlackWebhook},
+		notify.TypeTeams: {WebhookURL: cfg.Notify.TeamsWebhook},
+		notify.TypeEmail: {To: splitList(cfg.Notify.EmailTo)},
+	} {
+		if config.WebhookURL == "" && len(config.To) == 0 {
+			continue
+		}
+		notifier, err := notify.New(channelType, config)
+		if err != nil {
+			logging.Fatal("Failed to configure notifications", "channel", channelType, "error", err)
+		}
+		notify.Register(channelType, channelType, notifier, cfg.Notify.Template)
+		slog.Info("Notification channel configured", "channel", channelType)
+	}
+
+	// Prune old events, archiving them first if configured
+	var archiver retention.Archiver
+	switch cfg.Retention.Archive {
+	case "":
+	case "file":
+		archiver, err = retention.NewFileArchiver(cfg.Retention.ArchiveDir)
+	case "s3":
+		archiver, err = retention.NewS3Archiver(retention.S3Archiver{
+			Endpoint:  cfg.Retention.S3Endpoint,
+			Region:    cfg.Retention.S3Region,
+			Bucket:    cfg.Retention.S3Bucket,
+			Prefix:    cfg.Retention.S3Prefix,
+			AccessKey: cfg.Retention.S3AccessKey,
+			SecretKey: cfg.Retention.S3SecretKey,
+		})
+	default:
+		logging.Fatal("Unknown EVENT_ARCHIVE, expected file or s3", "archive", cfg.Retention.Archive)
+	}
+	if err != nil {
+		logging.Fatal("Failed to configure event archive", "error", err)
 	}
-	defer db.Close()
+	pruner := retention.NewPruner(conn, retentionPolicy(cfg.Retention), archiver, cfg.Jobs.InstanceID)
+	pruner.Start()
 
 	// Start WebSocket hub
 	go api.Hub.Run()
-	log.Println("WebSocket hub started")
+	slog.Info("WebSocket hub started")
 
 	// Create router
 	router := mux.NewRouter()
 
 	// Apply middleware (CORS must be first!)
 	router.Use(api.CORS)
+	router.Use(api.RequestID)
+	router.Use(api.Trace)
 	router.Use(api.Logger)
+	router.Use(api.YAMLResponses)
 	router.Use(api.Recovery)
-
-	// Health check endpoint
-	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		api.WriteSuccess(w, map[string]string{
-			"status":  "ok",
-			"version": "1.0.0",
-		})
-	}).Methods("GET")
+	router.Use(api.Authenticate(tokens))
+	router.Use(api.Audit(store))
+	router.Use(api.Authorize(store))
 
 	// WebSocket endpoint
-	router.HandleFunc("/ws/clusters/{id}/events", api.HandleWebSocket)
+	router.HandleFunc("/ws/clusters/{id}/events", api.HandleWebSocket(store))
+
+	// Cluster events are recorded in the event store
+	api.SetEventStore(store.Events)
+
+	// Job queue
+	queue := jobs.NewQueue(store.Jobs, conn, jobs.Config{
+		Workers:      cfg.Jobs.Workers,
+		PollInterval: cfg.Jobs.PollInterval,
+		InstanceID:   cfg.Jobs.InstanceID,
+		LeaseTTL:     cfg.Jobs.LeaseTTL,
+
+		ShutdownTimeout: cfg.Jobs.ShutdownTimeout,
+	})
+
+	// Liveness and readiness probes
+	healthHandler := api.NewHealthHandler(store, queue, version)
+	healthHandler.RegisterRoutes(router)
 
 	// API routes
-	clusterHandler := api.NewClusterHandler()
+	authHandler := api.NewAuthHandler(store, tokens)
+	authHandler.RegisterRoutes(router)
+
+	userHandler := api.NewUserHandler(store)
+	userHandler.RegisterRoutes(router)
+
+	projectHandler := api.NewProjectHandler(store)
+	projectHandler.RegisterRoutes(router)
+
+	auditHandler := api.NewAuditHandler(store)
+	auditHandler.RegisterRoutes(router)
+
+	stateHandler := api.NewStateHandler(store)
+	stateHandler.RegisterRoutes(router)
+
+	purgeHandler := api.NewPurgeHandler(pruner)
+	purgeHandler.RegisterRoutes(router)
+
+	templateHandler := api.NewTemplateHandler(store)
+	templateHandler.RegisterRoutes(router)
+
+	policyHandler := api.NewPolicyHandler(store)
+	policyHandler.RegisterRoutes(router)
+
+	clusterHandler := api.NewClusterHandler(store, queue)
 	clusterHandler.RegisterRoutes(router)
 
+	jobHandler := api.NewJobHandler(store, queue)
+	jobHandler.RegisterRoutes(router)
+
+	batchHandler := api.NewBatchHandler(store, queue)
+	batchHandler.RegisterRoutes(router)
+
+	graphqlHandler := api.NewGraphQLHandler(store)
+	graphqlHandler.RegisterRoutes(router)
+
+	addonHandler := api.NewAddonHandler(store, queue)
+	addonHandler.RegisterRoutes(router)
+
+	provisionerHandler := api.NewProvisionerHandler()
+	provisionerHandler.RegisterRoutes(router)
+
+	releaseHandler := api.NewReleaseHandler(store, queue)
+	releaseHandler.RegisterRoutes(router)
+
+	backupHandler := api.NewBackupHandler(store)
+	backupHandler.RegisterRoutes(router)
+
+	notificationHandler := api.NewNotificationHandler(store)
+	notificationHandler.RegisterRoutes(router)
+
+	hostKeyHandler := api.NewHostKeyHandler(store)
+	hostKeyHandler.RegisterRoutes(router)
+
+	hostHandler := api.NewHostHandler(store, queue)
+	hostHandler.RegisterRoutes(router)
+
+	eventStreamHandler := api.NewEventStreamHandler(store)
+	eventStreamHandler.RegisterRoutes(router)
+
+	alertHandler := api.NewAlertHandler(store)
+	alertHandler.RegisterRoutes(router)
+
+	versionHandler := api.NewVersionHandler()
+	versionHandler.RegisterRoutes(router)
+
+	// Web UI, after the API routes as it matches every path under /ui/
+	uiHandler := api.NewUIHandler(tokens, web.Dist)
+	uiHandler.RegisterRoutes(router)
+
+	// Start job workers after all job handlers are registered
+	queue.Start()
+
+	// Fail clusters whose job hangs or was lost
+	detector := api.NewStuckClusterDetector(store, queue, cfg.Jobs.StuckClusterTimeout, cfg.Jobs.InstanceID)
+	detector.Start()
+
+	// Sample node CPU and memory usage of ready clusters
+	collector := api.NewMetricsCollector(store, cfg.Metrics.Interval, cfg.Metrics.Retention, cfg.Jobs.InstanceID)
+	collector.Start()
+
+	// Upgrade clusters to new patch releases in their maintenance window
+	upgrader := api.NewAutoUpgrader(store, queue, cfg.Jobs.InstanceID)
+	upgrader.Start()
+
+	// Alert on degraded ready clusters
+	evaluator := api.NewAlertEvaluator(store, cfg.Alerts.Interval, alertRules(cfg.Alerts), cfg.Jobs.InstanceID)
+	evaluator.Start()
+
+	// Read the latest patch releases from the upstream feed
+	refresher := api.NewVersionRefresher(cfg.Versions.Feed, cfg.Versions.RefreshInterval)
+	refresher.Start()
+
 	// Create HTTP server
 	addr := cfg.Server.Host + ":" + cfg.Server.Port
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      router,
+		Handler:      api.LegacyPaths(router),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server listening on %s", addr)
+		slog.Info("Server listening", "addr", addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			logging.Fatal("Failed to start server", "error", err)
+		}
+	}()
+
+	// gRPC API, running the same routes
+	var grpcServer *api.GRPCServer
+	if cfg.Server.GRPCPort != "" {
+		grpcAddr := cfg.Server.Host + ":" + cfg.Server.GRPCPort
+		listener, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			logging.Fatal("Failed to start gRPC server", "error", err)
+		}
+		grpcServer = api.NewGRPCServer(router, store, tokens)
+		go func() {
+			slog.Info("gRPC server listening", "addr", grpcAddr)
+			if err := grpcServer.Serve(listener); err != nil {
+				logging.Fatal("Failed to start gRPC server", "error", err)
+			}
+		}()
+	}
+
+	// Reload tunables on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		current := cfg
+		for range reload {
+			current = reloadConfig(configPath, current, pruner, detector, evaluator)
 		}
 	}()
 
@@ -77,8 +460,9 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	signal.Stop(reload)
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
@@ -86,8 +470,303 @@ func main() {
 
 	// Attempt graceful shutdown
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
+	}
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
+	}
+
+	// Stop job workers
+	refresher.Stop()
+	evaluator.Stop()
+	upgrader.Stop()
+	collector.Stop()
+	detector.Stop()
+	queue.Stop()
+	pruner.Stop()
+
+	// Flush pending spans
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
+	slog.Info("Server exited")
+}
+
+// configureSecrets sets the master key that wraps the data keys of secrets
+// at rest: the local key, or Vault or a KMS, in which case a local key is
+// still used to read values sealed before
+func configureSecrets(cfg config.SecretsConfig) error {
+	key, previousKeys := cfg.EncryptionKey, splitList(cfg.PreviousKeys)
+	if cfg.KeyFile != "" {
+		var err error
+		if key, previousKeys, err = secrets.ReadKeyFile(cfg.KeyFile); err != nil {
+			return err
+		}
+	}
+	var local []secrets.KeyProvider
+	if key != "" {
+		ring, err := secrets.NewKeyringBase64(key, previousKeys)
+		if err != nil {
+			return err
+		}
+		local = append(local, ring)
+	}
+
+	var provider secrets.KeyProvider
+	var err error
+	switch cfg.Provider {
+	case "":
+		if len(local) > 0 {
+			secrets.SetProvider(local[0])
+		}
+		return nil
+	case "vault":
+		provider, err = secrets.NewVaultTransit(secrets.VaultTransit{
+			Address:   cfg.VaultAddr,
+			Token:     cfg.VaultToken,
+			TokenFile: cfg.VaultTokenFile,
+			Namespace: cfg.VaultNamespace,
+			Mount:     cfg.VaultTransit,
+			Key:       cfg.VaultTransitKey,
+		})
+	case "awskms":
+		provider, err = secrets.NewAWSKMS(secrets.AWSKMS{
+			Key:      cfg.AWSKMSKey,
+			Region:   cfg.AWSRegion,
+			Endpoint: cfg.AWSKMSEndpoint,
+			Credentials: sigv4.Credentials{
+				AccessKey:    cfg.AWSAccessKey,
+				SecretKey:    cfg.AWSSecretKey,
+				SessionToken: cfg.AWSSessionToken,
+			},
+		})
+	case "gcpkms":
+		provider, err = secrets.NewGCPKMS(secrets.GCPKMS{
+			Key:             cfg.GCPKMSKey,
+			CredentialsFile: cfg.GCPCredentialFile,
+		})
+	default:
+		return fmt.Errorf("unknown SECRETS_PROVIDER %q, expected vault, awskms or gcpkms", cfg.Provider)
+	}
+	if err != nil {
+		return err
+	}
+	secrets.SetProvider(provider, local...)
+	slog.Info("Secrets are encrypted with an external key", "provider", cfg.Provider, "key", provider.KeyID())
+	return nil
+}
+
+// reloadConfig loads the configuration again and applies the log level,
+// provisioning limits, retries and timeouts, the event retention policy,
+// the stuck cluster timeout and the alert thresholds. Other settings keep
+// their value until a restart. On error the current configuration stays in
+// effect.
+func reloadConfig(path string, current *config.Config, pruner *retention.Pruner, detector *api.StuckClusterDetector, evaluator *api.AlertEvaluator) *config.Config {
+	next, err := config.Load(path)
+	if err != nil {
+		slog.Error("Failed to reload configuration", "error", err)
+		return current
+	}
+	if err := logging.SetLevel(next.Logger.Level); err != nil {
+		slog.Error("Failed to reload configuration", "error", err)
+		return current
+	}
+
+	if next.Provision.MaxParallelHosts != current.Provision.MaxParallelHosts ||
+		next.Provision.MaxConcurrentSSH != current.Provision.MaxConcurrentSSH {
+		// Operations already running release their slot of the previous limit
+		provision.SetConcurrencyLimits(next.Provision.MaxParallelHosts, next.Provision.MaxConcurrentSSH)
+	}
+	applyProvisionSettings(next.Provision)
+	pruner.SetPolicy(retentionPolicy(next.Retention))
+	detector.SetTimeout(next.Jobs.StuckClusterTimeout)
+	evaluator.SetRules(alertRules(next.Alerts))
+
+	// Report settings that changed but need a restart
+	applied := *current
+	applied.Logger.Level = next.Logger.Level
+	applied.Provision = next.Provision
+	applied.Provision.PluginDir = current.Provision.PluginDir // plugins are loaded at startup
+	applied.Retention.EventMaxAge = next.Retention.EventMaxAge
+	applied.Retention.EventMaxPerCluster = next.Retention.EventMaxPerCluster
+	applied.Retention.Interval = next.Retention.Interval
+	applied.Jobs.StuckClusterTimeout = next.Jobs.StuckClusterTimeout
+	applied.Alerts.NodeNotReady = next.Alerts.NodeNotReady
+	applied.Alerts.CertificateExpiry = next.Alerts.CertificateExpiry
+	applied.Alerts.BackupMissing = next.Alerts.BackupMissing
+	if !reflect.DeepEqual(applied, *next) {
+		slog.Warn("Some changed settings take effect only after a restart")
 	}
 
-	log.Println("Server exited")
+	slog.Info("Configuration reloaded", "config", path)
+	return &applied
+}
+
+// applyProvisionSettings sets the retry policy, default step timeouts, SSH
+// agent socket, proxies, network check and smoke tests
+func applyProvisionSettings(cfg config.ProvisionConfig) {
+	provision.SetRetryPolicy(provision.RetryPolicy{
+		Attempts:       cfg.RetryAttempts,
+		InitialBackoff: cfg.RetryInitialBackoff,
+		MaxBackoff:     cfg.RetryMaxBackoff,
+	})
+	provision.SetDefaultStepTimeouts(provision.StepTimeouts{
+		Prepare:   provision.Duration(cfg.PrepareTimeout),
+		Bootstrap: provision.Duration(cfg.BootstrapTimeout),
+		CNI:       provision.Duration(cfg.CNITimeout),
+		Join:      provision.Duration(cfg.JoinTimeout),
+		Upgrade:   provision.Duration(cfg.UpgradeTimeout),
+		Machine:   provision.Duration(cfg.MachineTimeout),
+	})
+	provision.SetAgentSocket(cfg.SSHAgentSocket)
+	provision.SetProxy(provision.ProxySettings{
+		HTTP:    cfg.HTTPProxy,
+		HTTPS:   cfg.HTTPSProxy,
+		NoProxy: cfg.NoProxy,
+	})
+	provision.SetNetworkCheck(provision.NetworkCheckSettings{
+		Enabled: cfg.NetworkCheck,
+		Image:   cfg.NetworkCheckImage,
+	})
+	provision.SetVerify(cfg.Verify)
+}
+
+// retentionPolicy converts the retention settings to a pruning policy
+func retentionPolicy(cfg config.RetentionConfig) retention.Policy {
+	return retention.Policy{
+		MaxAge:        cfg.EventMaxAge,
+		MaxPerCluster: cfg.EventMaxPerCluster,
+		DeletedMaxAge: cfg.DeletedMaxAge,
+		Interval:      cfg.Interval,
+	}
+}
+
+// alertRules converts the alert settings to rule thresholds
+func alertRules(cfg config.AlertsConfig) api.AlertRules {
+	return api.AlertRules{
+		NodeNotReady:      cfg.NodeNotReady,
+		CertificateExpiry: cfg.CertificateExpiry,
+		BackupMissing:     cfg.BackupMissing,
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// runMigrate applies the pending migrations, or with status only lists the
+// migrations, and prints each with the time it was applied. With copy it
+// applies them and copies the database into the one given by -driver and
+// -dsn, e.g. to move from SQLite to PostgreSQL.
+func runMigrate(conn *gorm.DB, command string, args []string, source config.DatabaseConfig) {
+	switch command {
+	case "copy":
+		flags := flag.NewFlagSet("migrate copy", flag.ExitOnError)
+		driver := flags.String("driver", "postgres", "driver of the target database: postgres, mysql or sqlite")
+		dsn := flags.String("dsn", "", "DSN of the target database")
+		flags.Parse(args)
+		if *dsn == "" {
+			logging.Fatal("The target database is required, pass -dsn")
+		}
+		if *driver == source.Driver && *dsn == source.DSN {
+			logging.Fatal("The target database is the configured database")
+		}
+
+		if _, err := db.Migrate(conn); err != nil {
+			logging.Fatal("Failed to migrate database", "error", err)
+		}
+		target, err := db.Open(db.Config{Driver: *driver, DSN: *dsn, LogLevel: source.LogLevel, SlowQueryThreshold: source.SlowQueryThreshold})
+		if err != nil {
+			logging.Fatal("Failed to open target database", "error", err)
+		}
+		results, err := db.CopyDatabase(conn, target)
+		if err != nil {
+			logging.Fatal("Failed to copy database", "error", err)
+		}
+		var rows int64
+		for _, result := range results {
+			fmt.Printf("%-28s %d\n", result.Table, result.Rows)
+			rows += result.Rows
+		}
+		slog.Info("Database copied, point DB_DRIVER and DB_DSN at the target to use it", "driver", *driver, "tables", len(results), "rows", rows)
+		return
+	case "":
+		applied, err := db.Migrate(conn)
+		if err != nil {
+			logging.Fatal("Failed to migrate database", "applied", applied, "error", err)
+		}
+		slog.Info("Database migrated", "applied", len(applied))
+	case "status":
+	default:
+		logging.Fatal("Unknown migrate command, expected status or copy", "command", command)
+	}
+
+	statuses, err := db.Migrations(conn)
+	if err != nil {
+		logging.Fatal("Failed to read migrations", "error", err)
+	}
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%-36s %-25s %s\n", status.ID, applied, status.Description)
+	}
+}
+
+// statePassphraseEnv holds the passphrase state archives are encrypted with
+const statePassphraseEnv = "KUBEFORGE_STATE_PASSPHRASE"
+
+// runState runs kubeforge-server export -out FILE, which writes the state
+// of the server to an archive, or import -in FILE, which restores one into
+// an empty database
+func runState(conn *gorm.DB, command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	out := flags.String("out", "kubeforge-state.tar.gz", "archive to write")
+	in := flags.String("in", "", "archive to import")
+	flags.Parse(args)
+
+	passphrase := os.Getenv(statePassphraseEnv)
+	if passphrase == "" {
+		logging.Fatal("The state passphrase is required", "env", statePassphraseEnv)
+	}
+
+	switch command {
+	case "export":
+		file, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			logging.Fatal("Failed to create archive", "error", err)
+		}
+		manifest, err := db.ExportState(conn, file, passphrase)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(*out)
+			logging.Fatal("Failed to export state", "error", err)
+		}
+		slog.Info("State exported", "file", *out, "migration", manifest.Migration, "counts", manifest.Counts)
+	case "import":
+		if *in == "" {
+			logging.Fatal("The archive to import is required, pass -in")
+		}
+		file, err := os.Open(*in)
+		if err != nil {
+			logging.Fatal("Failed to open archive", "error", err)
+		}
+		defer file.Close()
+		manifest, err := db.ImportState(conn, file, passphrase)
+		if err != nil {
+			logging.Fatal("Failed to import state", "error", err)
+		}
+		slog.Info("State imported", "file", *in, "exported_at", manifest.ExportedAt, "driver", manifest.Driver, "counts", manifest.Counts)
+	}
 }
@@ -0,0 +1,559 @@
+// Command kubeforgectl manages the clusters of a KubeForge server from the
+// command line. It talks to the server only through the pkg/client SDK.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"kubeforge/pkg/client"
+)
+
+const usage = `Usage: kubeforgectl [flags] <command> [arguments]
+
+Commands:
+  me                      show the authenticated user
+  clusters                list clusters (-label key=value, -q name, -all)
+  cluster ID              show a cluster
+  create -f FILE          create a cluster from a JSON or YAML spec (-wait)
+  delete ID               delete a cluster
+  nodes ID                list the nodes of a cluster (-role)
+  kubeconfig ID           print the kubeconfig of a cluster (-role view|edit|admin)
+  jobs                    list jobs (-cluster, -status, -type, -limit)
+  job ID                  show a job
+  cancel ID               cancel a job
+  wait ID                 wait for a job to finish
+  events ID               show the events of a cluster, 0 for all clusters (-follow, -level)
+
+The server and credentials are read from KUBEFORGE_URL, and KUBEFORGE_TOKEN
+or KUBEFORGE_USERNAME and KUBEFORGE_PASSWORD.
+
+Flags:
+`
+
+// cli runs a command
+type cli struct {
+	client *client.Client
+	json   bool
+	out    io.Writer
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	server := flag.String("server", envOr("KUBEFORGE_URL", "http://localhost:8080"), "URL of the KubeForge server")
+	token := flag.String("token", os.Getenv("KUBEFORGE_TOKEN"), "access token, instead of a username and password")
+	jsonOutput := flag.Bool("json", false, "print JSON instead of tables")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := []client.Option{client.WithUserAgent("kubeforgectl")}
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	} else if username := os.Getenv("KUBEFORGE_USERNAME"); username != "" {
+		opts = append(opts, client.WithCredentials(username, os.Getenv("KUBEFORGE_PASSWORD")))
+	}
+	c, err := client.New(*server, opts...)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	app := &cli{client: c, json: *jsonOutput, out: os.Stdout}
+	if err := app.run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		stop()
+		fatal(err)
+	}
+}
+
+// run runs a command with its arguments
+func (a *cli) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "me":
+		return a.me(ctx)
+	case "clusters":
+		return a.clusters(ctx, args)
+	case "cluster":
+		return a.cluster(ctx, args)
+	case "create":
+		return a.create(ctx, args)
+	case "delete":
+		return a.delete(ctx, args)
+	case "nodes":
+		return a.nodes(ctx, args)
+	case "kubeconfig":
+		return a.kubeconfig(ctx, args)
+	case "jobs":
+		return a.jobs(ctx, args)
+	case "job":
+		return a.job(ctx, args)
+	case "cancel":
+		return a.cancel(ctx, args)
+	case "wait":
+		return a.wait(ctx, args)
+	case "events":
+		return a.events(ctx, args)
+	}
+	return fmt.Errorf("unknown command %q, run kubeforgectl -h for the commands", command)
+}
+
+func (a *cli) me(ctx context.Context) error {
+	user, err := a.client.Me(ctx)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(user)
+	}
+	fmt.Fprintf(a.out, "%s (%s)\n", user.Username, user.Role)
+	return nil
+}
+
+func (a *cli) clusters(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("clusters", flag.ExitOnError)
+	var labels labelFlags
+	fs.Var(&labels, "label", "key=value label the clusters must have, repeatable")
+	query := fs.String("q", "", "part of the name")
+	all := fs.Bool("all", false, "include deleted clusters")
+	fs.Parse(args)
+
+	clusters, err := a.client.ListClusters(ctx, client.ListClustersOptions{Labels: labels, Query: *query, IncludeDeleted: *all})
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(clusters)
+	}
+	return a.table([]string{"ID", "NAME", "VERSION", "STATUS", "NODES", "CREATED"}, len(clusters), func(i int) []interface{} {
+		cl := clusters[i]
+		return []interface{}{cl.ID, cl.Name, cl.K8sVersion, cl.Status, len(cl.Nodes), age(cl.CreatedAt)}
+	})
+}
+
+func (a *cli) cluster(ctx context.Context, args []string) error {
+	id, err := idArg("cluster", args)
+	if err != nil {
+		return err
+	}
+	cl, err := a.client.GetCluster(ctx, id)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(cl)
+	}
+	fmt.Fprintf(a.out, "Name:       %s\nID:         %d\nStatus:     %s\n", cl.Name, cl.ID, cl.Status)
+	if cl.StatusMessage != "" {
+		fmt.Fprintf(a.out, "Message:    %s\n", cl.StatusMessage)
+	}
+	fmt.Fprintf(a.out, "Version:    %s\nCNI:        %s\nRuntime:    %s\nEndpoint:   %s\nCreated:    %s\n\n",
+		cl.K8sVersion, cl.CNI, cl.ContainerRuntime, cl.APIServerEndpoint, cl.CreatedAt.Format(time.RFC3339))
+	return a.printNodes(cl.Nodes)
+}
+
+func (a *cli) create(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	file := fs.String("f", "", "JSON or YAML cluster spec, - for stdin")
+	key := fs.String("idempotency-key", "", "key making a retried creation return the cluster created first")
+	wait := fs.Bool("wait", false, "follow the provisioning job until it finishes")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("create needs a spec file, -f FILE")
+	}
+
+	spec, err := readSpec(*file)
+	if err != nil {
+		return err
+	}
+	created, err := a.client.CreateCluster(ctx, spec, *key)
+	if err != nil {
+		return err
+	}
+	for _, warning := range created.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", warning.Field, warning.Message)
+	}
+	if a.json && !*wait {
+		return a.printJSON(created)
+	}
+	fmt.Fprintf(a.out, "Cluster %s (%d) is being provisioned by job %d\n", created.Name, created.ID, created.JobID)
+	if !*wait {
+		return nil
+	}
+	return a.follow(ctx, created.ID, created.JobID)
+}
+
+func (a *cli) delete(ctx context.Context, args []string) error {
+	id, err := idArg("cluster", args)
+	if err != nil {
+		return err
+	}
+	if err := a.client.DeleteCluster(ctx, id); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Cluster %d is being deleted\n", id)
+	return nil
+}
+
+func (a *cli) nodes(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("nodes", flag.ExitOnError)
+	role := fs.String("role", "", "control-plane or worker")
+	fs.Parse(args)
+	id, err := idArg("cluster", fs.Args())
+	if err != nil {
+		return err
+	}
+	nodes, err := a.client.ListNodes(ctx, id, *role)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(nodes)
+	}
+	return a.printNodes(nodes)
+}
+
+func (a *cli) kubeconfig(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kubeconfig", flag.ExitOnError)
+	role := fs.String("role", "", "short-lived credential with the view, edit or admin role")
+	fs.Parse(args)
+	id, err := idArg("cluster", fs.Args())
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := a.client.Kubeconfig(ctx, id, *role)
+	if err != nil {
+		return err
+	}
+	_, err = a.out.Write(kubeconfig)
+	return err
+}
+
+func (a *cli) jobs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+	clusterID := fs.Uint("cluster", 0, "jobs of a cluster")
+	status := fs.String("status", "", "pending, running, completed, failed or cancelled")
+	typ := fs.String("type", "", "provision, upgrade, destroy and others")
+	limit := fs.Int("limit", 0, "at most this many jobs, 100 by default")
+	fs.Parse(args)
+
+	jobs, err := a.client.ListJobs(ctx, client.ListJobsOptions{ClusterID: uint(*clusterID), Status: *status, Type: *typ, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(jobs)
+	}
+	return a.table([]string{"ID", "CLUSTER", "TYPE", "STATUS", "PROGRESS", "PHASE", "CREATED"}, len(jobs), func(i int) []interface{} {
+		job := jobs[i]
+		return []interface{}{job.ID, job.ClusterID, job.Type, job.Status, strconv.Itoa(job.Progress) + "%", job.Phase, age(job.CreatedAt)}
+	})
+}
+
+func (a *cli) job(ctx context.Context, args []string) error {
+	id, err := idArg("job", args)
+	if err != nil {
+		return err
+	}
+	job, err := a.client.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.printJob(job)
+}
+
+func (a *cli) cancel(ctx context.Context, args []string) error {
+	id, err := idArg("job", args)
+	if err != nil {
+		return err
+	}
+	if err := a.client.CancelJob(ctx, id); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Job %d is being cancelled\n", id)
+	return nil
+}
+
+func (a *cli) wait(ctx context.Context, args []string) error {
+	id, err := idArg("job", args)
+	if err != nil {
+		return err
+	}
+	job, err := a.client.WaitJob(ctx, id, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := a.printJob(job); err != nil {
+		return err
+	}
+	return jobError(job)
+}
+
+func (a *cli) events(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "stream new events until interrupted")
+	level := fs.String("level", "", "this level or more severe: debug, info, warn or error")
+	jobID := fs.Uint("job", 0, "events of a job")
+	fs.Parse(args)
+	id, err := idArg("cluster", fs.Args())
+	if err != nil {
+		return err
+	}
+	filter := client.EventFilter{Level: *level, JobID: uint(*jobID)}
+
+	if !*follow {
+		if id == 0 {
+			return errors.New("events of all clusters can only be followed, add -follow")
+		}
+		events, err := a.client.ListEvents(ctx, id, filter)
+		if err != nil {
+			return err
+		}
+		// Oldest first, like a log
+		for i := len(events) - 1; i >= 0; i-- {
+			a.printEvent(&events[i])
+		}
+		return nil
+	}
+
+	stream, err := a.client.StreamEvents(ctx, id, filter)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		if msg.Event != nil {
+			a.printEvent(msg.Event)
+		}
+	}
+}
+
+// follow prints the events of a cluster until a job finishes
+func (a *cli) follow(ctx context.Context, clusterID, jobID uint) error {
+	stream, err := a.client.StreamEvents(ctx, clusterID, client.EventFilter{JobID: jobID})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// The stream carries no message once the job finished, so its status is
+	// polled alongside
+	done := make(chan *client.Job, 1)
+	errs := make(chan error, 1)
+	go func() {
+		job, err := a.client.WaitJob(ctx, jobID, 5*time.Second)
+		if err != nil {
+			errs <- err
+			return
+		}
+		done <- job
+		stream.Close()
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		if msg.Event != nil {
+			a.printEvent(msg.Event)
+		} else if msg.Progress != nil && msg.Progress.JobID == jobID && !a.json {
+			fmt.Fprintf(os.Stderr, "[%3d%%] %s\n", msg.Progress.Progress, msg.Progress.Phase)
+		}
+	}
+
+	select {
+	case job := <-done:
+		if err := a.printJob(job); err != nil {
+			return err
+		}
+		return jobError(job)
+	case err := <-errs:
+		return err
+	}
+}
+
+// printNodes prints a table of nodes
+func (a *cli) printNodes(nodes []client.Node) error {
+	return a.table([]string{"ID", "HOSTNAME", "ADDRESS", "ROLE", "STATUS", "VERSION"}, len(nodes), func(i int) []interface{} {
+		node := nodes[i]
+		status := node.Status
+		if node.Cordoned {
+			status += ",cordoned"
+		}
+		return []interface{}{node.ID, node.Hostname, node.Address, node.Role, status, node.K8sVersion}
+	})
+}
+
+// printJob prints a job
+func (a *cli) printJob(job *client.Job) error {
+	if a.json {
+		return a.printJSON(job)
+	}
+	fmt.Fprintf(a.out, "Job %d (%s) of cluster %d: %s, %d%%", job.ID, job.Type, job.ClusterID, job.Status, job.Progress)
+	if job.Phase != "" {
+		fmt.Fprintf(a.out, ", %s", job.Phase)
+	}
+	fmt.Fprintln(a.out)
+	if job.Error != "" {
+		fmt.Fprintf(a.out, "Error: %s\n", job.Error)
+	}
+	return nil
+}
+
+// printEvent prints an event as a log line
+func (a *cli) printEvent(event *client.Event) {
+	if a.json {
+		a.printJSON(event)
+		return
+	}
+	line := fmt.Sprintf("%s %-5s", event.Timestamp.Local().Format("15:04:05"), strings.ToUpper(event.Level))
+	if event.Host != "" {
+		line += " [" + event.Host + "]"
+	}
+	if event.Step != "" {
+		line += " " + event.Step + ":"
+	}
+	fmt.Fprintln(a.out, line, event.Message)
+}
+
+// printJSON prints a value as indented JSON
+func (a *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(a.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table prints rows under a header, aligned in columns
+func (a *cli) table(header []string, rows int, row func(int) []interface{}) error {
+	w := tabwriter.NewWriter(a.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for i := 0; i < rows; i++ {
+		cells := row(i)
+		values := make([]string, len(cells))
+		for j, cell := range cells {
+			values[j] = fmt.Sprint(cell)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	return w.Flush()
+}
+
+// readSpec reads a cluster spec from a JSON or YAML file
+func readSpec(path string) (client.ClusterSpec, error) {
+	var spec client.ClusterSpec
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return spec, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		// YAML is a superset of JSON, so stdin and other files are read as
+		// YAML and sent as JSON
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return spec, fmt.Errorf("invalid spec %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return spec, fmt.Errorf("invalid spec %s: %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// jobError returns an error for a job that did not complete
+func jobError(job *client.Job) error {
+	switch job.Status {
+	case client.JobFailed:
+		return fmt.Errorf("job %d failed", job.ID)
+	case client.JobCancelled:
+		return fmt.Errorf("job %d was cancelled", job.ID)
+	}
+	return nil
+}
+
+// idArg reads the ID argument of a command
+func idArg(kind string, args []string) (uint, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected a %s ID", kind)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s ID %q", kind, args[0])
+	}
+	return uint(id), nil
+}
+
+// age returns how long ago a time was, e.g. 3d or 5m
+func age(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d >= 48*time.Hour:
+		return strconv.Itoa(int(d.Hours()/24)) + "d"
+	case d >= time.Hour:
+		return strconv.Itoa(int(d.Hours())) + "h"
+	case d >= time.Minute:
+		return strconv.Itoa(int(d.Minutes())) + "m"
+	}
+	return strconv.Itoa(int(d.Seconds())) + "s"
+}
+
+// envOr returns an environment variable, or def when it is not set
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// fatal prints an error and exits
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+// labelFlags collects repeated -label flags
+type labelFlags []string
+
+func (l *labelFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labelFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
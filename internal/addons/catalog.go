@@ -0,0 +1,70 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+)
+
+// manifestAddon installs an addon from a versioned upstream manifest
+type manifestAddon struct {
+	name           string
+	description    string
+	defaultVersion string
+	namespace      string
+	manifestURL    string // format string, receives the version
+}
+
+func (a *manifestAddon) Name() string {
+	return a.name
+}
+
+func (a *manifestAddon) Description() string {
+	return a.description
+}
+
+func (a *manifestAddon) DefaultVersion() string {
+	return a.defaultVersion
+}
+
+// URL returns the manifest URL for the given version
+func (a *manifestAddon) URL(version string) string {
+	return fmt.Sprintf(a.manifestURL, version)
+}
+
+// Install applies the upstream manifest and waits for its deployments
+func (a *manifestAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	kubectl.Emit("info", "addon", fmt.Sprintf("Applying %s %s manifest", a.name, opts.Version))
+	if err := kubectl.ApplyURL(ctx, a.URL(opts.Version)); err != nil {
+		return err
+	}
+
+	if a.namespace != "" {
+		if err := kubectl.WaitForDeployments(ctx, a.namespace); err != nil {
+			kubectl.Emit("warn", "addon", fmt.Sprintf("%s deployments may not be fully ready yet", a.name))
+		}
+	}
+	return nil
+}
+
+// Uninstall deletes the resources of the upstream manifest
+func (a *manifestAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	kubectl.Emit("info", "addon", fmt.Sprintf("Deleting %s %s manifest", a.name, opts.Version))
+	return kubectl.DeleteURL(ctx, a.URL(opts.Version))
+}
+
+func init() {
+	RegisterAddon(&manifestAddon{
+		name:           "metrics-server",
+		description:    "Cluster-wide resource metrics for kubectl top and HPA",
+		defaultVersion: "v0.6.4",
+		namespace:      "kube-system",
+		manifestURL:    "https://github.com/kubernetes-sigs/metrics-server/releases/download/%s/components.yaml",
+	})
+	RegisterAddon(&manifestAddon{
+		name:           "kubernetes-dashboard",
+		description:    "General purpose web UI for Kubernetes clusters",
+		defaultVersion: "v2.7.0",
+		namespace:      "kubernetes-dashboard",
+		manifestURL:    "https://raw.githubusercontent.com/kubernetes/dashboard/%s/aio/deploy/recommended.yaml",
+	})
+}
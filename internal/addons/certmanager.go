@@ -0,0 +1,263 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+
+	"kubeforge/internal/provision"
+)
+
+// ACME directory URLs
+const (
+	LetsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// CertManagerConfig configures cert-manager and its optional ClusterIssuer
+type CertManagerConfig struct {
+	Issuer      *IssuerConfig     `json:"issuer,omitempty"`
+	Credentials *DNS01Credentials `json:"credentials,omitempty"` // DNS-01 provider secrets
+}
+
+// IssuerConfig describes an ACME ClusterIssuer
+type IssuerConfig struct {
+	Name         string       `json:"name,omitempty"`          // default: "letsencrypt"
+	Email        string       `json:"email"`                   // ACME account email
+	Staging      bool         `json:"staging,omitempty"`       // use the Let's Encrypt staging directory
+	Server       string       `json:"server,omitempty"`        // custom ACME directory URL
+	Solver       string       `json:"solver,omitempty"`        // http01 or dns01, default: http01
+	IngressClass string       `json:"ingress_class,omitempty"` // HTTP-01 ingress class, default: nginx
+	DNS01        *DNS01Config `json:"dns01,omitempty"`
+}
+
+// DNS01Config contains the DNS provider settings for DNS-01 challenges
+type DNS01Config struct {
+	Provider    string `json:"provider"` // cloudflare, route53, digitalocean
+	Region      string `json:"region,omitempty"`
+	AccessKeyID string `json:"access_key_id,omitempty"`
+}
+
+// DNS01Credentials authenticate the DNS-01 solver against the DNS provider
+type DNS01Credentials struct {
+	APIToken        string `json:"api_token,omitempty"`         // cloudflare, digitalocean
+	SecretAccessKey string `json:"secret_access_key,omitempty"` // route53
+}
+
+// Route53 settings rendered into the ClusterIssuer
+var (
+	awsRegionPattern    = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	awsAccessKeyPattern = regexp.MustCompile(`^[A-Z0-9]{16,128}$`)
+)
+
+// certManagerAddon installs cert-manager and bootstraps an ACME ClusterIssuer
+type certManagerAddon struct {
+	*manifestAddon
+}
+
+func init() {
+	RegisterAddon(&certManagerAddon{&manifestAddon{
+		name:           "cert-manager",
+		description:    "X.509 certificate management with optional Let's Encrypt ClusterIssuer",
+		defaultVersion: "v1.13.2",
+		namespace:      "cert-manager",
+		manifestURL:    "https://github.com/cert-manager/cert-manager/releases/download/%s/cert-manager.yaml",
+	}})
+}
+
+// ValidateConfig checks the issuer configuration
+func (a *certManagerAddon) ValidateConfig(opts InstallOptions) error {
+	var config CertManagerConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	if config.Issuer == nil {
+		return nil
+	}
+	return config.Issuer.validate(config.Credentials)
+}
+
+// Install applies the cert-manager manifest and creates the ClusterIssuer
+func (a *certManagerAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config CertManagerConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	var manifest string
+	if config.Issuer != nil {
+		if err := config.Issuer.validate(config.Credentials); err != nil {
+			return err
+		}
+		var err error
+		if manifest, err = config.Issuer.manifest(config.Credentials); err != nil {
+			return err
+		}
+	}
+
+	if err := a.manifestAddon.Install(ctx, kubectl, opts); err != nil {
+		return err
+	}
+	if config.Issuer == nil {
+		return nil
+	}
+
+	// The ClusterIssuer is rejected until the cert-manager webhook is serving
+	if _, err := kubectl.Run(ctx, "wait --for=condition=Available deployment/cert-manager-webhook -n cert-manager --timeout=300s"); err != nil {
+		return fmt.Errorf("cert-manager webhook is not ready: %w", err)
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Creating ClusterIssuer %s", config.Issuer.name()))
+	if err := kubectl.Apply(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to create ClusterIssuer: %w", err)
+	}
+	return nil
+}
+
+// Uninstall removes the ClusterIssuer and cert-manager itself
+func (a *certManagerAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config CertManagerConfig
+	if err := opts.DecodeConfig(&config); err == nil && config.Issuer != nil {
+		name := config.Issuer.name()
+		if err := validateName("issuer name", name); err != nil {
+			kubectl.Emit("warn", "addon", fmt.Sprintf("Skipping ClusterIssuer deletion: %v", err))
+		} else if _, err := kubectl.Run(ctx, "delete clusterissuer --ignore-not-found "+provision.ShellQuote(name)); err != nil {
+			kubectl.Emit("warn", "addon", fmt.Sprintf("Failed to delete ClusterIssuer: %v", err))
+		}
+	}
+	return a.manifestAddon.Uninstall(ctx, kubectl, opts)
+}
+
+// validate checks the issuer settings and the DNS-01 credentials it needs
+func (c *IssuerConfig) validate(credentials *DNS01Credentials) error {
+	if err := validateName("issuer name", c.name()); err != nil {
+		return err
+	}
+	if c.Email == "" {
+		return fmt.Errorf("cert-manager issuer requires an email")
+	}
+	if address, err := mail.ParseAddress(c.Email); err != nil || address.Address != c.Email {
+		return fmt.Errorf("invalid issuer email %q", c.Email)
+	}
+	if c.Server != "" {
+		if u, err := url.Parse(c.Server); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid issuer server %q: expected an https URL", c.Server)
+		}
+	}
+
+	switch c.Solver {
+	case "", "http01":
+		if c.IngressClass != "" {
+			return validateName("ingress_class", c.IngressClass)
+		}
+		return nil
+	case "dns01":
+		if c.DNS01 == nil {
+			return fmt.Errorf("dns01 solver requires dns01 provider settings")
+		}
+		if credentials == nil {
+			credentials = &DNS01Credentials{}
+		}
+		switch c.DNS01.Provider {
+		case "cloudflare", "digitalocean":
+			if credentials.APIToken == "" {
+				return fmt.Errorf("%s dns01 provider requires credentials.api_token", c.DNS01.Provider)
+			}
+		case "route53":
+			if c.DNS01.Region == "" || c.DNS01.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+				return fmt.Errorf("route53 dns01 provider requires dns01.region, dns01.access_key_id and credentials.secret_access_key")
+			}
+			if !awsRegionPattern.MatchString(c.DNS01.Region) {
+				return fmt.Errorf("invalid route53 region %q", c.DNS01.Region)
+			}
+			if !awsAccessKeyPattern.MatchString(c.DNS01.AccessKeyID) {
+				return fmt.Errorf("invalid route53 access_key_id: expected 16 to 128 upper case letters or digits")
+			}
+		default:
+			return fmt.Errorf("unsupported dns01 provider %q", c.DNS01.Provider)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid solver %q: expected http01 or dns01", c.Solver)
+	}
+}
+
+func (c *IssuerConfig) name() string {
+	if c.Name == "" {
+		return "letsencrypt"
+	}
+	return c.Name
+}
+
+func (c *IssuerConfig) server() string {
+	switch {
+	case c.Server != "":
+		return c.Server
+	case c.Staging:
+		return LetsEncryptStaging
+	default:
+		return LetsEncryptProduction
+	}
+}
+
+// manifest renders the credentials Secret (for DNS-01) and the ClusterIssuer
+func (c *IssuerConfig) manifest(credentials *DNS01Credentials) (string, error) {
+	var solver map[string]interface{}
+	var objects []interface{}
+
+	if c.Solver == "dns01" {
+		secretName := c.name() + "-dns01-credentials"
+		secretData := map[string]string{}
+		switch c.DNS01.Provider {
+		case "cloudflare":
+			secretData["api-token"] = credentials.APIToken
+			solver = map[string]interface{}{"cloudflare": map[string]interface{}{
+				"apiTokenSecretRef": map[string]string{"name": secretName, "key": "api-token"},
+			}}
+		case "digitalocean":
+			secretData["api-token"] = credentials.APIToken
+			solver = map[string]interface{}{"digitalocean": map[string]interface{}{
+				"tokenSecretRef": map[string]string{"name": secretName, "key": "api-token"},
+			}}
+		case "route53":
+			secretData["secret-access-key"] = credentials.SecretAccessKey
+			solver = map[string]interface{}{"route53": map[string]interface{}{
+				"region":                   c.DNS01.Region,
+				"accessKeyID":              c.DNS01.AccessKeyID,
+				"secretAccessKeySecretRef": map[string]string{"name": secretName, "key": "secret-access-key"},
+			}}
+		}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]string{"name": secretName, "namespace": "cert-manager"},
+			"type":       "Opaque",
+			"stringData": secretData,
+		})
+		solver = map[string]interface{}{"dns01": solver}
+	} else {
+		ingressClass := c.IngressClass
+		if ingressClass == "" {
+			ingressClass = "nginx"
+		}
+		solver = map[string]interface{}{"http01": map[string]interface{}{
+			"ingress": map[string]string{"class": ingressClass},
+		}}
+	}
+
+	objects = append(objects, map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "ClusterIssuer",
+		"metadata":   map[string]string{"name": c.name()},
+		"spec": map[string]interface{}{
+			"acme": map[string]interface{}{
+				"email":               c.Email,
+				"server":              c.server(),
+				"privateKeySecretRef": map[string]string{"name": c.name() + "-account-key"},
+				"solvers":             []interface{}{solver},
+			},
+		},
+	})
+	return renderManifest(objects...)
+}
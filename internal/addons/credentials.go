@@ -0,0 +1,51 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// credentialsKey is the top-level config key holding addon secrets. Its value
+// is stored encrypted and never returned by the API.
+const credentialsKey = "credentials"
+
+// SplitCredentials separates the credentials object from an addon config
+func SplitCredentials(config json.RawMessage) (public, credentials json.RawMessage, err error) {
+	if len(config) == 0 || string(config) == "null" {
+		return config, nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return nil, nil, fmt.Errorf("invalid addon config: %w", err)
+	}
+
+	credentials, ok := fields[credentialsKey]
+	if !ok {
+		return config, nil, nil
+	}
+	delete(fields, credentialsKey)
+
+	public, err = json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return public, credentials, nil
+}
+
+// MergeCredentials re-attaches credentials to a config stripped by SplitCredentials
+func MergeCredentials(config, credentials json.RawMessage) (json.RawMessage, error) {
+	if len(credentials) == 0 {
+		return config, nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if len(config) > 0 && string(config) != "null" {
+		if err := json.Unmarshal(config, &fields); err != nil {
+			return nil, fmt.Errorf("invalid addon config: %w", err)
+		}
+	}
+	fields[credentialsKey] = credentials
+
+	return json.Marshal(fields)
+}
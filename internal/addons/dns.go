@@ -0,0 +1,438 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// CoreDNSConfig tunes the CoreDNS deployed by kubeadm
+type CoreDNSConfig struct {
+	Forwarders  []string            `json:"forwarders,omitempty"`   // upstream servers, default: the resolv.conf of the nodes
+	StubDomains map[string][]string `json:"stub_domains,omitempty"` // servers of domains resolved elsewhere, by domain
+	Replicas    int                 `json:"replicas,omitempty"`     // default: 2, as deployed by kubeadm
+	CacheTTL    int                 `json:"cache_ttl,omitempty"`    // seconds answers are cached, default: 30
+}
+
+// NodeLocalDNSConfig configures NodeLocal DNSCache
+type NodeLocalDNSConfig struct {
+	LocalIP string `json:"local_ip,omitempty"` // link-local address of the cache, default: 169.254.20.10
+}
+
+// kubeadmCoreDNSReplicas is the number of CoreDNS replicas kubeadm deploys
+const kubeadmCoreDNSReplicas = 2
+
+// clusterDomainPattern finds the cluster domain in a CoreDNS Corefile
+var clusterDomainPattern = regexp.MustCompile(`kubernetes\s+(\S+)\s`)
+
+// ipvsModePattern finds IPVS mode in the configuration of kube-proxy
+var ipvsModePattern = regexp.MustCompile(`(?m)^mode:\s*"?ipvs"?\s*$`)
+
+// corednsAddon configures the CoreDNS of the cluster instead of installing
+// one
+type corednsAddon struct{}
+
+// nodeLocalDNSAddon runs a DNS cache on every node, answering pods on a
+// link-local address and forwarding misses to CoreDNS
+type nodeLocalDNSAddon struct {
+	defaultVersion string
+}
+
+func init() {
+	RegisterAddon(&corednsAddon{})
+	RegisterAddon(&nodeLocalDNSAddon{defaultVersion: "1.26.4"})
+}
+
+func (a *corednsAddon) Name() string {
+	return "coredns"
+}
+
+func (a *corednsAddon) Description() string {
+	return "Upstream forwarders, stub domains and replicas of the CoreDNS of the cluster"
+}
+
+// DefaultVersion is the version of CoreDNS kubeadm deploys, which upgrades
+// it with the cluster
+func (a *corednsAddon) DefaultVersion() string {
+	return "kubeadm"
+}
+
+// ValidateConfig checks the forwarders and stub domains
+func (a *corednsAddon) ValidateConfig(opts InstallOptions) error {
+	var config CoreDNSConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	for _, server := range config.Forwarders {
+		if err := validateDNSServer(server); err != nil {
+			return fmt.Errorf("invalid forwarder: %w", err)
+		}
+	}
+	for domain, servers := range config.StubDomains {
+		if !validation.Host(domain) || net.ParseIP(domain) != nil {
+			return fmt.Errorf("invalid stub domain %q", domain)
+		}
+		if len(servers) == 0 {
+			return fmt.Errorf("stub domain %s needs a server", domain)
+		}
+		for _, server := range servers {
+			if err := validateDNSServer(server); err != nil {
+				return fmt.Errorf("invalid server of stub domain %s: %w", domain, err)
+			}
+		}
+	}
+	if config.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	if config.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative")
+	}
+	return nil
+}
+
+// Install replaces the Corefile of CoreDNS, which reloads it, and scales
+// its deployment
+func (a *corednsAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config CoreDNSConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	return a.configure(ctx, kubectl, config)
+}
+
+// Uninstall restores the Corefile and replicas kubeadm deploys
+func (a *corednsAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	return a.configure(ctx, kubectl, CoreDNSConfig{})
+}
+
+func (a *corednsAddon) configure(ctx context.Context, kubectl *Kubectl, config CoreDNSConfig) error {
+	domain, err := clusterDomain(ctx, kubectl)
+	if err != nil {
+		return err
+	}
+
+	kubectl.Emit("info", "addon", "Updating the CoreDNS Corefile")
+	if err := kubectl.Apply(ctx, corednsConfigMap(corefile(domain, config))); err != nil {
+		return fmt.Errorf("failed to update Corefile: %w", err)
+	}
+
+	replicas := config.Replicas
+	if replicas == 0 {
+		replicas = kubeadmCoreDNSReplicas
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("Scaling CoreDNS to %d replicas", replicas))
+	if _, err := kubectl.Run(ctx, fmt.Sprintf("-n kube-system scale deployment coredns --replicas=%d", replicas)); err != nil {
+		return err
+	}
+	if _, err := kubectl.Run(ctx, "-n kube-system rollout status deployment coredns --timeout=300s"); err != nil {
+		kubectl.Emit("warn", "addon", "CoreDNS may not be fully ready yet")
+	}
+	return nil
+}
+
+// corefile renders the Corefile kubeadm deploys with the forwarders, cache
+// and stub domains of a config
+func corefile(domain string, config CoreDNSConfig) string {
+	upstream := "/etc/resolv.conf"
+	if len(config.Forwarders) > 0 {
+		upstream = strings.Join(config.Forwarders, " ")
+	}
+	ttl := config.CacheTTL
+	if ttl == 0 {
+		ttl = 30
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `.:53 {
+    errors
+    health {
+       lameduck 5s
+    }
+    ready
+    kubernetes %s in-addr.arpa ip6.arpa {
+       pods insecure
+       fallthrough in-addr.arpa ip6.arpa
+       ttl 30
+    }
+    prometheus :9153
+    forward . %s {
+       max_concurrent 1000
+    }
+    cache %d
+    loop
+    reload
+    loadbalance
+}
+`, domain, upstream, ttl)
+
+	domains := make([]string, 0, len(config.StubDomains))
+	for stub := range config.StubDomains {
+		domains = append(domains, stub)
+	}
+	sort.Strings(domains)
+	for _, stub := range domains {
+		fmt.Fprintf(&b, `%s:53 {
+    errors
+    cache %d
+    forward . %s
+}
+`, stub, ttl, strings.Join(config.StubDomains[stub], " "))
+	}
+	return b.String()
+}
+
+// corednsConfigMap renders the ConfigMap CoreDNS reads its Corefile from
+func corednsConfigMap(corefile string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: coredns
+  namespace: kube-system
+data:
+  Corefile: |
+%s`, indent(corefile, "    "))
+}
+
+func (a *nodeLocalDNSAddon) Name() string {
+	return provision.NodeLocalDNSAddon
+}
+
+func (a *nodeLocalDNSAddon) Description() string {
+	return "NodeLocal DNSCache answering pods from a DNS cache on their node"
+}
+
+func (a *nodeLocalDNSAddon) DefaultVersion() string {
+	return a.defaultVersion
+}
+
+// ValidateConfig checks the address of the cache
+func (a *nodeLocalDNSAddon) ValidateConfig(opts InstallOptions) error {
+	var config NodeLocalDNSConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	if config.LocalIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(config.LocalIP)
+	if ip == nil || ip.To4() == nil || !ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("invalid local_ip %q: expected a link-local IPv4 address such as %s", config.LocalIP, provision.DefaultNodeLocalDNSIP)
+	}
+	return nil
+}
+
+// Install deploys the cache as a DaemonSet. With kube-proxy in iptables
+// mode it also answers on the address of the kube-dns service, so pods use
+// it without changing the kubelets. In IPVS mode the kubelets must point
+// pods at its link-local address, which the kubeadm configuration does for
+// clusters created with the addon.
+func (a *nodeLocalDNSAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	manifest, err := a.manifest(ctx, kubectl, opts)
+	if err != nil {
+		return err
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("Deploying NodeLocal DNSCache %s", opts.Version))
+	if err := kubectl.Apply(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to deploy NodeLocal DNSCache: %w", err)
+	}
+	if _, err := kubectl.Run(ctx, "-n kube-system rollout status daemonset node-local-dns --timeout=300s"); err != nil {
+		kubectl.Emit("warn", "addon", "NodeLocal DNSCache may not be running on every node yet")
+	}
+	return nil
+}
+
+// Uninstall deletes the cache. Kubelets pointed at its address lose DNS
+// until they are pointed back at CoreDNS.
+func (a *nodeLocalDNSAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	manifest, err := a.manifest(ctx, kubectl, opts)
+	if err != nil {
+		return err
+	}
+	kubectl.Emit("info", "addon", "Deleting NodeLocal DNSCache")
+	return kubectl.Delete(ctx, manifest)
+}
+
+// manifest renders the cache for the DNS settings of the cluster
+func (a *nodeLocalDNSAddon) manifest(ctx context.Context, kubectl *Kubectl, opts InstallOptions) (string, error) {
+	localIP := provision.NodeLocalDNSIP(opts.Config)
+	domain, err := clusterDomain(ctx, kubectl)
+	if err != nil {
+		return "", err
+	}
+	dnsIP, err := kubectl.Run(ctx, "-n kube-system get service kube-dns -o jsonpath={.spec.clusterIP}")
+	if err != nil {
+		return "", fmt.Errorf("failed to find the kube-dns service: %w", err)
+	}
+	mode, _ := kubectl.Run(ctx, `-n kube-system get configmap kube-proxy -o jsonpath={.data.config\.conf}`)
+
+	addresses := localIP + " " + strings.TrimSpace(dnsIP)
+	if ipvsModePattern.MatchString(mode) {
+		kubectl.Emit("info", "addon", fmt.Sprintf("kube-proxy runs in IPVS mode, kubelets must use %s as cluster DNS", localIP))
+		addresses = localIP
+	}
+	return nodeLocalDNSManifest(opts.Version, domain, addresses), nil
+}
+
+// nodeLocalDNSManifest renders the NodeLocal DNSCache manifest of the
+// Kubernetes DNS addon. Every query the cache cannot answer goes to
+// CoreDNS, so the forwarders and stub domains of CoreDNS apply to it.
+func nodeLocalDNSManifest(version, domain, addresses string) string {
+	listen := strings.ReplaceAll(addresses, " ", ",")
+	localIP, _, _ := strings.Cut(addresses, " ")
+	zone := func(name, cache, health string) string {
+		return fmt.Sprintf(`    %s:53 {
+        errors
+        %s
+        reload
+        loop
+        bind %s
+        forward . __PILLAR__CLUSTER__DNS__ {
+                force_tcp
+        }
+        prometheus :9253%s
+    }
+`, name, cache, addresses, health)
+	}
+	corefile := zone(domain, "cache {\n                success 9984 30\n                denial 9984 5\n        }", "\n        health "+localIP+":8080") +
+		zone("in-addr.arpa", "cache 30", "") + zone("ip6.arpa", "cache 30", "") + zone(".", "cache 30", "")
+
+	return fmt.Sprintf(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: node-local-dns
+  namespace: kube-system
+  labels:
+    app.kubernetes.io/managed-by: kubeforge
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: kube-dns-upstream
+  namespace: kube-system
+  labels:
+    k8s-app: kube-dns
+    app.kubernetes.io/managed-by: kubeforge
+spec:
+  ports:
+    - {name: dns, port: 53, protocol: UDP, targetPort: 53}
+    - {name: dns-tcp, port: 53, protocol: TCP, targetPort: 53}
+  selector:
+    k8s-app: kube-dns
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: node-local-dns
+  namespace: kube-system
+  labels:
+    app.kubernetes.io/managed-by: kubeforge
+data:
+  Corefile: |
+%[1]s---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: node-local-dns
+  namespace: kube-system
+  labels:
+    k8s-app: node-local-dns
+    app.kubernetes.io/managed-by: kubeforge
+spec:
+  updateStrategy:
+    rollingUpdate:
+      maxUnavailable: 10%%
+  selector:
+    matchLabels:
+      k8s-app: node-local-dns
+  template:
+    metadata:
+      labels:
+        k8s-app: node-local-dns
+      annotations:
+        prometheus.io/port: "9253"
+        prometheus.io/scrape: "true"
+    spec:
+      priorityClassName: system-node-critical
+      serviceAccountName: node-local-dns
+      hostNetwork: true
+      dnsPolicy: Default
+      tolerations:
+        - {key: CriticalAddonsOnly, operator: Exists}
+        - {effect: NoExecute, operator: Exists}
+        - {effect: NoSchedule, operator: Exists}
+      containers:
+        - name: node-cache
+          image: registry.k8s.io/dns/k8s-dns-node-cache:%[2]s
+          args: ["-localip", "%[3]s", "-conf", "/etc/Corefile", "-upstreamsvc", "kube-dns-upstream"]
+          resources:
+            requests: {cpu: 25m, memory: 5Mi}
+          securityContext:
+            capabilities:
+              add: [NET_ADMIN]
+          ports:
+            - {containerPort: 53, name: dns, protocol: UDP}
+            - {containerPort: 53, name: dns-tcp, protocol: TCP}
+            - {containerPort: 9253, name: metrics, protocol: TCP}
+          livenessProbe:
+            httpGet: {host: %[4]s, path: /health, port: 8080}
+            initialDelaySeconds: 60
+            timeoutSeconds: 5
+          volumeMounts:
+            - {name: xtables-lock, mountPath: /run/xtables.lock}
+            - {name: config-volume, mountPath: /etc/coredns}
+            - {name: kube-dns-config, mountPath: /etc/kube-dns}
+      volumes:
+        - name: xtables-lock
+          hostPath: {path: /run/xtables.lock, type: FileOrCreate}
+        - name: kube-dns-config
+          configMap: {name: kube-dns, optional: true}
+        - name: config-volume
+          configMap:
+            name: node-local-dns
+            items:
+              - {key: Corefile, path: Corefile.base}
+`, corefile, version, listen, localIP)
+}
+
+// clusterDomain returns the DNS domain of the cluster from the Corefile of
+// CoreDNS
+func clusterDomain(ctx context.Context, kubectl *Kubectl) (string, error) {
+	corefile, err := kubectl.Run(ctx, "-n kube-system get configmap coredns -o jsonpath={.data.Corefile}")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the CoreDNS Corefile: %w", err)
+	}
+	if match := clusterDomainPattern.FindStringSubmatch(corefile); match != nil {
+		return match[1], nil
+	}
+	return "cluster.local", nil
+}
+
+// validateDNSServer accepts an IP address optionally followed by a port
+func validateDNSServer(server string) error {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil || !validation.Endpoint(server) {
+		return fmt.Errorf("%q is not an IP address or IP:port", server)
+	}
+	return nil
+}
+
+// indent prefixes every line of text
+func indent(text, prefix string) string {
+	lines := strings.SplitAfter(text, "\n")
+	var b strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			b.WriteString(prefix + line)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,265 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GitOpsConfig points a GitOps controller at the repository it reconciles
+type GitOpsConfig struct {
+	RepoURL     string          `json:"repo_url"`
+	Path        string          `json:"path,omitempty"`   // default: "."
+	Branch      string          `json:"branch,omitempty"` // default: "main"
+	Credentials *GitCredentials `json:"credentials,omitempty"`
+}
+
+// GitCredentials authenticate against a private Git repository
+type GitCredentials struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"` // password or access token
+	SSHPrivateKey string `json:"ssh_private_key,omitempty"`
+	KnownHosts    string `json:"known_hosts,omitempty"` // required by Flux for SSH URLs
+}
+
+func (c GitOpsConfig) path() string {
+	if c.Path == "" {
+		return "."
+	}
+	return c.Path
+}
+
+func (c GitOpsConfig) branch() string {
+	if c.Branch == "" {
+		return "main"
+	}
+	return c.Branch
+}
+
+// validate checks the repository settings shared by all GitOps addons
+func (c GitOpsConfig) validate() error {
+	if c.RepoURL == "" {
+		return fmt.Errorf("gitops addon requires repo_url")
+	}
+	if creds := c.Credentials; creds != nil {
+		if creds.SSHPrivateKey == "" && (creds.Username == "" || creds.Password == "") {
+			return fmt.Errorf("git credentials require username and password, or ssh_private_key")
+		}
+	}
+	return nil
+}
+
+// argoCDAddon installs Argo CD and a bootstrap Application
+type argoCDAddon struct {
+	*manifestAddon
+}
+
+// fluxAddon installs Flux and a bootstrap GitRepository/Kustomization
+type fluxAddon struct {
+	*manifestAddon
+}
+
+func init() {
+	RegisterAddon(&argoCDAddon{&manifestAddon{
+		name:           "argocd",
+		description:    "Argo CD GitOps controller reconciling a bootstrap repository",
+		defaultVersion: "v2.9.3",
+		namespace:      "argocd",
+		manifestURL:    "https://raw.githubusercontent.com/argoproj/argo-cd/%s/manifests/install.yaml",
+	}})
+	RegisterAddon(&fluxAddon{&manifestAddon{
+		name:           "flux",
+		description:    "Flux GitOps toolkit reconciling a bootstrap repository",
+		defaultVersion: "v2.2.2",
+		namespace:      "flux-system",
+		manifestURL:    "https://github.com/fluxcd/flux2/releases/download/%s/install.yaml",
+	}})
+}
+
+// ValidateConfig checks the repository settings
+func (a *argoCDAddon) ValidateConfig(opts InstallOptions) error {
+	var config GitOpsConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	return config.validate()
+}
+
+// Install applies Argo CD into its namespace and creates the bootstrap Application
+func (a *argoCDAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config GitOpsConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+
+	// The upstream manifest has no namespace set, so it must be applied with -n
+	if _, err := kubectl.Run(ctx, "create namespace argocd --dry-run=client -o yaml | kubectl apply -f -"); err != nil {
+		return err
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("Applying argocd %s manifest", opts.Version))
+	if _, err := kubectl.Run(ctx, fmt.Sprintf("apply -n argocd -f %s", a.URL(opts.Version))); err != nil {
+		return err
+	}
+	if err := kubectl.WaitForDeployments(ctx, "argocd"); err != nil {
+		kubectl.Emit("warn", "addon", "argocd deployments may not be fully ready yet")
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Pointing Argo CD at %s (%s, %s)", config.RepoURL, config.branch(), config.path()))
+	if err := kubectl.Apply(ctx, a.manifest(config)); err != nil {
+		return fmt.Errorf("failed to create bootstrap application: %w", err)
+	}
+	return nil
+}
+
+// Uninstall removes the bootstrap Application and Argo CD
+func (a *argoCDAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	if _, err := kubectl.Run(ctx, "delete application kubeforge-bootstrap -n argocd --ignore-not-found"); err != nil {
+		kubectl.Emit("warn", "addon", fmt.Sprintf("Failed to delete bootstrap application: %v", err))
+	}
+	if _, err := kubectl.Run(ctx, fmt.Sprintf("delete -n argocd -f %s --ignore-not-found", a.URL(opts.Version))); err != nil {
+		return err
+	}
+	_, err := kubectl.Run(ctx, "delete namespace argocd --ignore-not-found")
+	return err
+}
+
+// manifest renders the repository Secret and the bootstrap Application
+func (a *argoCDAddon) manifest(config GitOpsConfig) string {
+	var b strings.Builder
+
+	if creds := config.Credentials; creds != nil {
+		fmt.Fprintf(&b, `apiVersion: v1
+kind: Secret
+metadata:
+  name: kubeforge-bootstrap-repo
+  namespace: argocd
+  labels:
+    argocd.argoproj.io/secret-type: repository
+type: Opaque
+stringData:
+  type: git
+  url: %q
+`, config.RepoURL)
+		if creds.SSHPrivateKey != "" {
+			fmt.Fprintf(&b, "  sshPrivateKey: %q\n", creds.SSHPrivateKey)
+		} else {
+			fmt.Fprintf(&b, "  username: %q\n  password: %q\n", creds.Username, creds.Password)
+		}
+		b.WriteString("---\n")
+	}
+
+	fmt.Fprintf(&b, `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: kubeforge-bootstrap
+  namespace: argocd
+spec:
+  project: default
+  source:
+    repoURL: %q
+    path: %q
+    targetRevision: %q
+  destination:
+    server: https://kubernetes.default.svc
+  syncPolicy:
+    automated:
+      prune: true
+      selfHeal: true
+`, config.RepoURL, config.path(), config.branch())
+	return b.String()
+}
+
+// ValidateConfig checks the repository settings
+func (a *fluxAddon) ValidateConfig(opts InstallOptions) error {
+	var config GitOpsConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if creds := config.Credentials; creds != nil && creds.SSHPrivateKey != "" && creds.KnownHosts == "" {
+		return fmt.Errorf("flux requires known_hosts when authenticating with ssh_private_key")
+	}
+	return nil
+}
+
+// Install applies the Flux controllers and creates the bootstrap sources
+func (a *fluxAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config GitOpsConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+
+	if err := a.manifestAddon.Install(ctx, kubectl, opts); err != nil {
+		return err
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Pointing Flux at %s (%s, %s)", config.RepoURL, config.branch(), config.path()))
+	if err := kubectl.Apply(ctx, a.manifest(config)); err != nil {
+		return fmt.Errorf("failed to create bootstrap sources: %w", err)
+	}
+	return nil
+}
+
+// Uninstall removes the bootstrap sources and Flux
+func (a *fluxAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	if _, err := kubectl.Run(ctx, "delete kustomization,gitrepository kubeforge-bootstrap -n flux-system --ignore-not-found"); err != nil {
+		kubectl.Emit("warn", "addon", fmt.Sprintf("Failed to delete bootstrap sources: %v", err))
+	}
+	return a.manifestAddon.Uninstall(ctx, kubectl, opts)
+}
+
+// manifest renders the auth Secret, GitRepository and Kustomization
+func (a *fluxAddon) manifest(config GitOpsConfig) string {
+	var b strings.Builder
+
+	creds := config.Credentials
+	if creds != nil {
+		b.WriteString(`apiVersion: v1
+kind: Secret
+metadata:
+  name: kubeforge-bootstrap-auth
+  namespace: flux-system
+type: Opaque
+stringData:
+`)
+		if creds.SSHPrivateKey != "" {
+			fmt.Fprintf(&b, "  identity: %q\n  known_hosts: %q\n", creds.SSHPrivateKey, creds.KnownHosts)
+		} else {
+			fmt.Fprintf(&b, "  username: %q\n  password: %q\n", creds.Username, creds.Password)
+		}
+		b.WriteString("---\n")
+	}
+
+	fmt.Fprintf(&b, `apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata:
+  name: kubeforge-bootstrap
+  namespace: flux-system
+spec:
+  interval: 1m
+  url: %q
+  ref:
+    branch: %q
+`, config.RepoURL, config.branch())
+	if creds != nil {
+		b.WriteString("  secretRef:\n    name: kubeforge-bootstrap-auth\n")
+	}
+
+	fmt.Fprintf(&b, `---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: kubeforge-bootstrap
+  namespace: flux-system
+spec:
+  interval: 10m
+  path: %q
+  prune: true
+  sourceRef:
+    kind: GitRepository
+    name: kubeforge-bootstrap
+`, config.path())
+	return b.String()
+}
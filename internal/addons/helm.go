@@ -0,0 +1,57 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+
+	"kubeforge/internal/helm"
+)
+
+// helmAddon installs an addon from a Helm chart
+type helmAddon struct {
+	name           string
+	description    string
+	defaultVersion string // chart version
+	repoURL        string
+	chart          string
+	releaseName    string
+	namespace      string
+}
+
+func (a *helmAddon) Name() string {
+	return a.name
+}
+
+func (a *helmAddon) Description() string {
+	return a.description
+}
+
+func (a *helmAddon) DefaultVersion() string {
+	return a.defaultVersion
+}
+
+// Install installs the chart with default values
+func (a *helmAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	return a.installChart(ctx, kubectl, opts, nil)
+}
+
+// Uninstall removes the chart release
+func (a *helmAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	kubectl.Emit("info", "addon", fmt.Sprintf("Uninstalling %s release", a.releaseName))
+	return helm.NewClient(opts.Kubeconfig).Uninstall(ctx, a.releaseName, a.namespace)
+}
+
+// installChart runs helm upgrade --install with the given values
+func (a *helmAddon) installChart(ctx context.Context, kubectl *Kubectl, opts InstallOptions, values map[string]interface{}) error {
+	kubectl.Emit("info", "addon", fmt.Sprintf("Installing %s %s chart", a.chart, opts.Version))
+	_, err := helm.NewClient(opts.Kubeconfig).UpgradeInstall(ctx, helm.ChartSpec{
+		ReleaseName: a.releaseName,
+		Namespace:   a.namespace,
+		RepoURL:     a.repoURL,
+		Chart:       a.chart,
+		Version:     opts.Version,
+		Values:      values,
+		Wait:        true,
+	})
+	return err
+}
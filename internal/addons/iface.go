@@ -0,0 +1,122 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"kubeforge/internal/provision"
+)
+
+// IAddon defines the interface for an installable cluster addon
+type IAddon interface {
+	// Name returns the addon name (metrics-server, ingress-nginx, etc.)
+	Name() string
+
+	// Description returns a short human readable description
+	Description() string
+
+	// DefaultVersion returns the version installed when none is requested
+	DefaultVersion() string
+
+	// Install installs or upgrades the addon to the given version
+	Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error
+
+	// Uninstall removes the addon from the cluster
+	Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error
+}
+
+// ConfigValidator is implemented by addons that accept a config payload
+type ConfigValidator interface {
+	// ValidateConfig checks the addon specific config before installation starts
+	ValidateConfig(opts InstallOptions) error
+}
+
+// EndpointReporter is implemented by addons that expose user facing endpoints
+type EndpointReporter interface {
+	// Endpoints returns the URLs the addon is reachable under after installation
+	Endpoints(ctx context.Context, kubectl *Kubectl, opts InstallOptions) ([]string, error)
+}
+
+// InstallResult contains the outcome of an addon installation
+type InstallResult struct {
+	Version   string   `json:"version"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// InstallOptions contains parameters for an addon install, upgrade or uninstall
+type InstallOptions struct {
+	Version    string               `json:"version"`
+	Config     json.RawMessage      `json:"config,omitempty"` // addon specific settings
+	Hosts      []provision.HostSpec `json:"-"`                // all cluster nodes, for host level prerequisites
+	Kubeconfig []byte               `json:"-"`                // admin kubeconfig of the cluster, for Helm charts
+}
+
+// CatalogEntry describes an addon available for installation
+type CatalogEntry struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	DefaultVersion string `json:"default_version"`
+}
+
+// Common errors
+var (
+	ErrAddonNotFound = errors.New("addon not found")
+)
+
+var addonRegistry = make(map[string]IAddon)
+
+// RegisterAddon registers a new addon in the catalog
+func RegisterAddon(addon IAddon) {
+	addonRegistry[addon.Name()] = addon
+}
+
+// GetAddon returns an addon by name
+func GetAddon(name string) (IAddon, error) {
+	addon, ok := addonRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAddonNotFound, name)
+	}
+	return addon, nil
+}
+
+// ValidateOptions validates install options for the named addon
+func ValidateOptions(name string, opts InstallOptions) error {
+	addon, err := GetAddon(name)
+	if err != nil {
+		return err
+	}
+	if validator, ok := addon.(ConfigValidator); ok {
+		return validator.ValidateConfig(opts)
+	}
+	return nil
+}
+
+// Catalog returns all registered addons sorted by name
+func Catalog() []CatalogEntry {
+	entries := make([]CatalogEntry, 0, len(addonRegistry))
+	for _, addon := range addonRegistry {
+		entries = append(entries, CatalogEntry{
+			Name:           addon.Name(),
+			Description:    addon.Description(),
+			DefaultVersion: addon.DefaultVersion(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// DecodeConfig unmarshals addon specific config into v, ignoring empty config
+func (o InstallOptions) DecodeConfig(v interface{}) error {
+	if len(o.Config) == 0 || string(o.Config) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(o.Config, v); err != nil {
+		return fmt.Errorf("invalid addon config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"kubeforge/internal/provision"
+)
+
+// Ingress exposure modes
+const (
+	IngressModeNodePort     = "nodeport"
+	IngressModeHostNetwork  = "hostnetwork"
+	IngressModeLoadBalancer = "loadbalancer"
+)
+
+// ingressNodeLabel selects the nodes running the controller in hostNetwork mode
+const ingressNodeLabel = "kubeforge.io/ingress"
+
+// IngressConfig configures how ingress-nginx is exposed
+type IngressConfig struct {
+	Mode      string   `json:"mode,omitempty"`       // nodeport, hostnetwork, loadbalancer; default: loadbalancer with MetalLB, nodeport otherwise
+	HTTPPort  int      `json:"http_port,omitempty"`  // NodePort for HTTP, default: 30080
+	HTTPSPort int      `json:"https_port,omitempty"` // NodePort for HTTPS, default: 30443
+	Nodes     []string `json:"nodes,omitempty"`      // node names labeled for hostNetwork mode, default: all workers
+}
+
+// ingressAddon installs ingress-nginx in the requested exposure mode
+type ingressAddon struct {
+	*helmAddon
+}
+
+func init() {
+	RegisterAddon(&ingressAddon{&helmAddon{
+		name:           "ingress-nginx",
+		description:    "NGINX ingress controller exposed via NodePort, hostNetwork or LoadBalancer",
+		defaultVersion: "4.8.3",
+		repoURL:        "https://kubernetes.github.io/ingress-nginx",
+		chart:          "ingress-nginx",
+		releaseName:    "ingress-nginx",
+		namespace:      "ingress-nginx",
+	}})
+}
+
+// ValidateConfig checks the exposure mode and node names
+func (a *ingressAddon) ValidateConfig(opts InstallOptions) error {
+	var config IngressConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	switch config.Mode {
+	case "", IngressModeNodePort, IngressModeHostNetwork, IngressModeLoadBalancer:
+	default:
+		return fmt.Errorf("invalid ingress mode %q: expected nodeport, hostnetwork or loadbalancer", config.Mode)
+	}
+	for _, node := range config.Nodes {
+		if err := validateName("node name", node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Install installs the controller with values for the selected mode
+func (a *ingressAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	config, err := a.config(ctx, kubectl, opts)
+	if err != nil {
+		return err
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Exposing ingress-nginx in %s mode", config.Mode))
+
+	if config.Mode == IngressModeHostNetwork {
+		if err := a.labelNodes(ctx, kubectl, config, opts); err != nil {
+			return err
+		}
+	}
+
+	return a.installChart(ctx, kubectl, opts, config.values())
+}
+
+// Endpoints returns the URLs under which the ingress controller is reachable
+func (a *ingressAddon) Endpoints(ctx context.Context, kubectl *Kubectl, opts InstallOptions) ([]string, error) {
+	config, err := a.config(ctx, kubectl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Mode {
+	case IngressModeLoadBalancer:
+		ip, err := kubectl.Run(ctx, "get svc ingress-nginx-controller -n ingress-nginx -o jsonpath='{.status.loadBalancer.ingress[0].ip}'")
+		if err != nil {
+			return nil, err
+		}
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			return nil, nil
+		}
+		return []string{"http://" + ip, "https://" + ip}, nil
+
+	case IngressModeHostNetwork:
+		addresses, err := kubectl.Run(ctx, fmt.Sprintf(`get nodes -l %s=true -o jsonpath='{.items[*].status.addresses[?(@.type=="InternalIP")].address}'`, ingressNodeLabel))
+		if err != nil {
+			return nil, err
+		}
+		var endpoints []string
+		for _, ip := range strings.Fields(addresses) {
+			endpoints = append(endpoints, "http://"+ip, "https://"+ip)
+		}
+		return endpoints, nil
+
+	default:
+		var endpoints []string
+		for _, host := range storageHosts(opts.Hosts) {
+			endpoints = append(endpoints,
+				fmt.Sprintf("http://%s:%d", host.Address, config.HTTPPort),
+				fmt.Sprintf("https://%s:%d", host.Address, config.HTTPSPort))
+		}
+		return endpoints, nil
+	}
+}
+
+// config decodes the config and resolves the default mode and ports
+func (a *ingressAddon) config(ctx context.Context, kubectl *Kubectl, opts InstallOptions) (IngressConfig, error) {
+	var config IngressConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return config, err
+	}
+
+	hasMetalLB := a.metallbInstalled(ctx, kubectl)
+	if config.Mode == "" {
+		config.Mode = IngressModeNodePort
+		if hasMetalLB {
+			config.Mode = IngressModeLoadBalancer
+		}
+	}
+	if config.Mode == IngressModeLoadBalancer && !hasMetalLB {
+		return config, fmt.Errorf("loadbalancer mode requires the metallb addon")
+	}
+	if config.HTTPPort == 0 {
+		config.HTTPPort = 30080
+	}
+	if config.HTTPSPort == 0 {
+		config.HTTPSPort = 30443
+	}
+	return config, nil
+}
+
+// metallbInstalled checks whether MetalLB is running in the cluster
+func (a *ingressAddon) metallbInstalled(ctx context.Context, kubectl *Kubectl) bool {
+	_, err := kubectl.Run(ctx, "get namespace metallb-system")
+	return err == nil
+}
+
+// labelNodes labels the nodes that should run the hostNetwork controller
+func (a *ingressAddon) labelNodes(ctx context.Context, kubectl *Kubectl, config IngressConfig, opts InstallOptions) error {
+	nodes := config.Nodes
+	if len(nodes) == 0 {
+		for _, host := range storageHosts(opts.Hosts) {
+			nodes = append(nodes, host.Hostname)
+		}
+	}
+	for _, node := range nodes {
+		if err := validateName("node name", node); err != nil {
+			return err
+		}
+		if _, err := kubectl.Run(ctx, fmt.Sprintf("label node %s %s=true --overwrite", provision.ShellQuote(node), ingressNodeLabel)); err != nil {
+			return fmt.Errorf("failed to label node %s: %w", node, err)
+		}
+	}
+	return nil
+}
+
+// values renders the chart values for the selected mode
+func (c IngressConfig) values() map[string]interface{} {
+	controller := map[string]interface{}{}
+
+	switch c.Mode {
+	case IngressModeHostNetwork:
+		controller["kind"] = "DaemonSet"
+		controller["hostNetwork"] = true
+		controller["dnsPolicy"] = "ClusterFirstWithHostNet"
+		controller["nodeSelector"] = map[string]string{ingressNodeLabel: "true"}
+		controller["service"] = map[string]interface{}{"type": "ClusterIP"}
+	case IngressModeLoadBalancer:
+		controller["service"] = map[string]interface{}{"type": "LoadBalancer"}
+	default:
+		controller["service"] = map[string]interface{}{
+			"type": "NodePort",
+			"nodePorts": map[string]int{
+				"http":  c.HTTPPort,
+				"https": c.HTTPSPort,
+			},
+		}
+	}
+
+	return map[string]interface{}{"controller": controller}
+}
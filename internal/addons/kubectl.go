@@ -0,0 +1,97 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"kubeforge/internal/provision"
+)
+
+// Kubectl runs kubectl commands on a control plane node over SSH
+type Kubectl struct {
+	client        *provision.SSHClient
+	host          provision.HostSpec
+	eventCallback provision.EventCallback
+}
+
+// NewKubectl connects to the control plane host
+func NewKubectl(host provision.HostSpec, callback provision.EventCallback) (*Kubectl, error) {
+	client, err := provision.NewSSHClient(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	return &Kubectl{
+		client:        client,
+		host:          host,
+		eventCallback: callback,
+	}, nil
+}
+
+// Close closes the underlying SSH connection
+func (k *Kubectl) Close() error {
+	return k.client.Close()
+}
+
+// Host returns the control plane host kubectl runs on
+func (k *Kubectl) Host() provision.HostSpec {
+	return k.host
+}
+
+// Client returns the underlying SSH client
+func (k *Kubectl) Client() *provision.SSHClient {
+	return k.client
+}
+
+// Run executes "kubectl <args>" and returns stdout
+func (k *Kubectl) Run(ctx context.Context, args string) (string, error) {
+	return k.run(ctx, args, args)
+}
+
+// ApplyURL applies a remote manifest
+func (k *Kubectl) ApplyURL(ctx context.Context, url string) error {
+	_, err := k.Run(ctx, fmt.Sprintf("apply -f %s", url))
+	return err
+}
+
+// DeleteURL deletes the resources of a remote manifest
+func (k *Kubectl) DeleteURL(ctx context.Context, url string) error {
+	_, err := k.Run(ctx, fmt.Sprintf("delete -f %s --ignore-not-found", url))
+	return err
+}
+
+// Apply applies an inline manifest. The manifest is passed on stdin, so it
+// may contain anything, and is never included in returned errors as it may
+// contain secrets.
+func (k *Kubectl) Apply(ctx context.Context, manifest string) error {
+	_, err := k.run(ctx, "apply -f -", "apply -f -", provision.WithStdin(strings.NewReader(manifest)))
+	return err
+}
+
+// Delete deletes the resources of an inline manifest
+func (k *Kubectl) Delete(ctx context.Context, manifest string) error {
+	_, err := k.run(ctx, "delete --ignore-not-found -f -", "delete -f -", provision.WithStdin(strings.NewReader(manifest)))
+	return err
+}
+
+// run executes kubectl, reporting failures using display instead of the full arguments
+func (k *Kubectl) run(ctx context.Context, args, display string, opts ...provision.CommandOption) (string, error) {
+	stdout, stderr, err := k.client.RunCommand(ctx, "kubectl "+args, opts...)
+	if err != nil {
+		return stdout, fmt.Errorf("kubectl %s failed: %s: %w", display, stderr, err)
+	}
+	return stdout, nil
+}
+
+// WaitForDeployments waits until all deployments in a namespace are available
+func (k *Kubectl) WaitForDeployments(ctx context.Context, namespace string) error {
+	_, err := k.Run(ctx, fmt.Sprintf("wait --for=condition=Available deployment --all -n %s --timeout=300s", namespace))
+	return err
+}
+
+// Emit sends an event through the configured callback
+func (k *Kubectl) Emit(level, step, message string) {
+	if k.eventCallback != nil {
+		k.eventCallback(provision.NewProvisionEvent(level, k.host.Address, provision.Step(step), message))
+	}
+}
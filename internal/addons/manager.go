@@ -0,0 +1,80 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+
+	"kubeforge/internal/provision"
+)
+
+// Manager installs, upgrades and removes addons on a cluster
+type Manager struct {
+	eventCallback provision.EventCallback
+}
+
+// NewManager creates a new addon manager
+func NewManager(callback provision.EventCallback) *Manager {
+	return &Manager{eventCallback: callback}
+}
+
+// Install installs (or upgrades) an addon using the given control plane host
+func (m *Manager) Install(ctx context.Context, controlPlane provision.HostSpec, name string, opts InstallOptions) (*InstallResult, error) {
+	addon, err := GetAddon(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Version == "" {
+		opts.Version = addon.DefaultVersion()
+	}
+	if err := ValidateOptions(name, opts); err != nil {
+		return nil, err
+	}
+
+	kubectl, err := NewKubectl(controlPlane, m.eventCallback)
+	if err != nil {
+		return nil, err
+	}
+	defer kubectl.Close()
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Installing %s %s", name, opts.Version))
+	if err := addon.Install(ctx, kubectl, opts); err != nil {
+		return nil, fmt.Errorf("failed to install %s: %w", name, err)
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("%s %s installed successfully", name, opts.Version))
+
+	result := &InstallResult{Version: opts.Version}
+	if reporter, ok := addon.(EndpointReporter); ok {
+		endpoints, err := reporter.Endpoints(ctx, kubectl, opts)
+		if err != nil {
+			kubectl.Emit("warn", "addon", fmt.Sprintf("Failed to resolve %s endpoints: %v", name, err))
+		}
+		result.Endpoints = endpoints
+	}
+
+	return result, nil
+}
+
+// Uninstall removes an addon using the given control plane host
+func (m *Manager) Uninstall(ctx context.Context, controlPlane provision.HostSpec, name string, opts InstallOptions) error {
+	addon, err := GetAddon(name)
+	if err != nil {
+		return err
+	}
+	if opts.Version == "" {
+		opts.Version = addon.DefaultVersion()
+	}
+
+	kubectl, err := NewKubectl(controlPlane, m.eventCallback)
+	if err != nil {
+		return err
+	}
+	defer kubectl.Close()
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Uninstalling %s %s", name, opts.Version))
+	if err := addon.Uninstall(ctx, kubectl, opts); err != nil {
+		return fmt.Errorf("failed to uninstall %s: %w", name, err)
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("%s uninstalled", name))
+
+	return nil
+}
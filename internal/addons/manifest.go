@@ -0,0 +1,39 @@
+package addons
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dnsNamePattern matches DNS-1123 subdomains, the names of most Kubernetes
+// resources
+var dnsNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// validateName checks that a name from addon config is a DNS-1123 subdomain,
+// so it is safe in manifests and kubectl commands
+func validateName(field, name string) error {
+	if len(name) > 253 || !dnsNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid %s %q: must consist of lower case alphanumeric characters, '-' or '.'", field, name)
+	}
+	return nil
+}
+
+// renderManifest marshals Kubernetes objects into a multi-document YAML
+// manifest, so values from addon config cannot change its structure
+func renderManifest(objects ...interface{}) (string, error) {
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return "", fmt.Errorf("failed to render manifest: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to render manifest: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,141 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MetalLBConfig configures the address pool created for MetalLB
+type MetalLBConfig struct {
+	PoolName  string   `json:"pool_name,omitempty"` // default: "default"
+	Addresses []string `json:"addresses"`           // CIDRs or "start-end" ranges
+	L2        *bool    `json:"l2,omitempty"`        // create an L2Advertisement, default: true
+}
+
+// metallbAddon installs MetalLB and configures its address pool
+type metallbAddon struct {
+	*manifestAddon
+}
+
+func init() {
+	RegisterAddon(&metallbAddon{&manifestAddon{
+		name:           "metallb",
+		description:    "Network load balancer for bare-metal clusters",
+		defaultVersion: "v0.13.12",
+		namespace:      "metallb-system",
+		manifestURL:    "https://raw.githubusercontent.com/metallb/metallb/%s/config/manifests/metallb-native.yaml",
+	}})
+}
+
+// ValidateConfig checks the address pool configuration
+func (a *metallbAddon) ValidateConfig(opts InstallOptions) error {
+	var config MetalLBConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	return config.validate()
+}
+
+// Install applies the MetalLB manifest and creates the address pool
+func (a *metallbAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config MetalLBConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+
+	if err := a.manifestAddon.Install(ctx, kubectl, opts); err != nil {
+		return err
+	}
+
+	if len(config.Addresses) == 0 {
+		kubectl.Emit("warn", "addon", "No MetalLB addresses configured, LoadBalancer services will stay pending")
+		return nil
+	}
+
+	manifest, err := config.manifest()
+	if err != nil {
+		return err
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("Creating MetalLB address pool %s", config.poolName()))
+	if err := kubectl.Apply(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to configure address pool: %w", err)
+	}
+	return nil
+}
+
+// Uninstall removes the address pool and MetalLB itself
+func (a *metallbAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config MetalLBConfig
+	if err := opts.DecodeConfig(&config); err == nil && len(config.Addresses) > 0 {
+		manifest, err := config.manifest()
+		if err == nil {
+			err = kubectl.Delete(ctx, manifest)
+		}
+		if err != nil {
+			kubectl.Emit("warn", "addon", fmt.Sprintf("Failed to delete address pool: %v", err))
+		}
+	}
+	return a.manifestAddon.Uninstall(ctx, kubectl, opts)
+}
+
+func (c MetalLBConfig) poolName() string {
+	if c.PoolName == "" {
+		return "default"
+	}
+	return c.PoolName
+}
+
+// validate checks the pool name and addresses
+func (c MetalLBConfig) validate() error {
+	if err := validateName("pool_name", c.poolName()); err != nil {
+		return err
+	}
+	for _, address := range c.Addresses {
+		if err := validateAddressRange(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifest renders the IPAddressPool and L2Advertisement resources
+func (c MetalLBConfig) manifest() (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	metadata := map[string]interface{}{"name": c.poolName(), "namespace": "metallb-system"}
+	objects := []interface{}{map[string]interface{}{
+		"apiVersion": "metallb.io/v1beta1",
+		"kind":       "IPAddressPool",
+		"metadata":   metadata,
+		"spec":       map[string]interface{}{"addresses": c.Addresses},
+	}}
+	if c.L2 == nil || *c.L2 {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "metallb.io/v1beta1",
+			"kind":       "L2Advertisement",
+			"metadata":   metadata,
+			"spec":       map[string]interface{}{"ipAddressPools": []string{c.poolName()}},
+		})
+	}
+	return renderManifest(objects...)
+}
+
+// validateAddressRange accepts a CIDR or an "start-end" IP range
+func validateAddressRange(address string) error {
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return nil
+	}
+	parts := strings.Split(address, "-")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid address range %q: expected CIDR or start-end", address)
+	}
+	for _, part := range parts {
+		if net.ParseIP(strings.TrimSpace(part)) == nil {
+			return fmt.Errorf("invalid address range %q: %q is not an IP address", address, part)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+
+	"kubeforge/internal/helm"
+	"kubeforge/internal/provision"
+)
+
+// defaultClassAnnotation marks a StorageClass as the cluster default
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// StorageConfig configures a storage addon
+type StorageConfig struct {
+	Default      *bool    `json:"default,omitempty"`       // mark the StorageClass as default, default: true
+	StorageClass string   `json:"storage_class,omitempty"` // StorageClass name override
+	Server       string   `json:"server,omitempty"`        // NFS server address
+	Share        string   `json:"share,omitempty"`         // NFS export path
+	MountOptions []string `json:"mount_options,omitempty"` // NFS mount options
+}
+
+// IsDefault reports whether the StorageClass should become the cluster default
+func (c StorageConfig) IsDefault() bool {
+	return c.Default == nil || *c.Default
+}
+
+// storageAddon installs a dynamic volume provisioner and its StorageClass
+type storageAddon struct {
+	*manifestAddon
+	storageClass string
+	prepareHost  string // script run on every node before installation
+}
+
+// nfsAddon installs the NFS CSI driver via Helm and creates a StorageClass for the given share
+type nfsAddon struct {
+	defaultVersion string
+}
+
+func init() {
+	RegisterAddon(&storageAddon{
+		manifestAddon: &manifestAddon{
+			name:           "local-path-provisioner",
+			description:    "Node-local persistent volumes backed by host directories",
+			defaultVersion: "v0.0.26",
+			namespace:      "local-path-storage",
+			manifestURL:    "https://raw.githubusercontent.com/rancher/local-path-provisioner/%s/deploy/local-path-storage.yaml",
+		},
+		storageClass: "local-path",
+	})
+	RegisterAddon(&storageAddon{
+		manifestAddon: &manifestAddon{
+			name:           "longhorn",
+			description:    "Distributed replicated block storage",
+			defaultVersion: "v1.5.3",
+			namespace:      "longhorn-system",
+			manifestURL:    "https://raw.githubusercontent.com/longhorn/longhorn/%s/deploy/longhorn.yaml",
+		},
+		storageClass: "longhorn",
+		prepareHost: `
+apt-get update
+apt-get install -y open-iscsi nfs-common
+systemctl enable --now iscsid
+`,
+	})
+	RegisterAddon(&nfsAddon{defaultVersion: "v4.5.0"})
+}
+
+// Install prepares the nodes, applies the manifest and sets the default StorageClass
+func (a *storageAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config StorageConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+
+	if a.prepareHost != "" {
+		for _, host := range storageHosts(opts.Hosts) {
+			if err := a.prepareNode(ctx, kubectl, host); err != nil {
+				return fmt.Errorf("failed to prepare host %s: %w", host.Address, err)
+			}
+		}
+	}
+
+	if err := a.manifestAddon.Install(ctx, kubectl, opts); err != nil {
+		return err
+	}
+
+	if config.IsDefault() {
+		return SetDefaultStorageClass(ctx, kubectl, a.storageClass)
+	}
+	return nil
+}
+
+// prepareNode installs the host level prerequisites of the provisioner
+func (a *storageAddon) prepareNode(ctx context.Context, kubectl *Kubectl, host provision.HostSpec) error {
+	kubectl.Emit("info", "addon", fmt.Sprintf("Installing %s prerequisites on %s", a.name, host.Address))
+
+	client, err := provision.NewSSHClient(host)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	if _, stderr, err := client.RunCommand(ctx, a.prepareHost); err != nil {
+		return fmt.Errorf("%s: %w", stderr, err)
+	}
+	return nil
+}
+
+// storageHosts returns the worker nodes, or all nodes of a cluster without workers
+func storageHosts(hosts []provision.HostSpec) []provision.HostSpec {
+	var workers []provision.HostSpec
+	for _, host := range hosts {
+		if host.Role == "worker" {
+			workers = append(workers, host)
+		}
+	}
+	if len(workers) == 0 {
+		return hosts
+	}
+	return workers
+}
+
+func (a *nfsAddon) Name() string {
+	return "nfs-csi"
+}
+
+func (a *nfsAddon) Description() string {
+	return "NFS CSI driver with a StorageClass for an existing NFS share"
+}
+
+func (a *nfsAddon) DefaultVersion() string {
+	return a.defaultVersion
+}
+
+// ValidateConfig checks that the NFS server and share are provided
+func (a *nfsAddon) ValidateConfig(opts InstallOptions) error {
+	var config StorageConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	return a.validate(config)
+}
+
+// validate checks the StorageClass name and the NFS settings
+func (a *nfsAddon) validate(config StorageConfig) error {
+	if config.Server == "" || config.Share == "" {
+		return fmt.Errorf("nfs-csi requires config.server and config.share")
+	}
+	if err := validateName("storage_class", a.storageClass(config)); err != nil {
+		return err
+	}
+	if net.ParseIP(config.Server) == nil {
+		if err := validateName("server", config.Server); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(config.Share, "/") || strings.ContainsFunc(config.Share, unicode.IsControl) {
+		return fmt.Errorf("invalid share %q: expected an absolute export path", config.Share)
+	}
+	for _, option := range config.MountOptions {
+		if option == "" || strings.ContainsFunc(option, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+			return fmt.Errorf("invalid mount option %q", option)
+		}
+	}
+	return nil
+}
+
+// Install deploys the CSI driver chart and creates the NFS StorageClass
+func (a *nfsAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config StorageConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	manifest, err := a.manifest(config)
+	if err != nil {
+		return err
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Installing csi-driver-nfs %s chart", opts.Version))
+	if _, err := helm.NewClient(opts.Kubeconfig).UpgradeInstall(ctx, helm.ChartSpec{
+		ReleaseName: "csi-driver-nfs",
+		Namespace:   "kube-system",
+		RepoURL:     "https://raw.githubusercontent.com/kubernetes-csi/csi-driver-nfs/master/charts",
+		Chart:       "csi-driver-nfs",
+		Version:     opts.Version,
+		Wait:        true,
+	}); err != nil {
+		return err
+	}
+
+	kubectl.Emit("info", "addon", fmt.Sprintf("Creating StorageClass %s for %s:%s", a.storageClass(config), config.Server, config.Share))
+	if err := kubectl.Apply(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to create StorageClass: %w", err)
+	}
+
+	if config.IsDefault() {
+		return SetDefaultStorageClass(ctx, kubectl, a.storageClass(config))
+	}
+	return nil
+}
+
+// Uninstall removes the StorageClass and the CSI driver release
+func (a *nfsAddon) Uninstall(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config StorageConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+
+	if err := validateName("storage_class", a.storageClass(config)); err != nil {
+		return err
+	}
+	if _, err := kubectl.Run(ctx, "delete storageclass --ignore-not-found "+provision.ShellQuote(a.storageClass(config))); err != nil {
+		return err
+	}
+	return helm.NewClient(opts.Kubeconfig).Uninstall(ctx, "csi-driver-nfs", "kube-system")
+}
+
+func (a *nfsAddon) storageClass(config StorageConfig) string {
+	if config.StorageClass == "" {
+		return "nfs-csi"
+	}
+	return config.StorageClass
+}
+
+// manifest renders the NFS StorageClass
+func (a *nfsAddon) manifest(config StorageConfig) (string, error) {
+	if err := a.validate(config); err != nil {
+		return "", err
+	}
+	storageClass := map[string]interface{}{
+		"apiVersion":  "storage.k8s.io/v1",
+		"kind":        "StorageClass",
+		"metadata":    map[string]interface{}{"name": a.storageClass(config)},
+		"provisioner": "nfs.csi.k8s.io",
+		"parameters": map[string]string{
+			"server": config.Server,
+			"share":  config.Share,
+		},
+		"reclaimPolicy":     "Delete",
+		"volumeBindingMode": "Immediate",
+	}
+	if len(config.MountOptions) > 0 {
+		storageClass["mountOptions"] = config.MountOptions
+	}
+	return renderManifest(storageClass)
+}
+
+// SetDefaultStorageClass marks name as the only default StorageClass
+func SetDefaultStorageClass(ctx context.Context, kubectl *Kubectl, name string) error {
+	if err := validateName("storage class", name); err != nil {
+		return err
+	}
+	kubectl.Emit("info", "addon", fmt.Sprintf("Marking StorageClass %s as default", name))
+
+	unset := fmt.Sprintf(`for sc in $(kubectl get storageclass -o name); do kubectl patch "$sc" -p %s; done`, provision.ShellQuote(defaultClassPatch("false")))
+	if _, stderr, err := kubectl.Client().RunCommand(ctx, unset); err != nil {
+		return fmt.Errorf("failed to reset default StorageClass: %s: %w", stderr, err)
+	}
+
+	_, err := kubectl.Run(ctx, fmt.Sprintf("patch storageclass %s -p %s", provision.ShellQuote(name), provision.ShellQuote(defaultClassPatch("true"))))
+	return err
+}
+
+// defaultClassPatch returns the merge patch setting the default class
+// annotation to value
+func defaultClassPatch(value string) string {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{defaultClassAnnotation: value},
+		},
+	})
+	return string(patch)
+}
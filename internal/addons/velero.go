@@ -0,0 +1,203 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// veleroNamespace is where Velero and its custom resources live
+const veleroNamespace = "velero"
+
+// veleroNamePattern restricts schedule and backup names to DNS-1123 labels
+var veleroNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// VeleroConfig configures the S3-compatible backup storage location
+type VeleroConfig struct {
+	Endpoint    string             `json:"endpoint,omitempty"` // S3 URL, empty for AWS S3
+	Bucket      string             `json:"bucket"`
+	Prefix      string             `json:"prefix,omitempty"`
+	Region      string             `json:"region,omitempty"` // default: us-east-1
+	PluginImage string             `json:"plugin_image,omitempty"`
+	Credentials *ObjectCredentials `json:"credentials,omitempty"`
+	NodeAgent   bool               `json:"node_agent,omitempty"` // file system backups of pod volumes
+}
+
+// ObjectCredentials authenticate against S3-compatible object storage
+type ObjectCredentials struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// BackupSchedule describes a Velero Schedule
+type BackupSchedule struct {
+	Name              string   `json:"name"`
+	Schedule          string   `json:"schedule"` // cron expression
+	IncludeNamespaces []string `json:"include_namespaces,omitempty"`
+	ExcludeNamespaces []string `json:"exclude_namespaces,omitempty"`
+	TTL               string   `json:"ttl,omitempty"` // e.g. "720h0m0s"
+}
+
+// veleroAddon installs Velero via Helm with an S3-compatible storage location
+type veleroAddon struct {
+	*helmAddon
+}
+
+func init() {
+	RegisterAddon(&veleroAddon{&helmAddon{
+		name:           "velero",
+		description:    "Workload backups to S3-compatible object storage",
+		defaultVersion: "5.1.7",
+		repoURL:        "https://vmware-tanzu.github.io/helm-charts",
+		chart:          "velero",
+		releaseName:    "velero",
+		namespace:      veleroNamespace,
+	}})
+}
+
+// ValidateConfig checks the storage location settings
+func (a *veleroAddon) ValidateConfig(opts InstallOptions) error {
+	var config VeleroConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	if config.Bucket == "" {
+		return fmt.Errorf("velero requires a bucket")
+	}
+	if config.Credentials == nil || config.Credentials.AccessKeyID == "" || config.Credentials.SecretAccessKey == "" {
+		return fmt.Errorf("velero requires credentials.access_key_id and credentials.secret_access_key")
+	}
+	return nil
+}
+
+// Install deploys the Velero chart configured for the storage location
+func (a *veleroAddon) Install(ctx context.Context, kubectl *Kubectl, opts InstallOptions) error {
+	var config VeleroConfig
+	if err := opts.DecodeConfig(&config); err != nil {
+		return err
+	}
+	return a.installChart(ctx, kubectl, opts, config.values())
+}
+
+// values renders the chart values
+func (c VeleroConfig) values() map[string]interface{} {
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	pluginImage := c.PluginImage
+	if pluginImage == "" {
+		pluginImage = "velero/velero-plugin-for-aws:v1.8.2"
+	}
+
+	locationConfig := map[string]interface{}{"region": region}
+	if c.Endpoint != "" {
+		locationConfig["s3Url"] = c.Endpoint
+		locationConfig["s3ForcePathStyle"] = "true"
+	}
+	location := map[string]interface{}{
+		"name":     "default",
+		"provider": "aws",
+		"bucket":   c.Bucket,
+		"default":  true,
+		"config":   locationConfig,
+	}
+	if c.Prefix != "" {
+		location["prefix"] = c.Prefix
+	}
+
+	return map[string]interface{}{
+		"configuration": map[string]interface{}{
+			"backupStorageLocation":  []interface{}{location},
+			"volumeSnapshotLocation": []interface{}{},
+		},
+		"credentials": map[string]interface{}{
+			"useSecret": true,
+			"secretContents": map[string]string{
+				"cloud": fmt.Sprintf("[default]\naws_access_key_id=%s\naws_secret_access_key=%s\n",
+					c.Credentials.AccessKeyID, c.Credentials.SecretAccessKey),
+			},
+		},
+		"initContainers": []interface{}{
+			map[string]interface{}{
+				"name":  "velero-plugin-for-aws",
+				"image": pluginImage,
+				"volumeMounts": []interface{}{
+					map[string]string{"mountPath": "/target", "name": "plugins"},
+				},
+			},
+		},
+		"snapshotsEnabled": false,
+		"deployNodeAgent":  c.NodeAgent,
+	}
+}
+
+// ListVeleroResources returns the raw items of a Velero resource kind (schedules, backups)
+func ListVeleroResources(ctx context.Context, kubectl *Kubectl, kind string) ([]json.RawMessage, error) {
+	stdout, err := kubectl.Run(ctx, fmt.Sprintf("get %s.velero.io -n %s -o json", kind, veleroNamespace))
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return list.Items, nil
+}
+
+// Validate checks a backup schedule
+func (s BackupSchedule) Validate() error {
+	if !veleroNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid schedule name %q", s.Name)
+	}
+	if len(strings.Fields(s.Schedule)) != 5 && !strings.HasPrefix(s.Schedule, "@") {
+		return fmt.Errorf("invalid cron schedule %q", s.Schedule)
+	}
+	return nil
+}
+
+// ApplySchedule creates or updates a Velero Schedule
+func ApplySchedule(ctx context.Context, kubectl *Kubectl, schedule BackupSchedule) error {
+	template := map[string]interface{}{}
+	if len(schedule.IncludeNamespaces) > 0 {
+		template["includedNamespaces"] = schedule.IncludeNamespaces
+	}
+	if len(schedule.ExcludeNamespaces) > 0 {
+		template["excludedNamespaces"] = schedule.ExcludeNamespaces
+	}
+	if schedule.TTL != "" {
+		template["ttl"] = schedule.TTL
+	}
+
+	// JSON is valid YAML, so the resource can be applied as-is
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Schedule",
+		"metadata": map[string]string{
+			"name":      schedule.Name,
+			"namespace": veleroNamespace,
+		},
+		"spec": map[string]interface{}{
+			"schedule": schedule.Schedule,
+			"template": template,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return kubectl.Apply(ctx, string(manifest))
+}
+
+// DeleteSchedule deletes a Velero Schedule
+func DeleteSchedule(ctx context.Context, kubectl *Kubectl, name string) error {
+	if !veleroNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid schedule name %q", name)
+	}
+	_, err := kubectl.Run(ctx, fmt.Sprintf("delete schedules.velero.io %s -n %s", name, veleroNamespace))
+	return err
+}
@@ -0,0 +1,464 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/secrets"
+	"kubeforge/internal/tracing"
+)
+
+// InstallAddonRequest represents the request to install or upgrade an addon
+type InstallAddonRequest struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+}
+
+// Addon job types
+const (
+	jobInstallAddon   = "install-addon" // also upgrades and reconfigures
+	jobUninstallAddon = "uninstall-addon"
+)
+
+// addonPayload is the input of addon jobs
+type addonPayload struct {
+	AddonID uint `json:"addon_id"`
+}
+
+// AddonHandler handles addon-related API requests
+type AddonHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
+
+// NewAddonHandler creates a new addon handler and registers its job handlers
+func NewAddonHandler(store *db.Store, queue *jobs.Queue) *AddonHandler {
+	h := &AddonHandler{store: store, queue: queue}
+	queue.RegisterHandler(jobInstallAddon, trackJob(h.runInstallAddonJob))
+	queue.RegisterHandler(jobUninstallAddon, trackJob(h.runUninstallAddonJob))
+	return h
+}
+
+// RegisterRoutes registers addon API routes
+func (h *AddonHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/addons", h.ListCatalog).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/addons", h.ListAddons).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/addons", h.InstallAddon).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/addons/{name}", h.GetAddon).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/addons/{name}", h.UpgradeAddon).Methods("PUT")
+	router.HandleFunc("/api/v1/clusters/{id}/addons/{name}", h.UninstallAddon).Methods("DELETE")
+}
+
+// ListCatalog lists all addons available for installation
+func (h *AddonHandler) ListCatalog(w http.ResponseWriter, r *http.Request) {
+	WriteSuccess(w, addons.Catalog())
+}
+
+// ListAddons lists addons installed on a cluster
+func (h *AddonHandler) ListAddons(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var installed []db.Addon
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("name").Find(&installed).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve addons")
+		return
+	}
+
+	WriteSuccess(w, installed)
+}
+
+// GetAddon returns an addon of a cluster, whose status tracks a running
+// install, upgrade or uninstall
+func (h *AddonHandler) GetAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var record db.Addon
+	if err := h.store.DB().Where("cluster_id = ? AND name = ?", id, vars["name"]).First(&record).Error; err != nil {
+		WriteNotFound(w, "Addon not installed")
+		return
+	}
+
+	WriteSuccess(w, record)
+}
+
+// InstallAddon installs an addon on a cluster
+func (h *AddonHandler) InstallAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var req InstallAddonRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		WriteBadRequest(w, "Addon name is required")
+		return
+	}
+
+	addon, err := addons.GetAddon(req.Name)
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+	if req.Version == "" {
+		req.Version = addon.DefaultVersion()
+	}
+	if err := addons.ValidateOptions(req.Name, addons.InstallOptions{Version: req.Version, Config: req.Config}); err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var existing db.Addon
+	if err := h.store.DB().Where("cluster_id = ? AND name = ?", cluster.ID, req.Name).First(&existing).Error; err == nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Addon is already installed, use PUT to upgrade")
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteInternalError(w, "Failed to retrieve addons")
+		return
+	}
+
+	config, credentials, err := sealAddonConfig(req.Config)
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	record := db.Addon{
+		ClusterID:   cluster.ID,
+		Name:        req.Name,
+		Version:     req.Version,
+		Status:      "installing",
+		Config:      config,
+		Credentials: credentials,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	err = h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		_, err := enqueueAddonJob(r.Context(), h.queue, tx, jobInstallAddon, record)
+		return err
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to create addon")
+		return
+	}
+	h.queue.Notify()
+
+	WriteAccepted(w, addonLocation(record.ClusterID, record.Name), record)
+}
+
+// UpgradeAddon upgrades (or reconfigures) an installed addon
+func (h *AddonHandler) UpgradeAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var req InstallAddonRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var record db.Addon
+	if err := h.store.DB().Where("cluster_id = ? AND name = ?", cluster.ID, vars["name"]).First(&record).Error; err != nil {
+		WriteNotFound(w, "Addon not installed")
+		return
+	}
+
+	addon, err := addons.GetAddon(record.Name)
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+	if req.Version == "" {
+		req.Version = addon.DefaultVersion()
+	}
+	if len(req.Config) > 0 {
+		config, credentials, err := sealAddonConfig(req.Config)
+		if err != nil {
+			WriteBadRequest(w, err.Error())
+			return
+		}
+		// Credentials are kept unless new ones are supplied
+		record.Config = config
+		if credentials != nil {
+			record.Credentials = credentials
+		}
+	}
+
+	fullConfig, err := openAddonConfig(record)
+	if err != nil {
+		WriteInternalError(w, "Failed to read addon credentials")
+		return
+	}
+	if err := addons.ValidateOptions(record.Name, addons.InstallOptions{Version: req.Version, Config: fullConfig}); err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	record.Version = req.Version
+	record.Status = "upgrading"
+	record.Error = ""
+	err = h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+		_, err := enqueueAddonJob(r.Context(), h.queue, tx, jobInstallAddon, record)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "An install or upgrade of the addon is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to update addon")
+		return
+	}
+	h.queue.Notify()
+
+	WriteAccepted(w, addonLocation(record.ClusterID, record.Name), record)
+}
+
+// UninstallAddon removes an addon from a cluster
+func (h *AddonHandler) UninstallAddon(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var record db.Addon
+	if err := h.store.DB().Where("cluster_id = ? AND name = ?", cluster.ID, vars["name"]).First(&record).Error; err != nil {
+		WriteNotFound(w, "Addon not installed")
+		return
+	}
+
+	err = h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&record).Update("status", "uninstalling").Error; err != nil {
+			return err
+		}
+		_, err := enqueueAddonJob(r.Context(), h.queue, tx, jobUninstallAddon, record)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "An uninstall of the addon is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to uninstall addon")
+		return
+	}
+	h.queue.Notify()
+
+	// The addon is gone once the location returns 404
+	WriteAccepted(w, addonLocation(record.ClusterID, record.Name), map[string]string{"message": "Addon uninstall started"})
+}
+
+// enqueueAddonJob queues an addon job for an addon record in the transaction
+// that saves it, so the job cannot run before the record is committed.
+// Call Notify on the queue once the transaction has committed.
+func enqueueAddonJob(ctx context.Context, queue *jobs.Queue, tx *gorm.DB, jobType string, record db.Addon) (*db.Job, error) {
+	payload, _ := json.Marshal(addonPayload{AddonID: record.ID})
+	job := db.Job{
+		ClusterID:   record.ClusterID,
+		Type:        jobType,
+		Target:      record.Name,
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(ctx),
+		TraceParent: tracing.Inject(ctx),
+	}
+	return &job, queue.EnqueueTx(db.NewStore(tx).Jobs, &job)
+}
+
+// loadAddonJob returns the addon record of an addon job
+func loadAddonJob(conn *gorm.DB, job *db.Job) (db.Addon, error) {
+	var payload addonPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return db.Addon{}, fmt.Errorf("invalid addon job payload: %w", err)
+	}
+	var record db.Addon
+	err := conn.First(&record, payload.AddonID).Error
+	return record, err
+}
+
+// runInstallAddonJob installs, upgrades or reconfigures the addon of a job
+func (h *AddonHandler) runInstallAddonJob(ctx context.Context, job *db.Job) error {
+	record, err := loadAddonJob(h.store.DB(), job)
+	if err != nil {
+		return fmt.Errorf("failed to load addon: %w", err)
+	}
+	return installAddon(h.store.DB(), ctx, record)
+}
+
+// runUninstallAddonJob uninstalls the addon of a job. An addon that is gone
+// was uninstalled before the job was interrupted.
+func (h *AddonHandler) runUninstallAddonJob(ctx context.Context, job *db.Job) error {
+	record, err := loadAddonJob(h.store.DB(), job)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load addon: %w", err)
+	}
+	return uninstallAddon(h.store.DB(), ctx, record)
+}
+
+// installAddon installs or upgrades an addon, recording the outcome on the addon record
+func installAddon(conn *gorm.DB, ctx context.Context, record db.Addon) error {
+	config, err := openAddonConfig(record)
+	if err != nil {
+		return failAddon(conn, record, "Failed to read addon credentials", err)
+	}
+	opts := addons.InstallOptions{Version: record.Version, Config: config}
+
+	host, err := controlPlaneHost(conn, record.ClusterID)
+	if err != nil {
+		return failAddon(conn, record, "Failed to find control plane", err)
+	}
+
+	opts.Hosts, err = clusterHosts(conn, record.ClusterID)
+	if err != nil {
+		return failAddon(conn, record, "Failed to load cluster nodes", err)
+	}
+
+	opts.Kubeconfig, err = clusterKubeconfig(conn, record.ClusterID)
+	if err != nil {
+		return failAddon(conn, record, "Failed to load cluster kubeconfig", err)
+	}
+
+	manager := addons.NewManager(eventRecorder(record.ClusterID))
+	result, err := manager.Install(ctx, host, record.Name, opts)
+	if err != nil {
+		return failAddon(conn, record, "Failed to install addon "+record.Name, err)
+	}
+
+	if record.Name == "ingress-nginx" {
+		conn.Model(&db.Cluster{ID: record.ClusterID}).Select("ingress_endpoints").Updates(&db.Cluster{IngressEndpoints: result.Endpoints})
+	}
+
+	now := time.Now()
+	conn.Model(&db.Addon{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status":       "installed",
+		"version":      result.Version,
+		"error":        "",
+		"installed_at": &now,
+	})
+	return nil
+}
+
+// uninstallAddon removes an addon and deletes its record
+func uninstallAddon(conn *gorm.DB, ctx context.Context, record db.Addon) error {
+	host, err := controlPlaneHost(conn, record.ClusterID)
+	if err != nil {
+		return failAddon(conn, record, "Failed to find control plane", err)
+	}
+
+	config, err := openAddonConfig(record)
+	if err != nil {
+		return failAddon(conn, record, "Failed to read addon credentials", err)
+	}
+
+	kubeconfig, err := clusterKubeconfig(conn, record.ClusterID)
+	if err != nil {
+		return failAddon(conn, record, "Failed to load cluster kubeconfig", err)
+	}
+
+	manager := addons.NewManager(eventRecorder(record.ClusterID))
+	opts := addons.InstallOptions{Version: record.Version, Config: config, Kubeconfig: kubeconfig}
+	if err := manager.Uninstall(ctx, host, record.Name, opts); err != nil {
+		return failAddon(conn, record, "Failed to uninstall addon "+record.Name, err)
+	}
+
+	if record.Name == "ingress-nginx" {
+		conn.Model(&db.Cluster{ID: record.ClusterID}).Select("ingress_endpoints").Updates(&db.Cluster{})
+	}
+	return conn.Delete(&db.Addon{}, record.ID).Error
+}
+
+// failAddon marks an addon as failed, records an error event and returns err
+func failAddon(conn *gorm.DB, record db.Addon, message string, err error) error {
+	recordEvent(record.ClusterID, "error", "localhost", "addon", message+": "+err.Error())
+	conn.Model(&db.Addon{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status": "failed",
+		"error":  err.Error(),
+	})
+	return err
+}
+
+// sealAddonConfig strips credentials from an addon config and encrypts them
+func sealAddonConfig(config json.RawMessage) (string, []byte, error) {
+	public, credentials, err := addons.SplitCredentials(config)
+	if err != nil {
+		return "", nil, err
+	}
+	if credentials == nil {
+		return string(public), nil, nil
+	}
+
+	sealed, err := secrets.Encrypt(credentials)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot store addon credentials: %w", err)
+	}
+	return string(public), sealed, nil
+}
+
+// openAddonConfig returns the addon config with its decrypted credentials
+func openAddonConfig(record db.Addon) (json.RawMessage, error) {
+	if len(record.Credentials) == 0 {
+		return json.RawMessage(record.Config), nil
+	}
+
+	credentials, err := secrets.Decrypt(record.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return addons.MergeCredentials(json.RawMessage(record.Config), credentials)
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/lock"
+	"kubeforge/internal/notify"
+	"kubeforge/internal/provision"
+)
+
+// Alert rules
+const (
+	RuleAPIServerUnreachable = "api-server-unreachable"
+	RuleNodeNotReady         = "node-not-ready"
+	RuleCertificateExpiry    = "certificate-expiry"
+	RuleBackupMissing        = "backup-missing"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// alertsLockName guards evaluation so only one replica fires each alert
+const alertsLockName = "alerts:evaluate"
+
+// alertClusterTimeout bounds evaluating the rules of one cluster
+const alertClusterTimeout = 30 * time.Second
+
+// AlertRules are the thresholds of the alert rules. A threshold of zero
+// disables its rule.
+type AlertRules struct {
+	NodeNotReady      time.Duration // a node NotReady for longer fires
+	CertificateExpiry time.Duration // a certificate expiring sooner fires
+	BackupMissing     time.Duration // no completed Velero backup for longer fires
+}
+
+// alertCondition is a degraded condition found by a rule
+type alertCondition struct {
+	rule    string
+	subject string
+	message string
+}
+
+// AlertEvaluator evaluates the alert rules on ready clusters. A condition
+// found for the first time fires an alert, told to the notification
+// channels of the cluster; the alert is resolved once the condition clears.
+type AlertEvaluator struct {
+	store      *db.Store
+	instanceID string
+	interval   time.Duration
+
+	mu    sync.Mutex
+	rules AlertRules
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAlertEvaluator creates an evaluator. An interval of zero disables it.
+func NewAlertEvaluator(store *db.Store, interval time.Duration, rules AlertRules, instanceID string) *AlertEvaluator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AlertEvaluator{
+		store:      store,
+		instanceID: instanceID,
+		interval:   interval,
+		rules:      rules,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start evaluates the rules in the background every interval
+func (e *AlertEvaluator) Start() {
+	if e.interval <= 0 {
+		return
+	}
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop waits for a running evaluation to finish
+func (e *AlertEvaluator) Stop() {
+	e.cancel()
+	e.wg.Wait()
+}
+
+// SetRules replaces the thresholds, taking effect from the next evaluation
+func (e *AlertEvaluator) SetRules(rules AlertRules) {
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+}
+
+// Rules returns the current thresholds
+func (e *AlertEvaluator) Rules() AlertRules {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rules
+}
+
+func (e *AlertEvaluator) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := lock.Acquire(e.store.DB(), alertsLockName, e.instanceID, e.interval)
+		if err != nil {
+			slog.Error("Failed to acquire alerts lock", "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		e.Evaluate(e.ctx)
+	}
+}
+
+// Evaluate runs the rules on every ready cluster once
+func (e *AlertEvaluator) Evaluate(ctx context.Context) {
+	var clusters []db.Cluster
+	err := e.store.DB().Select("id", "name", "kubeconfig", "notifications").
+		Where("status = ? AND kubeconfig IS NOT NULL", db.ClusterReady).Find(&clusters).Error
+	if err != nil {
+		slog.Error("Failed to list clusters for alerts", "error", err)
+		return
+	}
+	rules := e.Rules()
+	for _, cluster := range clusters {
+		if ctx.Err() != nil {
+			return
+		}
+		conditions, evaluated := evaluateCluster(e.store.DB(), ctx, cluster, rules)
+		if err := syncAlerts(e.store.DB(), cluster, conditions, evaluated); err != nil {
+			slog.Error("Failed to update alerts", "cluster_id", cluster.ID, "error", err)
+		}
+	}
+}
+
+// evaluateCluster runs the rules on a cluster. It returns the conditions
+// found and the rules that could be evaluated; alerts of the other rules
+// are left as they are.
+func evaluateCluster(conn *gorm.DB, ctx context.Context, cluster db.Cluster, rules AlertRules) ([]alertCondition, map[string]bool) {
+	ctx, cancel := context.WithTimeout(ctx, alertClusterTimeout)
+	defer cancel()
+	evaluated := map[string]bool{RuleAPIServerUnreachable: true}
+
+	api, err := clusterAPIFor(cluster.ID, cluster.Kubeconfig)
+	if err != nil {
+		return []alertCondition{{rule: RuleAPIServerUnreachable, message: "Invalid kubeconfig: " + err.Error()}}, evaluated
+	}
+	var nodes struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Status   struct {
+				Conditions []struct {
+					Type               string    `json:"type"`
+					Status             string    `json:"status"`
+					Reason             string    `json:"reason"`
+					LastTransitionTime time.Time `json:"lastTransitionTime"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := api.get(ctx, "/api/v1/nodes", &nodes); err != nil {
+		return []alertCondition{{rule: RuleAPIServerUnreachable, message: "API server unreachable: " + err.Error()}}, evaluated
+	}
+
+	var conditions []alertCondition
+	evaluated[RuleNodeNotReady] = true
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type != "Ready" || condition.Status == "True" || rules.NodeNotReady <= 0 {
+				continue
+			}
+			if since := time.Since(condition.LastTransitionTime); since > rules.NodeNotReady {
+				conditions = append(conditions, alertCondition{
+					rule:    RuleNodeNotReady,
+					subject: node.Metadata.Name,
+					message: fmt.Sprintf("Node %s NotReady for %s (%s)", node.Metadata.Name, since.Round(time.Minute), condition.Reason),
+				})
+			}
+		}
+	}
+
+	if rules.CertificateExpiry > 0 {
+		certificates := map[string]func() (*x509.Certificate, error){
+			"apiserver": func() (*x509.Certificate, error) { return api.serverCertificate(ctx) },
+			"admin":     func() (*x509.Certificate, error) { return provision.KubeconfigClientCertificate(cluster.Kubeconfig) },
+		}
+		evaluated[RuleCertificateExpiry] = true
+		for name, load := range certificates {
+			certificate, err := load()
+			if err != nil {
+				// Keep the alert state of the certificate as it is
+				delete(evaluated, RuleCertificateExpiry)
+				continue
+			}
+			if certificate == nil {
+				continue
+			}
+			if left := time.Until(certificate.NotAfter); left < rules.CertificateExpiry {
+				conditions = append(conditions, alertCondition{
+					rule:    RuleCertificateExpiry,
+					subject: name,
+					message: fmt.Sprintf("The %s certificate expires on %s", name, certificate.NotAfter.UTC().Format(time.RFC3339)),
+				})
+			}
+		}
+	} else {
+		evaluated[RuleCertificateExpiry] = true
+	}
+
+	if condition, ok := evaluateBackups(conn, ctx, api, cluster.ID, rules.BackupMissing); ok {
+		evaluated[RuleBackupMissing] = true
+		if condition != nil {
+			conditions = append(conditions, *condition)
+		}
+	}
+	return conditions, evaluated
+}
+
+// evaluateBackups checks that a cluster with the velero addon completed a
+// backup recently. ok is false when the backups could not be read.
+func evaluateBackups(conn *gorm.DB, ctx context.Context, api *clusterAPI, clusterID uint, missing time.Duration) (*alertCondition, bool) {
+	var installed int64
+	conn.Model(&db.Addon{}).Where("cluster_id = ? AND name = ? AND status = ?", clusterID, "velero", "installed").Count(&installed)
+	if missing <= 0 || installed == 0 {
+		return nil, true
+	}
+
+	var backups struct {
+		Items []struct {
+			Status struct {
+				Phase               string     `json:"phase"`
+				CompletionTimestamp *time.Time `json:"completionTimestamp"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := api.get(ctx, "/apis/velero.io/v1/namespaces/velero/backups", &backups); err != nil {
+		return nil, false
+	}
+	var last time.Time
+	for _, backup := range backups.Items {
+		if backup.Status.Phase == "Completed" && backup.Status.CompletionTimestamp != nil && backup.Status.CompletionTimestamp.After(last) {
+			last = *backup.Status.CompletionTimestamp
+		}
+	}
+	if time.Since(last) <= missing {
+		return nil, true
+	}
+	message := "No completed Velero backup"
+	if !last.IsZero() {
+		message = fmt.Sprintf("No completed Velero backup since %s", last.UTC().Format(time.RFC3339))
+	}
+	return &alertCondition{rule: RuleBackupMissing, message: message}, true
+}
+
+// syncAlerts fires alerts for new conditions and resolves the alerts of
+// evaluated rules whose condition cleared
+func syncAlerts(conn *gorm.DB, cluster db.Cluster, conditions []alertCondition, evaluated map[string]bool) error {
+	var firing []db.Alert
+	if err := conn.Where("cluster_id = ? AND state = ?", cluster.ID, AlertFiring).Find(&firing).Error; err != nil {
+		return err
+	}
+	open := make(map[string]db.Alert, len(firing))
+	for _, alert := range firing {
+		open[alert.Rule+"/"+alert.Subject] = alert
+	}
+
+	now := time.Now()
+	for _, condition := range conditions {
+		key := condition.rule + "/" + condition.subject
+		if alert, ok := open[key]; ok {
+			delete(open, key)
+			if alert.Message != condition.message {
+				conn.Model(&alert).Updates(map[string]interface{}{"message": condition.message, "updated_at": now})
+			}
+			continue
+		}
+		alert := db.Alert{
+			ClusterID: cluster.ID,
+			Rule:      condition.rule,
+			Subject:   condition.subject,
+			State:     AlertFiring,
+			Message:   condition.message,
+			FiredAt:   now,
+			UpdatedAt: now,
+		}
+		if err := conn.Create(&alert).Error; err != nil {
+			return err
+		}
+		recordEvent(cluster.ID, "warn", "localhost", "alert", "Alert "+condition.rule+" firing: "+condition.message)
+		notifyAlert(conn, cluster, alert)
+	}
+
+	for _, alert := range open {
+		if !evaluated[alert.Rule] {
+			continue
+		}
+		alert.State, alert.ResolvedAt, alert.UpdatedAt = AlertResolved, &now, now
+		if err := conn.Save(&alert).Error; err != nil {
+			return err
+		}
+		recordEvent(cluster.ID, "info", "localhost", "alert", "Alert "+alert.Rule+" resolved: "+alert.Message)
+		notifyAlert(conn, cluster, alert)
+	}
+	return nil
+}
+
+// notifyAlert tells the cluster's channels that an alert fired or resolved
+func notifyAlert(conn *gorm.DB, cluster db.Cluster, alert db.Alert) {
+	operation := "alert " + alert.Rule
+	if alert.Subject != "" {
+		operation += " " + alert.Subject
+	}
+	event := notify.Event{
+		ClusterID: cluster.ID,
+		Cluster:   cluster.Name,
+		Operation: operation,
+		Status:    alert.State,
+		Time:      alert.UpdatedAt,
+	}
+	if alert.State == AlertFiring {
+		event.Error = alert.Message
+	}
+	notify.Dispatch(conn, event, cluster.Notifications)
+}
+
+// AlertHandler exposes the alerts of clusters
+type AlertHandler struct {
+	store *db.Store
+}
+
+// NewAlertHandler creates a new alert handler
+func NewAlertHandler(store *db.Store) *AlertHandler {
+	return &AlertHandler{store: store}
+}
+
+// RegisterRoutes registers alert API routes
+func (h *AlertHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/alerts", h.ListAlerts).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/alerts", h.ListClusterAlerts).Methods("GET")
+}
+
+// ListAlerts lists the alerts of the clusters in the caller's projects,
+// newest first, filtered by ?state=
+func (h *AlertHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	h.listAlerts(w, r, 0)
+}
+
+// ListClusterAlerts lists the alerts of a cluster, newest first, filtered
+// by ?state=
+func (h *AlertHandler) ListClusterAlerts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	h.listAlerts(w, r, uint(id))
+}
+
+// listAlerts writes alerts matching the query filters, scoped to a cluster
+// when clusterID is set
+func (h *AlertHandler) listAlerts(w http.ResponseWriter, r *http.Request, clusterID uint) {
+	query := h.store.DB().Order("id desc").Limit(1000)
+	if clusterID != 0 {
+		query = query.Where("cluster_id = ?", clusterID)
+	} else if _, all := memberProjects(h.store.DB(), r); !all {
+		clusters := scopeProjects(h.store.DB(), r, h.store.DB().Unscoped().Model(&db.Cluster{}).Select("id"), "project_id")
+		query = query.Where("cluster_id IN (?)", clusters)
+	}
+	if state := r.URL.Query().Get("state"); state != "" {
+		if state != AlertFiring && state != AlertResolved {
+			WriteBadRequest(w, "Invalid state, expected firing or resolved")
+			return
+		}
+		query = query.Where("state = ?", state)
+	}
+
+	var alerts []db.Alert
+	if err := query.Find(&alerts).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve alerts")
+		return
+	}
+	WriteSuccess(w, alerts)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/audit"
+	"kubeforge/internal/db"
+)
+
+// Audit middleware records every mutating API call in the audit log. It must
+// run after Authenticate so the caller is known, and before Authorize so
+// denied calls are recorded too.
+func Audit(store *db.Store) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// GraphQL queries are posted but change nothing
+			if !strings.HasPrefix(r.URL.Path, "/api/") || !isMutating(r.Method) || r.URL.Path == "/api/v1/graphql" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				WriteBadRequest(w, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// YAML bodies are recorded as JSON, so their secrets are redacted
+			payload := body
+			if isYAML(r.Header.Get("Content-Type")) {
+				if converted, err := yamlToJSON(body); err == nil {
+					payload = converted
+				}
+			}
+
+			entry := &db.AuditLog{
+				Timestamp:  time.Now(),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Payload:    audit.Summarize(payload),
+				RemoteAddr: r.RemoteAddr,
+			}
+			if claims := CurrentUser(r); claims != nil {
+				entry.UserID = claims.UserID()
+				entry.Username = claims.Username
+			}
+			if strings.HasPrefix(r.URL.Path, "/api/v1/clusters/") {
+				if id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32); err == nil {
+					entry.ClusterID = uint(id)
+				}
+			}
+
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(wrapped, r)
+
+			entry.Status = wrapped.statusCode
+			audit.Record(store.DB(), entry)
+		})
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// AuditHandler handles audit log API requests
+type AuditHandler struct {
+	store *db.Store
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(store *db.Store) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// RegisterRoutes registers audit API routes
+func (h *AuditHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/audit", h.ListAuditLogs).Methods("GET")
+}
+
+// ListAuditLogs lists audit entries, newest first. Entries can be filtered by
+// user (name or ID), cluster_id, method and a since/until time range in
+// RFC 3339 format.
+func (h *AuditHandler) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := h.store.DB().Order("id desc")
+
+	if user := params.Get("user"); user != "" {
+		if id, err := strconv.ParseUint(user, 10, 32); err == nil {
+			query = query.Where("user_id = ?", id)
+		} else {
+			query = query.Where("username = ?", user)
+		}
+	}
+	if value := params.Get("cluster_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			WriteBadRequest(w, "Invalid cluster ID")
+			return
+		}
+		query = query.Where("cluster_id = ?", id)
+	}
+	if method := params.Get("method"); method != "" {
+		query = query.Where("method = ?", strings.ToUpper(method))
+	}
+	if value := params.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			WriteBadRequest(w, "Invalid since time, expected RFC 3339")
+			return
+		}
+		query = query.Where("timestamp >= ?", since)
+	}
+	if value := params.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			WriteBadRequest(w, "Invalid until time, expected RFC 3339")
+			return
+		}
+		query = query.Where("timestamp <= ?", until)
+	}
+
+	limit := 100
+	if value := params.Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+
+	var entries []db.AuditLog
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve audit log")
+		return
+	}
+
+	WriteSuccess(w, entries)
+}
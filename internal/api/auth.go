@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+)
+
+// LoginRequest represents the request to log in
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest represents the request to refresh or revoke tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AuthHandler handles authentication API requests
+type AuthHandler struct {
+	store  *db.Store
+	tokens *auth.TokenManager
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(store *db.Store, tokens *auth.TokenManager) *AuthHandler {
+	return &AuthHandler{store: store, tokens: tokens}
+}
+
+// RegisterRoutes registers auth API routes
+func (h *AuthHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/auth/login", h.Login).Methods("POST")
+	router.HandleFunc("/api/v1/auth/refresh", h.Refresh).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout", h.Logout).Methods("POST")
+	router.HandleFunc("/api/v1/auth/me", h.Me).Methods("GET")
+}
+
+// Login verifies credentials and issues an access and refresh token, with
+// the cookie the UI is served with
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		WriteBadRequest(w, "Username and password are required")
+		return
+	}
+
+	pair, _, err := h.tokens.Login(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", err.Error())
+			return
+		}
+		WriteInternalError(w, "Failed to log in")
+		return
+	}
+
+	setUICookie(w, r, pair)
+	WriteSuccess(w, pair)
+}
+
+// Refresh exchanges a refresh token for a new token pair
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := ParseJSON(r, &req); err != nil || req.RefreshToken == "" {
+		WriteBadRequest(w, "Refresh token is required")
+		return
+	}
+
+	pair, err := h.tokens.Refresh(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", err.Error())
+			return
+		}
+		WriteInternalError(w, "Failed to refresh token")
+		return
+	}
+
+	setUICookie(w, r, pair)
+	WriteSuccess(w, pair)
+}
+
+// Logout revokes a refresh token
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := ParseJSON(r, &req); err != nil || req.RefreshToken == "" {
+		WriteBadRequest(w, "Refresh token is required")
+		return
+	}
+
+	if err := h.tokens.Revoke(req.RefreshToken); err != nil {
+		WriteInternalError(w, "Failed to revoke token")
+		return
+	}
+
+	clearUICookie(w)
+	WriteSuccess(w, map[string]string{"message": "Logged out"})
+}
+
+// Me returns the authenticated user
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	claims := CurrentUser(r)
+	if claims == nil {
+		WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Authentication required")
+		return
+	}
+
+	var user db.User
+	if err := h.store.DB().First(&user, claims.UserID()).Error; err != nil {
+		WriteNotFound(w, "User not found")
+		return
+	}
+
+	WriteSuccess(w, user)
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// publicPaths can be called without an access token
+var publicPaths = map[string]bool{
+	"/api/v1/auth/login":   true,
+	"/api/v1/auth/refresh": true,
+	// Hosts joining with cloud-init present a registration token instead
+	"/api/v1/nodes/register": true,
+}
+
+// streamPaths are /api routes that browsers open as WebSocket or EventSource
+// streams
+var streamPaths = map[string]bool{
+	"/api/v1/events/stream": true,
+	"/api/v1/graphql":       true,
+}
+
+// Authenticate middleware requires a valid access token on /api and /ws
+// routes. Tokens are read from the Authorization header, or from the token
+// query parameter for WebSocket and event stream connections which cannot
+// set headers.
+func Authenticate(tokens *auth.TokenManager) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			isWebSocket := strings.HasPrefix(path, "/ws/")
+			if (!strings.HasPrefix(path, "/api/") && !isWebSocket) || publicPaths[path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == r.Header.Get("Authorization") {
+				token = ""
+			}
+			if token == "" && (isWebSocket || streamPaths[path]) {
+				token = r.URL.Query().Get("token")
+			}
+			if token == "" {
+				WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Authentication required")
+				return
+			}
+
+			claims, err := tokens.Verify(token)
+			if err != nil {
+				WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", err.Error())
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CurrentUser returns the claims of the authenticated user, or nil
+func CurrentUser(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(userContextKey).(*auth.Claims)
+	return claims
+}
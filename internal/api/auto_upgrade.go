@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/lock"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// Automatic upgrade policies of a cluster
+const (
+	autoUpgradeNone  = "none"
+	autoUpgradePatch = "patch"
+)
+
+// autoUpgradeCheckInterval is how often clusters are checked for patch
+// releases to upgrade to
+const autoUpgradeCheckInterval = 5 * time.Minute
+
+// autoUpgradeLockName guards the check so only one replica schedules each
+// upgrade
+const autoUpgradeLockName = "clusters:auto-upgrade"
+
+// maxMaintenanceWindow is the longest maintenance window
+const maxMaintenanceWindow = 24 * time.Hour
+
+// weekdays are the day names of maintenance windows
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// validateAutoUpgrade checks an automatic upgrade policy and the maintenance
+// window it needs
+func validateAutoUpgrade(policy string, window *db.MaintenanceWindow) validation.Errors {
+	var errs validation.Errors
+	if policy != "" && policy != autoUpgradeNone && policy != autoUpgradePatch {
+		errs.Add("auto_upgrade", validation.CodeUnsupported, fmt.Sprintf("unsupported automatic upgrade policy %q, expected patch or none", policy))
+	}
+	if window == nil {
+		if policy == autoUpgradePatch {
+			errs.Add("maintenance_window", validation.CodeRequired, "automatic upgrades need a maintenance window")
+		}
+		return errs
+	}
+
+	for i, day := range window.Days {
+		if _, ok := weekdays[day]; !ok {
+			errs.Add(validation.Index(validation.Path("maintenance_window", "days"), i), validation.CodeInvalid, fmt.Sprintf("invalid day %q, expected mon to sun", day))
+		}
+	}
+	if _, err := time.Parse("15:04", window.Start); err != nil {
+		errs.Add(validation.Path("maintenance_window", "start"), validation.CodeInvalid, "start must be a time of day such as 02:00")
+	}
+	if duration, err := time.ParseDuration(window.Duration); err != nil {
+		errs.Add(validation.Path("maintenance_window", "duration"), validation.CodeInvalid, "duration must be a duration such as 4h")
+	} else if duration <= 0 || duration > maxMaintenanceWindow {
+		errs.Add(validation.Path("maintenance_window", "duration"), validation.CodeOutOfRange, "duration must be positive and at most 24h")
+	}
+	if _, err := time.LoadLocation(window.Timezone); err != nil {
+		errs.Add(validation.Path("maintenance_window", "timezone"), validation.CodeInvalid, fmt.Sprintf("unknown time zone %q", window.Timezone))
+	}
+	return errs
+}
+
+// windowOpen reports whether a maintenance window is open at now. A window
+// opening late in the day stays open past midnight.
+func windowOpen(window *db.MaintenanceWindow, now time.Time) bool {
+	location, err := time.LoadLocation(window.Timezone)
+	if err != nil {
+		return false
+	}
+	start, err := time.Parse("15:04", window.Start)
+	if err != nil {
+		return false
+	}
+	duration, err := time.ParseDuration(window.Duration)
+	if err != nil {
+		return false
+	}
+
+	now = now.In(location)
+	for _, daysAgo := range []int{0, 1} {
+		day := now.AddDate(0, 0, -daysAgo)
+		opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, location)
+		if !opensOn(window.Days, opens.Weekday()) {
+			continue
+		}
+		if !now.Before(opens) && now.Before(opens.Add(duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// opensOn reports whether a window with days opens on a weekday
+func opensOn(days []string, weekday time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, day := range days {
+		if weekdays[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// AutoUpgrader starts upgrade jobs to the latest patch release of their
+// minor release for ready clusters with the patch policy, when their
+// maintenance window is open. A cluster whose upgrade failed is not ready
+// and is left alone until it is.
+type AutoUpgrader struct {
+	store      *db.Store
+	queue      *jobs.Queue
+	instanceID string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAutoUpgrader creates an upgrader
+func NewAutoUpgrader(store *db.Store, queue *jobs.Queue, instanceID string) *AutoUpgrader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AutoUpgrader{
+		store:      store,
+		queue:      queue,
+		instanceID: instanceID,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start checks clusters in the background every few minutes
+func (u *AutoUpgrader) Start() {
+	u.wg.Add(1)
+	go u.run()
+}
+
+// Stop waits for a running check to finish
+func (u *AutoUpgrader) Stop() {
+	u.cancel()
+	u.wg.Wait()
+}
+
+func (u *AutoUpgrader) run() {
+	defer u.wg.Done()
+
+	ticker := time.NewTicker(autoUpgradeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := lock.Acquire(u.store.DB(), autoUpgradeLockName, u.instanceID, autoUpgradeCheckInterval)
+		if err != nil {
+			slog.Error("Failed to acquire auto-upgrade lock", "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		if _, err := u.Schedule(time.Now()); err != nil {
+			slog.Error("Failed to schedule automatic upgrades", "error", err)
+		}
+	}
+}
+
+// Schedule starts the upgrades due at now and returns how many
+func (u *AutoUpgrader) Schedule(now time.Time) (int, error) {
+	var clusters []db.Cluster
+	if err := u.store.DB().Where("auto_upgrade = ? AND status = ?", autoUpgradePatch, db.ClusterReady).Find(&clusters).Error; err != nil {
+		return 0, err
+	}
+
+	policy, _, err := loadClusterPolicy(u.store.DB())
+	if err != nil {
+		return 0, err
+	}
+
+	scheduled := 0
+	for _, cluster := range clusters {
+		if cluster.MaintenanceWindow == nil || !windowOpen(cluster.MaintenanceWindow, now) {
+			continue
+		}
+		latest, ok := provision.LatestPatch(cluster.K8sVersion)
+		if !ok || latest == cluster.K8sVersion || !policy.allowsVersion(latest) {
+			continue
+		}
+		if err := provision.ValidateUpgrade(cluster.K8sVersion, latest); err != nil {
+			continue
+		}
+
+		payload, _ := json.Marshal(upgradePayload{K8sVersion: latest, Automatic: true})
+		job := db.Job{ClusterID: cluster.ID, Type: "upgrade", Payload: string(payload)}
+		if err := u.queue.Enqueue(&job); err != nil {
+			if !errors.Is(err, jobs.ErrDuplicateJob) {
+				slog.Error("Failed to queue automatic upgrade", "cluster_id", cluster.ID, "error", err)
+			}
+			continue
+		}
+		recordEvent(cluster.ID, "info", "localhost", "auto-upgrade",
+			fmt.Sprintf("Automatic upgrade from %s to %s started in the maintenance window (job %d)", cluster.K8sVersion, latest, job.ID))
+		scheduled++
+	}
+	return scheduled, nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+)
+
+// BackupHandler exposes Velero schedules and backups of a cluster
+type BackupHandler struct {
+	store *db.Store
+}
+
+// NewBackupHandler creates a new backup handler
+func NewBackupHandler(store *db.Store) *BackupHandler {
+	return &BackupHandler{store: store}
+}
+
+// RegisterRoutes registers backup API routes
+func (h *BackupHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/clusters/{id}/backups", h.ListBackups).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/backups/schedules", h.ListSchedules).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/backups/schedules", h.ApplySchedule).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/backups/schedules/{name}", h.DeleteSchedule).Methods("DELETE")
+}
+
+// ListBackups returns the Velero Backup resources of a cluster
+func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	h.listResources(w, r, "backups")
+}
+
+// ListSchedules returns the Velero Schedule resources of a cluster
+func (h *BackupHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	h.listResources(w, r, "schedules")
+}
+
+// ApplySchedule creates or updates a Velero Schedule
+func (h *BackupHandler) ApplySchedule(w http.ResponseWriter, r *http.Request) {
+	clusterID, ok := h.veleroCluster(w, r)
+	if !ok {
+		return
+	}
+
+	var schedule addons.BackupSchedule
+	if err := ParseJSON(r, &schedule); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if err := schedule.Validate(); err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	err := withKubectl(h.store.DB(), r.Context(), clusterID, func(ctx context.Context, kubectl *addons.Kubectl) error {
+		return addons.ApplySchedule(ctx, kubectl, schedule)
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to apply schedule: "+err.Error())
+		return
+	}
+
+	WriteCreated(w, schedule)
+}
+
+// DeleteSchedule deletes a Velero Schedule
+func (h *BackupHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	clusterID, ok := h.veleroCluster(w, r)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	err := withKubectl(h.store.DB(), r.Context(), clusterID, func(ctx context.Context, kubectl *addons.Kubectl) error {
+		return addons.DeleteSchedule(ctx, kubectl, name)
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to delete schedule: "+err.Error())
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Schedule deleted"})
+}
+
+// listResources writes the raw Velero resources of the given kind
+func (h *BackupHandler) listResources(w http.ResponseWriter, r *http.Request, kind string) {
+	clusterID, ok := h.veleroCluster(w, r)
+	if !ok {
+		return
+	}
+
+	var items []json.RawMessage
+	err := withKubectl(h.store.DB(), r.Context(), clusterID, func(ctx context.Context, kubectl *addons.Kubectl) error {
+		var err error
+		items, err = addons.ListVeleroResources(ctx, kubectl, kind)
+		return err
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve "+kind+": "+err.Error())
+		return
+	}
+
+	WriteSuccess(w, items)
+}
+
+// veleroCluster resolves a ready cluster that has the velero addon installed
+func (h *BackupHandler) veleroCluster(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return 0, false
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return 0, false
+	}
+
+	var addon db.Addon
+	if err := h.store.DB().Where("cluster_id = ? AND name = ? AND status = ?", cluster.ID, "velero", "installed").First(&addon).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "The velero addon is not installed")
+		return 0, false
+	}
+	return cluster.ID, true
+}
+
+// withKubectl connects to the cluster's control plane and runs fn with kubectl
+func withKubectl(conn *gorm.DB, ctx context.Context, clusterID uint, fn func(ctx context.Context, kubectl *addons.Kubectl) error) error {
+	host, err := controlPlaneHost(conn, clusterID)
+	if err != nil {
+		return err
+	}
+
+	kubectl, err := addons.NewKubectl(host, eventRecorder(clusterID))
+	if err != nil {
+		return err
+	}
+	defer kubectl.Close()
+
+	return fn(ctx, kubectl)
+}
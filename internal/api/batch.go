@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// Operations of a batch
+const (
+	batchUpgrade    = "upgrade"
+	batchRenewCerts = "renew-certs"
+)
+
+// batchOperations lists the operations a batch can run
+var batchOperations = []string{batchUpgrade, batchRenewCerts}
+
+// Child jobs of a batch running at once, by default and at most
+const (
+	defaultBatchParallel = 5
+	maxBatchParallel     = 50
+)
+
+// batchPollInterval is how often a batch job checks on its child jobs
+const batchPollInterval = 2 * time.Second
+
+// BatchSelector picks the clusters an operation runs on. Clusters must
+// match every given criterion.
+type BatchSelector struct {
+	All        bool              `json:"all,omitempty"`
+	ClusterIDs []uint            `json:"cluster_ids,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// BatchOperation is an operation run on each cluster of a selector
+type BatchOperation struct {
+	Type       string        `json:"type"` // upgrade, renew-certs
+	Selector   BatchSelector `json:"selector"`
+	K8sVersion string        `json:"k8s_version,omitempty"` // upgrade only, default: the latest patch release of each cluster
+}
+
+// BatchRequest is the request body of a batch of operations
+type BatchRequest struct {
+	Operations  []BatchOperation `json:"operations"`
+	MaxParallel int              `json:"max_parallel,omitempty"` // child jobs running at once, default 5
+}
+
+// batchItem is an operation on one cluster, run as a child job of the batch
+type batchItem struct {
+	ClusterID uint   `json:"cluster_id"`
+	Cluster   string `json:"cluster"`
+	Operation string `json:"operation"`
+	Payload   string `json:"payload,omitempty"` // of the child job
+	Skipped   string `json:"skipped,omitempty"` // why the cluster is left out
+}
+
+// batchPayload is the input of a batch job
+type batchPayload struct {
+	Items       []batchItem `json:"items"`
+	MaxParallel int         `json:"max_parallel"`
+}
+
+// batchCheckpoint is the resume state of a batch job, by item
+type batchCheckpoint struct {
+	Jobs   []uint   `json:"jobs"`   // child job IDs, 0 for items not started
+	Errors []string `json:"errors"` // why items failed before their job started
+}
+
+// BatchResult is the outcome of an item of a batch, recorded in the
+// metadata of the batch job
+type BatchResult struct {
+	ClusterID uint   `json:"cluster_id"`
+	Cluster   string `json:"cluster"`
+	Operation string `json:"operation"`
+	JobID     uint   `json:"job_id,omitempty"`
+	Status    string `json:"status"` // of the child job, or skipped
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchResponse is the batch job with the items it runs
+type BatchResponse struct {
+	Job   db.Job      `json:"job"`
+	Items []batchItem `json:"items"`
+}
+
+// BatchHandler handles fleet-wide batches of cluster operations
+type BatchHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
+
+// NewBatchHandler creates a new batch handler
+func NewBatchHandler(store *db.Store, queue *jobs.Queue) *BatchHandler {
+	h := &BatchHandler{store: store, queue: queue}
+	queue.RegisterHandler("batch", h.runBatchJob)
+	return h
+}
+
+// RegisterRoutes registers batch API routes
+func (h *BatchHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/batch", h.CreateBatch).Methods("POST")
+}
+
+// Validate checks a batch request
+func (req *BatchRequest) Validate() validation.Errors {
+	var errs validation.Errors
+	if len(req.Operations) == 0 {
+		errs.Add("operations", validation.CodeRequired, "at least one operation is required")
+	}
+	if req.MaxParallel < 0 || req.MaxParallel > maxBatchParallel {
+		errs.Add("max_parallel", validation.CodeOutOfRange, fmt.Sprintf("max_parallel must be between 1 and %d", maxBatchParallel))
+	}
+	for i, op := range req.Operations {
+		path := validation.Index("operations", i)
+		if !validation.OneOf(op.Type, batchOperations) {
+			errs.Add(validation.Path(path, "type"), validation.CodeUnsupported,
+				fmt.Sprintf("unsupported operation %q, expected one of %s", op.Type, strings.Join(batchOperations, ", ")))
+		}
+		if op.K8sVersion != "" {
+			if op.Type != batchUpgrade {
+				errs.Add(validation.Path(path, "k8s_version"), validation.CodeInvalid, "k8s_version applies to upgrades only")
+			} else if _, err := provision.ParseVersion(op.K8sVersion); err != nil {
+				errs.Add(validation.Path(path, "k8s_version"), validation.CodeInvalid, err.Error())
+			}
+		}
+		selector := op.Selector
+		if !selector.All && len(selector.ClusterIDs) == 0 && len(selector.Labels) == 0 {
+			errs.Add(validation.Path(path, "selector"), validation.CodeRequired, "select clusters by cluster_ids or labels, or set all")
+		}
+		errs = append(errs, validateLabels(validation.Path(validation.Path(path, "selector"), "labels"), selector.Labels)...)
+	}
+	return errs
+}
+
+// CreateBatch starts a batch job running each operation on the clusters it
+// selects, one child job per cluster. Only clusters in the caller's
+// projects are selected, and the caller needs the operator role in each.
+func (h *BatchHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if req.MaxParallel == 0 {
+		req.MaxParallel = defaultBatchParallel
+	}
+
+	policy, _, err := loadClusterPolicy(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve policy")
+		return
+	}
+	var errs validation.Errors
+	for i, op := range req.Operations {
+		if op.K8sVersion != "" && !policy.allowsVersion(op.K8sVersion) {
+			errs.Add(validation.Path(validation.Index("operations", i), "k8s_version"), validation.CodePolicy,
+				fmt.Sprintf("k8s version %s is not allowed by the cluster policy", op.K8sVersion))
+		}
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	var items []batchItem
+	for _, op := range req.Operations {
+		filter := db.ClusterFilter{ProjectIDs: projectFilter(h.store.DB(), r)}
+		if len(op.Selector.ClusterIDs) > 0 {
+			filter.IDs = op.Selector.ClusterIDs
+		}
+		clusters, err := h.store.Clusters.List(filter)
+		if err != nil {
+			WriteInternalError(w, "Failed to retrieve clusters")
+			return
+		}
+
+		for _, cluster := range clusters {
+			if !hasLabels(cluster.Labels, op.Selector.Labels) {
+				continue
+			}
+			if !auth.HasRole(projectRole(h.store.DB(), CurrentUser(r), cluster.ProjectID), auth.RoleOperator) {
+				WriteError(w, http.StatusForbidden, "FORBIDDEN",
+					fmt.Sprintf("Cluster %s needs the operator role in its project", cluster.Name))
+				return
+			}
+			items = append(items, batchOperationItem(op, cluster, policy))
+		}
+	}
+	if len(items) == 0 {
+		errs.Add("operations", validation.CodeInvalid, "no clusters match the selectors")
+		WriteValidationError(w, errs)
+		return
+	}
+
+	payload, _ := json.Marshal(batchPayload{Items: items, MaxParallel: req.MaxParallel})
+	job := db.Job{
+		Type:        "batch",
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		WriteInternalError(w, "Failed to queue batch job")
+		return
+	}
+	WriteAccepted(w, jobLocation(job.ID), BatchResponse{Job: job, Items: items})
+}
+
+// batchOperationItem plans an operation on a cluster, or the reason it is
+// skipped. Clusters that are not ready yet are checked again when their
+// turn comes.
+func batchOperationItem(op BatchOperation, cluster db.Cluster, policy *ClusterPolicy) batchItem {
+	item := batchItem{ClusterID: cluster.ID, Cluster: cluster.Name, Operation: op.Type}
+	if op.Type != batchUpgrade {
+		return item
+	}
+
+	version := strings.TrimPrefix(op.K8sVersion, "v")
+	if version == "" {
+		latest, ok := provision.LatestPatch(cluster.K8sVersion)
+		if !ok {
+			item.Skipped = fmt.Sprintf("no known releases of %s", cluster.K8sVersion)
+			return item
+		}
+		if !policy.allowsVersion(latest) {
+			item.Skipped = fmt.Sprintf("k8s version %s is not allowed by the cluster policy", latest)
+			return item
+		}
+		version = latest
+	}
+	if version == cluster.K8sVersion {
+		item.Skipped = "already runs " + version
+		return item
+	}
+	if err := provision.ValidateUpgrade(cluster.K8sVersion, version); err != nil {
+		item.Skipped = err.Error()
+		return item
+	}
+	payload, _ := json.Marshal(upgradePayload{K8sVersion: version})
+	item.Payload = string(payload)
+	return item
+}
+
+// runBatchJob starts the child jobs of a batch, at most max_parallel at a
+// time, and waits for them. Its progress is the average progress of the
+// children. A batch holds a worker while it waits, so the children run on
+// the others. Cancelling the batch cancels its running children.
+func (h *BatchHandler) runBatchJob(ctx context.Context, job *db.Job) error {
+	var payload batchPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid batch payload: %w", err)
+	}
+	items := payload.Items
+
+	var checkpoint batchCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil || len(checkpoint.Jobs) != len(items) {
+		checkpoint = batchCheckpoint{Jobs: make([]uint, len(items)), Errors: make([]string, len(items))}
+	}
+
+	next := 0
+	for {
+		children := map[uint]db.Job{}
+		records, err := h.store.Jobs.List(db.JobFilter{ParentID: job.ID})
+		if err != nil {
+			return err
+		}
+		for _, child := range records {
+			children[child.ID] = child
+		}
+
+		// Start items until max_parallel children are active
+		active := 0
+		for _, id := range checkpoint.Jobs {
+			if id != 0 && !finishedJob(children[id].Status) {
+				active++
+			}
+		}
+		for ; next < len(items) && active < payload.MaxParallel; next++ {
+			item := items[next]
+			if item.Skipped != "" || checkpoint.Jobs[next] != 0 || checkpoint.Errors[next] != "" {
+				continue
+			}
+			child, err := h.startBatchItem(job, item)
+			if err != nil {
+				checkpoint.Errors[next] = err.Error()
+				continue
+			}
+			checkpoint.Jobs[next] = child.ID
+			children[child.ID] = *child
+			active++
+		}
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			return err
+		}
+
+		// Finished and skipped items count as done
+		total := 0
+		for i, item := range items {
+			id := checkpoint.Jobs[i]
+			switch {
+			case item.Skipped != "", checkpoint.Errors[i] != "", id != 0 && finishedJob(children[id].Status):
+				total += 100
+			case id != 0:
+				total += children[id].Progress
+			}
+		}
+		h.queue.UpdateProgress(job.ID, fmt.Sprintf("%d of %d clusters running", active, len(items)), total/len(items))
+
+		if next >= len(items) && active == 0 {
+			return h.finishBatch(job, items, checkpoint, children)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(context.Cause(ctx), jobs.ErrJobCancelled) {
+				for _, id := range checkpoint.Jobs {
+					if id != 0 && !finishedJob(children[id].Status) {
+						h.queue.Cancel(id)
+					}
+				}
+			}
+			return ctx.Err()
+		case <-time.After(batchPollInterval):
+		}
+	}
+}
+
+// startBatchItem queues the child job of an item. The cluster must be
+// ready by then.
+func (h *BatchHandler) startBatchItem(parent *db.Job, item batchItem) (*db.Job, error) {
+	cluster, err := h.store.Clusters.Get(item.ClusterID)
+	if err != nil {
+		return nil, errors.New("cluster not found")
+	}
+	if cluster.Status != db.ClusterReady {
+		return nil, fmt.Errorf("cluster is %s, not ready", cluster.Status)
+	}
+
+	child := db.Job{
+		ClusterID:   item.ClusterID,
+		ParentID:    parent.ID,
+		Type:        item.Operation,
+		Payload:     item.Payload,
+		RequestID:   parent.RequestID,
+		TraceParent: parent.TraceParent,
+	}
+	if err := h.queue.Enqueue(&child); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			return nil, fmt.Errorf("%s job %d is already active", item.Operation, child.ID)
+		}
+		return nil, err
+	}
+	return &child, nil
+}
+
+// finishBatch records the result of each item of a batch and fails the
+// batch if any item failed or was cancelled
+func (h *BatchHandler) finishBatch(job *db.Job, items []batchItem, checkpoint batchCheckpoint, children map[uint]db.Job) error {
+	results := make([]BatchResult, len(items))
+	failed := 0
+	for i, item := range items {
+		result := BatchResult{ClusterID: item.ClusterID, Cluster: item.Cluster, Operation: item.Operation, JobID: checkpoint.Jobs[i]}
+		switch {
+		case item.Skipped != "":
+			result.Status, result.Error = "skipped", item.Skipped
+		case checkpoint.Errors[i] != "":
+			result.Status, result.Error = jobs.StatusFailed, checkpoint.Errors[i]
+		default:
+			child := children[result.JobID]
+			result.Status, result.Error = child.Status, child.Error
+		}
+		if result.Status == jobs.StatusFailed || result.Status == jobs.StatusCancelled {
+			failed++
+		}
+		results[i] = result
+	}
+	if err := h.queue.SaveMetadata(job.ID, results); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d clusters failed", failed, len(items))
+	}
+	return nil
+}
+
+// finishedJob reports whether a job status is final
+func finishedJob(status string) bool {
+	return status == jobs.StatusCompleted || status == jobs.StatusFailed || status == jobs.StatusCancelled
+}
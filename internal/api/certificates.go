@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+)
+
+// renewCertsTimeout bounds the renewal on one control plane, including the
+// restart of its components
+const renewCertsTimeout = provision.Duration(10 * time.Minute)
+
+// renewCertsCheckpoint is the resume state of a renew-certs job
+type renewCertsCheckpoint struct {
+	Renewed []string `json:"renewed,omitempty"` // addresses of the control planes done
+}
+
+// RenewCertificates starts a job renewing the control plane certificates
+// of a ready cluster, one control plane at a time
+func (h *ClusterHandler) RenewCertificates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "renew-certs",
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "Certificates are already being renewed")
+			return
+		}
+		WriteInternalError(w, "Failed to queue renew-certs job")
+		return
+	}
+	WriteAccepted(w, jobLocation(job.ID), job)
+}
+
+// runRenewCertsJob renews the certificates of each control plane in turn,
+// so the API stays available through the others, and stores the renewed
+// admin kubeconfig of the first one
+func (h *ClusterHandler) runRenewCertsJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var checkpoint renewCertsCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(clusterID, "Invalid renew-certs checkpoint, starting over", err)
+		checkpoint = renewCertsCheckpoint{}
+	}
+
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		h.reportError(clusterID, "Failed to get provisioner", err)
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+
+	nodes, err := h.store.Nodes.ListByRole(clusterID, "control-plane")
+	if err != nil {
+		h.reportError(clusterID, "Failed to load control planes", err)
+		return err
+	}
+	if len(nodes) == 0 {
+		err := errors.New("cluster has no control planes")
+		h.reportError(clusterID, "Failed to renew certificates", err)
+		return err
+	}
+
+	skipHostKeyCheck := skipsHostKeyCheck(h.store.DB(), clusterID)
+	progress := &provisionProgress{job: job, total: len(nodes)}
+	h.logEvent(clusterID, "info", "localhost", "renew-certs", fmt.Sprintf("Renewing certificates on %d control planes", len(nodes)))
+	for i, node := range nodes {
+		if containsString(checkpoint.Renewed, node.Address) {
+			progress.advance("renew-certs", 1)
+			continue
+		}
+		host := nodeHostSpec(node, skipHostKeyCheck)
+		var kubeconfig []byte
+		err := provision.RunStep(ctx, "renew certificates "+host.Address, renewCertsTimeout, func(ctx context.Context) error {
+			var err error
+			kubeconfig, err = provisioner.RenewCertificates(ctx, host)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.logEvent(clusterID, "error", host.Address, "renew-certs", "Failed to renew certificates: "+err.Error())
+			return err
+		}
+		if i == 0 {
+			h.store.Clusters.Update(&db.Cluster{ID: clusterID, Kubeconfig: kubeconfig}, "kubeconfig")
+		}
+		checkpoint.Renewed = append(checkpoint.Renewed, node.Address)
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save renew-certs checkpoint", err)
+		}
+		progress.advance("renew-certs", 1)
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "complete", "Control plane certificates renewed")
+	return nil
+}
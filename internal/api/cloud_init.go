@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// registrationTokenTTL is how long user-data can register hosts, the
+// lifetime of the kubeadm bootstrap token it joins with
+const registrationTokenTTL = 24 * time.Hour
+
+// cloudInitRoles are the roles user-data can join hosts as
+var cloudInitRoles = []string{"worker", "control-plane"}
+
+// GetCloudInit returns cloud-config user-data joining hosts booted by other
+// tooling to a ready cluster (?role=worker, the default, or control-plane).
+// The hosts run the same preparation scripts as hosts provisioned over SSH,
+// rendered for ?os (default ubuntu) and ?arch (default amd64), then register
+// with KubeForge. Each call creates a bootstrap token valid for 24 hours.
+func (h *ClusterHandler) GetCloudInit(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	query := r.URL.Query()
+	role := query.Get("role")
+	if role == "" {
+		role = "worker"
+	}
+	osID := query.Get("os")
+	if osID == "" {
+		osID = "ubuntu"
+	}
+	arch := query.Get("arch")
+	if arch == "" {
+		arch = "amd64"
+	}
+	var errs validation.Errors
+	if !validation.OneOf(role, cloudInitRoles) {
+		errs.Add("role", validation.CodeUnsupported, fmt.Sprintf("unsupported role %q, expected one of %s", role, strings.Join(cloudInitRoles, ", ")))
+	}
+	platform, err := provision.NewPlatform(osID, nil, arch)
+	if err != nil {
+		errs.Add("os", validation.CodeUnsupported, err.Error())
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+	if role == "control-plane" && cluster.APIServerEndpoint == "" {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Control planes can only join clusters with an api_server_endpoint")
+		return
+	}
+	if role == "control-plane" && cluster.HardeningProfile == provision.HardeningCIS {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Control planes of clusters with the cis hardening profile need its encryption key and cannot join with cloud-init")
+		return
+	}
+
+	provisioner, err := clusterProvisioner(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "Failed to get provisioner")
+		return
+	}
+	controlPlane, err := controlPlaneHost(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "Failed to find control plane")
+		return
+	}
+
+	timeouts := provision.DefaultStepTimeouts()
+	var joinCommand string
+	err = provision.RunStep(r.Context(), "join token", timeouts.Join, func(ctx context.Context) error {
+		var err error
+		joinCommand, err = provisioner.GenerateJoinToken(ctx, controlPlane)
+		if err != nil || role != "control-plane" {
+			return err
+		}
+		key, err := provisioner.UploadCertificates(ctx, controlPlane)
+		joinCommand += " --control-plane --certificate-key " + key
+		return err
+	})
+	if err != nil {
+		h.reportError(cluster.ID, "Failed to create join token for cloud-init", err)
+		WriteError(w, http.StatusBadGateway, "CONTROL_PLANE_ERROR", err.Error())
+		return
+	}
+
+	token, err := newRegistrationToken(h.store.DB(), cluster.ID, role)
+	if err != nil {
+		WriteInternalError(w, "Failed to create registration token")
+		return
+	}
+	userData, err := provision.RenderCloudInit(provision.CloudInitParams{
+		Platform:      platform,
+		Runtime:       cluster.ContainerRuntime,
+		K8sVersion:    cluster.K8sVersion,
+		JoinCommand:   joinCommand,
+		RegisterURL:   externalURL(r) + "/api/" + APIVersion + "/nodes/register",
+		RegisterToken: token,
+	})
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	h.logEvent(cluster.ID, "info", "localhost", "cloud-init", fmt.Sprintf("Created cloud-init user-data for %s hosts on %s/%s", role, platform.OS, platform.Arch))
+	w.Header().Set("Content-Type", "text/cloud-config")
+	w.Header().Set("Content-Disposition", "attachment; filename=user-data")
+	w.Write(userData)
+}
+
+// RegisterNodeRequest is sent by a host after running cloud-init user-data
+type RegisterNodeRequest struct {
+	Hostname string `json:"hostname"`
+	Address  string `json:"address"`
+	Status   string `json:"status"` // ready, or failed when preparing or joining failed
+}
+
+// RegisterNode records a host that ran the cloud-init user-data of a
+// cluster. It is authenticated by the registration token of the user-data
+// instead of an access token.
+func (h *ClusterHandler) RegisterNode(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var registration db.RegistrationToken
+	if token == "" || h.store.DB().Where("token_hash = ? AND expires_at > ?", hashRegistrationToken(token), time.Now()).First(&registration).Error != nil {
+		WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid or expired registration token")
+		return
+	}
+
+	var req RegisterNodeRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	var errs validation.Errors
+	if req.Hostname == "" {
+		errs.Add("hostname", validation.CodeRequired, "hostname is required")
+	}
+	if net.ParseIP(req.Address) == nil {
+		errs.Add("address", validation.CodeInvalid, fmt.Sprintf("%q is not a valid IP address", req.Address))
+	}
+	if req.Status != "ready" && req.Status != "failed" {
+		errs.Add("status", validation.CodeUnsupported, "status must be ready or failed")
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	clusterID := registration.ClusterID
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id", "k8s_version", "container_runtime").First(&cluster, clusterID).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+	var joinedAt *time.Time
+	if req.Status == "ready" {
+		now := time.Now()
+		joinedAt = &now
+	}
+
+	var node db.Node
+	err := h.store.DB().Where("cluster_id = ? AND address = ?", clusterID, req.Address).First(&node).Error
+	if err == nil {
+		err = h.store.DB().Model(&node).Updates(map[string]interface{}{"hostname": req.Hostname, "status": req.Status, "joined_at": joinedAt}).Error
+	} else {
+		node = db.Node{
+			ClusterID:        clusterID,
+			Hostname:         req.Hostname,
+			Address:          req.Address,
+			Role:             registration.Role,
+			Status:           req.Status,
+			K8sVersion:       cluster.K8sVersion,
+			ContainerRuntime: cluster.ContainerRuntime,
+			JoinedAt:         joinedAt,
+		}
+		err = h.store.DB().Create(&node).Error
+	}
+	if err != nil {
+		WriteInternalError(w, "Failed to save node")
+		return
+	}
+
+	if req.Status == "ready" {
+		h.logEvent(clusterID, "info", req.Address, "register", fmt.Sprintf("Host %s joined as %s with cloud-init", req.Hostname, registration.Role))
+	} else {
+		h.logEvent(clusterID, "error", req.Address, "register", fmt.Sprintf("Host %s failed to join with cloud-init, see /var/log/cloud-init-output.log on the host", req.Hostname))
+	}
+	WriteSuccess(w, node)
+}
+
+// newRegistrationToken creates a registration token for hosts joining a
+// cluster and returns it. Only its hash is stored.
+func newRegistrationToken(conn *gorm.DB, clusterID uint, role string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	conn.Where("expires_at < ?", now).Delete(&db.RegistrationToken{})
+	record := db.RegistrationToken{
+		ClusterID: clusterID,
+		TokenHash: hashRegistrationToken(token),
+		Role:      role,
+		ExpiresAt: now.Add(registrationTokenTTL),
+		CreatedAt: now,
+	}
+	if err := conn.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashRegistrationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// externalURL returns the scheme and host clients reach the server at,
+// honoring the headers of a reverse proxy
+func externalURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
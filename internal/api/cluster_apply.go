@@ -0,0 +1,646 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// Actions of a reconciliation plan, in the order they are carried out
+const (
+	actionRename         = "rename"
+	actionNotifications  = "update-notifications"
+	actionRemoveWorker   = "remove-worker"
+	actionUpgrade        = "upgrade"
+	actionAddWorker      = "add-worker"
+	actionInstallAddon   = "install-addon"
+	actionUpgradeAddon   = "upgrade-addon"
+	actionUninstallAddon = "uninstall-addon"
+)
+
+// PlanAction is one change needed to bring a cluster to its desired spec
+type PlanAction struct {
+	Action string `json:"action"`
+	Target string `json:"target"` // cluster name, host address or hostname, or addon name
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// ApplySpecResponse is the plan computed for a spec and the job carrying it
+// out, if one was started
+type ApplySpecResponse struct {
+	Plan     []PlanAction            `json:"plan"`
+	DryRun   bool                    `json:"dry_run,omitempty"`
+	Job      *db.Job                 `json:"job,omitempty"`
+	Warnings []validation.FieldError `json:"warnings,omitempty"` // spec lint warnings
+}
+
+// reconcilePayload is the input of a reconcile job
+type reconcilePayload struct {
+	Plan    []PlanAction          `json:"plan"`
+	Workers []provision.HostSpec  `json:"workers,omitempty"` // workers to add
+	Addons  []provision.AddonSpec `json:"addons,omitempty"`  // addons to install or upgrade
+
+	Infrastructure *infra.Spec `json:"infrastructure,omitempty"` // provider creating the machines of new workers
+}
+
+// reconcileCheckpoint is the resume state of a reconcile job
+type reconcileCheckpoint struct {
+	upgradeCheckpoint
+	Done        int    `json:"done"` // number of plan actions completed
+	JoinCommand string `json:"join_command,omitempty"`
+
+	Machines map[string]infra.Machine `json:"machines,omitempty"` // machines of new workers by hostname
+}
+
+// ApplySpec takes the full desired spec of a cluster, computes the changes
+// needed to reach it and queues a reconcile job carrying them out. With
+// dry_run=true only the plan is returned.
+func (h *ClusterHandler) ApplySpec(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var req CreateClusterRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.TemplateID != 0 {
+		template, err := loadTemplateSpec(h.store.DB(), req.TemplateID)
+		if err != nil {
+			var errs validation.Errors
+			errs.Add("template_id", validation.CodeInvalid, "template not found")
+			WriteValidationError(w, errs)
+			return
+		}
+		template.applyTo(&req)
+	}
+	h.applySpec(w, r, uint(id), req, revisionApply, 0)
+}
+
+// applySpec plans and applies a spec, recording it as a new revision of the
+// cluster
+func (h *ClusterHandler) applySpec(w http.ResponseWriter, r *http.Request, id uint, req CreateClusterRequest, action string, source int) {
+	var cluster db.Cluster
+	if err := h.store.DB().Preload("Nodes").First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	if errs := resolveInventoryHosts(h.store.DB(), cluster.ProjectID, cluster.ID, &req); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	warnings := req.Lint(h.store.DB())
+	if errs := req.Validate(h.store.DB()); len(errs) > 0 {
+		WriteSpecValidationError(w, errs, warnings)
+		return
+	}
+	var installed []db.Addon
+	if err := h.store.DB().Where("cluster_id = ?", cluster.ID).Order("name").Find(&installed).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve addons")
+		return
+	}
+
+	payload, errs := planCluster(&cluster, installed, &req)
+	policy, _, err := loadClusterPolicy(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve policy")
+		return
+	}
+	if version := strings.TrimPrefix(req.K8sVersion, "v"); version != "" && version != cluster.K8sVersion && !policy.allowsVersion(version) {
+		errs.Add("k8s_version", validation.CodePolicy, fmt.Sprintf("k8s version %s is not allowed by the cluster policy", version))
+	}
+	errs = append(errs, policy.checkAddons(req.Addons)...)
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	workerKeys, errs := resolveSSHKeys(h.store.DB(), cluster.ProjectID, "workers", payload.Workers)
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	for i := range payload.Workers {
+		payload.Workers[i].SSHKeyID = workerKeys[i]
+	}
+
+	response := ApplySpecResponse{Plan: payload.Plan, Warnings: warnings}
+	if r.URL.Query().Get("dry_run") == "true" {
+		response.DryRun = true
+		WriteSuccess(w, response)
+		return
+	}
+
+	// Settings are changed right away, everything else by the job
+	var changes []string
+	for _, action := range payload.Plan {
+		switch action.Action {
+		case actionRename:
+			cluster.Name = req.Name
+			changes = append(changes, "name")
+		case actionNotifications:
+			cluster.Notifications = req.Notifications
+			changes = append(changes, "notifications")
+		}
+	}
+	needsJob := len(payload.Plan) > len(changes)
+	if needsJob && cluster.Status != db.ClusterReady {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster must be ready to reconcile its nodes, version or addons")
+		return
+	}
+	if len(changes) > 0 {
+		if err := h.store.DB().Model(&cluster).Select(changes).Updates(&cluster).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				WriteError(w, http.StatusConflict, "CONFLICT", "Cluster name already in use")
+				return
+			}
+			WriteInternalError(w, "Failed to update cluster")
+			return
+		}
+	}
+	if !needsJob {
+		recordRevision(h.store.DB(), cluster.ID, req, action, source, 0, r)
+		WriteSuccess(w, response)
+		return
+	}
+
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "reconcile",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A reconciliation is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue reconcile job")
+		return
+	}
+	response.Job = &job
+	recordRevision(h.store.DB(), cluster.ID, req, action, source, job.ID, r)
+	WriteAccepted(w, jobLocation(job.ID), response)
+}
+
+// planCluster compares a cluster with its desired spec and returns the
+// actions converging it. Empty scalar fields of the spec keep their current
+// value, while workers and addons list the complete desired sets.
+func planCluster(cluster *db.Cluster, installed []db.Addon, req *CreateClusterRequest) (*reconcilePayload, validation.Errors) {
+	var errs validation.Errors
+	immutable := func(field, current, desired string) {
+		if desired != "" && desired != current {
+			errs.Add(field, validation.CodeImmutable, "field cannot be changed after the cluster is created")
+		}
+	}
+	if req.ProjectID != 0 && req.ProjectID != cluster.ProjectID {
+		errs.Add("project_id", validation.CodeImmutable, "field cannot be changed after the cluster is created")
+	}
+	immutable("pod_network_cidr", cluster.PodNetworkCIDR, req.PodNetworkCIDR)
+	immutable("service_cidr", cluster.ServiceCIDR, req.ServiceCIDR)
+	immutable("cni", cluster.CNI, req.CNI)
+	immutable("container_runtime", cluster.ContainerRuntime, req.ContainerRuntime)
+	immutable("api_server_endpoint", cluster.APIServerEndpoint, req.APIServerEndpoint)
+	immutable("kubeadm_config", cluster.KubeadmConfig, req.KubeadmConfig)
+	immutable("hardening_profile", cluster.HardeningProfile, req.HardeningProfile)
+
+	matchMachines(cluster.Nodes, req.ControlPlanes)
+	matchMachines(cluster.Nodes, req.Workers)
+
+	var controlPlanes, workers []db.Node
+	for _, node := range cluster.Nodes {
+		if node.Role == "control-plane" {
+			controlPlanes = append(controlPlanes, node)
+		} else {
+			workers = append(workers, node)
+		}
+	}
+	if !sameAddresses(controlPlanes, req.ControlPlanes) {
+		errs.Add("control_planes", validation.CodeImmutable, "control planes cannot be changed after the cluster is created")
+	}
+
+	payload := &reconcilePayload{}
+	if req.Name != cluster.Name {
+		payload.Plan = append(payload.Plan, PlanAction{Action: actionRename, Target: cluster.Name, From: cluster.Name, To: req.Name})
+	}
+	if strings.Join(req.Notifications, ",") != strings.Join(cluster.Notifications, ",") {
+		payload.Plan = append(payload.Plan, PlanAction{Action: actionNotifications, Target: cluster.Name,
+			From: strings.Join(cluster.Notifications, ","), To: strings.Join(req.Notifications, ",")})
+	}
+
+	// Workers are removed before the upgrade and added after it, so new
+	// workers join with the new version
+	desired := make(map[string]bool)
+	for _, host := range req.Workers {
+		desired[hostTarget(host)] = true
+	}
+	for _, node := range workers {
+		if !desired[nodeTarget(node)] {
+			payload.Plan = append(payload.Plan, PlanAction{Action: actionRemoveWorker, Target: nodeTarget(node)})
+		}
+	}
+
+	if version := strings.TrimPrefix(req.K8sVersion, "v"); version != "" && version != cluster.K8sVersion {
+		if err := provision.ValidateUpgrade(cluster.K8sVersion, version); err != nil {
+			errs.Add("k8s_version", validation.CodeInvalid, err.Error())
+		}
+		payload.Plan = append(payload.Plan, PlanAction{Action: actionUpgrade, Target: cluster.Name, From: cluster.K8sVersion, To: version})
+	}
+
+	// Workers that failed to join are added again
+	current := make(map[string]bool)
+	for _, node := range workers {
+		current[nodeTarget(node)] = node.Status != "failed"
+	}
+	for _, host := range req.Workers {
+		if !current[hostTarget(host)] {
+			payload.Plan = append(payload.Plan, PlanAction{Action: actionAddWorker, Target: hostTarget(host)})
+			payload.Workers = append(payload.Workers, host)
+			if host.Machine != nil {
+				payload.Infrastructure = req.Infrastructure
+			}
+		}
+	}
+
+	records := make(map[string]db.Addon)
+	for _, record := range installed {
+		records[record.Name] = record
+	}
+	wanted := make(map[string]bool)
+	for _, spec := range req.Addons {
+		wanted[spec.Name] = true
+		record, ok := records[spec.Name]
+		if !ok {
+			addon, _ := addons.GetAddon(spec.Name)
+			if spec.Version == "" {
+				spec.Version = addon.DefaultVersion()
+			}
+			payload.Plan = append(payload.Plan, PlanAction{Action: actionInstallAddon, Target: spec.Name, To: spec.Version})
+			payload.Addons = append(payload.Addons, spec)
+			continue
+		}
+		if spec.Version == "" {
+			spec.Version = record.Version
+		}
+		if spec.Version != record.Version || addonConfigChanged(record, spec.Config) {
+			payload.Plan = append(payload.Plan, PlanAction{Action: actionUpgradeAddon, Target: spec.Name, From: record.Version, To: spec.Version})
+			payload.Addons = append(payload.Addons, spec)
+		}
+	}
+	for _, record := range installed {
+		if !wanted[record.Name] {
+			payload.Plan = append(payload.Plan, PlanAction{Action: actionUninstallAddon, Target: record.Name, From: record.Version})
+		}
+	}
+
+	if payload.Plan == nil {
+		payload.Plan = []PlanAction{}
+	}
+	return payload, errs
+}
+
+// sameAddresses reports whether the nodes are exactly the hosts
+func sameAddresses(nodes []db.Node, hosts []provision.HostSpec) bool {
+	if len(nodes) != len(hosts) {
+		return false
+	}
+	addresses := make(map[string]bool)
+	for _, node := range nodes {
+		addresses[node.Address] = true
+	}
+	for _, host := range hosts {
+		if !addresses[host.Address] {
+			return false
+		}
+	}
+	return true
+}
+
+// addonConfigChanged reports whether a desired addon config differs from the
+// stored one. Credentials in the desired config always count as a change, as
+// the stored ones cannot be compared without decrypting them.
+func addonConfigChanged(record db.Addon, config json.RawMessage) bool {
+	if len(config) == 0 {
+		return false
+	}
+	public, credentials, err := addons.SplitCredentials(config)
+	if err != nil || credentials != nil {
+		return true
+	}
+	var desired, stored bytes.Buffer
+	if json.Compact(&desired, public) != nil || json.Compact(&stored, []byte(record.Config)) != nil {
+		return true
+	}
+	return desired.String() != stored.String()
+}
+
+// runReconcileJob carries out the plan of a reconcile job, skipping the
+// actions already completed according to the checkpoint
+func (h *ClusterHandler) runReconcileJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload reconcilePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.logError(clusterID, "Invalid reconcile job payload", err)
+		return err
+	}
+
+	var checkpoint reconcileCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(clusterID, "Invalid reconcile checkpoint, starting over", err)
+		checkpoint = reconcileCheckpoint{}
+	}
+	save := func() {
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save reconcile checkpoint", err)
+		}
+	}
+
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		h.logError(clusterID, "Failed to get provisioner", err)
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+
+	controlPlane, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		h.logError(clusterID, "Failed to find control plane", err)
+		return err
+	}
+
+	var nodeCount int64
+	h.store.DB().Model(&db.Node{}).Where("cluster_id = ?", clusterID).Count(&nodeCount)
+	progress := &provisionProgress{job: job}
+	for _, action := range payload.Plan {
+		if action.Action == actionUpgrade {
+			progress.total += int(nodeCount)
+		} else {
+			progress.total++
+		}
+	}
+
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id", "k8s_version", "container_runtime", "insecure_skip_host_key_check", "hardening_profile").First(&cluster, clusterID).Error; err != nil {
+		h.logError(clusterID, "Failed to load cluster", err)
+		return err
+	}
+	k8sVersion := cluster.K8sVersion
+	timeouts := provision.DefaultStepTimeouts()
+
+	h.setClusterStatus(clusterID, db.ClusterReconciling, "")
+	h.logEvent(clusterID, "info", "localhost", "reconcile", "Reconciling cluster with its spec")
+
+	for i, action := range payload.Plan {
+		if action.Action == actionUpgrade {
+			k8sVersion = action.To
+		}
+		if i < checkpoint.Done {
+			if action.Action == actionUpgrade {
+				progress.advance(action.Action, int(nodeCount))
+			} else {
+				progress.advance(action.Action, 1)
+			}
+			continue
+		}
+		units := 1
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch action.Action {
+		case actionRemoveWorker:
+			node, err := findNode(h.store.DB(), clusterID, action.Target)
+			if err != nil {
+				break
+			}
+			h.logEvent(clusterID, "info", action.Target, "remove-node", "Removing worker")
+			h.store.DB().Model(&node).Update("status", "removing")
+			// A node whose machine never came up has nothing to reset
+			if node.Address != "" {
+				host := nodeHostSpec(node, cluster.InsecureSkipHostKeyCheck)
+				err := provision.RunStep(ctx, "remove "+host.Address, timeouts.Join, func(ctx context.Context) error {
+					return provisioner.RemoveNode(ctx, host, controlPlane)
+				})
+				if err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					h.store.DB().Model(&node).Update("status", "unknown")
+					h.logNodeFailure(clusterID, node.Address, "remove-node", "Failed to remove worker", err)
+					return err
+				}
+			}
+			if node.MachineID != "" {
+				h.logEvent(clusterID, "info", action.Target, "machine", "Deleting machine "+node.MachineID)
+				deleteMachine(ctx, clusterID, node.MachineID)
+			}
+			// Deleted for good so the host can join the cluster again
+			h.store.DB().Unscoped().Delete(&node)
+
+		case actionUpgrade:
+			err := h.upgradeCluster(ctx, clusterID, action.To, &checkpoint.upgradeCheckpoint, save, progress)
+			if err != nil {
+				return err
+			}
+			h.setClusterStatus(clusterID, db.ClusterReconciling, "")
+			units = 0 // advanced per node
+
+		case actionAddWorker:
+			for _, host := range payload.Workers {
+				if hostTarget(host) != action.Target {
+					continue
+				}
+				if err := h.addWorker(ctx, provisioner, cluster, payload.Infrastructure, host, k8sVersion, controlPlane, &checkpoint, save); err != nil {
+					if ctx.Err() == nil {
+						h.setClusterStatus(clusterID, db.ClusterReady, "")
+					}
+					return err
+				}
+			}
+
+		case actionInstallAddon, actionUpgradeAddon:
+			for _, spec := range payload.Addons {
+				if spec.Name == action.Target {
+					h.logEvent(clusterID, "info", controlPlane.Address, "addon", "Installing addon "+spec.Name+" "+spec.Version)
+					if record, err := saveAddonSpec(h.store.DB(), clusterID, spec); err != nil {
+						h.logEvent(clusterID, "error", "localhost", "addon", "Failed to save addon "+spec.Name+": "+err.Error())
+					} else {
+						installAddon(h.store.DB(), ctx, record)
+					}
+				}
+			}
+
+		case actionUninstallAddon:
+			var record db.Addon
+			if err := h.store.DB().Where("cluster_id = ? AND name = ?", clusterID, action.Target).First(&record).Error; err == nil {
+				h.logEvent(clusterID, "info", controlPlane.Address, "addon", "Uninstalling addon "+record.Name)
+				h.store.DB().Model(&record).Update("status", "uninstalling")
+				uninstallAddon(h.store.DB(), ctx, record)
+			}
+		}
+
+		checkpoint.Done = i + 1
+		save()
+		progress.advance(action.Action, units)
+	}
+
+	h.setClusterStatus(clusterID, db.ClusterReady, "")
+	h.logEvent(clusterID, "info", "localhost", "complete", "Cluster reconciled with its spec")
+	return nil
+}
+
+// addWorker prepares a new worker and joins it to the cluster, creating its
+// node record or reusing the record of an earlier failed attempt. The
+// machine of a worker with a machine spec is created first. A failure is
+// recorded on the node, the cluster status is left to the caller.
+func (h *ClusterHandler) addWorker(ctx context.Context, provisioner provision.IProvisioner, cluster db.Cluster, infrastructure *infra.Spec,
+	host provision.HostSpec, k8sVersion string, controlPlane provision.HostSpec, checkpoint *reconcileCheckpoint, save func()) error {
+	clusterID := cluster.ID
+	address := hostTarget(host)
+	host.Role = "worker"
+	host.SetDefaults()
+	host.SetInsecureSkipHostKeyCheck(cluster.InsecureSkipHostKeyCheck)
+
+	node, err := findNode(h.store.DB(), clusterID, address)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		node = newNodeRecord(clusterID, host, host.SSHKeyID, "worker")
+		err = h.store.DB().Create(&node).Error
+	}
+	if err != nil {
+		h.logEvent(clusterID, "error", address, "join", "Failed to save node: "+err.Error())
+		return err
+	}
+	h.store.DB().Model(&node).Update("status", "provisioning")
+
+	timeouts := provision.DefaultStepTimeouts()
+	if host.Machine != nil && node.MachineID == "" && infrastructure != nil {
+		machine, created := checkpoint.Machines[host.Hostname]
+		if !created {
+			machine, err = h.createMachine(ctx, clusterID, *infrastructure, host, timeouts.Machine)
+			if err == nil {
+				if checkpoint.Machines == nil {
+					checkpoint.Machines = make(map[string]infra.Machine)
+				}
+				checkpoint.Machines[host.Hostname] = machine
+				save()
+			}
+		}
+		host.Address = machine.Address
+		if err == nil {
+			err = waitForMachine(ctx, host, timeouts.Machine)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.store.DB().Model(&node).Update("status", "failed")
+			h.logEvent(clusterID, "error", address, "machine", "Failed to create machine: "+err.Error())
+			return err
+		}
+		address = host.Address
+	}
+
+	h.logEvent(clusterID, "info", address, "prepare", "Preparing worker")
+	err = provision.RunStep(ctx, "prepare "+address, timeouts.Prepare, func(ctx context.Context) error {
+		return provisioner.PrepareHosts(ctx, []provision.HostSpec{host}, cluster.ContainerRuntime, k8sVersion)
+	})
+	if err == nil && checkpoint.JoinCommand == "" {
+		err = provision.RunStep(ctx, "join token", timeouts.Join, func(ctx context.Context) error {
+			var err error
+			checkpoint.JoinCommand, err = provisioner.GenerateJoinToken(ctx, controlPlane)
+			return err
+		})
+		save()
+	}
+	if err == nil {
+		h.logEvent(clusterID, "info", address, "join", "Joining worker")
+		err = provision.RunStep(ctx, "join "+address, timeouts.Join, func(ctx context.Context) error {
+			return provisioner.JoinWorker(ctx, host, checkpoint.JoinCommand)
+		})
+		if err == nil {
+			h.secureNodeFiles(ctx, clusterID, cluster.HardeningProfile, host)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		h.store.DB().Model(&node).Update("status", "failed")
+		h.logEvent(clusterID, "error", address, "join", "Failed to add worker: "+err.Error())
+		return err
+	}
+
+	h.store.DB().Model(&node).Updates(map[string]interface{}{"status": "ready", "k8s_version": k8sVersion})
+	return nil
+}
+
+// logNodeFailure records a failed change of a node. The rest of the cluster
+// keeps working, so unlike logError it leaves the cluster ready.
+func (h *ClusterHandler) logNodeFailure(clusterID uint, host, step, message string, err error) {
+	h.logEvent(clusterID, "error", host, step, message+": "+err.Error())
+	h.setClusterStatus(clusterID, db.ClusterReady, "")
+}
+
+// saveAddonSpec creates or updates the record of an addon to install with
+// the version and config of spec
+func saveAddonSpec(conn *gorm.DB, clusterID uint, spec provision.AddonSpec) (db.Addon, error) {
+	var record db.Addon
+	err := conn.Where("cluster_id = ? AND name = ?", clusterID, spec.Name).First(&record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return record, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record = db.Addon{ClusterID: clusterID, Name: spec.Name, CreatedAt: time.Now()}
+	}
+
+	if len(spec.Config) > 0 {
+		config, credentials, err := sealAddonConfig(spec.Config)
+		if err != nil {
+			return record, err
+		}
+		// Credentials are kept unless new ones are supplied
+		record.Config = config
+		if credentials != nil {
+			record.Credentials = credentials
+		}
+	}
+	record.Version = spec.Version
+	record.Status = "installing"
+	record.Error = ""
+	record.UpdatedAt = time.Now()
+	return record, conn.Save(&record).Error
+}
+
+// findNode returns the node of a cluster named by a plan target
+func findNode(conn *gorm.DB, clusterID uint, target string) (db.Node, error) {
+	var node db.Node
+	err := conn.Where("cluster_id = ? AND (address = ? OR (address = ? AND hostname = ?))", clusterID, target, "", target).First(&node).Error
+	return node, err
+}
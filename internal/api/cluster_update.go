@@ -0,0 +1,430 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// UpdateClusterRequest represents a partial update of a cluster. Only the
+// fields present in the request are changed.
+type UpdateClusterRequest struct {
+	Name        *string            `json:"name"`
+	Labels      *map[string]string `json:"labels"`
+	Annotations *map[string]string `json:"annotations"`
+	K8sVersion  *string            `json:"k8s_version"` // starts an upgrade job
+	Addons      map[string]bool    `json:"addons"`      // true installs an addon with defaults, false uninstalls it
+
+	Notifications *[]string `json:"notifications"` // channels told when provisioning or an upgrade completes or fails
+
+	InsecureSkipHostKeyCheck *bool `json:"insecure_skip_host_key_check"` // accept any SSH host key
+
+	AutoUpgrade       *string               `json:"auto_upgrade"`       // patch or none
+	MaintenanceWindow *db.MaintenanceWindow `json:"maintenance_window"` // null removes the window
+}
+
+// UpdateClusterResponse is the updated cluster and the upgrade job, if one
+// was started
+type UpdateClusterResponse struct {
+	Cluster    *db.Cluster `json:"cluster"`
+	UpgradeJob *db.Job     `json:"upgrade_job,omitempty"`
+}
+
+// immutableClusterFields cannot be changed once the cluster is created
+var immutableClusterFields = map[string]bool{
+	"project_id":          true,
+	"pod_network_cidr":    true,
+	"service_cidr":        true,
+	"cni":                 true,
+	"container_runtime":   true,
+	"provider":            true,
+	"api_server_endpoint": true,
+	"load_balancer_ip":    true,
+	"control_planes":      true,
+	"workers":             true,
+	"timeouts":            true,
+	"bastion":             true,
+	"control_plane_hosts": true,
+	"worker_hosts":        true,
+}
+
+// mutableClusterFields are the fields accepted by UpdateCluster
+var mutableClusterFields = map[string]bool{
+	"name":        true,
+	"labels":      true,
+	"annotations": true,
+	"k8s_version": true,
+	"addons":      true,
+
+	"notifications": true,
+
+	"insecure_skip_host_key_check": true,
+
+	"auto_upgrade":       true,
+	"maintenance_window": true,
+}
+
+// upgradeCheckpoint is the resume state of an upgrade job
+type upgradeCheckpoint struct {
+	UpgradedHosts []string `json:"upgraded_hosts,omitempty"`
+}
+
+// upgradePayload is the input of an upgrade job
+type upgradePayload struct {
+	K8sVersion string `json:"k8s_version"`
+	Automatic  bool   `json:"automatic,omitempty"` // started by the auto-upgrade policy of the cluster
+}
+
+// UpdateCluster changes the mutable fields of a cluster
+func (h *ClusterHandler) UpdateCluster(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err == nil && isYAML(r.Header.Get("Content-Type")) {
+		body, err = yamlToJSON(body)
+	}
+	if err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	var errs validation.Errors
+	for field := range fields {
+		if immutableClusterFields[field] {
+			errs.Add(field, validation.CodeImmutable, "field cannot be changed after the cluster is created")
+		} else if !mutableClusterFields[field] {
+			errs.Add(field, validation.CodeUnknown, "unknown field")
+		}
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	var req UpdateClusterRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	var cluster db.Cluster
+	if err := h.store.DB().First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	// Validate everything before changing anything
+	if req.Name != nil && *req.Name == "" {
+		errs.Add("name", validation.CodeRequired, "cluster name must not be empty")
+	}
+	if req.Labels != nil {
+		errs = append(errs, validateLabels("labels", *req.Labels)...)
+	}
+	if req.Annotations != nil {
+		errs = append(errs, validateAnnotations("annotations", *req.Annotations)...)
+	}
+	if req.Notifications != nil {
+		errs = append(errs, validateNotifications(h.store.DB(), "notifications", *req.Notifications)...)
+	}
+	_, setWindow := fields["maintenance_window"]
+	if req.AutoUpgrade != nil || setWindow {
+		policy, window := cluster.AutoUpgrade, cluster.MaintenanceWindow
+		if req.AutoUpgrade != nil {
+			policy = *req.AutoUpgrade
+		}
+		if setWindow {
+			window = req.MaintenanceWindow
+		}
+		errs = append(errs, validateAutoUpgrade(policy, window)...)
+	}
+	upgrade := req.K8sVersion != nil && strings.TrimPrefix(*req.K8sVersion, "v") != cluster.K8sVersion
+	if upgrade {
+		if err := provision.ValidateUpgrade(cluster.K8sVersion, *req.K8sVersion); err != nil {
+			errs.Add("k8s_version", validation.CodeInvalid, err.Error())
+		}
+	}
+	for name, enabled := range req.Addons {
+		path := validation.Path("addons", name)
+		addon, err := addons.GetAddon(name)
+		if err != nil {
+			errs.Add(path, validation.CodeUnsupported, err.Error())
+			continue
+		}
+		if !enabled {
+			continue
+		}
+		opts := addons.InstallOptions{Version: addon.DefaultVersion()}
+		if err := addons.ValidateOptions(name, opts); err != nil {
+			errs.Add(path, validation.CodeInvalid, fmt.Sprintf("addon cannot be enabled with defaults, install it with its config instead: %v", err))
+		}
+	}
+
+	// Changes must keep the cluster within the cluster policy
+	policy, _, err := loadClusterPolicy(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve policy")
+		return
+	}
+	if upgrade && !policy.allowsVersion(*req.K8sVersion) {
+		errs.Add("k8s_version", validation.CodePolicy, fmt.Sprintf("k8s version %s is not allowed by the cluster policy", *req.K8sVersion))
+	}
+	for name, enabled := range req.Addons {
+		if !enabled && policy.mandatory(name) {
+			errs.Add(validation.Path("addons", name), validation.CodePolicy, fmt.Sprintf("addon %s is mandatory", name))
+		}
+	}
+	if req.Labels != nil {
+		errs = append(errs, policy.checkLabels(*req.Labels)...)
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if (upgrade || len(req.Addons) > 0) && cluster.Status != db.ClusterReady {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster must be ready to change its version or addons")
+		return
+	}
+
+	if req.Name != nil {
+		cluster.Name = *req.Name
+	}
+	if req.Labels != nil {
+		cluster.Labels = *req.Labels
+	}
+	if req.Annotations != nil {
+		cluster.Annotations = *req.Annotations
+	}
+	if req.Notifications != nil {
+		cluster.Notifications = *req.Notifications
+	}
+	if req.InsecureSkipHostKeyCheck != nil {
+		cluster.InsecureSkipHostKeyCheck = *req.InsecureSkipHostKeyCheck
+	}
+	if req.AutoUpgrade != nil {
+		cluster.AutoUpgrade = *req.AutoUpgrade
+	}
+	if setWindow {
+		cluster.MaintenanceWindow = req.MaintenanceWindow
+	}
+	if req.Name != nil || req.Labels != nil || req.Annotations != nil || req.Notifications != nil || req.InsecureSkipHostKeyCheck != nil ||
+		req.AutoUpgrade != nil || setWindow {
+		if err := h.store.DB().Model(&cluster).Select("name", "labels", "annotations", "notifications", "insecure_skip_host_key_check",
+			"auto_upgrade", "maintenance_window").Updates(&cluster).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				WriteError(w, http.StatusConflict, "CONFLICT", "Cluster name already in use")
+				return
+			}
+			WriteInternalError(w, "Failed to update cluster")
+			return
+		}
+	}
+
+	if err := h.toggleAddons(r.Context(), cluster.ID, req.Addons); err != nil {
+		WriteInternalError(w, "Failed to update addons")
+		return
+	}
+
+	response := UpdateClusterResponse{Cluster: &cluster}
+	if upgrade {
+		payload, _ := json.Marshal(upgradePayload{K8sVersion: strings.TrimPrefix(*req.K8sVersion, "v")})
+		job := db.Job{
+			ClusterID:   cluster.ID,
+			Type:        "upgrade",
+			Payload:     string(payload),
+			RequestID:   logging.RequestID(r.Context()),
+			TraceParent: tracing.Inject(r.Context()),
+		}
+		if err := h.queue.Enqueue(&job); err != nil {
+			if errors.Is(err, jobs.ErrDuplicateJob) {
+				WriteError(w, http.StatusConflict, "CONFLICT", "An upgrade is already in progress")
+				return
+			}
+			WriteInternalError(w, "Failed to queue upgrade job")
+			return
+		}
+		response.UpgradeJob = &job
+	}
+
+	h.store.DB().Preload("Nodes").First(&cluster, cluster.ID)
+	if response.UpgradeJob != nil {
+		WriteAccepted(w, jobLocation(response.UpgradeJob.ID), response)
+		return
+	}
+	WriteSuccess(w, response)
+}
+
+// toggleAddons installs enabled addons that are missing with their default
+// settings and uninstalls disabled addons that are present, queueing a job
+// for each. An addon already being uninstalled is left to its job.
+func (h *ClusterHandler) toggleAddons(ctx context.Context, clusterID uint, toggles map[string]bool) error {
+	for name, enabled := range toggles {
+		var record db.Addon
+		err := h.store.DB().Where("cluster_id = ? AND name = ?", clusterID, name).First(&record).Error
+		installed := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		switch {
+		case enabled && !installed:
+			addon, _ := addons.GetAddon(name)
+			record = db.Addon{
+				ClusterID: clusterID,
+				Name:      name,
+				Version:   addon.DefaultVersion(),
+				Status:    "installing",
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+				_, err := enqueueAddonJob(ctx, h.queue, tx, jobInstallAddon, record)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		case !enabled && installed:
+			err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&record).Update("status", "uninstalling").Error; err != nil {
+					return err
+				}
+				_, err := enqueueAddonJob(ctx, h.queue, tx, jobUninstallAddon, record)
+				return err
+			})
+			if err != nil && !errors.Is(err, jobs.ErrDuplicateJob) {
+				return err
+			}
+		}
+	}
+	h.queue.Notify()
+	return nil
+}
+
+// runUpgradeJob upgrades the nodes of a cluster one at a time, control
+// planes first, skipping the nodes already upgraded according to the
+// checkpoint
+func (h *ClusterHandler) runUpgradeJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload upgradePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.logError(clusterID, "Invalid upgrade job payload", err)
+		return err
+	}
+
+	var checkpoint upgradeCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(clusterID, "Invalid upgrade checkpoint, starting over", err)
+		checkpoint = upgradeCheckpoint{}
+	}
+
+	var nodeCount int64
+	h.store.DB().Model(&db.Node{}).Where("cluster_id = ?", clusterID).Count(&nodeCount)
+	progress := &provisionProgress{job: job, total: int(nodeCount)}
+
+	save := func() {
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save upgrade checkpoint", err)
+		}
+	}
+	operation := "upgrade to " + payload.K8sVersion
+	if payload.Automatic {
+		operation = "automatic " + operation
+	}
+	if err := h.upgradeCluster(ctx, clusterID, payload.K8sVersion, &checkpoint, save, progress); err != nil {
+		// Cancelled and interrupted jobs have not ended yet from the user's view
+		if ctx.Err() == nil {
+			notifyJob(h.store.DB(), job, operation, err)
+		}
+		return err
+	}
+
+	h.setClusterStatus(clusterID, db.ClusterReady, "")
+	h.logEvent(clusterID, "info", "localhost", "complete", "Cluster upgraded to "+payload.K8sVersion)
+	notifyJob(h.store.DB(), job, operation, nil)
+	return nil
+}
+
+// upgradeCluster upgrades the nodes of a cluster to k8sVersion, recording
+// each upgraded node in the checkpoint and calling save after it
+func (h *ClusterHandler) upgradeCluster(ctx context.Context, clusterID uint, k8sVersion string, checkpoint *upgradeCheckpoint, save func(), progress *provisionProgress) error {
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		h.logError(clusterID, "Failed to get provisioner", err)
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+
+	var nodes []db.Node
+	if err := h.store.DB().Where("cluster_id = ?", clusterID).Order("id").Find(&nodes).Error; err != nil {
+		h.logError(clusterID, "Failed to load cluster nodes", err)
+		return err
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Role == "control-plane" && nodes[j].Role != "control-plane"
+	})
+
+	timeouts := provision.DefaultStepTimeouts()
+	skipHostKeyCheck := skipsHostKeyCheck(h.store.DB(), clusterID)
+
+	h.setClusterStatus(clusterID, db.ClusterUpgrading, "")
+	h.logEvent(clusterID, "info", "localhost", "upgrade", "Upgrading cluster to "+k8sVersion)
+
+	for _, node := range nodes {
+		if containsString(checkpoint.UpgradedHosts, node.Address) {
+			progress.advance("upgrade", 1)
+			continue
+		}
+
+		host := nodeHostSpec(node, skipHostKeyCheck)
+		first := len(checkpoint.UpgradedHosts) == 0
+		h.store.DB().Model(&node).Update("status", "upgrading")
+		err := provision.RunStep(ctx, "upgrade "+host.Address, timeouts.Upgrade, func(ctx context.Context) error {
+			return provisioner.UpgradeNode(ctx, host, k8sVersion, first)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.store.DB().Model(&node).Update("status", "unknown")
+			h.logError(clusterID, "Failed to upgrade node "+host.Address, err)
+			return err
+		}
+
+		h.store.DB().Model(&node).Updates(map[string]interface{}{"status": "ready", "k8s_version": k8sVersion})
+		checkpoint.UpgradedHosts = append(checkpoint.UpgradedHosts, node.Address)
+		save()
+		progress.advance("upgrade", 1)
+	}
+
+	h.store.DB().Model(&db.Cluster{}).Where("id = ?", clusterID).Update("k8s_version", k8sVersion)
+	return nil
+}
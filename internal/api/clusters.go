@@ -3,59 +3,346 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/bmc"
 	"kubeforge/internal/db"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
 	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
 )
 
+// ClusterDetail is a cluster with the capacity and usage of its nodes
+type ClusterDetail struct {
+	db.Cluster
+	Resources *ClusterResources `json:"resources,omitempty"` // latest metrics sample, if any
+}
+
 // CreateClusterRequest represents the request to create a new cluster
 type CreateClusterRequest struct {
 	Name             string                `json:"name"`
+	ProjectID        uint                  `json:"project_id,omitempty"`
 	K8sVersion       string                `json:"k8s_version"`
 	PodNetworkCIDR   string                `json:"pod_network_cidr"`
 	ServiceCIDR      string                `json:"service_cidr"`
 	CNI              string                `json:"cni"`
 	ContainerRuntime string                `json:"container_runtime"`
+	Provider         string                `json:"provider,omitempty"` // provisioner installing Kubernetes, kubeadm or a plugin
 	APIServerEndpoint string               `json:"api_server_endpoint,omitempty"`
 	ControlPlanes    []provision.HostSpec  `json:"control_planes"`
 	Workers          []provision.HostSpec  `json:"workers"`
+	Addons           []provision.AddonSpec `json:"addons,omitempty"`
+	Timeouts         *provision.StepTimeouts `json:"timeouts,omitempty"`
+	Notifications    []string              `json:"notifications,omitempty"` // channels told when provisioning or an upgrade completes or fails
+	Labels           map[string]string     `json:"labels,omitempty"`
+	Annotations      map[string]string     `json:"annotations,omitempty"`
+
+	InsecureSkipHostKeyCheck bool `json:"insecure_skip_host_key_check,omitempty"` // accept any SSH host key, for lab environments
+
+	AutoUpgrade       string                `json:"auto_upgrade,omitempty"`       // patch to upgrade to new patch releases automatically
+	MaintenanceWindow *db.MaintenanceWindow `json:"maintenance_window,omitempty"` // when automatic upgrades may start
+
+	Bastion *provision.HostSpec `json:"bastion,omitempty"` // jump host of the hosts without their own
+
+	TemplateID    uint   `json:"template_id,omitempty"`    // template filling the fields left empty
+	KubeadmConfig string `json:"kubeadm_config,omitempty"` // kubeadm configuration documents kubeadm init runs with
+
+	HardeningProfile string `json:"hardening_profile,omitempty"` // baseline or cis settings applied during provisioning
+
+	Hooks []provision.HookSpec `json:"hooks,omitempty"` // site specific scripts and webhooks run during provisioning
+
+	Infrastructure *infra.Spec `json:"infrastructure,omitempty"` // provider creating the machines of hosts with a machine spec
+
+	// Free inventory hosts added to the control planes and workers
+	ControlPlaneHosts *HostSelector `json:"control_plane_hosts,omitempty"`
+	WorkerHosts       *HostSelector `json:"worker_hosts,omitempty"`
+}
+
+// Spec builds the cluster spec described by the request
+func (req *CreateClusterRequest) Spec() provision.ClusterSpec {
+	spec := provision.ClusterSpec{
+		Name:              req.Name,
+		ControlPlanes:     append([]provision.HostSpec(nil), req.ControlPlanes...),
+		Workers:           append([]provision.HostSpec(nil), req.Workers...),
+		K8sVersion:        req.K8sVersion,
+		PodNetworkCIDR:    req.PodNetworkCIDR,
+		ServiceCIDR:       req.ServiceCIDR,
+		CNI:               req.CNI,
+		ContainerRuntime:  req.ContainerRuntime,
+		APIServerEndpoint: req.APIServerEndpoint,
+		Addons:            req.Addons,
+		Timeouts:          req.Timeouts,
+		KubeadmConfig:     req.KubeadmConfig,
+		HardeningProfile:  req.HardeningProfile,
+		Hooks:             req.Hooks,
+		Infrastructure:    req.Infrastructure,
+	}
+	if req.Bastion != nil {
+		for _, hosts := range [][]provision.HostSpec{spec.ControlPlanes, spec.Workers} {
+			for i := range hosts {
+				if hosts[i].Bastion == nil {
+					bastion := *req.Bastion
+					hosts[i].Bastion = &bastion
+				}
+			}
+		}
+	}
+	return spec
+}
+
+// Validate checks the request and returns every invalid field, looking up
+// the notification channels it names in conn
+func (req *CreateClusterRequest) Validate(conn *gorm.DB) validation.Errors {
+	spec := req.Spec()
+	spec.SetDefaults()
+	errs := spec.ValidateFields()
+
+	for i, addon := range req.Addons {
+		path := validation.Index("addons", i)
+		if _, err := addons.GetAddon(addon.Name); err != nil {
+			errs.Add(validation.Path(path, "name"), validation.CodeUnsupported, err.Error())
+			continue
+		}
+		if err := addons.ValidateOptions(addon.Name, addons.InstallOptions{Version: addon.Version, Config: addon.Config}); err != nil {
+			errs.Add(validation.Path(path, "config"), validation.CodeInvalid, err.Error())
+			continue
+		}
+		if _, _, err := sealAddonConfig(addon.Config); err != nil {
+			errs.Add(validation.Path(path, "config"), validation.CodeInvalid, err.Error())
+		}
+	}
+	if req.Provider != "" && !slices.Contains(provision.ListProvisioners(), req.Provider) {
+		errs.Add("provider", validation.CodeUnsupported, fmt.Sprintf("unsupported provider %q, expected one of %s", req.Provider, strings.Join(provision.ListProvisioners(), ", ")))
+	}
+	errs = append(errs, validateLabels("labels", req.Labels)...)
+	errs = append(errs, validateAnnotations("annotations", req.Annotations)...)
+	errs = append(errs, validateNotifications(conn, "notifications", req.Notifications)...)
+	errs = append(errs, validateAutoUpgrade(req.AutoUpgrade, req.MaintenanceWindow)...)
+	return errs
+}
+
+// Lint returns the warnings about the spec of the request, see
+// provision.ClusterSpec.Lint, and about inventory hosts of different
+// architectures, read from conn
+func (req *CreateClusterRequest) Lint(conn *gorm.DB) validation.Errors {
+	spec := req.Spec()
+	spec.SetDefaults()
+	warnings := spec.Lint()
+
+	// Only the architecture of inventory hosts is known before provisioning
+	first := ""
+	for _, group := range []struct {
+		field string
+		hosts []provision.HostSpec
+	}{{"control_planes", spec.ControlPlanes}, {"workers", spec.Workers}} {
+		for i, host := range group.hosts {
+			var inventory db.Host
+			if host.HostID == 0 || conn.Select("arch").First(&inventory, host.HostID).Error != nil || inventory.Arch == "" {
+				continue
+			}
+			if first == "" {
+				first = inventory.Arch
+			} else if inventory.Arch != first {
+				warnings.Add(validation.Path(validation.Index(group.field, i), "host_id"), validation.CodeArchitecture,
+					fmt.Sprintf("host runs %s while other hosts run %s, workloads need images built for both", inventory.Arch, first))
+			}
+		}
+	}
+	return warnings
+}
+
+// resolveSSHKeys checks that the stored SSH keys referenced by hosts and
+// their bastions exist in the project and returns the IDs of the keys of the
+// hosts, 0 for hosts without one
+func resolveSSHKeys(conn *gorm.DB, projectID uint, field string, hosts []provision.HostSpec) ([]uint, validation.Errors) {
+	var errs validation.Errors
+	ids := make([]uint, len(hosts))
+	for i, host := range hosts {
+		id, hostErrs := resolveHostSSHKeys(conn, projectID, validation.Index(field, i), host)
+		ids[i], errs = id, append(errs, hostErrs...)
+	}
+	return ids, errs
+}
+
+// resolveHostSSHKeys checks the stored SSH keys referenced by a host and its
+// bastions and returns the ID of the key of the host. Field paths are
+// relative to prefix.
+func resolveHostSSHKeys(conn *gorm.DB, projectID uint, prefix string, host provision.HostSpec) (uint, validation.Errors) {
+	id, errs := resolveSSHKey(conn, projectID, prefix, host)
+	path := prefix
+	for bastion := host.Bastion; bastion != nil; bastion = bastion.Bastion {
+		path = validation.Path(path, "bastion")
+		_, bastionErrs := resolveSSHKey(conn, projectID, path, *bastion)
+		errs = append(errs, bastionErrs...)
+	}
+	return id, errs
+}
+
+// resolveSSHKey checks the stored SSH key referenced by a host, if any, and
+// returns its ID. Field paths are relative to prefix.
+func resolveSSHKey(conn *gorm.DB, projectID uint, prefix string, host provision.HostSpec) (uint, validation.Errors) {
+	var errs validation.Errors
+	if !host.StoredKey() {
+		return 0, nil
+	}
+	path := validation.Path(prefix, "ssh_key_name")
+	if host.SSHKeyID != 0 {
+		path = validation.Path(prefix, "ssh_key_id")
+	}
+	key, err := db.FindSSHKey(conn, host.SSHKeyID, host.SSHKeyName)
+	switch {
+	case err != nil || key.ProjectID != projectID:
+		errs.Add(path, validation.CodeInvalid, "SSH key not found in the project")
+	case host.SSHKeyID != 0 && host.SSHKeyName != "" && key.Name != host.SSHKeyName:
+		errs.Add(path, validation.CodeInvalid, fmt.Sprintf("SSH key %d is not named %s", key.ID, host.SSHKeyName))
+	case len(key.PrivateKey) == 0:
+		errs.Add(path, validation.CodeInvalid, "SSH key "+key.Name+" has no private key")
+	default:
+		return key.ID, provision.ValidatePrivateKey(prefix, key.PrivateKey, host.SSHKeyPassphrase)
+	}
+	return 0, errs
+}
+
+// CreateClusterResponse is the created cluster and the ID of the job
+// provisioning it
+type CreateClusterResponse struct {
+	db.Cluster
+	JobID    uint                    `json:"job_id"`
+	Warnings []validation.FieldError `json:"warnings,omitempty"` // spec lint warnings
 }
 
 // ClusterHandler handles cluster-related API requests
-type ClusterHandler struct{}
+type ClusterHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
 
-// NewClusterHandler creates a new cluster handler
-func NewClusterHandler() *ClusterHandler {
-	return &ClusterHandler{}
+// NewClusterHandler creates a new cluster handler and registers its job handlers
+func NewClusterHandler(store *db.Store, queue *jobs.Queue) *ClusterHandler {
+	h := &ClusterHandler{store: store, queue: queue}
+	queue.RegisterHandler("provision", trackJob(h.runProvisionJob))
+	queue.RegisterHandler("upgrade", trackJob(h.runUpgradeJob))
+	queue.RegisterHandler("reconcile", trackJob(h.runReconcileJob))
+	queue.RegisterHandler("add-node", trackJob(h.runAddNodesJob))
+	queue.RegisterHandler("drain", trackJob(h.runDrainJob))
+	queue.RegisterHandler("maintenance", trackJob(h.runMaintenanceJob))
+	queue.RegisterHandler("scan", trackJob(h.runScanJob))
+	queue.RegisterHandler("vulnerability-scan", trackJob(h.runVulnerabilityScanJob))
+	queue.RegisterHandler("conformance", trackJob(h.runConformanceJob))
+	queue.RegisterHandler("renew-certs", trackJob(h.runRenewCertsJob))
+	queue.RegisterHandler(jobDeleteMachines, trackJob(h.runDeleteMachinesJob))
+	return h
 }
 
 // RegisterRoutes registers cluster API routes
 func (h *ClusterHandler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/api/clusters", h.ListClusters).Methods("GET")
-	router.HandleFunc("/api/clusters", h.CreateCluster).Methods("POST")
-	router.HandleFunc("/api/clusters/{id}", h.GetCluster).Methods("GET")
-	router.HandleFunc("/api/clusters/{id}", h.DeleteCluster).Methods("DELETE")
-	router.HandleFunc("/api/clusters/{id}/nodes", h.AddNode).Methods("POST")
-	router.HandleFunc("/api/clusters/{id}/nodes/{nodeId}", h.RemoveNode).Methods("DELETE")
-	router.HandleFunc("/api/clusters/{id}/kubeconfig", h.GetKubeconfig).Methods("GET")
-	router.HandleFunc("/api/clusters/{id}/events", h.GetEvents).Methods("GET")
+	router.HandleFunc("/api/v1/summary", h.GetSummary).Methods("GET")
+	router.HandleFunc("/api/v1/clusters", h.ListClusters).Methods("GET")
+	router.HandleFunc("/api/v1/clusters", h.CreateCluster).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}", h.GetCluster).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/overview", h.GetClusterOverview).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}", h.UpdateCluster).Methods("PATCH")
+	router.HandleFunc("/api/v1/clusters/{id}", h.DeleteCluster).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/spec", h.ApplySpec).Methods("PUT")
+	router.HandleFunc("/api/v1/clusters/{id}/force-state", h.ForceState).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/restore", h.RestoreCluster).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/revisions", h.ListRevisions).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/revisions/{revision}", h.GetRevision).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/revisions/{revision}/diff", h.DiffRevision).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/revisions/{revision}/rollback", h.RollbackRevision).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/pools", h.ListPools).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/pools", h.CreatePool).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/pools/{name}", h.GetPool).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/pools/{name}", h.UpdatePool).Methods("PUT")
+	router.HandleFunc("/api/v1/clusters/{id}/pools/{name}", h.DeletePool).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/pools/{name}/scale", h.ScalePool).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes", h.ListNodes).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes", h.AddNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}", h.UpdateNode).Methods("PATCH")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}", h.RemoveNode).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/maintenance", h.PatchNodes).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/replace", h.ReplaceNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/cordon", h.CordonNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/uncordon", h.UncordonNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/drain", h.DrainNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/power", h.GetNodePower).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/power", h.SetNodePower).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/bmc", h.SetNodeBMC).Methods("PUT")
+	router.HandleFunc("/api/v1/clusters/{id}/nodes/{nodeId}/bmc", h.DeleteNodeBMC).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/kubeconfig", h.GetKubeconfig).Methods("GET")
+	router.HandleFunc("/api/v1/kubeconfig", h.GetKubeconfigBundle).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/credentials", h.ListCredentials).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/credentials", h.CreateCredential).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/credentials", h.RevokeCredentials).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/credentials/{credentialId}", h.RevokeCredential).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/export", h.ExportCluster).Methods("GET")
+	router.PathPrefix("/api/v1/clusters/{id}/proxy/").HandlerFunc(h.ProxyCluster)
+	router.HandleFunc("/api/v1/clusters/{id}/namespaces", h.ListNamespaces).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/deployments", h.ListDeployments).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/pods", h.ListPods).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/pvcs", h.ListPVCs).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/metrics", h.GetMetrics).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/scans", h.ListScans).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/scans", h.CreateScan).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/scans/{scanId}", h.GetScan).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/scans/{scanId}/diff", h.DiffScan).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/vulnerability-scans", h.ListVulnerabilityScans).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/vulnerability-scans", h.CreateVulnerabilityScan).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/vulnerability-scans/{scanId}", h.GetVulnerabilityScan).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/conformance", h.ListConformanceRuns).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/conformance", h.CreateConformanceRun).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/conformance/{runId}", h.GetConformanceRun).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/conformance/{runId}/results", h.GetConformanceResults).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/security", h.GetSecurity).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/renew-certs", h.RenewCertificates).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/cloud-init", h.GetCloudInit).Methods("GET")
+	router.HandleFunc("/api/v1/nodes/register", h.RegisterNode).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/events", h.GetEvents).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/steps", h.GetSteps).Methods("GET")
 }
 
-// ListClusters lists all clusters
+// ListClusters lists the clusters of the caller's projects, filtered by
+// ?label=key=value (repeatable, all must match) and ?q=, a part of the
+// name matched regardless of case
 func (h *ClusterHandler) ListClusters(w http.ResponseWriter, r *http.Request) {
-	var clusters []db.Cluster
+	query := r.URL.Query()
+	labels, err := parseLabelFilter(query["label"])
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
 
-	result := db.DB.Preload("Nodes").Find(&clusters)
-	if result.Error != nil {
+	filter := db.ClusterFilter{ProjectIDs: projectFilter(h.store.DB(), r), WithNodes: true}
+	filter.IncludeDeleted, _ = strconv.ParseBool(query.Get("include_deleted"))
+	clusters, err := h.store.Clusters.List(filter)
+	if err != nil {
 		WriteInternalError(w, "Failed to retrieve clusters")
 		return
 	}
 
-	WriteSuccess(w, clusters)
+	search := strings.ToLower(query.Get("q"))
+	matching := make([]db.Cluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		if hasLabels(cluster.Labels, labels) && strings.Contains(strings.ToLower(cluster.Name), search) {
+			matching = append(matching, cluster)
+		}
+	}
+	WriteSuccess(w, matching)
 }
 
 // GetCluster retrieves a single cluster by ID
@@ -67,52 +354,135 @@ func (h *ClusterHandler) GetCluster(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var cluster db.Cluster
-	result := db.DB.Preload("Nodes").Preload("Events").First(&cluster, id)
-	if result.Error != nil {
+	cluster, err := h.store.Clusters.GetDetails(uint(id))
+	if err != nil {
 		WriteNotFound(w, "Cluster not found")
 		return
 	}
 
-	WriteSuccess(w, cluster)
+	WriteSuccess(w, ClusterDetail{Cluster: *cluster, Resources: latestResources(h.store.DB(), cluster.ID)})
 }
 
 // CreateCluster creates a new cluster
 func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
 	var req CreateClusterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := ParseJSON(r, &req); err != nil {
 		WriteBadRequest(w, "Invalid request body")
 		return
 	}
 
-	// Validate request
-	if req.Name == "" {
-		WriteBadRequest(w, "Cluster name is required")
+	// Fill the fields left empty from the template
+	if req.TemplateID != 0 {
+		template, err := loadTemplateSpec(h.store.DB(), req.TemplateID)
+		if err != nil {
+			var errs validation.Errors
+			errs.Add("template_id", validation.CodeInvalid, "template not found")
+			WriteValidationError(w, errs)
+			return
+		}
+		template.applyTo(&req)
+	}
+
+	// Then from the defaults of the cluster policy
+	policy, _, err := loadClusterPolicy(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve policy")
+		return
+	}
+	policy.Defaults.applyTo(&req)
+
+	// Resolve the project and require operator access to it
+	if req.ProjectID == 0 {
+		req.ProjectID = defaultClusterProject(h.store.DB(), r)
+	}
+	switch role := projectRole(h.store.DB(), CurrentUser(r), req.ProjectID); {
+	case role == "":
+		WriteNotFound(w, "Project not found")
+		return
+	case !auth.HasRole(role, auth.RoleOperator):
+		WriteError(w, http.StatusForbidden, "FORBIDDEN", "This action requires the operator role")
+		return
+	}
+
+	// A retried request with the same Idempotency-Key gets the cluster it
+	// created instead of a second one. Its inventory hosts are the ones the
+	// cluster runs on.
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if idempotencyKey != "" {
+		if existing, err := h.store.Clusters.FindByIdempotencyKey(req.ProjectID, idempotencyKey); err == nil {
+			resolveInventoryHosts(h.store.DB(), req.ProjectID, existing.ID, &req)
+			h.replayCreate(w, *existing, req)
+			return
+		}
+	}
+
+	// Fill in the hosts taken from the inventory of the project
+	if errs := resolveInventoryHosts(h.store.DB(), req.ProjectID, 0, &req); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	// Validate request. New clusters must run a supported release, existing
+	// ones keep theirs past its end of life.
+	errs := req.Validate(h.store.DB())
+	if _, err := provision.ParseVersion(req.K8sVersion); err == nil {
+		if err := provision.ValidateVersion(req.K8sVersion); err != nil {
+			errs.Add("k8s_version", validation.CodeUnsupported, err.Error())
+		}
+	}
+	var project db.Project
+	h.store.DB().First(&project, req.ProjectID)
+	errs = append(errs, policy.Check(&req, project.Name)...)
+	warnings := req.Lint(h.store.DB())
+	if len(errs) > 0 {
+		WriteSpecValidationError(w, errs, warnings)
 		return
 	}
-	if len(req.ControlPlanes) == 0 {
-		WriteBadRequest(w, "At least one control plane is required")
+
+	// Referenced SSH keys must be stored in the cluster's project
+	spec := req.Spec()
+	controlPlaneKeys, errs := resolveSSHKeys(h.store.DB(), req.ProjectID, "control_planes", spec.ControlPlanes)
+	workerKeys, workerErrs := resolveSSHKeys(h.store.DB(), req.ProjectID, "workers", spec.Workers)
+	if errs = append(errs, workerErrs...); len(errs) > 0 {
+		WriteValidationError(w, errs)
 		return
 	}
 
+	provider := req.Provider
+	if provider == "" {
+		provider = provision.DefaultProvisioner
+	}
+
 	// Create cluster record
 	cluster := db.Cluster{
 		Name:             req.Name,
+		ProjectID:        req.ProjectID,
 		K8sVersion:       req.K8sVersion,
 		PodNetworkCIDR:   req.PodNetworkCIDR,
 		ServiceCIDR:      req.ServiceCIDR,
 		CNI:              req.CNI,
 		ContainerRuntime: req.ContainerRuntime,
 		APIServerEndpoint: req.APIServerEndpoint,
-		Provider:         "kubeadm",
-		Status:           "pending",
+		Notifications:    req.Notifications,
+		Labels:           req.Labels,
+		Annotations:      req.Annotations,
+		InsecureSkipHostKeyCheck: req.InsecureSkipHostKeyCheck,
+		AutoUpgrade:      req.AutoUpgrade,
+		MaintenanceWindow: req.MaintenanceWindow,
+		KubeadmConfig:    req.KubeadmConfig,
+		HardeningProfile: req.HardeningProfile,
+		TemplateID:       req.TemplateID,
+		Provider:         provider,
+		IdempotencyKey:   idempotencyKey,
 		CreatedAt:        time.Now(),
 		UpdatedAt:        time.Now(),
 	}
 
 	// Set defaults
 	if cluster.K8sVersion == "" {
-		cluster.K8sVersion = "1.28.0"
+		cluster.K8sVersion = provision.DefaultVersion()
 	}
 	if cluster.PodNetworkCIDR == "" {
 		cluster.PodNetworkCIDR = "10.244.0.0/16"
@@ -128,158 +498,572 @@ func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save to database
-	if err := db.DB.Create(&cluster).Error; err != nil {
+	taken, err := h.store.Clusters.NameTaken(cluster.Name)
+	if err != nil {
 		WriteInternalError(w, "Failed to create cluster")
 		return
 	}
+	if taken {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster name already in use")
+		return
+	}
+	// The cluster, its nodes, addons, provisioning job and first revision are
+	// created together, so a failure leaves nothing behind. Addons and
+	// revisions have no store of their own and are written through tx.
+	payload, _ := json.Marshal(req)
+	job := db.Job{
+		Type:        "provision",
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	err = h.store.DB().Transaction(func(tx *gorm.DB) error {
+		store := db.NewStore(tx)
+		if err := store.Clusters.Create(&cluster); err != nil {
+			return err
+		}
 
-	// Create node records
-	for _, cp := range req.ControlPlanes {
-		node := db.Node{
-			ClusterID: cluster.ID,
-			Hostname:  cp.Hostname,
-			Address:   cp.Address,
-			User:      cp.User,
-			SSHKeyPath: cp.SSHKeyPath,
-			Port:      cp.Port,
-			Role:      "control-plane",
-			Status:    "provisioning",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		// Create node records
+		for i, cp := range spec.ControlPlanes {
+			node := newNodeRecord(cluster.ID, cp, controlPlaneKeys[i], "control-plane")
+			if err := store.Nodes.Create(&node); err != nil {
+				return err
+			}
 		}
-		if node.Port == 0 {
-			node.Port = 22
+		for i, worker := range spec.Workers {
+			node := newNodeRecord(cluster.ID, worker, workerKeys[i], "worker")
+			if err := store.Nodes.Create(&node); err != nil {
+				return err
+			}
 		}
-		db.DB.Create(&node)
+
+		// Create addon records, installed once provisioning completes
+		for _, spec := range req.Addons {
+			config, credentials, err := sealAddonConfig(spec.Config)
+			if err != nil {
+				return err
+			}
+			addon := db.Addon{
+				ClusterID:   cluster.ID,
+				Name:        spec.Name,
+				Version:     spec.Version,
+				Status:      "pending",
+				Config:      config,
+				Credentials: credentials,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+			}
+			if err := tx.Create(&addon).Error; err != nil {
+				return err
+			}
+		}
+
+		// Queue a job for async provisioning
+		job.ClusterID = cluster.ID
+		if err := h.queue.EnqueueTx(store.Jobs, &job); err != nil {
+			return err
+		}
+		return saveRevision(tx, cluster.ID, req, revisionCreate, 0, job.ID, r)
+	})
+	if err != nil {
+		// Another request took the name since it was checked
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "Cluster name already in use")
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to create cluster", "cluster", cluster.Name, "error", err)
+		WriteInternalError(w, "Failed to create cluster")
+		return
+	}
+	h.queue.Notify()
+
+	// Return created cluster, provisioning continues in the background
+	if created, err := h.store.Clusters.GetWithNodes(cluster.ID); err == nil {
+		cluster = *created
+	}
+	WriteAccepted(w, jobLocation(job.ID), CreateClusterResponse{Cluster: cluster, JobID: job.ID, Warnings: warnings})
+}
+
+// IdempotencyKeyHeader makes cluster creation safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// replayCreate answers a create request repeated with the Idempotency-Key of
+// an existing cluster like the original request, provided it is the same
+// request
+func (h *ClusterHandler) replayCreate(w http.ResponseWriter, cluster db.Cluster, req CreateClusterRequest) {
+	var revision db.ClusterRevision
+	spec, _ := json.Marshal(req)
+	if err := h.store.DB().Where("cluster_id = ? AND revision = 1", cluster.ID).First(&revision).Error; err != nil || revision.Spec != string(spec) {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Idempotency key was already used for a different request")
+		return
+	}
+
+	// The first provision job is the one the request created
+	provisions, err := h.store.Jobs.List(db.JobFilter{ClusterID: cluster.ID, Type: "provision"})
+	if err != nil || len(provisions) == 0 {
+		WriteInternalError(w, "Failed to retrieve provisioning job")
+		return
+	}
+	job := provisions[len(provisions)-1]
+	if current, err := h.store.Clusters.GetWithNodes(cluster.ID); err == nil {
+		cluster = *current
 	}
+	WriteAccepted(w, jobLocation(job.ID), CreateClusterResponse{Cluster: cluster, JobID: job.ID})
+}
+
+// Provisioning phases recorded in the job checkpoint, in order
+const (
+	phaseMachines       = "machines-created"
+	phasePrepared       = "prepared"
+	phaseBootstrapped   = "bootstrapped"
+	phaseCNIInstalled   = "cni-installed"
+	phaseJoined         = "joined"
+	phaseNetworkChecked = "network-checked"
+)
 
-	for _, worker := range req.Workers {
-		node := db.Node{
-			ClusterID: cluster.ID,
-			Hostname:  worker.Hostname,
-			Address:   worker.Address,
-			User:      worker.User,
-			SSHKeyPath: worker.SSHKeyPath,
-			Port:      worker.Port,
-			Role:      "worker",
-			Status:    "provisioning",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+var provisionPhases = []string{phaseMachines, phasePrepared, phaseBootstrapped, phaseCNIInstalled, phaseJoined, phaseNetworkChecked}
+
+// provisionCheckpoint is the resume state of a provision job
+type provisionCheckpoint struct {
+	Phase          string   `json:"phase,omitempty"`
+	JoinCommand    string   `json:"join_command,omitempty"`
+	CertificateKey string   `json:"certificate_key,omitempty"`
+	PreparedHosts  []string `json:"prepared_hosts,omitempty"`
+	JoinedHosts    []string `json:"joined_hosts,omitempty"`
+	Hooks          []string `json:"hooks,omitempty"` // completed hook runs, see hookRun
+	CNIFailed      bool     `json:"cni_failed,omitempty"` // the network check is skipped
+
+	Machines map[string]infra.Machine `json:"machines,omitempty"` // created machines by hostname
+}
+
+// reached reports whether the given phase has already been completed
+func (c *provisionCheckpoint) reached(phase string) bool {
+	if c.Phase == "" {
+		return false
+	}
+	for _, p := range provisionPhases {
+		if p == phase {
+			return true
 		}
-		if node.Port == 0 {
-			node.Port = 22
+		if p == c.Phase {
+			return false
 		}
-		db.DB.Create(&node)
 	}
+	return false
+}
 
-	// Create a job for async provisioning
-	job := db.Job{
-		ClusterID: cluster.ID,
-		Type:      "provision",
-		Status:    "pending",
-		Progress:  0,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// prepared reports whether a host has already been prepared
+func (c *provisionCheckpoint) prepared(address string) bool {
+	return containsString(c.PreparedHosts, address)
+}
+
+// joined reports whether a host has already joined the cluster
+func (c *provisionCheckpoint) joined(address string) bool {
+	return containsString(c.JoinedHosts, address)
+}
+
+// Relative weights of the provisioning steps used to compute job progress
+const (
+	weightMachine     = 4
+	weightPrepareHost = 4
+	weightBootstrap   = 4
+	weightCNI         = 2
+	weightJoinHost    = 2
+	weightAddon       = 1
+)
+
+// provisionProgress tracks the weighted progress of a provision job and
+// reports it on the job record and over the WebSocket hub
+type provisionProgress struct {
+	queue *jobs.Queue
+	job   *db.Job
+	total int
+	done  int
+}
+
+// newProvisionProgress computes the total weight of provisioning a cluster
+func newProvisionProgress(queue *jobs.Queue, job *db.Job, spec provision.ClusterSpec) *provisionProgress {
+	hosts := len(spec.ControlPlanes) + len(spec.Workers)
+	total := hosts*weightPrepareHost + weightBootstrap + weightCNI +
+		(hosts-1)*weightJoinHost + len(spec.Addons)*weightAddon
+	for _, group := range [][]provision.HostSpec{spec.ControlPlanes, spec.Workers} {
+		for _, host := range group {
+			if host.Machine != nil {
+				total += weightMachine
+			}
+		}
 	}
-	db.DB.Create(&job)
+	return &provisionProgress{queue: queue, job: job, total: total}
+}
 
-	// Start provisioning in background (async)
-	go h.provisionCluster(cluster.ID, req)
+// advance marks units of work as done within a phase and reports the new progress
+func (p *provisionProgress) advance(phase string, units int) {
+	p.done += units
+	p.report(phase)
+}
 
-	// Return created cluster
-	db.DB.Preload("Nodes").First(&cluster, cluster.ID)
-	WriteCreated(w, cluster)
+// report publishes the current progress for a phase
+func (p *provisionProgress) report(phase string) {
+	progress := 0
+	if p.total > 0 {
+		progress = p.done * 100 / p.total
+	}
+	// 100% is reserved for the completed job
+	if progress > 99 {
+		progress = 99
+	}
+	if err := p.queue.UpdateProgress(p.job.ID, phase, progress); err != nil {
+		slog.Error("Failed to update job progress", "job_id", p.job.ID, "error", err)
+	}
+	Hub.BroadcastProgress(p.job.ClusterID, JobProgress{
+		JobID:     p.job.ID,
+		ClusterID: p.job.ClusterID,
+		Phase:     phase,
+		Progress:  progress,
+	})
 }
 
-// provisionCluster provisions the cluster asynchronously
-func (h *ClusterHandler) provisionCluster(clusterID uint, req CreateClusterRequest) {
-	ctx := context.Background()
+// runProvisionJob executes a queued provision job
+func (h *ClusterHandler) runProvisionJob(ctx context.Context, job *db.Job) error {
+	var req CreateClusterRequest
+	if err := json.Unmarshal([]byte(job.Payload), &req); err != nil {
+		h.logError(job.ClusterID, "Invalid provisioning job payload", err)
+		return err
+	}
+
+	var checkpoint provisionCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(job.ClusterID, "Invalid provisioning checkpoint, starting over", err)
+		checkpoint = provisionCheckpoint{}
+	}
+
+	err := h.provisionCluster(ctx, job, req, &checkpoint)
+	// Cancelled and interrupted jobs have not ended yet from the user's view
+	if ctx.Err() == nil {
+		notifyJob(h.store.DB(), job, "provisioning", err)
+	}
+	return err
+}
+
+// provisionCluster provisions the cluster, skipping the phases and hosts
+// already completed according to the checkpoint
+func (h *ClusterHandler) provisionCluster(ctx context.Context, job *db.Job, req CreateClusterRequest, checkpoint *provisionCheckpoint) error {
+	clusterID := job.ClusterID
+	save := func(phase string) {
+		if phase != "" {
+			checkpoint.Phase = phase
+		}
+		if err := h.queue.SaveCheckpoint(job.ID, checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save provisioning checkpoint", err)
+		}
+	}
+
+	if checkpoint.Phase != "" {
+		h.logEvent(clusterID, "info", "localhost", "resume", "Resuming provisioning after phase "+checkpoint.Phase)
+	}
 
 	// Update cluster status
-	db.DB.Model(&db.Cluster{}).Where("id = ?", clusterID).Update("status", "provisioning")
+	h.setClusterStatus(clusterID, db.ClusterProvisioning, "")
 
 	// Get provisioner
-	provisioner, err := provision.GetProvisioner("kubeadm", nil)
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
 	if err != nil {
 		h.logError(clusterID, "Failed to get provisioner", err)
-		return
+		return err
 	}
+	streamProvisioner(provisioner, clusterID)
 
 	// Build ClusterSpec
-	spec := provision.ClusterSpec{
-		Name:             req.Name,
-		ControlPlanes:    req.ControlPlanes,
-		Workers:          req.Workers,
-		K8sVersion:       req.K8sVersion,
-		PodNetworkCIDR:   req.PodNetworkCIDR,
-		ServiceCIDR:      req.ServiceCIDR,
-		CNI:              req.CNI,
-		ContainerRuntime: req.ContainerRuntime,
-		APIServerEndpoint: req.APIServerEndpoint,
+	spec := req.Spec()
+	if skipsHostKeyCheck(h.store.DB(), clusterID) {
+		for _, hosts := range [][]provision.HostSpec{spec.ControlPlanes, spec.Workers} {
+			for i := range hosts {
+				hosts[i].SetInsecureSkipHostKeyCheck(true)
+			}
+		}
 	}
 
 	// Validate spec
 	if err := provisioner.ValidateSpec(&spec); err != nil {
 		h.logError(clusterID, "Invalid cluster spec", err)
-		return
+		return err
+	}
+
+	progress := newProvisionProgress(h.queue, job, spec)
+
+	timeouts := provision.DefaultStepTimeouts()
+	if spec.Timeouts != nil {
+		timeouts = spec.Timeouts.Merge(timeouts)
+	}
+
+	// Create the machines of hosts with a machine spec
+	if err := h.createMachines(ctx, clusterID, &spec, checkpoint, save, timeouts, progress); err != nil {
+		return err
 	}
 
 	// Prepare all hosts
-	allHosts := append(spec.ControlPlanes, spec.Workers...)
-	h.logEvent(clusterID, "info", "localhost", "prepare", "Preparing hosts")
+	if !checkpoint.reached(phasePrepared) {
+		allHosts := append(spec.ControlPlanes, spec.Workers...)
+		h.logEvent(clusterID, "info", "localhost", "prepare", "Preparing hosts")
+		progress.report("prepare")
 
-	if err := provisioner.PrepareHosts(ctx, allHosts, spec.ContainerRuntime, spec.K8sVersion); err != nil {
-		h.logError(clusterID, "Failed to prepare hosts", err)
-		return
+		var remaining []provision.HostSpec
+		for _, host := range allHosts {
+			if checkpoint.prepared(host.Address) {
+				progress.advance("prepare", weightPrepareHost)
+				continue
+			}
+			remaining = append(remaining, host)
+		}
+
+		// Hosts are prepared in parallel, one at a time per call so each is
+		// checkpointed as soon as it is ready
+		var mu sync.Mutex
+		err := provision.ForEachHost(ctx, remaining, func(ctx context.Context, host provision.HostSpec) error {
+			// Hosts that are not prepared yet run their pre-prepare hooks
+			// again when the job resumes
+			if err := h.runHooks(ctx, clusterID, spec, provision.HookPrePrepare, nil, nil, host); err != nil {
+				return err
+			}
+			err := provision.RunStep(ctx, "prepare "+host.Address, timeouts.Prepare, func(ctx context.Context) error {
+				return provisioner.PrepareHosts(ctx, []provision.HostSpec{host}, spec.ContainerRuntime, spec.K8sVersion)
+			})
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			checkpoint.PreparedHosts = append(checkpoint.PreparedHosts, host.Address)
+			save("")
+			progress.advance("prepare", weightPrepareHost)
+			return nil
+		})
+		if err != nil {
+			h.logError(clusterID, "Failed to prepare hosts", err)
+			return err
+		}
+		save(phasePrepared)
+	} else {
+		progress.done += (len(spec.ControlPlanes) + len(spec.Workers)) * weightPrepareHost
 	}
 
 	// Bootstrap first control plane
-	h.logEvent(clusterID, "info", spec.ControlPlanes[0].Address, "bootstrap", "Bootstrapping control plane")
+	if !checkpoint.reached(phaseBootstrapped) {
+		h.logEvent(clusterID, "info", spec.ControlPlanes[0].Address, "bootstrap", "Bootstrapping control plane")
+		progress.report("bootstrap")
 
-	result, err := provisioner.BootstrapControlPlane(ctx, spec.ControlPlanes[0], spec)
-	if err != nil {
-		h.logError(clusterID, "Failed to bootstrap control plane", err)
-		return
-	}
+		var result *provision.ProvisionResult
+		err := provision.RunStep(ctx, "bootstrap", timeouts.Bootstrap, func(ctx context.Context) error {
+			var err error
+			result, err = provisioner.BootstrapControlPlane(ctx, spec.ControlPlanes[0], spec)
+			return err
+		})
+		if err != nil {
+			h.logError(clusterID, "Failed to bootstrap control plane", err)
+			return err
+		}
 
-	// Save kubeconfig and join command
-	db.DB.Model(&db.Cluster{}).Where("id = ?", clusterID).Updates(map[string]interface{}{
-		"kubeconfig":      result.Kubeconfig,
-		"join_command":    result.JoinCommand,
-		"certificate_key": result.CertificateKey,
-	})
+		// Save kubeconfig and join command, a struct update so they are encrypted
+		h.store.Clusters.Update(&db.Cluster{
+			ID:             clusterID,
+			Kubeconfig:     result.Kubeconfig,
+			JoinCommand:    result.JoinCommand,
+			CertificateKey: result.CertificateKey,
+		}, "kubeconfig", "join_command", "certificate_key")
+		checkpoint.JoinCommand = result.JoinCommand
+		checkpoint.CertificateKey = result.CertificateKey
+		save(phaseBootstrapped)
+	}
+	if err := h.runHooks(ctx, clusterID, spec, provision.HookPostBootstrap, checkpoint, save, spec.ControlPlanes[0]); err != nil {
+		h.logError(clusterID, "Post-bootstrap hook failed", err)
+		return err
+	}
+	progress.advance("bootstrap", weightBootstrap)
 
 	// Install CNI
-	h.logEvent(clusterID, "info", spec.ControlPlanes[0].Address, "cni", "Installing CNI")
-	if err := provisioner.InstallCNI(ctx, result.Kubeconfig, spec.CNI, spec.ControlPlanes[0]); err != nil {
-		h.logError(clusterID, "Failed to install CNI", err)
-		// Continue anyway, CNI can be installed manually
+	if !checkpoint.reached(phaseCNIInstalled) {
+		cluster, err := h.store.Clusters.Get(clusterID)
+		if err != nil {
+			h.logError(clusterID, "Failed to load kubeconfig", err)
+			return err
+		}
+
+		h.logEvent(clusterID, "info", spec.ControlPlanes[0].Address, "cni", "Installing CNI")
+		progress.report("cni")
+		err = provision.RunStep(ctx, "CNI install", timeouts.CNI, func(ctx context.Context) error {
+			return provisioner.InstallCNI(ctx, cluster.Kubeconfig, spec.CNI, spec.ControlPlanes[0])
+		})
+		if err != nil {
+			h.reportError(clusterID, "Failed to install CNI", err)
+			// Continue anyway, CNI can be installed manually
+			checkpoint.CNIFailed = true
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		save(phaseCNIInstalled)
 	}
+	progress.advance("cni", weightCNI)
+
+	if !checkpoint.reached(phaseJoined) {
+		// Post-join hooks run once a host is recorded as joined, so a
+		// resumed job runs those it did not complete
+		postJoin := func(host provision.HostSpec) error {
+			if err := h.runHooks(ctx, clusterID, spec, provision.HookPostJoin, checkpoint, save, host); err != nil {
+				h.logError(clusterID, "Post-join hook failed", err)
+				return err
+			}
+			return nil
+		}
 
-	// Join additional control planes
-	for i := 1; i < len(spec.ControlPlanes); i++ {
-		cp := spec.ControlPlanes[i]
-		h.logEvent(clusterID, "info", cp.Address, "join", "Joining control plane")
+		// Join additional control planes
+		for i := 1; i < len(spec.ControlPlanes); i++ {
+			cp := spec.ControlPlanes[i]
+			if checkpoint.joined(cp.Address) {
+				if err := postJoin(cp); err != nil {
+					return err
+				}
+				progress.advance("join", weightJoinHost)
+				continue
+			}
+			h.logEvent(clusterID, "info", cp.Address, "join", "Joining control plane")
 
-		if err := provisioner.JoinControlPlane(ctx, cp, result.JoinCommand, result.CertificateKey); err != nil {
-			h.logError(clusterID, "Failed to join control plane", err)
-			// Continue with other nodes
+			err := provision.RunStep(ctx, "join "+cp.Address, timeouts.Join, func(ctx context.Context) error {
+				// The API server of every control plane reads the same
+				// audit policy and encryption key
+				if spec.HardeningProfile == provision.HardeningCIS {
+					if err := provision.CopyHardeningFiles(ctx, spec.ControlPlanes[0], cp); err != nil {
+						return err
+					}
+				}
+				return provisioner.JoinControlPlane(ctx, cp, checkpoint.JoinCommand, checkpoint.CertificateKey)
+			})
+			if err == nil {
+				h.secureNodeFiles(ctx, clusterID, spec.HardeningProfile, cp)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				h.reportError(clusterID, "Failed to join control plane", err)
+				// Continue with other nodes
+			}
+			checkpoint.JoinedHosts = append(checkpoint.JoinedHosts, cp.Address)
+			save("")
+			if err == nil {
+				if err := postJoin(cp); err != nil {
+					return err
+				}
+			}
+			progress.advance("join", weightJoinHost)
 		}
+
+		// Join workers
+		for _, worker := range spec.Workers {
+			if checkpoint.joined(worker.Address) {
+				if err := postJoin(worker); err != nil {
+					return err
+				}
+				progress.advance("join", weightJoinHost)
+				continue
+			}
+			h.logEvent(clusterID, "info", worker.Address, "join", "Joining worker")
+
+			err := provision.RunStep(ctx, "join "+worker.Address, timeouts.Join, func(ctx context.Context) error {
+				return provisioner.JoinWorker(ctx, worker, checkpoint.JoinCommand)
+			})
+			if err == nil {
+				h.secureNodeFiles(ctx, clusterID, spec.HardeningProfile, worker)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				h.reportError(clusterID, "Failed to join worker", err)
+				// Continue with other nodes
+			}
+			checkpoint.JoinedHosts = append(checkpoint.JoinedHosts, worker.Address)
+			save("")
+			if err == nil {
+				if err := postJoin(worker); err != nil {
+					return err
+				}
+			}
+			progress.advance("join", weightJoinHost)
+		}
+		save(phaseJoined)
+	} else {
+		progress.done += (len(spec.ControlPlanes) + len(spec.Workers) - 1) * weightJoinHost
 	}
 
-	// Join workers
-	for _, worker := range spec.Workers {
-		h.logEvent(clusterID, "info", worker.Address, "join", "Joining worker")
+	// Test the pod network once every node joined, a broken network fails
+	// the cluster rather than its workloads later
+	if !checkpoint.reached(phaseNetworkChecked) {
+		switch {
+		case !provision.CurrentNetworkCheck().Enabled:
+		case checkpoint.CNIFailed:
+			h.logEvent(clusterID, "warn", spec.ControlPlanes[0].Address, "check-network", "Network check skipped, the CNI was not installed")
+		default:
+			progress.report("check-network")
+			err := provision.RunStep(ctx, "network check", timeouts.CNI, func(ctx context.Context) error {
+				_, err := provisioner.CheckNetwork(ctx, spec.ControlPlanes[0])
+				return err
+			})
+			if err != nil {
+				h.logError(clusterID, "Network connectivity check failed", err)
+				return err
+			}
+		}
+		save(phaseNetworkChecked)
+	}
 
-		if err := provisioner.JoinWorker(ctx, worker, result.JoinCommand); err != nil {
-			h.logError(clusterID, "Failed to join worker", err)
-			// Continue with other nodes
+	// Install requested addons, including any interrupted by a restart
+	var pending []db.Addon
+	h.store.DB().Where("cluster_id = ? AND status IN ?", clusterID, []string{"pending", "installing"}).Order("id").Find(&pending)
+	if installed := len(spec.Addons) - len(pending); installed > 0 {
+		progress.done += installed * weightAddon
+	}
+	for _, addon := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h.logEvent(clusterID, "info", spec.ControlPlanes[0].Address, "addon", "Installing addon "+addon.Name)
+		h.store.DB().Model(&addon).Update("status", "installing")
+		installAddon(h.store.DB(), ctx, addon)
+		progress.advance("addons", weightAddon)
+	}
+
+	allHosts := append(append([]provision.HostSpec(nil), spec.ControlPlanes...), spec.Workers...)
+	if err := h.runHooks(ctx, clusterID, spec, provision.HookPostProvision, checkpoint, save, allHosts...); err != nil {
+		h.logError(clusterID, "Post-provision hook failed", err)
+		return err
+	}
+
+	// Smoke test the cluster before it is reported ready
+	if provision.VerifyEnabled() {
+		progress.report("verify")
+		if err := h.verifyCluster(ctx, clusterID, provisioner, spec.ControlPlanes[0]); err != nil {
+			h.logError(clusterID, "Cluster verification failed", err)
+			return err
 		}
 	}
 
 	// Update cluster status
-	db.DB.Model(&db.Cluster{}).Where("id = ?", clusterID).Update("status", "ready")
+	h.setClusterStatus(clusterID, db.ClusterReady, "")
 	h.logEvent(clusterID, "info", "localhost", "complete", "Cluster provisioned successfully")
+	return nil
+}
+
+// secureNodeFiles restricts the permissions of the Kubernetes files of a
+// node that joined a cluster with a hardening profile. The node works
+// either way, so a failure is reported without failing the join.
+func (h *ClusterHandler) secureNodeFiles(ctx context.Context, clusterID uint, profile string, host provision.HostSpec) {
+	if profile == "" {
+		return
+	}
+	if err := provision.SecureNodeFiles(ctx, host); err != nil {
+		h.reportError(clusterID, "Failed to secure the files of "+host.Address, err)
+	}
 }
 
 // DeleteCluster deletes a cluster
@@ -293,18 +1077,74 @@ func (h *ClusterHandler) DeleteCluster(w http.ResponseWriter, r *http.Request) {
 
 	// TODO: Run kubeadm reset on all nodes before deleting
 
-	if err := db.DB.Delete(&db.Cluster{}, id).Error; err != nil {
+	// Jobs of the cluster must not keep working on its hosts while its
+	// machines are destroyed
+	if _, stopped, err := h.stopClusterJobs(r.Context(), uint(id)); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to cancel cluster jobs", "cluster_id", id, "error", err)
+		WriteInternalError(w, "Failed to cancel the jobs of the cluster")
+		return
+	} else if !stopped {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cancelled jobs of the cluster are still stopping, try again")
+		return
+	}
+
+	if err := h.store.Clusters.SetStatus(uint(id), db.ClusterDestroying, ""); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			WriteNotFound(w, "Cluster not found")
+			return
+		}
+		WriteInternalError(w, "Failed to delete cluster")
+		return
+	}
+
+	// Machines created for the cluster are deleted with it
+	machines, _ := h.store.Nodes.ListMachines(uint(id))
+
+	if err := h.store.Clusters.Delete(uint(id)); err != nil {
+		h.setClusterStatus(uint(id), db.ClusterFailed, "Failed to delete cluster: "+err.Error())
 		WriteInternalError(w, "Failed to delete cluster")
 		return
 	}
+	if len(machines) > 0 {
+		if err := h.enqueueDeleteMachines(r.Context(), uint(id), machines); err != nil {
+			slog.Error("Failed to queue the deletion of the machines of a deleted cluster", "cluster_id", id, "error", err)
+			recordEvent(uint(id), "warn", "localhost", "machine", "Failed to queue the deletion of the machines of the cluster: "+err.Error())
+		}
+	}
 
 	WriteSuccess(w, map[string]string{"message": "Cluster deleted"})
 }
 
-// AddNode adds a node to an existing cluster
-func (h *ClusterHandler) AddNode(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement
-	WriteError(w, http.StatusNotImplemented, "NOT_IMPLEMENTED", "Not yet implemented")
+// RestoreCluster brings back a deleted cluster that was not purged yet. It
+// is restored in the failed status, a reconcile brings it back to ready.
+func (h *ClusterHandler) RestoreCluster(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	if err := h.store.Clusters.Restore(uint(id)); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			WriteNotFound(w, "Cluster not found")
+		case errors.Is(err, db.ErrNotDeleted):
+			WriteError(w, http.StatusConflict, "CONFLICT", "Cluster is not deleted")
+		case errors.Is(err, db.ErrNameTaken):
+			WriteError(w, http.StatusConflict, "CONFLICT", "Another cluster uses the name of the cluster")
+		default:
+			WriteInternalError(w, "Failed to restore cluster")
+		}
+		return
+	}
+	h.logEvent(uint(id), "warn", "localhost", "restore", "Cluster restored after deletion")
+
+	cluster, err := h.store.Clusters.Get(uint(id))
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve cluster")
+		return
+	}
+	WriteSuccess(w, cluster)
 }
 
 // RemoveNode removes a node from a cluster
@@ -313,7 +1153,10 @@ func (h *ClusterHandler) RemoveNode(w http.ResponseWriter, r *http.Request) {
 	WriteError(w, http.StatusNotImplemented, "NOT_IMPLEMENTED", "Not yet implemented")
 }
 
-// GetKubeconfig returns the kubeconfig for a cluster
+// GetKubeconfig returns the kubeconfig for a cluster. With ?role=view,
+// edit or admin it issues a kubeconfig of a service account of the caller
+// bound to that cluster role instead of the admin kubeconfig, so read-only
+// access can be handed out to viewers of the project.
 func (h *ClusterHandler) GetKubeconfig(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
@@ -323,22 +1166,102 @@ func (h *ClusterHandler) GetKubeconfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var cluster db.Cluster
-	if err := db.DB.First(&cluster, id).Error; err != nil {
+	if err := h.store.DB().First(&cluster, id).Error; err != nil {
 		WriteNotFound(w, "Cluster not found")
 		return
 	}
 
+	credential, required, ok := kubeconfigOptions(w, r)
+	if !ok {
+		return
+	}
+	if !auth.HasRole(projectRole(h.store.DB(), CurrentUser(r), cluster.ProjectID), required) {
+		WriteError(w, http.StatusForbidden, "FORBIDDEN", "This action requires the "+required+" role")
+		return
+	}
 	if cluster.Kubeconfig == nil {
 		WriteError(w, http.StatusNotFound, "NOT_FOUND", "Kubeconfig not available")
 		return
 	}
 
+	kubeconfig := cluster.Kubeconfig
+	if credential != nil {
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		if _, kubeconfig, ok = h.issueCredential(wrapped, r, cluster.ID, *credential, currentKubeconfigPolicy()); !ok {
+			recordKubeconfigDownload(h.store.DB(), r, cluster.ID, wrapped.statusCode)
+			return
+		}
+	}
+	recordKubeconfigDownload(h.store.DB(), r, cluster.ID, http.StatusOK)
+
 	w.Header().Set("Content-Type", "application/x-yaml")
 	w.Header().Set("Content-Disposition", "attachment; filename=kubeconfig.yaml")
-	w.Write(cluster.Kubeconfig)
+	w.Write(kubeconfig)
 }
 
-// GetEvents returns events for a cluster
+// ExportCluster renders a cluster as Cluster API manifests (?format=capi,
+// the default) or as its kubeadm configuration and host inventory
+// (?format=kubeadm)
+func (h *ClusterHandler) ExportCluster(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = provision.ExportCAPI
+	}
+	if !validation.OneOf(format, provision.ExportFormats) {
+		WriteBadRequest(w, fmt.Sprintf("Unsupported export format, expected one of %s", strings.Join(provision.ExportFormats, ", ")))
+		return
+	}
+
+	cluster, err := h.store.Clusters.Get(uint(id))
+	if err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+	nodes, err := h.store.Nodes.List(cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve nodes")
+		return
+	}
+
+	spec := provision.ClusterSpec{
+		Name:              cluster.Name,
+		K8sVersion:        cluster.K8sVersion,
+		PodNetworkCIDR:    cluster.PodNetworkCIDR,
+		ServiceCIDR:       cluster.ServiceCIDR,
+		CNI:               cluster.CNI,
+		ContainerRuntime:  cluster.ContainerRuntime,
+		APIServerEndpoint: cluster.APIServerEndpoint,
+		KubeadmConfig:     cluster.KubeadmConfig,
+	}
+	for _, node := range nodes {
+		host := provision.HostSpec{Hostname: node.Hostname, Address: node.Address, User: node.User, Port: node.Port, Role: node.Role}
+		if node.Role == "control-plane" {
+			spec.ControlPlanes = append(spec.ControlPlanes, host)
+		} else {
+			spec.Workers = append(spec.Workers, host)
+		}
+	}
+
+	manifests, err := provision.Export(spec, format)
+	if err != nil {
+		WriteInternalError(w, "Failed to export cluster: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.yaml", cluster.Name, format))
+	w.Write(manifests)
+}
+
+// GetEvents returns events for a cluster, optionally filtered by level,
+// host, step, phase and job_id like the WebSocket stream
 func (h *ClusterHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
@@ -347,8 +1270,14 @@ func (h *ClusterHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var events []db.Event
-	if err := db.DB.Where("cluster_id = ?", id).Order("timestamp desc").Limit(100).Find(&events).Error; err != nil {
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	events, err := h.store.Events.List(filter.Query(uint(id), 100))
+	if err != nil {
 		WriteInternalError(w, "Failed to retrieve events")
 		return
 	}
@@ -359,22 +1288,306 @@ func (h *ClusterHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 // Helper methods
 
 func (h *ClusterHandler) logEvent(clusterID uint, level, host, step, message string) {
-	event := db.Event{
+	recordEvent(clusterID, level, host, step, message)
+}
+
+// logError records an error event and marks the cluster failed with the
+// error as its status message
+func (h *ClusterHandler) logError(clusterID uint, message string, err error) {
+	// A job stopped by a shutdown resumes where it stopped
+	if errors.Is(err, jobs.ErrInterrupted) {
+		h.logEvent(clusterID, "warn", "localhost", "interrupted", message+": "+err.Error()+", the job resumes on the next start")
+		return
+	}
+	h.reportError(clusterID, message, err)
+	h.setClusterStatus(clusterID, db.ClusterFailed, message+": "+err.Error())
+}
+
+// reportError records an error event the job recovers from, leaving the
+// status of the cluster alone
+func (h *ClusterHandler) reportError(clusterID uint, message string, err error) {
+	h.logEvent(clusterID, "error", "localhost", "error", message+": "+err.Error())
+}
+
+// setClusterStatus moves a cluster to a new status, logging transitions the
+// status state machine does not allow
+func (h *ClusterHandler) setClusterStatus(clusterID uint, status db.ClusterStatus, message string) {
+	if err := h.store.Clusters.SetStatus(clusterID, status, message); err != nil {
+		slog.Warn("Cluster status not changed", "cluster_id", clusterID, "status", status, "error", err)
+	}
+}
+
+// recordEvent persists a cluster event and broadcasts it to WebSocket clients
+func recordEvent(clusterID uint, level, host, step, message string) {
+	saveEvent(db.Event{
 		ClusterID: clusterID,
-		Timestamp: time.Now(),
 		Level:     level,
 		Host:      host,
 		Step:      step,
 		Message:   message,
-		CreatedAt: time.Now(),
+	})
+}
+
+// eventStore keeps the events recorded by handlers and jobs
+var eventStore db.EventStore
+
+// SetEventStore configures where cluster events are recorded
+func SetEventStore(store db.EventStore) {
+	eventStore = store
+}
+
+// saveEvent stamps an event with the running job and time, persists it and
+// broadcasts it to WebSocket clients
+func saveEvent(event db.Event) {
+	job := currentJob(event.ClusterID)
+	event.JobID, event.RequestID, event.TraceID = job.id, job.requestID, job.traceID
+	if event.Phase == "" {
+		event.Phase = string(provision.Step(event.Step).Phase())
+	}
+	event.Timestamp = time.Now()
+	event.CreatedAt = event.Timestamp
+	if err := eventStore.Create(&event); err != nil {
+		slog.Error("Failed to record event", "cluster_id", event.ClusterID, "error", err)
 	}
-	db.DB.Create(&event)
 
 	// Broadcast event to WebSocket clients
-	Hub.BroadcastEvent(clusterID, event)
+	Hub.BroadcastEvent(event.ClusterID, event)
 }
 
-func (h *ClusterHandler) logError(clusterID uint, message string, err error) {
-	h.logEvent(clusterID, "error", "localhost", "error", message+": "+err.Error())
-	db.DB.Model(&db.Cluster{}).Where("id = ?", clusterID).Update("status", "failed")
+// outputRecorder returns a provisioner output callback bound to a cluster.
+// Chunks are only broadcast; the full output is stored on an event once the
+// command ends.
+func outputRecorder(clusterID uint) provision.OutputCallback {
+	return func(output provision.CommandOutput) {
+		if !output.Done {
+			Hub.BroadcastOutput(clusterID, CommandOutput{
+				ClusterID: clusterID,
+				JobID:     currentJob(clusterID).id,
+				Host:      output.Host,
+				Step:      output.Step,
+				Command:   output.Command,
+				Data:      output.Chunk,
+			})
+			return
+		}
+
+		event := db.Event{
+			ClusterID: clusterID,
+			Level:     "debug",
+			Host:      output.Host,
+			Step:      output.Step,
+			Message:   "Output of " + output.Command,
+			Output:    output.Output,
+		}
+		if output.Error != "" {
+			event.Level = "error"
+			event.Message += " (failed: " + output.Error + ")"
+		}
+		saveEvent(event)
+	}
+}
+
+// streamProvisioner records the host and step events of a job's provisioner
+// and attaches its live command output to the cluster's stream
+func streamProvisioner(provisioner provision.IProvisioner, clusterID uint) {
+	if emitter, ok := provisioner.(provision.EventEmitter); ok {
+		emitter.SetEventCallback(eventRecorder(clusterID))
+	}
+	if streamer, ok := provisioner.(provision.OutputStreamer); ok {
+		streamer.SetOutputCallback(outputRecorder(clusterID))
+	}
+}
+
+// eventRecorder returns a provisioner event callback bound to a cluster
+func eventRecorder(clusterID uint) provision.EventCallback {
+	return func(event provision.ProvisionEvent) {
+		saveEvent(db.Event{
+			ClusterID:  clusterID,
+			Level:      event.Level,
+			Host:       event.Host,
+			Step:       string(event.Step),
+			Phase:      string(event.Phase),
+			StepStatus: string(event.Status),
+			Message:    event.Message,
+		})
+	}
+}
+
+// nodeHostSpec converts a stored node into a host spec for SSH access
+func nodeHostSpec(node db.Node, skipHostKeyCheck bool) provision.HostSpec {
+	host := provision.HostSpec{
+		Hostname:   node.Hostname,
+		Address:    node.Address,
+		User:       node.User,
+		SSHKeyPath: node.SSHKeyPath,
+		SSHKeyID:   node.SSHKeyID,
+		Port:       node.Port,
+		Role:       node.Role,
+
+		SSHKeyPassphrase: node.SSHKeyPassphrase,
+		SSHAgent:         node.SSHAgent,
+		UseSudo:          node.UseSudo,
+		SudoPassword:     node.SudoPassword,
+	}
+	var err error
+	if host.Bastion, err = decodeBastion(node.Bastion); err != nil {
+		slog.Error("Invalid bastion of node", "node_id", node.ID, "error", err)
+	}
+	if host.BMC, err = decodeBMC(node.BMC); err != nil {
+		slog.Error("Invalid BMC of node", "node_id", node.ID, "error", err)
+	}
+	host.SetInsecureSkipHostKeyCheck(skipHostKeyCheck)
+	return host
+}
+
+// newNodeRecord returns the record of a node of the cluster, provisioning
+// host with the stored SSH key keyID
+func newNodeRecord(clusterID uint, host provision.HostSpec, keyID uint, role string) db.Node {
+	node := db.Node{
+		ClusterID:        clusterID,
+		Hostname:         host.Hostname,
+		Address:          host.Address,
+		User:             host.User,
+		SSHKeyPath:       host.SSHKeyPath,
+		SSHKeyID:         keyID,
+		SSHKeyPassphrase: host.SSHKeyPassphrase,
+		SSHAgent:         host.SSHAgent,
+		UseSudo:          host.UseSudo,
+		SudoPassword:     host.SudoPassword,
+		Bastion:          encodeBastion(host.Bastion),
+		BMC:              encodeBMC(host.BMC),
+		HostID:           host.HostID,
+		Labels:           host.Labels,
+		Port:             host.Port,
+		Role:             role,
+		Status:           "provisioning",
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if node.Port == 0 {
+		node.Port = 22
+	}
+	return node
+}
+
+// encodeBastion encodes the bastion of a host for storage with its node or
+// inventory host
+func encodeBastion(bastion *provision.HostSpec) string {
+	if bastion == nil {
+		return ""
+	}
+	data, _ := json.Marshal(bastion)
+	return string(data)
+}
+
+// encodeBMC encodes the BMC of a host for storage with its node or
+// inventory host
+func encodeBMC(controller *bmc.Spec) string {
+	if controller == nil {
+		return ""
+	}
+	data, _ := json.Marshal(controller)
+	return string(data)
+}
+
+// decodeBastion decodes a bastion stored with a node or inventory host
+func decodeBastion(data string) (*provision.HostSpec, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var bastion provision.HostSpec
+	if err := json.Unmarshal([]byte(data), &bastion); err != nil {
+		return nil, err
+	}
+	return &bastion, nil
+}
+
+// decodeBMC decodes a BMC stored with a node or inventory host
+func decodeBMC(data string) (*bmc.Spec, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var controller bmc.Spec
+	if err := json.Unmarshal([]byte(data), &controller); err != nil {
+		return nil, err
+	}
+	return &controller, nil
+}
+
+// skipsHostKeyCheck reports whether a cluster accepts any SSH host key
+func skipsHostKeyCheck(conn *gorm.DB, clusterID uint) bool {
+	var cluster db.Cluster
+	conn.Select("insecure_skip_host_key_check").First(&cluster, clusterID)
+	return cluster.InsecureSkipHostKeyCheck
+}
+
+// clusterProvisioner returns the provisioner a cluster was created with
+func clusterProvisioner(conn *gorm.DB, clusterID uint) (provision.IProvisioner, error) {
+	var cluster db.Cluster
+	if err := conn.Unscoped().Select("provider").First(&cluster, clusterID).Error; err != nil {
+		return nil, err
+	}
+	if cluster.Provider == "" {
+		return provision.GetProvisioner(provision.DefaultProvisioner, nil)
+	}
+	return provision.GetProvisioner(cluster.Provider, nil)
+}
+
+// clusterHosts returns host specs for all nodes of a cluster
+func clusterHosts(conn *gorm.DB, clusterID uint) ([]provision.HostSpec, error) {
+	var nodes []db.Node
+	if err := conn.Where("cluster_id = ?", clusterID).Order("id").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	skip := skipsHostKeyCheck(conn, clusterID)
+	hosts := make([]provision.HostSpec, 0, len(nodes))
+	for _, node := range nodes {
+		hosts = append(hosts, nodeHostSpec(node, skip))
+	}
+	return hosts, nil
+}
+
+// controlPlaneHost returns the first control plane node of a cluster
+func controlPlaneHost(conn *gorm.DB, clusterID uint) (provision.HostSpec, error) {
+	var node db.Node
+	if err := conn.Where("cluster_id = ? AND role = ?", clusterID, "control-plane").Order("id").First(&node).Error; err != nil {
+		return provision.HostSpec{}, err
+	}
+	return nodeHostSpec(node, skipsHostKeyCheck(conn, clusterID)), nil
+}
+
+// clusterKubeconfig returns the stored admin kubeconfig of a cluster
+func clusterKubeconfig(conn *gorm.DB, clusterID uint) ([]byte, error) {
+	var cluster db.Cluster
+	if err := conn.Select("id", "kubeconfig").First(&cluster, clusterID).Error; err != nil {
+		return nil, err
+	}
+	if cluster.Kubeconfig == nil {
+		return nil, errors.New("kubeconfig not available")
+	}
+	return cluster.Kubeconfig, nil
+}
+
+// readyCluster loads a cluster and writes an error response unless it is ready
+func readyCluster(conn *gorm.DB, w http.ResponseWriter, id uint) (*db.Cluster, bool) {
+	var cluster db.Cluster
+	if err := conn.First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return nil, false
+	}
+	if cluster.Status != db.ClusterReady {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster is not ready")
+		return nil, false
+	}
+	return &cluster, true
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
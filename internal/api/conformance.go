@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// conformanceTimeouts bound a conformance run in each mode, the certified
+// suite runs for hours on small clusters
+var conformanceTimeouts = map[string]time.Duration{
+	provision.SonobuoyModeQuick:     30 * time.Minute,
+	provision.SonobuoyModeCertified: 6 * time.Hour,
+}
+
+// conformancePollInterval is how often the progress of Sonobuoy is read
+const conformancePollInterval = 30 * time.Second
+
+// ConformanceRequest starts the conformance tests of a cluster
+type ConformanceRequest struct {
+	Mode string `json:"mode,omitempty"` // quick (default) or certified-conformance
+}
+
+// conformancePayload is the input of a conformance job
+type conformancePayload struct {
+	RunID uint `json:"run_id"`
+}
+
+// CreateConformanceRun starts a job running the Sonobuoy conformance tests
+// in a ready cluster and returns the run
+func (h *ClusterHandler) CreateConformanceRun(w http.ResponseWriter, r *http.Request) {
+	var req ConformanceRequest
+	if r.ContentLength != 0 {
+		if err := ParseJSON(r, &req); err != nil {
+			WriteBadRequest(w, "Invalid request body")
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = provision.SonobuoyModeQuick
+	}
+	if _, ok := conformanceTimeouts[req.Mode]; !ok {
+		var errs validation.Errors
+		errs.Add("mode", validation.CodeUnsupported, fmt.Sprintf("unsupported mode %q, expected quick or certified-conformance", req.Mode))
+		WriteValidationError(w, errs)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	run := db.ConformanceRun{ClusterID: cluster.ID, Mode: req.Mode, Status: scanPending, CreatedAt: time.Now()}
+	if err := h.store.DB().Create(&run).Error; err != nil {
+		WriteInternalError(w, "Failed to create conformance run")
+		return
+	}
+	data, _ := json.Marshal(conformancePayload{RunID: run.ID})
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "conformance",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		h.store.DB().Delete(&run)
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A conformance run of the cluster is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue conformance job")
+		return
+	}
+	run.JobID = job.ID
+	h.store.DB().Model(&run).Update("job_id", job.ID)
+
+	WriteAccepted(w, jobLocation(job.ID), run)
+}
+
+// ListConformanceRuns lists the conformance runs of a cluster, newest first
+func (h *ClusterHandler) ListConformanceRuns(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var runs []db.ConformanceRun
+	if err := h.store.DB().Omit("results").Where("cluster_id = ?", id).Order("id desc").Find(&runs).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve conformance runs")
+		return
+	}
+	WriteSuccess(w, runs)
+}
+
+// GetConformanceRun returns a conformance run with its failed tests
+func (h *ClusterHandler) GetConformanceRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := clusterConformanceRun(h.store.DB(), w, r, false)
+	if !ok {
+		return
+	}
+	WriteSuccess(w, run)
+}
+
+// GetConformanceResults downloads the Sonobuoy results tarball of a run
+func (h *ClusterHandler) GetConformanceResults(w http.ResponseWriter, r *http.Request) {
+	run, ok := clusterConformanceRun(h.store.DB(), w, r, true)
+	if !ok {
+		return
+	}
+	if len(run.Results) == 0 {
+		WriteNotFound(w, "The conformance run has no results")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sonobuoy-cluster-%d-run-%d.tar.gz", run.ClusterID, run.ID))
+	w.Write(run.Results)
+}
+
+// clusterConformanceRun resolves a conformance run of the cluster of the
+// request, with its results tarball when results is set
+func clusterConformanceRun(conn *gorm.DB, w http.ResponseWriter, r *http.Request, results bool) (db.ConformanceRun, bool) {
+	var run db.ConformanceRun
+	vars := mux.Vars(r)
+	clusterID, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return run, false
+	}
+	id, err := strconv.ParseUint(vars["runId"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid conformance run ID")
+		return run, false
+	}
+	query := conn.Where("cluster_id = ?", clusterID)
+	if !results {
+		query = query.Omit("results")
+	}
+	if err := query.First(&run, id).Error; err != nil {
+		WriteNotFound(w, "Conformance run not found")
+		return run, false
+	}
+	return run, true
+}
+
+// runConformanceJob runs Sonobuoy from the first control plane, following
+// its progress, then stores the results tarball and the summary of the e2e
+// tests. A resumed job follows the run still going on in the cluster.
+func (h *ClusterHandler) runConformanceJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload conformancePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.reportError(clusterID, "Invalid conformance job payload", err)
+		return err
+	}
+	var run db.ConformanceRun
+	if err := h.store.DB().Omit("results").First(&run, payload.RunID).Error; err != nil {
+		h.reportError(clusterID, "Conformance run not found", err)
+		return err
+	}
+	h.store.DB().Model(&run).Updates(map[string]interface{}{"status": scanRunning, "error": ""})
+
+	summary, tarball, err := h.runSonobuoy(ctx, job, &run)
+	now := time.Now()
+	run.Status, run.FinishedAt = scanCompleted, &now
+	run.Result, run.FailedTests = summary.Status, summary.FailedTests
+	run.Total, run.Passed, run.Failed, run.Skipped = summary.Total, summary.Passed, summary.Failed, summary.Skipped
+	run.Results, run.HasResults = tarball, tarball != nil
+	if err != nil {
+		run.Status, run.Error = scanFailed, err.Error()
+	}
+	h.store.DB().Model(&run).Select("status", "error", "finished_at", "result", "failed_tests",
+		"total", "passed", "failed", "skipped", "results", "has_results").Updates(&run)
+	if err != nil {
+		return err
+	}
+
+	level := "info"
+	if summary.Status != "passed" {
+		level = "warn"
+	}
+	h.logEvent(clusterID, level, "localhost", "complete",
+		fmt.Sprintf("Conformance run %d %s: %d passed, %d failed, %d skipped", run.ID, summary.Status, summary.Passed, summary.Failed, summary.Skipped))
+	return nil
+}
+
+// runSonobuoy starts Sonobuoy unless it is running already, waits for it to
+// finish and retrieves its results, removing it from the cluster afterwards
+func (h *ClusterHandler) runSonobuoy(ctx context.Context, job *db.Job, run *db.ConformanceRun) (provision.ConformanceSummary, []byte, error) {
+	clusterID := run.ClusterID
+	var summary provision.ConformanceSummary
+	host, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		return summary, nil, fmt.Errorf("no control plane: %w", err)
+	}
+	client, err := provision.NewSSHClient(host)
+	if err != nil {
+		return summary, nil, fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	if err := provision.InstallSonobuoy(ctx, client); err != nil {
+		return summary, nil, err
+	}
+	if _, err := provision.GetSonobuoyStatus(ctx, client); err == nil {
+		h.logEvent(clusterID, "info", host.Address, "conformance", "Sonobuoy is running, following it")
+	} else {
+		h.logEvent(clusterID, "info", host.Address, "conformance", fmt.Sprintf("Starting Sonobuoy in %s mode", run.Mode))
+		if err := provision.StartSonobuoy(ctx, client, run.Mode); err != nil {
+			return summary, nil, err
+		}
+	}
+	// Sonobuoy is left running for the resumed job when the server stops
+	defer func() {
+		if ctx.Err() == nil {
+			provision.DeleteSonobuoy(context.Background(), client)
+		}
+	}()
+
+	progress := &provisionProgress{queue: h.queue, job: job}
+	var tarball []byte
+	err = provision.RunStep(ctx, "conformance", provision.Duration(conformanceTimeouts[run.Mode]), func(ctx context.Context) error {
+		for {
+			status, err := provision.GetSonobuoyStatus(ctx, client)
+			if err != nil {
+				return err
+			}
+			switch status.Status {
+			case provision.SonobuoyComplete, provision.SonobuoyFailed:
+				tarball, summary, err = provision.RetrieveSonobuoy(ctx, client)
+				if err == nil && status.Status == provision.SonobuoyFailed && summary.Status == "" {
+					err = errors.New("sonobuoy failed without e2e results")
+				}
+				return err
+			}
+			if status.Total > 0 && (status.Completed != progress.done || status.Total != progress.total) {
+				progress.total, progress.done = status.Total, status.Completed
+				progress.report("conformance")
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(conformancePollInterval):
+			}
+		}
+	})
+	return summary, tarball, err
+}
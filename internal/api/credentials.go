@@ -0,0 +1,401 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/audit"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// Kubeconfig access modes
+const (
+	KubeconfigAdmin      = "admin"       // the cluster-admin kubeconfig of kubeadm
+	KubeconfigShortLived = "short-lived" // a service account token minted per download
+)
+
+// minCredentialTTL is the shortest token lifetime the API server accepts
+const minCredentialTTL = 10 * time.Minute
+
+// credentialTimeout bounds creating or revoking a credential on the
+// control plane
+const credentialTimeout = provision.Duration(time.Minute)
+
+// clusterRolePattern matches cluster role names, system roles included
+var clusterRolePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.:]*[a-z0-9])?$`)
+
+// scopedKubeconfigRoles maps the cluster roles of scoped kubeconfigs to the
+// project role needed to download them
+var scopedKubeconfigRoles = map[string]string{
+	"view":  auth.RoleViewer,
+	"edit":  auth.RoleOperator,
+	"admin": auth.RoleOperator,
+}
+
+// nonNameChars matches what a service account name cannot contain
+var nonNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// KubeconfigPolicy controls the kubeconfigs served for clusters
+type KubeconfigPolicy struct {
+	Access string        // KubeconfigAdmin or KubeconfigShortLived
+	TTL    time.Duration // default lifetime of short-lived kubeconfigs
+	MaxTTL time.Duration // longest lifetime a caller may request
+	Role   string        // default cluster role of short-lived kubeconfigs
+}
+
+var (
+	kubeconfigMu     sync.RWMutex
+	kubeconfigPolicy = KubeconfigPolicy{Access: KubeconfigAdmin, TTL: time.Hour, MaxTTL: 24 * time.Hour, Role: "cluster-admin"}
+)
+
+// SetKubeconfigPolicy configures how kubeconfigs are served
+func SetKubeconfigPolicy(policy KubeconfigPolicy) error {
+	if policy.Access == "" {
+		policy.Access = KubeconfigAdmin
+	}
+	if policy.Access != KubeconfigAdmin && policy.Access != KubeconfigShortLived {
+		return fmt.Errorf("unknown kubeconfig access %q, expected %s or %s", policy.Access, KubeconfigAdmin, KubeconfigShortLived)
+	}
+	if policy.MaxTTL < minCredentialTTL {
+		policy.MaxTTL = minCredentialTTL
+	}
+	policy.TTL = max(minCredentialTTL, min(policy.MaxTTL, policy.TTL))
+	if !clusterRolePattern.MatchString(policy.Role) {
+		return fmt.Errorf("invalid kubeconfig role %q", policy.Role)
+	}
+
+	kubeconfigMu.Lock()
+	kubeconfigPolicy = policy
+	kubeconfigMu.Unlock()
+	return nil
+}
+
+// currentKubeconfigPolicy returns the configured kubeconfig policy
+func currentKubeconfigPolicy() KubeconfigPolicy {
+	kubeconfigMu.RLock()
+	defer kubeconfigMu.RUnlock()
+	return kubeconfigPolicy
+}
+
+// CredentialRequest issues a short-lived kubeconfig
+type CredentialRequest struct {
+	TTL  provision.Duration `json:"ttl,omitempty"`  // lifetime, the configured default when unset
+	Role string             `json:"role,omitempty"` // cluster role granted, the configured default when unset
+}
+
+// Validate checks the request against the policy and returns every invalid
+// field
+func (req *CredentialRequest) Validate(policy KubeconfigPolicy) validation.Errors {
+	var errs validation.Errors
+	if ttl := time.Duration(req.TTL); ttl != 0 && (ttl < minCredentialTTL || ttl > policy.MaxTTL) {
+		errs.Add("ttl", validation.CodeOutOfRange, fmt.Sprintf("ttl must be between %s and %s", minCredentialTTL, policy.MaxTTL))
+	}
+	if req.Role != "" && !clusterRolePattern.MatchString(req.Role) {
+		errs.Add("role", validation.CodeInvalid, fmt.Sprintf("invalid cluster role name %q", req.Role))
+	}
+	return errs
+}
+
+// kubeconfigOptions reads the ?role= and ?ttl= of a kubeconfig download
+// and returns the credential to issue, nil to serve the admin kubeconfig,
+// and the project role the download needs, writing an error response when
+// they are invalid. A scoped kubeconfig needs the project role matching its
+// cluster role, anything else grants at least edit access and needs
+// operator.
+func kubeconfigOptions(w http.ResponseWriter, r *http.Request) (*CredentialRequest, string, bool) {
+	scope := r.URL.Query().Get("role")
+	required := auth.RoleOperator
+	if scope != "" {
+		var ok bool
+		if required, ok = scopedKubeconfigRoles[scope]; !ok {
+			var errs validation.Errors
+			errs.Add("role", validation.CodeUnsupported, fmt.Sprintf("unsupported role %q, expected view, edit or admin", scope))
+			WriteValidationError(w, errs)
+			return nil, "", false
+		}
+	}
+
+	policy := currentKubeconfigPolicy()
+	if scope == "" && policy.Access != KubeconfigShortLived {
+		return nil, required, true
+	}
+	req := CredentialRequest{Role: scope}
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			WriteBadRequest(w, "Invalid ttl")
+			return nil, "", false
+		}
+		req.TTL = provision.Duration(ttl)
+	}
+	if errs := req.Validate(policy); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return nil, "", false
+	}
+	return &req, required, true
+}
+
+// CredentialResponse is an issued credential and its kubeconfig
+type CredentialResponse struct {
+	Credential db.KubeconfigCredential `json:"credential"`
+	Kubeconfig string                  `json:"kubeconfig"`
+}
+
+// CreateCredential issues a kubeconfig with a service account token bound
+// to a cluster role, valid for a limited time
+func (h *ClusterHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var req CredentialRequest
+	// The body is optional, every field has a default
+	if err := ParseJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	policy := currentKubeconfigPolicy()
+	if errs := req.Validate(policy); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	credential, kubeconfig, ok := h.issueCredential(w, r, uint(id), req, policy)
+	if !ok {
+		return
+	}
+	WriteCreated(w, CredentialResponse{Credential: *credential, Kubeconfig: string(kubeconfig)})
+}
+
+// ListCredentials lists the short-lived kubeconfigs issued for a cluster,
+// newest first
+func (h *ClusterHandler) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var credentials []db.KubeconfigCredential
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("id desc").Find(&credentials).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve credentials")
+		return
+	}
+	WriteSuccess(w, credentials)
+}
+
+// RevokeCredential invalidates a short-lived kubeconfig before it expires
+func (h *ClusterHandler) RevokeCredential(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var credential db.KubeconfigCredential
+	if err := h.store.DB().Where("cluster_id = ?", id).First(&credential, vars["credentialId"]).Error; err != nil {
+		WriteNotFound(w, "Credential not found")
+		return
+	}
+	if credential.RevokedAt != nil {
+		WriteSuccess(w, credential)
+		return
+	}
+	if err := h.revokeCredentials(r.Context(), uint(id), []db.KubeconfigCredential{credential}); err != nil {
+		WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", err.Error())
+		return
+	}
+	h.store.DB().First(&credential, credential.ID)
+	WriteSuccess(w, credential)
+}
+
+// RevokeCredentials invalidates every short-lived kubeconfig of a cluster
+// that has not expired
+func (h *ClusterHandler) RevokeCredentials(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var credentials []db.KubeconfigCredential
+	h.store.DB().Where("cluster_id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).Find(&credentials)
+	if err := h.revokeCredentials(r.Context(), uint(id), credentials); err != nil {
+		WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", err.Error())
+		return
+	}
+	WriteSuccess(w, map[string]string{"message": fmt.Sprintf("%d credentials revoked", len(credentials))})
+}
+
+// issueCredential creates a service account token on the control plane and
+// returns its record and kubeconfig, writing an error response on failure.
+// Service accounts of expired credentials of the cluster are removed on
+// the way.
+func (h *ClusterHandler) issueCredential(w http.ResponseWriter, r *http.Request, clusterID uint, req CredentialRequest, policy KubeconfigPolicy) (*db.KubeconfigCredential, []byte, bool) {
+	var cluster db.Cluster
+	if err := h.store.DB().First(&cluster, clusterID).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return nil, nil, false
+	}
+	if cluster.Kubeconfig == nil {
+		WriteError(w, http.StatusNotFound, "NOT_FOUND", "Kubeconfig not available")
+		return nil, nil, false
+	}
+	controlPlane, err := controlPlaneHost(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "No control plane found")
+		return nil, nil, false
+	}
+	provisioner, err := clusterProvisioner(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "Failed to get provisioner")
+		return nil, nil, false
+	}
+
+	ttl, role := time.Duration(req.TTL), req.Role
+	if ttl == 0 {
+		ttl = policy.TTL
+	}
+	if role == "" {
+		role = policy.Role
+	}
+	credential := db.KubeconfigCredential{
+		ClusterID: cluster.ID,
+		Role:      role,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+	if claims := CurrentUser(r); claims != nil {
+		credential.UserID = claims.UserID()
+		credential.Username = claims.Username
+	}
+	if err := h.store.DB().Create(&credential).Error; err != nil {
+		WriteInternalError(w, "Failed to save credential")
+		return nil, nil, false
+	}
+	credential.Name = credentialName(credential.ID, credential.Username)
+	h.store.DB().Model(&credential).Update("name", credential.Name)
+
+	var token string
+	err = provision.RunStep(r.Context(), "credentials", credentialTimeout, func(ctx context.Context) error {
+		token, err = provisioner.CreateCredential(ctx, controlPlane, credential.Name, role, ttl)
+		return err
+	})
+	if err != nil {
+		h.store.DB().Delete(&credential)
+		h.reportError(cluster.ID, "Failed to issue kubeconfig", err)
+		WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", err.Error())
+		return nil, nil, false
+	}
+	kubeconfig, err := provision.TokenKubeconfig(cluster.Kubeconfig, credential.Name, token)
+	if err != nil {
+		WriteInternalError(w, "Failed to build kubeconfig")
+		return nil, nil, false
+	}
+
+	h.logEvent(cluster.ID, "info", "localhost", "credentials", fmt.Sprintf("Kubeconfig %s with role %s issued to %s until %s",
+		credential.Name, role, credential.Username, credential.ExpiresAt.UTC().Format(time.RFC3339)))
+	h.pruneExpiredCredentials(r.Context(), cluster.ID)
+	return &credential, kubeconfig, true
+}
+
+// credentialName names the service account of a credential after the user
+// it is issued to, so bindings in the cluster show who holds them. The ID
+// keeps names unique.
+func credentialName(id uint, username string) string {
+	name := fmt.Sprintf("kubeforge-%d", id)
+	if user := strings.Trim(nonNameChars.ReplaceAllString(strings.ToLower(username), "-"), "-"); user != "" {
+		name += "-" + user
+	}
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// revokeCredentials deletes the service accounts of credentials of a
+// cluster and marks them revoked
+func (h *ClusterHandler) revokeCredentials(ctx context.Context, clusterID uint, credentials []db.KubeconfigCredential) error {
+	if len(credentials) == 0 {
+		return nil
+	}
+	controlPlane, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		return fmt.Errorf("no control plane found: %w", err)
+	}
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		return err
+	}
+	for _, credential := range credentials {
+		err := provision.RunStep(ctx, "credentials", credentialTimeout, func(ctx context.Context) error {
+			return provisioner.RevokeCredential(ctx, controlPlane, credential.Name)
+		})
+		if err != nil {
+			h.reportError(clusterID, "Failed to revoke kubeconfig "+credential.Name, err)
+			return err
+		}
+		now := time.Now()
+		h.store.DB().Model(&credential).Update("revoked_at", &now)
+		h.logEvent(clusterID, "info", "localhost", "credentials", "Kubeconfig "+credential.Name+" revoked")
+	}
+	return nil
+}
+
+// pruneExpiredCredentials removes the service accounts of expired
+// credentials, which no longer have a valid token. Failures are left for
+// the next call.
+func (h *ClusterHandler) pruneExpiredCredentials(ctx context.Context, clusterID uint) {
+	var expired []db.KubeconfigCredential
+	h.store.DB().Where("cluster_id = ? AND revoked_at IS NULL AND expires_at < ?", clusterID, time.Now()).Find(&expired)
+	if len(expired) == 0 {
+		return
+	}
+	controlPlane, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		return
+	}
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		return
+	}
+	for _, credential := range expired {
+		err := provision.RunStep(ctx, "credentials", credentialTimeout, func(ctx context.Context) error {
+			return provisioner.RevokeCredential(ctx, controlPlane, credential.Name)
+		})
+		if err != nil {
+			return
+		}
+		h.store.DB().Model(&credential).Update("revoked_at", credential.ExpiresAt)
+	}
+}
+
+// recordKubeconfigDownload adds a download of a kubeconfig to the audit
+// log, which otherwise records changes only
+func recordKubeconfigDownload(conn *gorm.DB, r *http.Request, clusterID uint, status int) {
+	entry := &db.AuditLog{
+		Timestamp:  time.Now(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		ClusterID:  clusterID,
+		Status:     status,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if claims := CurrentUser(r); claims != nil {
+		entry.UserID = claims.UserID()
+		entry.Username = claims.Username
+	}
+	audit.Record(conn, entry)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// DiscoverRequest scans a network for SSH hosts and registers the hosts the
+// credentials log in to in the inventory of a project
+type DiscoverRequest struct {
+	ProjectID        uint              `json:"project_id,omitempty"`
+	CIDR             string            `json:"cidr"` // at most a /16 network
+	User             string            `json:"user"`
+	Port             int               `json:"port"`
+	SSHKeyPath       string            `json:"ssh_key_path,omitempty"`
+	SSHKeyID         uint              `json:"ssh_key_id,omitempty"`
+	SSHKeyName       string            `json:"ssh_key_name,omitempty"`
+	SSHKeyPassphrase string            `json:"ssh_key_passphrase,omitempty"`
+	SSHAgent         bool              `json:"ssh_agent,omitempty"`
+	UseSudo          bool              `json:"use_sudo,omitempty"`
+	SudoPassword     string            `json:"sudo_password,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"` // set on every discovered host
+}
+
+// hostRequest returns the request registering a discovered host
+func (req *DiscoverRequest) hostRequest(hostname, address string) *HostRequest {
+	return &HostRequest{
+		ProjectID:        req.ProjectID,
+		Hostname:         hostname,
+		Address:          address,
+		User:             req.User,
+		Port:             req.Port,
+		SSHKeyPath:       req.SSHKeyPath,
+		SSHKeyID:         req.SSHKeyID,
+		SSHKeyName:       req.SSHKeyName,
+		SSHKeyPassphrase: req.SSHKeyPassphrase,
+		SSHAgent:         req.SSHAgent,
+		UseSudo:          req.UseSudo,
+		SudoPassword:     req.SudoPassword,
+		Labels:           req.Labels,
+	}
+}
+
+// Validate checks the request and returns every invalid field
+func (req *DiscoverRequest) Validate() validation.Errors {
+	var errs validation.Errors
+	if req.CIDR == "" {
+		errs.Add("cidr", validation.CodeRequired, "cidr is required")
+	} else if _, err := provision.ScanAddresses(req.CIDR); err != nil {
+		errs.Add("cidr", validation.CodeInvalid, err.Error())
+	}
+	// The hostname and address stand in for the ones found by the scan
+	host := req.hostRequest("discovered", "127.0.0.1")
+	return append(errs, host.Validate()...)
+}
+
+// DiscoveryResult is recorded as the metadata of a discovery job
+type DiscoveryResult struct {
+	Scanned   int               `json:"scanned"`          // addresses probed
+	Open      int               `json:"open"`             // addresses accepting SSH connections
+	Known     int               `json:"known"`            // open addresses already in the inventory
+	Added     []uint            `json:"added,omitempty"`  // IDs of the registered hosts
+	Preflight int               `json:"preflight_passed"` // registered hosts passing the preflight checks
+	Failed    map[string]string `json:"failed,omitempty"` // why open addresses were not registered
+}
+
+// DiscoverHosts starts a job scanning a CIDR range for SSH hosts. Hosts the
+// credentials log in to are registered in the inventory with their facts
+// and preflight result; addresses already in the inventory are skipped.
+func (h *HostHandler) DiscoverHosts(w http.ResponseWriter, r *http.Request) {
+	var req DiscoverRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	// Resolve the project and require operator access to it
+	if req.ProjectID == 0 {
+		req.ProjectID = defaultClusterProject(h.store.DB(), r)
+	}
+	switch role := projectRole(h.store.DB(), CurrentUser(r), req.ProjectID); {
+	case role == "":
+		WriteNotFound(w, "Project not found")
+		return
+	case !auth.HasRole(role, auth.RoleOperator):
+		WriteError(w, http.StatusForbidden, "FORBIDDEN", "This action requires the operator role")
+		return
+	}
+
+	// Stored keys are checked now rather than for every host
+	host := req.hostRequest("discovered", "127.0.0.1").hostSpec()
+	keyID, errs := resolveHostSSHKeys(h.store.DB(), req.ProjectID, "", host)
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	req.SSHKeyID, req.SSHKeyName = keyID, ""
+
+	payload, _ := json.Marshal(req)
+	job := db.Job{
+		Type:        "discover",
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		WriteInternalError(w, "Failed to queue discovery job")
+		return
+	}
+
+	WriteAccepted(w, jobLocation(job.ID), job)
+}
+
+// runDiscoveryJob scans the network of a discovery job and registers the
+// hosts found
+func (h *HostHandler) runDiscoveryJob(ctx context.Context, job *db.Job) error {
+	var req DiscoverRequest
+	if err := json.Unmarshal([]byte(job.Payload), &req); err != nil {
+		return fmt.Errorf("invalid discovery job payload: %w", err)
+	}
+	addresses, err := provision.ScanAddresses(req.CIDR)
+	if err != nil {
+		return err
+	}
+	if req.Port == 0 {
+		req.Port = 22
+	}
+
+	// Probing takes the first half of the progress, logging in the second
+	result := DiscoveryResult{Scanned: len(addresses), Failed: map[string]string{}}
+	h.queue.UpdateProgress(job.ID, "scan", 0)
+	open, err := provision.ScanSSH(ctx, addresses, req.Port, func(done int) {
+		if done%256 == 0 {
+			h.queue.UpdateProgress(job.ID, "scan", done*50/len(addresses))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	result.Open = len(open)
+
+	var known []string
+	h.store.DB().Model(&db.Host{}).Where("project_id = ? AND address IN ?", req.ProjectID, open).Pluck("address", &known)
+	result.Known = len(known)
+	candidates := make([]provision.HostSpec, 0, len(open))
+	for _, address := range open {
+		if !containsString(known, address) {
+			candidates = append(candidates, req.hostRequest(address, address).hostSpec())
+		}
+	}
+
+	var mu sync.Mutex
+	done := 0
+	h.queue.UpdateProgress(job.ID, "facts", 50)
+	provision.ForEachHost(ctx, candidates, func(ctx context.Context, spec provision.HostSpec) error {
+		host, err := discoverHost(h.store.DB(), ctx, req, spec)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			result.Failed[spec.Address] = err.Error()
+		} else {
+			result.Added = append(result.Added, host.ID)
+			if host.PreflightPassed {
+				result.Preflight++
+			}
+		}
+		done++
+		h.queue.UpdateProgress(job.ID, "facts", 50+done*50/len(candidates))
+		return nil
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := h.queue.SaveMetadata(job.ID, result); err != nil {
+		return fmt.Errorf("failed to save discovery result: %w", err)
+	}
+	return nil
+}
+
+// discoverHost logs in to a scanned host, reads its facts and registers it
+// under the hostname it reports, or its address if that name is taken
+func discoverHost(conn *gorm.DB, ctx context.Context, req DiscoverRequest, spec provision.HostSpec) (*db.Host, error) {
+	var facts provision.HostFacts
+	err := provision.RunStep(ctx, "collect facts", factsTimeout, func(ctx context.Context) error {
+		var err error
+		facts, err = provision.CollectFacts(ctx, spec)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	host := db.Host{
+		ProjectID:        req.ProjectID,
+		Hostname:         facts.Hostname,
+		Address:          spec.Address,
+		User:             spec.User,
+		Port:             spec.Port,
+		SSHKeyPath:       spec.SSHKeyPath,
+		SSHKeyID:         spec.SSHKeyID,
+		SSHKeyPassphrase: spec.SSHKeyPassphrase,
+		SSHAgent:         spec.SSHAgent,
+		UseSudo:          spec.UseSudo,
+		SudoPassword:     spec.SudoPassword,
+		Labels:           req.Labels,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	setHostFacts(&host, facts)
+	var taken int64
+	conn.Unscoped().Model(&db.Host{}).Where("project_id = ? AND hostname = ?", host.ProjectID, host.Hostname).Count(&taken)
+	if host.Hostname == "" || taken > 0 {
+		host.Hostname = spec.Address
+	}
+	if err := conn.Create(&host).Error; err != nil {
+		return nil, fmt.Errorf("failed to register host: %w", err)
+	}
+	return &host, nil
+}
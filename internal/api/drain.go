@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+)
+
+// cordonTimeout bounds cordoning or uncordoning a node
+const cordonTimeout = provision.Duration(2 * time.Minute)
+
+// drainPayload is the input of a drain job
+type drainPayload struct {
+	NodeID  uint                   `json:"node_id"`
+	Options provision.DrainOptions `json:"options"`
+}
+
+// CordonNode marks a node unschedulable without evicting its pods
+func (h *ClusterHandler) CordonNode(w http.ResponseWriter, r *http.Request) {
+	h.setNodeCordoned(w, r, true)
+}
+
+// UncordonNode makes a cordoned or drained node schedulable again
+func (h *ClusterHandler) UncordonNode(w http.ResponseWriter, r *http.Request) {
+	h.setNodeCordoned(w, r, false)
+}
+
+// setNodeCordoned cordons or uncordons the node of a route
+func (h *ClusterHandler) setNodeCordoned(w http.ResponseWriter, r *http.Request, cordon bool) {
+	node, kubeconfig, ok := maintenanceNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	provisioner, err := clusterProvisioner(h.store.DB(), node.ClusterID)
+	if err != nil {
+		WriteInternalError(w, "Failed to get provisioner")
+		return
+	}
+
+	host := nodeHostSpec(*node, skipsHostKeyCheck(h.store.DB(), node.ClusterID))
+	err = provision.RunStep(r.Context(), "cordon "+host.Address, cordonTimeout, func(ctx context.Context) error {
+		return provisioner.CordonNode(ctx, kubeconfig, host, cordon)
+	})
+	if err != nil {
+		h.reportError(node.ClusterID, "Failed to change scheduling of node "+node.Hostname, err)
+		WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", err.Error())
+		return
+	}
+	node.Cordoned = cordon
+	h.store.Nodes.Update(node, "cordoned")
+
+	message := fmt.Sprintf("Node %s cordoned", node.Hostname)
+	if !cordon {
+		message = fmt.Sprintf("Node %s uncordoned", node.Hostname)
+	}
+	h.logEvent(node.ClusterID, "info", node.Address, "cordon", message)
+	WriteSuccess(w, map[string]string{"message": message})
+}
+
+// DrainNode starts a job cordoning a node and evicting its pods, for
+// maintenance without removing the node. The evictions are recorded as
+// events of the cluster; the node stays cordoned until it is uncordoned.
+func (h *ClusterHandler) DrainNode(w http.ResponseWriter, r *http.Request) {
+	var opts provision.DrainOptions
+	// The body is optional, every option has a default
+	if err := ParseJSON(r, &opts); err != nil && !errors.Is(err, io.EOF) {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := opts.ValidateFields(""); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	node, _, ok := maintenanceNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	payload, _ := json.Marshal(drainPayload{NodeID: node.ID, Options: opts})
+	job := db.Job{
+		ClusterID:   node.ClusterID,
+		Type:        "drain",
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A node of the cluster is already being drained")
+			return
+		}
+		WriteInternalError(w, "Failed to queue drain job")
+		return
+	}
+
+	WriteAccepted(w, jobLocation(job.ID), job)
+}
+
+// runDrainJob drains the node of a drain job
+func (h *ClusterHandler) runDrainJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload drainPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.reportError(clusterID, "Invalid drain job payload", err)
+		return err
+	}
+	node, err := h.store.Nodes.Get(clusterID, payload.NodeID)
+	if err != nil {
+		return fmt.Errorf("node %d not found: %w", payload.NodeID, err)
+	}
+	kubeconfig, err := clusterKubeconfig(h.store.DB(), clusterID)
+	if err != nil {
+		return err
+	}
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+
+	opts := payload.Options
+	opts.SetDefaults()
+	host := nodeHostSpec(*node, skipsHostKeyCheck(h.store.DB(), clusterID))
+	h.queue.UpdateProgress(job.ID, "drain", 0)
+	h.logEvent(clusterID, "info", node.Address, "drain", "Draining node "+node.Hostname)
+	// The drain gives up after its timeout, the step allows for cordoning
+	err = provision.RunStep(ctx, "drain "+host.Address, opts.Timeout+cordonTimeout, func(ctx context.Context) error {
+		return provisioner.DrainNode(ctx, kubeconfig, host, opts)
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The drain cordons the node before evicting, even if evicting fails
+	node.Cordoned = true
+	h.store.Nodes.Update(node, "cordoned")
+	if err != nil {
+		h.reportError(clusterID, "Failed to drain node "+node.Hostname, err)
+		return err
+	}
+
+	h.logEvent(clusterID, "info", node.Address, "drain", "Node "+node.Hostname+" drained")
+	return nil
+}
+
+// maintenanceNode looks up the node of a route and the admin kubeconfig of
+// its cluster, writing an error response unless the cluster is ready and the
+// node is reachable
+func maintenanceNode(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.Node, []byte, bool) {
+	node, ok := clusterNode(conn, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	cluster, ok := readyCluster(conn, w, node.ClusterID)
+	if !ok {
+		return nil, nil, false
+	}
+	if node.Address == "" {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Node has no address yet")
+		return nil, nil, false
+	}
+	if cluster.Kubeconfig == nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster kubeconfig not available")
+		return nil, nil, false
+	}
+	return node, cluster.Kubeconfig, true
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+)
+
+// eventLevels ranks event levels from least to most severe
+var eventLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// EventFilter selects the events a client receives. Zero fields match
+// everything.
+type EventFilter struct {
+	MinLevel string // only events at this level or more severe
+	Host     string
+	Step     string
+	Phase    string
+	JobID    uint
+}
+
+// parseEventFilter reads a filter from the level, host, step, phase and
+// job_id query parameters
+func parseEventFilter(query url.Values) (EventFilter, error) {
+	filter := EventFilter{
+		MinLevel: query.Get("level"),
+		Host:     query.Get("host"),
+		Step:     query.Get("step"),
+		Phase:    query.Get("phase"),
+	}
+	if _, ok := eventLevels[filter.MinLevel]; filter.MinLevel != "" && !ok {
+		return filter, fmt.Errorf("invalid level %q, expected debug, info, warn or error", filter.MinLevel)
+	}
+	if value := query.Get("job_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("invalid job ID %q", value)
+		}
+		filter.JobID = uint(id)
+	}
+	return filter, nil
+}
+
+// MatchEvent reports whether an event passes the filter
+func (f EventFilter) MatchEvent(event db.Event) bool {
+	if f.MinLevel != "" && eventLevels[event.Level] < eventLevels[f.MinLevel] {
+		return false
+	}
+	if f.Host != "" && event.Host != f.Host {
+		return false
+	}
+	if f.Step != "" && event.Step != f.Step {
+		return false
+	}
+	if f.Phase != "" && event.Phase != f.Phase {
+		return false
+	}
+	return f.JobID == 0 || event.JobID == f.JobID
+}
+
+// Match reports whether a hub message passes the filter. Progress updates
+// are only filtered by job, command output counts as debug level.
+func (f EventFilter) Match(message interface{}) bool {
+	switch m := message.(type) {
+	case db.Event:
+		return f.MatchEvent(m)
+	case JobProgress:
+		return f.JobID == 0 || m.JobID == f.JobID
+	case CommandOutput:
+		return f.MatchEvent(db.Event{Level: "debug", Host: m.Host, Step: m.Step, Phase: string(provision.Step(m.Step).Phase()), JobID: m.JobID})
+	}
+	return true
+}
+
+// Query returns a store query for the newest events of a cluster passing
+// the filter, at most limit of them
+func (f EventFilter) Query(clusterID uint, limit int) db.EventFilter {
+	query := db.EventFilter{ClusterID: clusterID, Host: f.Host, Step: f.Step, Phase: f.Phase, JobID: f.JobID, Limit: limit}
+	if f.MinLevel != "" {
+		query.Levels = []string{}
+		for level, rank := range eventLevels {
+			if rank >= eventLevels[f.MinLevel] {
+				query.Levels = append(query.Levels, level)
+			}
+		}
+	}
+	return query
+}
+
+// clusterJobs maps clusters to the job running for them in this process, so
+// events recorded while a job runs are attributed to it and to the request
+// that created it. The queue never runs two jobs of a cluster at once.
+var clusterJobs sync.Map // uint -> runningJob
+
+// runningJob identifies the job, request and trace events are recorded for
+type runningJob struct {
+	id        uint
+	requestID string
+	traceID   string
+}
+
+// currentJob returns the job running for a cluster in this process, or the
+// zero value
+func currentJob(clusterID uint) runningJob {
+	if job, ok := clusterJobs.Load(clusterID); ok {
+		return job.(runningJob)
+	}
+	return runningJob{}
+}
+
+// trackJob wraps a job handler so events recorded while it runs carry its ID
+// and its steps are not cut short by a shutdown
+func trackJob(handler jobs.Handler) jobs.Handler {
+	return func(ctx context.Context, job *db.Job) error {
+		if gate := jobs.Gate(ctx); gate != nil {
+			ctx = provision.WithStepGate(ctx, gate)
+		}
+		if job.ClusterID != 0 {
+			clusterJobs.Store(job.ClusterID, runningJob{id: job.ID, requestID: job.RequestID, traceID: tracing.TraceID(ctx)})
+			defer clusterJobs.Delete(job.ClusterID)
+		}
+		return handler(ctx, job)
+	}
+}
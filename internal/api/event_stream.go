@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+)
+
+// firehose is the hub key of clients subscribed to the events of all clusters.
+// Cluster IDs start at 1.
+const firehose = 0
+
+// errStreamClosed is returned when writing to a closed event stream
+var errStreamClosed = errors.New("event stream closed")
+
+// EventStreamHandler serves the events of all clusters
+type EventStreamHandler struct {
+	store *db.Store
+}
+
+// NewEventStreamHandler creates a new event stream handler
+func NewEventStreamHandler(store *db.Store) *EventStreamHandler {
+	return &EventStreamHandler{store: store}
+}
+
+// RegisterRoutes registers event stream routes
+func (h *EventStreamHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/events/stream", h.Stream).Methods("GET")
+}
+
+// Stream broadcasts the events and job progress of every cluster the caller
+// can see, over WebSocket when the client asks for an upgrade and as
+// server-sent events otherwise. It accepts the same filters as the
+// per-cluster stream and sends no history.
+func (h *EventStreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	projects, all := memberProjects(h.store.DB(), r)
+	client := &Client{
+		clusterID:   firehose,
+		filter:      filter,
+		projects:    projects,
+		allProjects: all,
+		hub:         Hub,
+		store:       h.store,
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveWebSocket(w, r, client)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteInternalError(w, "Streaming is not supported")
+		return
+	}
+	disableWriteTimeout(w, r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	conn := &sseConn{w: w, flusher: flusher, done: make(chan struct{})}
+	client.conn = conn
+	Hub.register <- client
+
+	// Comments keep proxies from closing an idle stream
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.write(": ping\n\n"); err != nil {
+				Hub.unregister <- client
+				return
+			}
+		case <-conn.done:
+			return
+		case <-r.Context().Done():
+			Hub.unregister <- client
+			// Wait for the hub so nothing is written after the handler returns
+			conn.Close()
+			return
+		}
+	}
+}
+
+// allowsCluster reports whether the client may receive messages of a cluster
+func (c *Client) allowsCluster(clusterID uint) bool {
+	if c.clusterID != firehose || c.allProjects {
+		return true
+	}
+	return containsUint(c.projects, clusterProject(c.store.DB(), clusterID))
+}
+
+// clusterProjects caches the project of each cluster for firehose clients.
+// Clusters never move between projects.
+var clusterProjects sync.Map // uint -> uint
+
+// clusterProject returns the project of a cluster, or 0 if it is unknown
+func clusterProject(conn *gorm.DB, clusterID uint) uint {
+	if id, ok := clusterProjects.Load(clusterID); ok {
+		return id.(uint)
+	}
+	var cluster db.Cluster
+	if err := conn.Unscoped().Select("id", "project_id").First(&cluster, clusterID).Error; err != nil {
+		return 0
+	}
+	clusterProjects.Store(clusterID, cluster.ProjectID)
+	return cluster.ProjectID
+}
+
+// sseConn writes hub messages as server-sent events
+type sseConn struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	done    chan struct{}
+	closed  bool
+}
+
+// WriteJSON sends a message as a data event
+func (c *sseConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(fmt.Sprintf("data: %s\n\n", data))
+}
+
+func (c *sseConn) write(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errStreamClosed
+	}
+	if _, err := fmt.Fprint(c.w, text); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// Close ends the stream. It waits for a write in progress.
+func (c *sseConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"kubeforge/internal/db"
+	"kubeforge/internal/graphql"
+)
+
+// graphqlWSProtocol is the WebSocket subprotocol of GraphQL subscriptions
+const graphqlWSProtocol = "graphql-transport-ws"
+
+// graphqlInitTimeout is how long a WebSocket client has to send
+// connection_init
+const graphqlInitTimeout = 10 * time.Second
+
+var graphqlUpgrader = websocket.Upgrader{
+	Subprotocols: []string{graphqlWSProtocol},
+	CheckOrigin:  upgrader.CheckOrigin,
+}
+
+// GraphQLHandler serves clusters, nodes, jobs and events over GraphQL, with
+// subscriptions to live events over WebSocket
+type GraphQLHandler struct {
+	store  *db.Store
+	schema *graphql.Schema
+}
+
+// NewGraphQLHandler creates a new GraphQL handler
+func NewGraphQLHandler(store *db.Store) *GraphQLHandler {
+	h := &GraphQLHandler{store: store}
+	h.schema = h.newGraphQLSchema()
+	return h
+}
+
+// RegisterRoutes registers GraphQL routes
+func (h *GraphQLHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/graphql", h.Serve).Methods("GET", "POST")
+	router.HandleFunc("/api/v1/graphql/schema", h.GetSchema).Methods("GET")
+}
+
+// Serve runs a GraphQL query given as a JSON body, or as the query,
+// operationName and variables query parameters of a GET request. WebSocket
+// upgrades run queries and subscriptions with the graphql-transport-ws
+// protocol.
+func (h *GraphQLHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r)
+		return
+	}
+
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				WriteBadRequest(w, "Invalid variables")
+				return
+			}
+		}
+	} else if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, r)
+	WriteJSON(w, http.StatusOK, graphql.Execute(ctx, h.schema, req))
+}
+
+// GetSchema returns the schema in the GraphQL schema definition language
+func (h *GraphQLHandler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(h.schema.SDL()))
+}
+
+// graphqlMessage is a message of the graphql-transport-ws protocol
+type graphqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlSocket is a WebSocket connection running GraphQL operations
+type graphqlSocket struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // serializes writes
+
+	operations map[string]context.CancelFunc
+	opMu       sync.Mutex
+}
+
+// send writes a message of the protocol
+func (s *graphqlSocket) send(id, typ string, payload interface{}) error {
+	msg := map[string]interface{}{"type": typ}
+	if id != "" {
+		msg["id"] = id
+	}
+	if payload != nil {
+		msg["payload"] = payload
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+// close ends the connection with a protocol close code
+func (s *graphqlSocket) close(code int, reason string) {
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	s.conn.Close()
+}
+
+// serveWebSocket runs the graphql-transport-ws protocol: the client sends
+// connection_init, then subscribe messages whose results are sent as next
+// messages until complete. The caller was authenticated by the upgrade
+// request.
+func (h *GraphQLHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphqlUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to upgrade connection", "error", err)
+		return
+	}
+	s := &graphqlSocket{conn: conn, operations: make(map[string]context.CancelFunc)}
+	if conn.Subprotocol() != graphqlWSProtocol {
+		s.close(4406, "Subprotocol not acceptable")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), graphqlRequestKey{}, r))
+	defer cancel()
+
+	acked := false
+	initTimer := time.AfterFunc(graphqlInitTimeout, func() {
+		s.close(4408, "Connection initialisation timeout")
+	})
+	defer initTimer.Stop()
+
+	// Keep the connection alive through proxies
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg graphqlMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.close(4400, "Invalid message")
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if acked {
+				s.close(4429, "Too many initialisation requests")
+				return
+			}
+			initTimer.Stop()
+			acked = true
+			s.send("", "connection_ack", nil)
+		case "ping":
+			s.send("", "pong", nil)
+		case "pong":
+		case "subscribe":
+			if !acked {
+				s.close(4401, "Unauthorized")
+				return
+			}
+			var req graphql.Request
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+				s.close(4400, "Invalid subscribe message")
+				return
+			}
+			if !h.start(ctx, s, msg.ID, req) {
+				s.close(4409, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+		case "complete":
+			s.opMu.Lock()
+			if stop, ok := s.operations[msg.ID]; ok {
+				stop()
+				delete(s.operations, msg.ID)
+			}
+			s.opMu.Unlock()
+		default:
+			s.close(4400, "Unknown message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// start runs an operation of a socket, sending its results until it ends
+// or the client completes it. It reports false when the ID is in use.
+func (h *GraphQLHandler) start(ctx context.Context, s *graphqlSocket, id string, req graphql.Request) bool {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+	if _, ok := s.operations[id]; ok {
+		return false
+	}
+	ctx, stop := context.WithCancel(ctx)
+	results, errs := graphql.Subscribe(ctx, h.schema, req)
+	if errs != nil {
+		stop()
+		s.send(id, "error", errs)
+		return true
+	}
+	s.operations[id] = stop
+
+	go func() {
+		for result := range results {
+			if err := s.send(id, "next", result); err != nil {
+				stop()
+			}
+		}
+		s.opMu.Lock()
+		_, running := s.operations[id]
+		delete(s.operations, id)
+		s.opMu.Unlock()
+		// Operations completed by the client are not completed again
+		if running {
+			s.send(id, "complete", nil)
+		}
+		stop()
+	}()
+	return true
+}
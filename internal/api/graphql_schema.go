@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/graphql"
+)
+
+// Default and largest number of jobs or events a GraphQL list returns
+const (
+	graphqlDefaultLimit = 50
+	graphqlMaxLimit     = 1000
+)
+
+// graphqlRequestKey stores the HTTP request in the context of resolvers, so
+// they check the caller's access as the REST handlers do
+type graphqlRequestKey struct{}
+
+// graphqlRequest returns the request a resolver runs for
+func graphqlRequest(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(graphqlRequestKey{}).(*http.Request)
+	return r
+}
+
+// eventArgs filter events like the query parameters of the event routes
+var eventArgs = map[string]string{
+	"level":  "String", // this level or more severe
+	"host":   "String",
+	"step":   "String",
+	"phase":  "String",
+	"job_id": "ID",
+}
+
+// withArgs returns a copy of args with more arguments
+func withArgs(args map[string]string, more map[string]string) map[string]string {
+	merged := make(map[string]string, len(args)+len(more))
+	for name, typ := range args {
+		merged[name] = typ
+	}
+	for name, typ := range more {
+		merged[name] = typ
+	}
+	return merged
+}
+
+// newGraphQLSchema builds the schema of clusters, nodes, jobs and events.
+// Field names match the JSON of the REST API.
+func (h *GraphQLHandler) newGraphQLSchema() *graphql.Schema {
+	cluster := graphql.NewObject("Cluster", db.Cluster{}, nil)
+	node := graphql.NewObject("Node", db.Node{}, nil)
+	job := graphql.NewObject("Job", db.Job{}, nil)
+	event := graphql.NewObject("Event", db.Event{}, nil)
+	progress := graphql.NewObject("JobProgress", JobProgress{}, nil)
+
+	clusterOf := &graphql.Field{Type: cluster, Resolve: func(ctx context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		switch s := source.(type) {
+		case db.Node:
+			return h.visibleCluster(ctx, s.ClusterID)
+		case db.Job:
+			return h.visibleCluster(ctx, s.ClusterID)
+		}
+		return nil, nil
+	}}
+
+	cluster.Fields["nodes"] = &graphql.Field{Type: node, List: true, Resolve: func(ctx context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		c := source.(db.Cluster)
+		if c.Nodes != nil {
+			return c.Nodes, nil
+		}
+		return h.store.Nodes.List(c.ID)
+	}}
+	cluster.Fields["jobs"] = &graphql.Field{Type: job, List: true, Args: map[string]string{"status": "String", "type": "String", "limit": "Int"},
+		Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			return h.store.Jobs.List(db.JobFilter{ClusterID: source.(db.Cluster).ID, Status: args.String("status"), Type: args.String("type"), Limit: graphqlLimit(args)})
+		}}
+	cluster.Fields["last_job"] = &graphql.Field{Type: job, Resolve: func(ctx context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		jobs, err := h.store.Jobs.List(db.JobFilter{ClusterID: source.(db.Cluster).ID, Limit: 1})
+		if err != nil || len(jobs) == 0 {
+			return nil, err
+		}
+		return jobs[0], nil
+	}}
+	cluster.Fields["events"] = &graphql.Field{Type: event, List: true, Args: withArgs(eventArgs, map[string]string{"limit": "Int"}),
+		Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			return h.events(source.(db.Cluster).ID, args)
+		}}
+	cluster.Fields["status_history"] = &graphql.Field{Resolve: func(ctx context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		var history []db.ClusterStatusChange
+		err := h.store.DB().Where("cluster_id = ?", source.(db.Cluster).ID).Order("id").Find(&history).Error
+		return history, err
+	}}
+	cluster.Fields["resources"] = &graphql.Field{Resolve: func(ctx context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		return latestResources(h.store.DB(), source.(db.Cluster).ID), nil
+	}}
+	node.Fields["cluster"] = clusterOf
+	job.Fields["cluster"] = clusterOf
+	job.Fields["events"] = &graphql.Field{Type: event, List: true, Args: map[string]string{"level": "String", "limit": "Int"},
+		Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			j := source.(db.Job)
+			if j.ClusterID == 0 {
+				return []db.Event{}, nil
+			}
+			args["job_id"] = strconv.FormatUint(uint64(j.ID), 10)
+			return h.events(j.ClusterID, args)
+		}}
+
+	query := graphql.NewObject("Query", nil, map[string]*graphql.Field{
+		"clusters": {Type: cluster, List: true, Args: map[string]string{"ids": "[ID!]", "status": "String", "label": "[String!]", "q": "String", "include_deleted": "Boolean"},
+			Resolve: h.resolveClusters},
+		"cluster": {Type: cluster, Args: map[string]string{"id": "ID!"}, Resolve: func(ctx context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+			id, err := graphqlID(args.String("id"))
+			if err != nil {
+				return nil, err
+			}
+			return h.visibleCluster(ctx, id)
+		}},
+		"jobs": {Type: job, List: true, Args: map[string]string{"cluster_id": "ID", "status": "String", "type": "String", "limit": "Int"},
+			Resolve: h.resolveJobs},
+		"job": {Type: job, Args: map[string]string{"id": "ID!"}, Resolve: func(ctx context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+			id, err := graphqlID(args.String("id"))
+			if err != nil {
+				return nil, err
+			}
+			j, err := h.store.Jobs.Get(id)
+			if err != nil {
+				return nil, nil
+			}
+			if j.ClusterID != 0 {
+				if c, err := h.visibleCluster(ctx, j.ClusterID); c == nil {
+					return nil, err
+				}
+			}
+			return *j, nil
+		}},
+		"events": {Type: event, List: true, Args: withArgs(eventArgs, map[string]string{"cluster_id": "ID!", "limit": "Int"}),
+			Resolve: func(ctx context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+				id, err := graphqlID(args.String("cluster_id"))
+				if err != nil {
+					return nil, err
+				}
+				if c, err := h.visibleCluster(ctx, id); c == nil {
+					return nil, err
+				}
+				return h.events(id, args)
+			}},
+	})
+
+	subscription := graphql.NewObject("Subscription", nil, map[string]*graphql.Field{
+		"events": {Type: event, Args: withArgs(eventArgs, map[string]string{"cluster_id": "ID"}),
+			Subscribe: func(ctx context.Context, args graphql.Args) (<-chan interface{}, error) {
+				return h.subscribe(ctx, args, func(message interface{}) bool {
+					_, ok := message.(db.Event)
+					return ok
+				})
+			}},
+		"job_progress": {Type: progress, Args: map[string]string{"cluster_id": "ID", "job_id": "ID"},
+			Subscribe: func(ctx context.Context, args graphql.Args) (<-chan interface{}, error) {
+				return h.subscribe(ctx, args, func(message interface{}) bool {
+					_, ok := message.(JobProgress)
+					return ok
+				})
+			}},
+	})
+
+	return &graphql.Schema{Query: query, Subscription: subscription}
+}
+
+// resolveClusters lists the clusters of the caller's projects, filtered by
+// IDs, status, labels (key=value, all must match) and a part of the name
+func (h *GraphQLHandler) resolveClusters(ctx context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+	labels, err := parseLabelFilter(args.Strings("label"))
+	if err != nil {
+		return nil, err
+	}
+	filter := db.ClusterFilter{ProjectIDs: projectFilter(h.store.DB(), graphqlRequest(ctx)), WithNodes: true, IncludeDeleted: args.Bool("include_deleted")}
+	if _, ok := args["ids"]; ok {
+		filter.IDs = []uint{}
+		for _, value := range args.Strings("ids") {
+			id, err := graphqlID(value)
+			if err != nil {
+				return nil, err
+			}
+			filter.IDs = append(filter.IDs, id)
+		}
+	}
+	clusters, err := h.store.Clusters.List(filter)
+	if err != nil {
+		return nil, errors.New("failed to retrieve clusters")
+	}
+
+	status, search := args.String("status"), strings.ToLower(args.String("q"))
+	matching := make([]db.Cluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		if (status == "" || string(cluster.Status) == status) && hasLabels(cluster.Labels, labels) &&
+			strings.Contains(strings.ToLower(cluster.Name), search) {
+			matching = append(matching, cluster)
+		}
+	}
+	return matching, nil
+}
+
+// resolveJobs lists the jobs of the caller's projects, newest first
+func (h *GraphQLHandler) resolveJobs(ctx context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+	filter := db.JobFilter{Status: args.String("status"), Type: args.String("type"), Limit: graphqlLimit(args)}
+	if value := args.String("cluster_id"); value != "" {
+		id, err := graphqlID(value)
+		if err != nil {
+			return nil, err
+		}
+		if c, err := h.visibleCluster(ctx, id); c == nil {
+			return []db.Job{}, err
+		}
+		filter.ClusterID = id
+	} else {
+		filter.ProjectIDs = projectFilter(h.store.DB(), graphqlRequest(ctx))
+	}
+	jobs, err := h.store.Jobs.List(filter)
+	if err != nil {
+		return nil, errors.New("failed to retrieve jobs")
+	}
+	return jobs, nil
+}
+
+// visibleCluster returns a cluster of the caller's projects, nil when it
+// does not exist or belongs to another project
+func (h *GraphQLHandler) visibleCluster(ctx context.Context, id uint) (interface{}, error) {
+	cluster, err := h.store.Clusters.Get(id)
+	if err != nil {
+		return nil, nil
+	}
+	if projectRole(h.store.DB(), CurrentUser(graphqlRequest(ctx)), cluster.ProjectID) == "" {
+		return nil, nil
+	}
+	return *cluster, nil
+}
+
+// events returns the newest events of a cluster passing the event arguments
+func (h *GraphQLHandler) events(clusterID uint, args graphql.Args) (interface{}, error) {
+	filter, err := graphqlEventFilter(args)
+	if err != nil {
+		return nil, err
+	}
+	events, err := h.store.Events.List(filter.Query(clusterID, graphqlLimit(args)))
+	if err != nil {
+		return nil, errors.New("failed to retrieve events")
+	}
+	return events, nil
+}
+
+// graphqlEventFilter reads an event filter from the event arguments
+func graphqlEventFilter(args graphql.Args) (EventFilter, error) {
+	query := url.Values{}
+	for name := range eventArgs {
+		if value := args.String(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	return parseEventFilter(query)
+}
+
+// subscribe registers a hub client receiving the messages accept lets
+// through, of a cluster of the caller's projects or of all of them
+func (h *GraphQLHandler) subscribe(ctx context.Context, args graphql.Args, accept func(interface{}) bool) (<-chan interface{}, error) {
+	filter, err := graphqlEventFilter(args)
+	if err != nil {
+		return nil, err
+	}
+	r := graphqlRequest(ctx)
+	client := &Client{clusterID: firehose, filter: filter, store: h.store}
+	if value := args.String("cluster_id"); value != "" {
+		id, err := graphqlID(value)
+		if err != nil {
+			return nil, err
+		}
+		if c, _ := h.visibleCluster(ctx, id); c == nil {
+			return nil, fmt.Errorf("cluster %d not found", id)
+		}
+		client.clusterID = id
+	} else {
+		client.projects, client.allProjects = memberProjects(h.store.DB(), r)
+	}
+
+	return subscribe(ctx, client, accept), nil
+}
+
+// graphqlID parses the ID of a record
+func graphqlID(value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q", value)
+	}
+	return uint(id), nil
+}
+
+// graphqlLimit returns the limit argument of a list, within bounds
+func graphqlLimit(args graphql.Args) int {
+	limit := args.Int("limit", graphqlDefaultLimit)
+	if limit < 1 {
+		return 1
+	}
+	if limit > graphqlMaxLimit {
+		return graphqlMaxLimit
+	}
+	return limit
+}
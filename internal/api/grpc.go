@@ -0,0 +1,534 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	kubeforgev1 "kubeforge/api/kubeforge/v1"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+)
+
+// grpcJobPollInterval is how often a job progress stream checks whether its
+// job finished
+const grpcJobPollInterval = 5 * time.Second
+
+// grpcMaxHistory is the most recent events a WatchEvents stream starts with
+const grpcMaxHistory = 1000
+
+// GRPCServer serves the gRPC API defined in api/kubeforge/v1. Unary calls
+// run the matching REST route in process, so they are authenticated,
+// authorized, validated and audited like HTTP requests; streams are fed by
+// the WebSocket hub.
+type GRPCServer struct {
+	server *grpc.Server
+	routes *grpcRoutes
+}
+
+// NewGRPCServer creates a gRPC server running the routes of handler, the
+// REST API router
+func NewGRPCServer(handler http.Handler, store *db.Store, tokens *auth.TokenManager) *GRPCServer {
+	routes := &grpcRoutes{handler: handler, store: store, tokens: tokens, done: make(chan struct{})}
+	server := grpc.NewServer(
+		// Keep idle streams alive through proxies, like the WebSocket pings
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: 30 * time.Second}),
+	)
+	kubeforgev1.RegisterClusterServiceServer(server, &grpcClusterService{routes: routes})
+	kubeforgev1.RegisterJobServiceServer(server, &grpcJobService{routes: routes})
+	reflection.Register(server)
+	return &GRPCServer{server: server, routes: routes}
+}
+
+// Serve accepts connections on the listener until the server is shut down
+func (s *GRPCServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown ends the streams, waits for the running calls and closes the
+// connections, or closes them right away once ctx is done
+func (s *GRPCServer) Shutdown(ctx context.Context) {
+	close(s.routes.done)
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+// grpcRoutes runs gRPC calls against the REST API
+type grpcRoutes struct {
+	handler http.Handler
+	store   *db.Store
+	tokens  *auth.TokenManager
+	done    chan struct{} // closed on shutdown to end the streams
+}
+
+// call runs a REST route with the caller's token, request ID and address
+// and returns the data of its response, or the error of the route as a gRPC
+// status
+func (g *grpcRoutes) call(ctx context.Context, method, path string, query url.Values, body interface{}, header http.Header) (json.RawMessage, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		r.Header.Set("Authorization", values[0])
+	}
+	if values := md.Get(strings.ToLower(RequestIDHeader)); len(values) > 0 {
+		r.Header.Set(RequestIDHeader, values[0])
+	}
+	if values := md.Get("user-agent"); len(values) > 0 {
+		r.Header.Set("User-Agent", values[0])
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	w := httptest.NewRecorder()
+	g.handler.ServeHTTP(w, r)
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), id))
+	}
+
+	var resp struct {
+		Data  json.RawMessage `json:"data"`
+		Error *ErrorInfo      `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid response of %s %s", method, path)
+	}
+	if w.Code >= http.StatusBadRequest {
+		return nil, grpcError(w.Code, resp.Error)
+	}
+	return resp.Data, nil
+}
+
+// grpcError converts the error of a REST response to a gRPC status, with
+// the invalid fields of a validation error as details
+func grpcError(statusCode int, info *ErrorInfo) error {
+	code := codes.Unknown
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	default:
+		if statusCode >= http.StatusInternalServerError {
+			code = codes.Internal
+		}
+	}
+	if info == nil {
+		return status.Error(code, http.StatusText(statusCode))
+	}
+
+	st := status.New(code, info.Message)
+	if len(info.Details) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(info.Details))
+		for _, detail := range info.Details {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: detail.Field, Description: detail.Message})
+		}
+		if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// grpcDecode decodes the data of a REST response into a message. The JSON
+// of the REST API has the field names of the messages; fields the messages
+// leave out are ignored. A list is decoded into the field of the message
+// named by list.
+func grpcDecode(data json.RawMessage, list string, out proto.Message) error {
+	if list != "" {
+		wrapped, err := json.Marshal(map[string]json.RawMessage{list: data})
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		data = wrapped
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, out); err != nil {
+		return status.Errorf(codes.Internal, "failed to convert response: %v", err)
+	}
+	return nil
+}
+
+// authenticate returns a request carrying the claims of the caller's token,
+// for the checks of streams which do not run a REST route throughout
+func (g *grpcRoutes) authenticate(ctx context.Context) (*http.Request, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	claims, err := g.tokens.Verify(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, userContextKey, claims), http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return r, nil
+}
+
+// follow passes the hub messages of a subscription, and nil on each tick,
+// to send until it reports the stream done, the client goes away, the
+// subscription falls behind or the server shuts down
+func (g *grpcRoutes) follow(ctx context.Context, messages <-chan interface{}, tick <-chan time.Time, send func(message interface{}) (bool, error)) error {
+	for {
+		var message interface{}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.done:
+			return status.Error(codes.Unavailable, "Server is shutting down")
+		case m, ok := <-messages:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "Stream fell behind")
+			}
+			message = m
+		case <-tick:
+		}
+		if done, err := send(message); done || err != nil {
+			return err
+		}
+	}
+}
+
+// grpcClusterService implements ClusterService
+type grpcClusterService struct {
+	kubeforgev1.UnimplementedClusterServiceServer
+	routes *grpcRoutes
+}
+
+// ListClusters runs GET /api/v1/clusters
+func (s *grpcClusterService) ListClusters(ctx context.Context, req *kubeforgev1.ListClustersRequest) (*kubeforgev1.ListClustersResponse, error) {
+	query := url.Values{"label": req.Labels}
+	if req.Query != "" {
+		query.Set("q", req.Query)
+	}
+	if req.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	data, err := s.routes.call(ctx, http.MethodGet, "/api/v1/clusters", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kubeforgev1.ListClustersResponse{}
+	return resp, grpcDecode(data, "clusters", resp)
+}
+
+// GetCluster runs GET /api/v1/clusters/{id}
+func (s *grpcClusterService) GetCluster(ctx context.Context, req *kubeforgev1.GetClusterRequest) (*kubeforgev1.Cluster, error) {
+	data, err := s.routes.call(ctx, http.MethodGet, clusterPath(req.Id, ""), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &kubeforgev1.Cluster{}
+	return cluster, grpcDecode(data, "", cluster)
+}
+
+// CreateCluster runs POST /api/v1/clusters with the spec as body
+func (s *grpcClusterService) CreateCluster(ctx context.Context, req *kubeforgev1.CreateClusterRequest) (*kubeforgev1.CreateClusterResponse, error) {
+	header := http.Header{}
+	if req.IdempotencyKey != "" {
+		header.Set(IdempotencyKeyHeader, req.IdempotencyKey)
+	}
+	data, err := s.routes.call(ctx, http.MethodPost, "/api/v1/clusters", nil, req.Spec.AsMap(), header)
+	if err != nil {
+		return nil, err
+	}
+	// The REST response is the cluster with the job ID and warnings
+	resp := &kubeforgev1.CreateClusterResponse{Cluster: &kubeforgev1.Cluster{}}
+	if err := grpcDecode(data, "", resp.Cluster); err != nil {
+		return nil, err
+	}
+	cluster := resp.Cluster
+	if err := grpcDecode(data, "", resp); err != nil {
+		return nil, err
+	}
+	resp.Cluster = cluster
+	return resp, nil
+}
+
+// DeleteCluster runs DELETE /api/v1/clusters/{id}
+func (s *grpcClusterService) DeleteCluster(ctx context.Context, req *kubeforgev1.DeleteClusterRequest) (*kubeforgev1.DeleteClusterResponse, error) {
+	if _, err := s.routes.call(ctx, http.MethodDelete, clusterPath(req.Id, ""), nil, nil, nil); err != nil {
+		return nil, err
+	}
+	return &kubeforgev1.DeleteClusterResponse{}, nil
+}
+
+// ListNodes runs GET /api/v1/clusters/{id}/nodes
+func (s *grpcClusterService) ListNodes(ctx context.Context, req *kubeforgev1.ListNodesRequest) (*kubeforgev1.ListNodesResponse, error) {
+	query := url.Values{"label": req.Labels}
+	if req.Role != "" {
+		query.Set("role", req.Role)
+	}
+	data, err := s.routes.call(ctx, http.MethodGet, clusterPath(req.ClusterId, "/nodes"), query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kubeforgev1.ListNodesResponse{}
+	return resp, grpcDecode(data, "nodes", resp)
+}
+
+// ListEvents runs GET /api/v1/clusters/{id}/events
+func (s *grpcClusterService) ListEvents(ctx context.Context, req *kubeforgev1.ListEventsRequest) (*kubeforgev1.ListEventsResponse, error) {
+	query := eventQuery(req.Level, req.Host, req.Step, req.Phase, req.JobId)
+	data, err := s.routes.call(ctx, http.MethodGet, clusterPath(req.ClusterId, "/events"), query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kubeforgev1.ListEventsResponse{}
+	return resp, grpcDecode(data, "events", resp)
+}
+
+// WatchEvents streams the events of a cluster the caller can see, after
+// the requested number of recent ones, or the events of all clusters of the
+// caller's projects
+func (s *grpcClusterService) WatchEvents(req *kubeforgev1.WatchEventsRequest, stream kubeforgev1.ClusterService_WatchEventsServer) error {
+	ctx := stream.Context()
+	r, err := s.routes.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	filter, err := parseEventFilter(eventQuery(req.Level, req.Host, req.Step, req.Phase, req.JobId))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	client := &Client{clusterID: firehose, filter: filter, store: s.routes.store}
+	if req.ClusterId != 0 {
+		// The cluster route checks that the caller may see the cluster
+		if _, err := s.routes.call(ctx, http.MethodGet, clusterPath(req.ClusterId, ""), nil, nil, nil); err != nil {
+			return err
+		}
+		client.clusterID = uint(req.ClusterId)
+	} else {
+		client.projects, client.allProjects = memberProjects(s.routes.store.DB(), r)
+	}
+	messages := subscribe(ctx, client, func(message interface{}) bool {
+		_, ok := message.(db.Event)
+		return ok
+	})
+
+	if req.ClusterId != 0 && req.History > 0 {
+		events, err := eventStore.List(filter.Query(client.clusterID, min(int(req.History), grpcMaxHistory)))
+		if err != nil {
+			return status.Error(codes.Internal, "Failed to retrieve events")
+		}
+		// Oldest first, as they were recorded
+		for i := len(events) - 1; i >= 0; i-- {
+			if err := sendEvent(stream, events[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.routes.follow(ctx, messages, nil, func(message interface{}) (bool, error) {
+		return false, sendEvent(stream, message.(db.Event))
+	})
+}
+
+// sendEvent sends an event on a WatchEvents stream
+func sendEvent(stream kubeforgev1.ClusterService_WatchEventsServer, event db.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	message := &kubeforgev1.Event{}
+	if err := grpcDecode(data, "", message); err != nil {
+		return err
+	}
+	return stream.Send(message)
+}
+
+// grpcJobService implements JobService
+type grpcJobService struct {
+	kubeforgev1.UnimplementedJobServiceServer
+	routes *grpcRoutes
+}
+
+// ListJobs runs GET /api/v1/jobs, or /api/v1/clusters/{id}/jobs for the
+// jobs of a cluster
+func (s *grpcJobService) ListJobs(ctx context.Context, req *kubeforgev1.ListJobsRequest) (*kubeforgev1.ListJobsResponse, error) {
+	path := "/api/v1/jobs"
+	if req.ClusterId != 0 {
+		path = clusterPath(req.ClusterId, "/jobs")
+	}
+	query := url.Values{}
+	if req.Status != "" {
+		query.Set("status", req.Status)
+	}
+	if req.Type != "" {
+		query.Set("type", req.Type)
+	}
+	if req.ParentId != 0 {
+		query.Set("parent_id", strconv.FormatUint(req.ParentId, 10))
+	}
+	if req.Limit > 0 {
+		query.Set("limit", strconv.Itoa(int(req.Limit)))
+	}
+	data, err := s.routes.call(ctx, http.MethodGet, path, query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kubeforgev1.ListJobsResponse{}
+	return resp, grpcDecode(data, "jobs", resp)
+}
+
+// GetJob runs GET /api/v1/jobs/{id}
+func (s *grpcJobService) GetJob(ctx context.Context, req *kubeforgev1.GetJobRequest) (*kubeforgev1.Job, error) {
+	data, err := s.routes.call(ctx, http.MethodGet, jobPath(req.Id, ""), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	job := &kubeforgev1.Job{}
+	return job, grpcDecode(data, "", job)
+}
+
+// CancelJob runs POST /api/v1/jobs/{id}/cancel
+func (s *grpcJobService) CancelJob(ctx context.Context, req *kubeforgev1.CancelJobRequest) (*kubeforgev1.CancelJobResponse, error) {
+	if _, err := s.routes.call(ctx, http.MethodPost, jobPath(req.Id, "/cancel"), nil, nil, nil); err != nil {
+		return nil, err
+	}
+	return &kubeforgev1.CancelJobResponse{}, nil
+}
+
+// WatchJobProgress sends the state of a job the caller can see, then its
+// progress updates, and ends with its final status once it finished
+func (s *grpcJobService) WatchJobProgress(req *kubeforgev1.WatchJobProgressRequest, stream kubeforgev1.JobService_WatchJobProgressServer) error {
+	ctx := stream.Context()
+	r, err := s.routes.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	// The job route checks that the caller may see the job
+	data, err := s.routes.call(ctx, http.MethodGet, jobPath(req.Id, ""), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	job := &kubeforgev1.Job{}
+	if err := grpcDecode(data, "", job); err != nil {
+		return err
+	}
+
+	client := &Client{clusterID: uint(job.ClusterId), filter: EventFilter{JobID: uint(job.Id)}, store: s.routes.store}
+	if job.ClusterId == 0 {
+		client.projects, client.allProjects = memberProjects(s.routes.store.DB(), r)
+	}
+	messages := subscribe(ctx, client, func(message interface{}) bool {
+		_, ok := message.(JobProgress)
+		return ok
+	})
+
+	if err := stream.Send(&kubeforgev1.JobProgress{JobId: job.Id, ClusterId: job.ClusterId, Phase: job.Phase, Progress: job.Progress, Status: job.Status}); err != nil || finishedJob(job.Status) {
+		return err
+	}
+
+	ticker := time.NewTicker(grpcJobPollInterval)
+	defer ticker.Stop()
+	return s.routes.follow(ctx, messages, ticker.C, func(message interface{}) (bool, error) {
+		if progress, ok := message.(JobProgress); ok {
+			return false, stream.Send(&kubeforgev1.JobProgress{
+				JobId:     uint64(progress.JobID),
+				ClusterId: uint64(progress.ClusterID),
+				Phase:     progress.Phase,
+				Progress:  int32(progress.Progress),
+			})
+		}
+		current, err := s.routes.store.Jobs.Get(uint(job.Id))
+		if err != nil || !finishedJob(current.Status) {
+			return false, nil
+		}
+		return true, stream.Send(&kubeforgev1.JobProgress{
+			JobId:     uint64(current.ID),
+			ClusterId: uint64(current.ClusterID),
+			Phase:     current.Phase,
+			Progress:  int32(current.Progress),
+			Status:    current.Status,
+		})
+	})
+}
+
+// eventQuery returns the query parameters of an event filter
+func eventQuery(level, host, step, phase string, jobID uint64) url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{"level": level, "host": host, "step": step, "phase": phase} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if jobID != 0 {
+		query.Set("job_id", strconv.FormatUint(jobID, 10))
+	}
+	return query
+}
+
+// clusterPath returns the REST path of a cluster, or of a route under it
+func clusterPath(id uint64, suffix string) string {
+	return "/api/v1/clusters/" + strconv.FormatUint(id, 10) + suffix
+}
+
+// jobPath returns the REST path of a job, or of a route under it
+func jobPath(id uint64, suffix string) string {
+	return "/api/v1/jobs/" + strconv.FormatUint(id, 10) + suffix
+}
@@ -2,7 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+
+	"kubeforge/internal/validation"
 )
 
 // Response is a standard API response envelope
@@ -14,8 +17,11 @@ type Response struct {
 
 // ErrorInfo contains error details
 type ErrorInfo struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Details []validation.FieldError `json:"details,omitempty"` // invalid fields of a VALIDATION_FAILED error
+
+	Warnings []validation.FieldError `json:"warnings,omitempty"` // spec lint warnings found along with the errors
 }
 
 // WriteJSON writes a JSON response with the given status code
@@ -41,6 +47,16 @@ func WriteCreated(w http.ResponseWriter, data interface{}) {
 	})
 }
 
+// WriteAccepted writes a 202 Accepted response for a request that started
+// background work. location is the resource to poll for its progress.
+func WriteAccepted(w http.ResponseWriter, location string, data interface{}) {
+	w.Header().Set("Location", location)
+	WriteJSON(w, http.StatusAccepted, Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
 // WriteError writes an error JSON response
 func WriteError(w http.ResponseWriter, statusCode int, code, message string) {
 	WriteJSON(w, statusCode, Response{
@@ -57,6 +73,32 @@ func WriteBadRequest(w http.ResponseWriter, message string) {
 	WriteError(w, http.StatusBadRequest, "BAD_REQUEST", message)
 }
 
+// WriteValidationError writes a 400 Bad Request error listing every invalid field
+func WriteValidationError(w http.ResponseWriter, errs validation.Errors) {
+	WriteJSON(w, http.StatusBadRequest, Response{
+		Success: false,
+		Error: &ErrorInfo{
+			Code:    "VALIDATION_FAILED",
+			Message: "Request validation failed",
+			Details: errs,
+		},
+	})
+}
+
+// WriteSpecValidationError writes a 400 Bad Request error listing every
+// invalid field of a cluster spec and the warnings of linting it
+func WriteSpecValidationError(w http.ResponseWriter, errs, warnings validation.Errors) {
+	WriteJSON(w, http.StatusBadRequest, Response{
+		Success: false,
+		Error: &ErrorInfo{
+			Code:     "VALIDATION_FAILED",
+			Message:  "Request validation failed",
+			Details:  errs,
+			Warnings: warnings,
+		},
+	})
+}
+
 // WriteNotFound writes a 404 Not Found error
 func WriteNotFound(w http.ResponseWriter, message string) {
 	WriteError(w, http.StatusNotFound, "NOT_FOUND", message)
@@ -67,7 +109,19 @@ func WriteInternalError(w http.ResponseWriter, message string) {
 	WriteError(w, http.StatusInternalServerError, "INTERNAL_ERROR", message)
 }
 
-// ParseJSON parses JSON request body into the given struct
+// ParseJSON parses JSON request body into the given struct. A body sent as
+// Content-Type: application/yaml is parsed as YAML with the same field names.
 func ParseJSON(r *http.Request, v interface{}) error {
+	if isYAML(r.Header.Get("Content-Type")) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		converted, err := yamlToJSON(body)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(converted, v)
+	}
 	return json.NewDecoder(r.Body).Decode(v)
 }
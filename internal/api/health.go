@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+)
+
+// healthCheckTimeout bounds each dependency check of a readiness probe
+const healthCheckTimeout = 2 * time.Second
+
+// Component statuses
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// ComponentStatus is the state of one dependency of the server
+type ComponentStatus struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Latency string      `json:"latency,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// HealthReport is returned by the readiness probe
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Version    string                     `json:"version"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	store   *db.Store
+	queue   *jobs.Queue
+	version string
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler(store *db.Store, queue *jobs.Queue, version string) *HealthHandler {
+	return &HealthHandler{store: store, queue: queue, version: version}
+}
+
+// RegisterRoutes registers health routes. They live outside /api so probes
+// need no access token.
+func (h *HealthHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/livez", h.Live).Methods("GET")
+	router.HandleFunc("/readyz", h.Ready).Methods("GET")
+	router.HandleFunc("/healthz", h.Live).Methods("GET")
+}
+
+// Live reports that the process is up and serving requests. It checks no
+// dependencies, so an unreachable database does not get the server restarted.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	WriteSuccess(w, map[string]string{
+		"status":  "ok",
+		"version": h.version,
+	})
+}
+
+// Ready reports whether the server can handle traffic: the database answers
+// and the job workers are running. It returns 503 with the failing
+// components otherwise.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{
+		Status:  StatusUp,
+		Version: h.version,
+		Components: map[string]ComponentStatus{
+			"database": h.checkDatabase(r.Context()),
+			"jobs":     h.checkJobs(),
+		},
+	}
+
+	status := http.StatusOK
+	for _, component := range report.Components {
+		if component.Status != StatusUp {
+			report.Status = StatusDown
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	WriteJSON(w, status, Response{
+		Success: status == http.StatusOK,
+		Data:    report,
+	})
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.Ping(ctx, h.store.DB())
+	component := ComponentStatus{Status: StatusUp, Latency: time.Since(start).String()}
+	if err != nil {
+		component.Status = StatusDown
+		component.Error = err.Error()
+	}
+	return component
+}
+
+func (h *HealthHandler) checkJobs() ComponentStatus {
+	health, err := h.queue.Health()
+	component := ComponentStatus{Status: StatusUp, Details: health}
+	if err != nil {
+		component.Status = StatusDown
+		component.Error = err.Error()
+	}
+	return component
+}
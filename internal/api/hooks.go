@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"kubeforge/internal/provision"
+)
+
+// hookRun identifies a run of a hook on a host in the provisioning
+// checkpoint
+func hookRun(hook provision.HookSpec, address string) string {
+	return hook.Stage + "/" + hook.Name + "/" + address
+}
+
+// runHooks runs the hooks of a stage for each host they apply to and
+// records their output as events. A failed hook fails the stage unless it
+// continues on error. With a checkpoint, runs that completed are recorded
+// and skipped when a resumed job reaches the stage again.
+func (h *ClusterHandler) runHooks(ctx context.Context, clusterID uint, spec provision.ClusterSpec, stage string,
+	checkpoint *provisionCheckpoint, save func(string), hosts ...provision.HostSpec) error {
+	for _, hook := range spec.Hooks {
+		if hook.Stage != stage {
+			continue
+		}
+		for _, host := range hosts {
+			role := "worker"
+			for _, cp := range spec.ControlPlanes {
+				if cp.Address == host.Address {
+					role = "control-plane"
+				}
+			}
+			run := hookRun(hook, host.Address)
+			if !hook.RunsOn(role) || (checkpoint != nil && containsString(checkpoint.Hooks, run)) {
+				continue
+			}
+
+			target := provision.HookTarget{
+				Cluster: spec.Name,
+				Stage:   stage,
+				Hook:    hook.Name,
+				Host:    host.Hostname,
+				Address: host.Address,
+				Role:    role,
+			}
+			var output string
+			err := provision.RunStep(ctx, "hook "+hook.Name+" "+host.Address, provision.Duration(hook.TimeoutDuration()), func(ctx context.Context) error {
+				var err error
+				output, err = provision.RunHook(ctx, hook, target, host)
+				return err
+			})
+			if output != "" {
+				output = ": " + output
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if !hook.ContinueOnError {
+					h.logEvent(clusterID, "error", host.Address, "hook", fmt.Sprintf("Hook %s failed at %s: %v%s", hook.Name, stage, err, output))
+					return fmt.Errorf("hook %s failed on %s: %w", hook.Name, host.Address, err)
+				}
+				h.logEvent(clusterID, "warn", host.Address, "hook", fmt.Sprintf("Hook %s failed at %s, continuing: %v%s", hook.Name, stage, err, output))
+			} else {
+				h.logEvent(clusterID, "info", host.Address, "hook", fmt.Sprintf("Hook %s completed at %s%s", hook.Name, stage, output))
+			}
+			if checkpoint != nil {
+				checkpoint.Hooks = append(checkpoint.Hooks, run)
+				save("")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+)
+
+// Host key statuses
+const (
+	HostKeyTrusted = "trusted"
+	HostKeyPending = "pending"
+)
+
+// HostKeyStore keeps SSH host keys in the database, trusting the first key
+// seen for a host
+type HostKeyStore struct {
+	store *db.Store
+}
+
+// NewHostKeyStore creates a host key store keeping the keys in store
+func NewHostKeyStore(store *db.Store) HostKeyStore {
+	return HostKeyStore{store: store}
+}
+
+// CheckHostKey implements provision.HostKeyStore
+func (s HostKeyStore) CheckHostKey(host string, key provision.HostKey) (bool, error) {
+	trusted := false
+	err := s.store.DB().Transaction(func(tx *gorm.DB) error {
+		var known []db.HostKey
+		if err := tx.Where("host = ?", host).Find(&known).Error; err != nil {
+			return err
+		}
+		var record *db.HostKey
+		hasTrusted := false
+		for i := range known {
+			if known[i].Status == HostKeyTrusted {
+				hasTrusted = true
+			}
+			if known[i].Fingerprint == key.Fingerprint {
+				record = &known[i]
+			}
+		}
+
+		now := time.Now()
+		if record != nil {
+			// A pending key is trusted once the host has no other key left
+			trusted = record.Status == HostKeyTrusted || !hasTrusted
+			status := HostKeyPending
+			if trusted {
+				status = HostKeyTrusted
+			}
+			return tx.Model(record).Updates(map[string]interface{}{"status": status, "last_seen_at": now}).Error
+		}
+
+		// Trust on first use
+		record = &db.HostKey{
+			Host:        host,
+			Type:        key.Type,
+			Fingerprint: key.Fingerprint,
+			PublicKey:   key.PublicKey,
+			Status:      HostKeyPending,
+			LastSeenAt:  now,
+		}
+		if !hasTrusted {
+			record.Status = HostKeyTrusted
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Recorded by a concurrent connection to the same host
+			if err := tx.Where("host = ? AND fingerprint = ?", host, key.Fingerprint).First(record).Error; err != nil {
+				return err
+			}
+		}
+		trusted = record.Status == HostKeyTrusted
+		return nil
+	})
+	if err == nil && !trusted {
+		slog.Warn("Host presented an untrusted SSH host key", "host", host, "type", key.Type, "fingerprint", key.Fingerprint)
+	}
+	return trusted, err
+}
+
+// HostKeyHandler handles the review of SSH host keys
+type HostKeyHandler struct {
+	store *db.Store
+}
+
+// NewHostKeyHandler creates a new host key handler
+func NewHostKeyHandler(store *db.Store) *HostKeyHandler {
+	return &HostKeyHandler{store: store}
+}
+
+// RegisterRoutes registers host key API routes
+func (h *HostKeyHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/host-keys", h.ListHostKeys).Methods("GET")
+	router.HandleFunc("/api/v1/host-keys", h.ClearHost).Methods("DELETE")
+	router.HandleFunc("/api/v1/host-keys/{id}/approve", h.ApproveHostKey).Methods("POST")
+	router.HandleFunc("/api/v1/host-keys/{id}", h.DeleteHostKey).Methods("DELETE")
+}
+
+// ListHostKeys lists host keys, optionally filtered by host and status.
+// Callers who do not see every project only get the keys of the nodes and
+// inventory hosts of their projects.
+func (h *HostKeyHandler) ListHostKeys(w http.ResponseWriter, r *http.Request) {
+	query := h.store.DB().Order("host, id")
+	if hosts, all := projectHostAddresses(h.store.DB(), r); !all {
+		if len(hosts) == 0 {
+			WriteSuccess(w, []db.HostKey{})
+			return
+		}
+		query = query.Where("host IN ?", hosts)
+	}
+	if host := r.URL.Query().Get("host"); host != "" {
+		query = query.Where("host = ?", host)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var keys []db.HostKey
+	if err := query.Find(&keys).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve host keys")
+		return
+	}
+
+	WriteSuccess(w, keys)
+}
+
+// projectHostAddresses returns the address:port, as host keys are recorded
+// with, of the nodes and inventory hosts of the caller's projects. all is
+// true for callers who see every project.
+func projectHostAddresses(conn *gorm.DB, r *http.Request) (addresses []string, all bool) {
+	if _, all := memberProjects(conn, r); all {
+		return nil, true
+	}
+
+	type endpoint struct {
+		Address string
+		Port    int
+	}
+	var nodes, hosts []endpoint
+	clusters := scopeProjects(conn, r, conn.Model(&db.Cluster{}).Select("id"), "project_id")
+	conn.Model(&db.Node{}).Where("cluster_id IN (?)", clusters).Select("address", "port").Scan(&nodes)
+	scopeProjects(conn, r, conn.Model(&db.Host{}), "project_id").Select("address", "port").Scan(&hosts)
+
+	for _, e := range append(nodes, hosts...) {
+		addresses = append(addresses, fmt.Sprintf("%s:%d", e.Address, e.Port))
+	}
+	return addresses, false
+}
+
+// ApproveHostKey trusts a pending key in place of the keys trusted for its
+// host so far, e.g. after the host was reinstalled
+func (h *HostKeyHandler) ApproveHostKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.loadHostKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("host = ? AND id <> ?", key.Host, key.ID).Delete(&db.HostKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(key).Update("status", HostKeyTrusted).Error
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to approve host key")
+		return
+	}
+
+	WriteSuccess(w, key)
+}
+
+// DeleteHostKey forgets a host key
+func (h *HostKeyHandler) DeleteHostKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.loadHostKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DB().Delete(key).Error; err != nil {
+		WriteInternalError(w, "Failed to delete host key")
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Host key deleted"})
+}
+
+// ClearHost forgets all keys of a host, so the next key it presents is
+// trusted again
+func (h *HostKeyHandler) ClearHost(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		WriteBadRequest(w, "The host query parameter is required")
+		return
+	}
+
+	result := h.store.DB().Where("host = ?", host).Delete(&db.HostKey{})
+	if result.Error != nil {
+		WriteInternalError(w, "Failed to clear host keys")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{"message": "Host keys cleared", "deleted": result.RowsAffected})
+}
+
+// loadHostKey resolves the host key from the request path
+func (h *HostKeyHandler) loadHostKey(w http.ResponseWriter, r *http.Request) (*db.HostKey, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid host key ID")
+		return nil, false
+	}
+
+	var key db.HostKey
+	if err := h.store.DB().First(&key, id).Error; err != nil {
+		WriteNotFound(w, "Host key not found")
+		return nil, false
+	}
+	return &key, true
+}
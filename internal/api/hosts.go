@@ -0,0 +1,536 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/bmc"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// factsTimeout bounds connecting to an inventory host and reading its facts
+const factsTimeout = provision.Duration(time.Minute)
+
+// inventoryMu keeps concurrent cluster requests from picking the same free
+// inventory hosts
+var inventoryMu sync.Mutex
+
+// HostRequest registers an inventory host or replaces its settings
+type HostRequest struct {
+	ProjectID        uint                `json:"project_id,omitempty"`
+	Hostname         string              `json:"hostname"`
+	Address          string              `json:"address"`
+	User             string              `json:"user"`
+	Port             int                 `json:"port"`
+	SSHKeyPath       string              `json:"ssh_key_path,omitempty"`
+	SSHKeyID         uint                `json:"ssh_key_id,omitempty"`
+	SSHKeyName       string              `json:"ssh_key_name,omitempty"`
+	SSHKeyPassphrase string              `json:"ssh_key_passphrase,omitempty"`
+	SSHAgent         bool                `json:"ssh_agent,omitempty"`
+	UseSudo          bool                `json:"use_sudo,omitempty"`
+	SudoPassword     string              `json:"sudo_password,omitempty"`
+	Bastion          *provision.HostSpec `json:"bastion,omitempty"`
+	BMC              *bmc.Spec           `json:"bmc,omitempty"`
+	Labels           map[string]string   `json:"labels,omitempty"`
+}
+
+// hostSpec returns the SSH settings of the request as a host spec
+func (req *HostRequest) hostSpec() provision.HostSpec {
+	return provision.HostSpec{
+		Hostname:         req.Hostname,
+		Address:          req.Address,
+		User:             req.User,
+		Port:             req.Port,
+		SSHKeyPath:       req.SSHKeyPath,
+		SSHKeyID:         req.SSHKeyID,
+		SSHKeyName:       req.SSHKeyName,
+		SSHKeyPassphrase: req.SSHKeyPassphrase,
+		SSHAgent:         req.SSHAgent,
+		UseSudo:          req.UseSudo,
+		SudoPassword:     req.SudoPassword,
+		Bastion:          req.Bastion,
+		BMC:              req.BMC,
+	}
+}
+
+// Validate checks the request and returns every invalid field
+func (req *HostRequest) Validate() validation.Errors {
+	var errs validation.Errors
+	if req.Hostname == "" {
+		errs.Add("hostname", validation.CodeRequired, "hostname is required")
+	}
+	host := req.hostSpec()
+	host.SetDefaults()
+	errs = append(errs, host.ValidateFields("")...)
+	for key := range req.Labels {
+		if key == "" {
+			errs.Add("labels", validation.CodeInvalid, "label keys must not be empty")
+		}
+	}
+	return errs
+}
+
+// HostResponse is an inventory host and the cluster running on it, if any
+type HostResponse struct {
+	db.Host
+	ClusterID uint `json:"cluster_id,omitempty"`
+}
+
+// HostSelector picks free, reachable inventory hosts of the project of a
+// cluster that pass the preflight checks
+type HostSelector struct {
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels,omitempty"` // hosts must have every label
+}
+
+// HostHandler handles host inventory API requests
+type HostHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
+
+// NewHostHandler creates a new host inventory handler and registers its job handlers
+func NewHostHandler(store *db.Store, queue *jobs.Queue) *HostHandler {
+	h := &HostHandler{store: store, queue: queue}
+	queue.RegisterHandler("discover", trackJob(h.runDiscoveryJob))
+	return h
+}
+
+// RegisterRoutes registers host inventory API routes
+func (h *HostHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/hosts", h.ListHosts).Methods("GET")
+	router.HandleFunc("/api/v1/hosts", h.CreateHost).Methods("POST")
+	router.HandleFunc("/api/v1/hosts/discover", h.DiscoverHosts).Methods("POST")
+	router.HandleFunc("/api/v1/hosts/{id}", h.GetHost).Methods("GET")
+	router.HandleFunc("/api/v1/hosts/{id}", h.UpdateHost).Methods("PUT")
+	router.HandleFunc("/api/v1/hosts/{id}", h.DeleteHost).Methods("DELETE")
+	router.HandleFunc("/api/v1/hosts/{id}/facts", h.RefreshFacts).Methods("POST")
+}
+
+// ListHosts lists the inventory hosts of the caller's projects, filtered by
+// ?label=key=value (repeatable, all must match) and ?free=true|false
+func (h *HostHandler) ListHosts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	labels, err := parseLabelFilter(query["label"])
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	var hosts []db.Host
+	if err := scopeProjects(h.store.DB(), r, h.store.DB(), "project_id").Order("hostname").Find(&hosts).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve hosts")
+		return
+	}
+	clusters, err := hostClusters(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve nodes")
+		return
+	}
+
+	free := query.Get("free")
+	responses := make([]HostResponse, 0, len(hosts))
+	for _, host := range hosts {
+		clusterID := clusters[host.ID]
+		if !hasLabels(host.Labels, labels) || (free == "true" && clusterID != 0) || (free == "false" && clusterID == 0) {
+			continue
+		}
+		responses = append(responses, HostResponse{Host: host, ClusterID: clusterID})
+	}
+	WriteSuccess(w, responses)
+}
+
+// GetHost retrieves a single inventory host by ID
+func (h *HostHandler) GetHost(w http.ResponseWriter, r *http.Request) {
+	host, ok := loadHost(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	WriteSuccess(w, hostResponse(h.store.DB(), *host))
+}
+
+// CreateHost registers a host in the inventory of a project and collects
+// its facts over SSH. Unreachable hosts are registered too, with the error.
+func (h *HostHandler) CreateHost(w http.ResponseWriter, r *http.Request) {
+	var req HostRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	// Resolve the project and require operator access to it
+	if req.ProjectID == 0 {
+		req.ProjectID = defaultClusterProject(h.store.DB(), r)
+	}
+	switch role := projectRole(h.store.DB(), CurrentUser(r), req.ProjectID); {
+	case role == "":
+		WriteNotFound(w, "Project not found")
+		return
+	case !auth.HasRole(role, auth.RoleOperator):
+		WriteError(w, http.StatusForbidden, "FORBIDDEN", "This action requires the operator role")
+		return
+	}
+
+	host := db.Host{ProjectID: req.ProjectID, CreatedAt: time.Now()}
+	if errs := setHost(h.store.DB(), &host, req); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	collectHostFacts(r.Context(), &host)
+	if err := h.store.DB().Create(&host).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Hostname already in use in this project")
+		return
+	}
+
+	WriteCreated(w, hostResponse(h.store.DB(), host))
+}
+
+// UpdateHost replaces the settings and labels of an inventory host and
+// collects its facts again. Nodes already running on the host keep the
+// settings they were created with.
+func (h *HostHandler) UpdateHost(w http.ResponseWriter, r *http.Request) {
+	host, ok := loadHost(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	var req HostRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if req.ProjectID != 0 && req.ProjectID != host.ProjectID {
+		var errs validation.Errors
+		errs.Add("project_id", validation.CodeInvalid, "hosts cannot be moved to another project")
+		WriteValidationError(w, errs)
+		return
+	}
+
+	if errs := setHost(h.store.DB(), host, req); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	collectHostFacts(r.Context(), host)
+	if err := h.store.DB().Save(host).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Hostname already in use in this project")
+		return
+	}
+
+	WriteSuccess(w, hostResponse(h.store.DB(), *host))
+}
+
+// DeleteHost removes a host from the inventory. Hosts with nodes of a
+// cluster cannot be removed.
+func (h *HostHandler) DeleteHost(w http.ResponseWriter, r *http.Request) {
+	host, ok := loadHost(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	if clusterID := hostCluster(h.store.DB(), host.ID); clusterID != 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", fmt.Sprintf("Host is used by cluster %d", clusterID))
+		return
+	}
+
+	if err := h.store.DB().Delete(host).Error; err != nil {
+		WriteInternalError(w, "Failed to delete host")
+		return
+	}
+	WriteSuccess(w, map[string]string{"message": "Host deleted"})
+}
+
+// RefreshFacts collects the facts of an inventory host again
+func (h *HostHandler) RefreshFacts(w http.ResponseWriter, r *http.Request) {
+	host, ok := loadHost(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	collectHostFacts(r.Context(), host)
+	if err := h.store.DB().Save(host).Error; err != nil {
+		WriteInternalError(w, "Failed to save host facts")
+		return
+	}
+	WriteSuccess(w, hostResponse(h.store.DB(), *host))
+}
+
+// setHost applies a validated request to a host, resolving its stored SSH
+// keys in the project of the host
+func setHost(conn *gorm.DB, host *db.Host, req HostRequest) validation.Errors {
+	spec := req.hostSpec()
+	spec.SetDefaults()
+	keyID, errs := resolveHostSSHKeys(conn, host.ProjectID, "", spec)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	host.Hostname = spec.Hostname
+	host.Address = spec.Address
+	host.User = spec.User
+	host.Port = spec.Port
+	host.SSHKeyPath = spec.SSHKeyPath
+	host.SSHKeyID = keyID
+	host.SSHKeyPassphrase = spec.SSHKeyPassphrase
+	host.SSHAgent = spec.SSHAgent
+	host.UseSudo = spec.UseSudo
+	host.SudoPassword = spec.SudoPassword
+	host.Bastion = encodeBastion(spec.Bastion)
+	host.BMC = encodeBMC(spec.BMC)
+	host.Labels = req.Labels
+	host.UpdatedAt = time.Now()
+	return nil
+}
+
+// collectHostFacts connects to an inventory host and records its facts, or
+// why they could not be collected
+func collectHostFacts(ctx context.Context, host *db.Host) {
+	var facts provision.HostFacts
+	err := provision.RunStep(ctx, "collect facts", factsTimeout, func(ctx context.Context) error {
+		var err error
+		facts, err = provision.CollectFacts(ctx, inventoryHostSpec(*host))
+		return err
+	})
+	if err != nil {
+		host.Status, host.Error = db.HostUnreachable, err.Error()
+		return
+	}
+	setHostFacts(host, facts)
+}
+
+// setHostFacts records the facts of a reachable inventory host and whether
+// it passes the preflight checks
+func setHostFacts(host *db.Host, facts provision.HostFacts) {
+	now := time.Now()
+	host.Status, host.Error = db.HostReachable, ""
+	host.OS, host.OSVersion, host.Kernel, host.Arch = facts.OS, facts.OSVersion, facts.Kernel, facts.Arch
+	host.CPUs, host.MemoryMB, host.DiskGB, host.Disks = facts.CPUs, facts.MemoryMB, facts.DiskGB, facts.Disks
+	host.FactsCollectedAt = &now
+	host.PreflightErrors = facts.Preflight()
+	host.PreflightPassed = len(host.PreflightErrors) == 0
+}
+
+// loadHost resolves the inventory host from the request path
+func loadHost(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.Host, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid host ID")
+		return nil, false
+	}
+
+	var host db.Host
+	if err := conn.First(&host, id).Error; err != nil {
+		WriteNotFound(w, "Host not found")
+		return nil, false
+	}
+	return &host, true
+}
+
+// hostResponse returns an inventory host with the cluster running on it
+func hostResponse(conn *gorm.DB, host db.Host) HostResponse {
+	return HostResponse{Host: host, ClusterID: hostCluster(conn, host.ID)}
+}
+
+// inventoryHostSpec converts an inventory host into a host spec for SSH access
+func inventoryHostSpec(host db.Host) provision.HostSpec {
+	spec := provision.HostSpec{
+		Hostname:   host.Hostname,
+		Address:    host.Address,
+		User:       host.User,
+		SSHKeyPath: host.SSHKeyPath,
+		SSHKeyID:   host.SSHKeyID,
+		Port:       host.Port,
+		HostID:     host.ID,
+
+		SSHKeyPassphrase: host.SSHKeyPassphrase,
+		SSHAgent:         host.SSHAgent,
+		UseSudo:          host.UseSudo,
+		SudoPassword:     host.SudoPassword,
+	}
+	// Both were validated when the host was registered
+	spec.Bastion, _ = decodeBastion(host.Bastion)
+	spec.BMC, _ = decodeBMC(host.BMC)
+	return spec
+}
+
+// usedHosts selects the IDs of the inventory hosts nodes of existing
+// clusters run on
+func usedHosts(conn *gorm.DB) *gorm.DB {
+	return conn.Model(&db.Node{}).Select("host_id").
+		Where("host_id <> 0 AND cluster_id IN (?)", conn.Model(&db.Cluster{}).Select("id"))
+}
+
+// clusterInventoryHosts selects the IDs of the inventory hosts nodes of a
+// cluster run on
+func clusterInventoryHosts(conn *gorm.DB, clusterID uint) *gorm.DB {
+	return conn.Model(&db.Node{}).Select("host_id").Where("host_id <> 0 AND cluster_id = ?", clusterID)
+}
+
+// hostClusters maps the IDs of the inventory hosts in use to the cluster
+// running on them
+func hostClusters(conn *gorm.DB) (map[uint]uint, error) {
+	var nodes []db.Node
+	err := conn.Select("host_id", "cluster_id").
+		Where("host_id <> 0 AND cluster_id IN (?)", conn.Model(&db.Cluster{}).Select("id")).Find(&nodes).Error
+	clusters := make(map[uint]uint, len(nodes))
+	for _, node := range nodes {
+		clusters[node.HostID] = node.ClusterID
+	}
+	return clusters, err
+}
+
+// hostCluster returns the ID of the cluster running on an inventory host, 0
+// for free hosts
+func hostCluster(conn *gorm.DB, hostID uint) uint {
+	var node db.Node
+	err := conn.Select("cluster_id").
+		Where("host_id = ? AND cluster_id IN (?)", hostID, conn.Model(&db.Cluster{}).Select("id")).First(&node).Error
+	if err != nil {
+		return 0
+	}
+	return node.ClusterID
+}
+
+// resolveInventoryHosts fills in the connection settings of the hosts of a
+// request that reference inventory hosts by host_id and adds the hosts
+// picked by its selectors, in ID order. Hosts must belong to the project
+// and must not run nodes of another cluster than clusterID, 0 for a new
+// cluster; selectors pick free hosts and hosts of that cluster. The caller
+// holds inventoryMu until the nodes are recorded.
+func resolveInventoryHosts(conn *gorm.DB, projectID, clusterID uint, req *CreateClusterRequest) validation.Errors {
+	var errs validation.Errors
+	picked := map[uint]bool{}
+	groups := []struct {
+		field    string
+		hosts    []provision.HostSpec
+		selector *HostSelector
+		selField string
+	}{
+		{"control_planes", req.ControlPlanes, req.ControlPlaneHosts, "control_plane_hosts"},
+		{"workers", req.Workers, req.WorkerHosts, "worker_hosts"},
+	}
+
+	for _, group := range groups {
+		for i := range group.hosts {
+			spec := &group.hosts[i]
+			if spec.HostID == 0 {
+				continue
+			}
+			path := validation.Path(validation.Index(group.field, i), "host_id")
+			var host db.Host
+			switch {
+			case picked[spec.HostID]:
+				errs.Add(path, validation.CodeDuplicate, fmt.Sprintf("host %d is listed more than once", spec.HostID))
+			case spec.Machine != nil:
+				errs.Add(path, validation.CodeInvalid, "inventory hosts cannot have a machine")
+			case conn.Where("project_id = ?", projectID).First(&host, spec.HostID).Error != nil:
+				errs.Add(path, validation.CodeInvalid, "host not found in the project inventory")
+			default:
+				if used := hostCluster(conn, host.ID); used != 0 && used != clusterID {
+					errs.Add(path, validation.CodeInvalid, fmt.Sprintf("host %s is used by cluster %d", host.Hostname, used))
+				}
+				fillInventoryHost(spec, host)
+			}
+			picked[spec.HostID] = true
+		}
+	}
+
+	for i, group := range groups {
+		if group.selector == nil {
+			continue
+		}
+		if group.selector.Count < 1 {
+			errs.Add(validation.Path(group.selField, "count"), validation.CodeOutOfRange, "count must be at least 1")
+			continue
+		}
+		candidates, err := candidateHosts(conn, projectID, clusterID)
+		if err != nil {
+			errs.Add(group.selField, validation.CodeInvalid, "failed to read the host inventory")
+			continue
+		}
+		var selected []provision.HostSpec
+		for _, host := range candidates {
+			if len(selected) == group.selector.Count {
+				break
+			}
+			if picked[host.ID] || !hasLabels(host.Labels, group.selector.Labels) {
+				continue
+			}
+			var spec provision.HostSpec
+			fillInventoryHost(&spec, host)
+			selected = append(selected, spec)
+			picked[host.ID] = true
+		}
+		if len(selected) < group.selector.Count {
+			errs.Add(validation.Path(group.selField, "count"), validation.CodeOutOfRange,
+				fmt.Sprintf("%d hosts requested but only %d free reachable hosts match", group.selector.Count, len(selected)))
+			continue
+		}
+		groups[i].hosts = append(group.hosts, selected...)
+	}
+
+	// The request keeps the picked hosts, so that revisions and retried
+	// jobs use the same ones
+	req.ControlPlanes, req.Workers = groups[0].hosts, groups[1].hosts
+	req.ControlPlaneHosts, req.WorkerHosts = nil, nil
+	return errs
+}
+
+// candidateHosts returns the inventory hosts of a project selectors may
+// pick for clusterID: reachable hosts passing the preflight checks that are
+// free or run nodes of that cluster, in ID order
+func candidateHosts(conn *gorm.DB, projectID, clusterID uint) ([]db.Host, error) {
+	var hosts []db.Host
+	err := conn.Where("project_id = ? AND status = ? AND preflight_passed = ?", projectID, db.HostReachable, true).
+		Where("id NOT IN (?) OR id IN (?)", usedHosts(conn), clusterInventoryHosts(conn, clusterID)).
+		Order("id").Find(&hosts).Error
+	return hosts, err
+}
+
+// fillInventoryHost replaces the connection settings of a host spec with
+// those of an inventory host. Its role, labels and taints are kept.
+func fillInventoryHost(spec *provision.HostSpec, host db.Host) {
+	filled := inventoryHostSpec(host)
+	filled.Role, filled.Labels, filled.Taints = spec.Role, spec.Labels, spec.Taints
+	*spec = filled
+}
+
+// parseLabelFilter parses key=value label filters
+func parseLabelFilter(values []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label filter %q, expected key=value", value)
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+// hasLabels reports whether labels contains every label of want
+func hasLabels(labels, want map[string]string) bool {
+	for key, value := range want {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+)
+
+// JobHandler handles job-related API requests
+type JobHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
+
+// NewJobHandler creates a new job handler
+func NewJobHandler(store *db.Store, queue *jobs.Queue) *JobHandler {
+	return &JobHandler{store: store, queue: queue}
+}
+
+// RegisterRoutes registers job API routes
+func (h *JobHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/jobs", h.ListJobs).Methods("GET")
+	router.HandleFunc("/api/v1/jobs/{id}", h.GetJob).Methods("GET")
+	router.HandleFunc("/api/v1/jobs/{id}/cancel", h.CancelJob).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/jobs", h.ListClusterJobs).Methods("GET")
+}
+
+// ListJobs lists jobs, optionally filtered by status and type
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	h.listJobs(w, r, 0)
+}
+
+// ListClusterJobs lists the jobs of a cluster
+func (h *JobHandler) ListClusterJobs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	h.listJobs(w, r, uint(id))
+}
+
+// GetJob retrieves a single job by ID
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid job ID")
+		return
+	}
+
+	job, err := h.store.Jobs.Get(uint(id))
+	if err != nil {
+		WriteNotFound(w, "Job not found")
+		return
+	}
+
+	WriteSuccess(w, job)
+}
+
+// CancelJob cancels a pending or running job
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid job ID")
+		return
+	}
+
+	if err := h.queue.Cancel(uint(id)); err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrJobNotFound):
+			WriteNotFound(w, "Job not found")
+		case errors.Is(err, jobs.ErrJobFinished):
+			WriteError(w, http.StatusConflict, "CONFLICT", "Job has already finished")
+		default:
+			WriteInternalError(w, "Failed to cancel job")
+		}
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Job cancellation requested"})
+}
+
+// listJobs writes jobs matching the query filters, scoped to a cluster when clusterID is set
+func (h *JobHandler) listJobs(w http.ResponseWriter, r *http.Request, clusterID uint) {
+	filter := db.JobFilter{
+		ClusterID: clusterID,
+		Status:    r.URL.Query().Get("status"),
+		Type:      r.URL.Query().Get("type"),
+		Limit:     100,
+	}
+	if clusterID == 0 {
+		// Only jobs of clusters in the caller's projects
+		filter.ProjectIDs = projectFilter(h.store.DB(), r)
+	}
+	if parent := r.URL.Query().Get("parent_id"); parent != "" {
+		id, err := strconv.ParseUint(parent, 10, 32)
+		if err != nil {
+			WriteBadRequest(w, "Invalid parent job ID")
+			return
+		}
+		filter.ParentID = uint(id)
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= 1000 {
+			filter.Limit = n
+		}
+	}
+
+	result, err := h.store.Jobs.List(filter)
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve jobs")
+		return
+	}
+
+	WriteSuccess(w, result)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+)
+
+// nonContextChars matches what the context names of a kubeconfig bundle
+// cannot contain
+var nonContextChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// GetKubeconfigBundle merges the kubeconfigs of several clusters into one
+// file with a context per cluster: those of ?clusters= IDs, or with
+// ?all=true of every cluster the caller may download a kubeconfig of. ?role=
+// and ?ttl= apply to each cluster as for a single kubeconfig.
+func (h *ClusterHandler) GetKubeconfigBundle(w http.ResponseWriter, r *http.Request) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	var ids []uint
+	if value := r.URL.Query().Get("clusters"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+			if err != nil {
+				WriteBadRequest(w, fmt.Sprintf("Invalid cluster ID %q", part))
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+	if all == (len(ids) > 0) {
+		WriteBadRequest(w, "Either clusters or all=true is required")
+		return
+	}
+	credential, required, ok := kubeconfigOptions(w, r)
+	if !ok {
+		return
+	}
+
+	var clusters []db.Cluster
+	query := h.store.DB().Order("id")
+	if !all {
+		query = query.Where("id IN ?", ids)
+	} else if projects := projectFilter(h.store.DB(), r); projects != nil {
+		query = query.Where("project_id IN ?", projects)
+	}
+	if err := query.Find(&clusters).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve clusters")
+		return
+	}
+	found := make(map[uint]bool, len(clusters))
+	for _, cluster := range clusters {
+		found[cluster.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			WriteNotFound(w, fmt.Sprintf("Cluster %d not found", id))
+			return
+		}
+	}
+
+	// Listed clusters must all be available, all=true skips the others
+	claims := CurrentUser(r)
+	var selected []db.Cluster
+	for _, cluster := range clusters {
+		role := projectRole(h.store.DB(), claims, cluster.ProjectID)
+		allowed := auth.HasRole(role, required)
+		switch {
+		case all && (!allowed || cluster.Kubeconfig == nil):
+			continue
+		case role == "":
+			WriteNotFound(w, fmt.Sprintf("Cluster %d not found", cluster.ID))
+			return
+		case !allowed:
+			WriteError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("The kubeconfig of cluster %s requires the %s role", cluster.Name, required))
+			return
+		case cluster.Kubeconfig == nil:
+			WriteError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("Kubeconfig of cluster %s not available", cluster.Name))
+			return
+		}
+		selected = append(selected, cluster)
+	}
+	if len(selected) == 0 {
+		WriteNotFound(w, "No cluster kubeconfig available")
+		return
+	}
+
+	names := make(map[string]bool, len(selected))
+	entries := make([]provision.KubeconfigEntry, 0, len(selected))
+	for _, cluster := range selected {
+		kubeconfig := cluster.Kubeconfig
+		if credential != nil {
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			if _, kubeconfig, ok = h.issueCredential(wrapped, r, cluster.ID, *credential, currentKubeconfigPolicy()); !ok {
+				recordKubeconfigDownload(h.store.DB(), r, cluster.ID, wrapped.statusCode)
+				return
+			}
+		}
+		name := contextName(cluster)
+		if names[name] {
+			name = fmt.Sprintf("%s-%d", name, cluster.ID)
+		}
+		names[name] = true
+		entries = append(entries, provision.KubeconfigEntry{Name: name, Kubeconfig: kubeconfig})
+	}
+	bundle, err := provision.MergeKubeconfigs(entries)
+	if err != nil {
+		WriteInternalError(w, "Failed to merge kubeconfigs")
+		return
+	}
+	for _, cluster := range selected {
+		recordKubeconfigDownload(h.store.DB(), r, cluster.ID, http.StatusOK)
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Header().Set("Content-Disposition", "attachment; filename=kubeconfig.yaml")
+	w.Write(bundle)
+}
+
+// contextName names the context of a cluster in a kubeconfig bundle after
+// the cluster, lowercased with anything but letters, digits, dots and
+// dashes replaced by a dash
+func contextName(cluster db.Cluster) string {
+	name := strings.Trim(nonContextChars.ReplaceAllString(strings.ToLower(cluster.Name), "-"), "-.")
+	if name == "" {
+		return fmt.Sprintf("cluster-%d", cluster.ID)
+	}
+	return name
+}
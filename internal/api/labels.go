@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"kubeforge/internal/validation"
+)
+
+// maxAnnotationSize bounds the total size of the annotations of a cluster
+const maxAnnotationSize = 256 * 1024
+
+// validateLabels checks the keys and values of labels
+func validateLabels(field string, labels map[string]string) validation.Errors {
+	var errs validation.Errors
+	for _, key := range sortedKeys(labels) {
+		path := validation.Path(field, key)
+		if !validation.LabelKey(key) {
+			errs.Add(path, validation.CodeInvalid, fmt.Sprintf("invalid label key %q, expected a name of at most 63 letters, digits, '-', '_' or '.' with an optional DNS prefix", key))
+		} else if !validation.LabelValue(labels[key]) {
+			errs.Add(path, validation.CodeInvalid, "label values must be at most 63 letters, digits, '-', '_' or '.'")
+		}
+	}
+	return errs
+}
+
+// validateAnnotations checks the keys of annotations and their total size.
+// Values are free-form.
+func validateAnnotations(field string, annotations map[string]string) validation.Errors {
+	var errs validation.Errors
+	size := 0
+	for _, key := range sortedKeys(annotations) {
+		if !validation.LabelKey(key) {
+			errs.Add(validation.Path(field, key), validation.CodeInvalid, fmt.Sprintf("invalid annotation key %q, expected a name of at most 63 letters, digits, '-', '_' or '.' with an optional DNS prefix", key))
+		}
+		size += len(key) + len(annotations[key])
+	}
+	if size > maxAnnotationSize {
+		errs.Add(field, validation.CodeOutOfRange, "annotations must be at most 256 KiB in total")
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of a map in order, so errors are reported in
+// a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
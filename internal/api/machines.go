@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+)
+
+// machineDeleteTimeout bounds deleting a machine that failed to come up
+const machineDeleteTimeout = 5 * time.Minute
+
+// createMachines creates the machines of the hosts of a spec with a machine
+// spec and fills in the addresses of hosts using DHCP. Machines already
+// created according to the checkpoint are reused.
+func (h *ClusterHandler) createMachines(ctx context.Context, clusterID uint, spec *provision.ClusterSpec, checkpoint *provisionCheckpoint,
+	save func(phase string), timeouts provision.StepTimeouts, progress *provisionProgress) error {
+	var hosts []*provision.HostSpec
+	var remaining []provision.HostSpec
+	for _, group := range [][]provision.HostSpec{spec.ControlPlanes, spec.Workers} {
+		for i := range group {
+			if group[i].Machine != nil {
+				hosts = append(hosts, &group[i])
+				remaining = append(remaining, group[i])
+			}
+		}
+	}
+
+	// Addresses of machines created before a restart
+	fillAddresses := func() {
+		for _, host := range hosts {
+			if machine, ok := checkpoint.Machines[host.Hostname]; ok {
+				host.Address = machine.Address
+			}
+		}
+	}
+	if len(hosts) == 0 || checkpoint.reached(phaseMachines) {
+		fillAddresses()
+		progress.done += len(hosts) * weightMachine
+		return nil
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "machine", fmt.Sprintf("Creating %d machines on %s", len(hosts), spec.Infrastructure.Provider))
+	progress.report("machines")
+
+	var mu sync.Mutex
+	err := provision.ForEachHost(ctx, remaining, func(ctx context.Context, host provision.HostSpec) error {
+		mu.Lock()
+		machine, created := checkpoint.Machines[host.Hostname]
+		mu.Unlock()
+		if !created {
+			var err error
+			machine, err = h.createMachine(ctx, clusterID, *spec.Infrastructure, host, timeouts.Machine)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			if checkpoint.Machines == nil {
+				checkpoint.Machines = make(map[string]infra.Machine)
+			}
+			checkpoint.Machines[host.Hostname] = machine
+			save("")
+			mu.Unlock()
+		}
+
+		host.Address = machine.Address
+		if err := waitForMachine(ctx, host, timeouts.Machine); err != nil {
+			return err
+		}
+		mu.Lock()
+		progress.advance("machines", weightMachine)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		h.logError(clusterID, "Failed to create machines", err)
+		return err
+	}
+
+	fillAddresses()
+	save(phaseMachines)
+	return nil
+}
+
+// createMachine creates the machine of a host on the infrastructure of its
+// cluster and records its address and ID on the node of the host. A machine
+// that fails to come up is deleted again.
+func (h *ClusterHandler) createMachine(ctx context.Context, clusterID uint, spec infra.Spec, host provision.HostSpec, timeout provision.Duration) (infra.Machine, error) {
+	driver, err := infra.GetDriver(spec.Provider)
+	if err != nil {
+		return infra.Machine{}, err
+	}
+	key, err := provision.AuthorizedKey(host)
+	if err != nil {
+		return infra.Machine{}, err
+	}
+
+	h.logEvent(clusterID, "info", host.Hostname, "machine", "Creating machine on "+driver.Name())
+	var machine *infra.Machine
+	err = provision.RunStep(ctx, "create machine "+host.Hostname, timeout, func(ctx context.Context) error {
+		var err error
+		machine, err = driver.CreateMachine(ctx, infra.MachineRequest{
+			Name:         host.Hostname,
+			Spec:         spec,
+			Machine:      *host.Machine,
+			Address:      host.Address,
+			User:         host.User,
+			SSHPublicKey: key,
+		})
+		return err
+	})
+	if err != nil {
+		if machine != nil && machine.ID != "" {
+			deleteMachine(ctx, clusterID, driver.Name()+":"+machine.ID)
+		}
+		return infra.Machine{}, fmt.Errorf("failed to create machine %s: %w", host.Hostname, err)
+	}
+
+	machineID := driver.Name() + ":" + machine.ID
+	query := h.store.DB().Model(&db.Node{}).Where("cluster_id = ? AND machine_id = ?", clusterID, "")
+	if host.Address != "" {
+		query = query.Where("address = ?", host.Address)
+	} else {
+		query = query.Where("hostname = ?", host.Hostname)
+	}
+	if err := query.Updates(map[string]interface{}{"address": machine.Address, "machine_id": machineID}).Error; err != nil {
+		return infra.Machine{}, err
+	}
+	h.logEvent(clusterID, "info", machine.Address, "machine", fmt.Sprintf("Machine %s created as %s", host.Hostname, machineID))
+	return *machine, nil
+}
+
+// waitForMachine waits until a new machine accepts SSH connections and has
+// run cloud-init
+func waitForMachine(ctx context.Context, host provision.HostSpec, timeout provision.Duration) error {
+	return provision.RunStep(ctx, "wait for "+host.Address, timeout, func(ctx context.Context) error {
+		return provision.WaitForHost(ctx, host)
+	})
+}
+
+// deleteMachine deletes a machine by the machine ID of its node, logging
+// failures, which leave the machine for the administrator to remove
+func deleteMachine(ctx context.Context, clusterID uint, machineID string) {
+	provider, id, _ := strings.Cut(machineID, ":")
+	driver, err := infra.GetDriver(provider)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, machineDeleteTimeout)
+		defer cancel()
+		err = driver.DeleteMachine(ctx, id)
+	}
+	if err != nil {
+		slog.Error("Failed to delete machine", "cluster_id", clusterID, "machine_id", machineID, "error", err)
+		recordEvent(clusterID, "warn", "localhost", "machine", "Failed to delete machine "+machineID+": "+err.Error())
+	}
+}
+
+// jobDeleteMachines is the job type deleting the machines of a deleted cluster
+const jobDeleteMachines = "delete-machines"
+
+// deleteMachinesPayload is the input of delete-machines jobs
+type deleteMachinesPayload struct {
+	Machines []deleteMachinesEntry `json:"machines"`
+}
+
+// deleteMachinesEntry is a machine to delete and the host it ran
+type deleteMachinesEntry struct {
+	Hostname  string `json:"hostname"`
+	MachineID string `json:"machine_id"`
+}
+
+// enqueueDeleteMachines queues the deletion of the machines of the nodes of
+// a deleted cluster
+func (h *ClusterHandler) enqueueDeleteMachines(ctx context.Context, clusterID uint, nodes []db.Node) error {
+	var payload deleteMachinesPayload
+	for _, node := range nodes {
+		payload.Machines = append(payload.Machines, deleteMachinesEntry{Hostname: node.Hostname, MachineID: node.MachineID})
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   clusterID,
+		Type:        jobDeleteMachines,
+		Payload:     string(data),
+		RequestID:   logging.RequestID(ctx),
+		TraceParent: tracing.Inject(ctx),
+	}
+	return h.queue.Enqueue(&job)
+}
+
+// runDeleteMachinesJob deletes the machines of a deleted cluster one after
+// another, skipping those deleted before the job was interrupted
+func (h *ClusterHandler) runDeleteMachinesJob(ctx context.Context, job *db.Job) error {
+	var payload deleteMachinesPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid delete-machines job payload: %w", err)
+	}
+
+	var checkpoint struct {
+		Done int `json:"done"`
+	}
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		slog.Warn("Invalid machine deletion checkpoint, starting over", "job_id", job.ID, "error", err)
+		checkpoint.Done = 0
+	}
+	for i := checkpoint.Done; i < len(payload.Machines); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		machine := payload.Machines[i]
+		slog.Info("Deleting machine of deleted cluster", "cluster_id", job.ClusterID, "host", machine.Hostname, "machine_id", machine.MachineID)
+		deleteMachine(ctx, job.ClusterID, machine.MachineID)
+		checkpoint.Done = i + 1
+		if err := h.queue.SaveCheckpoint(job.ID, checkpoint); err != nil {
+			slog.Warn("Failed to save machine deletion checkpoint", "job_id", job.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// hostTarget names a host in a plan: its address, or its hostname while its
+// machine has not been created
+func hostTarget(host provision.HostSpec) string {
+	if host.Address == "" {
+		return host.Hostname
+	}
+	return host.Address
+}
+
+// nodeTarget names a node in a plan like hostTarget
+func nodeTarget(node db.Node) string {
+	if node.Address == "" {
+		return node.Hostname
+	}
+	return node.Address
+}
+
+// matchMachines gives hosts whose machine was created already the address of
+// their machine, so specs can keep listing them without one
+func matchMachines(nodes []db.Node, hosts []provision.HostSpec) {
+	for i := range hosts {
+		if hosts[i].Machine == nil || hosts[i].Address != "" {
+			continue
+		}
+		for _, node := range nodes {
+			if node.MachineID != "" && node.Hostname == hosts[i].Hostname {
+				hosts[i].Address = node.Address
+			}
+		}
+	}
+}
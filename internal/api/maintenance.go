@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// patchNodeTimeout bounds draining, updating, rebooting and uncordoning a
+// single node
+const patchNodeTimeout = provision.Duration(time.Hour)
+
+// MaintenanceRequest applies operating system updates to the nodes of a
+// cluster one at a time. Without node_ids every node with the role and
+// labels is patched, so a node pool is selected by its labels.
+type MaintenanceRequest struct {
+	NodeIDs []uint            `json:"node_ids,omitempty"`
+	Role    string            `json:"role,omitempty"`   // control-plane or worker
+	Labels  map[string]string `json:"labels,omitempty"` // node labels the nodes must have
+	provision.PatchOptions
+}
+
+// Validate checks the request and returns every invalid field
+func (req *MaintenanceRequest) Validate() validation.Errors {
+	var errs validation.Errors
+	if req.Role != "" && !validation.OneOf(req.Role, []string{"control-plane", "worker"}) {
+		errs.Add("role", validation.CodeUnsupported, fmt.Sprintf("unsupported role %q, expected control-plane or worker", req.Role))
+	}
+	return append(errs, req.PatchOptions.ValidateFields("")...)
+}
+
+// maintenancePayload is the input of a maintenance job
+type maintenancePayload struct {
+	NodeIDs []uint                 `json:"node_ids"`
+	Options provision.PatchOptions `json:"options"`
+}
+
+// maintenanceCheckpoint is the resume state of a maintenance job
+type maintenanceCheckpoint struct {
+	PatchedNodes []uint `json:"patched_nodes,omitempty"`
+}
+
+// PatchNodes starts a rolling maintenance job: each selected node is
+// drained, its operating system packages are updated, it is rebooted and
+// uncordoned once Ready, before the next node is taken out of service
+func (h *ClusterHandler) PatchNodes(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	clusterID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, uint(clusterID))
+	if !ok {
+		return
+	}
+	nodes, errs := maintenanceNodes(h.store.DB(), cluster.ID, &req)
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if len(nodes) == 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", "No nodes match the request")
+		return
+	}
+
+	payload := maintenancePayload{Options: req.PatchOptions}
+	for _, node := range nodes {
+		payload.NodeIDs = append(payload.NodeIDs, node.ID)
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "maintenance",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A maintenance of the cluster is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue maintenance job")
+		return
+	}
+
+	WriteAccepted(w, jobLocation(job.ID), job)
+}
+
+// maintenanceNodes returns the nodes selected by a maintenance request,
+// control planes first
+func maintenanceNodes(conn *gorm.DB, clusterID uint, req *MaintenanceRequest) ([]db.Node, validation.Errors) {
+	var errs validation.Errors
+	var nodes []db.Node
+	query := conn.Where("cluster_id = ? AND address <> ''", clusterID)
+	if len(req.NodeIDs) > 0 {
+		query = query.Where("id IN ?", req.NodeIDs)
+	}
+	if req.Role != "" {
+		query = query.Where("role = ?", req.Role)
+	}
+	query.Order("id").Find(&nodes)
+
+	for i, id := range req.NodeIDs {
+		found := false
+		for _, node := range nodes {
+			found = found || node.ID == id
+		}
+		if !found {
+			errs.Add(validation.Index("node_ids", i), validation.CodeInvalid, fmt.Sprintf("node %d not found in the cluster", id))
+		}
+	}
+
+	selected := nodes[:0]
+	for _, node := range nodes {
+		if hasLabels(node.Labels, req.Labels) {
+			selected = append(selected, node)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Role == "control-plane" && selected[j].Role != "control-plane"
+	})
+	return selected, errs
+}
+
+// runMaintenanceJob patches the nodes of a maintenance job one at a time,
+// skipping the nodes patched before the job was interrupted. A node that
+// fails stops the rollout and stays cordoned.
+func (h *ClusterHandler) runMaintenanceJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload maintenancePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.reportError(clusterID, "Invalid maintenance job payload", err)
+		return err
+	}
+	var checkpoint maintenanceCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(clusterID, "Invalid maintenance checkpoint, starting over", err)
+		checkpoint = maintenanceCheckpoint{}
+	}
+
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+	kubeconfig, err := clusterKubeconfig(h.store.DB(), clusterID)
+	if err != nil {
+		return err
+	}
+	skipHostKeyCheck := skipsHostKeyCheck(h.store.DB(), clusterID)
+	progress := &provisionProgress{job: job, total: len(payload.NodeIDs)}
+
+	h.logEvent(clusterID, "info", "localhost", "patch", fmt.Sprintf("Patching %d nodes", len(payload.NodeIDs)))
+	for _, id := range payload.NodeIDs {
+		if containsNodeID(checkpoint.PatchedNodes, id) {
+			progress.advance("patch", 1)
+			continue
+		}
+		var node db.Node
+		if err := h.store.DB().Where("cluster_id = ?", clusterID).First(&node, id).Error; err != nil {
+			h.logEvent(clusterID, "warn", "localhost", "patch", fmt.Sprintf("Node %d was removed, skipping it", id))
+			progress.advance("patch", 1)
+			continue
+		}
+
+		host := nodeHostSpec(node, skipHostKeyCheck)
+		h.store.DB().Model(&node).Updates(map[string]interface{}{"status": "maintenance", "cordoned": true})
+		h.logEvent(clusterID, "info", node.Address, "patch", "Patching node "+node.Hostname)
+		err = provision.RunStep(ctx, "patch "+host.Address, patchNodeTimeout, func(ctx context.Context) error {
+			return provisioner.PatchNode(ctx, kubeconfig, host, payload.Options)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.store.DB().Model(&node).Update("status", "unknown")
+			h.reportError(clusterID, "Failed to patch node "+node.Hostname+", stopping the maintenance", err)
+			return err
+		}
+
+		h.store.DB().Model(&node).Updates(map[string]interface{}{"status": "ready", "cordoned": false})
+		h.logEvent(clusterID, "info", node.Address, "patch", "Node "+node.Hostname+" patched")
+		checkpoint.PatchedNodes = append(checkpoint.PatchedNodes, id)
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save maintenance checkpoint", err)
+		}
+		progress.advance("patch", 1)
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "complete", fmt.Sprintf("Patched %d nodes", len(payload.NodeIDs)))
+	return nil
+}
+
+// containsNodeID reports whether ids contains id
+func containsNodeID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/lock"
+)
+
+// metricsLockName guards collection so only one replica samples each round
+const metricsLockName = "metrics:collect"
+
+// metricsClusterTimeout bounds sampling one cluster
+const metricsClusterTimeout = 30 * time.Second
+
+// maxMetricSamples bounds the samples returned by one request
+const maxMetricSamples = 10000
+
+// Sources of metric samples
+const (
+	metricsServerSource = "metrics-server"
+	kubeletSource       = "kubelet"
+)
+
+// MetricsCollector samples the CPU and memory usage of the nodes of ready
+// clusters. Usage is read from metrics-server when the addon is installed,
+// else from the summary API of each kubelet through the API server.
+type MetricsCollector struct {
+	store      *db.Store
+	instanceID string
+	interval   time.Duration
+	retention  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMetricsCollector creates a collector. An interval of zero disables it.
+func NewMetricsCollector(store *db.Store, interval, retention time.Duration, instanceID string) *MetricsCollector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MetricsCollector{
+		store:      store,
+		instanceID: instanceID,
+		interval:   interval,
+		retention:  retention,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start samples clusters in the background every interval
+func (c *MetricsCollector) Start() {
+	if c.interval <= 0 {
+		return
+	}
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop waits for a running round to finish
+func (c *MetricsCollector) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *MetricsCollector) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := lock.Acquire(c.store.DB(), metricsLockName, c.instanceID, c.interval)
+		if err != nil {
+			slog.Error("Failed to acquire metrics lock", "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		c.Collect(c.ctx)
+	}
+}
+
+// Collect samples every ready cluster once and removes samples past the
+// retention
+func (c *MetricsCollector) Collect(ctx context.Context) {
+	var clusters []db.Cluster
+	if err := c.store.DB().Select("id", "kubeconfig").Where("status = ? AND kubeconfig IS NOT NULL", db.ClusterReady).Find(&clusters).Error; err != nil {
+		slog.Error("Failed to list clusters for metrics", "error", err)
+		return
+	}
+	for _, cluster := range clusters {
+		if ctx.Err() != nil {
+			return
+		}
+		samples, err := sampleCluster(ctx, cluster)
+		if err != nil {
+			slog.Warn("Failed to collect cluster metrics", "cluster_id", cluster.ID, "error", err)
+			continue
+		}
+		if len(samples) > 0 {
+			if err := c.store.DB().Create(&samples).Error; err != nil {
+				slog.Error("Failed to save cluster metrics", "cluster_id", cluster.ID, "error", err)
+			}
+		}
+	}
+
+	if c.retention > 0 {
+		if err := c.store.DB().Where("collected_at < ?", time.Now().Add(-c.retention)).Delete(&db.MetricSample{}).Error; err != nil {
+			slog.Error("Failed to prune metrics", "error", err)
+		}
+	}
+}
+
+// sampleCluster reads the usage and allocatable resources of the nodes of a
+// cluster
+func sampleCluster(ctx context.Context, cluster db.Cluster) ([]db.MetricSample, error) {
+	api, err := clusterAPIFor(cluster.ID, cluster.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, metricsClusterTimeout)
+	defer cancel()
+
+	var nodes struct {
+		Items []struct {
+			Metadata objectMeta `json:"metadata"`
+			Status   struct {
+				Allocatable map[string]string `json:"allocatable"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := api.get(ctx, "/api/v1/nodes", &nodes); err != nil {
+		return nil, err
+	}
+
+	usage, source := metricsServerUsage(ctx, api), metricsServerSource
+	if usage == nil {
+		usage, source = map[string][2]int64{}, kubeletSource
+	}
+
+	now := time.Now()
+	samples := make([]db.MetricSample, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		name := node.Metadata.Name
+		nodeUsage, ok := usage[name]
+		if !ok && source == kubeletSource {
+			if nodeUsage, err = kubeletUsage(ctx, api, name); err != nil {
+				slog.Debug("Failed to read kubelet summary", "cluster_id", cluster.ID, "node", name, "error", err)
+				continue
+			}
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+		cpu, _ := parseQuantity(node.Status.Allocatable["cpu"])
+		memory, _ := parseQuantity(node.Status.Allocatable["memory"])
+		samples = append(samples, db.MetricSample{
+			ClusterID:      cluster.ID,
+			Node:           name,
+			Source:         source,
+			CPUUsage:       nodeUsage[0],
+			CPUCapacity:    int64(cpu * 1000),
+			MemoryUsage:    nodeUsage[1],
+			MemoryCapacity: int64(memory),
+			CollectedAt:    now,
+		})
+	}
+	return samples, nil
+}
+
+// metricsServerUsage returns the CPU millicores and memory bytes used by each
+// node according to metrics-server, or nil when it is not available
+func metricsServerUsage(ctx context.Context, api *clusterAPI) map[string][2]int64 {
+	var list struct {
+		Items []struct {
+			Metadata objectMeta        `json:"metadata"`
+			Usage    map[string]string `json:"usage"`
+		} `json:"items"`
+	}
+	if err := api.get(ctx, "/apis/metrics.k8s.io/v1beta1/nodes", &list); err != nil {
+		return nil
+	}
+	usage := make(map[string][2]int64, len(list.Items))
+	for _, item := range list.Items {
+		cpu, _ := parseQuantity(item.Usage["cpu"])
+		memory, _ := parseQuantity(item.Usage["memory"])
+		usage[item.Metadata.Name] = [2]int64{int64(cpu * 1000), int64(memory)}
+	}
+	return usage
+}
+
+// kubeletUsage returns the CPU millicores and memory bytes used by a node
+// according to the summary API of its kubelet
+func kubeletUsage(ctx context.Context, api *clusterAPI, node string) ([2]int64, error) {
+	var summary struct {
+		Node struct {
+			CPU struct {
+				UsageNanoCores int64 `json:"usageNanoCores"`
+			} `json:"cpu"`
+			Memory struct {
+				WorkingSetBytes int64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"node"`
+	}
+	if err := api.get(ctx, "/api/v1/nodes/"+url.PathEscape(node)+"/proxy/stats/summary", &summary); err != nil {
+		return [2]int64{}, err
+	}
+	return [2]int64{summary.Node.CPU.UsageNanoCores / 1e6, summary.Node.Memory.WorkingSetBytes}, nil
+}
+
+// quantitySuffixes are the multipliers of Kubernetes resource quantities
+var quantitySuffixes = map[string]float64{
+	"n": 1e-9, "u": 1e-6, "m": 1e-3,
+	"k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "P": 1e15, "E": 1e18,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40, "Pi": 1 << 50, "Ei": 1 << 60,
+}
+
+// parseQuantity converts a Kubernetes resource quantity such as 250m,
+// 16Gi or 1e3 to its value in base units
+func parseQuantity(quantity string) (float64, error) {
+	number, multiplier := quantity, 1.0
+	if len(quantity) > 2 {
+		if m, ok := quantitySuffixes[quantity[len(quantity)-2:]]; ok {
+			number, multiplier = quantity[:len(quantity)-2], m
+		}
+	}
+	if multiplier == 1 && len(quantity) > 1 {
+		if m, ok := quantitySuffixes[quantity[len(quantity)-1:]]; ok {
+			number, multiplier = quantity[:len(quantity)-1], m
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	return value * multiplier, nil
+}
+
+// ClusterResources is the allocatable capacity of a cluster and its usage
+// in the latest sample of its nodes
+type ClusterResources struct {
+	CPUUsage       int64             `json:"cpu_usage_millicores"`
+	CPUCapacity    int64             `json:"cpu_capacity_millicores"`
+	MemoryUsage    int64             `json:"memory_usage_bytes"`
+	MemoryCapacity int64             `json:"memory_capacity_bytes"`
+	CollectedAt    time.Time         `json:"collected_at"`
+	Nodes          []db.MetricSample `json:"nodes"`
+}
+
+// latestResources sums the latest samples of the nodes of a cluster, nil
+// when it has none
+func latestResources(conn *gorm.DB, clusterID uint) *ClusterResources {
+	var latest db.MetricSample
+	if err := conn.Where("cluster_id = ?", clusterID).Order("collected_at desc").First(&latest).Error; err != nil {
+		return nil
+	}
+	resources := &ClusterResources{CollectedAt: latest.CollectedAt}
+	conn.Where("cluster_id = ? AND collected_at = ?", clusterID, latest.CollectedAt).Order("node").Find(&resources.Nodes)
+	for _, sample := range resources.Nodes {
+		resources.CPUUsage += sample.CPUUsage
+		resources.CPUCapacity += sample.CPUCapacity
+		resources.MemoryUsage += sample.MemoryUsage
+		resources.MemoryCapacity += sample.MemoryCapacity
+	}
+	return resources
+}
+
+// GetMetrics returns the node usage samples of a cluster between ?from= and
+// ?to= (RFC 3339, the last hour by default), of one node with ?node=
+func (h *ClusterHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id").First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	to, from := time.Now(), time.Now().Add(-time.Hour)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			WriteBadRequest(w, "Invalid "+name+", expected RFC 3339")
+			return
+		}
+		*target = parsed
+	}
+	if !from.Before(to) {
+		WriteBadRequest(w, "from must be before to")
+		return
+	}
+
+	query := h.store.DB().Where("cluster_id = ? AND collected_at >= ? AND collected_at <= ?", cluster.ID, from, to)
+	if node := strings.TrimSpace(r.URL.Query().Get("node")); node != "" {
+		query = query.Where("node = ?", node)
+	}
+	var samples []db.MetricSample
+	if err := query.Order("collected_at, node").Limit(maxMetricSamples + 1).Find(&samples).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve metrics")
+		return
+	}
+	if len(samples) > maxMetricSamples {
+		WriteBadRequest(w, fmt.Sprintf("More than %d samples, narrow the time range", maxMetricSamples))
+		return
+	}
+	WriteSuccess(w, samples)
+}
@@ -1,18 +1,27 @@
 package api
 
 import (
-	"log"
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/tracing"
 )
 
 // CORS middleware adds CORS headers to responses
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, Idempotency-Key")
 		w.Header().Set("Access-Control-Max-Age", "3600")
+		w.Header().Set("Access-Control-Expose-Headers", "Deprecation, Link, Location, X-Request-ID")
 
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
@@ -24,6 +33,62 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
+// RequestIDHeader carries the correlation ID of a request
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID middleware tags each request with a correlation ID, taken from
+// the X-Request-ID header when the client sends a usable one. The ID is
+// returned in the response header, added to every log line written with the
+// request context and stored on jobs and events started by the request.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts short IDs of letters, digits, dashes, underscores and dots
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Trace middleware records a span for each request, continuing the trace of
+// a caller that sends a traceparent header. It must run after RequestID.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := tracing.ExtractHeader(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method+" "+route,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("request_id", logging.RequestID(ctx)),
+		)
+		defer span.End()
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", wrapped.statusCode))
+	})
+}
+
 // Logger middleware logs HTTP requests
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -34,17 +99,28 @@ func Logger(next http.Handler) http.Handler {
 
 		next.ServeHTTP(wrapped, r)
 
-		duration := time.Since(start)
-		log.Printf(
-			"%s %s %d %s",
-			r.Method,
-			r.RequestURI,
-			wrapped.statusCode,
-			duration,
+		slog.InfoContext(r.Context(), "HTTP request",
+			"method", r.Method,
+			"path", loggedURI(r),
+			"status", wrapped.statusCode,
+			"duration", time.Since(start),
 		)
 	})
 }
 
+// loggedURI returns the request URI for logs. The token query parameter
+// WebSocket and stream clients authenticate with is redacted.
+func loggedURI(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("token") {
+		return r.URL.RequestURI()
+	}
+	query.Set("token", "REDACTED")
+	redacted := *r.URL
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -56,12 +132,42 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush passes flushes of streaming responses through the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection through the wrapper
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades take over the connection through the wrapper
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// disableWriteTimeout lifts the server WriteTimeout for a long-lived
+// response, which would otherwise be cut off once it expires
+func disableWriteTimeout(w http.ResponseWriter, r *http.Request) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Failed to lift the write timeout", "path", r.URL.Path, "error", err)
+	}
+}
+
 // Recovery middleware recovers from panics and returns 500 error
 func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("PANIC: %v", err)
+				slog.ErrorContext(r.Context(), "Panic while serving request", "panic", err, "path", loggedURI(r))
 				WriteInternalError(w, "Internal server error")
 			}
 		}()
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// addNodesPayload is the input of an add-node job
+type addNodesPayload struct {
+	Workers        []provision.HostSpec `json:"workers"`
+	Infrastructure *infra.Spec          `json:"infrastructure,omitempty"` // provider creating the machines of new workers
+}
+
+// addNodesCheckpoint is the resume state of an add-node job
+type addNodesCheckpoint struct {
+	JoinCommand string                   `json:"join_command,omitempty"`
+	Joined      []string                 `json:"joined,omitempty"`   // targets of the workers that joined
+	Machines    map[string]infra.Machine `json:"machines,omitempty"` // machines of new workers by hostname
+}
+
+// AddNodesResult is the outcome of an add-node job per worker, recorded as
+// its metadata
+type AddNodesResult struct {
+	Joined []string          `json:"joined"`
+	Failed map[string]string `json:"failed"` // error by worker
+}
+
+// UpdateNodeRequest represents a partial update of a node. Only the fields
+// present in the request are changed.
+type UpdateNodeRequest struct {
+	Labels *map[string]string `json:"labels"` // replaces the labels of the node
+}
+
+// ListNodes lists the nodes of a cluster, filtered by ?label=key=value
+// (repeatable, all must match) and ?role=control-plane|worker
+func (h *ClusterHandler) ListNodes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	query := r.URL.Query()
+	labels, err := parseLabelFilter(query["label"])
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+	if _, err := h.store.Clusters.Get(uint(id)); err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	var nodes []db.Node
+	if role := query.Get("role"); role != "" {
+		nodes, err = h.store.Nodes.ListByRole(uint(id), role)
+	} else {
+		nodes, err = h.store.Nodes.List(uint(id))
+	}
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve nodes")
+		return
+	}
+	matching := make([]db.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if hasLabels(node.Labels, labels) {
+			matching = append(matching, node)
+		}
+	}
+	WriteSuccess(w, matching)
+}
+
+// UpdateNode changes the labels of a node. They are kept by KubeForge for
+// grouping and selecting nodes and are not applied to the Kubernetes node.
+func (h *ClusterHandler) UpdateNode(w http.ResponseWriter, r *http.Request) {
+	node, ok := clusterNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	var req UpdateNodeRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Labels != nil {
+		if errs := validateLabels("labels", *req.Labels); len(errs) > 0 {
+			WriteValidationError(w, errs)
+			return
+		}
+		node.Labels = *req.Labels
+		if err := h.store.Nodes.Update(node, "labels"); err != nil {
+			WriteInternalError(w, "Failed to update node")
+			return
+		}
+	}
+	WriteSuccess(w, node)
+}
+
+// AddNode adds one worker, or a list of workers, to a ready cluster. The
+// hosts are given like the workers of a cluster spec, inline or as
+// inventory host_id references. One add-node job joins them in parallel,
+// and a worker that fails does not stop the others; the job metadata
+// lists the workers that joined and the error of each that did not. The
+// cluster spec is recorded as a new revision with the workers added, so a
+// worker that failed is added again by the next apply.
+func (h *ClusterHandler) AddNode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var body json.RawMessage
+	if err := ParseJSON(r, &body); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	var hosts []provision.HostSpec
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		err = json.Unmarshal(body, &hosts)
+	} else {
+		var host provision.HostSpec
+		err = json.Unmarshal(body, &host)
+		hosts = []provision.HostSpec{host}
+	}
+	if err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if len(hosts) == 0 {
+		WriteBadRequest(w, "At least one host is required")
+		return
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+	spec, err := latestSpec(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster has no recorded spec")
+		return
+	}
+
+	// Errors of the new workers are reported by their index in the request
+	var errs validation.Errors
+	first := len(spec.Workers)
+	requestPath := func(field string) string {
+		for i := range hosts {
+			prefix := validation.Index("workers", first+i)
+			if field == prefix || strings.HasPrefix(field, prefix+".") || strings.HasPrefix(field, prefix+"[") {
+				return validation.Index("", i) + strings.TrimPrefix(field, prefix)
+			}
+		}
+		return field
+	}
+	for i := range hosts {
+		if hosts[i].Role != "" && hosts[i].Role != "worker" {
+			errs.Add(validation.Path(validation.Index("", i), "role"), validation.CodeUnsupported, "only workers can be added to a cluster")
+		}
+		hosts[i].Role = "worker"
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	spec.Workers = append(spec.Workers, hosts...)
+
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	errs = resolveInventoryHosts(h.store.DB(), cluster.ProjectID, cluster.ID, &spec)
+	if len(errs) == 0 {
+		errs = spec.Validate(h.store.DB())
+	}
+	hosts = spec.Workers[first:]
+	for i, host := range hosts {
+		keyID, keyErrs := resolveHostSSHKeys(h.store.DB(), cluster.ProjectID, validation.Index("workers", first+i), host)
+		hosts[i].SSHKeyID = keyID
+		errs = append(errs, keyErrs...)
+	}
+	if len(errs) > 0 {
+		for i := range errs {
+			errs[i].Field = requestPath(errs[i].Field)
+		}
+		WriteValidationError(w, errs)
+		return
+	}
+
+	payload := addNodesPayload{Workers: hosts}
+	var plan []PlanAction
+	for _, host := range hosts {
+		plan = append(plan, PlanAction{Action: actionAddWorker, Target: hostTarget(host)})
+		if host.Machine != nil {
+			payload.Infrastructure = spec.Infrastructure
+		}
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "add-node",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "Workers are already being added")
+			return
+		}
+		WriteInternalError(w, "Failed to queue add-node job")
+		return
+	}
+
+	recordRevision(h.store.DB(), cluster.ID, spec, revisionAddNodes, 0, job.ID, r)
+	WriteAccepted(w, jobLocation(job.ID), ApplySpecResponse{Plan: plan, Job: &job})
+}
+
+// runAddNodesJob joins the workers of an add-node job in parallel, skipping
+// the workers that joined before the job was resumed. It fails when any
+// worker fails, after the others are done.
+func (h *ClusterHandler) runAddNodesJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload addNodesPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.logError(clusterID, "Invalid add-node job payload", err)
+		return err
+	}
+
+	var checkpoint addNodesCheckpoint
+	if err := jobs.LoadCheckpoint(job, &checkpoint); err != nil {
+		h.reportError(clusterID, "Invalid add-node checkpoint, starting over", err)
+		checkpoint = addNodesCheckpoint{}
+	}
+	var mu sync.Mutex // guards checkpoint and result
+	save := func() {
+		if err := h.queue.SaveCheckpoint(job.ID, &checkpoint); err != nil {
+			h.reportError(clusterID, "Failed to save add-node checkpoint", err)
+		}
+	}
+
+	provisioner, err := clusterProvisioner(h.store.DB(), clusterID)
+	if err != nil {
+		h.logError(clusterID, "Failed to get provisioner", err)
+		return err
+	}
+	streamProvisioner(provisioner, clusterID)
+
+	controlPlane, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		h.logError(clusterID, "Failed to find control plane", err)
+		return err
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id", "k8s_version", "container_runtime", "insecure_skip_host_key_check", "hardening_profile").First(&cluster, clusterID).Error; err != nil {
+		h.logError(clusterID, "Failed to load cluster", err)
+		return err
+	}
+
+	h.setClusterStatus(clusterID, db.ClusterReconciling, "")
+	h.logEvent(clusterID, "info", "localhost", "add-node", fmt.Sprintf("Adding %d workers", len(payload.Workers)))
+
+	// Every worker joins with the same token
+	if checkpoint.JoinCommand == "" {
+		err := provision.RunStep(ctx, "join token", provision.DefaultStepTimeouts().Join, func(ctx context.Context) error {
+			var err error
+			checkpoint.JoinCommand, err = provisioner.GenerateJoinToken(ctx, controlPlane)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logEvent(clusterID, "error", controlPlane.Address, "join", "Failed to create join token: "+err.Error())
+				h.setClusterStatus(clusterID, db.ClusterReady, "")
+			}
+			return err
+		}
+		save()
+	}
+
+	result := AddNodesResult{Joined: []string{}, Failed: map[string]string{}}
+	progress := &provisionProgress{job: job, total: len(payload.Workers)}
+	provision.ForEachHost(ctx, payload.Workers, func(ctx context.Context, host provision.HostSpec) error {
+		target := hostTarget(host)
+		mu.Lock()
+		joined := containsString(checkpoint.Joined, target)
+		local := reconcileCheckpoint{JoinCommand: checkpoint.JoinCommand}
+		if machine, ok := checkpoint.Machines[host.Hostname]; ok {
+			local.Machines = map[string]infra.Machine{host.Hostname: machine}
+		}
+		mu.Unlock()
+
+		if !joined {
+			err := h.addWorker(ctx, provisioner, cluster, payload.Infrastructure, host, cluster.K8sVersion, controlPlane, &local, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				for name, machine := range local.Machines {
+					if checkpoint.Machines == nil {
+						checkpoint.Machines = make(map[string]infra.Machine)
+					}
+					checkpoint.Machines[name] = machine
+				}
+				save()
+			})
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				result.Failed[target] = err.Error()
+				progress.advance("add-node", 1)
+				return nil
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !joined {
+			checkpoint.Joined = append(checkpoint.Joined, target)
+			save()
+		}
+		result.Joined = append(result.Joined, target)
+		progress.advance("add-node", 1)
+		return nil
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	h.setClusterStatus(clusterID, db.ClusterReady, "")
+	if err := h.queue.SaveMetadata(job.ID, result); err != nil {
+		h.reportError(clusterID, "Failed to save add-node result", err)
+	}
+	if len(result.Failed) > 0 {
+		h.logEvent(clusterID, "warn", "localhost", "add-node",
+			fmt.Sprintf("%d of %d workers joined, %d failed", len(result.Joined), len(payload.Workers), len(result.Failed)))
+		return fmt.Errorf("%d of %d workers failed to join", len(result.Failed), len(payload.Workers))
+	}
+	h.logEvent(clusterID, "info", "localhost", "complete", fmt.Sprintf("Added %d workers", len(payload.Workers)))
+	return nil
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/notify"
+	"kubeforge/internal/validation"
+)
+
+// NotificationChannelRequest represents the request to create a notification channel
+type NotificationChannelRequest struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // slack, teams, email
+	notify.ChannelConfig
+	Template string `json:"template,omitempty"` // text/template for the message body
+}
+
+// NotificationChannelInfo describes a channel clusters can opt in to
+type NotificationChannelInfo struct {
+	ID       uint   `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Template string `json:"template,omitempty"`
+	Source   string `json:"source"` // env or api
+}
+
+// NotificationHandler handles notification channel API requests
+type NotificationHandler struct {
+	store *db.Store
+}
+
+// NewNotificationHandler creates a new notification handler
+func NewNotificationHandler(store *db.Store) *NotificationHandler {
+	return &NotificationHandler{store: store}
+}
+
+// RegisterRoutes registers notification API routes
+func (h *NotificationHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/notifications/channels", h.ListChannels).Methods("GET")
+	router.HandleFunc("/api/v1/notifications/channels", h.CreateChannel).Methods("POST")
+	router.HandleFunc("/api/v1/notifications/channels/{name}", h.DeleteChannel).Methods("DELETE")
+	router.HandleFunc("/api/v1/notifications/channels/{name}/test", h.TestChannel).Methods("POST")
+}
+
+// ListChannels lists the channels configured from the environment and through the API
+func (h *NotificationHandler) ListChannels(w http.ResponseWriter, r *http.Request) {
+	var stored []db.NotificationChannel
+	if err := h.store.DB().Order("name").Find(&stored).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve notification channels")
+		return
+	}
+
+	channels := make([]NotificationChannelInfo, 0, len(stored))
+	for _, channel := range notify.StaticChannels() {
+		channels = append(channels, NotificationChannelInfo{Name: channel.Name, Type: channel.Type, Source: "env"})
+	}
+	for _, channel := range stored {
+		channels = append(channels, NotificationChannelInfo{
+			ID:       channel.ID,
+			Name:     channel.Name,
+			Type:     channel.Type,
+			Template: channel.Template,
+			Source:   "api",
+		})
+	}
+
+	WriteSuccess(w, channels)
+}
+
+// CreateChannel stores a new channel. Its destination is encrypted at rest.
+func (h *NotificationHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	var req NotificationChannelRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	var errs validation.Errors
+	if req.Name == "" {
+		errs.Add("name", validation.CodeRequired, "channel name is required")
+	} else if notify.IsStatic(req.Name) {
+		errs.Add("name", validation.CodeDuplicate, "a channel configured from the environment already uses this name")
+	}
+	if !validation.OneOf(req.Type, notify.Types) {
+		errs.Add("type", validation.CodeUnsupported, "type must be one of slack, teams, email")
+	} else if err := req.ChannelConfig.Validate(req.Type); err != nil {
+		errs.Add("config", validation.CodeInvalid, err.Error())
+	}
+	if err := notify.ValidateTemplate(req.Template); err != nil {
+		errs.Add("template", validation.CodeInvalid, err.Error())
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	config, err := notify.SealConfig(req.ChannelConfig)
+	if err != nil {
+		WriteBadRequest(w, err.Error())
+		return
+	}
+
+	channel := db.NotificationChannel{
+		Name:      req.Name,
+		Type:      req.Type,
+		Config:    config,
+		Template:  req.Template,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := h.store.DB().Create(&channel).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Channel name already in use")
+		return
+	}
+
+	WriteCreated(w, channel)
+}
+
+// DeleteChannel deletes a channel created through the API
+func (h *NotificationHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if notify.IsStatic(name) {
+		WriteBadRequest(w, "Channels configured from the environment cannot be deleted")
+		return
+	}
+
+	result := h.store.DB().Where("name = ?", name).Delete(&db.NotificationChannel{})
+	if result.Error != nil {
+		WriteInternalError(w, "Failed to delete notification channel")
+		return
+	}
+	if result.RowsAffected == 0 {
+		WriteNotFound(w, "Notification channel not found")
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Notification channel deleted"})
+}
+
+// TestChannel sends a sample notification through a channel
+func (h *NotificationHandler) TestChannel(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !notify.Exists(h.store.DB(), name) {
+		WriteNotFound(w, "Notification channel not found")
+		return
+	}
+
+	event := notify.Event{
+		Cluster:   "example",
+		Operation: "provisioning",
+		Status:    "succeeded",
+		Duration:  42 * time.Minute,
+		Time:      time.Now(),
+	}
+	if err := notify.SendTo(r.Context(), h.store.DB(), name, event); err != nil {
+		WriteError(w, http.StatusBadGateway, "NOTIFICATION_FAILED", err.Error())
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Test notification sent"})
+}
+
+// validateNotifications checks that every channel a cluster opts in to exists
+func validateNotifications(conn *gorm.DB, field string, channels []string) validation.Errors {
+	var errs validation.Errors
+	for i, name := range channels {
+		if !notify.Exists(conn, name) {
+			errs.Add(validation.Index(field, i), validation.CodeInvalid, "unknown notification channel "+name)
+		}
+	}
+	return errs
+}
+
+// notifyJob tells the cluster's channels how the operation of a job ended
+func notifyJob(conn *gorm.DB, job *db.Job, operation string, err error) {
+	var cluster db.Cluster
+	if conn.Select("id", "name", "notifications").First(&cluster, job.ClusterID).Error != nil || len(cluster.Notifications) == 0 {
+		return
+	}
+
+	event := notify.Event{
+		ClusterID: cluster.ID,
+		Cluster:   cluster.Name,
+		Operation: operation,
+		Status:    "succeeded",
+		Time:      time.Now(),
+	}
+	if job.StartedAt != nil {
+		event.Duration = time.Since(*job.StartedAt).Round(time.Second)
+	}
+	if err != nil {
+		event.Status = "failed"
+		event.Error = err.Error()
+	}
+	notify.Dispatch(conn, event, cluster.Notifications)
+}
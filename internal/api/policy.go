@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// clusterPolicyID is the ID of the only stored cluster policy
+const clusterPolicyID = 1
+
+// ClusterPolicy constrains what users may create. Defaults fill the fields
+// a cluster request leaves empty, after its template; the other fields
+// restrict new clusters and changes to existing ones. Empty lists allow
+// anything.
+type ClusterPolicy struct {
+	Defaults           TemplateSpec `json:"defaults"`
+	AllowedK8sVersions []string     `json:"allowed_k8s_versions,omitempty"` // minor releases such as 1.33, or exact versions
+	AllowedCNIs        []string     `json:"allowed_cnis,omitempty"`
+	MandatoryAddons    []string     `json:"mandatory_addons,omitempty"`
+	RequiredLabels     []string     `json:"required_labels,omitempty"`     // label keys every cluster must have
+	ProductionProjects []string     `json:"production_projects,omitempty"` // names of projects whose clusters need more than one control plane
+}
+
+// ClusterPolicyResponse is the cluster policy with the time it was last
+// changed. Addon credentials of the defaults are not returned.
+type ClusterPolicyResponse struct {
+	ClusterPolicy
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Validate checks the policy and its defaults
+func (p *ClusterPolicy) Validate(conn *gorm.DB) validation.Errors {
+	var errs validation.Errors
+	for i, version := range p.AllowedK8sVersions {
+		if !validVersionPattern(version) {
+			errs.Add(validation.Index("allowed_k8s_versions", i), validation.CodeInvalid,
+				fmt.Sprintf("invalid k8s version %q, expected major.minor or major.minor.patch", version))
+		}
+	}
+	for i, cni := range p.AllowedCNIs {
+		if !validation.OneOf(cni, provision.SupportedCNIs) {
+			errs.Add(validation.Index("allowed_cnis", i), validation.CodeUnsupported,
+				fmt.Sprintf("unsupported CNI %q, expected one of %s", cni, strings.Join(provision.SupportedCNIs, ", ")))
+		}
+	}
+	for i, name := range p.MandatoryAddons {
+		if _, err := addons.GetAddon(name); err != nil {
+			errs.Add(validation.Index("mandatory_addons", i), validation.CodeUnsupported, err.Error())
+		}
+	}
+	for i, key := range p.RequiredLabels {
+		if key == "" {
+			errs.Add(validation.Index("required_labels", i), validation.CodeRequired, "label key must not be empty")
+		}
+	}
+	for i, name := range p.ProductionProjects {
+		if name == "" {
+			errs.Add(validation.Index("production_projects", i), validation.CodeRequired, "project name must not be empty")
+		}
+	}
+
+	for _, err := range p.Defaults.Validate(conn) {
+		err.Field = "defaults" + strings.TrimPrefix(err.Field, "spec")
+		errs = append(errs, err)
+	}
+	if p.Defaults.K8sVersion != "" && !p.allowsVersion(p.Defaults.K8sVersion) {
+		errs.Add("defaults.k8s_version", validation.CodePolicy, "default k8s version is not an allowed version")
+	}
+	if p.Defaults.CNI != "" && len(p.AllowedCNIs) > 0 && !validation.OneOf(p.Defaults.CNI, p.AllowedCNIs) {
+		errs.Add("defaults.cni", validation.CodePolicy, "default CNI is not an allowed CNI")
+	}
+	return errs
+}
+
+// validVersionPattern reports whether an allowed version is a minor
+// release or an exact version
+func validVersionPattern(version string) bool {
+	if _, err := provision.ParseVersion(version); err == nil {
+		return true
+	}
+	_, err := provision.ParseVersion(version + ".0")
+	return err == nil && strings.Count(strings.TrimPrefix(version, "v"), ".") == 1
+}
+
+// allowsVersion reports whether clusters may run a version
+func (p *ClusterPolicy) allowsVersion(version string) bool {
+	if len(p.AllowedK8sVersions) == 0 {
+		return true
+	}
+	version = strings.TrimPrefix(version, "v")
+	for _, allowed := range p.AllowedK8sVersions {
+		allowed = strings.TrimPrefix(allowed, "v")
+		if version == allowed || strings.HasPrefix(version, allowed+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// mandatory reports whether an addon must be installed
+func (p *ClusterPolicy) mandatory(addon string) bool {
+	return validation.OneOf(addon, p.MandatoryAddons)
+}
+
+// checkAddons returns an error for each mandatory addon a full list of
+// addons leaves out
+func (p *ClusterPolicy) checkAddons(specs []provision.AddonSpec) validation.Errors {
+	var errs validation.Errors
+	installed := make(map[string]bool)
+	for _, addon := range specs {
+		installed[addon.Name] = true
+	}
+	for _, name := range p.MandatoryAddons {
+		if !installed[name] {
+			errs.Add("addons", validation.CodePolicy, fmt.Sprintf("addon %s is mandatory", name))
+		}
+	}
+	return errs
+}
+
+// checkLabels returns an error for each required label missing from labels
+func (p *ClusterPolicy) checkLabels(labels map[string]string) validation.Errors {
+	var errs validation.Errors
+	for _, key := range p.RequiredLabels {
+		if labels[key] == "" {
+			errs.Add(validation.Path("labels", key), validation.CodePolicy, fmt.Sprintf("label %s is required", key))
+		}
+	}
+	return errs
+}
+
+// Check returns every way a request of a new cluster in a project violates
+// the policy. Fields left empty are checked with their built-in defaults.
+func (p *ClusterPolicy) Check(req *CreateClusterRequest, project string) validation.Errors {
+	var errs validation.Errors
+	spec := req.Spec()
+	spec.SetDefaults()
+	if !p.allowsVersion(spec.K8sVersion) {
+		errs.Add("k8s_version", validation.CodePolicy,
+			fmt.Sprintf("k8s version %s is not allowed, expected one of %s", spec.K8sVersion, strings.Join(p.AllowedK8sVersions, ", ")))
+	}
+	if len(p.AllowedCNIs) > 0 && !validation.OneOf(spec.CNI, p.AllowedCNIs) {
+		errs.Add("cni", validation.CodePolicy,
+			fmt.Sprintf("CNI %s is not allowed, expected one of %s", spec.CNI, strings.Join(p.AllowedCNIs, ", ")))
+	}
+	errs = append(errs, p.checkAddons(req.Addons)...)
+	errs = append(errs, p.checkLabels(req.Labels)...)
+	if validation.OneOf(project, p.ProductionProjects) && len(req.ControlPlanes) < 2 {
+		errs.Add("control_planes", validation.CodePolicy,
+			fmt.Sprintf("clusters of the production project %s need more than one control plane", project))
+	}
+	return errs
+}
+
+// loadClusterPolicy returns the stored cluster policy, an empty one when
+// none is stored
+func loadClusterPolicy(conn *gorm.DB) (*ClusterPolicy, *time.Time, error) {
+	var record db.ClusterPolicy
+	err := conn.First(&record, clusterPolicyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &ClusterPolicy{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var policy ClusterPolicy
+	if err := json.Unmarshal([]byte(record.Spec), &policy); err != nil {
+		return nil, nil, err
+	}
+	return &policy, &record.UpdatedAt, nil
+}
+
+// PolicyHandler handles cluster policy API requests
+type PolicyHandler struct {
+	store *db.Store
+}
+
+// NewPolicyHandler creates a new policy handler
+func NewPolicyHandler(store *db.Store) *PolicyHandler {
+	return &PolicyHandler{store: store}
+}
+
+// RegisterRoutes registers policy API routes
+func (h *PolicyHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/policy", h.GetPolicy).Methods("GET")
+	router.HandleFunc("/api/v1/policy", h.UpdatePolicy).Methods("PUT")
+}
+
+// GetPolicy returns the cluster policy, so users can see what they may
+// create
+func (h *PolicyHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, updated, err := loadClusterPolicy(h.store.DB())
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve policy")
+		return
+	}
+	policy.Defaults = redactTemplateSpec(policy.Defaults)
+	WriteSuccess(w, ClusterPolicyResponse{ClusterPolicy: *policy, UpdatedAt: updated})
+}
+
+// UpdatePolicy replaces the cluster policy. Existing clusters are not
+// checked against it until they are changed.
+func (h *PolicyHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	var policy ClusterPolicy
+	if err := ParseJSON(r, &policy); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := policy.Validate(h.store.DB()); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	spec, _ := json.Marshal(policy)
+	record := db.ClusterPolicy{ID: clusterPolicyID, Spec: string(spec), UpdatedAt: time.Now()}
+	if err := h.store.DB().Save(&record).Error; err != nil {
+		WriteInternalError(w, "Failed to save policy")
+		return
+	}
+
+	policy.Defaults = redactTemplateSpec(policy.Defaults)
+	WriteSuccess(w, ClusterPolicyResponse{ClusterPolicy: policy, UpdatedAt: &record.UpdatedAt})
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// poolLabel is the node label naming the worker pool of a worker
+const poolLabel = "kubeforge.io/pool"
+
+var poolNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// PoolRequest creates or replaces a worker pool
+type PoolRequest struct {
+	Name       string            `json:"name"`
+	MinSize    int               `json:"min_size"`
+	MaxSize    int               `json:"max_size"`
+	HostLabels map[string]string `json:"host_labels,omitempty"` // inventory hosts must have every label
+	Labels     map[string]string `json:"labels,omitempty"`
+	Taints     []string          `json:"taints,omitempty"`
+}
+
+// Validate checks the request and returns every invalid field
+func (req *PoolRequest) Validate() validation.Errors {
+	var errs validation.Errors
+	if !poolNamePattern.MatchString(req.Name) || len(req.Name) > 63 {
+		errs.Add("name", validation.CodeInvalid, "name must be a lowercase DNS label")
+	}
+	if req.MinSize < 0 {
+		errs.Add("min_size", validation.CodeOutOfRange, "min_size must not be negative")
+	}
+	if req.MaxSize < req.MinSize || req.MaxSize < 1 {
+		errs.Add("max_size", validation.CodeOutOfRange, "max_size must be at least 1 and at least min_size")
+	}
+	if _, ok := req.Labels[poolLabel]; ok {
+		errs.Add(validation.Path("labels", poolLabel), validation.CodeInvalid, "the pool label is set by KubeForge")
+	}
+	return errs
+}
+
+// PoolResponse is a worker pool and its current number of workers
+type PoolResponse struct {
+	db.WorkerPool
+	Size int `json:"size"`
+}
+
+// ScaleRequest sets the size of a worker pool or changes it by a delta.
+// Sizes out of the pool bounds are rejected, while deltas are clamped to
+// them, so an autoscaler can ask for more or fewer workers at the bounds.
+type ScaleRequest struct {
+	Size  *int `json:"size,omitempty"`
+	Delta int  `json:"delta,omitempty"`
+}
+
+// ListPools lists the worker pools of a cluster
+func (h *ClusterHandler) ListPools(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var pools []db.WorkerPool
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("name").Find(&pools).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve worker pools")
+		return
+	}
+	spec, _ := latestSpec(h.store.DB(), uint(id))
+
+	responses := make([]PoolResponse, 0, len(pools))
+	for _, pool := range pools {
+		responses = append(responses, PoolResponse{WorkerPool: pool, Size: len(poolWorkers(spec, pool.Name))})
+	}
+	WriteSuccess(w, responses)
+}
+
+// GetPool returns a worker pool
+func (h *ClusterHandler) GetPool(w http.ResponseWriter, r *http.Request) {
+	pool, ok := loadPool(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	spec, _ := latestSpec(h.store.DB(), pool.ClusterID)
+	WriteSuccess(w, PoolResponse{WorkerPool: *pool, Size: len(poolWorkers(spec, pool.Name))})
+}
+
+// CreatePool declares a worker pool. It has no workers until it is scaled.
+func (h *ClusterHandler) CreatePool(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var req PoolRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	pool := db.WorkerPool{
+		ClusterID:  cluster.ID,
+		Name:       req.Name,
+		MinSize:    req.MinSize,
+		MaxSize:    req.MaxSize,
+		HostLabels: req.HostLabels,
+		Labels:     req.Labels,
+		Taints:     req.Taints,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if err := h.store.DB().Create(&pool).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Worker pool "+req.Name+" already exists")
+		return
+	}
+	WriteCreated(w, PoolResponse{WorkerPool: pool})
+}
+
+// UpdatePool replaces the bounds, host labels, node labels and taints of a
+// worker pool. Labels and taints apply to workers added afterwards.
+func (h *ClusterHandler) UpdatePool(w http.ResponseWriter, r *http.Request) {
+	pool, ok := loadPool(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	var req PoolRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	req.Name = pool.Name
+	if errs := req.Validate(); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	pool.MinSize, pool.MaxSize = req.MinSize, req.MaxSize
+	pool.HostLabels, pool.Labels, pool.Taints = req.HostLabels, req.Labels, req.Taints
+	pool.UpdatedAt = time.Now()
+	if err := h.store.DB().Save(pool).Error; err != nil {
+		WriteInternalError(w, "Failed to save worker pool")
+		return
+	}
+	spec, _ := latestSpec(h.store.DB(), pool.ClusterID)
+	WriteSuccess(w, PoolResponse{WorkerPool: *pool, Size: len(poolWorkers(spec, pool.Name))})
+}
+
+// DeletePool removes a worker pool. Its workers stay in the cluster.
+func (h *ClusterHandler) DeletePool(w http.ResponseWriter, r *http.Request) {
+	pool, ok := loadPool(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DB().Delete(pool).Error; err != nil {
+		WriteInternalError(w, "Failed to delete worker pool")
+		return
+	}
+	WriteSuccess(w, map[string]string{"message": "Worker pool deleted"})
+}
+
+// ScalePool resizes a worker pool. New workers are free inventory hosts of
+// the cluster's project with the host labels of the pool; the workers added
+// last are removed first. The spec of the cluster is changed and applied
+// like PUT /spec, recording a revision.
+func (h *ClusterHandler) ScalePool(w http.ResponseWriter, r *http.Request) {
+	var req ScaleRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	pool, ok := loadPool(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().First(&cluster, pool.ClusterID).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+	spec, err := latestSpec(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster has no recorded spec")
+		return
+	}
+
+	members := poolWorkers(spec, pool.Name)
+	size := len(members) + req.Delta
+	if req.Size != nil {
+		var errs validation.Errors
+		if req.Delta != 0 {
+			errs.Add("delta", validation.CodeInvalid, "size and delta are mutually exclusive")
+		}
+		if size = *req.Size; size < pool.MinSize || size > pool.MaxSize {
+			errs.Add("size", validation.CodeOutOfRange, fmt.Sprintf("size must be between %d and %d", pool.MinSize, pool.MaxSize))
+		}
+		if len(errs) > 0 {
+			WriteValidationError(w, errs)
+			return
+		}
+	}
+	size = max(pool.MinSize, min(pool.MaxSize, size))
+
+	switch {
+	case size > len(members):
+		hosts, err := poolHosts(h.store.DB(), cluster, spec, pool, size-len(members))
+		if err != nil {
+			WriteError(w, http.StatusConflict, "CONFLICT", err.Error())
+			return
+		}
+		spec.Workers = append(spec.Workers, hosts...)
+	case size < len(members):
+		removed := map[int]bool{}
+		for _, i := range members[size:] {
+			removed[i] = true
+		}
+		workers := spec.Workers[:0]
+		for i, host := range spec.Workers {
+			if !removed[i] {
+				workers = append(workers, host)
+			}
+		}
+		spec.Workers = workers
+	}
+
+	h.applySpec(w, r, cluster.ID, spec, revisionScale, 0)
+}
+
+// poolWorkers returns the indexes of the workers of a pool in a spec, in
+// the order they were added
+func poolWorkers(spec CreateClusterRequest, name string) []int {
+	var members []int
+	for i, host := range spec.Workers {
+		if host.Labels[poolLabel] == name {
+			members = append(members, i)
+		}
+	}
+	return members
+}
+
+// poolHosts picks count inventory hosts for new workers of a pool, skipping
+// the hosts already in the spec
+func poolHosts(conn *gorm.DB, cluster db.Cluster, spec CreateClusterRequest, pool *db.WorkerPool, count int) ([]provision.HostSpec, error) {
+	candidates, err := candidateHosts(conn, cluster.ProjectID, cluster.ID)
+	if err != nil {
+		return nil, errors.New("failed to read the host inventory")
+	}
+	inSpec := map[uint]bool{}
+	for _, hosts := range [][]provision.HostSpec{spec.ControlPlanes, spec.Workers} {
+		for _, host := range hosts {
+			inSpec[host.HostID] = true
+		}
+	}
+
+	var hosts []provision.HostSpec
+	for _, host := range candidates {
+		if len(hosts) == count {
+			break
+		}
+		if inSpec[host.ID] || !hasLabels(host.Labels, pool.HostLabels) {
+			continue
+		}
+		labels := map[string]string{poolLabel: pool.Name}
+		for key, value := range pool.Labels {
+			labels[key] = value
+		}
+		hosts = append(hosts, provision.HostSpec{HostID: host.ID, Role: "worker", Labels: labels, Taints: pool.Taints})
+	}
+	if len(hosts) < count {
+		return nil, fmt.Errorf("%d hosts needed but only %d free reachable hosts match the pool", count, len(hosts))
+	}
+	return hosts, nil
+}
+
+// loadPool resolves the worker pool of a route with a cluster ID and a pool
+// name, writing an error response if there is none
+func loadPool(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.WorkerPool, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return nil, false
+	}
+	var pool db.WorkerPool
+	err = conn.Where("cluster_id = ? AND name = ?", id, vars["name"]).First(&pool).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteNotFound(w, "Worker pool not found")
+		return nil, false
+	}
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve worker pool")
+		return nil, false
+	}
+	return &pool, true
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/bmc"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// bmcTimeout bounds a request to the BMC of a node
+const bmcTimeout = provision.Duration(2 * time.Minute)
+
+// PowerRequest runs a power action on a node, optionally booting it from
+// the network to reimage it
+type PowerRequest struct {
+	Action string `json:"action"`         // on, off, cycle or reset
+	Boot   string `json:"boot,omitempty"` // pxe boots the node from the network once
+}
+
+// PowerStatus is the power state of a node
+type PowerStatus struct {
+	NodeID uint   `json:"node_id"`
+	State  string `json:"state"` // on, off or unknown
+}
+
+// GetNodePower returns the power state of a node as reported by its BMC
+func (h *ClusterHandler) GetNodePower(w http.ResponseWriter, r *http.Request) {
+	node, controller, ok := nodeController(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	var state string
+	err := provision.RunStep(r.Context(), "bmc power state", bmcTimeout, func(ctx context.Context) error {
+		var err error
+		state, err = controller.PowerState(ctx)
+		return err
+	})
+	if err != nil {
+		WriteError(w, http.StatusBadGateway, "BMC_ERROR", err.Error())
+		return
+	}
+	WriteSuccess(w, PowerStatus{NodeID: node.ID, State: state})
+}
+
+// SetNodePower powers a node on or off, power cycles or resets it through
+// its BMC. With boot set to pxe the node boots from the network, to be
+// reimaged by the PXE infrastructure.
+func (h *ClusterHandler) SetNodePower(w http.ResponseWriter, r *http.Request) {
+	var req PowerRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	var errs validation.Errors
+	if !validation.OneOf(req.Action, bmc.Actions) {
+		errs.Add("action", validation.CodeUnsupported, fmt.Sprintf("unsupported power action %q, expected one of %s", req.Action, strings.Join(bmc.Actions, ", ")))
+	} else if req.Boot != "" && req.Action == bmc.ActionOff {
+		errs.Add("boot", validation.CodeInvalid, "boot requires an action that boots the node")
+	}
+	if req.Boot != "" && req.Boot != "pxe" {
+		errs.Add("boot", validation.CodeUnsupported, fmt.Sprintf("unsupported boot device %q, expected pxe", req.Boot))
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	node, controller, ok := nodeController(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	err := provision.RunStep(r.Context(), "bmc power "+req.Action, bmcTimeout, func(ctx context.Context) error {
+		if req.Boot != "" {
+			if err := controller.BootPXE(ctx); err != nil {
+				return fmt.Errorf("failed to set PXE boot: %w", err)
+			}
+		}
+		return controller.Power(ctx, req.Action)
+	})
+	if err != nil {
+		h.reportError(node.ClusterID, fmt.Sprintf("Failed to power %s node %s", req.Action, node.Hostname), err)
+		status := http.StatusBadGateway
+		if errors.Is(err, bmc.ErrUnsupported) {
+			status = http.StatusConflict
+		}
+		WriteError(w, status, "BMC_ERROR", err.Error())
+		return
+	}
+
+	message := fmt.Sprintf("Power %s of node %s through its BMC", req.Action, node.Hostname)
+	if req.Boot != "" {
+		message += ", booting from the network"
+	}
+	h.logEvent(node.ClusterID, "info", node.Address, "power", message)
+	WriteSuccess(w, map[string]string{"message": message})
+}
+
+// SetNodeBMC sets the BMC a node is power managed through
+func (h *ClusterHandler) SetNodeBMC(w http.ResponseWriter, r *http.Request) {
+	var spec bmc.Spec
+	if err := ParseJSON(r, &spec); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	spec.SetDefaults()
+	if errs := spec.ValidateFields(""); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	node, ok := clusterNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	if node.MachineID != "" {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Nodes running on created machines are managed through their infrastructure")
+		return
+	}
+	if err := h.store.DB().Model(node).Update("bmc", encodeBMC(&spec)).Error; err != nil {
+		WriteInternalError(w, "Failed to save BMC")
+		return
+	}
+
+	h.logEvent(node.ClusterID, "info", node.Address, "power", fmt.Sprintf("Set %s BMC %s of node %s", spec.Protocol, spec.Address, node.Hostname))
+	WriteSuccess(w, map[string]string{"message": "BMC saved"})
+}
+
+// DeleteNodeBMC stops power managing a node
+func (h *ClusterHandler) DeleteNodeBMC(w http.ResponseWriter, r *http.Request) {
+	node, ok := clusterNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DB().Model(node).Update("bmc", "").Error; err != nil {
+		WriteInternalError(w, "Failed to remove BMC")
+		return
+	}
+
+	h.logEvent(node.ClusterID, "info", node.Address, "power", fmt.Sprintf("Removed the BMC of node %s", node.Hostname))
+	WriteSuccess(w, map[string]string{"message": "BMC removed"})
+}
+
+// clusterNode looks up the node of a route with a cluster and node ID,
+// writing an error response if there is none
+func clusterNode(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.Node, bool) {
+	vars := mux.Vars(r)
+	clusterID, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return nil, false
+	}
+	nodeID, err := strconv.ParseUint(vars["nodeId"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid node ID")
+		return nil, false
+	}
+
+	var node db.Node
+	if err := conn.Where("cluster_id = ?", clusterID).First(&node, nodeID).Error; err != nil {
+		WriteNotFound(w, "Node not found")
+		return nil, false
+	}
+	return &node, true
+}
+
+// nodeController returns the node of a route and the controller of its BMC,
+// writing an error response if the node is not power managed
+func nodeController(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.Node, bmc.Controller, bool) {
+	node, ok := clusterNode(conn, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if node.BMC == "" {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Node has no BMC")
+		return nil, nil, false
+	}
+	var spec bmc.Spec
+	if err := json.Unmarshal([]byte(node.BMC), &spec); err != nil {
+		WriteInternalError(w, "Invalid BMC of node")
+		return nil, nil, false
+	}
+	controller, err := bmc.New(spec)
+	if err != nil {
+		WriteError(w, http.StatusConflict, "BMC_ERROR", err.Error())
+		return nil, nil, false
+	}
+	return node, controller, true
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+)
+
+// ProjectRequest represents the request to create or update a project
+type ProjectRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// MemberRequest represents the request to add a member or change their role
+type MemberRequest struct {
+	Role string `json:"role"`
+}
+
+// ProjectHandler handles project API requests
+type ProjectHandler struct {
+	store *db.Store
+}
+
+// NewProjectHandler creates a new project handler
+func NewProjectHandler(store *db.Store) *ProjectHandler {
+	return &ProjectHandler{store: store}
+}
+
+// RegisterRoutes registers project API routes
+func (h *ProjectHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/projects", h.ListProjects).Methods("GET")
+	router.HandleFunc("/api/v1/projects", h.CreateProject).Methods("POST")
+	router.HandleFunc("/api/v1/projects/{id}", h.GetProject).Methods("GET")
+	router.HandleFunc("/api/v1/projects/{id}", h.UpdateProject).Methods("PUT")
+	router.HandleFunc("/api/v1/projects/{id}", h.DeleteProject).Methods("DELETE")
+	router.HandleFunc("/api/v1/projects/{id}/members", h.ListMembers).Methods("GET")
+	router.HandleFunc("/api/v1/projects/{id}/members/{userId}", h.SetMember).Methods("PUT")
+	router.HandleFunc("/api/v1/projects/{id}/members/{userId}", h.RemoveMember).Methods("DELETE")
+}
+
+// ListProjects lists the projects the caller is a member of
+func (h *ProjectHandler) ListProjects(w http.ResponseWriter, r *http.Request) {
+	var projects []db.Project
+	query := scopeProjects(h.store.DB(), r, h.store.DB().Model(&db.Project{}), "id")
+	if err := query.Order("name").Find(&projects).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve projects")
+		return
+	}
+
+	WriteSuccess(w, projects)
+}
+
+// GetProject retrieves a single project by ID
+func (h *ProjectHandler) GetProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+
+	WriteSuccess(w, project)
+}
+
+// CreateProject creates a new project
+func (h *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	var req ProjectRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		WriteBadRequest(w, "Project name is required")
+		return
+	}
+
+	project := db.Project{
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := h.store.DB().Create(&project).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Project name already in use")
+		return
+	}
+
+	WriteCreated(w, project)
+}
+
+// UpdateProject renames a project or changes its description
+func (h *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+
+	var req ProjectRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Name != "" {
+		project.Name = req.Name
+	}
+	project.Description = req.Description
+
+	if err := h.store.DB().Save(project).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Project name already in use")
+		return
+	}
+
+	WriteSuccess(w, project)
+}
+
+// DeleteProject deletes an empty project
+func (h *ProjectHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+	if project.Name == db.DefaultProjectName {
+		WriteBadRequest(w, "The default project cannot be deleted")
+		return
+	}
+
+	var clusters int64
+	h.store.DB().Model(&db.Cluster{}).Where("project_id = ?", project.ID).Count(&clusters)
+	if clusters > 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Project still has clusters")
+		return
+	}
+
+	err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", project.ID).Delete(&db.ProjectMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(project).Error
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to delete project")
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Project deleted"})
+}
+
+// ListMembers lists the members of a project
+func (h *ProjectHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+
+	var members []db.ProjectMember
+	if err := h.store.DB().Preload("User").Where("project_id = ?", project.ID).Find(&members).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve members")
+		return
+	}
+
+	WriteSuccess(w, members)
+}
+
+// SetMember adds a user to a project or changes their role
+func (h *ProjectHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(mux.Vars(r)["userId"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid user ID")
+		return
+	}
+	var user db.User
+	if err := h.store.DB().First(&user, userID).Error; err != nil {
+		WriteNotFound(w, "User not found")
+		return
+	}
+
+	var req MemberRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		WriteBadRequest(w, "Role must be one of admin, operator, viewer")
+		return
+	}
+
+	member := db.ProjectMember{ProjectID: project.ID, UserID: user.ID}
+	err = h.store.DB().Where(db.ProjectMember{ProjectID: project.ID, UserID: user.ID}).
+		Assign(db.ProjectMember{Role: req.Role}).
+		FirstOrCreate(&member).Error
+	if err != nil {
+		WriteInternalError(w, "Failed to save member")
+		return
+	}
+
+	WriteSuccess(w, member)
+}
+
+// RemoveMember removes a user from a project
+func (h *ProjectHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.loadProject(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(mux.Vars(r)["userId"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	if err := h.store.DB().Where("project_id = ? AND user_id = ?", project.ID, userID).Delete(&db.ProjectMember{}).Error; err != nil {
+		WriteInternalError(w, "Failed to remove member")
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Member removed"})
+}
+
+// loadProject resolves the project from the request path
+func (h *ProjectHandler) loadProject(w http.ResponseWriter, r *http.Request) (*db.Project, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid project ID")
+		return nil, false
+	}
+
+	var project db.Project
+	if err := h.store.DB().First(&project, id).Error; err != nil {
+		WriteNotFound(w, "Project not found")
+		return nil, false
+	}
+	return &project, true
+}
+
+// projectRole returns the role of the caller in a project, or "" if they are
+// not a member. Global admins are admins of every project, and every user
+// keeps their global role in the default project unless given another one.
+func projectRole(conn *gorm.DB, claims *auth.Claims, projectID uint) string {
+	if claims == nil {
+		return ""
+	}
+	if claims.Role == auth.RoleAdmin {
+		return auth.RoleAdmin
+	}
+
+	var member db.ProjectMember
+	if err := conn.Where("project_id = ? AND user_id = ?", projectID, claims.UserID()).First(&member).Error; err == nil {
+		return member.Role
+	}
+	if projectID == defaultProjectID(conn) {
+		return claims.Role
+	}
+	return ""
+}
+
+// memberProjects returns the IDs of the caller's projects, including the
+// default project. all is true for global admins, who see every project.
+func memberProjects(conn *gorm.DB, r *http.Request) (ids []uint, all bool) {
+	claims := CurrentUser(r)
+	if claims == nil || claims.Role == auth.RoleAdmin {
+		return nil, true
+	}
+
+	conn.Model(&db.ProjectMember{}).Where("user_id = ?", claims.UserID()).Pluck("project_id", &ids)
+	if id := defaultProjectID(conn); id != 0 && !containsUint(ids, id) {
+		ids = append(ids, id)
+	}
+	return ids, false
+}
+
+// defaultProjectID returns the ID of the default project, or 0 if it is missing
+func defaultProjectID(conn *gorm.DB) uint {
+	var project db.Project
+	if err := conn.Select("id").Where("name = ?", db.DefaultProjectName).First(&project).Error; err != nil {
+		return 0
+	}
+	return project.ID
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeProjects restricts a query to rows whose column references one of the
+// caller's projects
+func scopeProjects(conn *gorm.DB, r *http.Request, query *gorm.DB, column string) *gorm.DB {
+	ids, all := memberProjects(conn, r)
+	if all {
+		return query
+	}
+	if len(ids) == 0 {
+		return query.Where("1 = 0")
+	}
+	return query.Where(column+" IN ?", ids)
+}
+
+// projectFilter returns the projects of the caller for a store filter, nil
+// when the caller sees every project
+func projectFilter(conn *gorm.DB, r *http.Request) []uint {
+	ids, all := memberProjects(conn, r)
+	if all {
+		return nil
+	}
+	if ids == nil {
+		ids = []uint{}
+	}
+	return ids
+}
+
+// defaultClusterProject picks the project for a new cluster when none is
+// given: the only project the caller was added to, or the default project
+func defaultClusterProject(conn *gorm.DB, r *http.Request) uint {
+	if claims := CurrentUser(r); claims != nil && claims.Role != auth.RoleAdmin {
+		var ids []uint
+		conn.Model(&db.ProjectMember{}).Where("user_id = ?", claims.UserID()).Pluck("project_id", &ids)
+		if len(ids) == 1 {
+			return ids[0]
+		}
+	}
+	return defaultProjectID(conn)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/provision"
+)
+
+// ProvisionerInfo describes a provisioner clusters can be created with
+type ProvisionerInfo struct {
+	Name        string `json:"name"`
+	Plugin      bool   `json:"plugin"` // loaded from the plugin directory
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	Default     bool   `json:"default,omitempty"` // used by clusters without a provider
+}
+
+// ProvisionerHandler handles provisioner API requests
+type ProvisionerHandler struct{}
+
+// NewProvisionerHandler creates a new provisioner handler
+func NewProvisionerHandler() *ProvisionerHandler {
+	return &ProvisionerHandler{}
+}
+
+// RegisterRoutes registers provisioner API routes
+func (h *ProvisionerHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/provisioners", h.ListProvisioners).Methods("GET")
+}
+
+// ListProvisioners lists the built-in provisioners and the loaded plugins
+func (h *ProvisionerHandler) ListProvisioners(w http.ResponseWriter, r *http.Request) {
+	plugins := make(map[string]provision.PluginInfo)
+	for _, plugin := range provision.Plugins() {
+		plugins[plugin.Name] = plugin
+	}
+
+	names := provision.ListProvisioners()
+	list := make([]ProvisionerInfo, 0, len(names))
+	for _, name := range names {
+		info := ProvisionerInfo{Name: name, Default: name == provision.DefaultProvisioner}
+		if plugin, ok := plugins[name]; ok {
+			info.Plugin, info.Version, info.Description = true, plugin.Version, plugin.Description
+		}
+		list = append(list, info)
+	}
+	WriteSuccess(w, list)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"k8s.io/client-go/kubernetes"
+	"kubeforge/internal/db"
+	"kubeforge/internal/kube"
+	"kubeforge/internal/provision"
+)
+
+// clusterAPI reaches the API server of a cluster with its stored
+// kubeconfig. It is kept per cluster to reuse connections and rebuilt when
+// the kubeconfig changes.
+type clusterAPI struct {
+	kubeconfig [sha256.Size]byte
+	server     *url.URL
+	client     *http.Client
+	clientset  kubernetes.Interface // typed client-go access to built-in resources
+	proxy      *httputil.ReverseProxy
+}
+
+// clusterAPITimeout bounds reads of the API server made by KubeForge itself
+const clusterAPITimeout = 30 * time.Second
+
+var (
+	clusterAPIsMu sync.Mutex
+	clusterAPIs   = map[uint]*clusterAPI{}
+)
+
+// ProxyCluster forwards /api/v1/clusters/{id}/proxy/<path> to <path> on the
+// API server of the cluster, authenticated with its stored kubeconfig, so
+// the cluster can be queried without a kubeconfig or access to its network.
+// The KubeForge credentials of the caller are not forwarded.
+func (h *ClusterHandler) ProxyCluster(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id", "kubeconfig").First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+	if cluster.Kubeconfig == nil {
+		WriteError(w, http.StatusNotFound, "NOT_FOUND", "Kubeconfig not available")
+		return
+	}
+	api, err := clusterAPIFor(cluster.ID, cluster.Kubeconfig)
+	if err != nil {
+		WriteInternalError(w, "Failed to read the cluster kubeconfig")
+		return
+	}
+
+	// Watches and log follows stay open as long as the client wants
+	disableWriteTimeout(w, r)
+	prefix := "/api/v1/clusters/" + mux.Vars(r)["id"] + "/proxy"
+	out := r.Clone(r.Context())
+	out.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	out.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	api.proxy.ServeHTTP(w, out)
+}
+
+// clusterAPIFor returns the client of the API server of a cluster
+func clusterAPIFor(clusterID uint, kubeconfig []byte) (*clusterAPI, error) {
+	sum := sha256.Sum256(kubeconfig)
+
+	clusterAPIsMu.Lock()
+	defer clusterAPIsMu.Unlock()
+	if cached, ok := clusterAPIs[clusterID]; ok && cached.kubeconfig == sum {
+		return cached, nil
+	}
+
+	server, transport, err := provision.APIServerTransport(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kube.NewClientset(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	api := &clusterAPI{
+		kubeconfig: sum,
+		server:     server,
+		client:     &http.Client{Transport: transport, Timeout: clusterAPITimeout},
+		clientset:  clientset,
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(server)
+				pr.Out.Header.Del("Authorization")
+				pr.Out.Header.Del("Cookie")
+			},
+			Transport: transport,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				slog.WarnContext(r.Context(), "Failed to proxy to API server", "cluster_id", clusterID, "error", err)
+				WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", "API server unreachable")
+			},
+		},
+	}
+	clusterAPIs[clusterID] = api
+	return api, nil
+}
+
+// get reads a resource or list of the API server into out
+func (c *clusterAPI) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server.JoinPath(path).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// serverCertificate returns the serving certificate of the API server
+func (c *clusterAPI) serverCertificate(ctx context.Context) (*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server.JoinPath("/version").String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("API server is not served over TLS")
+	}
+	return resp.TLS.PeerCertificates[0], nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/retention"
+)
+
+// PurgeHandler permanently removes deleted records on request, outside the
+// schedule of the retention policy
+type PurgeHandler struct {
+	pruner *retention.Pruner
+}
+
+// NewPurgeHandler creates a new purge handler
+func NewPurgeHandler(pruner *retention.Pruner) *PurgeHandler {
+	return &PurgeHandler{pruner: pruner}
+}
+
+// RegisterRoutes registers purge API routes
+func (h *PurgeHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/purge", h.Purge).Methods("POST")
+}
+
+// Purge removes the clusters, with their events and other records, hosts
+// and SSH keys deleted longer ago than the older_than duration. It defaults
+// to the deleted_max_age of the retention policy; 0s purges every deleted
+// record.
+func (h *PurgeHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	olderThan := h.pruner.Policy().DeletedMaxAge
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(value); err != nil || olderThan < 0 {
+			WriteBadRequest(w, "Invalid older_than")
+			return
+		}
+	}
+
+	result, err := h.pruner.Purge(r.Context(), time.Now().Add(-olderThan))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to purge deleted records", "clusters", result.Clusters, "error", err)
+		WriteInternalError(w, "Failed to purge deleted records")
+		return
+	}
+	slog.InfoContext(r.Context(), "Purged deleted records", "older_than", olderThan, "clusters", result.Clusters, "events", result.Events, "hosts", result.Hosts, "ssh_keys", result.SSHKeys)
+	WriteSuccess(w, result)
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+)
+
+// routeRule requires a role for a route. An empty method matches any method,
+// a template ending in "*" matches by prefix.
+type routeRule struct {
+	method   string
+	template string
+	role     string
+}
+
+// routeRules are checked in order, the first match wins. Routes without a
+// rule need viewer for reads and operator for changes.
+var routeRules = []routeRule{
+	{"", "/api/v1/auth/*", auth.RoleViewer},
+	{"", "/api/v1/users*", auth.RoleAdmin},
+	{"", "/api/v1/ssh-keys*", auth.RoleAdmin},
+	{"", "/api/v1/audit", auth.RoleAdmin},
+	{"", "/api/v1/state", auth.RoleAdmin},
+	{"POST", "/api/v1/purge", auth.RoleAdmin},
+	{"POST", "/api/v1/notifications/*", auth.RoleAdmin},
+	{"DELETE", "/api/v1/notifications/*", auth.RoleAdmin},
+	{"POST", "/api/v1/host-keys*", auth.RoleAdmin},
+	{"DELETE", "/api/v1/host-keys*", auth.RoleAdmin},
+	{"POST", "/api/v1/templates*", auth.RoleAdmin},
+	{"PUT", "/api/v1/templates*", auth.RoleAdmin},
+	{"DELETE", "/api/v1/templates*", auth.RoleAdmin},
+	{"PUT", "/api/v1/policy", auth.RoleAdmin},
+	{"POST", "/api/v1/projects", auth.RoleAdmin},
+	{"", "/api/v1/projects/{id}/members/{userId}", auth.RoleAdmin},
+	{"PUT", "/api/v1/projects/{id}", auth.RoleAdmin},
+	{"DELETE", "/api/v1/projects/{id}", auth.RoleAdmin},
+	// CreateCluster checks the role in the target project itself
+	{"POST", "/api/v1/clusters", auth.RoleViewer},
+	{"DELETE", "/api/v1/clusters/{id}", auth.RoleAdmin},
+	{"POST", "/api/v1/clusters/{id}/force-state", auth.RoleAdmin},
+	{"POST", "/api/v1/clusters/{id}/restore", auth.RoleAdmin},
+	// CreateBatch checks the role in the project of each selected cluster
+	{"POST", "/api/v1/batch", auth.RoleViewer},
+	// CreateHost and DiscoverHosts check the role in the target project itself
+	{"POST", "/api/v1/hosts", auth.RoleViewer},
+	{"POST", "/api/v1/hosts/discover", auth.RoleViewer},
+	// GetKubeconfig checks the role for the requested access itself, other
+	// kubeconfigs grant cluster-admin access
+	{"GET", "/api/v1/clusters/{id}/kubeconfig", auth.RoleViewer},
+	// GetKubeconfigBundle checks the role in the project of each cluster
+	{"GET", "/api/v1/kubeconfig", auth.RoleViewer},
+	// GraphQL has no mutations, its resolvers only return clusters of the
+	// caller's projects
+	{"", "/api/v1/graphql*", auth.RoleViewer},
+	{"", "/api/v1/clusters/{id}/credentials*", auth.RoleOperator},
+	// The API server proxy acts with the cluster-admin kubeconfig
+	{"", "/api/v1/clusters/{id}/proxy*", auth.RoleOperator},
+	// Cloud-init user-data carries a join token
+	{"GET", "/api/v1/clusters/{id}/cloud-init", auth.RoleOperator},
+}
+
+// requiredRole returns the role needed to call a route
+func requiredRole(method, template string) string {
+	for _, rule := range routeRules {
+		if rule.method != "" && rule.method != method {
+			continue
+		}
+		if strings.HasSuffix(rule.template, "*") {
+			if strings.HasPrefix(template, strings.TrimSuffix(rule.template, "*")) {
+				return rule.role
+			}
+			continue
+		}
+		if rule.template == template {
+			return rule.role
+		}
+	}
+
+	if method == http.MethodGet || method == http.MethodHead {
+		return auth.RoleViewer
+	}
+	return auth.RoleOperator
+}
+
+// Authorize middleware enforces role based access control on authenticated
+// routes. It must run after Authenticate.
+func Authorize(store *db.Store) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			if (!strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/ws/")) || publicPaths[path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims := CurrentUser(r)
+			if claims == nil {
+				WriteError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Authentication required")
+				return
+			}
+
+			template := path
+			if route := mux.CurrentRoute(r); route != nil {
+				if t, err := route.GetPathTemplate(); err == nil {
+					template = t
+				}
+			}
+
+			// Routes of a project resource are checked against the caller's
+			// role in that project rather than their global role
+			role := claims.Role
+			if projectID, found := resourceProject(store.DB(), template, mux.Vars(r)); found {
+				if role = projectRole(store.DB(), claims, projectID); role == "" {
+					WriteNotFound(w, "Resource not found")
+					return
+				}
+			}
+
+			if required := requiredRole(r.Method, template); !auth.HasRole(role, required) {
+				WriteError(w, http.StatusForbidden, "FORBIDDEN", "This action requires the "+required+" role")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resourceProject resolves the project owning the resource of a route. found
+// is false for routes outside any project and for missing resources, which
+// the handlers report themselves.
+func resourceProject(conn *gorm.DB, template string, vars map[string]string) (projectID uint, found bool) {
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	switch {
+	case strings.HasPrefix(template, "/api/v1/projects/{id}"):
+		return uint(id), true
+	case strings.HasPrefix(template, "/api/v1/clusters/{id}"), strings.HasPrefix(template, "/ws/clusters/{id}"):
+		// Deleted clusters still belong to their project until purged
+		var cluster db.Cluster
+		if err := conn.Unscoped().Select("id", "project_id").First(&cluster, id).Error; err != nil {
+			return 0, false
+		}
+		return cluster.ProjectID, true
+	case strings.HasPrefix(template, "/api/v1/hosts/{id}"):
+		var host db.Host
+		if err := conn.Select("id", "project_id").First(&host, id).Error; err != nil {
+			return 0, false
+		}
+		return host.ProjectID, true
+	case strings.HasPrefix(template, "/api/v1/jobs/{id}"):
+		var job db.Job
+		if err := conn.Select("id", "cluster_id").First(&job, id).Error; err != nil {
+			return 0, false
+		}
+		// Jobs without a cluster are checked against the global role
+		if job.ClusterID == 0 {
+			return 0, false
+		}
+		var cluster db.Cluster
+		if err := conn.Unscoped().Select("id", "project_id").First(&cluster, job.ClusterID).Error; err != nil {
+			return 0, false
+		}
+		return cluster.ProjectID, true
+	}
+	return 0, false
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/helm"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/tracing"
+)
+
+// releaseTimeout bounds a single helm install, upgrade or rollback
+const releaseTimeout = 10 * time.Minute
+
+// ReleaseRequest represents the request to install or upgrade a Helm release
+type ReleaseRequest struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	RepoURL   string                 `json:"repo_url"`
+	Chart     string                 `json:"chart"`
+	Version   string                 `json:"version"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+// RollbackRequest represents the request to roll back a Helm release
+type RollbackRequest struct {
+	Revision int `json:"revision"` // 0 rolls back to the previous revision
+}
+
+// Release job types
+const (
+	jobDeployRelease    = "deploy-release" // installs or upgrades
+	jobRollbackRelease  = "rollback-release"
+	jobUninstallRelease = "uninstall-release"
+)
+
+// releasePayload is the input of release jobs
+type releasePayload struct {
+	ReleaseID uint `json:"release_id"`
+	Revision  int  `json:"revision,omitempty"` // rollback-release only
+}
+
+// ReleaseHandler handles Helm release API requests
+type ReleaseHandler struct {
+	store *db.Store
+	queue *jobs.Queue
+}
+
+// NewReleaseHandler creates a new release handler and registers its job handlers
+func NewReleaseHandler(store *db.Store, queue *jobs.Queue) *ReleaseHandler {
+	h := &ReleaseHandler{store: store, queue: queue}
+	queue.RegisterHandler(jobDeployRelease, trackJob(h.runDeployReleaseJob))
+	queue.RegisterHandler(jobRollbackRelease, trackJob(h.runRollbackReleaseJob))
+	queue.RegisterHandler(jobUninstallRelease, trackJob(h.runUninstallReleaseJob))
+	return h
+}
+
+// RegisterRoutes registers release API routes
+func (h *ReleaseHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/clusters/{id}/releases", h.ListReleases).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/releases", h.InstallRelease).Methods("POST")
+	router.HandleFunc("/api/v1/clusters/{id}/releases/{name}", h.GetRelease).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/releases/{name}", h.UpgradeRelease).Methods("PUT")
+	router.HandleFunc("/api/v1/clusters/{id}/releases/{name}", h.UninstallRelease).Methods("DELETE")
+	router.HandleFunc("/api/v1/clusters/{id}/releases/{name}/history", h.GetHistory).Methods("GET")
+	router.HandleFunc("/api/v1/clusters/{id}/releases/{name}/rollback", h.RollbackRelease).Methods("POST")
+}
+
+// ListReleases lists Helm releases managed by KubeForge on a cluster
+func (h *ReleaseHandler) ListReleases(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var releases []db.Release
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("namespace, name").Find(&releases).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve releases")
+		return
+	}
+
+	WriteSuccess(w, releases)
+}
+
+// InstallRelease installs a chart into a cluster
+func (h *ReleaseHandler) InstallRelease(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var req ReleaseRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Name == "" || req.Chart == "" {
+		WriteBadRequest(w, "Release name and chart are required")
+		return
+	}
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var existing db.Release
+	err = h.store.DB().Where("cluster_id = ? AND name = ? AND namespace = ?", cluster.ID, req.Name, req.Namespace).First(&existing).Error
+	if err == nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Release already exists, use PUT to upgrade")
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteInternalError(w, "Failed to retrieve releases")
+		return
+	}
+
+	values, _ := json.Marshal(req.Values)
+	record := db.Release{
+		ClusterID: cluster.ID,
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		RepoURL:   req.RepoURL,
+		Chart:     req.Chart,
+		Version:   req.Version,
+		Values:    string(values),
+		Status:    "installing",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	err = h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return h.enqueueReleaseJob(r.Context(), tx, jobDeployRelease, record, 0)
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to create release")
+		return
+	}
+	h.queue.Notify()
+
+	WriteAccepted(w, releaseLocation(&record), record)
+}
+
+// GetRelease returns a release, whose status tracks a running install,
+// upgrade, rollback or uninstall
+func (h *ReleaseHandler) GetRelease(w http.ResponseWriter, r *http.Request) {
+	record, ok := h.loadRelease(w, r)
+	if !ok {
+		return
+	}
+
+	WriteSuccess(w, record)
+}
+
+// UpgradeRelease upgrades a release to a new chart version and/or values
+func (h *ReleaseHandler) UpgradeRelease(w http.ResponseWriter, r *http.Request) {
+	record, ok := h.loadRelease(w, r)
+	if !ok {
+		return
+	}
+
+	var req ReleaseRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	if req.RepoURL != "" {
+		record.RepoURL = req.RepoURL
+	}
+	if req.Chart != "" {
+		record.Chart = req.Chart
+	}
+	if req.Version != "" {
+		record.Version = req.Version
+	}
+	if req.Values != nil {
+		encoded, _ := json.Marshal(req.Values)
+		record.Values = string(encoded)
+	}
+	record.Status = "upgrading"
+	record.Error = ""
+	err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(record).Error; err != nil {
+			return err
+		}
+		return h.enqueueReleaseJob(r.Context(), tx, jobDeployRelease, *record, 0)
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A deploy of the release is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to update release")
+		return
+	}
+	h.queue.Notify()
+
+	WriteAccepted(w, releaseLocation(record), record)
+}
+
+// RollbackRelease rolls a release back to a previous revision
+func (h *ReleaseHandler) RollbackRelease(w http.ResponseWriter, r *http.Request) {
+	record, ok := h.loadRelease(w, r)
+	if !ok {
+		return
+	}
+
+	var req RollbackRequest
+	if err := ParseJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(record).Updates(map[string]interface{}{"status": "rolling-back", "error": ""}).Error; err != nil {
+			return err
+		}
+		return h.enqueueReleaseJob(r.Context(), tx, jobRollbackRelease, *record, req.Revision)
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A rollback of the release is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to roll back release")
+		return
+	}
+	h.queue.Notify()
+
+	WriteAccepted(w, releaseLocation(record), map[string]string{"message": "Rollback started"})
+}
+
+// UninstallRelease removes a release from a cluster
+func (h *ReleaseHandler) UninstallRelease(w http.ResponseWriter, r *http.Request) {
+	record, ok := h.loadRelease(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(record).Updates(map[string]interface{}{"status": "uninstalling", "error": ""}).Error; err != nil {
+			return err
+		}
+		return h.enqueueReleaseJob(r.Context(), tx, jobUninstallRelease, *record, 0)
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "An uninstall of the release is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to uninstall release")
+		return
+	}
+	h.queue.Notify()
+
+	// The release is gone once the location returns 404
+	WriteAccepted(w, releaseLocation(record), map[string]string{"message": "Uninstall started"})
+}
+
+// GetHistory returns the revision history of a release
+func (h *ReleaseHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	record, ok := h.loadRelease(w, r)
+	if !ok {
+		return
+	}
+
+	var history []helm.Revision
+	err := withHelm(h.store.DB(), r.Context(), record.ClusterID, func(ctx context.Context, client *helm.Client) error {
+		var err error
+		history, err = client.History(ctx, record.Name, record.Namespace)
+		return err
+	})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve release history: "+err.Error())
+		return
+	}
+
+	WriteSuccess(w, history)
+}
+
+// enqueueReleaseJob queues a release job for a release record in the
+// transaction that saves it, so the job cannot run before the record is
+// committed. Call Notify on the queue once the transaction has committed.
+func (h *ReleaseHandler) enqueueReleaseJob(ctx context.Context, tx *gorm.DB, jobType string, record db.Release, revision int) error {
+	payload, _ := json.Marshal(releasePayload{ReleaseID: record.ID, Revision: revision})
+	job := db.Job{
+		ClusterID:   record.ClusterID,
+		Type:        jobType,
+		Target:      record.Namespace + "/" + record.Name,
+		Payload:     string(payload),
+		RequestID:   logging.RequestID(ctx),
+		TraceParent: tracing.Inject(ctx),
+	}
+	return h.queue.EnqueueTx(db.NewStore(tx).Jobs, &job)
+}
+
+// loadReleaseJob returns the payload and release record of a release job
+func loadReleaseJob(conn *gorm.DB, job *db.Job) (releasePayload, db.Release, error) {
+	var payload releasePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return payload, db.Release{}, fmt.Errorf("invalid release job payload: %w", err)
+	}
+	var record db.Release
+	err := conn.First(&record, payload.ReleaseID).Error
+	return payload, record, err
+}
+
+// runDeployReleaseJob installs or upgrades the release of a job
+func (h *ReleaseHandler) runDeployReleaseJob(ctx context.Context, job *db.Job) error {
+	_, record, err := loadReleaseJob(h.store.DB(), job)
+	if err != nil {
+		return fmt.Errorf("failed to load release: %w", err)
+	}
+	return h.deployRelease(ctx, record)
+}
+
+// runRollbackReleaseJob rolls back the release of a job
+func (h *ReleaseHandler) runRollbackReleaseJob(ctx context.Context, job *db.Job) error {
+	payload, record, err := loadReleaseJob(h.store.DB(), job)
+	if err != nil {
+		return fmt.Errorf("failed to load release: %w", err)
+	}
+	return h.rollbackRelease(ctx, record, payload.Revision)
+}
+
+// runUninstallReleaseJob uninstalls the release of a job. A release that is
+// gone was uninstalled before the job was interrupted.
+func (h *ReleaseHandler) runUninstallReleaseJob(ctx context.Context, job *db.Job) error {
+	_, record, err := loadReleaseJob(h.store.DB(), job)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load release: %w", err)
+	}
+	return h.uninstallRelease(ctx, record)
+}
+
+// deployRelease runs helm upgrade --install with the stored values
+func (h *ReleaseHandler) deployRelease(ctx context.Context, record db.Release) error {
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(record.Values), &values); record.Values != "" && err != nil {
+		return h.fail(record, "Invalid values of release "+record.Name, err)
+	}
+
+	recordEvent(record.ClusterID, "info", "localhost", "helm", "Deploying release "+record.Name+" ("+record.Chart+")")
+
+	var release *helm.Release
+	err := withHelm(h.store.DB(), ctx, record.ClusterID, func(ctx context.Context, client *helm.Client) error {
+		var err error
+		release, err = client.UpgradeInstall(ctx, helm.ChartSpec{
+			ReleaseName: record.Name,
+			Namespace:   record.Namespace,
+			RepoURL:     record.RepoURL,
+			Chart:       record.Chart,
+			Version:     record.Version,
+			Values:      values,
+			Wait:        true,
+			Timeout:     releaseTimeout,
+		})
+		return err
+	})
+	if err != nil {
+		return h.fail(record, "Failed to deploy release "+record.Name, err)
+	}
+
+	h.store.DB().Model(&db.Release{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status":   release.Status,
+		"revision": release.Revision,
+		"error":    "",
+	})
+	recordEvent(record.ClusterID, "info", "localhost", "helm", "Release "+record.Name+" deployed (revision "+release.Revision+")")
+	return nil
+}
+
+// rollbackRelease runs helm rollback
+func (h *ReleaseHandler) rollbackRelease(ctx context.Context, record db.Release, revision int) error {
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+
+	var release *helm.Release
+	err := withHelm(h.store.DB(), ctx, record.ClusterID, func(ctx context.Context, client *helm.Client) error {
+		if err := client.Rollback(ctx, record.Name, record.Namespace, revision); err != nil {
+			return err
+		}
+		var err error
+		release, err = client.Status(ctx, record.Name, record.Namespace)
+		return err
+	})
+	if err != nil {
+		return h.fail(record, "Failed to roll back release "+record.Name, err)
+	}
+
+	h.store.DB().Model(&db.Release{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status":   release.Status,
+		"revision": release.Revision,
+	})
+	recordEvent(record.ClusterID, "info", "localhost", "helm", "Release "+record.Name+" rolled back to revision "+release.Revision)
+	return nil
+}
+
+// uninstallRelease runs helm uninstall and deletes the release record
+func (h *ReleaseHandler) uninstallRelease(ctx context.Context, record db.Release) error {
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+
+	err := withHelm(h.store.DB(), ctx, record.ClusterID, func(ctx context.Context, client *helm.Client) error {
+		return client.Uninstall(ctx, record.Name, record.Namespace)
+	})
+	if err != nil {
+		return h.fail(record, "Failed to uninstall release "+record.Name, err)
+	}
+
+	if err := h.store.DB().Delete(&db.Release{}, record.ID).Error; err != nil {
+		return err
+	}
+	recordEvent(record.ClusterID, "info", "localhost", "helm", "Release "+record.Name+" uninstalled")
+	return nil
+}
+
+// loadRelease resolves the cluster and release from the request path
+func (h *ReleaseHandler) loadRelease(w http.ResponseWriter, r *http.Request) (*db.Release, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return nil, false
+	}
+
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return nil, false
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	var record db.Release
+	if err := h.store.DB().Where("cluster_id = ? AND name = ? AND namespace = ?", cluster.ID, vars["name"], namespace).First(&record).Error; err != nil {
+		WriteNotFound(w, "Release not found")
+		return nil, false
+	}
+	return &record, true
+}
+
+// fail marks a release as failed, records an error event and returns err
+func (h *ReleaseHandler) fail(record db.Release, message string, err error) error {
+	recordEvent(record.ClusterID, "error", "localhost", "helm", message+": "+err.Error())
+	h.store.DB().Model(&db.Release{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+		"status": "failed",
+		"error":  err.Error(),
+	})
+	return err
+}
+
+// withHelm runs fn with a helm client for the cluster's API server, using
+// its stored admin kubeconfig
+func withHelm(conn *gorm.DB, ctx context.Context, clusterID uint, fn func(ctx context.Context, client *helm.Client) error) error {
+	kubeconfig, err := clusterKubeconfig(conn, clusterID)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, helm.NewClient(kubeconfig))
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// ReplaceNode replaces a worker, typically one on failed hardware, with a
+// new host given inline or as an inventory host_id. A reconcile job joins
+// the new host first, then drains the old node, deletes it and resets its
+// host if it is still reachable. The new host takes over the labels and
+// taints of the old one unless it sets its own, and the cluster spec is
+// recorded as a new revision with the host swapped.
+func (h *ClusterHandler) ReplaceNode(w http.ResponseWriter, r *http.Request) {
+	var host provision.HostSpec
+	if err := ParseJSON(r, &host); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	node, ok := clusterNode(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, node.ClusterID)
+	if !ok {
+		return
+	}
+	if node.Role == "control-plane" {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Replacing control plane nodes is not supported")
+		return
+	}
+
+	spec, err := latestSpec(h.store.DB(), cluster.ID)
+	if err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cluster has no recorded spec")
+		return
+	}
+	index := -1
+	for i, worker := range spec.Workers {
+		if hostTarget(worker) == nodeTarget(*node) {
+			index = i
+		}
+	}
+	if index < 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Node is not part of the cluster spec")
+		return
+	}
+
+	old := spec.Workers[index]
+	host.Role = "worker"
+	if host.Labels == nil {
+		host.Labels = old.Labels
+	}
+	if host.Taints == nil {
+		host.Taints = old.Taints
+	}
+	spec.Workers[index] = host
+
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	errs := resolveInventoryHosts(h.store.DB(), cluster.ProjectID, cluster.ID, &spec)
+	if len(errs) == 0 {
+		errs = spec.Validate(h.store.DB())
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	host = spec.Workers[index]
+	if hostTarget(host) == nodeTarget(*node) {
+		errs.Add(validation.Path(validation.Index("workers", index), "address"), validation.CodeInvalid, "the new host must differ from the node it replaces")
+		WriteValidationError(w, errs)
+		return
+	}
+	keyID, errs := resolveHostSSHKeys(h.store.DB(), cluster.ProjectID, validation.Index("workers", index), host)
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	host.SSHKeyID = keyID
+
+	// Unlike a planned reconciliation, the new worker joins before the old
+	// one is drained, so the workloads have somewhere to go
+	payload := reconcilePayload{
+		Plan: []PlanAction{
+			{Action: actionAddWorker, Target: hostTarget(host)},
+			{Action: actionRemoveWorker, Target: nodeTarget(*node)},
+		},
+		Workers: []provision.HostSpec{host},
+	}
+	if host.Machine != nil {
+		payload.Infrastructure = spec.Infrastructure
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "reconcile",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A reconciliation is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue reconcile job")
+		return
+	}
+
+	recordRevision(h.store.DB(), cluster.ID, spec, revisionReplace, 0, job.ID, r)
+	h.logEvent(cluster.ID, "info", node.Address, "replace", "Replacing node "+node.Hostname+" with "+hostTarget(host))
+	WriteAccepted(w, jobLocation(job.ID), ApplySpecResponse{Plan: payload.Plan, Job: &job})
+}
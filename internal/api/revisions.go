@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/audit"
+	"kubeforge/internal/db"
+)
+
+// Actions recording a cluster revision
+const (
+	revisionCreate   = "create"
+	revisionApply    = "apply"
+	revisionRollback = "rollback"
+	revisionReplace  = "replace"
+	revisionScale    = "scale"
+	revisionAddNodes = "add-nodes"
+)
+
+// RevisionResponse is a cluster revision with its spec. Secrets of the spec
+// are redacted.
+type RevisionResponse struct {
+	db.ClusterRevision
+	Spec interface{} `json:"spec"`
+}
+
+// SpecChange is a field that differs between two revisions. From or To is
+// missing when the field was added or removed.
+type SpecChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// RevisionDiff lists the changes from one revision to another
+type RevisionDiff struct {
+	From    int          `json:"from"`
+	To      int          `json:"to"`
+	Changes []SpecChange `json:"changes"`
+}
+
+// ListRevisions lists the revisions of a cluster, newest first
+func (h *ClusterHandler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var revisions []db.ClusterRevision
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("revision DESC").Find(&revisions).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve revisions")
+		return
+	}
+
+	responses := make([]RevisionResponse, 0, len(revisions))
+	for _, revision := range revisions {
+		spec, err := redactedSpec(revision)
+		if err != nil {
+			WriteInternalError(w, fmt.Sprintf("Failed to read revision %d", revision.Revision))
+			return
+		}
+		responses = append(responses, RevisionResponse{ClusterRevision: revision, Spec: spec})
+	}
+	WriteSuccess(w, responses)
+}
+
+// GetRevision retrieves a single revision of a cluster
+func (h *ClusterHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	revision, ok := loadRevision(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	spec, err := redactedSpec(*revision)
+	if err != nil {
+		WriteInternalError(w, "Failed to read revision")
+		return
+	}
+	WriteSuccess(w, RevisionResponse{ClusterRevision: *revision, Spec: spec})
+}
+
+// DiffRevision lists what changed in a revision compared to the revision
+// given by ?from=, by default the one before it
+func (h *ClusterHandler) DiffRevision(w http.ResponseWriter, r *http.Request) {
+	to, ok := loadRevision(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	fromNumber := to.Revision - 1
+	if value := r.URL.Query().Get("from"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			WriteBadRequest(w, "Invalid from revision")
+			return
+		}
+		fromNumber = n
+	}
+
+	// The first revision is compared to an empty spec
+	var fromSpec interface{} = map[string]interface{}{}
+	if fromNumber > 0 {
+		var from db.ClusterRevision
+		if err := h.store.DB().Where("cluster_id = ? AND revision = ?", to.ClusterID, fromNumber).First(&from).Error; err != nil {
+			WriteNotFound(w, "Revision not found")
+			return
+		}
+		spec, err := redactedSpec(from)
+		if err != nil {
+			WriteInternalError(w, "Failed to read revision")
+			return
+		}
+		fromSpec = spec
+	}
+	toSpec, err := redactedSpec(*to)
+	if err != nil {
+		WriteInternalError(w, "Failed to read revision")
+		return
+	}
+
+	changes := []SpecChange{}
+	diffSpecs("", fromSpec, toSpec, &changes)
+	WriteSuccess(w, RevisionDiff{From: fromNumber, To: to.Revision, Changes: changes})
+}
+
+// RollbackRevision applies the spec of an earlier revision like PUT
+// /spec, dry_run included, and records it as a new revision
+func (h *ClusterHandler) RollbackRevision(w http.ResponseWriter, r *http.Request) {
+	revision, ok := loadRevision(h.store.DB(), w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateClusterRequest
+	if err := json.Unmarshal([]byte(revision.Spec), &req); err != nil {
+		WriteInternalError(w, "Failed to read revision")
+		return
+	}
+	h.applySpec(w, r, revision.ClusterID, req, revisionRollback, revision.Revision)
+}
+
+// loadRevision resolves the revision of a cluster from the request path
+func loadRevision(conn *gorm.DB, w http.ResponseWriter, r *http.Request) (*db.ClusterRevision, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return nil, false
+	}
+	number, err := strconv.Atoi(vars["revision"])
+	if err != nil {
+		WriteBadRequest(w, "Invalid revision")
+		return nil, false
+	}
+
+	var revision db.ClusterRevision
+	if err := conn.Where("cluster_id = ? AND revision = ?", id, number).First(&revision).Error; err != nil {
+		WriteNotFound(w, "Revision not found")
+		return nil, false
+	}
+	return &revision, true
+}
+
+// recordRevision stores the spec applied to a cluster as its next revision,
+// unless it is the spec of the latest revision
+func recordRevision(conn *gorm.DB, clusterID uint, req CreateClusterRequest, action string, source int, jobID uint, r *http.Request) {
+	if err := saveRevision(conn, clusterID, req, action, source, jobID, r); err != nil {
+		slog.Error("Failed to record cluster revision", "cluster_id", clusterID, "error", err)
+	}
+}
+
+// saveRevision records a revision like recordRevision, within tx
+func saveRevision(tx *gorm.DB, clusterID uint, req CreateClusterRequest, action string, source int, jobID uint, r *http.Request) error {
+	spec, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var latest db.ClusterRevision
+	if err := tx.Where("cluster_id = ?", clusterID).Order("revision DESC").First(&latest).Error; err == nil && latest.Spec == string(spec) {
+		return nil
+	}
+
+	revision := db.ClusterRevision{
+		ClusterID:      clusterID,
+		Revision:       latest.Revision + 1,
+		Action:         action,
+		SourceRevision: source,
+		Spec:           string(spec),
+		JobID:          jobID,
+		CreatedAt:      time.Now(),
+	}
+	if claims := CurrentUser(r); claims != nil {
+		revision.CreatedBy = claims.Username
+	}
+	return tx.Create(&revision).Error
+}
+
+// latestSpec returns the spec of the latest revision of a cluster
+func latestSpec(conn *gorm.DB, clusterID uint) (CreateClusterRequest, error) {
+	var req CreateClusterRequest
+	var latest db.ClusterRevision
+	if err := conn.Where("cluster_id = ?", clusterID).Order("revision DESC").First(&latest).Error; err != nil {
+		return req, err
+	}
+	err := json.Unmarshal([]byte(latest.Spec), &req)
+	return req, err
+}
+
+// redactedSpec decodes the spec of a revision without its secrets: addon
+// credentials, SSH keys and passwords
+func redactedSpec(revision db.ClusterRevision) (interface{}, error) {
+	var req CreateClusterRequest
+	if err := json.Unmarshal([]byte(revision.Spec), &req); err != nil {
+		return nil, err
+	}
+	for i, addon := range req.Addons {
+		if public, _, err := addons.SplitCredentials(addon.Config); err == nil {
+			req.Addons[i].Config = public
+		}
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var spec interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return audit.Redact(spec), nil
+}
+
+// diffSpecs appends the differences between two decoded JSON values to
+// changes, with paths like workers[1].address
+func diffSpecs(path string, from, to interface{}, changes *[]SpecChange) {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(f)+len(t))
+			for key := range f {
+				keys = append(keys, key)
+			}
+			for key := range t {
+				if _, ok := f[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				child := key
+				if path != "" {
+					child = path + "." + key
+				}
+				diffSpecs(child, f[key], t[key], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			for i := 0; i < len(f) || i < len(t); i++ {
+				var fromItem, toItem interface{}
+				if i < len(f) {
+					fromItem = f[i]
+				}
+				if i < len(t) {
+					toItem = t[i]
+				}
+				diffSpecs(fmt.Sprintf("%s[%d]", path, i), fromItem, toItem, changes)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, SpecChange{Path: path, From: from, To: to})
+	}
+}
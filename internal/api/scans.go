@@ -0,0 +1,423 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// Methods of running kube-bench
+const (
+	scanMethodJob = "job"
+	scanMethodSSH = "ssh"
+)
+
+// scanNamespace is the namespace kube-bench Jobs run in
+const scanNamespace = "kube-system"
+
+// scanNodeTimeout bounds running kube-bench on a single node
+const scanNodeTimeout = 10 * time.Minute
+
+// Statuses of a scan
+const (
+	scanPending   = "pending"
+	scanRunning   = "running"
+	scanCompleted = "completed"
+	scanFailed    = "failed"
+)
+
+// ScanRequest starts a CIS benchmark scan of the nodes of a cluster
+type ScanRequest struct {
+	Method  string `json:"method,omitempty"`   // job (default) or ssh, which needs kube-bench installed on the hosts
+	NodeIDs []uint `json:"node_ids,omitempty"` // every node when empty
+}
+
+// scanPayload is the input of a scan job
+type scanPayload struct {
+	ScanID  uint   `json:"scan_id"`
+	NodeIDs []uint `json:"node_ids"`
+}
+
+// ScanChange is a check whose status differs between two scans. An empty
+// status means the check was not reported by that scan.
+type ScanChange struct {
+	Node        string `json:"node"`
+	CheckID     string `json:"check_id"`
+	Description string `json:"description"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+}
+
+// ScanDiff lists how the findings of a scan differ from an earlier one
+type ScanDiff struct {
+	BaseScanID  uint         `json:"base_scan_id"`
+	ScanID      uint         `json:"scan_id"`
+	NewFailures []ScanChange `json:"new_failures"` // checks failing now that did not before
+	Fixed       []ScanChange `json:"fixed"`        // checks failing before that do not now
+	Changed     []ScanChange `json:"changed"`      // other status changes
+}
+
+// CreateScan starts a job running kube-bench on the nodes of a cluster, as a
+// Job in the cluster on each node or over SSH, and returns the scan
+func (h *ClusterHandler) CreateScan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if r.ContentLength != 0 {
+		if err := ParseJSON(r, &req); err != nil {
+			WriteBadRequest(w, "Invalid request body")
+			return
+		}
+	}
+	if req.Method == "" {
+		req.Method = scanMethodJob
+	}
+	var errs validation.Errors
+	if !validation.OneOf(req.Method, []string{scanMethodJob, scanMethodSSH}) {
+		errs.Add("method", validation.CodeUnsupported, fmt.Sprintf("unsupported method %q, expected job or ssh", req.Method))
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var nodes []db.Node
+	query := h.store.DB().Where("cluster_id = ?", cluster.ID)
+	if len(req.NodeIDs) > 0 {
+		query = query.Where("id IN ?", req.NodeIDs)
+	}
+	query.Order("id").Find(&nodes)
+	for i, nodeID := range req.NodeIDs {
+		found := false
+		for _, node := range nodes {
+			found = found || node.ID == nodeID
+		}
+		if !found {
+			errs.Add(validation.Index("node_ids", i), validation.CodeInvalid, fmt.Sprintf("node %d not found in the cluster", nodeID))
+		}
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if len(nodes) == 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", "The cluster has no nodes")
+		return
+	}
+
+	scan := db.Scan{ClusterID: cluster.ID, Method: req.Method, Status: scanPending, CreatedAt: time.Now()}
+	if err := h.store.DB().Create(&scan).Error; err != nil {
+		WriteInternalError(w, "Failed to create scan")
+		return
+	}
+	payload := scanPayload{ScanID: scan.ID}
+	for _, node := range nodes {
+		payload.NodeIDs = append(payload.NodeIDs, node.ID)
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "scan",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		h.store.DB().Delete(&scan)
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A scan of the cluster is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue scan job")
+		return
+	}
+	scan.JobID = job.ID
+	h.store.DB().Model(&scan).Update("job_id", job.ID)
+
+	WriteAccepted(w, jobLocation(job.ID), scan)
+}
+
+// ListScans lists the scans of a cluster, newest first, without their
+// findings
+func (h *ClusterHandler) ListScans(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var scans []db.Scan
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("id desc").Find(&scans).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve scans")
+		return
+	}
+	WriteSuccess(w, scans)
+}
+
+// GetScan returns a scan with its findings, of one node with ?node= and of
+// one status with ?status=
+func (h *ClusterHandler) GetScan(w http.ResponseWriter, r *http.Request) {
+	scan, ok := clusterScan(h.store.DB(), w, r, mux.Vars(r)["scanId"])
+	if !ok {
+		return
+	}
+	query := h.store.DB().Where("scan_id = ?", scan.ID)
+	if node := r.URL.Query().Get("node"); node != "" {
+		query = query.Where("node = ?", node)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if err := query.Order("node, id").Find(&scan.Results).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve scan results")
+		return
+	}
+	WriteSuccess(w, scan)
+}
+
+// DiffScan compares the findings of a scan with those of ?base=, by default
+// the previous completed scan of the cluster
+func (h *ClusterHandler) DiffScan(w http.ResponseWriter, r *http.Request) {
+	scan, ok := clusterScan(h.store.DB(), w, r, mux.Vars(r)["scanId"])
+	if !ok {
+		return
+	}
+	var base db.Scan
+	if baseID := r.URL.Query().Get("base"); baseID != "" {
+		if base, ok = clusterScan(h.store.DB(), w, r, baseID); !ok {
+			return
+		}
+	} else if err := h.store.DB().Where("cluster_id = ? AND status = ? AND id < ?", scan.ClusterID, scanCompleted, scan.ID).
+		Order("id desc").First(&base).Error; err != nil {
+		WriteNotFound(w, "No earlier completed scan to compare with")
+		return
+	}
+	if scan.Status != scanCompleted || base.Status != scanCompleted {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Only completed scans can be compared")
+		return
+	}
+
+	var before, after []db.ScanResult
+	h.store.DB().Where("scan_id = ?", base.ID).Find(&before)
+	h.store.DB().Where("scan_id = ?", scan.ID).Find(&after)
+	WriteSuccess(w, diffScanResults(base.ID, scan.ID, before, after))
+}
+
+// clusterScan resolves a scan of the cluster of the request
+func clusterScan(conn *gorm.DB, w http.ResponseWriter, r *http.Request, scanID string) (db.Scan, bool) {
+	var scan db.Scan
+	clusterID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return scan, false
+	}
+	id, err := strconv.ParseUint(scanID, 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid scan ID")
+		return scan, false
+	}
+	if err := conn.Where("cluster_id = ?", clusterID).First(&scan, id).Error; err != nil {
+		WriteNotFound(w, "Scan not found")
+		return scan, false
+	}
+	return scan, true
+}
+
+// diffScanResults compares the findings of two scans by node and check
+func diffScanResults(baseID, scanID uint, before, after []db.ScanResult) ScanDiff {
+	type key struct{ node, check string }
+	previous := make(map[key]db.ScanResult, len(before))
+	for _, result := range before {
+		previous[key{result.Node, result.CheckID}] = result
+	}
+
+	diff := ScanDiff{BaseScanID: baseID, ScanID: scanID, NewFailures: []ScanChange{}, Fixed: []ScanChange{}, Changed: []ScanChange{}}
+	add := func(change ScanChange) {
+		switch {
+		case change.To == "FAIL":
+			diff.NewFailures = append(diff.NewFailures, change)
+		case change.From == "FAIL":
+			diff.Fixed = append(diff.Fixed, change)
+		default:
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	for _, result := range after {
+		k := key{result.Node, result.CheckID}
+		old, ok := previous[k]
+		delete(previous, k)
+		if ok && old.Status == result.Status {
+			continue
+		}
+		add(ScanChange{Node: result.Node, CheckID: result.CheckID, Description: result.Description, From: old.Status, To: result.Status})
+	}
+	for _, old := range previous {
+		add(ScanChange{Node: old.Node, CheckID: old.CheckID, Description: old.Description, From: old.Status})
+	}
+
+	for _, changes := range [][]ScanChange{diff.NewFailures, diff.Fixed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool {
+			if changes[i].Node != changes[j].Node {
+				return changes[i].Node < changes[j].Node
+			}
+			return changes[i].CheckID < changes[j].CheckID
+		})
+	}
+	return diff
+}
+
+// runScanJob runs kube-bench on the nodes of a scan one at a time and
+// stores the findings. A resumed job starts the scan over.
+func (h *ClusterHandler) runScanJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload scanPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.reportError(clusterID, "Invalid scan job payload", err)
+		return err
+	}
+	var scan db.Scan
+	if err := h.store.DB().First(&scan, payload.ScanID).Error; err != nil {
+		h.reportError(clusterID, "Scan not found", err)
+		return err
+	}
+	h.store.DB().Where("scan_id = ?", scan.ID).Delete(&db.ScanResult{})
+	h.store.DB().Model(&scan).Updates(map[string]interface{}{"status": scanRunning, "error": "", "pass": 0, "fail": 0, "warn": 0, "info": 0})
+
+	err := h.scanNodes(ctx, job, &scan, payload.NodeIDs)
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status": scanCompleted, "finished_at": &now,
+		"pass": scan.Pass, "fail": scan.Fail, "warn": scan.Warn, "info": scan.Info,
+	}
+	if err != nil {
+		updates["status"], updates["error"] = scanFailed, err.Error()
+	}
+	h.store.DB().Model(&scan).Updates(updates)
+	if err != nil {
+		return err
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "complete",
+		fmt.Sprintf("CIS scan %d completed: %d passed, %d failed, %d warnings", scan.ID, scan.Pass, scan.Fail, scan.Warn))
+	return nil
+}
+
+// scanNodes runs kube-bench on each node and saves its findings, counting
+// them in the scan
+func (h *ClusterHandler) scanNodes(ctx context.Context, job *db.Job, scan *db.Scan, nodeIDs []uint) error {
+	clusterID := scan.ClusterID
+	skipHostKeyCheck := skipsHostKeyCheck(h.store.DB(), clusterID)
+	progress := &provisionProgress{job: job, total: len(nodeIDs)}
+
+	h.logEvent(clusterID, "info", "localhost", "scan", fmt.Sprintf("Running kube-bench on %d nodes (%s)", len(nodeIDs), scan.Method))
+	for _, id := range nodeIDs {
+		var node db.Node
+		if err := h.store.DB().Where("cluster_id = ?", clusterID).First(&node, id).Error; err != nil {
+			h.logEvent(clusterID, "warn", "localhost", "scan", fmt.Sprintf("Node %d was removed, skipping it", id))
+			progress.advance("scan", 1)
+			continue
+		}
+
+		var checks []provision.BenchCheck
+		err := provision.RunStep(ctx, "scan "+node.Address, provision.Duration(scanNodeTimeout), func(ctx context.Context) error {
+			var err error
+			if scan.Method == scanMethodSSH {
+				checks, err = sshKubeBench(ctx, nodeHostSpec(node, skipHostKeyCheck))
+			} else {
+				checks, err = jobKubeBench(h.store.DB(), ctx, clusterID, fmt.Sprintf("kubeforge-bench-%d-%d", scan.ID, node.ID), node.Hostname)
+			}
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.reportError(clusterID, "Failed to scan node "+node.Hostname, err)
+			return fmt.Errorf("node %s: %w", node.Hostname, err)
+		}
+
+		results := make([]db.ScanResult, 0, len(checks))
+		for _, check := range checks {
+			results = append(results, db.ScanResult{
+				ScanID:      scan.ID,
+				Node:        node.Hostname,
+				CheckID:     check.ID,
+				Section:     check.Section,
+				Description: check.Description,
+				Status:      check.Status,
+				Scored:      check.Scored,
+				Remediation: check.Remediation,
+			})
+			switch check.Status {
+			case "PASS":
+				scan.Pass++
+			case "FAIL":
+				scan.Fail++
+			case "WARN":
+				scan.Warn++
+			default:
+				scan.Info++
+			}
+		}
+		if err := h.store.DB().CreateInBatches(&results, 200).Error; err != nil {
+			return fmt.Errorf("failed to save findings of %s: %w", node.Hostname, err)
+		}
+		h.logEvent(clusterID, "info", node.Address, "scan", fmt.Sprintf("Scanned node %s: %d checks", node.Hostname, len(checks)))
+		progress.advance("scan", 1)
+	}
+	return nil
+}
+
+// sshKubeBench runs kube-bench installed on a host
+func sshKubeBench(ctx context.Context, host provision.HostSpec) ([]provision.BenchCheck, error) {
+	client, err := provision.NewSSHClient(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+	return provision.RunKubeBench(ctx, client)
+}
+
+// jobKubeBench runs kube-bench as a Job on a node of the cluster, reads its
+// output from the logs of the Job and deletes it
+func jobKubeBench(conn *gorm.DB, ctx context.Context, clusterID uint, name, node string) ([]provision.BenchCheck, error) {
+	var checks []provision.BenchCheck
+	err := withKubectl(conn, ctx, clusterID, func(ctx context.Context, kubectl *addons.Kubectl) error {
+		if err := kubectl.Apply(ctx, provision.KubeBenchJob(name, scanNamespace, node)); err != nil {
+			return err
+		}
+		defer kubectl.Run(context.Background(), fmt.Sprintf("delete job %s -n %s --ignore-not-found", name, scanNamespace))
+
+		wait := fmt.Sprintf("wait --for=condition=complete job/%s -n %s --timeout=%ds", name, scanNamespace, int(scanNodeTimeout.Seconds()))
+		if _, err := kubectl.Run(ctx, wait); err != nil {
+			return err
+		}
+		output, err := kubectl.Run(ctx, fmt.Sprintf("logs job/%s -n %s", name, scanNamespace))
+		if err != nil {
+			return err
+		}
+		checks, err = provision.ParseKubeBench([]byte(output))
+		return err
+	})
+	return checks, err
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/secrets"
+)
+
+// StatePassphraseHeader carries the passphrase a state archive is encrypted
+// with
+const StatePassphraseHeader = "X-State-Passphrase"
+
+// maxStateSize bounds an imported state archive
+const maxStateSize = 512 << 20
+
+// StateHandler exports and imports the state of the server, to rebuild it
+// or move it to another database
+type StateHandler struct {
+	store *db.Store
+}
+
+// NewStateHandler creates a new state handler
+func NewStateHandler(store *db.Store) *StateHandler {
+	return &StateHandler{store: store}
+}
+
+// RegisterRoutes registers state API routes
+func (h *StateHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/state", h.ExportState).Methods("GET")
+	router.HandleFunc("/api/v1/state", h.ImportState).Methods("POST")
+}
+
+// ExportState returns the state of the server as a gzipped tar archive
+// encrypted with the passphrase of the X-State-Passphrase header
+func (h *StateHandler) ExportState(w http.ResponseWriter, r *http.Request) {
+	passphrase := r.Header.Get(StatePassphraseHeader)
+	if passphrase == "" {
+		WriteBadRequest(w, StatePassphraseHeader+" header is required")
+		return
+	}
+
+	// Large installations take longer to archive and send than WriteTimeout
+	disableWriteTimeout(w, r)
+
+	// Buffered, so a failure is still reported as an error response
+	var archive bytes.Buffer
+	manifest, err := db.ExportState(h.store.DB(), &archive, passphrase)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to export state", "error", err)
+		WriteInternalError(w, "Failed to export state")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=kubeforge-state-%s.tar.gz", manifest.ExportedAt.Format("20060102-150405")))
+	w.Write(archive.Bytes())
+}
+
+// ImportState restores a state archive sent as the request body into a
+// server that does not manage clusters yet
+func (h *StateHandler) ImportState(w http.ResponseWriter, r *http.Request) {
+	passphrase := r.Header.Get(StatePassphraseHeader)
+	if passphrase == "" {
+		WriteBadRequest(w, StatePassphraseHeader+" header is required")
+		return
+	}
+
+	manifest, err := db.ImportState(h.store.DB(), http.MaxBytesReader(w, r.Body, maxStateSize), passphrase)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrStateNotEmpty):
+			WriteError(w, http.StatusConflict, "CONFLICT", err.Error())
+		case errors.Is(err, db.ErrStateInvalid):
+			WriteBadRequest(w, err.Error())
+		case errors.Is(err, secrets.ErrDecryptFailed):
+			WriteBadRequest(w, "Failed to decrypt state, check the passphrase")
+		default:
+			slog.ErrorContext(r.Context(), "Failed to import state", "error", err)
+			WriteInternalError(w, "Failed to import state")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Imported state", "exported_at", manifest.ExportedAt, "driver", manifest.Driver, "clusters", manifest.Counts["clusters"])
+	WriteSuccess(w, manifest)
+}
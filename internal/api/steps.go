@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+)
+
+// HostStep is the status of a step of a job on a host
+type HostStep struct {
+	Host       string               `json:"host"`
+	Step       provision.Step       `json:"step"`
+	Phase      provision.Phase      `json:"phase,omitempty"`
+	Status     provision.StepStatus `json:"status"`
+	Message    string               `json:"message,omitempty"` // of the last status change, the error of a failed step
+	StartedAt  *time.Time           `json:"started_at,omitempty"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+	Duration   float64              `json:"duration_seconds,omitempty"` // so far for a running step
+}
+
+// JobSteps lists the steps of a job per host
+type JobSteps struct {
+	JobID     uint       `json:"job_id"`
+	JobType   string     `json:"job_type"`
+	JobStatus string     `json:"job_status"`
+	Steps     []HostStep `json:"steps"`
+}
+
+// GetSteps returns the status of the steps of a cluster job on each host,
+// built from the events starting and ending them. The job is ?job_id= or
+// the latest job of the cluster. Steps a provisioning job has not reached
+// yet are listed as pending.
+func (h *ClusterHandler) GetSteps(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+
+	var job *db.Job
+	if value := r.URL.Query().Get("job_id"); value != "" {
+		jobID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			WriteBadRequest(w, "Invalid job ID")
+			return
+		}
+		job, err = h.store.Jobs.Get(uint(jobID))
+		if err != nil || job.ClusterID != uint(id) {
+			WriteNotFound(w, "Job not found")
+			return
+		}
+	} else {
+		jobs, err := h.store.Jobs.List(db.JobFilter{ClusterID: uint(id), Limit: 1})
+		if err != nil {
+			WriteInternalError(w, "Failed to retrieve jobs")
+			return
+		}
+		if len(jobs) == 0 {
+			WriteNotFound(w, "Cluster has no jobs")
+			return
+		}
+		job = &jobs[0]
+	}
+
+	events, err := h.store.Events.List(db.EventFilter{ClusterID: uint(id), JobID: job.ID, StepChanges: true})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve events")
+		return
+	}
+
+	WriteSuccess(w, JobSteps{
+		JobID:     job.ID,
+		JobType:   job.Type,
+		JobStatus: job.Status,
+		Steps:     jobSteps(job, events, time.Now()),
+	})
+}
+
+// jobSteps replays the step events of a job, oldest first, over the steps
+// planned for it. Steps still running when the job finished are failed.
+func jobSteps(job *db.Job, events []db.Event, now time.Time) []HostStep {
+	steps := plannedSteps(job)
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		index[step.Host+"/"+string(step.Step)] = i
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		key := event.Host + "/" + event.Step
+		n, ok := index[key]
+		if !ok {
+			n = len(steps)
+			index[key] = n
+			step := provision.Step(event.Step)
+			steps = append(steps, HostStep{Host: event.Host, Step: step, Phase: step.Phase()})
+		}
+		step := &steps[n]
+		step.Status = provision.StepStatus(event.StepStatus)
+		step.Message = event.Message
+		timestamp := event.Timestamp
+		if step.Status == provision.StepRunning {
+			step.StartedAt, step.FinishedAt = &timestamp, nil
+		} else {
+			step.FinishedAt = &timestamp
+		}
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		if step.Status == provision.StepRunning && job.FinishedAt != nil {
+			step.Status, step.FinishedAt = provision.StepFailed, job.FinishedAt
+			step.Message = "Job ended before the step finished"
+		}
+		if step.StartedAt == nil {
+			continue
+		}
+		end := now
+		if step.FinishedAt != nil {
+			end = *step.FinishedAt
+		}
+		step.Duration = end.Sub(*step.StartedAt).Seconds()
+	}
+	return steps
+}
+
+// plannedSteps lists the steps a provisioning job runs on each host, as
+// pending. Other jobs only report the steps they reached.
+func plannedSteps(job *db.Job) []HostStep {
+	var req CreateClusterRequest
+	if job.Type != "provision" || json.Unmarshal([]byte(job.Payload), &req) != nil {
+		return []HostStep{}
+	}
+	spec := req.Spec()
+
+	steps := []HostStep{}
+	add := func(host provision.HostSpec, step provision.Step) {
+		steps = append(steps, HostStep{Host: host.Address, Step: step, Phase: step.Phase(), Status: provision.StepPending})
+	}
+	hosts := append(append([]provision.HostSpec{}, spec.ControlPlanes...), spec.Workers...)
+	for _, host := range hosts {
+		add(host, provision.StepPrepare)
+		add(host, provision.StepInstallRuntime)
+		add(host, provision.StepInstallK8s)
+	}
+	for i, host := range spec.ControlPlanes {
+		if i == 0 {
+			add(host, provision.StepBootstrap)
+			add(host, provision.StepInstallCNI)
+		} else {
+			add(host, provision.StepJoinControlPlane)
+		}
+	}
+	for _, host := range spec.Workers {
+		add(host, provision.StepJoinWorker)
+	}
+	if len(spec.ControlPlanes) > 0 && provision.CurrentNetworkCheck().Enabled {
+		add(spec.ControlPlanes[0], provision.StepCheckNetwork)
+	}
+	if len(spec.ControlPlanes) > 0 && provision.VerifyEnabled() {
+		add(spec.ControlPlanes[0], provision.StepVerify)
+	}
+	return steps
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/lock"
+	"kubeforge/internal/validation"
+)
+
+// stuckCheckInterval is how often clusters are checked for a stuck status
+const stuckCheckInterval = time.Minute
+
+// forceStateJobWait bounds waiting for cancelled jobs to stop before a
+// status is forced or a cluster deleted
+const forceStateJobWait = 30 * time.Second
+
+// stuckLockName guards the check so only one replica fails each cluster
+const stuckLockName = "clusters:stuck"
+
+// StuckClusterDetector marks clusters failed that stay pending,
+// provisioning, upgrading or reconciling past a deadline, typically because
+// their job hangs or was lost. The active job of such a cluster is
+// cancelled and the failure is recorded as an event with what the job was
+// doing.
+type StuckClusterDetector struct {
+	store      *db.Store
+	queue      *jobs.Queue
+	instanceID string
+
+	mu      sync.Mutex
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewStuckClusterDetector creates a detector. A timeout of zero disables it.
+func NewStuckClusterDetector(store *db.Store, queue *jobs.Queue, timeout time.Duration, instanceID string) *StuckClusterDetector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StuckClusterDetector{
+		store:      store,
+		queue:      queue,
+		instanceID: instanceID,
+		timeout:    timeout,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start checks clusters in the background every minute
+func (d *StuckClusterDetector) Start() {
+	d.wg.Add(1)
+	go d.run()
+}
+
+// Stop waits for a running check to finish
+func (d *StuckClusterDetector) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// SetTimeout replaces the deadline, taking effect from the next check
+func (d *StuckClusterDetector) SetTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	d.timeout = timeout
+	d.mu.Unlock()
+}
+
+// Timeout returns the current deadline
+func (d *StuckClusterDetector) Timeout() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.timeout
+}
+
+func (d *StuckClusterDetector) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(stuckCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if d.Timeout() <= 0 {
+			continue
+		}
+
+		acquired, err := lock.Acquire(d.store.DB(), stuckLockName, d.instanceID, stuckCheckInterval)
+		if err != nil {
+			slog.Error("Failed to acquire stuck cluster lock", "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		if _, err := d.Detect(); err != nil {
+			slog.Error("Failed to check for stuck clusters", "error", err)
+		}
+	}
+}
+
+// Detect fails the clusters stuck past the deadline and returns how many
+func (d *StuckClusterDetector) Detect() (int, error) {
+	timeout := d.Timeout()
+	cutoff := time.Now().Add(-timeout)
+
+	var clusters []db.Cluster
+	err := d.store.DB().Select("id", "status").
+		Where("status IN ? AND COALESCE(status_changed_at, updated_at) < ?", db.ClusterInProgress, cutoff).
+		Find(&clusters).Error
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, cluster := range clusters {
+		var job db.Job
+		diagnosis := "no job is pending or running for the cluster"
+		err := d.store.DB().Where("cluster_id = ? AND status IN ?", cluster.ID, []string{jobs.StatusPending, jobs.StatusRunning}).
+			Order("id").First(&job).Error
+		if err == nil {
+			diagnosis = jobDiagnosis(job)
+		}
+
+		message := fmt.Sprintf("Cluster %s for more than %s: %s", cluster.Status, timeout, diagnosis)
+		ok, err := db.FailStaleCluster(d.store.DB(), cluster.ID, cluster.Status, cutoff, message)
+		if err != nil {
+			return failed, err
+		}
+		if !ok {
+			// The job finished in the meantime
+			continue
+		}
+		failed++
+
+		if job.ID != 0 {
+			if err := d.queue.Cancel(job.ID); err != nil && !errors.Is(err, jobs.ErrJobFinished) {
+				slog.Warn("Failed to cancel job of stuck cluster", "cluster_id", cluster.ID, "job_id", job.ID, "error", err)
+			}
+		}
+		recordEvent(cluster.ID, "error", "localhost", "stuck", message)
+		slog.Warn("Cluster marked failed after being stuck", "cluster_id", cluster.ID, "status", cluster.Status, "job_id", job.ID)
+	}
+	return failed, nil
+}
+
+// jobDiagnosis describes what an active job was doing
+func jobDiagnosis(job db.Job) string {
+	parts := []string{fmt.Sprintf("job %d (%s) is %s", job.ID, job.Type, job.Status)}
+	if job.Phase != "" {
+		parts = append(parts, fmt.Sprintf("in phase %s at %d%%", job.Phase, job.Progress))
+	}
+	if job.WorkerID != "" {
+		parts = append(parts, "on "+job.WorkerID)
+	}
+	if job.HeartbeatAt != nil {
+		parts = append(parts, "last heartbeat "+job.HeartbeatAt.UTC().Format(time.RFC3339))
+	} else if job.Status == jobs.StatusPending {
+		parts = append(parts, "waiting for a worker since "+job.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	return strings.Join(parts, ", ") + "; the job was cancelled"
+}
+
+// ForceStateRequest sets the status of a cluster
+type ForceStateRequest struct {
+	Status  db.ClusterStatus `json:"status"`
+	Message string           `json:"message,omitempty"` // status message, e.g. why the status was forced
+}
+
+// ForceState sets the status of a cluster regardless of the allowed
+// transitions, to recover a cluster left in a status by a lost job without
+// editing the database. Pending and running jobs of the cluster are
+// cancelled first, so they cannot change the status afterwards.
+func (h *ClusterHandler) ForceState(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var req ForceStateRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if !req.Status.Valid() || req.Status == db.ClusterDeleted {
+		var errs validation.Errors
+		errs.Add("status", validation.CodeUnsupported, fmt.Sprintf("unsupported cluster status %q", req.Status))
+		WriteValidationError(w, errs)
+		return
+	}
+
+	var cluster db.Cluster
+	if err := h.store.DB().First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	// A cancelled job may still record its failure on the way out
+	active, stopped, err := h.stopClusterJobs(r.Context(), cluster.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to cancel cluster jobs", "cluster_id", cluster.ID, "error", err)
+		WriteInternalError(w, "Failed to cancel the jobs of the cluster")
+		return
+	}
+	if !stopped {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Cancelled jobs of the cluster are still stopping, try again")
+		return
+	}
+
+	if err := h.store.Clusters.ForceStatus(cluster.ID, req.Status, req.Message); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			WriteNotFound(w, "Cluster not found")
+			return
+		}
+		WriteInternalError(w, "Failed to update cluster status")
+		return
+	}
+	h.logEvent(cluster.ID, "warn", "localhost", "force-state",
+		fmt.Sprintf("Status forced from %s to %s, %d jobs cancelled", cluster.Status, req.Status, len(active)))
+
+	h.store.DB().First(&cluster, cluster.ID)
+	WriteSuccess(w, cluster)
+}
+
+// stopClusterJobs cancels the pending and running jobs of a cluster and
+// waits until they have stopped and released the cluster lock. It returns
+// the cancelled jobs and false if they are still stopping after
+// forceStateJobWait.
+func (h *ClusterHandler) stopClusterJobs(ctx context.Context, clusterID uint) ([]uint, bool, error) {
+	var active []uint
+	h.store.DB().Model(&db.Job{}).Where("cluster_id = ? AND status IN ?", clusterID, []string{jobs.StatusPending, jobs.StatusRunning}).Pluck("id", &active)
+	for _, jobID := range active {
+		if err := h.queue.Cancel(jobID); err != nil && !errors.Is(err, jobs.ErrJobFinished) {
+			return active, false, fmt.Errorf("failed to cancel job %d: %w", jobID, err)
+		}
+	}
+	return active, waitJobsStopped(h.store.DB(), ctx, clusterID, active, forceStateJobWait), nil
+}
+
+// waitJobsStopped waits until none of the jobs is pending or running and the
+// cluster lock is free, reporting false if they are still active after
+// timeout. A job cancelled on another replica is only marked cancelled; it
+// stops, releasing the lock, on the next heartbeat there.
+func waitJobsStopped(conn *gorm.DB, ctx context.Context, clusterID uint, ids []uint, timeout time.Duration) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		var active, locked int64
+		conn.Model(&db.Job{}).Where("id IN ? AND status IN ?", ids, []string{jobs.StatusPending, jobs.StatusRunning}).Count(&active)
+		conn.Model(&db.Lock{}).Where("name = ? AND expires_at > ?", lock.ClusterLock(clusterID), time.Now()).Count(&locked)
+		if active == 0 && locked == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
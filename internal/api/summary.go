@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/db"
+)
+
+// Number of records the summary and the overview of a cluster return
+const (
+	summaryJobs    = 10
+	overviewEvents = 20
+)
+
+// healthyNodeStatuses are the node statuses the summary does not list as
+// failing: ready nodes and nodes being worked on by a job
+var healthyNodeStatuses = map[string]bool{
+	"ready":        true,
+	"provisioning": true,
+	"upgrading":    true,
+	"maintenance":  true,
+	"removing":     true,
+}
+
+// Summary is the state of the clusters the caller can see at a glance
+type Summary struct {
+	Clusters     int                      `json:"clusters"`
+	ByStatus     map[db.ClusterStatus]int `json:"by_status"`
+	RecentJobs   []db.Job                 `json:"recent_jobs"`
+	FailingNodes []FailingNode            `json:"failing_nodes"`
+}
+
+// FailingNode is a node that is not ready, with the name of its cluster
+type FailingNode struct {
+	db.Node
+	ClusterName string `json:"cluster_name"`
+}
+
+// ClusterOverview is a cluster with its nodes, its last job and its latest
+// events
+type ClusterOverview struct {
+	Cluster ClusterDetail `json:"cluster"`
+	Nodes   []db.Node     `json:"nodes"`
+	LastJob *db.Job       `json:"last_job"`
+	Events  []db.Event    `json:"events"`
+}
+
+// GetSummary counts the clusters of the caller's projects by status and
+// lists their latest jobs and the nodes that are not ready
+func (h *ClusterHandler) GetSummary(w http.ResponseWriter, r *http.Request) {
+	projects := projectFilter(h.store.DB(), r)
+	clusters, err := h.store.Clusters.List(db.ClusterFilter{ProjectIDs: projects, WithNodes: true})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve clusters")
+		return
+	}
+	jobs, err := h.store.Jobs.List(db.JobFilter{ProjectIDs: projects, Limit: summaryJobs})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve jobs")
+		return
+	}
+
+	summary := Summary{
+		Clusters:     len(clusters),
+		ByStatus:     make(map[db.ClusterStatus]int),
+		RecentJobs:   jobs,
+		FailingNodes: []FailingNode{},
+	}
+	for _, cluster := range clusters {
+		summary.ByStatus[cluster.Status]++
+		for _, node := range cluster.Nodes {
+			if !healthyNodeStatuses[node.Status] {
+				summary.FailingNodes = append(summary.FailingNodes, FailingNode{Node: node, ClusterName: cluster.Name})
+			}
+		}
+	}
+
+	WriteSuccess(w, summary)
+}
+
+// GetClusterOverview returns a cluster with its nodes, last job and latest
+// events in one call
+func (h *ClusterHandler) GetClusterOverview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, err := h.store.Clusters.Get(uint(id))
+	if err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	nodes, err := h.store.Nodes.List(cluster.ID)
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve nodes")
+		return
+	}
+	jobs, err := h.store.Jobs.List(db.JobFilter{ClusterID: cluster.ID, Limit: 1})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve jobs")
+		return
+	}
+	events, err := h.store.Events.List(db.EventFilter{ClusterID: cluster.ID, Limit: overviewEvents})
+	if err != nil {
+		WriteInternalError(w, "Failed to retrieve events")
+		return
+	}
+
+	overview := ClusterOverview{
+		Cluster: ClusterDetail{Cluster: *cluster, Resources: latestResources(h.store.DB(), cluster.ID)},
+		Nodes:   nodes,
+		Events:  events,
+	}
+	if len(jobs) > 0 {
+		overview.LastJob = &jobs[0]
+	}
+	WriteSuccess(w, overview)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/addons"
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/validation"
+)
+
+// TemplateSpec is the reusable part of a cluster specification. Clusters
+// created from a template take the fields they do not set themselves.
+type TemplateSpec struct {
+	K8sVersion       string                  `json:"k8s_version,omitempty"`
+	PodNetworkCIDR   string                  `json:"pod_network_cidr,omitempty"`
+	ServiceCIDR      string                  `json:"service_cidr,omitempty"`
+	CNI              string                  `json:"cni,omitempty"`
+	ContainerRuntime string                  `json:"container_runtime,omitempty"`
+	KubeadmConfig    string                  `json:"kubeadm_config,omitempty"`
+	HardeningProfile string                  `json:"hardening_profile,omitempty"`
+	Hooks            []provision.HookSpec    `json:"hooks,omitempty"`
+	Addons           []provision.AddonSpec   `json:"addons,omitempty"`
+	Timeouts         *provision.StepTimeouts `json:"timeouts,omitempty"`
+	Notifications    []string                `json:"notifications,omitempty"`
+}
+
+// applyTo fills the fields a cluster request leaves empty
+func (s *TemplateSpec) applyTo(req *CreateClusterRequest) {
+	if req.K8sVersion == "" {
+		req.K8sVersion = s.K8sVersion
+	}
+	if req.PodNetworkCIDR == "" {
+		req.PodNetworkCIDR = s.PodNetworkCIDR
+	}
+	if req.ServiceCIDR == "" {
+		req.ServiceCIDR = s.ServiceCIDR
+	}
+	if req.CNI == "" {
+		req.CNI = s.CNI
+	}
+	if req.ContainerRuntime == "" {
+		req.ContainerRuntime = s.ContainerRuntime
+	}
+	if req.KubeadmConfig == "" {
+		req.KubeadmConfig = s.KubeadmConfig
+	}
+	if req.HardeningProfile == "" {
+		req.HardeningProfile = s.HardeningProfile
+	}
+	if len(req.Hooks) == 0 {
+		req.Hooks = s.Hooks
+	}
+	if len(req.Addons) == 0 {
+		req.Addons = s.Addons
+	}
+	if req.Timeouts == nil {
+		req.Timeouts = s.Timeouts
+	}
+	if len(req.Notifications) == 0 {
+		req.Notifications = s.Notifications
+	}
+}
+
+// Validate checks the spec like the request of a cluster created from it
+func (s *TemplateSpec) Validate(conn *gorm.DB) validation.Errors {
+	req := CreateClusterRequest{
+		Name:          "template",
+		ControlPlanes: []provision.HostSpec{{Address: "127.0.0.1", SSHAgent: true}},
+	}
+	s.applyTo(&req)
+	errs := req.Validate(conn)
+	for i := range errs {
+		errs[i].Field = validation.Path("spec", errs[i].Field)
+	}
+	return errs
+}
+
+// TemplateRequest represents the request to create or replace a template
+type TemplateRequest struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Spec        TemplateSpec `json:"spec"`
+}
+
+// TemplateResponse is a template with its spec. Addon credentials are not
+// returned.
+type TemplateResponse struct {
+	db.ClusterTemplate
+	Spec TemplateSpec `json:"spec"`
+}
+
+// TemplateHandler handles cluster template API requests
+type TemplateHandler struct {
+	store *db.Store
+}
+
+// NewTemplateHandler creates a new template handler
+func NewTemplateHandler(store *db.Store) *TemplateHandler {
+	return &TemplateHandler{store: store}
+}
+
+// RegisterRoutes registers template API routes
+func (h *TemplateHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/templates", h.ListTemplates).Methods("GET")
+	router.HandleFunc("/api/v1/templates", h.CreateTemplate).Methods("POST")
+	router.HandleFunc("/api/v1/templates/{id}", h.GetTemplate).Methods("GET")
+	router.HandleFunc("/api/v1/templates/{id}", h.UpdateTemplate).Methods("PUT")
+	router.HandleFunc("/api/v1/templates/{id}", h.DeleteTemplate).Methods("DELETE")
+}
+
+// ListTemplates lists all templates
+func (h *TemplateHandler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	var templates []db.ClusterTemplate
+	if err := h.store.DB().Order("name").Find(&templates).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve templates")
+		return
+	}
+
+	responses := make([]TemplateResponse, 0, len(templates))
+	for _, template := range templates {
+		response, err := templateResponse(template)
+		if err != nil {
+			WriteInternalError(w, "Failed to read template "+template.Name)
+			return
+		}
+		responses = append(responses, response)
+	}
+
+	WriteSuccess(w, responses)
+}
+
+// GetTemplate retrieves a single template by ID
+func (h *TemplateHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := templateResponse(*template)
+	if err != nil {
+		WriteInternalError(w, "Failed to read template")
+		return
+	}
+	WriteSuccess(w, response)
+}
+
+// CreateTemplate creates a new template
+func (h *TemplateHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req TemplateRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(h.store.DB()); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	spec, _ := json.Marshal(req.Spec)
+	template := db.ClusterTemplate{
+		Name:        req.Name,
+		Description: req.Description,
+		Spec:        string(spec),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := h.store.DB().Create(&template).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Template name already in use")
+		return
+	}
+
+	WriteCreated(w, TemplateResponse{ClusterTemplate: template, Spec: redactTemplateSpec(req.Spec)})
+}
+
+// UpdateTemplate replaces the name, description and spec of a template.
+// Clusters created from it keep the spec they were created with.
+func (h *TemplateHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	var req TemplateRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if errs := req.Validate(h.store.DB()); len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+
+	spec, _ := json.Marshal(req.Spec)
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Spec = string(spec)
+	if err := h.store.DB().Save(template).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Template name already in use")
+		return
+	}
+
+	WriteSuccess(w, TemplateResponse{ClusterTemplate: *template, Spec: redactTemplateSpec(req.Spec)})
+}
+
+// DeleteTemplate deletes a template. Clusters created from it are not
+// affected.
+func (h *TemplateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DB().Delete(template).Error; err != nil {
+		WriteInternalError(w, "Failed to delete template")
+		return
+	}
+
+	WriteSuccess(w, map[string]string{"message": "Template deleted"})
+}
+
+// Validate checks the request and returns every invalid field
+func (req *TemplateRequest) Validate(conn *gorm.DB) validation.Errors {
+	var errs validation.Errors
+	if req.Name == "" {
+		errs.Add("name", validation.CodeRequired, "template name is required")
+	}
+	return append(errs, req.Spec.Validate(conn)...)
+}
+
+// loadTemplate resolves the template from the request path
+func (h *TemplateHandler) loadTemplate(w http.ResponseWriter, r *http.Request) (*db.ClusterTemplate, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid template ID")
+		return nil, false
+	}
+
+	var template db.ClusterTemplate
+	if err := h.store.DB().First(&template, id).Error; err != nil {
+		WriteNotFound(w, "Template not found")
+		return nil, false
+	}
+	return &template, true
+}
+
+// loadTemplateSpec returns the spec of a stored template
+func loadTemplateSpec(conn *gorm.DB, id uint) (*TemplateSpec, error) {
+	var template db.ClusterTemplate
+	if err := conn.First(&template, id).Error; err != nil {
+		return nil, err
+	}
+	var spec TemplateSpec
+	if err := json.Unmarshal([]byte(template.Spec), &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// templateResponse decodes the spec of a template for a response
+func templateResponse(template db.ClusterTemplate) (TemplateResponse, error) {
+	var spec TemplateSpec
+	if err := json.Unmarshal([]byte(template.Spec), &spec); err != nil {
+		return TemplateResponse{}, err
+	}
+	return TemplateResponse{ClusterTemplate: template, Spec: redactTemplateSpec(spec)}, nil
+}
+
+// redactTemplateSpec strips the credentials from the addon configs of a spec
+func redactTemplateSpec(spec TemplateSpec) TemplateSpec {
+	redacted := make([]provision.AddonSpec, len(spec.Addons))
+	for i, addon := range spec.Addons {
+		redacted[i] = addon
+		if public, _, err := addons.SplitCredentials(addon.Config); err == nil {
+			redacted[i].Config = public
+		}
+	}
+	spec.Addons = redacted
+	return spec
+}
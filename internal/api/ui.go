@@ -0,0 +1,99 @@
+package api
+
+import (
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/auth"
+	"kubeforge/web"
+)
+
+// uiCookie carries the access token the pages of the UI are served with.
+// The UI itself calls the API with the token in the Authorization header,
+// the cookie is only sent to /ui.
+const uiCookie = "kubeforge_ui"
+
+// UIHandler serves the single-page UI to signed in users
+type UIHandler struct {
+	tokens *auth.TokenManager
+	files  fs.FS // built UI, nil when the server was built without it
+}
+
+// NewUIHandler creates a new UI handler serving the built UI in files
+func NewUIHandler(tokens *auth.TokenManager, files fs.FS) *UIHandler {
+	return &UIHandler{tokens: tokens, files: files}
+}
+
+// RegisterRoutes registers the UI routes. They match every path under /ui/,
+// so they must be registered after the other routes.
+func (h *UIHandler) RegisterRoutes(router *mux.Router) {
+	router.Handle("/", http.RedirectHandler("/ui/", http.StatusFound))
+	router.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	router.HandleFunc("/ui/login", h.Login).Methods("GET")
+	router.PathPrefix("/ui/").HandlerFunc(h.ServeUI).Methods("GET", "HEAD")
+}
+
+// Login serves the sign in page
+func (h *UIHandler) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(web.LoginPage)
+}
+
+// ServeUI serves a file of the UI, or its index page for the routes of the
+// UI, redirecting to the sign in page without a valid session cookie
+func (h *UIHandler) ServeUI(w http.ResponseWriter, r *http.Request) {
+	if h.files == nil {
+		WriteNotFound(w, "The server was built without the web UI")
+		return
+	}
+	cookie, err := r.Cookie(uiCookie)
+	if err == nil {
+		_, err = h.tokens.Verify(cookie.Value)
+	}
+	if err != nil {
+		http.Redirect(w, r, "/ui/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/ui")
+	name = strings.TrimPrefix(name, "/")
+	if info, err := fs.Stat(h.files, name); name == "" || err != nil || info.IsDir() {
+		// Routes of the UI are resolved by the UI, missing assets are not
+		if strings.HasPrefix(name, "assets/") {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+	}
+	if strings.HasPrefix(name, "assets/") {
+		// Built assets are named after their content
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeFileFS(w, r, h.files, name)
+}
+
+// setUICookie lets the browser load the UI for as long as the access token
+// of a pair is valid
+func setUICookie(w http.ResponseWriter, r *http.Request, pair *auth.TokenPair) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     uiCookie,
+		Value:    pair.AccessToken,
+		Path:     "/ui",
+		MaxAge:   pair.ExpiresIn,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearUICookie signs the browser out of the UI
+func clearUICookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: uiCookie, Path: "/ui", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/auth"
+	"kubeforge/internal/db"
+)
+
+// UserRequest represents the request to create or update a user
+type UserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UserHandler handles user management API requests
+type UserHandler struct {
+	store *db.Store
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(store *db.Store) *UserHandler {
+	return &UserHandler{store: store}
+}
+
+// RegisterRoutes registers user API routes
+func (h *UserHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/users", h.ListUsers).Methods("GET")
+	router.HandleFunc("/api/v1/users", h.CreateUser).Methods("POST")
+	router.HandleFunc("/api/v1/users/{id}", h.GetUser).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}", h.UpdateUser).Methods("PUT")
+	router.HandleFunc("/api/v1/users/{id}", h.DeleteUser).Methods("DELETE")
+}
+
+// ListUsers lists all users
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	var users []db.User
+	if err := h.store.DB().Order("username").Find(&users).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve users")
+		return
+	}
+
+	WriteSuccess(w, users)
+}
+
+// GetUser retrieves a single user by ID
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	WriteSuccess(w, user)
+}
+
+// CreateUser creates a new user
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		WriteBadRequest(w, "Username and password are required")
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+	if !auth.ValidRole(req.Role) {
+		WriteBadRequest(w, "Role must be one of admin, operator, viewer")
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		WriteInternalError(w, "Failed to hash password")
+		return
+	}
+
+	user := db.User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hash,
+		Role:         req.Role,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := h.store.DB().Create(&user).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Username or email already in use")
+		return
+	}
+
+	WriteCreated(w, user)
+}
+
+// UpdateUser changes the email, password or role of a user
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req UserRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteBadRequest(w, "Invalid request body")
+		return
+	}
+
+	if req.Email != "" {
+		user.Email = req.Email
+	}
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			WriteInternalError(w, "Failed to hash password")
+			return
+		}
+		user.PasswordHash = hash
+	}
+	if req.Role != "" {
+		if !auth.ValidRole(req.Role) {
+			WriteBadRequest(w, "Role must be one of admin, operator, viewer")
+			return
+		}
+		if user.ID == CurrentUser(r).UserID() && req.Role != auth.RoleAdmin {
+			WriteBadRequest(w, "You cannot remove your own admin role")
+			return
+		}
+		user.Role = req.Role
+	}
+
+	if err := h.store.DB().Save(user).Error; err != nil {
+		WriteError(w, http.StatusConflict, "CONFLICT", "Email already in use")
+		return
+	}
+
+	WriteSuccess(w, user)
+}
+
+// DeleteUser deletes a user and revokes their refresh tokens
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == CurrentUser(r).UserID() {
+		WriteBadRequest(w, "You cannot delete yourself")
+		return
+	}
+
+	if err := h.store.DB().Delete(user).Error; err != nil {
+		WriteInternalError(w, "Failed to delete user")
+		return
+	}
+	now := time.Now()
+	h.store.DB().Model(&db.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", &now)
+
+	WriteSuccess(w, map[string]string{"message": "User deleted"})
+}
+
+// loadUser resolves the user from the request path
+func (h *UserHandler) loadUser(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid user ID")
+		return nil, false
+	}
+
+	var user db.User
+	if err := h.store.DB().First(&user, id).Error; err != nil {
+		WriteNotFound(w, "User not found")
+		return nil, false
+	}
+	return &user, true
+}
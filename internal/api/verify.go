@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"kubeforge/internal/db"
+	"kubeforge/internal/provision"
+)
+
+// verifyTimeout bounds the smoke tests of a provisioned cluster
+const verifyTimeout = provision.Duration(10 * time.Minute)
+
+// verifyCluster smoke tests a provisioned cluster and records the results
+// as its verification. It fails when a test failed, so a ready cluster is
+// known to run workloads.
+func (h *ClusterHandler) verifyCluster(ctx context.Context, clusterID uint, provisioner provision.IProvisioner, controlPlane provision.HostSpec) error {
+	var checks []provision.SmokeCheck
+	err := provision.RunStep(ctx, "verify", verifyTimeout, func(ctx context.Context) error {
+		var err error
+		checks, err = provisioner.VerifyCluster(ctx, controlPlane)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	verification := &db.Verification{Passed: true, Checks: []db.VerificationCheck{}, VerifiedAt: time.Now()}
+	var failed []string
+	for _, check := range checks {
+		verification.Checks = append(verification.Checks, db.VerificationCheck{Name: check.Name, Passed: check.Passed, Message: check.Message})
+		if !check.Passed {
+			verification.Passed = false
+			failed = append(failed, check.Name+": "+check.Message)
+		}
+	}
+	if err := h.store.Clusters.Update(&db.Cluster{ID: clusterID, Verification: verification}, "verification"); err != nil {
+		return fmt.Errorf("failed to save verification: %w", err)
+	}
+	if !verification.Passed {
+		return fmt.Errorf("%d of %d smoke tests failed: %s", len(failed), len(checks), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"kubeforge/internal/db"
+)
+
+// APIVersion is the current version of the REST API
+const APIVersion = "v1"
+
+// versionedPath matches paths that already name an API version
+var versionedPath = regexp.MustCompile(`^/api/v[0-9]+(/|$)`)
+
+// jobLocation returns the path of a job resource
+func jobLocation(id uint) string {
+	return fmt.Sprintf("/api/%s/jobs/%d", APIVersion, id)
+}
+
+// addonLocation returns the path of an addon of a cluster
+func addonLocation(clusterID uint, name string) string {
+	return fmt.Sprintf("/api/%s/clusters/%d/addons/%s", APIVersion, clusterID, url.PathEscape(name))
+}
+
+// releaseLocation returns the path of a Helm release of a cluster
+func releaseLocation(release *db.Release) string {
+	return fmt.Sprintf("/api/%s/clusters/%d/releases/%s?namespace=%s",
+		APIVersion, release.ClusterID, url.PathEscape(release.Name), url.QueryEscape(release.Namespace))
+}
+
+// LegacyPaths serves the unversioned /api/... paths used before versioning
+// by rewriting them to /api/v1/... Responses to legacy paths carry a
+// Deprecation header and a Link to the versioned successor. It must wrap the
+// router since routes are matched before router middleware runs.
+func LegacyPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if !strings.HasPrefix(path, "/api/") || versionedPath.MatchString(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		successor := "/api/" + APIVersion + strings.TrimPrefix(path, "/api")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = successor
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
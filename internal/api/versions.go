@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"kubeforge/internal/provision"
+)
+
+// versionFeedTimeout bounds one refresh of the release catalog
+const versionFeedTimeout = time.Minute
+
+// VersionCatalog lists the Kubernetes releases clusters can run
+type VersionCatalog struct {
+	Default  string                  `json:"default"`
+	Releases []provision.ReleaseInfo `json:"releases"`
+}
+
+// VersionHandler handles the Kubernetes version catalog
+type VersionHandler struct{}
+
+// NewVersionHandler creates a new version handler
+func NewVersionHandler() *VersionHandler {
+	return &VersionHandler{}
+}
+
+// RegisterRoutes registers version API routes
+func (h *VersionHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/versions", h.ListVersions).Methods("GET")
+}
+
+// ListVersions lists the validated Kubernetes releases, newest first, with
+// their end of life, compatible CNI plugins and whether new clusters may
+// use them
+func (h *VersionHandler) ListVersions(w http.ResponseWriter, r *http.Request) {
+	WriteSuccess(w, VersionCatalog{
+		Default:  provision.DefaultVersion(),
+		Releases: provision.Releases(time.Now()),
+	})
+}
+
+// VersionRefresher keeps the latest patch of each release current by reading
+// the upstream release feed
+type VersionRefresher struct {
+	feed     string
+	interval time.Duration
+	client   *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewVersionRefresher creates a refresher. An interval of zero disables it.
+func NewVersionRefresher(feed string, interval time.Duration) *VersionRefresher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &VersionRefresher{
+		feed:     feed,
+		interval: interval,
+		client:   &http.Client{Timeout: versionFeedTimeout},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start refreshes the catalog now and then every interval in the background
+func (v *VersionRefresher) Start() {
+	if v.interval <= 0 || v.feed == "" {
+		return
+	}
+	v.wg.Add(1)
+	go v.run()
+}
+
+// Stop waits for a running refresh to finish
+func (v *VersionRefresher) Stop() {
+	v.cancel()
+	v.wg.Wait()
+}
+
+func (v *VersionRefresher) run() {
+	defer v.wg.Done()
+
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(v.ctx, versionFeedTimeout)
+		if err := provision.RefreshReleases(ctx, v.client, v.feed); err != nil && v.ctx.Err() == nil {
+			slog.Warn("Failed to refresh Kubernetes releases", "feed", v.feed, "error", err)
+		}
+		cancel()
+
+		select {
+		case <-v.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
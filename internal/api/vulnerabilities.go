@@ -0,0 +1,476 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/jobs"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/provision"
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// vulnerabilityScanTimeout bounds scanning a single node or image
+const vulnerabilityScanTimeout = 15 * time.Minute
+
+// maxScannedImages bounds the images one scan pulls and scans
+const maxScannedImages = 200
+
+// Targets of vulnerability findings
+const (
+	targetNode  = "node"
+	targetImage = "image"
+)
+
+// VulnerabilityScanRequest starts a Trivy scan of a cluster
+type VulnerabilityScanRequest struct {
+	Images  bool   `json:"images,omitempty"`   // also scan the images of running pods
+	NodeIDs []uint `json:"node_ids,omitempty"` // every node when empty
+}
+
+// vulnerabilityScanPayload is the input of a vulnerability scan job
+type vulnerabilityScanPayload struct {
+	ScanID  uint   `json:"scan_id"`
+	NodeIDs []uint `json:"node_ids"`
+}
+
+// TargetSeverities counts the vulnerabilities of a node or image by severity
+type TargetSeverities struct {
+	TargetType string `json:"target_type"`
+	Target     string `json:"target"`
+	Critical   int    `json:"critical"`
+	High       int    `json:"high"`
+	Medium     int    `json:"medium"`
+	Low        int    `json:"low"`
+	Unknown    int    `json:"unknown"`
+}
+
+// SecuritySummary is the security posture of a cluster: its latest
+// completed CIS and vulnerability scans
+type SecuritySummary struct {
+	CIS             *db.Scan              `json:"cis"`
+	Vulnerabilities *db.VulnerabilityScan `json:"vulnerabilities"`
+	Targets         []TargetSeverities    `json:"targets"` // of the vulnerability scan, most critical first
+}
+
+// CreateVulnerabilityScan starts a job scanning the operating system
+// packages of the nodes of a cluster with Trivy over SSH and, with images,
+// the images of its running pods with Trivy on a control plane
+func (h *ClusterHandler) CreateVulnerabilityScan(w http.ResponseWriter, r *http.Request) {
+	var req VulnerabilityScanRequest
+	if r.ContentLength != 0 {
+		if err := ParseJSON(r, &req); err != nil {
+			WriteBadRequest(w, "Invalid request body")
+			return
+		}
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	cluster, ok := readyCluster(h.store.DB(), w, uint(id))
+	if !ok {
+		return
+	}
+
+	var nodes []db.Node
+	query := h.store.DB().Where("cluster_id = ? AND address <> ''", cluster.ID)
+	if len(req.NodeIDs) > 0 {
+		query = query.Where("id IN ?", req.NodeIDs)
+	}
+	query.Order("id").Find(&nodes)
+	var errs validation.Errors
+	for i, nodeID := range req.NodeIDs {
+		found := false
+		for _, node := range nodes {
+			found = found || node.ID == nodeID
+		}
+		if !found {
+			errs.Add(validation.Index("node_ids", i), validation.CodeInvalid, fmt.Sprintf("node %d not found in the cluster", nodeID))
+		}
+	}
+	if len(errs) > 0 {
+		WriteValidationError(w, errs)
+		return
+	}
+	if len(nodes) == 0 {
+		WriteError(w, http.StatusConflict, "CONFLICT", "The cluster has no nodes")
+		return
+	}
+
+	scan := db.VulnerabilityScan{ClusterID: cluster.ID, Images: req.Images, Status: scanPending, CreatedAt: time.Now()}
+	if err := h.store.DB().Create(&scan).Error; err != nil {
+		WriteInternalError(w, "Failed to create scan")
+		return
+	}
+	payload := vulnerabilityScanPayload{ScanID: scan.ID}
+	for _, node := range nodes {
+		payload.NodeIDs = append(payload.NodeIDs, node.ID)
+	}
+	data, _ := json.Marshal(payload)
+	job := db.Job{
+		ClusterID:   cluster.ID,
+		Type:        "vulnerability-scan",
+		Payload:     string(data),
+		RequestID:   logging.RequestID(r.Context()),
+		TraceParent: tracing.Inject(r.Context()),
+	}
+	if err := h.queue.Enqueue(&job); err != nil {
+		h.store.DB().Delete(&scan)
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			WriteError(w, http.StatusConflict, "CONFLICT", "A vulnerability scan of the cluster is already in progress")
+			return
+		}
+		WriteInternalError(w, "Failed to queue scan job")
+		return
+	}
+	scan.JobID = job.ID
+	h.store.DB().Model(&scan).Update("job_id", job.ID)
+
+	WriteAccepted(w, jobLocation(job.ID), scan)
+}
+
+// ListVulnerabilityScans lists the vulnerability scans of a cluster, newest
+// first, without their findings
+func (h *ClusterHandler) ListVulnerabilityScans(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var scans []db.VulnerabilityScan
+	if err := h.store.DB().Where("cluster_id = ?", id).Order("id desc").Find(&scans).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve scans")
+		return
+	}
+	WriteSuccess(w, scans)
+}
+
+// GetVulnerabilityScan returns a vulnerability scan with its findings, most
+// severe first, of one severity with ?severity= and of one node or image
+// with ?target=
+func (h *ClusterHandler) GetVulnerabilityScan(w http.ResponseWriter, r *http.Request) {
+	clusterID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	scanID, err := strconv.ParseUint(mux.Vars(r)["scanId"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid scan ID")
+		return
+	}
+	var scan db.VulnerabilityScan
+	if err := h.store.DB().Where("cluster_id = ?", clusterID).First(&scan, scanID).Error; err != nil {
+		WriteNotFound(w, "Scan not found")
+		return
+	}
+
+	query := h.store.DB().Where("scan_id = ?", scan.ID)
+	if severity := r.URL.Query().Get("severity"); severity != "" {
+		query = query.Where("severity = ?", strings.ToUpper(severity))
+	}
+	if target := r.URL.Query().Get("target"); target != "" {
+		query = query.Where("target = ?", target)
+	}
+	if err := query.Order("target_type, target, vulnerability_id").Find(&scan.Findings).Error; err != nil {
+		WriteInternalError(w, "Failed to retrieve findings")
+		return
+	}
+	sort.SliceStable(scan.Findings, func(i, j int) bool {
+		return severityRank(scan.Findings[i].Severity) < severityRank(scan.Findings[j].Severity)
+	})
+	WriteSuccess(w, scan)
+}
+
+// GetSecurity summarizes the latest completed CIS and vulnerability scans
+// of a cluster, with vulnerability counts per node and image
+func (h *ClusterHandler) GetSecurity(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	var cluster db.Cluster
+	if err := h.store.DB().Select("id").First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+
+	summary := SecuritySummary{Targets: []TargetSeverities{}}
+	var cis db.Scan
+	if h.store.DB().Where("cluster_id = ? AND status = ?", cluster.ID, scanCompleted).Order("id desc").First(&cis).Error == nil {
+		summary.CIS = &cis
+	}
+	var scan db.VulnerabilityScan
+	if h.store.DB().Where("cluster_id = ? AND status = ?", cluster.ID, scanCompleted).Order("id desc").First(&scan).Error == nil {
+		summary.Vulnerabilities = &scan
+
+		var counts []struct {
+			TargetType string
+			Target     string
+			Severity   string
+			Count      int
+		}
+		h.store.DB().Model(&db.Vulnerability{}).Select("target_type, target, severity, count(*) as count").
+			Where("scan_id = ?", scan.ID).Group("target_type, target, severity").Scan(&counts)
+		byTarget := map[string]*TargetSeverities{}
+		for _, c := range counts {
+			key := c.TargetType + "/" + c.Target
+			target, ok := byTarget[key]
+			if !ok {
+				target = &TargetSeverities{TargetType: c.TargetType, Target: c.Target}
+				byTarget[key] = target
+			}
+			countSeverity(&target.Critical, &target.High, &target.Medium, &target.Low, &target.Unknown, c.Severity, c.Count)
+		}
+		for _, target := range byTarget {
+			summary.Targets = append(summary.Targets, *target)
+		}
+		sort.Slice(summary.Targets, func(i, j int) bool {
+			a, b := summary.Targets[i], summary.Targets[j]
+			if a.Critical != b.Critical {
+				return a.Critical > b.Critical
+			}
+			if a.High != b.High {
+				return a.High > b.High
+			}
+			return a.TargetType+a.Target < b.TargetType+b.Target
+		})
+	}
+	WriteSuccess(w, summary)
+}
+
+// severityRank orders severities from the most severe
+func severityRank(severity string) int {
+	switch severity {
+	case "CRITICAL":
+		return 0
+	case "HIGH":
+		return 1
+	case "MEDIUM":
+		return 2
+	case "LOW":
+		return 3
+	}
+	return 4
+}
+
+// countSeverity adds n to the counter of a severity
+func countSeverity(critical, high, medium, low, unknown *int, severity string, n int) {
+	switch severity {
+	case "CRITICAL":
+		*critical += n
+	case "HIGH":
+		*high += n
+	case "MEDIUM":
+		*medium += n
+	case "LOW":
+		*low += n
+	default:
+		*unknown += n
+	}
+}
+
+// runVulnerabilityScanJob scans the nodes and images of a vulnerability scan
+// and stores the findings. A resumed job starts the scan over.
+func (h *ClusterHandler) runVulnerabilityScanJob(ctx context.Context, job *db.Job) error {
+	clusterID := job.ClusterID
+
+	var payload vulnerabilityScanPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		h.reportError(clusterID, "Invalid vulnerability scan job payload", err)
+		return err
+	}
+	var scan db.VulnerabilityScan
+	if err := h.store.DB().First(&scan, payload.ScanID).Error; err != nil {
+		h.reportError(clusterID, "Scan not found", err)
+		return err
+	}
+	h.store.DB().Where("scan_id = ?", scan.ID).Delete(&db.Vulnerability{})
+	h.store.DB().Model(&scan).Updates(map[string]interface{}{"status": scanRunning, "error": "",
+		"critical": 0, "high": 0, "medium": 0, "low": 0, "unknown": 0})
+
+	err := h.scanVulnerabilities(ctx, job, &scan, payload.NodeIDs)
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status": scanCompleted, "finished_at": &now,
+		"critical": scan.Critical, "high": scan.High, "medium": scan.Medium, "low": scan.Low, "unknown": scan.Unknown,
+	}
+	if err != nil {
+		updates["status"], updates["error"] = scanFailed, err.Error()
+	}
+	h.store.DB().Model(&scan).Updates(updates)
+	if err != nil {
+		return err
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "complete",
+		fmt.Sprintf("Vulnerability scan %d completed: %d critical, %d high", scan.ID, scan.Critical, scan.High))
+	return nil
+}
+
+// scanVulnerabilities runs Trivy on each node and, if requested, on each
+// running image, saving the findings and counting them in the scan
+func (h *ClusterHandler) scanVulnerabilities(ctx context.Context, job *db.Job, scan *db.VulnerabilityScan, nodeIDs []uint) error {
+	clusterID := scan.ClusterID
+	skipHostKeyCheck := skipsHostKeyCheck(h.store.DB(), clusterID)
+
+	var images []string
+	if scan.Images {
+		var err error
+		if images, err = runningImages(h.store.DB(), ctx, clusterID); err != nil {
+			return fmt.Errorf("failed to list running images: %w", err)
+		}
+		if len(images) > maxScannedImages {
+			h.logEvent(clusterID, "warn", "localhost", "vulnerability-scan",
+				fmt.Sprintf("%d images are running, scanning the first %d", len(images), maxScannedImages))
+			images = images[:maxScannedImages]
+		}
+	}
+	progress := &provisionProgress{job: job, total: len(nodeIDs) + len(images)}
+
+	save := func(targetType, target string, findings []provision.VulnerabilityFinding) error {
+		records := make([]db.Vulnerability, 0, len(findings))
+		for _, f := range findings {
+			records = append(records, db.Vulnerability{
+				ScanID:           scan.ID,
+				TargetType:       targetType,
+				Target:           target,
+				VulnerabilityID:  f.ID,
+				Package:          f.Package,
+				InstalledVersion: f.InstalledVersion,
+				FixedVersion:     f.FixedVersion,
+				Severity:         f.Severity,
+				Title:            f.Title,
+			})
+			countSeverity(&scan.Critical, &scan.High, &scan.Medium, &scan.Low, &scan.Unknown, f.Severity, 1)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return h.store.DB().CreateInBatches(&records, 200).Error
+	}
+
+	h.logEvent(clusterID, "info", "localhost", "vulnerability-scan", fmt.Sprintf("Scanning %d nodes and %d images with Trivy", len(nodeIDs), len(images)))
+	for _, id := range nodeIDs {
+		var node db.Node
+		if err := h.store.DB().Where("cluster_id = ?", clusterID).First(&node, id).Error; err != nil {
+			h.logEvent(clusterID, "warn", "localhost", "vulnerability-scan", fmt.Sprintf("Node %d was removed, skipping it", id))
+			progress.advance("vulnerability-scan", 1)
+			continue
+		}
+		var findings []provision.VulnerabilityFinding
+		err := provision.RunStep(ctx, "trivy "+node.Address, provision.Duration(vulnerabilityScanTimeout), func(ctx context.Context) error {
+			client, err := provision.NewSSHClient(nodeHostSpec(node, skipHostKeyCheck))
+			if err != nil {
+				return fmt.Errorf("failed to connect: %w", err)
+			}
+			defer client.Close()
+			findings, err = provision.TrivyRootfs(ctx, client)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.reportError(clusterID, "Failed to scan node "+node.Hostname, err)
+			return fmt.Errorf("node %s: %w", node.Hostname, err)
+		}
+		if err := save(targetNode, node.Hostname, findings); err != nil {
+			return fmt.Errorf("failed to save findings of %s: %w", node.Hostname, err)
+		}
+		progress.advance("vulnerability-scan", 1)
+	}
+
+	if len(images) == 0 {
+		return nil
+	}
+	host, err := controlPlaneHost(h.store.DB(), clusterID)
+	if err != nil {
+		return fmt.Errorf("no control plane found: %w", err)
+	}
+	client, err := provision.NewSSHClient(host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+	for _, image := range images {
+		var findings []provision.VulnerabilityFinding
+		err := provision.RunStep(ctx, "trivy "+image, provision.Duration(vulnerabilityScanTimeout), func(ctx context.Context) error {
+			var err error
+			findings, err = provision.TrivyImage(ctx, client, image)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// An image that cannot be pulled does not fail the scan
+			h.logEvent(clusterID, "warn", host.Address, "vulnerability-scan", fmt.Sprintf("Failed to scan image %s: %v", image, err))
+			progress.advance("vulnerability-scan", 1)
+			continue
+		}
+		if err := save(targetImage, image, findings); err != nil {
+			return fmt.Errorf("failed to save findings of %s: %w", image, err)
+		}
+		progress.advance("vulnerability-scan", 1)
+	}
+	return nil
+}
+
+// runningImages returns the images of the containers of the pods of a
+// cluster, sorted
+func runningImages(conn *gorm.DB, ctx context.Context, clusterID uint) ([]string, error) {
+	kubeconfig, err := clusterKubeconfig(conn, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	api, err := clusterAPIFor(clusterID, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	var pods struct {
+		Items []struct {
+			Spec struct {
+				Containers []struct {
+					Image string `json:"image"`
+				} `json:"containers"`
+				InitContainers []struct {
+					Image string `json:"image"`
+				} `json:"initContainers"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := api.get(ctx, "/api/v1/pods", &pods); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, pod := range pods.Items {
+		for _, c := range pod.Spec.Containers {
+			seen[c.Image] = true
+		}
+		for _, c := range pod.Spec.InitContainers {
+			seen[c.Image] = true
+		}
+	}
+	delete(seen, "")
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"sync"
@@ -20,17 +22,28 @@ var upgrader = websocket.Upgrader{
 
 // WebSocketHub manages all active WebSocket connections
 type WebSocketHub struct {
-	clients    map[uint]map[*websocket.Conn]bool
+	clients    map[uint]map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan *BroadcastMessage
 	mu         sync.RWMutex
 }
 
+// streamConn is a subscriber connection, a WebSocket or a server-sent
+// events stream
+type streamConn interface {
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
 type Client struct {
-	conn      *websocket.Conn
-	clusterID uint
-	hub       *WebSocketHub
+	conn        streamConn
+	clusterID   uint // firehose for clients of all clusters
+	filter      EventFilter
+	projects    []uint // projects a firehose client may see, unless allProjects
+	allProjects bool
+	hub         *WebSocketHub
+	store       *db.Store // looks up the projects of clusters for firehose clients
 }
 
 type BroadcastMessage struct {
@@ -39,7 +52,7 @@ type BroadcastMessage struct {
 }
 
 var Hub = &WebSocketHub{
-	clients:    make(map[uint]map[*websocket.Conn]bool),
+	clients:    make(map[uint]map[*Client]bool),
 	register:   make(chan *Client),
 	unregister: make(chan *Client),
 	broadcast:  make(chan *BroadcastMessage, 256),
@@ -51,42 +64,97 @@ func (h *WebSocketHub) Run() {
 		case client := <-h.register:
 			h.mu.Lock()
 			if h.clients[client.clusterID] == nil {
-				h.clients[client.clusterID] = make(map[*websocket.Conn]bool)
+				h.clients[client.clusterID] = make(map[*Client]bool)
 			}
-			h.clients[client.clusterID][client.conn] = true
+			h.clients[client.clusterID][client] = true
 			h.mu.Unlock()
-			log.Printf("Client registered for cluster %d", client.clusterID)
+			slog.Debug("WebSocket client registered", "cluster_id", client.clusterID)
 
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if clients, ok := h.clients[client.clusterID]; ok {
-				if _, ok := clients[client.conn]; ok {
-					delete(clients, client.conn)
-					client.conn.Close()
-					if len(clients) == 0 {
-						delete(h.clients, client.clusterID)
-					}
-				}
-			}
-			h.mu.Unlock()
-			log.Printf("Client unregistered from cluster %d", client.clusterID)
+			h.remove(client)
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
-			clients := h.clients[message.clusterID]
+			clients := make([]*Client, 0, len(h.clients[message.clusterID])+len(h.clients[firehose]))
+			for client := range h.clients[message.clusterID] {
+				clients = append(clients, client)
+			}
+			for client := range h.clients[firehose] {
+				clients = append(clients, client)
+			}
 			h.mu.RUnlock()
 
-			for conn := range clients {
-				err := conn.WriteJSON(message.data)
-				if err != nil {
-					log.Printf("WebSocket write error: %v", err)
-					h.unregister <- &Client{conn: conn, clusterID: message.clusterID, hub: h}
+			for _, client := range clients {
+				// Filters are applied here so noisy provisions never reach the wire
+				if !client.filter.Match(message.data) || !client.allowsCluster(message.clusterID) {
+					continue
+				}
+				if err := client.conn.WriteJSON(message.data); err != nil {
+					slog.Debug("WebSocket write failed", "cluster_id", client.clusterID, "error", err)
+					h.remove(client)
 				}
 			}
 		}
 	}
 }
 
+// remove closes a client's connection and stops sending to it
+func (h *WebSocketHub) remove(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients, ok := h.clients[client.clusterID]
+	if !ok || !clients[client] {
+		return
+	}
+	delete(clients, client)
+	client.conn.Close()
+	if len(clients) == 0 {
+		delete(h.clients, client.clusterID)
+	}
+	slog.Debug("WebSocket client unregistered", "cluster_id", client.clusterID)
+}
+
+// JobProgress is sent to WebSocket clients when a job advances. Its type
+// field distinguishes it from event messages.
+type JobProgress struct {
+	Type      string `json:"type"` // always "progress"
+	JobID     uint   `json:"job_id"`
+	ClusterID uint   `json:"cluster_id"`
+	Phase     string `json:"phase"`
+	Progress  int    `json:"progress"`
+}
+
+// BroadcastProgress sends a job progress update to all clients watching a cluster
+func (h *WebSocketHub) BroadcastProgress(clusterID uint, progress JobProgress) {
+	progress.Type = "progress"
+	h.broadcast <- &BroadcastMessage{
+		clusterID: clusterID,
+		data:      progress,
+	}
+}
+
+// CommandOutput is sent to WebSocket clients with live output of a long
+// running command. It is not stored; the full output is saved on the event
+// recorded when the command ends.
+type CommandOutput struct {
+	Type      string `json:"type"` // always "output"
+	ClusterID uint   `json:"cluster_id"`
+	JobID     uint   `json:"job_id,omitempty"`
+	Host      string `json:"host"`
+	Step      string `json:"step"`
+	Command   string `json:"command"`
+	Data      string `json:"data"`
+}
+
+// BroadcastOutput sends a chunk of command output to all clients watching a cluster
+func (h *WebSocketHub) BroadcastOutput(clusterID uint, output CommandOutput) {
+	output.Type = "output"
+	h.broadcast <- &BroadcastMessage{
+		clusterID: clusterID,
+		data:      output,
+	}
+}
+
 // BroadcastEvent sends an event to all clients watching a cluster
 func (h *WebSocketHub) BroadcastEvent(clusterID uint, event db.Event) {
 	h.broadcast <- &BroadcastMessage{
@@ -95,45 +163,109 @@ func (h *WebSocketHub) BroadcastEvent(clusterID uint, event db.Event) {
 	}
 }
 
-// HandleWebSocket handles WebSocket connections for cluster events
-func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseUint(vars["id"], 10, 32)
-	if err != nil {
-		WriteError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid cluster ID")
-		return
+// subscriptionBuffer is how many hub messages a subscription may fall
+// behind before it is ended
+const subscriptionBuffer = 256
+
+// errSubscriptionOverflow ends a subscription that does not keep up
+var errSubscriptionOverflow = errors.New("subscription fell behind")
+
+// subscribe registers a hub client that passes the messages accept lets
+// through to the returned channel until ctx is done. The channel is closed
+// once the client is removed, also when it fell behind.
+func subscribe(ctx context.Context, client *Client, accept func(interface{}) bool) <-chan interface{} {
+	conn := &subscriptionConn{values: make(chan interface{}, subscriptionBuffer), accept: accept}
+	client.conn, client.hub = conn, Hub
+
+	Hub.register <- client
+	go func() {
+		<-ctx.Done()
+		Hub.unregister <- client
+	}()
+	return conn.values
+}
+
+// subscriptionConn passes the hub messages of a subscription, of GraphQL or
+// gRPC, to its stream. The hub writes and closes it from its own goroutine.
+type subscriptionConn struct {
+	values chan interface{}
+	accept func(interface{}) bool
+	once   sync.Once
+}
+
+// WriteJSON queues a message, failing when the subscription fell behind so
+// the hub drops it
+func (c *subscriptionConn) WriteJSON(v interface{}) error {
+	if !c.accept(v) {
+		return nil
 	}
-	clusterID := uint(id)
+	select {
+	case c.values <- v:
+		return nil
+	default:
+		return errSubscriptionOverflow
+	}
+}
 
+// Close ends the stream of the subscription
+func (c *subscriptionConn) Close() error {
+	c.once.Do(func() { close(c.values) })
+	return nil
+}
+
+// HandleWebSocket handles WebSocket connections for cluster events. The
+// level, host, step and job_id query parameters limit what the client receives.
+func HandleWebSocket(store *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.ParseUint(vars["id"], 10, 32)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid cluster ID")
+			return
+		}
+		clusterID := uint(id)
+
+		filter, err := parseEventFilter(r.URL.Query())
+		if err != nil {
+			WriteBadRequest(w, err.Error())
+			return
+		}
+
+		serveWebSocket(w, r, &Client{
+			clusterID: clusterID,
+			filter:    filter,
+			hub:       Hub,
+			store:     store,
+		})
+	}
+}
+
+// serveWebSocket upgrades the connection and streams the hub messages of the
+// client until it disconnects. Clients of a single cluster first receive its
+// recent events.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, client *Client) {
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		slog.WarnContext(r.Context(), "Failed to upgrade connection", "error", err)
 		return
 	}
-
-	client := &Client{
-		conn:      conn,
-		clusterID: clusterID,
-		hub:       Hub,
-	}
+	client.conn = conn
 
 	Hub.register <- client
 
 	// Send recent events immediately
-	go func() {
-		var events []db.Event
-		if err := db.DB.Where("cluster_id = ?", clusterID).
-			Order("timestamp desc").
-			Limit(50).
-			Find(&events).Error; err == nil {
-			// Reverse to get chronological order
-			for i := len(events) - 1; i >= 0; i-- {
-				conn.WriteJSON(events[i])
-				time.Sleep(10 * time.Millisecond) // Small delay for better UX
+	if client.clusterID != firehose {
+		go func() {
+			if events, err := eventStore.List(client.filter.Query(client.clusterID, 50)); err == nil {
+				// Reverse to get chronological order
+				for i := len(events) - 1; i >= 0; i-- {
+					conn.WriteJSON(events[i])
+					time.Sleep(10 * time.Millisecond) // Small delay for better UX
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	// Keep connection alive with ping/pong
 	go func() {
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"kubeforge/internal/db"
+)
+
+// workloadCacheTTL is how long summaries of cluster contents are served
+// from memory before the API server is asked again
+const workloadCacheTTL = 15 * time.Second
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// workloadEntry is a cached summary
+type workloadEntry struct {
+	fetchedAt time.Time
+	value     interface{}
+}
+
+var (
+	workloadCacheMu sync.Mutex
+	workloadCache   = map[string]workloadEntry{}
+)
+
+// objectMeta is the metadata of Kubernetes objects read here
+type objectMeta struct {
+	Name              string    `json:"name"`
+	Namespace         string    `json:"namespace"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+}
+
+// NamespaceSummary is a namespace of a cluster
+type NamespaceSummary struct {
+	Name      string    `json:"name"`
+	Phase     string    `json:"phase"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeploymentSummary is a deployment of a cluster and its rollout state
+type DeploymentSummary struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Replicas  int32     `json:"replicas"`
+	Ready     int32     `json:"ready"`
+	UpToDate  int32     `json:"up_to_date"`
+	Available int32     `json:"available"`
+	Images    []string  `json:"images"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PodSummary is a pod of a cluster
+type PodSummary struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Phase      string    `json:"phase"`
+	Node       string    `json:"node,omitempty"`
+	Ready      int       `json:"ready"`      // ready containers
+	Containers int       `json:"containers"` // containers of the pod, init containers excluded
+	Restarts   int32     `json:"restarts"`   // restarts of all containers
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PVCSummary is a persistent volume claim of a cluster
+type PVCSummary struct {
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	Phase        string    `json:"phase"`
+	StorageClass string    `json:"storage_class,omitempty"`
+	Capacity     string    `json:"capacity,omitempty"`
+	Volume       string    `json:"volume,omitempty"`
+	AccessModes  []string  `json:"access_modes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ListNamespaces summarizes the namespaces of a cluster
+func (h *ClusterHandler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
+	serveWorkloads(h.store.DB(), w, r, "namespaces", false, func(ctx context.Context, client kubernetes.Interface, _ string) (interface{}, error) {
+		list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		summaries := make([]NamespaceSummary, 0, len(list.Items))
+		for _, item := range list.Items {
+			summaries = append(summaries, NamespaceSummary{
+				Name:      item.Name,
+				Phase:     string(item.Status.Phase),
+				CreatedAt: item.CreationTimestamp.Time,
+			})
+		}
+		return summaries, nil
+	})
+}
+
+// ListDeployments summarizes the deployments of a cluster, of one namespace
+// with ?namespace=
+func (h *ClusterHandler) ListDeployments(w http.ResponseWriter, r *http.Request) {
+	serveWorkloads(h.store.DB(), w, r, "deployments", true, func(ctx context.Context, client kubernetes.Interface, namespace string) (interface{}, error) {
+		list, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		summaries := make([]DeploymentSummary, 0, len(list.Items))
+		for _, item := range list.Items {
+			summary := DeploymentSummary{
+				Namespace: item.Namespace,
+				Name:      item.Name,
+				Replicas:  1,
+				Ready:     item.Status.ReadyReplicas,
+				UpToDate:  item.Status.UpdatedReplicas,
+				Available: item.Status.AvailableReplicas,
+				Images:    []string{},
+				CreatedAt: item.CreationTimestamp.Time,
+			}
+			if item.Spec.Replicas != nil {
+				summary.Replicas = *item.Spec.Replicas
+			}
+			for _, container := range item.Spec.Template.Spec.Containers {
+				summary.Images = append(summary.Images, container.Image)
+			}
+			summaries = append(summaries, summary)
+		}
+		return summaries, nil
+	})
+}
+
+// ListPods summarizes the pods of a cluster with their restart counts, of
+// one namespace with ?namespace=
+func (h *ClusterHandler) ListPods(w http.ResponseWriter, r *http.Request) {
+	serveWorkloads(h.store.DB(), w, r, "pods", true, func(ctx context.Context, client kubernetes.Interface, namespace string) (interface{}, error) {
+		list, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		summaries := make([]PodSummary, 0, len(list.Items))
+		for _, item := range list.Items {
+			summary := PodSummary{
+				Namespace:  item.Namespace,
+				Name:       item.Name,
+				Phase:      string(item.Status.Phase),
+				Node:       item.Spec.NodeName,
+				Containers: len(item.Spec.Containers),
+				CreatedAt:  item.CreationTimestamp.Time,
+			}
+			for _, status := range item.Status.ContainerStatuses {
+				if status.Ready {
+					summary.Ready++
+				}
+				summary.Restarts += status.RestartCount
+			}
+			summaries = append(summaries, summary)
+		}
+		return summaries, nil
+	})
+}
+
+// ListPVCs summarizes the persistent volume claims of a cluster, of one
+// namespace with ?namespace=
+func (h *ClusterHandler) ListPVCs(w http.ResponseWriter, r *http.Request) {
+	serveWorkloads(h.store.DB(), w, r, "pvcs", true, func(ctx context.Context, client kubernetes.Interface, namespace string) (interface{}, error) {
+		list, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		summaries := make([]PVCSummary, 0, len(list.Items))
+		for _, item := range list.Items {
+			summary := PVCSummary{
+				Namespace:   item.Namespace,
+				Name:        item.Name,
+				Phase:       string(item.Status.Phase),
+				Volume:      item.Spec.VolumeName,
+				AccessModes: make([]string, 0, len(item.Status.AccessModes)),
+				CreatedAt:   item.CreationTimestamp.Time,
+			}
+			if item.Spec.StorageClassName != nil {
+				summary.StorageClass = *item.Spec.StorageClassName
+			}
+			if capacity, ok := item.Status.Capacity[corev1.ResourceStorage]; ok {
+				summary.Capacity = capacity.String()
+			}
+			for _, mode := range item.Status.AccessModes {
+				summary.AccessModes = append(summary.AccessModes, string(mode))
+			}
+			summaries = append(summaries, summary)
+		}
+		return summaries, nil
+	})
+}
+
+// serveWorkloads writes a summary of cluster contents, read from the API
+// server of the cluster by fetch or from the cache when it is recent
+func serveWorkloads(conn *gorm.DB, w http.ResponseWriter, r *http.Request, kind string, namespaced bool,
+	fetch func(ctx context.Context, client kubernetes.Interface, namespace string) (interface{}, error)) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		WriteBadRequest(w, "Invalid cluster ID")
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespaced && namespace != "" && (!namespacePattern.MatchString(namespace) || len(namespace) > 63) {
+		WriteBadRequest(w, "Invalid namespace")
+		return
+	}
+
+	key := fmt.Sprintf("%d/%s/%s", id, kind, namespace)
+	workloadCacheMu.Lock()
+	entry, ok := workloadCache[key]
+	workloadCacheMu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < workloadCacheTTL {
+		WriteSuccess(w, entry.value)
+		return
+	}
+
+	var cluster db.Cluster
+	if err := conn.Select("id", "kubeconfig").First(&cluster, id).Error; err != nil {
+		WriteNotFound(w, "Cluster not found")
+		return
+	}
+	if cluster.Kubeconfig == nil {
+		WriteError(w, http.StatusNotFound, "NOT_FOUND", "Kubeconfig not available")
+		return
+	}
+	api, err := clusterAPIFor(cluster.ID, cluster.Kubeconfig)
+	if err != nil {
+		WriteInternalError(w, "Failed to read the cluster kubeconfig")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), clusterAPITimeout)
+	defer cancel()
+	value, err := fetch(ctx, api.clientset, namespace)
+	if err != nil {
+		WriteError(w, http.StatusBadGateway, "KUBERNETES_ERROR", fmt.Sprintf("Failed to list %s: %v", kind, err))
+		return
+	}
+
+	workloadCacheMu.Lock()
+	now := time.Now()
+	for k, e := range workloadCache {
+		if now.Sub(e.fetchedAt) >= workloadCacheTTL {
+			delete(workloadCache, k)
+		}
+	}
+	workloadCache[key] = workloadEntry{fetchedAt: now, value: value}
+	workloadCacheMu.Unlock()
+
+	WriteSuccess(w, value)
+}
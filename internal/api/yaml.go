@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYAML reports whether a Content-Type or Accept media type is YAML
+func isYAML(mediaType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// acceptsYAML reports whether a request asks for YAML responses
+func acceptsYAML(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if isYAML(strings.TrimSpace(mediaRange)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML document to JSON, so that request structs only
+// need their JSON tags
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	converted, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("YAML cannot be represented as JSON: %w", err)
+	}
+	return converted, nil
+}
+
+// jsonToYAML converts a JSON document to block style YAML, keeping the order
+// of the fields
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow style and quoting JSON parses into, letting the
+// encoder quote only the strings that need it
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// YAMLResponses middleware converts JSON responses to YAML for requests
+// sending Accept: application/yaml. Streaming and other non-JSON responses
+// are passed through.
+func YAMLResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsYAML(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		wrapped := &yamlResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+		wrapped.finish()
+	})
+}
+
+// yamlResponseWriter buffers a JSON response to write it as YAML once the
+// handler returns
+type yamlResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (w *yamlResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = code
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.buffering = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *yamlResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes flushes of streaming responses through the wrapper
+func (w *yamlResponseWriter) Flush() {
+	if w.buffering {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish writes the buffered JSON response as YAML, or as it is if it cannot
+// be converted
+func (w *yamlResponseWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	body := w.body.Bytes()
+	if converted, err := jsonToYAML(body); err == nil {
+		body = converted
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.ResponseWriter.Write(body)
+}
+
+// Unwrap lets http.ResponseController reach the connection through the wrapper
+func (w *yamlResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
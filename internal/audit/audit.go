@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+)
+
+// MaxPayloadSize is the maximum length of a recorded request payload
+const MaxPayloadSize = 2048
+
+// redacted replaces the value of sensitive payload fields
+const redacted = "[REDACTED]"
+
+// sensitiveFields are redacted from payloads when a field name contains them
+var sensitiveFields = []string{
+	"password",
+	"secret",
+	"token",
+	"private_key",
+	"passphrase",
+	"certificate_key",
+	"credential",
+	"kubeconfig",
+}
+
+// Sink receives a copy of every audit entry, e.g. for shipping to a SIEM
+type Sink interface {
+	Write(entry *db.AuditLog) error
+}
+
+var sink Sink
+
+// SetSink configures where audit entries are exported besides the database.
+// A nil sink disables the export.
+func SetSink(s Sink) {
+	sink = s
+}
+
+// Record stores an audit entry and exports it to the configured sink
+func Record(conn *gorm.DB, entry *db.AuditLog) {
+	if err := conn.Create(entry).Error; err != nil {
+		slog.Error("Failed to record audit entry", "method", entry.Method, "path", entry.Path, "error", err)
+	}
+	if sink != nil {
+		if err := sink.Write(entry); err != nil {
+			slog.Error("Failed to export audit entry", "error", err)
+		}
+	}
+}
+
+// Summarize returns a request payload for the audit log with secrets
+// redacted and the result truncated to MaxPayloadSize
+func Summarize(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("<%d bytes of non-JSON data>", len(body))
+	}
+	summary, err := json.Marshal(redact(value))
+	if err != nil {
+		return ""
+	}
+	if len(summary) > MaxPayloadSize {
+		return string(summary[:MaxPayloadSize]) + "...(truncated)"
+	}
+	return string(summary)
+}
+
+// Redact replaces the sensitive fields of a decoded JSON value in place, as
+// they are redacted from audited payloads
+func Redact(value interface{}) interface{} {
+	return redact(value)
+}
+
+// redact replaces sensitive fields of a decoded JSON value
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitive(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redact(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return value
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if key == "ssh_key" {
+		return true
+	}
+	for _, field := range sensitiveFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileSink appends audit entries to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens a file sink, creating the file if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends an entry to the file
+func (s *FileSink) Write(entry *db.AuditLog) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+
+	"kubeforge/internal/db"
+)
+
+// SyslogSink sends audit entries to the local syslog daemon as JSON
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Write sends an entry to syslog
+func (s *SyslogSink) Write(entry *db.AuditLog) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(line))
+}
+
+// Close closes the syslog connection
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,27 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+
+	"kubeforge/internal/db"
+)
+
+// SyslogSink is not available on this platform
+type SyslogSink struct{}
+
+// NewSyslogSink always fails on platforms without syslog
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write is a no-op
+func (s *SyslogSink) Write(entry *db.AuditLog) error {
+	return nil
+}
+
+// Close is a no-op
+func (s *SyslogSink) Close() error {
+	return nil
+}
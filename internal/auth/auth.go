@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+)
+
+// Roles, from most to least privileged
+const (
+	RoleAdmin    = "admin"    // everything, including deleting clusters and managing users and SSH keys
+	RoleOperator = "operator" // create, scale and change clusters
+	RoleViewer   = "viewer"   // read only
+)
+
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole reports whether role grants at least the privileges of required
+func HasRole(role, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// Common errors
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+)
+
+// Claims are the JWT claims of an access token
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to
+func (c *Claims) UserID() uint {
+	id, _ := strconv.ParseUint(c.Subject, 10, 32)
+	return uint(id)
+}
+
+// dummyHash is compared against when a username does not exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("kubeforge"), bcrypt.DefaultCost)
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// TokenManager issues and verifies access and refresh tokens
+type TokenManager struct {
+	conn       *gorm.DB
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenManager creates a token manager storing refresh tokens in conn and
+// signing access tokens with secret
+func NewTokenManager(conn *gorm.DB, secret []byte, accessTTL, refreshTTL time.Duration) *TokenManager {
+	return &TokenManager{
+		conn:       conn,
+		secret:     secret,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// HashPassword returns the bcrypt hash of a password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Login verifies a username and password and issues a token pair
+func (m *TokenManager) Login(username, password string) (*TokenPair, *db.User, error) {
+	var user db.User
+	if err := m.conn.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Spend the same time as a real comparison to not reveal valid usernames
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			return nil, nil, ErrInvalidCredentials
+		}
+		return nil, nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	pair, err := m.issue(&user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, &user, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. The refresh token
+// is rotated: the old one is revoked.
+func (m *TokenManager) Refresh(refreshToken string) (*TokenPair, error) {
+	var token db.RefreshToken
+	err := m.conn.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(refreshToken), time.Now()).
+		First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	var user db.User
+	if err := m.conn.First(&user, token.UserID).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	result := m.conn.Model(&db.RefreshToken{}).Where("id = ? AND revoked_at IS NULL", token.ID).Update("revoked_at", &now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Used concurrently by another request
+		return nil, ErrInvalidToken
+	}
+
+	return m.issue(&user)
+}
+
+// Revoke invalidates a refresh token
+func (m *TokenManager) Revoke(refreshToken string) error {
+	now := time.Now()
+	return m.conn.Model(&db.RefreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hashToken(refreshToken)).
+		Update("revoked_at", &now).Error
+}
+
+// Verify parses and validates an access token
+func (m *TokenManager) Verify(accessToken string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("kubeforge"))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// issue creates a new access token and refresh token for a user
+func (m *TokenManager) issue(user *db.User) (*TokenPair, error) {
+	now := time.Now()
+	claims := Claims{
+		Username: user.Username,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    "kubeforge",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+		},
+	}
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	record := db.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: now.Add(m.refreshTTL),
+		CreatedAt: now,
+	}
+	if err := m.conn.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(m.accessTTL.Seconds()),
+	}, nil
+}
+
+// EnsureAdmin creates the initial admin user if no users exist yet. If
+// password is empty a random one is generated and returned.
+func EnsureAdmin(conn *gorm.DB, username, email, password string) (created bool, generated string, err error) {
+	var count int64
+	if err := conn.Model(&db.User{}).Count(&count).Error; err != nil {
+		return false, "", err
+	}
+	if count > 0 {
+		return false, "", nil
+	}
+
+	if password == "" {
+		password, err = randomToken()
+		if err != nil {
+			return false, "", err
+		}
+		generated = password
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return false, "", err
+	}
+
+	user := db.User{
+		Username:     username,
+		Email:        email,
+		PasswordHash: hash,
+		Role:         RoleAdmin,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := conn.Create(&user).Error; err != nil {
+		return false, "", err
+	}
+	return true, generated, nil
+}
+
+// RandomSecret returns a random 32 byte signing secret
+func RandomSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
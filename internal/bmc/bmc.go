@@ -0,0 +1,102 @@
+package bmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"kubeforge/internal/validation"
+)
+
+// Protocols a BMC is managed with
+const (
+	ProtocolRedfish = "redfish"
+	ProtocolIPMI    = "ipmi"
+)
+
+// Protocols are the supported BMC protocols
+var Protocols = []string{ProtocolRedfish, ProtocolIPMI}
+
+// Power actions
+const (
+	ActionOn    = "on"
+	ActionOff   = "off"   // immediate, like holding the power button
+	ActionCycle = "cycle" // off, then on again
+	ActionReset = "reset" // hard reset without powering off
+)
+
+// Actions are the supported power actions
+var Actions = []string{ActionOn, ActionOff, ActionCycle, ActionReset}
+
+// Power states
+const (
+	StateOn      = "on"
+	StateOff     = "off"
+	StateUnknown = "unknown"
+)
+
+// ErrUnsupported is returned for operations a BMC does not offer
+var ErrUnsupported = errors.New("not supported by the BMC")
+
+// Spec is the baseboard management controller of a bare-metal host
+type Spec struct {
+	Protocol              string `json:"protocol,omitempty"` // redfish (default) or ipmi
+	Address               string `json:"address"`            // host name or address, or a Redfish base URL
+	Username              string `json:"username"`
+	Password              string `json:"password,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecure_skip_tls_verify,omitempty"` // accept the self-signed certificate of a Redfish BMC
+}
+
+// SetDefaults fills in the protocol
+func (s *Spec) SetDefaults() {
+	if s.Protocol == "" {
+		s.Protocol = ProtocolRedfish
+	}
+}
+
+// ValidateFields checks the BMC settings. Field paths are relative to prefix.
+func (s *Spec) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	if s.Protocol != "" && !validation.OneOf(s.Protocol, Protocols) {
+		errs.Add(validation.Path(prefix, "protocol"), validation.CodeUnsupported,
+			fmt.Sprintf("unsupported BMC protocol %q, expected one of %s", s.Protocol, strings.Join(Protocols, ", ")))
+	}
+	if s.Address == "" {
+		errs.Add(validation.Path(prefix, "address"), validation.CodeRequired, "address is required")
+	} else if strings.Contains(s.Address, "://") {
+		if u, err := url.Parse(s.Address); err != nil || u.Host == "" || s.Protocol == ProtocolIPMI {
+			errs.Add(validation.Path(prefix, "address"), validation.CodeInvalid, "address must be a host name, an IP address or, for redfish, an https URL")
+		}
+	}
+	if s.Username == "" {
+		errs.Add(validation.Path(prefix, "username"), validation.CodeRequired, "username is required")
+	}
+	return errs
+}
+
+// Controller manages the power and boot device of a host through its BMC
+type Controller interface {
+	// PowerState returns on, off or unknown
+	PowerState(ctx context.Context) (string, error)
+
+	// Power runs a power action
+	Power(ctx context.Context, action string) error
+
+	// BootPXE makes the host boot from the network once, on its next boot
+	BootPXE(ctx context.Context) error
+}
+
+// New returns the controller of a BMC
+func New(spec Spec) (Controller, error) {
+	spec.SetDefaults()
+	switch spec.Protocol {
+	case ProtocolRedfish:
+		return newRedfish(spec), nil
+	case ProtocolIPMI:
+		return newIPMI(spec)
+	default:
+		return nil, fmt.Errorf("unsupported BMC protocol %q", spec.Protocol)
+	}
+}
@@ -0,0 +1,78 @@
+package bmc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ipmiActions maps power actions to ipmitool chassis power commands
+var ipmiActions = map[string]string{
+	ActionOn:    "on",
+	ActionOff:   "off",
+	ActionCycle: "cycle",
+	ActionReset: "reset",
+}
+
+// ipmi manages a BMC over IPMI v2.0 (lanplus) with ipmitool, which must be
+// installed on the KubeForge server
+type ipmi struct {
+	spec Spec
+}
+
+func newIPMI(spec Spec) (*ipmi, error) {
+	if _, err := exec.LookPath("ipmitool"); err != nil {
+		return nil, fmt.Errorf("ipmitool is not installed: %w", ErrUnsupported)
+	}
+	return &ipmi{spec: spec}, nil
+}
+
+// PowerState returns the chassis power state
+func (i *ipmi) PowerState(ctx context.Context) (string, error) {
+	out, err := i.run(ctx, "chassis", "power", "status")
+	if err != nil {
+		return StateUnknown, err
+	}
+	switch {
+	case strings.HasSuffix(strings.TrimSpace(out), "is on"):
+		return StateOn, nil
+	case strings.HasSuffix(strings.TrimSpace(out), "is off"):
+		return StateOff, nil
+	default:
+		return StateUnknown, nil
+	}
+}
+
+// Power runs a chassis power command
+func (i *ipmi) Power(ctx context.Context, action string) error {
+	command, ok := ipmiActions[action]
+	if !ok {
+		return fmt.Errorf("unknown power action %q", action)
+	}
+	_, err := i.run(ctx, "chassis", "power", command)
+	return err
+}
+
+// BootPXE sets the boot device of the next boot to PXE
+func (i *ipmi) BootPXE(ctx context.Context) error {
+	_, err := i.run(ctx, "chassis", "bootdev", "pxe")
+	return err
+}
+
+// run runs ipmitool against the BMC. The password is passed in the
+// environment so that it does not show in the process list.
+func (i *ipmi) run(ctx context.Context, args ...string) (string, error) {
+	args = append([]string{"-I", "lanplus", "-H", i.spec.Address, "-U", i.spec.Username, "-E"}, args...)
+	cmd := exec.CommandContext(ctx, "ipmitool", args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+i.spec.Password)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ipmitool %s: %s: %w", strings.Join(args[7:], " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.String(), nil
+}
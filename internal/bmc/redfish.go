@@ -0,0 +1,199 @@
+package bmc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// redfishRequestTimeout bounds a single Redfish request
+const redfishRequestTimeout = 30 * time.Second
+
+// resetTypes maps power actions to Redfish reset types, preferred first
+var resetTypes = map[string][]string{
+	ActionOn:    {"On"},
+	ActionOff:   {"ForceOff"},
+	ActionCycle: {"PowerCycle", "ForceRestart"},
+	ActionReset: {"ForceRestart"},
+}
+
+// redfish manages a BMC through the DMTF Redfish REST API
+type redfish struct {
+	spec   Spec
+	base   string
+	client *http.Client
+	system string // path of the computer system, found on first use
+}
+
+func newRedfish(spec Spec) *redfish {
+	base := spec.Address
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if spec.InsecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &redfish{
+		spec:   spec,
+		base:   strings.TrimSuffix(base, "/"),
+		client: &http.Client{Timeout: redfishRequestTimeout, Transport: transport},
+	}
+}
+
+// PowerState returns the power state of the computer system
+func (r *redfish) PowerState(ctx context.Context) (string, error) {
+	system, err := r.findSystem(ctx)
+	if err != nil {
+		return StateUnknown, err
+	}
+	var state struct {
+		PowerState string `json:"PowerState"`
+	}
+	if err := r.call(ctx, http.MethodGet, system, nil, &state); err != nil {
+		return StateUnknown, err
+	}
+	switch state.PowerState {
+	case "On", "PoweringOff":
+		return StateOn, nil
+	case "Off", "PoweringOn":
+		return StateOff, nil
+	default:
+		return StateUnknown, nil
+	}
+}
+
+// Power resets the computer system. Systems without a power cycle reset are
+// restarted instead.
+func (r *redfish) Power(ctx context.Context, action string) error {
+	types, ok := resetTypes[action]
+	if !ok {
+		return fmt.Errorf("unknown power action %q", action)
+	}
+	system, err := r.findSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	var allowed struct {
+		Actions struct {
+			Reset struct {
+				Types []string `json:"ResetType@Redfish.AllowableValues"`
+			} `json:"#ComputerSystem.Reset"`
+		} `json:"Actions"`
+	}
+	if err := r.call(ctx, http.MethodGet, system, nil, &allowed); err != nil {
+		return err
+	}
+	resetType := types[0]
+	// BMCs that do not list the allowed values accept the standard ones
+	if len(allowed.Actions.Reset.Types) > 0 {
+		resetType = ""
+		for _, t := range types {
+			if containsString(allowed.Actions.Reset.Types, t) {
+				resetType = t
+				break
+			}
+		}
+		if resetType == "" {
+			return fmt.Errorf("power %s: %w", action, ErrUnsupported)
+		}
+	}
+	return r.call(ctx, http.MethodPost, system+"/Actions/ComputerSystem.Reset", map[string]string{"ResetType": resetType}, nil)
+}
+
+// BootPXE overrides the boot source of the next boot with PXE
+func (r *redfish) BootPXE(ctx context.Context) error {
+	system, err := r.findSystem(ctx)
+	if err != nil {
+		return err
+	}
+	boot := map[string]interface{}{
+		"Boot": map[string]string{"BootSourceOverrideTarget": "Pxe", "BootSourceOverrideEnabled": "Once"},
+	}
+	return r.call(ctx, http.MethodPatch, system, boot, nil)
+}
+
+// findSystem returns the path of the first computer system of the BMC
+func (r *redfish) findSystem(ctx context.Context) (string, error) {
+	if r.system != "" {
+		return r.system, nil
+	}
+	var systems struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		} `json:"Members"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/redfish/v1/Systems", nil, &systems); err != nil {
+		return "", err
+	}
+	if len(systems.Members) == 0 {
+		return "", fmt.Errorf("the BMC manages no computer system")
+	}
+	r.system = systems.Members[0].ID
+	return r.system, nil
+}
+
+// call sends a request to the Redfish API with basic authentication
+func (r *redfish) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(r.spec.Username, r.spec.Password)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("redfish request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("redfish request failed: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var redfishErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &redfishErr)
+		return fmt.Errorf("redfish %s %s failed: %s: %s", method, path, resp.Status, redfishErr.Error.Message)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("redfish returned an invalid response: %w", err)
+	}
+	return nil
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,58 +2,469 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"time"
 )
 
 // Config holds all application configuration
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Logger   LoggerConfig
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Logger    LoggerConfig    `yaml:"logging" toml:"logging"`
+	Secrets   SecretsConfig   `yaml:"secrets" toml:"secrets"`
+	Jobs      JobsConfig      `yaml:"jobs" toml:"jobs"`
+	Provision ProvisionConfig `yaml:"provision" toml:"provision"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	Audit     AuditConfig     `yaml:"audit" toml:"audit"`
+	Notify    NotifyConfig    `yaml:"notify" toml:"notify"`
+	Retention RetentionConfig `yaml:"retention" toml:"retention"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Alerts    AlertsConfig    `yaml:"alerts" toml:"alerts"`
+	Versions  VersionsConfig  `yaml:"versions" toml:"versions"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Infra     InfraConfig     `yaml:"infra" toml:"infra"`
 }
 
 // ServerConfig contains HTTP server settings
 type ServerConfig struct {
-	Host            string
-	Port            string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	ShutdownTimeout time.Duration
+	Host            string        `yaml:"host" toml:"host"`
+	Port            string        `yaml:"port" toml:"port"`
+	GRPCPort        string        `yaml:"grpc_port" toml:"grpc_port"` // serves the gRPC API when set
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
 }
 
 // DatabaseConfig contains database connection settings
 type DatabaseConfig struct {
-	Driver string // sqlite, postgres, mysql
-	DSN    string // connection string
+	Driver      string `yaml:"driver" toml:"driver"`             // sqlite, postgres, mysql
+	DSN         string `yaml:"dsn" toml:"dsn"`                   // connection string
+	AutoMigrate bool   `yaml:"auto_migrate" toml:"auto_migrate"` // apply pending migrations on start, otherwise run kubeforge-server migrate
+
+	MaxOpenConns       int           `yaml:"max_open_conns" toml:"max_open_conns"`             // 0: unlimited
+	MaxIdleConns       int           `yaml:"max_idle_conns" toml:"max_idle_conns"`             // 0: database/sql default of 2
+	ConnMaxLifetime    time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`       // 0: connections are reused forever
+	LogLevel           string        `yaml:"log_level" toml:"log_level"`                       // silent, error, warn, info (every statement)
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" toml:"slow_query_threshold"` // slower statements are logged at warn, 0 disables
 }
 
 // LoggerConfig contains logging settings
 type LoggerConfig struct {
-	Level  string // debug, info, warn, error
-	Format string // json, console
+	Level  string `yaml:"level" toml:"level"`   // debug, info, warn, error
+	Format string `yaml:"format" toml:"format"` // json, console
+}
+
+// JobsConfig contains background job queue settings
+type JobsConfig struct {
+	Workers      int           `yaml:"workers" toml:"workers"`             // number of concurrent job workers
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"` // how often idle workers check for pending jobs
+	InstanceID   string        `yaml:"instance_id" toml:"instance_id"`     // unique name of this server replica, defaults to the hostname
+	LeaseTTL     time.Duration `yaml:"lease_ttl" toml:"lease_ttl"`         // how long a replica may go without heartbeat before its jobs are taken over
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"` // how long running jobs may take to finish their current step on shutdown
+
+	StuckClusterTimeout time.Duration `yaml:"stuck_cluster_timeout" toml:"stuck_cluster_timeout"` // clusters pending, provisioning, upgrading or reconciling for longer are marked failed, 0 to disable
+}
+
+// ProvisionConfig contains limits for SSH operations on hosts
+type ProvisionConfig struct {
+	MaxParallelHosts int `yaml:"max_parallel_hosts" toml:"max_parallel_hosts"` // hosts of one cluster prepared in parallel, 0 for unlimited
+	MaxConcurrentSSH int `yaml:"max_concurrent_ssh" toml:"max_concurrent_ssh"` // host operations running at once across all clusters, 0 for unlimited
+
+	RetryAttempts       int           `yaml:"retry_attempts" toml:"retry_attempts"`               // attempts for SSH connects, package installs and joins
+	RetryInitialBackoff time.Duration `yaml:"retry_initial_backoff" toml:"retry_initial_backoff"` // wait before the first retry, doubled on each attempt
+	RetryMaxBackoff     time.Duration `yaml:"retry_max_backoff" toml:"retry_max_backoff"`         // upper bound of the wait between retries
+
+	PrepareTimeout   time.Duration `yaml:"prepare_timeout" toml:"prepare_timeout"`     // per host package installation
+	BootstrapTimeout time.Duration `yaml:"bootstrap_timeout" toml:"bootstrap_timeout"` // kubeadm init
+	CNITimeout       time.Duration `yaml:"cni_timeout" toml:"cni_timeout"`             // CNI install and rollout
+	JoinTimeout      time.Duration `yaml:"join_timeout" toml:"join_timeout"`           // per host kubeadm join
+	UpgradeTimeout   time.Duration `yaml:"upgrade_timeout" toml:"upgrade_timeout"`     // per host Kubernetes version upgrade
+	MachineTimeout   time.Duration `yaml:"machine_timeout" toml:"machine_timeout"`     // per host machine creation, boot and cloud-init
+
+	SSHAgentSocket string `yaml:"ssh_agent_socket" toml:"ssh_agent_socket"` // agent used by hosts with ssh_agent set
+
+	HTTPProxy  string `yaml:"http_proxy" toml:"http_proxy"` // proxy hosts use for package repositories and image pulls
+	HTTPSProxy string `yaml:"https_proxy" toml:"https_proxy"`
+	NoProxy    string `yaml:"no_proxy" toml:"no_proxy"`
+
+	NetworkCheck      bool   `yaml:"network_check" toml:"network_check"`             // test the pod network once the nodes of a new cluster joined
+	NetworkCheckImage string `yaml:"network_check_image" toml:"network_check_image"` // agnhost image of the network check and smoke test pods, e.g. from a mirror
+	Verify            bool   `yaml:"verify" toml:"verify"`                           // smoke test new clusters before they are ready
+
+	PluginDir string `yaml:"plugin_dir" toml:"plugin_dir"` // directory of kubeforge-provisioner-<name> executables, read at startup
+}
+
+// AuthConfig contains API authentication settings
+type AuthConfig struct {
+	JWTSecret       string        `yaml:"jwt_secret" toml:"jwt_secret"`               // HMAC key for access tokens shared by all replicas, required unless the database is sqlite
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`   // lifetime of access tokens
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"` // lifetime of refresh tokens
+	AdminUsername   string        `yaml:"admin_username" toml:"admin_username"`       // initial admin created when no users exist
+	AdminEmail      string        `yaml:"admin_email" toml:"admin_email"`
+	AdminPassword   string        `yaml:"admin_password" toml:"admin_password"` // generated and logged once if empty
+
+	KubeconfigAccess string        `yaml:"kubeconfig_access" toml:"kubeconfig_access"`   // admin serves the cluster-admin kubeconfig, short-lived mints a credential per download
+	KubeconfigTTL    time.Duration `yaml:"kubeconfig_ttl" toml:"kubeconfig_ttl"`         // default lifetime of short-lived kubeconfigs
+	KubeconfigMaxTTL time.Duration `yaml:"kubeconfig_max_ttl" toml:"kubeconfig_max_ttl"` // longest lifetime a caller may request
+	KubeconfigRole   string        `yaml:"kubeconfig_role" toml:"kubeconfig_role"`       // default cluster role of short-lived kubeconfigs
+}
+
+// AuditConfig contains settings for exporting the audit log
+type AuditConfig struct {
+	Sink string `yaml:"sink" toml:"sink"` // "" to keep entries in the database only, file or syslog
+	File string `yaml:"file" toml:"file"` // path of the file sink
+}
+
+// NotifyConfig contains notification channels configured from the
+// environment and the mail server used by email channels
+type NotifyConfig struct {
+	SlackWebhook string `yaml:"slack_webhook" toml:"slack_webhook"` // webhook of the "slack" channel
+	TeamsWebhook string `yaml:"teams_webhook" toml:"teams_webhook"` // webhook of the "teams" channel
+	EmailTo      string `yaml:"email_to" toml:"email_to"`           // comma separated recipients of the "email" channel
+	Template     string `yaml:"template" toml:"template"`           // text/template for messages of these channels
+
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+	SMTPFrom     string `yaml:"smtp_from" toml:"smtp_from"`
+}
+
+// RetentionConfig limits how many cluster events are kept, where pruned
+// events are archived and how long deleted records are kept
+type RetentionConfig struct {
+	EventMaxAge        time.Duration `yaml:"event_max_age" toml:"event_max_age"`                 // 0 keeps events regardless of age
+	EventMaxPerCluster int           `yaml:"event_max_per_cluster" toml:"event_max_per_cluster"` // 0 keeps any number of events per cluster
+	DeletedMaxAge      time.Duration `yaml:"deleted_max_age" toml:"deleted_max_age"`             // deleted clusters, hosts and SSH keys are purged after this, 0 keeps them
+	Interval           time.Duration `yaml:"interval" toml:"interval"`                           // how often events are pruned
+
+	Archive    string `yaml:"archive" toml:"archive"`         // "" to delete without archiving, file or s3
+	ArchiveDir string `yaml:"archive_dir" toml:"archive_dir"` // directory of the file archive
+
+	S3Endpoint  string `yaml:"s3_endpoint" toml:"s3_endpoint"` // defaults to AWS S3 in S3Region
+	S3Region    string `yaml:"s3_region" toml:"s3_region"`
+	S3Bucket    string `yaml:"s3_bucket" toml:"s3_bucket"`
+	S3Prefix    string `yaml:"s3_prefix" toml:"s3_prefix"`
+	S3AccessKey string `yaml:"s3_access_key" toml:"s3_access_key"`
+	S3SecretKey string `yaml:"s3_secret_key" toml:"s3_secret_key"`
 }
 
-// Load reads configuration from environment variables with sensible defaults
-func Load() *Config {
+// MetricsConfig contains settings for collecting node resource usage
+type MetricsConfig struct {
+	Interval  time.Duration `yaml:"interval" toml:"interval"`   // how often usage is sampled, 0 to disable
+	Retention time.Duration `yaml:"retention" toml:"retention"` // how long samples are kept
+}
+
+// AlertsConfig contains the alert rules evaluated on ready clusters. A
+// threshold of 0 disables its rule.
+type AlertsConfig struct {
+	Interval          time.Duration `yaml:"interval" toml:"interval"`                     // how often rules are evaluated, 0 to disable alerting
+	NodeNotReady      time.Duration `yaml:"node_not_ready" toml:"node_not_ready"`         // a node NotReady for longer fires
+	CertificateExpiry time.Duration `yaml:"certificate_expiry" toml:"certificate_expiry"` // a certificate expiring sooner fires
+	BackupMissing     time.Duration `yaml:"backup_missing" toml:"backup_missing"`         // no completed Velero backup for longer fires
+}
+
+// VersionsConfig contains settings for the catalog of Kubernetes releases
+type VersionsConfig struct {
+	Feed            string        `yaml:"feed" toml:"feed"`                         // base URL serving stable-<minor>.txt
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval"` // how often patches are read from the feed, 0 keeps the built-in catalog
+}
+
+// TracingConfig contains OpenTelemetry trace export settings
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`         // "" to disable tracing, otlp
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`         // host:port of the OTLP/HTTP collector
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`         // send spans over plain HTTP
+	ServiceName string  `yaml:"service_name" toml:"service_name"` // service.name of the exported spans
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"` // fraction of traces recorded
+}
+
+// InfraConfig contains the credentials of the infrastructure providers
+// machines are created on
+type InfraConfig struct {
+	ProxmoxURL                   string `yaml:"proxmox_url" toml:"proxmox_url"`           // e.g. https://pve.example.com:8006
+	ProxmoxTokenID               string `yaml:"proxmox_token_id" toml:"proxmox_token_id"` // API token, USER@REALM!TOKENID
+	ProxmoxTokenSecret           string `yaml:"proxmox_token_secret" toml:"proxmox_token_secret"`
+	ProxmoxInsecureSkipTLSVerify bool   `yaml:"proxmox_insecure_skip_tls_verify" toml:"proxmox_insecure_skip_tls_verify"` // accept a self-signed certificate
+	LibvirtURI                   string `yaml:"libvirt_uri" toml:"libvirt_uri"`                                           // e.g. qemu:///system or qemu+ssh://root@kvm1/system
+	VSphereURL                   string `yaml:"vsphere_url" toml:"vsphere_url"`                                           // vCenter, e.g. https://vcenter.example.com
+	VSphereUsername              string `yaml:"vsphere_username" toml:"vsphere_username"`
+	VSpherePassword              string `yaml:"vsphere_password" toml:"vsphere_password"`
+	VSphereInsecureSkipTLSVerify bool   `yaml:"vsphere_insecure_skip_tls_verify" toml:"vsphere_insecure_skip_tls_verify"`
+}
+
+// SecretsConfig contains settings for encrypting secrets at rest
+type SecretsConfig struct {
+	EncryptionKey string `yaml:"encryption_key" toml:"encryption_key"` // base64 encoded 32 byte AES master key
+	KeyFile       string `yaml:"key_file" toml:"key_file"`             // file with the master key and previous keys, one per line
+	PreviousKeys  string `yaml:"previous_keys" toml:"previous_keys"`   // comma separated keys still accepted for decryption
+
+	// Provider wraps data keys with an external master key instead: "" for
+	// the local key, vault, awskms or gcpkms. A local key stays readable.
+	Provider string `yaml:"provider" toml:"provider"`
+
+	VaultAddr       string `yaml:"vault_addr" toml:"vault_addr"`
+	VaultToken      string `yaml:"vault_token" toml:"vault_token"`
+	VaultTokenFile  string `yaml:"vault_token_file" toml:"vault_token_file"` // e.g. written by a Vault agent
+	VaultNamespace  string `yaml:"vault_namespace" toml:"vault_namespace"`
+	VaultTransit    string `yaml:"vault_transit_mount" toml:"vault_transit_mount"`
+	VaultTransitKey string `yaml:"vault_transit_key" toml:"vault_transit_key"`
+
+	AWSKMSKey       string `yaml:"aws_kms_key" toml:"aws_kms_key"` // key ID, ARN or alias/name
+	AWSRegion       string `yaml:"aws_region" toml:"aws_region"`
+	AWSKMSEndpoint  string `yaml:"aws_kms_endpoint" toml:"aws_kms_endpoint"`
+	AWSAccessKey    string `yaml:"aws_access_key_id" toml:"aws_access_key_id"`
+	AWSSecretKey    string `yaml:"aws_secret_access_key" toml:"aws_secret_access_key"`
+	AWSSessionToken string `yaml:"aws_session_token" toml:"aws_session_token"`
+
+	GCPKMSKey         string `yaml:"gcp_kms_key" toml:"gcp_kms_key"`                   // projects/P/locations/L/keyRings/R/cryptoKeys/K
+	GCPCredentialFile string `yaml:"gcp_credentials_file" toml:"gcp_credentials_file"` // service account key, else the metadata server
+}
+
+// Load builds the configuration from defaults, the config file at path, if
+// any, and environment variables, each overriding the previous
+func Load(path string) (*Config, error) {
+	c := defaults()
+	if path != "" {
+		if err := loadFile(path, c); err != nil {
+			return nil, err
+		}
+	}
+	applyEnv(c)
+	return c, nil
+}
+
+// defaults returns the configuration used when nothing is set
+func defaults() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Host:            getEnv("SERVER_HOST", "0.0.0.0"),
-			Port:            getEnv("SERVER_PORT", "8080"),
-			ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
-			WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
-			ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 10*time.Second),
+			Host:            "0.0.0.0",
+			Port:            "8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
 		},
 		Database: DatabaseConfig{
-			Driver: getEnv("DB_DRIVER", "sqlite"),
-			DSN:    getEnv("DB_DSN", "kubeforge.db"),
+			Driver:             "sqlite",
+			DSN:                "kubeforge.db",
+			AutoMigrate:        true,
+			LogLevel:           "warn",
+			SlowQueryThreshold: 200 * time.Millisecond,
 		},
 		Logger: LoggerConfig{
-			Level:  getEnv("LOG_LEVEL", "info"),
-			Format: getEnv("LOG_FORMAT", "console"),
+			Level:  "info",
+			Format: "console",
+		},
+		Secrets: SecretsConfig{
+			VaultTransit:    "transit",
+			VaultTransitKey: "kubeforge",
+		},
+		Jobs: JobsConfig{
+			Workers:      4,
+			PollInterval: 5 * time.Second,
+			InstanceID:   defaultInstanceID(),
+			LeaseTTL:     time.Minute,
+
+			ShutdownTimeout: 5 * time.Minute,
+
+			StuckClusterTimeout: 3 * time.Hour,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 7 * 24 * time.Hour,
+			AdminUsername:   "admin",
+			AdminEmail:      "admin@kubeforge.local",
+
+			KubeconfigAccess: "admin",
+			KubeconfigTTL:    time.Hour,
+			KubeconfigMaxTTL: 24 * time.Hour,
+			KubeconfigRole:   "cluster-admin",
+		},
+		Audit: AuditConfig{
+			File: "audit.log",
+		},
+		Notify: NotifyConfig{
+			SMTPPort: 587,
+			SMTPFrom: "kubeforge@localhost",
+		},
+		Retention: RetentionConfig{
+			EventMaxAge: 90 * 24 * time.Hour,
+			Interval:    time.Hour,
+			ArchiveDir:  "event-archive",
+			S3Region:    "us-east-1",
+			S3Prefix:    "kubeforge/events/",
+		},
+		Metrics: MetricsConfig{
+			Interval:  time.Minute,
+			Retention: 7 * 24 * time.Hour,
+		},
+		Alerts: AlertsConfig{
+			Interval:          time.Minute,
+			NodeNotReady:      5 * time.Minute,
+			CertificateExpiry: 14 * 24 * time.Hour,
+			BackupMissing:     24 * time.Hour,
+		},
+		Versions: VersionsConfig{
+			Feed: "https://dl.k8s.io/release",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "kubeforge",
+			SampleRatio: 1,
+		},
+		Provision: ProvisionConfig{
+			MaxParallelHosts: 10,
+			MaxConcurrentSSH: 50,
+
+			RetryAttempts:       3,
+			RetryInitialBackoff: 5 * time.Second,
+			RetryMaxBackoff:     time.Minute,
+
+			PrepareTimeout:   30 * time.Minute,
+			BootstrapTimeout: 15 * time.Minute,
+			CNITimeout:       10 * time.Minute,
+			JoinTimeout:      10 * time.Minute,
+			UpgradeTimeout:   20 * time.Minute,
+			MachineTimeout:   15 * time.Minute,
+
+			NetworkCheck: true,
+			Verify:       true,
 		},
 	}
 }
 
+// applyEnv overrides settings with the environment variables that are set
+func applyEnv(c *Config) {
+	c.Server.Host = getEnv("SERVER_HOST", c.Server.Host)
+	c.Server.Port = getEnv("SERVER_PORT", c.Server.Port)
+	c.Server.GRPCPort = getEnv("GRPC_PORT", c.Server.GRPCPort)
+	c.Server.ReadTimeout = getDurationEnv("SERVER_READ_TIMEOUT", c.Server.ReadTimeout)
+	c.Server.WriteTimeout = getDurationEnv("SERVER_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	c.Server.ShutdownTimeout = getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+
+	c.Database.Driver = getEnv("DB_DRIVER", c.Database.Driver)
+	c.Database.DSN = getEnv("DB_DSN", c.Database.DSN)
+	c.Database.AutoMigrate = getBoolEnv("DB_AUTO_MIGRATE", c.Database.AutoMigrate)
+	c.Database.MaxOpenConns = getIntEnv("DB_MAX_OPEN_CONNS", c.Database.MaxOpenConns)
+	c.Database.MaxIdleConns = getIntEnv("DB_MAX_IDLE_CONNS", c.Database.MaxIdleConns)
+	c.Database.ConnMaxLifetime = getDurationEnv("DB_CONN_MAX_LIFETIME", c.Database.ConnMaxLifetime)
+	c.Database.LogLevel = getEnv("DB_LOG_LEVEL", c.Database.LogLevel)
+	c.Database.SlowQueryThreshold = getDurationEnv("DB_SLOW_QUERY_THRESHOLD", c.Database.SlowQueryThreshold)
+
+	c.Logger.Level = getEnv("LOG_LEVEL", c.Logger.Level)
+	c.Logger.Format = getEnv("LOG_FORMAT", c.Logger.Format)
+
+	c.Secrets.EncryptionKey = getEnv("ENCRYPTION_KEY", c.Secrets.EncryptionKey)
+	c.Secrets.KeyFile = getEnv("ENCRYPTION_KEY_FILE", c.Secrets.KeyFile)
+	c.Secrets.PreviousKeys = getEnv("ENCRYPTION_PREVIOUS_KEYS", c.Secrets.PreviousKeys)
+	c.Secrets.Provider = getEnv("SECRETS_PROVIDER", c.Secrets.Provider)
+	c.Secrets.VaultAddr = getEnv("VAULT_ADDR", c.Secrets.VaultAddr)
+	c.Secrets.VaultToken = getEnv("VAULT_TOKEN", c.Secrets.VaultToken)
+	c.Secrets.VaultTokenFile = getEnv("VAULT_TOKEN_FILE", c.Secrets.VaultTokenFile)
+	c.Secrets.VaultNamespace = getEnv("VAULT_NAMESPACE", c.Secrets.VaultNamespace)
+	c.Secrets.VaultTransit = getEnv("VAULT_TRANSIT_MOUNT", c.Secrets.VaultTransit)
+	c.Secrets.VaultTransitKey = getEnv("VAULT_TRANSIT_KEY", c.Secrets.VaultTransitKey)
+	c.Secrets.AWSKMSKey = getEnv("AWS_KMS_KEY_ID", c.Secrets.AWSKMSKey)
+	c.Secrets.AWSRegion = getEnv("AWS_REGION", c.Secrets.AWSRegion)
+	c.Secrets.AWSKMSEndpoint = getEnv("AWS_KMS_ENDPOINT", c.Secrets.AWSKMSEndpoint)
+	c.Secrets.AWSAccessKey = getEnv("AWS_ACCESS_KEY_ID", c.Secrets.AWSAccessKey)
+	c.Secrets.AWSSecretKey = getEnv("AWS_SECRET_ACCESS_KEY", c.Secrets.AWSSecretKey)
+	c.Secrets.AWSSessionToken = getEnv("AWS_SESSION_TOKEN", c.Secrets.AWSSessionToken)
+	c.Secrets.GCPKMSKey = getEnv("GCP_KMS_KEY", c.Secrets.GCPKMSKey)
+	c.Secrets.GCPCredentialFile = getEnv("GOOGLE_APPLICATION_CREDENTIALS", c.Secrets.GCPCredentialFile)
+
+	c.Jobs.Workers = getIntEnv("JOB_WORKERS", c.Jobs.Workers)
+	c.Jobs.PollInterval = getDurationEnv("JOB_POLL_INTERVAL", c.Jobs.PollInterval)
+	c.Jobs.InstanceID = getEnv("INSTANCE_ID", c.Jobs.InstanceID)
+	c.Jobs.LeaseTTL = getDurationEnv("JOB_LEASE_TTL", c.Jobs.LeaseTTL)
+	c.Jobs.ShutdownTimeout = getDurationEnv("JOB_SHUTDOWN_TIMEOUT", c.Jobs.ShutdownTimeout)
+	c.Jobs.StuckClusterTimeout = getDurationEnv("STUCK_CLUSTER_TIMEOUT", c.Jobs.StuckClusterTimeout)
+
+	c.Auth.JWTSecret = getEnv("JWT_SECRET", c.Auth.JWTSecret)
+	c.Auth.AccessTokenTTL = getDurationEnv("ACCESS_TOKEN_TTL", c.Auth.AccessTokenTTL)
+	c.Auth.RefreshTokenTTL = getDurationEnv("REFRESH_TOKEN_TTL", c.Auth.RefreshTokenTTL)
+	c.Auth.AdminUsername = getEnv("ADMIN_USERNAME", c.Auth.AdminUsername)
+	c.Auth.AdminEmail = getEnv("ADMIN_EMAIL", c.Auth.AdminEmail)
+	c.Auth.AdminPassword = getEnv("ADMIN_PASSWORD", c.Auth.AdminPassword)
+	c.Auth.KubeconfigAccess = getEnv("KUBECONFIG_ACCESS", c.Auth.KubeconfigAccess)
+	c.Auth.KubeconfigTTL = getDurationEnv("KUBECONFIG_TTL", c.Auth.KubeconfigTTL)
+	c.Auth.KubeconfigMaxTTL = getDurationEnv("KUBECONFIG_MAX_TTL", c.Auth.KubeconfigMaxTTL)
+	c.Auth.KubeconfigRole = getEnv("KUBECONFIG_ROLE", c.Auth.KubeconfigRole)
+
+	c.Audit.Sink = getEnv("AUDIT_SINK", c.Audit.Sink)
+	c.Audit.File = getEnv("AUDIT_FILE", c.Audit.File)
+
+	c.Notify.SlackWebhook = getEnv("NOTIFY_SLACK_WEBHOOK", c.Notify.SlackWebhook)
+	c.Notify.TeamsWebhook = getEnv("NOTIFY_TEAMS_WEBHOOK", c.Notify.TeamsWebhook)
+	c.Notify.EmailTo = getEnv("NOTIFY_EMAIL_TO", c.Notify.EmailTo)
+	c.Notify.Template = getEnv("NOTIFY_TEMPLATE", c.Notify.Template)
+	c.Notify.SMTPHost = getEnv("SMTP_HOST", c.Notify.SMTPHost)
+	c.Notify.SMTPPort = getIntEnv("SMTP_PORT", c.Notify.SMTPPort)
+	c.Notify.SMTPUsername = getEnv("SMTP_USERNAME", c.Notify.SMTPUsername)
+	c.Notify.SMTPPassword = getEnv("SMTP_PASSWORD", c.Notify.SMTPPassword)
+	c.Notify.SMTPFrom = getEnv("SMTP_FROM", c.Notify.SMTPFrom)
+
+	c.Retention.EventMaxAge = getDurationEnv("EVENT_RETENTION_MAX_AGE", c.Retention.EventMaxAge)
+	c.Retention.EventMaxPerCluster = getIntEnv("EVENT_RETENTION_MAX_PER_CLUSTER", c.Retention.EventMaxPerCluster)
+	c.Retention.DeletedMaxAge = getDurationEnv("DELETED_RETENTION_MAX_AGE", c.Retention.DeletedMaxAge)
+	c.Retention.Interval = getDurationEnv("EVENT_RETENTION_INTERVAL", c.Retention.Interval)
+	c.Retention.Archive = getEnv("EVENT_ARCHIVE", c.Retention.Archive)
+	c.Retention.ArchiveDir = getEnv("EVENT_ARCHIVE_DIR", c.Retention.ArchiveDir)
+	c.Retention.S3Endpoint = getEnv("EVENT_ARCHIVE_S3_ENDPOINT", c.Retention.S3Endpoint)
+	c.Retention.S3Region = getEnv("EVENT_ARCHIVE_S3_REGION", c.Retention.S3Region)
+	c.Retention.S3Bucket = getEnv("EVENT_ARCHIVE_S3_BUCKET", c.Retention.S3Bucket)
+	c.Retention.S3Prefix = getEnv("EVENT_ARCHIVE_S3_PREFIX", c.Retention.S3Prefix)
+	c.Retention.S3AccessKey = getEnv("EVENT_ARCHIVE_S3_ACCESS_KEY", c.Retention.S3AccessKey)
+	c.Retention.S3SecretKey = getEnv("EVENT_ARCHIVE_S3_SECRET_KEY", c.Retention.S3SecretKey)
+
+	c.Metrics.Interval = getDurationEnv("METRICS_INTERVAL", c.Metrics.Interval)
+	c.Metrics.Retention = getDurationEnv("METRICS_RETENTION", c.Metrics.Retention)
+
+	c.Alerts.Interval = getDurationEnv("ALERTS_INTERVAL", c.Alerts.Interval)
+	c.Alerts.NodeNotReady = getDurationEnv("ALERT_NODE_NOT_READY", c.Alerts.NodeNotReady)
+	c.Alerts.CertificateExpiry = getDurationEnv("ALERT_CERTIFICATE_EXPIRY", c.Alerts.CertificateExpiry)
+	c.Alerts.BackupMissing = getDurationEnv("ALERT_BACKUP_MISSING", c.Alerts.BackupMissing)
+
+	c.Versions.Feed = getEnv("VERSIONS_FEED", c.Versions.Feed)
+	c.Versions.RefreshInterval = getDurationEnv("VERSIONS_REFRESH_INTERVAL", c.Versions.RefreshInterval)
+
+	c.Tracing.Exporter = getEnv("TRACING_EXPORTER", c.Tracing.Exporter)
+	c.Tracing.Endpoint = getEnv("TRACING_ENDPOINT", c.Tracing.Endpoint)
+	c.Tracing.Insecure = getBoolEnv("TRACING_INSECURE", c.Tracing.Insecure)
+	c.Tracing.ServiceName = getEnv("TRACING_SERVICE_NAME", c.Tracing.ServiceName)
+	c.Tracing.SampleRatio = getFloatEnv("TRACING_SAMPLE_RATIO", c.Tracing.SampleRatio)
+
+	c.Provision.MaxParallelHosts = getIntEnv("PROVISION_MAX_PARALLEL_HOSTS", c.Provision.MaxParallelHosts)
+	c.Provision.MaxConcurrentSSH = getIntEnv("PROVISION_MAX_CONCURRENT_SSH", c.Provision.MaxConcurrentSSH)
+	c.Provision.RetryAttempts = getIntEnv("PROVISION_RETRY_ATTEMPTS", c.Provision.RetryAttempts)
+	c.Provision.RetryInitialBackoff = getDurationEnv("PROVISION_RETRY_BACKOFF", c.Provision.RetryInitialBackoff)
+	c.Provision.RetryMaxBackoff = getDurationEnv("PROVISION_RETRY_MAX_BACKOFF", c.Provision.RetryMaxBackoff)
+	c.Provision.PrepareTimeout = getDurationEnv("PROVISION_PREPARE_TIMEOUT", c.Provision.PrepareTimeout)
+	c.Provision.BootstrapTimeout = getDurationEnv("PROVISION_BOOTSTRAP_TIMEOUT", c.Provision.BootstrapTimeout)
+	c.Provision.CNITimeout = getDurationEnv("PROVISION_CNI_TIMEOUT", c.Provision.CNITimeout)
+	c.Provision.JoinTimeout = getDurationEnv("PROVISION_JOIN_TIMEOUT", c.Provision.JoinTimeout)
+	c.Provision.UpgradeTimeout = getDurationEnv("PROVISION_UPGRADE_TIMEOUT", c.Provision.UpgradeTimeout)
+	c.Provision.MachineTimeout = getDurationEnv("PROVISION_MACHINE_TIMEOUT", c.Provision.MachineTimeout)
+	c.Provision.SSHAgentSocket = getEnv("SSH_AUTH_SOCK", c.Provision.SSHAgentSocket)
+	c.Provision.HTTPProxy = getEnv("PROVISION_HTTP_PROXY", c.Provision.HTTPProxy)
+	c.Provision.HTTPSProxy = getEnv("PROVISION_HTTPS_PROXY", c.Provision.HTTPSProxy)
+	c.Provision.NoProxy = getEnv("PROVISION_NO_PROXY", c.Provision.NoProxy)
+	c.Provision.NetworkCheck = getBoolEnv("PROVISION_NETWORK_CHECK", c.Provision.NetworkCheck)
+	c.Provision.NetworkCheckImage = getEnv("PROVISION_NETWORK_CHECK_IMAGE", c.Provision.NetworkCheckImage)
+	c.Provision.Verify = getBoolEnv("PROVISION_VERIFY", c.Provision.Verify)
+	c.Provision.PluginDir = getEnv("PROVISIONER_PLUGIN_DIR", c.Provision.PluginDir)
+
+	c.Infra.ProxmoxURL = getEnv("PROXMOX_URL", c.Infra.ProxmoxURL)
+	c.Infra.ProxmoxTokenID = getEnv("PROXMOX_TOKEN_ID", c.Infra.ProxmoxTokenID)
+	c.Infra.ProxmoxTokenSecret = getEnv("PROXMOX_TOKEN_SECRET", c.Infra.ProxmoxTokenSecret)
+	c.Infra.ProxmoxInsecureSkipTLSVerify = getBoolEnv("PROXMOX_INSECURE_SKIP_TLS_VERIFY", c.Infra.ProxmoxInsecureSkipTLSVerify)
+	c.Infra.LibvirtURI = getEnv("LIBVIRT_URI", c.Infra.LibvirtURI)
+	c.Infra.VSphereURL = getEnv("VSPHERE_URL", c.Infra.VSphereURL)
+	c.Infra.VSphereUsername = getEnv("VSPHERE_USERNAME", c.Infra.VSphereUsername)
+	c.Infra.VSpherePassword = getEnv("VSPHERE_PASSWORD", c.Infra.VSpherePassword)
+	c.Infra.VSphereInsecureSkipTLSVerify = getBoolEnv("VSPHERE_INSECURE_SKIP_TLS_VERIFY", c.Infra.VSphereInsecureSkipTLSVerify)
+}
+
 // Helper functions
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -70,3 +481,38 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "kubeforge"
+	}
+	return hostname
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
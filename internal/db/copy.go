@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// copyBatchSize is the number of rows read and written at once by
+// CopyDatabase
+const copyBatchSize = 500
+
+// ErrTargetNotEmpty is returned when the target of a copy already has data
+var ErrTargetNotEmpty = errors.New("target database is not empty")
+
+// copyModels lists every model with data worth keeping, referenced models
+// first so foreign keys hold while rows are inserted. Locks are only held
+// by running servers and are not copied. New models must be added here.
+var copyModels = []interface{}{
+	&Project{},
+	&User{},
+	&ProjectMember{},
+	&RefreshToken{},
+	&RegistrationToken{},
+	&SSHKey{},
+	&HostKey{},
+	&ClusterTemplate{},
+	&ClusterPolicy{},
+	&Cluster{},
+	&ClusterStatusChange{},
+	&ClusterRevision{},
+	&KubeconfigCredential{},
+	&Host{},
+	&Node{},
+	&WorkerPool{},
+	&Event{},
+	&MetricSample{},
+	&Alert{},
+	&Scan{},
+	&ScanResult{},
+	&VulnerabilityScan{},
+	&Vulnerability{},
+	&ConformanceRun{},
+	&Job{},
+	&Addon{},
+	&Release{},
+	&AuditLog{},
+	&NotificationChannel{},
+}
+
+// CopyResult is the number of rows copied into a table
+type CopyResult struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+// CopyDatabase copies every row of source, soft-deleted ones included, into
+// target with the same IDs, e.g. to move a SQLite deployment to PostgreSQL.
+// Values go through the models, so each column gets the type of the target
+// database. The target is migrated first and must not have data besides the
+// default project; the copy is written in one transaction and the row
+// counts of both databases are compared once it commits. The source must
+// be migrated and should not change meanwhile, so the servers using it are
+// best stopped.
+func CopyDatabase(source, target *gorm.DB) ([]CopyResult, error) {
+	if pending, err := PendingMigrations(source); err != nil {
+		return nil, err
+	} else if len(pending) > 0 {
+		return nil, fmt.Errorf("source database has pending migrations: %v", pending)
+	}
+	if _, err := Migrate(target); err != nil {
+		return nil, fmt.Errorf("failed to migrate target database: %w", err)
+	}
+
+	var results []CopyResult
+	err := target.Transaction(func(to *gorm.DB) error {
+		if err := checkTargetEmpty(to); err != nil {
+			return err
+		}
+		// Replaced by the projects of the source
+		if err := to.Where("1 = 1").Delete(&Project{}).Error; err != nil {
+			return err
+		}
+
+		// One read transaction, so the copy is a consistent snapshot
+		return source.Transaction(func(from *gorm.DB) error {
+			for _, model := range copyModels {
+				table, err := tableName(from, model)
+				if err != nil {
+					return err
+				}
+				rows, err := copyTable(from, to, model)
+				if err != nil {
+					return fmt.Errorf("failed to copy %s: %w", table, err)
+				}
+				if err := resetSequence(to, model); err != nil {
+					return fmt.Errorf("failed to reset the ID sequence of %s: %w", table, err)
+				}
+				slog.Info("Copied table", "table", table, "rows", rows)
+				results = append(results, CopyResult{Table: table, Rows: rows})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return results, err
+	}
+
+	for i, result := range results {
+		var count int64
+		if err := target.Unscoped().Model(copyModels[i]).Count(&count).Error; err != nil {
+			return results, err
+		}
+		if count != result.Rows {
+			return results, fmt.Errorf("table %s has %d rows in the target, %d were copied", result.Table, count, result.Rows)
+		}
+	}
+	return results, nil
+}
+
+// copyTable copies the rows of a model in batches and returns how many
+func copyTable(from, to *gorm.DB, model interface{}) (int64, error) {
+	batch := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem())).Interface()
+	var rows int64
+	var insertErr error
+	result := from.Unscoped().Model(model).FindInBatches(batch, copyBatchSize, func(tx *gorm.DB, _ int) error {
+		if insertErr = to.Omit(clause.Associations).Create(batch).Error; insertErr != nil {
+			return insertErr
+		}
+		rows += tx.RowsAffected
+		return nil
+	})
+	if insertErr != nil {
+		return rows, insertErr
+	}
+	return rows, result.Error
+}
+
+// checkTargetEmpty fails if a table of the target has rows, other than a
+// single project created by the migrations
+func checkTargetEmpty(tx *gorm.DB) error {
+	for _, model := range copyModels {
+		var count int64
+		if err := tx.Unscoped().Model(model).Count(&count).Error; err != nil {
+			return err
+		}
+		limit := int64(0)
+		if _, ok := model.(*Project); ok {
+			limit = 1
+		}
+		if count > limit {
+			table, _ := tableName(tx, model)
+			return fmt.Errorf("%w: %s has %d rows", ErrTargetNotEmpty, table, count)
+		}
+	}
+	return nil
+}
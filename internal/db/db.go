@@ -1,27 +1,60 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/glebarez/sqlite"
-	"gorm.io/driver/postgres"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-// DB is the global database instance
-var DB *gorm.DB
-
 // Config holds database configuration
 type Config struct {
 	Driver string
 	DSN    string
+
+	// Connection pool, zero values keep the database/sql defaults
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	LogLevel           string        // silent, error, warn, info; default: warn
+	SlowQueryThreshold time.Duration // statements taking longer are logged at warn, 0 disables
 }
 
-// Init initializes the database connection
-func Init(config Config) error {
+// Init connects to the database of the server and reports its schema
+// version
+func Init(config Config) (*gorm.DB, error) {
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pending migrations are applied by Migrate
+	statuses, err := Migrations(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	applied, latest := 0, ""
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied++
+			latest = status.ID
+		}
+	}
+
+	slog.Info("Database initialized", "driver", config.Driver, "migration", latest, "pending_migrations", len(statuses)-applied)
+	return db, nil
+}
+
+// Open connects to a database without reporting its schema, e.g. the target
+// of CopyDatabase
+func Open(config Config) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 
 	switch config.Driver {
@@ -32,52 +65,81 @@ func Init(config Config) error {
 	case "mysql":
 		dialector = mysql.Open(config.DSN)
 	default:
-		return fmt.Errorf("unsupported database driver: %s", config.Driver)
+		return nil, fmt.Errorf("unsupported database driver: %s", config.Driver)
+	}
+
+	if config.LogLevel == "" {
+		config.LogLevel = "warn"
+	}
+	level, ok := logLevels[config.LogLevel]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database log level %q, expected silent, error, warn or info", config.LogLevel)
 	}
 
 	db, err := gorm.Open(dialector, &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: &slogLogger{level: level, slowThreshold: config.SlowQueryThreshold},
+		// Unique violations become gorm.ErrDuplicatedKey on every driver
+		TranslateError: true,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	DB = db
-
-	// Run migrations
-	if err := runMigrations(); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-
-	log.Println("Database initialized successfully")
-	return nil
+	if config.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	}
+	return db, nil
 }
 
-// runMigrations runs all database migrations
-func runMigrations() error {
-	return DB.AutoMigrate(
-		&Cluster{},
-		&Node{},
-		&Event{},
-		&SSHKey{},
-		&User{},
-		&Job{},
-	)
+// Ping checks that the database answers
+func Ping(ctx context.Context, conn *gorm.DB) error {
+	if conn == nil {
+		return errors.New("database not initialized")
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
 }
 
-// Close closes the database connection
-func Close() error {
-	if DB != nil {
-		sqlDB, err := DB.DB()
-		if err != nil {
-			return err
-		}
-		return sqlDB.Close()
+// DefaultProjectName is the project clusters belong to unless another is chosen
+const DefaultProjectName = "default"
+
+// Close closes a database connection
+func Close(conn *gorm.DB) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
 	}
-	return nil
+	return sqlDB.Close()
 }
 
-// GetDB returns the database instance
-func GetDB() *gorm.DB {
-	return DB
+// FindSSHKey looks up a stored SSH key by ID or, when id is 0, by name
+func FindSSHKey(conn *gorm.DB, id uint, name string) (*SSHKey, error) {
+	var key SSHKey
+	query := conn.Where("name = ?", name)
+	if id != 0 {
+		query = conn.Where("id = ?", id)
+	}
+	if err := query.First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if id != 0 {
+				return nil, fmt.Errorf("SSH key %d not found", id)
+			}
+			return nil, fmt.Errorf("SSH key %q not found", name)
+		}
+		return nil, err
+	}
+	return &key, nil
 }
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"kubeforge/internal/secrets"
+)
+
+// encryptedPrefix marks a column value sealed by the encrypted serializer.
+// Values without it are plaintext written before encryption was configured.
+const encryptedPrefix = "kfe:"
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// EncryptedSerializer encrypts string and []byte columns tagged with
+// `gorm:"serializer:encrypted"` at rest. Without a configured key values are
+// stored as plaintext and encrypted by the next write once a key is set.
+//
+// Serializers only apply to model and struct writes: updates through a map
+// or Update(column, value) store the value as is.
+type EncryptedSerializer struct{}
+
+// Scan decrypts a column value into the field
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored []byte
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		stored = v
+	case string:
+		stored = []byte(v)
+	default:
+		return fmt.Errorf("unsupported value %T for encrypted field %s", dbValue, field.Name)
+	}
+
+	plaintext, err := openValue(stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+	}
+
+	if field.FieldType.Kind() == reflect.String {
+		return field.Set(ctx, dst, string(plaintext))
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+// Value encrypts a field for storage
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plaintext []byte
+	switch v := fieldValue.(type) {
+	case string:
+		plaintext = []byte(v)
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		plaintext = v
+	default:
+		return nil, fmt.Errorf("unsupported type %T for encrypted field %s", fieldValue, field.Name)
+	}
+
+	stored, err := sealValue(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", field.Name, err)
+	}
+
+	if field.FieldType.Kind() == reflect.String {
+		return stored, nil
+	}
+	return []byte(stored), nil
+}
+
+// openValue returns the plaintext of a stored column value
+func openValue(stored []byte) ([]byte, error) {
+	if !strings.HasPrefix(string(stored), encryptedPrefix) {
+		return stored, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(stored[len(encryptedPrefix):]))
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Decrypt(sealed)
+}
+
+// sealValue returns the stored form of a column value, encrypted if a key is
+// configured
+func sealValue(plaintext []byte) (string, error) {
+	if len(plaintext) == 0 || !secrets.Enabled() {
+		return string(plaintext), nil
+	}
+	sealed, err := secrets.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// sealedWithCurrentKey reports whether a stored column value needs no
+// re-encryption
+func sealedWithCurrentKey(stored []byte) bool {
+	if !strings.HasPrefix(string(stored), encryptedPrefix) {
+		return false
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(stored[len(encryptedPrefix):]))
+	return err == nil && secrets.Current(sealed)
+}
+
+// migrateBatchSize is the number of rows re-encrypted per query
+const migrateBatchSize = 100
+
+// EncryptSecrets seals the encrypted columns of every row, including deleted
+// ones, with the current key: plaintext written before encryption was
+// configured as well as values sealed with a previous key. Addon credentials
+// and notification channel settings are re-encrypted as well. It returns the
+// number of values rewritten.
+func EncryptSecrets(conn *gorm.DB) (int, error) {
+	if !secrets.Enabled() {
+		return 0, secrets.ErrNoKey
+	}
+	return encryptSecrets(conn)
+}
+
+// encryptSecrets seals the encrypted columns like EncryptSecrets, within tx
+func encryptSecrets(tx *gorm.DB) (int, error) {
+	total := 0
+	for _, model := range []interface{}{&Cluster{}, &ClusterTemplate{}, &ClusterRevision{}, &Node{}, &SSHKey{}, &Job{}} {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return total, err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if _, ok := field.Serializer.(EncryptedSerializer); !ok {
+				continue
+			}
+			binary := field.FieldType.Kind() != reflect.String
+			n, err := rewriteColumn(tx, stmt.Schema.Table, field.DBName, func(stored []byte) (interface{}, bool, error) {
+				if sealedWithCurrentKey(stored) {
+					return nil, false, nil
+				}
+				plaintext, err := openValue(stored)
+				if err != nil {
+					return nil, false, err
+				}
+				value, err := sealValue(plaintext)
+				if binary {
+					return []byte(value), true, err
+				}
+				return value, true, err
+			})
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("failed to encrypt %s.%s: %w", stmt.Schema.Table, field.DBName, err)
+			}
+		}
+	}
+
+	// Columns sealed directly with secrets.Encrypt
+	for table, column := range map[string]string{"addons": "credentials", "notification_channels": "config"} {
+		n, err := rewriteColumn(tx, table, column, func(stored []byte) (interface{}, bool, error) {
+			if secrets.Current(stored) {
+				return nil, false, nil
+			}
+			plaintext, err := secrets.Decrypt(stored)
+			if err != nil {
+				return nil, false, err
+			}
+			sealed, err := secrets.Encrypt(plaintext)
+			return sealed, true, err
+		})
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to re-encrypt %s.%s: %w", table, column, err)
+		}
+	}
+	return total, nil
+}
+
+// rewriteColumn passes the non-empty values of a column to rewrite, page by
+// page, and stores the values it returns as changed
+func rewriteColumn(tx *gorm.DB, table, column string, rewrite func(stored []byte) (interface{}, bool, error)) (int, error) {
+	type row struct {
+		ID    uint
+		Value []byte
+	}
+
+	total := 0
+	var lastID uint
+	for {
+		var rows []row
+		err := tx.Table(table).Select("id, "+column+" AS value").
+			Where("id > ? AND "+column+" IS NOT NULL", lastID).
+			Order("id").Limit(migrateBatchSize).Scan(&rows).Error
+		if err != nil {
+			return total, err
+		}
+
+		for _, r := range rows {
+			lastID = r.ID
+			if len(r.Value) == 0 {
+				continue
+			}
+			value, changed, err := rewrite(r.Value)
+			if err != nil {
+				return total, fmt.Errorf("row %d: %w", r.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := tx.Table(table).Where("id = ?", r.ID).UpdateColumn(column, value).Error; err != nil {
+				return total, err
+			}
+			total++
+		}
+
+		if len(rows) < migrateBatchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job statuses as stored, the jobs package defines them for its callers
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// NewStore returns stores backed by a GORM connection
+func NewStore(conn *gorm.DB) *Store {
+	return &Store{
+		Clusters: gormClusters{conn},
+		Nodes:    gormNodes{conn},
+		Jobs:     gormJobs{conn},
+		Events:   gormEvents{conn},
+		conn:     conn,
+		transaction: func(fn func(tx *Store) error) error {
+			return conn.Transaction(func(tx *gorm.DB) error {
+				return fn(NewStore(tx))
+			})
+		},
+	}
+}
+
+// gormClusters is the ClusterStore of a GORM connection
+type gormClusters struct {
+	conn *gorm.DB
+}
+
+func (s gormClusters) Get(id uint) (*Cluster, error) {
+	var cluster Cluster
+	if err := s.conn.First(&cluster, id).Error; err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+func (s gormClusters) GetWithNodes(id uint) (*Cluster, error) {
+	var cluster Cluster
+	if err := s.conn.Preload("Nodes").First(&cluster, id).Error; err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+func (s gormClusters) GetDetails(id uint) (*Cluster, error) {
+	var cluster Cluster
+	err := s.conn.Preload("Nodes").Preload("Events").Preload("StatusHistory", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	}).First(&cluster, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+func (s gormClusters) List(filter ClusterFilter) ([]Cluster, error) {
+	query := s.conn.Order("id")
+	if filter.IncludeDeleted {
+		query = query.Unscoped()
+	}
+	if filter.IDs != nil {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if filter.ProjectIDs != nil {
+		query = query.Where("project_id IN ?", filter.ProjectIDs)
+	}
+	if filter.WithNodes {
+		query = query.Preload("Nodes")
+	}
+	var clusters []Cluster
+	return clusters, query.Find(&clusters).Error
+}
+
+func (s gormClusters) FindByIdempotencyKey(projectID uint, key string) (*Cluster, error) {
+	var cluster Cluster
+	if err := s.conn.Where("project_id = ? AND idempotency_key = ?", projectID, key).First(&cluster).Error; err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+func (s gormClusters) NameTaken(name string) (bool, error) {
+	var count int64
+	err := s.conn.Model(&Cluster{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+func (s gormClusters) Create(cluster *Cluster) error {
+	return CreateCluster(s.conn, cluster)
+}
+
+func (s gormClusters) Update(cluster *Cluster, fields ...string) error {
+	// A struct update so secret fields are encrypted
+	return s.conn.Model(&Cluster{ID: cluster.ID}).Select(fields).Updates(cluster).Error
+}
+
+func (s gormClusters) SetStatus(id uint, status ClusterStatus, message string) error {
+	return setClusterStatus(s.conn, id, status, message, false)
+}
+
+func (s gormClusters) ForceStatus(id uint, status ClusterStatus, message string) error {
+	return setClusterStatus(s.conn, id, status, message, true)
+}
+
+func (s gormClusters) Delete(id uint) error {
+	return deleteCluster(s.conn, id)
+}
+
+func (s gormClusters) Restore(id uint) error {
+	return restoreCluster(s.conn, id)
+}
+
+// gormNodes is the NodeStore of a GORM connection
+type gormNodes struct {
+	conn *gorm.DB
+}
+
+func (s gormNodes) List(clusterID uint) ([]Node, error) {
+	var nodes []Node
+	return nodes, s.conn.Where("cluster_id = ?", clusterID).Order("id").Find(&nodes).Error
+}
+
+func (s gormNodes) ListByRole(clusterID uint, role string) ([]Node, error) {
+	var nodes []Node
+	return nodes, s.conn.Where("cluster_id = ? AND role = ?", clusterID, role).Order("id").Find(&nodes).Error
+}
+
+func (s gormNodes) ListMachines(clusterID uint) ([]Node, error) {
+	var nodes []Node
+	return nodes, s.conn.Where("cluster_id = ? AND machine_id <> ?", clusterID, "").Order("id").Find(&nodes).Error
+}
+
+func (s gormNodes) Get(clusterID, id uint) (*Node, error) {
+	var node Node
+	if err := s.conn.Where("cluster_id = ?", clusterID).First(&node, id).Error; err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (s gormNodes) FindByAddress(clusterID uint, address string) (*Node, error) {
+	var node Node
+	if err := s.conn.Where("cluster_id = ? AND address = ?", clusterID, address).First(&node).Error; err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (s gormNodes) Create(node *Node) error {
+	return s.conn.Create(node).Error
+}
+
+func (s gormNodes) Update(node *Node, fields ...string) error {
+	return s.conn.Model(&Node{ID: node.ID}).Select(fields).Updates(node).Error
+}
+
+func (s gormNodes) Delete(node *Node) error {
+	return s.conn.Unscoped().Delete(node).Error
+}
+
+// gormJobs is the JobStore of a GORM connection
+type gormJobs struct {
+	conn *gorm.DB
+}
+
+func (s gormJobs) Get(id uint) (*Job, error) {
+	var job Job
+	if err := s.conn.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s gormJobs) List(filter JobFilter) ([]Job, error) {
+	query := s.conn.Order("id desc")
+	if filter.ClusterID != 0 {
+		query = query.Where("cluster_id = ?", filter.ClusterID)
+	}
+	if filter.ProjectIDs != nil {
+		clusters := s.conn.Unscoped().Model(&Cluster{}).Select("id").Where("project_id IN ?", filter.ProjectIDs)
+		query = query.Where("cluster_id IN (?)", clusters)
+	}
+	if filter.ParentID != 0 {
+		query = query.Where("parent_id = ?", filter.ParentID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var jobs []Job
+	return jobs, query.Find(&jobs).Error
+}
+
+func (s gormJobs) Create(job *Job) error {
+	return s.conn.Transaction(func(tx *gorm.DB) error {
+		if job.ClusterID != 0 {
+			var existing Job
+			err := tx.Where("cluster_id = ? AND type = ? AND target = ? AND status IN ?", job.ClusterID, job.Type, job.Target, []string{jobPending, jobRunning}).
+				First(&existing).Error
+			if err == nil {
+				*job = existing
+				return ErrActiveJob
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		job.Status = jobPending
+		job.Progress = 0
+		job.CreatedAt = time.Now()
+		job.UpdatedAt = time.Now()
+		return tx.Create(job).Error
+	})
+}
+
+func (s gormJobs) FirstClaimable() (*Job, error) {
+	var job Job
+	err := s.conn.Where("status = ?", jobPending).
+		Where("cluster_id = 0 OR cluster_id IS NULL OR NOT EXISTS (?)",
+			s.conn.Table("jobs AS r").Select("1").Where("r.cluster_id = jobs.cluster_id AND r.status = ?", jobRunning)).
+		Order("id").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s gormJobs) Claim(id uint, workerID string, at time.Time) (bool, error) {
+	result := s.conn.Model(&Job{}).
+		Where("id = ? AND status = ?", id, jobPending).
+		Updates(map[string]interface{}{
+			"status":       jobRunning,
+			"started_at":   &at,
+			"worker_id":    workerID,
+			"heartbeat_at": &at,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s gormJobs) Renew(id uint, workerID string, at time.Time) (bool, error) {
+	result := s.conn.Model(&Job{}).
+		Where("id = ? AND status = ? AND worker_id = ?", id, jobRunning, workerID).
+		Update("heartbeat_at", &at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s gormJobs) Requeue(id uint, workerID string) error {
+	return s.conn.Model(&Job{}).
+		Where("id = ? AND status = ? AND worker_id = ?", id, jobRunning, workerID).
+		Updates(map[string]interface{}{"status": jobPending, "started_at": nil, "worker_id": ""}).Error
+}
+
+func (s gormJobs) Interrupt(id uint, workerID, phase string) error {
+	return s.conn.Model(&Job{}).
+		Where("id = ? AND status = ? AND worker_id = ?", id, jobRunning, workerID).
+		Updates(map[string]interface{}{"status": jobPending, "started_at": nil, "worker_id": "", "phase": phase}).Error
+}
+
+func (s gormJobs) RequeueStale(workerID string, stale time.Time) (int64, error) {
+	result := s.conn.Model(&Job{}).
+		Where("status = ? AND (worker_id = ? OR heartbeat_at IS NULL OR heartbeat_at < ?)", jobRunning, workerID, stale).
+		Updates(map[string]interface{}{"status": jobPending, "started_at": nil, "worker_id": ""})
+	return result.RowsAffected, result.Error
+}
+
+func (s gormJobs) RequeueAbandoned(workerID string, stale time.Time) (int64, error) {
+	result := s.conn.Model(&Job{}).
+		Where("status = ? AND worker_id <> ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", jobRunning, workerID, stale).
+		Updates(map[string]interface{}{"status": jobPending, "started_at": nil, "worker_id": ""})
+	return result.RowsAffected, result.Error
+}
+
+func (s gormJobs) CancelPending(id uint, at time.Time) (bool, error) {
+	result := s.conn.Model(&Job{}).
+		Where("id = ? AND status = ?", id, jobPending).
+		Updates(map[string]interface{}{"status": jobCancelled, "finished_at": &at})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s gormJobs) MarkCancelled(id uint, at time.Time) error {
+	return s.conn.Model(&Job{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": jobCancelled, "finished_at": &at}).Error
+}
+
+func (s gormJobs) Finish(id uint, workerID, status, message string, at time.Time) error {
+	updates := map[string]interface{}{"status": status, "finished_at": &at}
+	switch status {
+	case jobCompleted:
+		updates["progress"] = 100
+		updates["phase"] = ""
+	case jobFailed:
+		updates["error"] = message
+	}
+	return s.conn.Model(&Job{}).Where("id = ? AND worker_id = ?", id, workerID).Updates(updates).Error
+}
+
+func (s gormJobs) UpdateProgress(id uint, phase string, progress int) error {
+	return s.conn.Model(&Job{}).Where("id = ? AND status = ?", id, jobRunning).
+		Updates(map[string]interface{}{"phase": phase, "progress": progress}).Error
+}
+
+func (s gormJobs) SaveCheckpoint(id uint, checkpoint string) error {
+	// A struct update so the checkpoint is encrypted
+	return s.conn.Model(&Job{ID: id}).Select("checkpoint").Updates(&Job{Checkpoint: checkpoint}).Error
+}
+
+func (s gormJobs) SaveMetadata(id uint, metadata string) error {
+	return s.conn.Model(&Job{}).Where("id = ?", id).Update("metadata", metadata).Error
+}
+
+// gormEvents is the EventStore of a GORM connection
+type gormEvents struct {
+	conn *gorm.DB
+}
+
+func (s gormEvents) Create(event *Event) error {
+	return s.conn.Create(event).Error
+}
+
+func (s gormEvents) List(filter EventFilter) ([]Event, error) {
+	query := s.conn.Where("cluster_id = ?", filter.ClusterID).Order("timestamp desc")
+	if filter.Levels != nil {
+		query = query.Where("level IN ?", filter.Levels)
+	}
+	if filter.Host != "" {
+		query = query.Where("host = ?", filter.Host)
+	}
+	if filter.Step != "" {
+		query = query.Where("step = ?", filter.Step)
+	}
+	if filter.Phase != "" {
+		query = query.Where("phase = ?", filter.Phase)
+	}
+	if filter.JobID != 0 {
+		query = query.Where("job_id = ?", filter.JobID)
+	}
+	if filter.StepChanges {
+		query = query.Where("step_status <> ''")
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var events []Event
+	return events, query.Find(&events).Error
+}
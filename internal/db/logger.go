@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// logLevels maps the configured log levels to GORM's
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// slogLogger writes GORM logs through slog, so they carry the request and
+// trace IDs of their context. Failed statements are logged at error, slow
+// ones at warn and, at the info level, every statement.
+type slogLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration // 0 disables slow query logging
+}
+
+// LogMode returns a logger with another level
+func (l *slogLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.level = level
+	return &copied
+}
+
+// Info logs a message of GORM at info
+func (l *slogLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Warn logs a message of GORM at warn
+func (l *slogLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Error logs a message of GORM at error
+func (l *slogLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Trace logs a statement once it ran. Missing records are expected and
+// not logged as errors.
+func (l *slogLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		slog.ErrorContext(ctx, "Database query failed", "sql", sql, "rows", rows, "duration", elapsed, "error", err)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		slog.WarnContext(ctx, "Slow database query", "sql", sql, "rows", rows, "duration", elapsed, "threshold", l.slowThreshold)
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		slog.InfoContext(ctx, "Database query", "sql", sql, "rows", rows, "duration", elapsed)
+	}
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/secrets"
+)
+
+// Migration is a versioned change of the schema or of the stored data.
+// Migrations run once, in the order of their IDs, each in a transaction
+// that also records it as applied. MySQL commits schema changes right
+// away, so a failed migration there may leave part of its changes behind;
+// migrations must therefore be safe to run again.
+type Migration struct {
+	ID          string // sortable, e.g. 0005_node_taints
+	Description string
+	Migrate     func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	ID          string    `gorm:"primaryKey;size:191" json:"id"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// MigrationStatus is a migration with the time it was applied, nil when it
+// is pending
+type MigrationStatus struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// migrations lists every migration in order. New models and columns are
+// added by a migration auto-migrating the models they belong to; renames
+// and data changes are written out explicitly. Applied migrations must not
+// be changed.
+var migrations = []Migration{
+	{
+		ID:          "0001_purge_removed_nodes",
+		Description: "Delete removed nodes kept soft-deleted by earlier versions, which would break the unique node address index",
+		Migrate: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasTable(&Node{}) {
+				return nil
+			}
+			return tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&Node{}).Error
+		},
+	},
+	{
+		ID:          "0002_schema",
+		Description: "Create the tables of all models, or bring the tables of earlier versions up to date",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&Cluster{},
+				&ClusterTemplate{},
+				&ClusterPolicy{},
+				&ClusterRevision{},
+				&ClusterStatusChange{},
+				&KubeconfigCredential{},
+				&Node{},
+				&Host{},
+				&WorkerPool{},
+				&Event{},
+				&MetricSample{},
+				&Alert{},
+				&Scan{},
+				&ScanResult{},
+				&VulnerabilityScan{},
+				&Vulnerability{},
+				&SSHKey{},
+				&HostKey{},
+				&User{},
+				&RefreshToken{},
+				&RegistrationToken{},
+				&Project{},
+				&ProjectMember{},
+				&Job{},
+				&Addon{},
+				&Release{},
+				&Lock{},
+				&AuditLog{},
+				&NotificationChannel{},
+			)
+		},
+	},
+	{
+		ID:          "0003_default_project",
+		Description: "Create the default project and move clusters and SSH keys created before projects existed into it",
+		Migrate: func(tx *gorm.DB) error {
+			project := Project{Name: DefaultProjectName, Description: "Default project"}
+			if err := tx.Where(Project{Name: DefaultProjectName}).FirstOrCreate(&project).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Cluster{}).Where("project_id = 0 OR project_id IS NULL").Update("project_id", project.ID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&SSHKey{}).Where("project_id = 0 OR project_id IS NULL").Update("project_id", project.ID).Error
+		},
+	},
+	{
+		ID:          "0004_cluster_status_changed_at",
+		Description: "Backfill when clusters created before the status history entered their status",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Unscoped().Model(&Cluster{}).Where("status_changed_at IS NULL").
+				UpdateColumn("status_changed_at", gorm.Expr("updated_at")).Error
+		},
+	},
+	{
+		ID:          "0005_encrypt_plaintext_secrets",
+		Description: "Encrypt kubeconfigs, SSH keys and other secrets stored as plaintext before encryption was configured",
+		Migrate: func(tx *gorm.DB) error {
+			// Without a key values stay plaintext until -encrypt-secrets is
+			// run once one is set
+			if !secrets.Enabled() {
+				return nil
+			}
+			_, err := encryptSecrets(tx)
+			return err
+		},
+	},
+	{
+		ID:          "0006_cluster_name_index",
+		Description: "Require unique names only among clusters that are not deleted, so the name of a deleted cluster can be reused",
+		Migrate: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			// Unique in earlier versions, a plain index since
+			if migrator.HasIndex(&Cluster{}, "idx_clusters_name") {
+				if err := migrator.DropIndex(&Cluster{}, "idx_clusters_name"); err != nil {
+					return err
+				}
+			}
+			if err := migrator.CreateIndex(&Cluster{}, "Name"); err != nil {
+				return err
+			}
+			if migrator.HasIndex(&Cluster{}, clusterNameIndex) {
+				return nil
+			}
+			return tx.Exec(clusterNameIndexSQL(tx.Dialector.Name())).Error
+		},
+	},
+	{
+		ID:          "0007_event_steps",
+		Description: "Add the phase and step status of events",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Event{})
+		},
+	},
+	{
+		ID:          "0008_cluster_verification",
+		Description: "Add the smoke test results of clusters",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Cluster{})
+		},
+	},
+	{
+		ID:          "0009_conformance_runs",
+		Description: "Add Sonobuoy conformance runs of clusters",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ConformanceRun{})
+		},
+	},
+	{
+		ID:          "0010_host_project_hostname",
+		Description: "Require unique host names only within a project, so tenants can register the same host name",
+		Migrate: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			// Unique across projects in earlier versions
+			for _, index := range []string{"idx_hosts_hostname", "idx_hosts_project_id"} {
+				if migrator.HasIndex(&Host{}, index) {
+					if err := migrator.DropIndex(&Host{}, index); err != nil {
+						return err
+					}
+				}
+			}
+			if migrator.HasIndex(&Host{}, "idx_host_project_hostname") {
+				return nil
+			}
+			return migrator.CreateIndex(&Host{}, "idx_host_project_hostname")
+		},
+	},
+	{
+		ID:          "0011_job_target",
+		Description: "Add the addon or release a job works on",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Job{})
+		},
+	},
+}
+
+// clusterNameIndex keeps the names of clusters that are not deleted unique
+const clusterNameIndex = "idx_clusters_live_name"
+
+// clusterNameIndexSQL creates clusterNameIndex. PostgreSQL and SQLite have
+// partial indexes; MySQL, from 8.0.13, indexes an expression that is NULL
+// for deleted clusters instead, as NULLs do not collide.
+func clusterNameIndexSQL(driver string) string {
+	if driver == "mysql" {
+		return "CREATE UNIQUE INDEX " + clusterNameIndex + " ON clusters ((CASE WHEN deleted_at IS NULL THEN name END))"
+	}
+	return "CREATE UNIQUE INDEX " + clusterNameIndex + " ON clusters (name) WHERE deleted_at IS NULL"
+}
+
+// Migrations returns the status of every migration of a database, in order
+func Migrations(conn *gorm.DB) ([]MigrationStatus, error) {
+	if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var records []SchemaMigration
+	if err := conn.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		applied[record.ID] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{ID: migration.ID, Description: migration.Description}
+		if at, ok := applied[migration.ID]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the IDs of the migrations not applied to a
+// database yet
+func PendingMigrations(conn *gorm.DB) ([]string, error) {
+	statuses, err := Migrations(conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.ID)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations of a database in order and returns
+// the IDs of those applied. It stops at the first that fails. Replicas
+// migrating at the same time cannot both record a migration, the second one
+// fails instead.
+func Migrate(conn *gorm.DB) ([]string, error) {
+	pending, err := PendingMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, migration := range migrations {
+		if !containsID(pending, migration.ID) {
+			continue
+		}
+		start := time.Now()
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			record := SchemaMigration{ID: migration.ID, Description: migration.Description, AppliedAt: start}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			return migration.Migrate(tx)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", migration.ID, err)
+		}
+		slog.Info("Applied database migration", "migration", migration.ID, "duration", time.Since(start).Round(time.Millisecond))
+		applied = append(applied, migration.ID)
+	}
+	return applied, nil
+}
+
+// containsID reports whether ids contains id
+func containsID(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -9,7 +9,8 @@ import (
 // Cluster represents a Kubernetes cluster
 type Cluster struct {
 	ID                uint      `gorm:"primaryKey" json:"id"`
-	Name              string    `gorm:"uniqueIndex;not null" json:"name"`
+	ProjectID         uint      `gorm:"index" json:"project_id"`
+	Name              string    `gorm:"index;not null" json:"name"` // unique among clusters that are not deleted, see clusterNameIndex
 	K8sVersion        string    `json:"k8s_version"`
 	PodNetworkCIDR    string    `json:"pod_network_cidr"`
 	ServiceCIDR       string    `json:"service_cidr"`
@@ -17,11 +18,25 @@ type Cluster struct {
 	ContainerRuntime  string    `json:"container_runtime"`
 	APIServerEndpoint string    `json:"api_server_endpoint"`
 	LoadBalancerIP    string    `json:"load_balancer_ip,omitempty"`
+	IngressEndpoints  []string  `gorm:"serializer:json" json:"ingress_endpoints,omitempty"`
+	Labels            map[string]string `gorm:"serializer:json" json:"labels,omitempty"`
+	Annotations       map[string]string `gorm:"serializer:json" json:"annotations,omitempty"` // free-form notes, not used for selection
+	Notifications     []string  `gorm:"serializer:json" json:"notifications,omitempty"` // channels told when provisioning or an upgrade completes or fails
+	InsecureSkipHostKeyCheck bool `json:"insecure_skip_host_key_check,omitempty"` // accept any SSH host key, for lab environments
+	AutoUpgrade       string    `json:"auto_upgrade,omitempty"` // patch to upgrade to new patch releases in the maintenance window, none or empty to not
+	MaintenanceWindow *MaintenanceWindow `gorm:"serializer:json" json:"maintenance_window,omitempty"` // when automatic upgrades may start
+	KubeadmConfig     string    `gorm:"type:text" json:"kubeadm_config,omitempty"` // kubeadm configuration YAML of kubeadm init
+	HardeningProfile  string    `json:"hardening_profile,omitempty"` // baseline or cis settings applied during provisioning
+	TemplateID        uint      `gorm:"index" json:"template_id,omitempty"` // template the cluster was created from
 	Provider          string    `json:"provider"` // kubeadm, k3s, kind
-	Status            string    `json:"status"`   // pending, provisioning, ready, failed, destroying
-	Kubeconfig        []byte    `json:"-"`        // encrypted, not exposed in JSON
-	JoinCommand       string    `json:"-"`        // not exposed in JSON
-	CertificateKey    string    `json:"-"`        // not exposed in JSON
+	Status            ClusterStatus `gorm:"index" json:"status"` // see clusterTransitions for the allowed changes
+	StatusMessage     string    `gorm:"type:text" json:"status_message,omitempty"` // why the cluster failed
+	StatusChangedAt   *time.Time `json:"status_changed_at,omitempty"` // when the cluster entered its status
+	Verification      *Verification `gorm:"serializer:json;type:text" json:"verification,omitempty"` // smoke tests run once the cluster was provisioned
+	IdempotencyKey    string    `gorm:"index" json:"-"` // Idempotency-Key of the create request
+	Kubeconfig        []byte    `gorm:"serializer:encrypted;type:bytes" json:"-"` // encrypted, not exposed in JSON
+	JoinCommand       string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed in JSON
+	CertificateKey    string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed in JSON
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -29,86 +44,499 @@ type Cluster struct {
 	// Relationships
 	Nodes  []Node  `gorm:"foreignKey:ClusterID" json:"nodes,omitempty"`
 	Events []Event `gorm:"foreignKey:ClusterID" json:"events,omitempty"`
+	StatusHistory []ClusterStatusChange `gorm:"foreignKey:ClusterID" json:"status_history,omitempty"`
+}
+
+// MaintenanceWindow is a recurring period in which automatic changes of a
+// cluster may start
+type MaintenanceWindow struct {
+	Days     []string `json:"days,omitempty"`     // mon to sun the window opens on, every day when empty
+	Start    string   `json:"start"`              // HH:MM the window opens at
+	Duration string   `json:"duration"`           // how long the window stays open, at most 24h
+	Timezone string   `json:"timezone,omitempty"` // IANA time zone of start, UTC when empty
+}
+
+// Verification is the outcome of the smoke tests of a provisioned cluster
+type Verification struct {
+	Passed     bool                `json:"passed"`
+	Checks     []VerificationCheck `json:"checks"`
+	VerifiedAt time.Time           `json:"verified_at"`
+}
+
+// VerificationCheck is the outcome of one smoke test
+type VerificationCheck struct {
+	Name    string `json:"name"` // deployment, service, dns, apiserver or component:<pod>
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"` // why the check failed
+}
+
+// ClusterStatusChange records a change of the status of a cluster
+type ClusterStatusChange struct {
+	ID        uint          `gorm:"primaryKey" json:"-"`
+	ClusterID uint          `gorm:"index;not null" json:"-"`
+	From      ClusterStatus `json:"from,omitempty"` // empty when the cluster was created
+	To        ClusterStatus `json:"to"`
+	Message   string        `gorm:"type:text" json:"message,omitempty"`
+	Forced    bool          `json:"forced,omitempty"` // set by an administrator, bypassing the allowed transitions
+	CreatedAt time.Time     `json:"at"`
+}
+
+// ClusterTemplate is a reusable cluster specification: version, networks,
+// CNI, runtime, addons and kubeadm configuration. Clusters created from it
+// only add their hosts.
+type ClusterTemplate struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `json:"description,omitempty"`
+	Spec        string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded spec with addon credentials, encrypted
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ClusterPolicy is the server-wide policy of new clusters: the defaults of
+// fields a request leaves empty and the constraints it must satisfy. There
+// is at most one, with ID 1.
+type ClusterPolicy struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	Spec      string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded policy with addon credentials of the defaults, encrypted
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ClusterRevision is a cluster spec as it was created or applied. Specs hold
+// SSH keys and addon credentials, so they are encrypted.
+type ClusterRevision struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ClusterID      uint      `gorm:"uniqueIndex:idx_revision_cluster_number;not null" json:"cluster_id"`
+	Revision       int       `gorm:"uniqueIndex:idx_revision_cluster_number;not null" json:"revision"`
+	Action         string    `json:"action"` // create, apply, rollback, replace, scale, add-nodes
+	SourceRevision int       `json:"source_revision,omitempty"` // revision rolled back to
+	Spec           string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded cluster request, encrypted
+	JobID          uint      `json:"job_id,omitempty"` // job converging the cluster to the spec
+	CreatedBy      string    `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 // Node represents a node in a cluster
 type Node struct {
 	ID               uint      `gorm:"primaryKey" json:"id"`
-	ClusterID        uint      `gorm:"index;not null" json:"cluster_id"`
+	ClusterID        uint      `gorm:"uniqueIndex:idx_node_cluster_address;not null" json:"cluster_id"`
 	Hostname         string    `json:"hostname"`
-	Address          string    `json:"address"`
+	Address          string    `gorm:"uniqueIndex:idx_node_cluster_address" json:"address"` // unique within the cluster
 	User             string    `json:"user"`
 	SSHKeyPath       string    `json:"ssh_key_path,omitempty"`
+	SSHKeyID         uint      `json:"ssh_key_id,omitempty"` // stored SSH key used to connect
+	SSHKeyPassphrase string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed
+	SSHAgent         bool      `json:"ssh_agent,omitempty"`
+	UseSudo          bool      `json:"use_sudo,omitempty"`
+	SudoPassword     string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed
+	Bastion          string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded jump host with its SSH key, encrypted, not exposed
 	Port             int       `json:"port"`
 	Role             string    `json:"role"` // control-plane, worker
-	Status           string    `json:"status"` // ready, notready, unknown, provisioning
+	Status           string    `json:"status"` // ready, notready, unknown, provisioning, upgrading, maintenance, removing, failed
 	K8sVersion       string    `json:"k8s_version"`
 	ContainerRuntime string    `json:"container_runtime"`
-	Labels           string    `json:"labels,omitempty"` // JSON encoded map
+	Labels           map[string]string `gorm:"serializer:json" json:"labels,omitempty"`
 	Taints           string    `json:"taints,omitempty"` // JSON encoded array
+	MachineID        string    `json:"machine_id,omitempty"` // provider:id of the machine created for the node
+	HostID           uint      `gorm:"index" json:"host_id,omitempty"` // inventory host the node runs on
+	BMC              string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded BMC with its credentials, encrypted, not exposed
+	Cordoned         bool      `json:"cordoned,omitempty"` // unschedulable after a cordon or drain through the API
 	JoinedAt         *time.Time `json:"joined_at,omitempty"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// WorkerPool is a group of workers of a cluster, sized between a minimum
+// and a maximum with hosts from the inventory of the cluster's project. The
+// workers of a pool carry the kubeforge.io/pool node label with its name.
+type WorkerPool struct {
+	ID         uint              `gorm:"primaryKey" json:"id"`
+	ClusterID  uint              `gorm:"uniqueIndex:idx_pool_cluster_name;not null" json:"cluster_id"`
+	Name       string            `gorm:"uniqueIndex:idx_pool_cluster_name;not null" json:"name"`
+	MinSize    int               `json:"min_size"`
+	MaxSize    int               `json:"max_size"`
+	HostLabels map[string]string `gorm:"serializer:json" json:"host_labels,omitempty"` // inventory host labels the pool takes hosts by
+	Labels     map[string]string `gorm:"serializer:json" json:"labels,omitempty"`      // node labels of the workers
+	Taints     []string          `gorm:"serializer:json" json:"taints,omitempty"`      // node taints of the workers
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Host is a machine registered in the host inventory, independent of any
+// cluster. Clusters reference inventory hosts instead of repeating their SSH
+// settings; a host is free while no node runs on it.
+type Host struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ProjectID        uint      `gorm:"uniqueIndex:idx_host_project_hostname" json:"project_id"`
+	Hostname         string    `gorm:"uniqueIndex:idx_host_project_hostname;not null" json:"hostname"` // unique within the project
+	Address          string    `json:"address"`
+	User             string    `json:"user"`
+	Port             int       `json:"port"`
+	SSHKeyPath       string    `json:"ssh_key_path,omitempty"`
+	SSHKeyID         uint      `json:"ssh_key_id,omitempty"` // stored SSH key used to connect
+	SSHKeyPassphrase string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed
+	SSHAgent         bool      `json:"ssh_agent,omitempty"`
+	UseSudo          bool      `json:"use_sudo,omitempty"`
+	SudoPassword     string    `gorm:"serializer:encrypted" json:"-"` // encrypted, not exposed
+	Bastion          string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded jump host with its SSH key, encrypted, not exposed
+	BMC              string    `gorm:"type:text;serializer:encrypted" json:"-"` // JSON encoded BMC with its credentials, encrypted, not exposed
+	Labels           map[string]string `gorm:"serializer:json" json:"labels,omitempty"` // used to pick hosts for clusters
+	Status           string    `json:"status"` // reachable or unreachable, as of the last facts collection
+	Error            string    `json:"error,omitempty"` // why the facts could not be collected
+
+	// Facts collected over SSH
+	OS               string     `json:"os,omitempty"`
+	OSVersion        string     `json:"os_version,omitempty"`
+	Kernel           string     `json:"kernel,omitempty"`
+	Arch             string     `json:"arch,omitempty"`
+	CPUs             int        `json:"cpus,omitempty"`
+	MemoryMB         int        `json:"memory_mb,omitempty"`
+	DiskGB           int        `json:"disk_gb,omitempty"` // size of the root file system
+	Disks            map[string]int `gorm:"serializer:json" json:"disks,omitempty"` // size in GB of the block devices by name
+	FactsCollectedAt *time.Time `json:"facts_collected_at,omitempty"`
+	PreflightPassed  bool       `json:"preflight_passed"` // the host can run a Kubernetes node
+	PreflightErrors  []string   `gorm:"serializer:json" json:"preflight_errors,omitempty"` // why it cannot
+
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
 // Event represents a provisioning or cluster event
 type Event struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	ClusterID uint      `gorm:"index;not null" json:"cluster_id"`
-	Timestamp time.Time `json:"timestamp"`
+	JobID     uint      `gorm:"index" json:"job_id,omitempty"` // job that was running when the event was recorded
+	RequestID string    `gorm:"index" json:"request_id,omitempty"` // API request that started the work
+	TraceID   string    `json:"trace_id,omitempty"` // trace of the job that recorded the event
+	Timestamp time.Time `gorm:"index" json:"timestamp"`
 	Level     string    `json:"level"` // info, warn, error
 	Host      string    `json:"host"`
 	Step      string    `json:"step"`
+	Phase     string    `gorm:"index" json:"phase,omitempty"` // prepare, bootstrap, cni, join, upgrade, destroy
+	StepStatus string   `json:"step_status,omitempty"` // running, succeeded or failed on the events starting and ending a step
 	Message   string    `json:"message"`
 	Output    string    `json:"output,omitempty" gorm:"type:text"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// MetricSample is the resource usage of a node when it was collected.
+// Capacities are the allocatable resources of the node.
+type MetricSample struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	ClusterID      uint      `gorm:"index:idx_metrics_cluster_time;not null" json:"-"`
+	Node           string    `json:"node"`
+	Source         string    `json:"source"` // metrics-server or kubelet
+	CPUUsage       int64     `json:"cpu_usage_millicores"`
+	CPUCapacity    int64     `json:"cpu_capacity_millicores"`
+	MemoryUsage    int64     `json:"memory_usage_bytes"`
+	MemoryCapacity int64     `json:"memory_capacity_bytes"`
+	CollectedAt    time.Time `gorm:"index:idx_metrics_cluster_time;index" json:"collected_at"`
+}
+
+// Alert is a degraded condition of a cluster found by an alert rule. One
+// alert fires per rule and subject and is resolved when the condition clears.
+type Alert struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ClusterID  uint       `gorm:"index;not null" json:"cluster_id"`
+	Rule       string     `gorm:"not null" json:"rule"`
+	Subject    string     `json:"subject,omitempty"` // node or certificate the alert is about
+	State      string     `gorm:"index" json:"state"` // firing, resolved
+	Message    string     `json:"message"`
+	FiredAt    time.Time  `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Scan is a CIS benchmark scan of the nodes of a cluster with kube-bench
+type Scan struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ClusterID  uint       `gorm:"index;not null" json:"cluster_id"`
+	JobID      uint       `json:"job_id,omitempty"`
+	Method     string     `json:"method"` // job runs kube-bench as a Job in the cluster, ssh on the hosts
+	Status     string     `gorm:"index" json:"status"` // pending, running, completed, failed
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	Pass       int        `json:"pass"`
+	Fail       int        `json:"fail"`
+	Warn       int        `json:"warn"`
+	Info       int        `json:"info"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	Results []ScanResult `gorm:"foreignKey:ScanID" json:"results,omitempty"`
+}
+
+// ScanResult is the outcome of one benchmark check on one node of a scan
+type ScanResult struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	ScanID      uint   `gorm:"index;not null" json:"-"`
+	Node        string `json:"node"`
+	CheckID     string `json:"check_id"`
+	Section     string `json:"section"`
+	Description string `gorm:"type:text" json:"description"`
+	Status      string `json:"status"` // PASS, FAIL, WARN, INFO
+	Scored      bool   `json:"scored"`
+	Remediation string `gorm:"type:text" json:"remediation,omitempty"`
+}
+
+// VulnerabilityScan is a Trivy scan of the operating system packages of the
+// nodes of a cluster and optionally of its running container images
+type VulnerabilityScan struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ClusterID  uint       `gorm:"index;not null" json:"cluster_id"`
+	JobID      uint       `json:"job_id,omitempty"`
+	Images     bool       `json:"images"` // running container images were scanned too
+	Status     string     `gorm:"index" json:"status"` // pending, running, completed, failed
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	Critical   int        `json:"critical"`
+	High       int        `json:"high"`
+	Medium     int        `json:"medium"`
+	Low        int        `json:"low"`
+	Unknown    int        `json:"unknown"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	Findings []Vulnerability `gorm:"foreignKey:ScanID" json:"findings,omitempty"`
+}
+
+// Vulnerability is a vulnerable package of a node or image found by a scan
+type Vulnerability struct {
+	ID               uint   `gorm:"primaryKey" json:"-"`
+	ScanID           uint   `gorm:"index;not null" json:"-"`
+	TargetType       string `json:"target_type"` // node or image
+	Target           string `json:"target"`      // node hostname or image reference
+	VulnerabilityID  string `gorm:"index" json:"vulnerability_id"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version,omitempty"`
+	Severity         string `gorm:"index" json:"severity"` // CRITICAL, HIGH, MEDIUM, LOW, UNKNOWN
+	Title            string `gorm:"type:text" json:"title,omitempty"`
+}
+
+// ConformanceRun is a run of the Kubernetes conformance tests of a cluster
+// with Sonobuoy
+type ConformanceRun struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	ClusterID   uint       `gorm:"index;not null" json:"cluster_id"`
+	JobID       uint       `json:"job_id,omitempty"`
+	Mode        string     `json:"mode"` // quick or certified-conformance
+	Status      string     `gorm:"index" json:"status"` // pending, running, completed, failed
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	Result      string     `json:"result,omitempty"` // passed or failed, of the e2e plugin
+	Total       int        `json:"total"`
+	Passed      int        `json:"passed"`
+	Failed      int        `json:"failed"`
+	Skipped     int        `json:"skipped"`
+	FailedTests []string   `gorm:"serializer:json;type:text" json:"failed_tests,omitempty"`
+	Results     []byte     `json:"-"` // the results tarball of sonobuoy retrieve
+	HasResults  bool       `json:"has_results"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
 // SSHKey represents an SSH key for authentication
 type SSHKey struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
+	ProjectID   uint      `gorm:"index" json:"project_id"`
 	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
 	PublicKey   string    `gorm:"type:text" json:"public_key"`
-	PrivateKey  []byte    `json:"-"` // encrypted, not exposed
+	PrivateKey  []byte    `gorm:"serializer:encrypted;type:bytes" json:"-"` // encrypted, not exposed
 	Fingerprint string    `json:"fingerprint"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// HostKey is an SSH host key presented by a host. The first key seen for a
+// host is trusted, keys presented later that do not match stay pending until
+// approved.
+type HostKey struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Host        string    `gorm:"uniqueIndex:idx_host_keys_host_fingerprint;not null" json:"host"` // address:port
+	Type        string    `json:"type"`
+	Fingerprint string    `gorm:"uniqueIndex:idx_host_keys_host_fingerprint;not null" json:"fingerprint"`
+	PublicKey   string    `gorm:"type:text" json:"public_key"`
+	Status      string    `gorm:"index" json:"status"` // trusted, pending
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // User represents a user of the system (for future auth)
 type User struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
 	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
 	Email        string    `gorm:"uniqueIndex" json:"email"`
 	PasswordHash string    `json:"-"` // bcrypt hash
-	Role         string    `json:"role"` // admin, user
+	Role         string    `json:"role"` // admin, operator, viewer
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// Project groups clusters, SSH keys and members. Members only see the
+// resources of their projects.
+type Project struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProjectMember grants a user a role within a project
+type ProjectMember struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ProjectID uint      `gorm:"uniqueIndex:idx_project_member;not null" json:"project_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_project_member;not null" json:"user_id"`
+	Role      string    `json:"role"` // admin, operator, viewer
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// RefreshToken is a long-lived token exchanged for new access tokens. Only
+// its hash is stored.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RegistrationToken authenticates hosts joining a cluster with cloud-init
+// user-data when they report back to KubeForge
+type RegistrationToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ClusterID uint      `gorm:"index;not null" json:"cluster_id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"`
+	Role      string    `json:"role"` // role of the hosts joining with it
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KubeconfigCredential is a short-lived kubeconfig issued for a cluster: a
+// service account token bound to a cluster role
+type KubeconfigCredential struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	ClusterID uint       `gorm:"index;not null" json:"cluster_id"`
+	Name      string     `json:"name"` // service account and cluster role binding in the cluster
+	Role      string     `json:"role"` // cluster role granted
+	UserID    uint       `gorm:"index" json:"user_id"`
+	Username  string     `json:"username"`
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 // Job represents an async provisioning job
 type Job struct {
 	ID         uint      `gorm:"primaryKey" json:"id"`
 	ClusterID  uint      `gorm:"index" json:"cluster_id,omitempty"`
-	Type       string    `json:"type"` // provision, destroy, add-node, remove-node
+	ParentID   uint      `gorm:"index" json:"parent_id,omitempty"` // batch job that started the job
+	Type       string    `json:"type"` // provision, upgrade, reconcile, destroy, add-node, remove-node, renew-certs, batch
+	Target     string    `gorm:"size:191;not null;default:''" json:"target,omitempty"` // addon or release the job works on, jobs of a cluster only duplicate jobs of the same type and target
 	Status     string    `json:"status"` // pending, running, completed, failed, cancelled
 	Progress   int       `json:"progress"` // 0-100
+	Phase      string    `json:"phase,omitempty"` // current step of a running job
 	Error      string    `json:"error,omitempty" gorm:"type:text"`
 	Metadata   string    `json:"metadata,omitempty" gorm:"type:text"` // JSON encoded metadata
+	Payload    string    `json:"-" gorm:"type:text;serializer:encrypted"` // JSON encoded job input with SSH keys, encrypted, not exposed
+	Checkpoint string    `json:"-" gorm:"type:text;serializer:encrypted"` // JSON encoded resume state with join tokens, encrypted, not exposed
+	WorkerID   string    `gorm:"index" json:"worker_id,omitempty"` // server replica running the job
+	RequestID  string    `json:"request_id,omitempty"` // API request that created the job
+	TraceParent string   `json:"-"` // W3C trace context of the request, continued by the job
+	HeartbeatAt *time.Time `json:"heartbeat_at,omitempty"`
 	StartedAt  *time.Time `json:"started_at,omitempty"`
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// Addon represents an addon installed on a cluster
+type Addon struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ClusterID   uint      `gorm:"uniqueIndex:idx_addon_cluster_name;not null" json:"cluster_id"`
+	Name        string    `gorm:"uniqueIndex:idx_addon_cluster_name;not null" json:"name"`
+	Version     string    `json:"version"`
+	Status      string    `json:"status"` // installing, installed, upgrading, uninstalling, failed
+	Config      string    `json:"config,omitempty" gorm:"type:text"` // JSON encoded addon config
+	Credentials []byte    `json:"-"` // encrypted, not exposed
+	Error       string    `json:"error,omitempty" gorm:"type:text"`
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Release represents a Helm release deployed to a cluster
+type Release struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ClusterID uint      `gorm:"uniqueIndex:idx_release_cluster_name;not null" json:"cluster_id"`
+	Name      string    `gorm:"uniqueIndex:idx_release_cluster_name;not null" json:"name"`
+	Namespace string    `gorm:"uniqueIndex:idx_release_cluster_name;not null" json:"namespace"`
+	RepoURL   string    `json:"repo_url,omitempty"`
+	Chart     string    `json:"chart"`
+	Version   string    `json:"version,omitempty"`
+	Values    string    `json:"values,omitempty" gorm:"type:text"` // JSON encoded values
+	Revision  string    `json:"revision,omitempty"`
+	Status    string    `json:"status"` // installing, deployed, upgrading, rolling-back, uninstalling, failed
+	Error     string    `json:"error,omitempty" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditLog records a mutating API call
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Timestamp  time.Time `gorm:"index" json:"timestamp"`
+	UserID     uint      `gorm:"index" json:"user_id,omitempty"`
+	Username   string    `gorm:"index" json:"username,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	ClusterID  uint      `gorm:"index" json:"cluster_id,omitempty"`
+	Payload    string    `json:"payload,omitempty" gorm:"type:text"` // request body with secrets redacted
+	Status     int       `json:"status"` // HTTP status of the response
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+// NotificationChannel is a Slack, Teams or email destination clusters can
+// send provisioning notifications to
+type NotificationChannel struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	Type      string    `json:"type"`                              // slack, teams, email
+	Config    []byte    `json:"-"`                                 // encrypted webhook URL or recipients, not exposed
+	Template  string    `json:"template,omitempty" gorm:"type:text"` // text/template for the message body
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Lock is a named lease held by one server replica at a time
+type Lock struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	Owner     string    `gorm:"not null" json:"owner"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // TableName overrides (optional, GORM will pluralize by default)
 func (Cluster) TableName() string {
 	return "clusters"
 }
 
+func (ClusterTemplate) TableName() string {
+	return "cluster_templates"
+}
+
+func (ClusterRevision) TableName() string {
+	return "cluster_revisions"
+}
+
 func (Node) TableName() string {
 	return "nodes"
 }
@@ -121,10 +549,54 @@ func (SSHKey) TableName() string {
 	return "ssh_keys"
 }
 
+func (HostKey) TableName() string {
+	return "host_keys"
+}
+
 func (User) TableName() string {
 	return "users"
 }
 
+func (Project) TableName() string {
+	return "projects"
+}
+
+func (ProjectMember) TableName() string {
+	return "project_members"
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
 func (Job) TableName() string {
 	return "jobs"
 }
+
+func (Addon) TableName() string {
+	return "addons"
+}
+
+func (Release) TableName() string {
+	return "releases"
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+func (NotificationChannel) TableName() string {
+	return "notification_channels"
+}
+
+func (Lock) TableName() string {
+	return "locks"
+}
+
+func (MetricSample) TableName() string {
+	return "metrics"
+}
+
+func (Alert) TableName() string {
+	return "alerts"
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotDeleted is returned when purging or restoring a record that is not
+// deleted
+var ErrNotDeleted = errors.New("record is not deleted")
+
+// clusterRecords lists the models holding records of a single cluster,
+// removed with it when it is purged. Scan results and vulnerabilities
+// belong to the scans and go first.
+var clusterRecords = []interface{}{
+	&Node{},
+	&WorkerPool{},
+	&Event{},
+	&ClusterStatusChange{},
+	&ClusterRevision{},
+	&KubeconfigCredential{},
+	&RegistrationToken{},
+	&MetricSample{},
+	&Alert{},
+	&Scan{},
+	&VulnerabilityScan{},
+	&ConformanceRun{},
+	&Job{},
+	&Addon{},
+	&Release{},
+}
+
+// DeletedClusters returns the IDs of the clusters deleted before cutoff
+func DeletedClusters(conn *gorm.DB, cutoff time.Time) ([]uint, error) {
+	var ids []uint
+	err := conn.Unscoped().Model(&Cluster{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// PurgeCluster permanently removes a deleted cluster with its nodes,
+// history, events, jobs and every other record of it. Audit logs are kept.
+func PurgeCluster(conn *gorm.DB, id uint) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		var cluster Cluster
+		if err := tx.Unscoped().Select("id", "deleted_at").First(&cluster, id).Error; err != nil {
+			return err
+		}
+		if !cluster.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+
+		scans := tx.Model(&Scan{}).Select("id").Where("cluster_id = ?", id)
+		if err := tx.Where("scan_id IN (?)", scans).Delete(&ScanResult{}).Error; err != nil {
+			return err
+		}
+		vulnerabilityScans := tx.Model(&VulnerabilityScan{}).Select("id").Where("cluster_id = ?", id)
+		if err := tx.Where("scan_id IN (?)", vulnerabilityScans).Delete(&Vulnerability{}).Error; err != nil {
+			return err
+		}
+		for _, model := range clusterRecords {
+			if err := tx.Unscoped().Where("cluster_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&Cluster{}, id).Error
+	})
+}
+
+// PurgeInventory permanently removes the hosts and SSH keys deleted before
+// cutoff. SSH keys still used by a node or host are kept.
+func PurgeInventory(conn *gorm.DB, cutoff time.Time) (hosts, sshKeys int64, err error) {
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(&Host{})
+		if result.Error != nil {
+			return result.Error
+		}
+		hosts = result.RowsAffected
+
+		nodeKeys := tx.Model(&Node{}).Select("ssh_key_id")
+		hostKeys := tx.Model(&Host{}).Select("ssh_key_id")
+		result = tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Where("id NOT IN (?) AND id NOT IN (?)", nodeKeys, hostKeys).
+			Delete(&SSHKey{})
+		if result.Error != nil {
+			return result.Error
+		}
+		sshKeys = result.RowsAffected
+		return nil
+	})
+	return hosts, sshKeys, err
+}
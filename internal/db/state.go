@@ -0,0 +1,316 @@
+package db
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubeforge/internal/secrets"
+)
+
+// StateFormat is the version of the state archive layout
+const StateFormat = 1
+
+// Files of a state archive
+const (
+	stateManifestFile = "manifest.json"
+	stateDataFile     = "state.bin"
+)
+
+// Common errors
+var (
+	ErrStateNotEmpty = errors.New("database already manages clusters, import needs an empty database")
+	ErrStateInvalid  = errors.New("invalid state archive")
+)
+
+// StateManifest describes a state archive. It is stored unencrypted next
+// to the state, so an archive can be identified without its passphrase.
+type StateManifest struct {
+	Format     int            `json:"format"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Driver     string         `json:"driver"`    // database the state was exported from
+	Migration  string         `json:"migration"` // last migration applied to it
+	Counts     map[string]int `json:"counts"`    // records per table
+}
+
+// State is everything needed to keep managing the clusters of a server:
+// clusters with their nodes, history and addons, the projects, SSH keys,
+// inventory hosts and known host keys they use, and jobs. Users, audit
+// logs, metrics and scan results are not part of it.
+type State struct {
+	Projects      []Project
+	SSHKeys       []SSHKey
+	HostKeys      []HostKey
+	Hosts         []Host
+	Clusters      []Cluster
+	StatusChanges []ClusterStatusChange
+	Revisions     []ClusterRevision
+	Nodes         []Node
+	WorkerPools   []WorkerPool
+	Addons        []Addon
+	Jobs          []Job
+}
+
+// tables returns the record slices of a state in the order they are
+// inserted, referenced records first
+func (s *State) tables() []interface{} {
+	return []interface{}{
+		&s.Projects,
+		&s.SSHKeys,
+		&s.HostKeys,
+		&s.Hosts,
+		&s.Clusters,
+		&s.StatusChanges,
+		&s.Revisions,
+		&s.Nodes,
+		&s.WorkerPools,
+		&s.Addons,
+		&s.Jobs,
+	}
+}
+
+// ExportState writes the state of the server to w as a gzipped tar archive.
+// Secrets are decrypted with the keys of this server and the whole state is
+// encrypted with passphrase, so it can be imported by a server with other
+// keys.
+func ExportState(conn *gorm.DB, w io.Writer, passphrase string) (*StateManifest, error) {
+	if passphrase == "" {
+		return nil, secrets.ErrNoPassphrase
+	}
+
+	var state State
+	manifest := StateManifest{Format: StateFormat, ExportedAt: time.Now().UTC(), Driver: conn.Dialector.Name(), Counts: map[string]int{}}
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		for _, table := range state.tables() {
+			if err := tx.Unscoped().Order("id").Find(table).Error; err != nil {
+				return err
+			}
+			name, err := tableName(tx, table)
+			if err != nil {
+				return err
+			}
+			manifest.Counts[name] = reflect.ValueOf(table).Elem().Len()
+		}
+		var last SchemaMigration
+		if err := tx.Order("id desc").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		manifest.Migration = last.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Addon credentials are sealed by the API rather than the serializer
+	for i := range state.Addons {
+		if len(state.Addons[i].Credentials) == 0 {
+			continue
+		}
+		credentials, err := secrets.Decrypt(state.Addons[i].Credentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt credentials of addon %d: %w", state.Addons[i].ID, err)
+		}
+		state.Addons[i].Credentials = credentials
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(&state); err != nil {
+		return nil, err
+	}
+	sealed, err := secrets.SealWithPassphrase(data.Bytes(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{stateManifestFile, header}, {stateDataFile, sealed}} {
+		if err := archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.data)), ModTime: manifest.ExportedAt}); err != nil {
+			return nil, err
+		}
+		if _, err := archive.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, gz.Close()
+}
+
+// ImportState restores a state written by ExportState into the database,
+// keeping the IDs of all records. Secrets are encrypted with the keys of
+// this server. The database must be migrated and must not have clusters,
+// hosts, SSH keys or jobs yet; projects without members, such as the
+// default project, are replaced. Jobs that were running are resumed from
+// their checkpoint.
+func ImportState(conn *gorm.DB, r io.Reader, passphrase string) (*StateManifest, error) {
+	if passphrase == "" {
+		return nil, secrets.ErrNoPassphrase
+	}
+	manifest, sealed, err := readStateArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Format != StateFormat {
+		return nil, fmt.Errorf("%w: unsupported format %d", ErrStateInvalid, manifest.Format)
+	}
+	if manifest.Migration != "" && !knownMigration(manifest.Migration) {
+		return nil, fmt.Errorf("%w: exported by a newer version with migration %s", ErrStateInvalid, manifest.Migration)
+	}
+
+	data, err := secrets.OpenWithPassphrase(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStateInvalid, err)
+	}
+
+	for i := range state.Addons {
+		if len(state.Addons[i].Credentials) == 0 {
+			continue
+		}
+		credentials, err := secrets.Encrypt(state.Addons[i].Credentials)
+		if err != nil {
+			return nil, fmt.Errorf("cannot store addon credentials: %w", err)
+		}
+		state.Addons[i].Credentials = credentials
+	}
+	// Running jobs were owned by the exporting server
+	for i := range state.Jobs {
+		if state.Jobs[i].Status == jobRunning {
+			state.Jobs[i].Status = jobPending
+			state.Jobs[i].WorkerID = ""
+			state.Jobs[i].StartedAt = nil
+			state.Jobs[i].HeartbeatAt = nil
+		}
+	}
+
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		if err := checkEmpty(tx); err != nil {
+			return err
+		}
+		if err := tx.Where("1 = 1").Delete(&Project{}).Error; err != nil {
+			return err
+		}
+		for _, table := range state.tables() {
+			if reflect.ValueOf(table).Elem().Len() == 0 {
+				continue
+			}
+			if err := tx.Omit(clause.Associations).CreateInBatches(table, 100).Error; err != nil {
+				return err
+			}
+			if err := resetSequence(tx, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// readStateArchive returns the manifest and the sealed state of an archive
+func readStateArchive(r io.Reader) (*StateManifest, []byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrStateInvalid, err)
+	}
+	defer gz.Close()
+
+	var manifest *StateManifest
+	var sealed []byte
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrStateInvalid, err)
+		}
+		switch header.Name {
+		case stateManifestFile:
+			manifest = &StateManifest{}
+			if err := json.NewDecoder(archive).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrStateInvalid, err)
+			}
+		case stateDataFile:
+			if sealed, err = io.ReadAll(archive); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrStateInvalid, err)
+			}
+		}
+	}
+	if manifest == nil || sealed == nil {
+		return nil, nil, fmt.Errorf("%w: %s or %s missing", ErrStateInvalid, stateManifestFile, stateDataFile)
+	}
+	return manifest, sealed, nil
+}
+
+// checkEmpty fails unless the database has no clusters, hosts, SSH keys or
+// jobs, deleted ones included, and its projects have no members
+func checkEmpty(tx *gorm.DB) error {
+	for _, model := range []interface{}{&Cluster{}, &Host{}, &SSHKey{}, &Job{}, &ProjectMember{}} {
+		var count int64
+		if err := tx.Unscoped().Model(model).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrStateNotEmpty
+		}
+	}
+	return nil
+}
+
+// resetSequence moves the ID sequence of a table past the imported IDs. Only
+// PostgreSQL keeps sequences that explicit IDs do not advance.
+func resetSequence(tx *gorm.DB, table interface{}) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	name, err := tableName(tx, table)
+	if err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), (SELECT MAX(id) FROM %s))", name, name)).Error
+}
+
+// tableName returns the table of a slice of models
+func tableName(tx *gorm.DB, table interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(table); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
+
+// knownMigration reports whether this version has a migration
+func knownMigration(id string) bool {
+	for _, migration := range migrations {
+		if migration.ID == id {
+			return true
+		}
+	}
+	return false
+}
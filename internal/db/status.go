@@ -0,0 +1,221 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ClusterStatus is the lifecycle status of a cluster. It only changes along
+// clusterTransitions, through the functions of this file, and every change
+// is recorded in the status history of the cluster.
+type ClusterStatus string
+
+// Cluster statuses
+const (
+	ClusterPending      ClusterStatus = "pending"      // created, provisioning not started
+	ClusterProvisioning ClusterStatus = "provisioning" // provision job running
+	ClusterReady        ClusterStatus = "ready"        // serving, accepts changes
+	ClusterUpgrading    ClusterStatus = "upgrading"    // nodes being upgraded
+	ClusterReconciling  ClusterStatus = "reconciling"  // reconcile job converging the spec
+	ClusterFailed       ClusterStatus = "failed"       // last job failed, see status_message
+	ClusterDestroying   ClusterStatus = "destroying"   // being deleted
+	ClusterDeleted      ClusterStatus = "deleted"      // deleted, kept for its history
+)
+
+// ClusterInProgress lists the statuses of a cluster with a job pending or
+// running, which the job leaves when it finishes
+var ClusterInProgress = []ClusterStatus{ClusterPending, ClusterProvisioning, ClusterUpgrading, ClusterReconciling}
+
+// Inventory host statuses
+const (
+	HostReachable   = "reachable"   // facts were collected over SSH
+	HostUnreachable = "unreachable" // SSH failed, see error
+)
+
+// clusterTransitions lists the statuses a cluster may move to from each
+// status. Staying in a status is always allowed.
+var clusterTransitions = map[ClusterStatus][]ClusterStatus{
+	ClusterPending:      {ClusterProvisioning, ClusterFailed, ClusterDestroying},
+	ClusterProvisioning: {ClusterReady, ClusterFailed, ClusterDestroying},
+	ClusterReady:        {ClusterUpgrading, ClusterReconciling, ClusterFailed, ClusterDestroying},
+	ClusterUpgrading:    {ClusterReady, ClusterReconciling, ClusterFailed, ClusterDestroying},
+	ClusterReconciling:  {ClusterUpgrading, ClusterReady, ClusterFailed, ClusterDestroying},
+	ClusterFailed:       {ClusterProvisioning, ClusterUpgrading, ClusterReconciling, ClusterDestroying},
+	ClusterDestroying:   {ClusterDeleted, ClusterFailed},
+	ClusterDeleted:      {},
+}
+
+// ErrInvalidTransition is returned when a cluster cannot move from its
+// current status to the requested one
+var ErrInvalidTransition = errors.New("invalid cluster status transition")
+
+// maxStatusAttempts bounds retries of a status change racing with another
+var maxStatusAttempts = 3
+
+// Valid reports whether s is a known cluster status
+func (s ClusterStatus) Valid() bool {
+	_, ok := clusterTransitions[s]
+	return ok
+}
+
+// CanTransition reports whether a cluster in status s may move to status to
+func (s ClusterStatus) CanTransition(to ClusterStatus) bool {
+	if s == to {
+		return true
+	}
+	for _, target := range clusterTransitions[s] {
+		if target == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCluster inserts a new cluster in the pending status and starts its
+// status history, within tx so callers can create the rest of the cluster
+// in the same transaction
+func CreateCluster(tx *gorm.DB, cluster *Cluster) error {
+	now := time.Now()
+	cluster.Status = ClusterPending
+	cluster.StatusChangedAt = &now
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(cluster).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ClusterStatusChange{ClusterID: cluster.ID, To: ClusterPending, CreatedAt: now}).Error
+	})
+}
+
+// setClusterStatus moves a cluster to status with message as its status
+// message, if its current status allows it or forced is set. The update is
+// conditional on the status read, so concurrent changes cannot skip a
+// transition.
+func setClusterStatus(conn *gorm.DB, id uint, status ClusterStatus, message string, forced bool) error {
+	for attempt := 0; attempt < maxStatusAttempts; attempt++ {
+		var cluster Cluster
+		if err := conn.Select("id", "status").First(&cluster, id).Error; err != nil {
+			return err
+		}
+		if !forced && !cluster.Status.CanTransition(status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, cluster.Status, status)
+		}
+		var changed bool
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			var err error
+			changed, err = changeClusterStatus(tx, id, cluster.Status, status, message, forced)
+			return err
+		})
+		// Some databases count unchanged rows as unaffected
+		if err != nil || changed || cluster.Status == status {
+			return err
+		}
+	}
+	return fmt.Errorf("status of cluster %d changed concurrently", id)
+}
+
+// FailStaleCluster moves a cluster to failed if it is still in status and
+// entered it before cutoff. It reports whether the cluster was failed.
+func FailStaleCluster(conn *gorm.DB, id uint, status ClusterStatus, cutoff time.Time, message string) (bool, error) {
+	if !status.CanTransition(ClusterFailed) {
+		return false, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, status, ClusterFailed)
+	}
+	var changed bool
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changed, err = changeClusterStatus(tx, id, status, ClusterFailed, message, false, func(query *gorm.DB) *gorm.DB {
+			return query.Where("COALESCE(status_changed_at, updated_at) < ?", cutoff)
+		})
+		return err
+	})
+	return changed, err
+}
+
+// deleteCluster moves a destroying cluster to deleted and soft deletes it,
+// keeping its status history
+func deleteCluster(conn *gorm.DB, id uint) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		var cluster Cluster
+		if err := tx.Select("id", "status").First(&cluster, id).Error; err != nil {
+			return err
+		}
+		if !cluster.Status.CanTransition(ClusterDeleted) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, cluster.Status, ClusterDeleted)
+		}
+		changed, err := changeClusterStatus(tx, id, cluster.Status, ClusterDeleted, "", false)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return fmt.Errorf("status of cluster %d changed concurrently", id)
+		}
+		return tx.Delete(&Cluster{}, id).Error
+	})
+}
+
+// ErrNameTaken is returned when restoring a cluster whose name a cluster
+// created since uses
+var ErrNameTaken = errors.New("cluster name is taken")
+
+// restoreCluster brings back a deleted cluster in the failed status, as its
+// nodes may have been reset or their machines deleted since. A reconcile
+// brings it back to ready.
+func restoreCluster(conn *gorm.DB, id uint) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		var cluster Cluster
+		if err := tx.Unscoped().Select("id", "name", "status", "deleted_at").First(&cluster, id).Error; err != nil {
+			return err
+		}
+		if !cluster.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+		var live int64
+		if err := tx.Model(&Cluster{}).Where("name = ?", cluster.Name).Count(&live).Error; err != nil {
+			return err
+		}
+		if live > 0 {
+			return fmt.Errorf("%w: %s", ErrNameTaken, cluster.Name)
+		}
+
+		if err := tx.Unscoped().Model(&Cluster{}).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		changed, err := changeClusterStatus(tx, id, cluster.Status, ClusterFailed, "Restored after deletion, reconcile the cluster to bring it back", true)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return fmt.Errorf("status of cluster %d changed concurrently", id)
+		}
+		return nil
+	})
+}
+
+// changeClusterStatus moves a cluster still in status from to status to,
+// if it matches the scopes, and records the change in its history. It
+// reports false if the cluster did not match.
+func changeClusterStatus(tx *gorm.DB, id uint, from, to ClusterStatus, message string, forced bool, scopes ...func(*gorm.DB) *gorm.DB) (bool, error) {
+	now := time.Now()
+	updates := map[string]interface{}{"status": to, "status_message": message}
+	if from != to {
+		updates["status_changed_at"] = &now
+	}
+	result := tx.Model(&Cluster{}).Where("id = ? AND status = ?", id, from).Scopes(scopes...).Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if from == to && !forced {
+		return true, nil
+	}
+	change := ClusterStatusChange{
+		ClusterID: id,
+		From:      from,
+		To:        to,
+		Message:   message,
+		Forced:    forced,
+		CreatedAt: now,
+	}
+	return true, tx.Create(&change).Error
+}
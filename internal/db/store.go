@@ -0,0 +1,175 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrActiveJob is returned when a cluster already has a pending or running
+// job of the type and target being created
+var ErrActiveJob = errors.New("an active job of this type already exists for the cluster")
+
+// Store gives access to clusters, nodes, jobs and events. Handlers and the
+// job queue are given a Store instead of opening a connection, so they can
+// run against fakes in tests and against other backends later. Records
+// without a store of their own are read through DB.
+type Store struct {
+	Clusters ClusterStore
+	Nodes    NodeStore
+	Jobs     JobStore
+	Events   EventStore
+
+	// conn is the connection of the stores, nil for stores not backed by
+	// GORM
+	conn *gorm.DB
+
+	// transaction runs fn with stores bound to one transaction, nil for
+	// stores without transactions
+	transaction func(fn func(tx *Store) error) error
+}
+
+// DB returns the connection of the stores, for records without a store of
+// their own
+func (s *Store) DB() *gorm.DB {
+	return s.conn
+}
+
+// Transaction runs fn with stores whose changes are committed together
+// when fn returns nil and rolled back otherwise. Stores without
+// transactions run fn on themselves.
+func (s *Store) Transaction(fn func(tx *Store) error) error {
+	if s.transaction == nil {
+		return fn(s)
+	}
+	return s.transaction(fn)
+}
+
+// ClusterFilter selects clusters. Zero fields match every cluster that is
+// not deleted.
+type ClusterFilter struct {
+	IDs        []uint
+	ProjectIDs []uint // when not nil, only clusters of these projects
+	WithNodes  bool
+
+	IncludeDeleted bool // deleted clusters too
+}
+
+// ClusterStore stores clusters and their status history
+type ClusterStore interface {
+	// Get returns a cluster, gorm.ErrRecordNotFound when there is none
+	Get(id uint) (*Cluster, error)
+	// GetWithNodes returns a cluster with its nodes
+	GetWithNodes(id uint) (*Cluster, error)
+	// GetDetails returns a cluster with its nodes, events and status history
+	GetDetails(id uint) (*Cluster, error)
+	List(filter ClusterFilter) ([]Cluster, error)
+	// FindByIdempotencyKey returns the cluster a create request with the key
+	// made in a project
+	FindByIdempotencyKey(projectID uint, key string) (*Cluster, error)
+	// NameTaken reports whether a cluster that is not deleted has the name.
+	// Deleted clusters free their name.
+	NameTaken(name string) (bool, error)
+	// Create inserts a cluster in the pending status
+	Create(cluster *Cluster) error
+	// Update saves the given fields of a cluster
+	Update(cluster *Cluster, fields ...string) error
+	// SetStatus moves a cluster to a status its current status allows
+	SetStatus(id uint, status ClusterStatus, message string) error
+	// ForceStatus sets the status of a cluster regardless of transitions
+	ForceStatus(id uint, status ClusterStatus, message string) error
+	// Delete moves a destroying cluster to deleted and soft deletes it
+	Delete(id uint) error
+	// Restore brings back a deleted cluster in the failed status
+	Restore(id uint) error
+}
+
+// NodeStore stores the nodes of clusters
+type NodeStore interface {
+	// List returns the nodes of a cluster in the order they were added
+	List(clusterID uint) ([]Node, error)
+	// ListByRole returns the nodes of a cluster with a role
+	ListByRole(clusterID uint, role string) ([]Node, error)
+	// ListMachines returns the nodes of a cluster running on created machines
+	ListMachines(clusterID uint) ([]Node, error)
+	Get(clusterID, id uint) (*Node, error)
+	FindByAddress(clusterID uint, address string) (*Node, error)
+	Create(node *Node) error
+	// Update saves the given fields of a node
+	Update(node *Node, fields ...string) error
+	// Delete removes a node for good, so its host can join the cluster again
+	Delete(node *Node) error
+}
+
+// JobFilter selects jobs. Zero fields match every job.
+type JobFilter struct {
+	ClusterID  uint
+	ProjectIDs []uint // when not nil, only jobs of clusters of these projects
+	ParentID   uint
+	Status     string
+	Type       string
+	Limit      int
+}
+
+// JobStore stores the jobs of the queue. Updates of a running job are
+// conditional on the worker owning it, so a replica that lost a job cannot
+// change it.
+type JobStore interface {
+	Get(id uint) (*Job, error)
+	// List returns the newest jobs first
+	List(filter JobFilter) ([]Job, error)
+	// Create inserts a pending job. Jobs of a cluster are exclusive by type
+	// and target: if one is active it is loaded into job and ErrActiveJob is
+	// returned.
+	Create(job *Job) error
+	// FirstClaimable returns the oldest pending job whose cluster has no
+	// running job, nil when there is none
+	FirstClaimable() (*Job, error)
+	// Claim moves a pending job to running for a worker. It reports false
+	// if the job is no longer pending.
+	Claim(id uint, workerID string, at time.Time) (bool, error)
+	// Renew records a heartbeat of a running job. It reports false if the
+	// worker no longer owns the job.
+	Renew(id uint, workerID string, at time.Time) (bool, error)
+	// Requeue returns a running job of a worker to pending
+	Requeue(id uint, workerID string) error
+	// Interrupt returns a running job of a worker stopped by its shutdown to
+	// pending, with phase telling why
+	Interrupt(id uint, workerID, phase string) error
+	// RequeueStale returns running jobs of a worker, or without a heartbeat
+	// since stale, to pending and returns how many
+	RequeueStale(workerID string, stale time.Time) (int64, error)
+	// RequeueAbandoned returns running jobs of other workers without a
+	// heartbeat since stale to pending and returns how many
+	RequeueAbandoned(workerID string, stale time.Time) (int64, error)
+	// CancelPending cancels a job if it is still pending
+	CancelPending(id uint, at time.Time) (bool, error)
+	// MarkCancelled cancels a job whatever its status
+	MarkCancelled(id uint, at time.Time) error
+	// Finish records the final status of a job run by a worker
+	Finish(id uint, workerID, status, message string, at time.Time) error
+	UpdateProgress(id uint, phase string, progress int) error
+	SaveCheckpoint(id uint, checkpoint string) error
+	SaveMetadata(id uint, metadata string) error
+}
+
+// EventFilter selects the events of a cluster. Other zero fields match
+// every event.
+type EventFilter struct {
+	ClusterID   uint
+	Levels      []string // when not nil, only events at these levels
+	Host        string
+	Step        string
+	Phase       string
+	JobID       uint
+	StepChanges bool // only the events starting or ending a step
+	Limit       int
+}
+
+// EventStore stores cluster events
+type EventStore interface {
+	Create(event *Event) error
+	// List returns the newest events first
+	List(filter EventFilter) ([]Event, error)
+}
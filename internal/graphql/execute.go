@@ -0,0 +1,540 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Execute runs a query operation of a request. Subscriptions are run with
+// Subscribe.
+func Execute(ctx context.Context, schema *Schema, req Request) *Response {
+	e, op, errs := prepare(schema, req)
+	if errs != nil {
+		return &Response{Errors: errs}
+	}
+	if op.kind == "subscription" {
+		return &Response{Errors: []*Error{{Message: "Subscriptions are only available over WebSocket", Locations: []Location{op.loc}}}}
+	}
+	return e.query(ctx, op)
+}
+
+// Subscribe runs an operation of a request and sends its results: a single
+// result for a query, a result for every value of the source stream for a
+// subscription, until the stream ends or ctx is done. The channel is closed
+// afterwards. Errors are returned when the operation could not be started.
+func Subscribe(ctx context.Context, schema *Schema, req Request) (<-chan *Response, []*Error) {
+	e, op, errs := prepare(schema, req)
+	if errs != nil {
+		return nil, errs
+	}
+	results := make(chan *Response, 1)
+	if op.kind == "query" {
+		results <- e.query(ctx, op)
+		close(results)
+		return results, nil
+	}
+
+	fields := e.collectFields(e.root, op.selections, nil)
+	if len(fields.keys) != 1 {
+		return nil, []*Error{{Message: "A subscription must select exactly one root field", Locations: []Location{op.loc}}}
+	}
+	key := fields.keys[0]
+	selected := fields.values[key].([]*field)
+	def := e.root.Fields[selected[0].name]
+	args, err := e.coerceArguments(def, selected[0])
+	if err != nil {
+		return nil, []*Error{{Message: err.Error()}}
+	}
+	source, err := def.Subscribe(ctx, args)
+	if err != nil {
+		return nil, []*Error{{Message: err.Error(), Path: []interface{}{key}}}
+	}
+
+	go func() {
+		defer close(results)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-source:
+				if !ok {
+					return
+				}
+				event := &executor{root: e.root, doc: e.doc, variables: e.variables}
+				data := newOrderedMap()
+				data.set(key, event.complete(ctx, def, value, mergeSelections(selected), []interface{}{key}))
+				select {
+				case results <- &Response{Data: data, Errors: event.errors}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return results, nil
+}
+
+// executor runs an operation of a document
+type executor struct {
+	root      *Object // Query or Subscription of the schema
+	doc       *document
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+// prepare parses and validates a request and coerces its variables
+func prepare(schema *Schema, req Request) (*executor, *operation, []*Error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, nil, []*Error{{Message: "The query is missing"}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, nil, []*Error{err.(*Error)}
+	}
+
+	var op *operation
+	for _, candidate := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) > 1 {
+			return nil, nil, []*Error{{Message: "The operation name is required when the document has several operations"}}
+		}
+		if req.OperationName == "" || candidate.name == req.OperationName {
+			op = candidate
+			break
+		}
+	}
+	if op == nil {
+		return nil, nil, []*Error{{Message: fmt.Sprintf("Unknown operation %q", req.OperationName)}}
+	}
+
+	root := schema.Query
+	if op.kind == "subscription" {
+		if schema.Subscription == nil {
+			return nil, nil, []*Error{{Message: "The schema has no subscriptions", Locations: []Location{op.loc}}}
+		}
+		root = schema.Subscription
+	}
+	v := &validator{doc: doc, variables: make(map[string]*variableDef)}
+	for _, def := range op.variables {
+		if _, ok := v.variables[def.name]; ok {
+			v.fail(def.loc, "There can be only one variable named $%s", def.name)
+		}
+		if err := checkType(def.typ); err != nil {
+			v.fail(def.loc, "Variable $%s: %s", def.name, err)
+		}
+		v.variables[def.name] = def
+	}
+	v.selectionSet(root, op.selections)
+	if len(v.errors) > 0 {
+		return nil, nil, v.errors
+	}
+
+	e := &executor{root: root, doc: doc, variables: make(map[string]interface{})}
+	for _, def := range op.variables {
+		value, given := req.Variables[def.name]
+		if !given {
+			if !def.hasDefault {
+				if strings.HasSuffix(def.typ, "!") {
+					return nil, nil, []*Error{{Message: fmt.Sprintf("Variable $%s of required type %s was not provided", def.name, def.typ), Locations: []Location{def.loc}}}
+				}
+				continue
+			}
+			value = def.defaultValue
+		}
+		coerced, err := coerceInput(def.typ, value)
+		if err != nil {
+			return nil, nil, []*Error{{Message: fmt.Sprintf("Variable $%s: %s", def.name, err), Locations: []Location{def.loc}}}
+		}
+		e.variables[def.name] = coerced
+	}
+	return e, op, nil
+}
+
+// query runs a query operation
+func (e *executor) query(ctx context.Context, op *operation) *Response {
+	data := e.selectionSet(ctx, e.root, op.selections, nil, nil)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+// selectionSet resolves the selected fields of an object
+func (e *executor) selectionSet(ctx context.Context, object *Object, selections []*selection, source interface{}, path []interface{}) *orderedMap {
+	result := newOrderedMap()
+	fields := e.collectFields(object, selections, nil)
+	for _, key := range fields.keys {
+		selected := fields.values[key].([]*field)
+		fieldPath := append(append([]interface{}(nil), path...), key)
+		if selected[0].name == "__typename" {
+			result.set(key, object.Name)
+			continue
+		}
+		def := object.Fields[selected[0].name]
+		args, err := e.coerceArguments(def, selected[0])
+		if err != nil {
+			e.errors = append(e.errors, &Error{Message: err.Error(), Path: fieldPath})
+			result.set(key, nil)
+			continue
+		}
+		value, err := def.Resolve(ctx, source, args)
+		if err != nil {
+			e.errors = append(e.errors, &Error{Message: err.Error(), Path: fieldPath})
+			result.set(key, nil)
+			continue
+		}
+		result.set(key, e.complete(ctx, def, value, mergeSelections(selected), fieldPath))
+	}
+	return result
+}
+
+// complete turns a resolved value into its response: objects by their
+// selection set, lists item by item and scalars as they are
+func (e *executor) complete(ctx context.Context, def *Field, value interface{}, selections []*selection, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil
+	}
+	if def.Type == nil {
+		return value
+	}
+	if def.List {
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			e.errors = append(e.errors, &Error{Message: "Expected a list", Path: path})
+			return nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			itemPath := append(append([]interface{}(nil), path...), i)
+			items[i] = e.selectionSet(ctx, def.Type, selections, v.Index(i).Interface(), itemPath)
+		}
+		return items
+	}
+	return e.selectionSet(ctx, def.Type, selections, value, path)
+}
+
+// mergeSelections joins the selection sets of the fields selected under the
+// same response key
+func mergeSelections(fields []*field) []*selection {
+	if len(fields) == 1 {
+		return fields[0].selections
+	}
+	var selections []*selection
+	for _, f := range fields {
+		selections = append(selections, f.selections...)
+	}
+	return selections
+}
+
+// collectFields groups the fields selected on an object by response key,
+// following fragments and the include and skip directives
+func (e *executor) collectFields(object *Object, selections []*selection, fields *orderedMap) *orderedMap {
+	if fields == nil {
+		fields = newOrderedMap()
+	}
+	for _, sel := range selections {
+		if !e.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.field != nil:
+			key := sel.field.responseKey()
+			existing, _ := fields.values[key].([]*field)
+			fields.set(key, append(existing, sel.field))
+		case sel.inline != nil:
+			if sel.inline.typeCondition == "" || sel.inline.typeCondition == object.Name {
+				e.collectFields(object, sel.inline.selections, fields)
+			}
+		default:
+			if frag := e.doc.fragments[sel.spread]; frag.typeCondition == object.Name {
+				e.collectFields(object, frag.selections, fields)
+			}
+		}
+	}
+	return fields
+}
+
+// included evaluates the include and skip directives of a selection
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		condition := false
+		for _, arg := range d.arguments {
+			if arg.name == "if" {
+				condition, _ = e.resolve(arg.value).(bool)
+			}
+		}
+		if d.name == "include" && !condition || d.name == "skip" && condition {
+			return false
+		}
+	}
+	return true
+}
+
+// coerceArguments coerces the arguments given to a field to their types
+func (e *executor) coerceArguments(def *Field, f *field) (Args, error) {
+	args := make(Args, len(f.arguments))
+	for _, arg := range f.arguments {
+		value := arg.value
+		if ref, ok := value.(variableRef); ok {
+			var given bool
+			if value, given = e.variables[string(ref)]; !given {
+				continue
+			}
+		}
+		coerced, err := coerceInput(def.Args[arg.name], e.resolve(value))
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", arg.name, err)
+		}
+		args[arg.name] = coerced
+	}
+	for name, typ := range def.Args {
+		if _, ok := args[name]; !ok && strings.HasSuffix(typ, "!") {
+			return nil, fmt.Errorf("argument %s of required type %s was not provided", name, typ)
+		}
+	}
+	return args, nil
+}
+
+// resolve replaces the variables of a value by their values
+func (e *executor) resolve(value interface{}) interface{} {
+	switch v := value.(type) {
+	case variableRef:
+		return e.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.resolve(item)
+		}
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[key] = e.resolve(item)
+		}
+		return object
+	}
+	return value
+}
+
+// coerceInput converts a literal or JSON variable value to a type
+func coerceInput(typ string, value interface{}) (interface{}, error) {
+	if strings.HasSuffix(typ, "!") {
+		if value == nil {
+			return nil, fmt.Errorf("expected a non-null %s", strings.TrimSuffix(typ, "!"))
+		}
+		typ = strings.TrimSuffix(typ, "!")
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		item := typ[1 : len(typ)-1]
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(list))
+		for i, v := range list {
+			c, err := coerceInput(item, v)
+			if err != nil {
+				return nil, err
+			}
+			coerced[i] = c
+		}
+		return coerced, nil
+	}
+
+	switch typ {
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatFloat(v, 'f', -1, 64), nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+	return nil, fmt.Errorf("%s cannot represent %v", typ, describeValue(value))
+}
+
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case enumValue:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// checkType reports whether a type reference names a supported input type
+func checkType(typ string) error {
+	base := strings.Trim(typ, "[]!")
+	switch base {
+	case "Int", "Float", "String", "Boolean", "ID":
+		return nil
+	}
+	return fmt.Errorf("unknown type %s", base)
+}
+
+// validator checks a document against the schema before it runs
+type validator struct {
+	doc       *document
+	variables map[string]*variableDef
+	errors    []*Error
+	spreading []string // fragments being validated, to detect cycles
+}
+
+func (v *validator) fail(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}})
+}
+
+func (v *validator) selectionSet(object *Object, selections []*selection) {
+	for _, sel := range selections {
+		v.directives(sel)
+		switch {
+		case sel.field != nil:
+			v.field(object, sel)
+		case sel.inline != nil:
+			if c := sel.inline.typeCondition; c != "" && c != object.Name {
+				v.fail(sel.loc, "Fragment on %s cannot be spread on %s", c, object.Name)
+				continue
+			}
+			v.selectionSet(object, sel.inline.selections)
+		default:
+			frag, ok := v.doc.fragments[sel.spread]
+			if !ok {
+				v.fail(sel.loc, "Unknown fragment %q", sel.spread)
+				continue
+			}
+			if frag.typeCondition != object.Name {
+				v.fail(sel.loc, "Fragment %s on %s cannot be spread on %s", frag.name, frag.typeCondition, object.Name)
+				continue
+			}
+			for _, name := range v.spreading {
+				if name == frag.name {
+					v.fail(sel.loc, "Fragment %s spreads itself", frag.name)
+					return
+				}
+			}
+			v.spreading = append(v.spreading, frag.name)
+			v.selectionSet(object, frag.selections)
+			v.spreading = v.spreading[:len(v.spreading)-1]
+		}
+	}
+}
+
+func (v *validator) field(object *Object, sel *selection) {
+	f := sel.field
+	if f.name == "__typename" {
+		if f.selections != nil || f.arguments != nil {
+			v.fail(sel.loc, "Field __typename takes no arguments or selection")
+		}
+		return
+	}
+	def, ok := object.Fields[f.name]
+	if !ok {
+		v.fail(sel.loc, "Cannot query field %q on type %q", f.name, object.Name)
+		return
+	}
+
+	given := make(map[string]bool, len(f.arguments))
+	for _, arg := range f.arguments {
+		typ, ok := def.Args[arg.name]
+		switch {
+		case !ok:
+			v.fail(arg.loc, "Unknown argument %q on field %s.%s", arg.name, object.Name, f.name)
+		case given[arg.name]:
+			v.fail(arg.loc, "There can be only one argument named %q", arg.name)
+		default:
+			v.value(arg.loc, typ, arg.value)
+		}
+		given[arg.name] = true
+	}
+	for name, typ := range def.Args {
+		if !given[name] && strings.HasSuffix(typ, "!") {
+			v.fail(sel.loc, "Field %s.%s argument %q of type %s is required", object.Name, f.name, name, typ)
+		}
+	}
+
+	switch {
+	case def.Type == nil && f.selections != nil:
+		v.fail(sel.loc, "Field %q must not have a selection since it is a scalar", f.name)
+	case def.Type != nil && f.selections == nil:
+		v.fail(sel.loc, "Field %q of type %q must have a selection of subfields", f.name, def.Type.Name)
+	case def.Type != nil:
+		v.selectionSet(def.Type, f.selections)
+	}
+}
+
+// value checks a literal against a type, variables against their
+// definitions
+func (v *validator) value(loc Location, typ string, value interface{}) {
+	switch val := value.(type) {
+	case variableRef:
+		if _, ok := v.variables[string(val)]; !ok {
+			v.fail(loc, "Variable $%s is not defined", val)
+		}
+		return
+	case []interface{}:
+		if strings.HasPrefix(strings.TrimSuffix(typ, "!"), "[") {
+			item := strings.TrimSuffix(typ, "!")
+			item = item[1 : len(item)-1]
+			for _, element := range val {
+				v.value(loc, item, element)
+			}
+			return
+		}
+	}
+	if _, err := coerceInput(typ, value); err != nil {
+		v.fail(loc, "Invalid value: %s", err)
+	}
+}
+
+// directives checks the directives of a selection, only include and skip
+// are supported
+func (v *validator) directives(sel *selection) {
+	for _, d := range sel.directives {
+		if d.name != "include" && d.name != "skip" {
+			v.fail(d.loc, "Unknown directive @%s", d.name)
+			continue
+		}
+		if len(d.arguments) != 1 || d.arguments[0].name != "if" {
+			v.fail(d.loc, "Directive @%s requires the argument if", d.name)
+			continue
+		}
+		v.value(d.arguments[0].loc, "Boolean!", d.arguments[0].value)
+	}
+}
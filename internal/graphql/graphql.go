@@ -0,0 +1,308 @@
+// Package graphql runs GraphQL queries and subscriptions against a schema of
+// objects whose fields are resolved by Go functions. It supports what API
+// clients need to select nested data in one request: operations with
+// variables, aliases, arguments, fragments and the include and skip
+// directives. Mutations and introspection are not supported, the schema is
+// published as SDL instead.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Request is a GraphQL request as sent over HTTP or WebSocket
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of an operation. Data is nil when the request
+// could not be executed at all.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error of a request, located in the document for syntax and
+// validation errors and by response path for field errors
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Location is a position in the request document
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Schema is the root objects of the operations
+type Schema struct {
+	Query        *Object
+	Subscription *Object // nil when there are no subscriptions
+}
+
+// Object is a type with fields
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an object. Fields of an object type are resolved into
+// values of that type, or lists of them; other fields are scalars and are
+// encoded as JSON.
+type Field struct {
+	Type   *Object // nil for scalar fields
+	List   bool    // the field is a list of Type
+	Scalar string  // GraphQL type of a scalar field in the SDL, JSON when empty
+	// Args are the arguments the field accepts by name, with their GraphQL
+	// type: Int, Float, String, Boolean, ID or lists of them, ! when required
+	Args map[string]string
+	// Resolve returns the value of the field of the source object, the
+	// object itself is nil for root fields
+	Resolve func(ctx context.Context, source interface{}, args Args) (interface{}, error)
+	// Subscribe returns the values of a subscription field, until the
+	// channel is closed or ctx is done
+	Subscribe func(ctx context.Context, args Args) (<-chan interface{}, error)
+}
+
+// NewObject creates an object type with a scalar field for each field of
+// the JSON encoding of model, a struct, and the given fields, which replace
+// the scalar fields of the same name. Fields hidden from JSON are not part
+// of the object.
+func NewObject(name string, model interface{}, fields map[string]*Field) *Object {
+	object := &Object{Name: name, Fields: make(map[string]*Field)}
+	if model != nil {
+		addStructFields(object, reflect.TypeOf(model), nil)
+	}
+	for fieldName, f := range fields {
+		object.Fields[fieldName] = f
+	}
+	return object
+}
+
+// addStructFields adds a scalar field for each JSON field of a struct type,
+// with the fields of embedded structs
+func addStructFields(object *Object, typ reflect.Type, index []int) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" || !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		name, _, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			addStructFields(object, sf.Type, fieldIndex)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if _, ok := object.Fields[name]; ok {
+			continue
+		}
+		object.Fields[name] = &Field{Scalar: scalarType(name, sf.Type), Resolve: structField(fieldIndex)}
+	}
+}
+
+// structField resolves a scalar field from the struct field at index
+func structField(index []int) func(context.Context, interface{}, Args) (interface{}, error) {
+	return func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+		v := reflect.ValueOf(source)
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		v, err := v.FieldByIndexErr(index)
+		if err != nil {
+			return nil, nil
+		}
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return nil, nil
+		}
+		return v.Interface(), nil
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// scalarType returns the GraphQL type of a Go field for the SDL
+func scalarType(name string, typ reflect.Type) string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return "String"
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return "String"
+	case reflect.Bool:
+		return "Boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if name == "id" {
+			return "ID"
+		}
+		return "Int"
+	case reflect.Float32, reflect.Float64:
+		return "Float"
+	}
+	return "JSON"
+}
+
+// SDL describes the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	b.WriteString("scalar JSON\n\nschema {\n  query: " + s.Query.Name + "\n")
+	if s.Subscription != nil {
+		b.WriteString("  subscription: " + s.Subscription.Name + "\n")
+	}
+	b.WriteString("}\n")
+
+	seen := make(map[string]bool)
+	var write func(object *Object)
+	write = func(object *Object) {
+		if seen[object.Name] {
+			return
+		}
+		seen[object.Name] = true
+		b.WriteString("\ntype " + object.Name + " {\n")
+		var related []*Object
+		for _, name := range sortedKeys(object.Fields) {
+			f := object.Fields[name]
+			b.WriteString("  " + name)
+			if len(f.Args) > 0 {
+				var args []string
+				for _, arg := range sortedKeys(f.Args) {
+					args = append(args, arg+": "+f.Args[arg])
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			typ := f.Scalar
+			if f.Type != nil {
+				typ = f.Type.Name
+				related = append(related, f.Type)
+			} else if typ == "" {
+				typ = "JSON"
+			}
+			if f.List {
+				typ = "[" + typ + "!]"
+			}
+			b.WriteString(": " + typ + "\n")
+		}
+		b.WriteString("}\n")
+		for _, object := range related {
+			write(object)
+		}
+	}
+	write(s.Query)
+	if s.Subscription != nil {
+		write(s.Subscription)
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Args are the coerced arguments of a field: int, float64, string, bool or
+// []interface{} of them by their declared type, IDs as strings. Arguments
+// that were not given are missing, null ones are nil.
+type Args map[string]interface{}
+
+// String returns a string or ID argument, "" when it is missing
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns an Int argument, def when it is missing
+func (a Args) Int(name string, def int) int {
+	if n, ok := a[name].(int); ok {
+		return n
+	}
+	return def
+}
+
+// Bool returns a Boolean argument, false when it is missing
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Strings returns a list of strings or IDs argument, nil when it is missing
+func (a Args) Strings(name string) []string {
+	list, ok := a[name].([]interface{})
+	if !ok {
+		return nil
+	}
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// orderedMap is an object of the response, its fields in the order they
+// were selected
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON encodes the fields in order
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		b.Write(name)
+		b.WriteByte(':')
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
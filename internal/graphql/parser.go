@@ -0,0 +1,609 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request: its operations and fragments
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is a query or subscription of a document
+type operation struct {
+	kind       string // query or subscription
+	name       string
+	variables  []*variableDef
+	selections []*selection
+	loc        Location
+}
+
+// variableDef declares a variable of an operation
+type variableDef struct {
+	name         string
+	typ          string // GraphQL type reference, like [ID!]!
+	defaultValue interface{}
+	hasDefault   bool
+	loc          Location
+}
+
+// fragment is a named selection set on a type
+type fragment struct {
+	name          string
+	typeCondition string
+	selections    []*selection
+	loc           Location
+}
+
+// selection is a field, a fragment spread or an inline fragment
+type selection struct {
+	field      *field
+	spread     string // name of the spread fragment
+	inline     *inlineFragment
+	directives []*directive
+	loc        Location
+}
+
+// field selects a field of an object, under its alias when set
+type field struct {
+	alias      string
+	name       string
+	arguments  []*argument
+	selections []*selection
+}
+
+// responseKey is the key of the field in the response
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// inlineFragment is a selection set applied when the type matches, always
+// when it has no type condition
+type inlineFragment struct {
+	typeCondition string
+	selections    []*selection
+}
+
+type argument struct {
+	name  string
+	value interface{}
+	loc   Location
+}
+
+type directive struct {
+	name      string
+	arguments []*argument
+	loc       Location
+}
+
+// Values of the document other than scalars, lists and input objects
+type (
+	variableRef string // $name
+	enumValue   string // bare name
+)
+
+// Token kinds of the lexer
+const (
+	tokenEOF = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  int
+	value string
+	loc   Location
+}
+
+// parser is a recursive descent parser of executable GraphQL documents
+type parser struct {
+	source string
+	pos    int
+	line   int
+	col    int
+	token  token
+}
+
+// parse reads a request document: operations and fragments, without type
+// system definitions
+func parse(source string) (doc *document, err error) {
+	p := &parser{source: source, line: 1, col: 1}
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = parseErr
+		}
+	}()
+
+	p.next()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunctuator, "{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", loc: p.token.loc, selections: p.selectionSet()})
+		case p.peek(tokenName, "fragment"):
+			frag := p.fragmentDefinition()
+			if _, ok := doc.fragments[frag.name]; ok {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q", frag.name), Locations: []Location{frag.loc}}
+			}
+			doc.fragments[frag.name] = frag
+		case p.token.kind == tokenName:
+			doc.operations = append(doc.operations, p.operationDefinition())
+		default:
+			p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "The document has no operation"}
+	}
+	return doc, nil
+}
+
+func (p *parser) operationDefinition() *operation {
+	op := &operation{loc: p.token.loc}
+	switch p.token.value {
+	case "query", "subscription":
+		op.kind = p.token.value
+	case "mutation":
+		p.fail("Mutations are not supported")
+	default:
+		p.unexpected()
+	}
+	p.next()
+	if p.token.kind == tokenName {
+		op.name = p.name()
+	}
+	if p.skip(tokenPunctuator, "(") {
+		for !p.skip(tokenPunctuator, ")") {
+			def := &variableDef{loc: p.token.loc}
+			p.expect(tokenPunctuator, "$")
+			def.name = p.name()
+			p.expect(tokenPunctuator, ":")
+			def.typ = p.typeReference()
+			if p.skip(tokenPunctuator, "=") {
+				def.defaultValue, def.hasDefault = p.value(true), true
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	if p.peek(tokenPunctuator, "@") {
+		p.fail("Directives on operations are not supported")
+	}
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *parser) fragmentDefinition() *fragment {
+	frag := &fragment{loc: p.token.loc}
+	p.next()
+	frag.name = p.name()
+	if frag.name == "on" {
+		p.fail("A fragment cannot be named on")
+	}
+	p.expectName("on")
+	frag.typeCondition = p.name()
+	if p.peek(tokenPunctuator, "@") {
+		p.fail("Directives on fragments are not supported")
+	}
+	frag.selections = p.selectionSet()
+	return frag
+}
+
+func (p *parser) selectionSet() []*selection {
+	p.expect(tokenPunctuator, "{")
+	var selections []*selection
+	for !p.skip(tokenPunctuator, "}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail("A selection set cannot be empty")
+	}
+	return selections
+}
+
+func (p *parser) selection() *selection {
+	sel := &selection{loc: p.token.loc}
+	if p.skip(tokenPunctuator, "...") {
+		switch {
+		case p.token.kind == tokenName && p.token.value != "on":
+			sel.spread = p.name()
+			sel.directives = p.directives()
+		default:
+			inline := &inlineFragment{}
+			if p.token.kind == tokenName {
+				p.next()
+				inline.typeCondition = p.name()
+			}
+			sel.directives = p.directives()
+			inline.selections = p.selectionSet()
+			sel.inline = inline
+		}
+		return sel
+	}
+
+	f := &field{name: p.name()}
+	if p.skip(tokenPunctuator, ":") {
+		f.alias, f.name = f.name, p.name()
+	}
+	f.arguments = p.arguments()
+	sel.directives = p.directives()
+	if p.peek(tokenPunctuator, "{") {
+		f.selections = p.selectionSet()
+	}
+	sel.field = f
+	return sel
+}
+
+func (p *parser) arguments() []*argument {
+	if !p.skip(tokenPunctuator, "(") {
+		return nil
+	}
+	var args []*argument
+	for !p.skip(tokenPunctuator, ")") {
+		arg := &argument{loc: p.token.loc, name: p.name()}
+		p.expect(tokenPunctuator, ":")
+		arg.value = p.value(false)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var directives []*directive
+	for p.peek(tokenPunctuator, "@") {
+		d := &directive{loc: p.token.loc}
+		p.next()
+		d.name = p.name()
+		d.arguments = p.arguments()
+		directives = append(directives, d)
+	}
+	return directives
+}
+
+// value reads a value literal, without variables when constant
+func (p *parser) value(constant bool) interface{} {
+	tok := p.token
+	switch tok.kind {
+	case tokenInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.value, 10, 32)
+		if err != nil {
+			p.failAt(tok.loc, fmt.Sprintf("Int cannot represent %s", tok.value))
+		}
+		return int(n)
+	case tokenFloat:
+		p.next()
+		f, _ := strconv.ParseFloat(tok.value, 64)
+		return f
+	case tokenString:
+		p.next()
+		return tok.value
+	case tokenName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(tok.value)
+	}
+
+	switch {
+	case p.skip(tokenPunctuator, "$"):
+		if constant {
+			p.failAt(tok.loc, "Unexpected variable in a constant value")
+		}
+		return variableRef(p.name())
+	case p.skip(tokenPunctuator, "["):
+		list := []interface{}{}
+		for !p.skip(tokenPunctuator, "]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip(tokenPunctuator, "{"):
+		object := map[string]interface{}{}
+		for !p.skip(tokenPunctuator, "}") {
+			name := p.name()
+			p.expect(tokenPunctuator, ":")
+			object[name] = p.value(constant)
+		}
+		return object
+	}
+	p.unexpected()
+	return nil
+}
+
+// typeReference reads a type like [ID!]!
+func (p *parser) typeReference() string {
+	var typ string
+	if p.skip(tokenPunctuator, "[") {
+		typ = "[" + p.typeReference() + "]"
+		p.expect(tokenPunctuator, "]")
+	} else {
+		typ = p.name()
+	}
+	if p.skip(tokenPunctuator, "!") {
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *parser) name() string {
+	if p.token.kind != tokenName {
+		p.unexpected()
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *parser) expectName(name string) {
+	if !p.peek(tokenName, name) {
+		p.unexpected()
+	}
+	p.next()
+}
+
+func (p *parser) expect(kind int, value string) {
+	if !p.skip(kind, value) {
+		p.fail(fmt.Sprintf("Expected %q, found %s", value, p.describe()))
+	}
+}
+
+// skip consumes the current token if it matches
+func (p *parser) skip(kind int, value string) bool {
+	if !p.peek(kind, value) {
+		return false
+	}
+	p.next()
+	return true
+}
+
+func (p *parser) peek(kind int, value string) bool {
+	return p.token.kind == kind && p.token.value == value
+}
+
+func (p *parser) unexpected() {
+	p.fail("Unexpected " + p.describe())
+}
+
+func (p *parser) describe() string {
+	switch p.token.kind {
+	case tokenEOF:
+		return "end of document"
+	case tokenString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", p.token.value)
+}
+
+func (p *parser) fail(message string) {
+	p.failAt(p.token.loc, message)
+}
+
+func (p *parser) failAt(loc Location, message string) {
+	panic(&Error{Message: "Syntax error: " + message, Locations: []Location{loc}})
+}
+
+// next reads the following token, skipping whitespace, commas and comments
+func (p *parser) next() {
+	p.skipIgnored()
+	loc := Location{Line: p.line, Column: p.col}
+	if p.pos >= len(p.source) {
+		p.token = token{kind: tokenEOF, loc: loc}
+		return
+	}
+	c := p.source[p.pos]
+	switch {
+	case strings.HasPrefix(p.source[p.pos:], "..."):
+		p.advance(3)
+		p.token = token{kind: tokenPunctuator, value: "...", loc: loc}
+	case strings.ContainsRune("!$():=@[]{}|", rune(c)):
+		p.advance(1)
+		p.token = token{kind: tokenPunctuator, value: string(c), loc: loc}
+	case c == '_' || isLetter(c):
+		start := p.pos
+		for p.pos < len(p.source) && (p.source[p.pos] == '_' || isLetter(p.source[p.pos]) || isDigit(p.source[p.pos])) {
+			p.advance(1)
+		}
+		p.token = token{kind: tokenName, value: p.source[start:p.pos], loc: loc}
+	case c == '-' || isDigit(c):
+		p.token = p.number(loc)
+	case c == '"':
+		p.token = token{kind: tokenString, value: p.string(loc), loc: loc}
+	default:
+		r, _ := utf8.DecodeRuneInString(p.source[p.pos:])
+		p.token = token{loc: loc}
+		p.failAt(loc, fmt.Sprintf("Unexpected character %q", r))
+	}
+}
+
+// skipIgnored skips whitespace, commas, comments and byte order marks
+func (p *parser) skipIgnored() {
+	for p.pos < len(p.source) {
+		switch c := p.source[p.pos]; {
+		case c == '\n':
+			p.pos++
+			p.line++
+			p.col = 1
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.advance(1)
+		case c == '#':
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' {
+				p.advance(1)
+			}
+		case strings.HasPrefix(p.source[p.pos:], "\uFEFF"):
+			p.advance(len("\uFEFF"))
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) number(loc Location) token {
+	start := p.pos
+	if p.source[p.pos] == '-' {
+		p.advance(1)
+	}
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.source) && isDigit(p.source[p.pos]) {
+			p.advance(1)
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		p.failAt(loc, "Invalid number")
+	}
+	kind := tokenInt
+	if p.pos < len(p.source) && p.source[p.pos] == '.' {
+		p.advance(1)
+		kind = tokenFloat
+		if digits() == 0 {
+			p.failAt(loc, "Invalid number")
+		}
+	}
+	if p.pos < len(p.source) && (p.source[p.pos] == 'e' || p.source[p.pos] == 'E') {
+		p.advance(1)
+		kind = tokenFloat
+		if p.pos < len(p.source) && (p.source[p.pos] == '+' || p.source[p.pos] == '-') {
+			p.advance(1)
+		}
+		if digits() == 0 {
+			p.failAt(loc, "Invalid number")
+		}
+	}
+	return token{kind: kind, value: p.source[start:p.pos], loc: loc}
+}
+
+// string reads a string or block string literal and returns its value
+func (p *parser) string(loc Location) string {
+	if strings.HasPrefix(p.source[p.pos:], `"""`) {
+		p.advance(3)
+		end := strings.Index(p.source[p.pos:], `"""`)
+		if end < 0 {
+			p.failAt(loc, "Unterminated string")
+		}
+		raw := p.source[p.pos : p.pos+end]
+		for _, c := range []byte(raw) {
+			if c == '\n' {
+				p.line++
+				p.col = 0
+			}
+			p.col++
+		}
+		p.pos += end
+		p.advance(3)
+		return blockStringValue(raw)
+	}
+
+	p.advance(1)
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.source) || p.source[p.pos] == '\n' {
+			p.failAt(loc, "Unterminated string")
+		}
+		c := p.source[p.pos]
+		switch c {
+		case '"':
+			p.advance(1)
+			return b.String()
+		case '\\':
+			if p.pos+1 >= len(p.source) {
+				p.failAt(loc, "Unterminated string")
+			}
+			escape := p.source[p.pos+1]
+			p.advance(2)
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.source) {
+					p.failAt(loc, "Invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.source[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.failAt(loc, "Invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				p.advance(4)
+			default:
+				p.failAt(loc, fmt.Sprintf("Invalid escape \\%c", escape))
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(p.source[p.pos:])
+			b.WriteString(p.source[p.pos : p.pos+size])
+			p.advance(size)
+		}
+	}
+}
+
+// blockStringValue removes the common indentation and the blank first and
+// last lines of a block string
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = ""
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *parser) advance(n int) {
+	p.pos += n
+	p.col += n
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,306 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// defaultTimeout bounds waiting for the resources of a release when the
+// chart spec sets no timeout, as helm does
+const defaultTimeout = 5 * time.Minute
+
+// Client manages the Helm releases of a cluster through its API server with
+// the Helm SDK, so nothing needs to be installed on the nodes
+type Client struct {
+	kubeconfig []byte
+	settings   *cli.EnvSettings
+}
+
+// ChartSpec describes a chart to install or upgrade
+type ChartSpec struct {
+	ReleaseName string                 `json:"release_name"`
+	Namespace   string                 `json:"namespace"`
+	RepoURL     string                 `json:"repo_url,omitempty"` // empty for OCI or local charts
+	Chart       string                 `json:"chart"`
+	Version     string                 `json:"version,omitempty"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+	Wait        bool                   `json:"wait"`
+	Timeout     time.Duration          `json:"timeout,omitempty"`
+}
+
+// Release describes a deployed helm release as reported by helm
+type Release struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Revision   string `json:"revision"`
+	Updated    string `json:"updated"`
+	Status     string `json:"status"`
+	Chart      string `json:"chart"`
+	AppVersion string `json:"app_version"`
+}
+
+// Revision describes a single entry of a release history
+type Revision struct {
+	Revision    int    `json:"revision"`
+	Updated     string `json:"updated"`
+	Status      string `json:"status"`
+	Chart       string `json:"chart"`
+	AppVersion  string `json:"app_version"`
+	Description string `json:"description"`
+}
+
+// NewClient creates a helm client for the cluster of an admin kubeconfig
+func NewClient(kubeconfig []byte) *Client {
+	return &Client{kubeconfig: kubeconfig, settings: cli.New()}
+}
+
+// UpgradeInstall installs a chart, or upgrades the release if it already exists
+func (c *Client) UpgradeInstall(ctx context.Context, spec ChartSpec) (*Release, error) {
+	if spec.ReleaseName == "" || spec.Chart == "" {
+		return nil, fmt.Errorf("release name and chart are required")
+	}
+	if spec.Namespace == "" {
+		spec.Namespace = "default"
+	}
+	if spec.Timeout == 0 {
+		spec.Timeout = defaultTimeout
+	}
+
+	config, err := c.configuration(spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	history := action.NewHistory(config)
+	history.Max = 1
+	_, err = history.Run(spec.ReleaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, fmt.Errorf("helm history failed: %w", err)
+	}
+
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		install := action.NewInstall(config)
+		install.SetRegistryClient(config.RegistryClient)
+		install.ReleaseName = spec.ReleaseName
+		install.Namespace = spec.Namespace
+		install.CreateNamespace = true
+		install.Wait = spec.Wait
+		install.Timeout = spec.Timeout
+		install.RepoURL = spec.RepoURL
+		install.Version = spec.Version
+
+		chrt, err := c.loadChart(&install.ChartPathOptions, spec.Chart)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := install.RunWithContext(ctx, chrt, spec.Values)
+		if err != nil {
+			return nil, fmt.Errorf("helm install failed: %w", err)
+		}
+		return releaseOf(rel), nil
+	}
+
+	upgrade := action.NewUpgrade(config)
+	upgrade.SetRegistryClient(config.RegistryClient)
+	upgrade.Namespace = spec.Namespace
+	upgrade.Wait = spec.Wait
+	upgrade.Timeout = spec.Timeout
+	upgrade.RepoURL = spec.RepoURL
+	upgrade.Version = spec.Version
+
+	chrt, err := c.loadChart(&upgrade.ChartPathOptions, spec.Chart)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := upgrade.RunWithContext(ctx, spec.ReleaseName, chrt, spec.Values)
+	if err != nil {
+		return nil, fmt.Errorf("helm upgrade failed: %w", err)
+	}
+	return releaseOf(rel), nil
+}
+
+// Status returns the current state of a release
+func (c *Client) Status(ctx context.Context, name, namespace string) (*Release, error) {
+	config, err := c.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := action.NewStatus(config).Run(name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, fmt.Errorf("release %s not found in namespace %s", name, namespace)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("helm status failed: %w", err)
+	}
+	return releaseOf(rel), nil
+}
+
+// List returns releases in a namespace, or in all namespaces when namespace is empty
+func (c *Client) List(ctx context.Context, namespace string) ([]Release, error) {
+	config, err := c.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	list := action.NewList(config)
+	list.All = true
+	list.AllNamespaces = namespace == ""
+	list.SetStateMask()
+	rels, err := list.Run()
+	if err != nil {
+		return nil, fmt.Errorf("helm list failed: %w", err)
+	}
+
+	releases := make([]Release, 0, len(rels))
+	for _, rel := range rels {
+		releases = append(releases, *releaseOf(rel))
+	}
+	return releases, nil
+}
+
+// History returns the revision history of a release
+func (c *Client) History(ctx context.Context, name, namespace string) ([]Revision, error) {
+	config, err := c.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+	rels, err := action.NewHistory(config).Run(name)
+	if err != nil {
+		return nil, fmt.Errorf("helm history failed: %w", err)
+	}
+
+	history := make([]Revision, 0, len(rels))
+	for _, rel := range rels {
+		revision := Revision{
+			Revision: rel.Version,
+			Chart:    chartName(rel),
+		}
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			revision.AppVersion = rel.Chart.Metadata.AppVersion
+		}
+		if rel.Info != nil {
+			revision.Updated = rel.Info.LastDeployed.Format(time.RFC3339)
+			revision.Status = rel.Info.Status.String()
+			revision.Description = rel.Info.Description
+		}
+		history = append(history, revision)
+	}
+	return history, nil
+}
+
+// Rollback rolls a release back to a revision (0 means the previous one)
+func (c *Client) Rollback(ctx context.Context, name, namespace string, revision int) error {
+	config, err := c.configuration(namespace)
+	if err != nil {
+		return err
+	}
+
+	rollback := action.NewRollback(config)
+	rollback.Version = revision
+	rollback.Wait = true
+	rollback.Timeout = timeoutFor(ctx)
+	if err := rollback.Run(name); err != nil {
+		return fmt.Errorf("helm rollback failed: %w", err)
+	}
+	return nil
+}
+
+// Uninstall removes a release
+func (c *Client) Uninstall(ctx context.Context, name, namespace string) error {
+	config, err := c.configuration(namespace)
+	if err != nil {
+		return err
+	}
+
+	uninstall := action.NewUninstall(config)
+	uninstall.IgnoreNotFound = true
+	uninstall.Timeout = timeoutFor(ctx)
+	if _, err := uninstall.Run(name); err != nil {
+		return fmt.Errorf("helm uninstall failed: %w", err)
+	}
+	return nil
+}
+
+// configuration returns the helm action configuration of a namespace, or of
+// all namespaces when namespace is empty, storing releases in secrets as
+// helm does by default
+func (c *Client) configuration(namespace string) (*action.Configuration, error) {
+	getter, err := newRESTClientGetter(c.kubeconfig, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(action.Configuration)
+	debug := func(format string, v ...interface{}) {
+		slog.Debug(fmt.Sprintf(format, v...), "component", "helm")
+	}
+	if err := config.Init(getter, namespace, "secret", debug); err != nil {
+		return nil, fmt.Errorf("failed to initialize helm: %w", err)
+	}
+	config.RegistryClient, err = registry.NewClient(registry.ClientOptCredentialsFile(c.settings.RegistryConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry client: %w", err)
+	}
+	return config, nil
+}
+
+// loadChart downloads a chart from its repository or OCI registry into the
+// helm cache, or reads a local chart, and loads it
+func (c *Client) loadChart(options *action.ChartPathOptions, name string) (*chart.Chart, error) {
+	path, err := options.LocateChart(name, c.settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chart %s: %w", name, err)
+	}
+	loaded, err := loader.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart %s: %w", name, err)
+	}
+	return loaded, nil
+}
+
+// releaseOf converts a release of the helm SDK, formatting it as helm list does
+func releaseOf(rel *release.Release) *Release {
+	result := &Release{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  strconv.Itoa(rel.Version),
+		Chart:     chartName(rel),
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		result.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	if rel.Info != nil {
+		result.Updated = rel.Info.LastDeployed.Format("2006-01-02 15:04:05.999999999 -0700 MST")
+		result.Status = rel.Info.Status.String()
+	}
+	return result
+}
+
+// chartName returns the chart of a release as name-version
+func chartName(rel *release.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Name + "-" + rel.Chart.Metadata.Version
+}
+
+// timeoutFor returns the time left until the deadline of ctx, or the default
+// timeout without one, for the actions that take no context
+func timeoutFor(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,60 @@
+package helm
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restClientGetter gives the helm SDK the API server of a kubeconfig held in
+// memory, as the kubeconfig of a cluster is stored encrypted in the database
+// rather than in a file
+type restClientGetter struct {
+	config clientcmd.ClientConfig
+}
+
+// newRESTClientGetter parses a kubeconfig, overriding its namespace unless
+// namespace is empty
+func newRESTClientGetter(kubeconfig []byte, namespace string) (*restClientGetter, error) {
+	raw, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.Context.Namespace = namespace
+	return &restClientGetter{config: clientcmd.NewDefaultClientConfig(*raw, overrides)}, nil
+}
+
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return g.config.ClientConfig()
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := g.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(client), nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	client, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(client)
+	return restmapper.NewShortcutExpander(mapper, client, nil), nil
+}
+
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.config
+}
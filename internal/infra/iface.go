@@ -0,0 +1,172 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"kubeforge/internal/validation"
+)
+
+// Driver creates the machines of a cluster on an infrastructure provider
+type Driver interface {
+	// Name returns the provider name (proxmox, etc.)
+	Name() string
+
+	// CreateMachine creates and starts a machine and returns it once it has
+	// an address. The machine may still be running cloud-init.
+	CreateMachine(ctx context.Context, req MachineRequest) (*Machine, error)
+
+	// DeleteMachine stops and deletes a machine created by CreateMachine
+	DeleteMachine(ctx context.Context, id string) error
+}
+
+// Spec selects the provider and the template the machines of a cluster are
+// created from
+type Spec struct {
+	Provider string `json:"provider"`           // proxmox, libvirt, vsphere
+	Node     string `json:"node,omitempty"`     // proxmox: node the machines are created on
+	Template int    `json:"template,omitempty"` // proxmox: VM ID of the cloud-init template to clone
+	Image    string `json:"image,omitempty"`    // libvirt: qcow2 volume the disks are based on; vsphere: name of the VM template
+	Storage  string `json:"storage,omitempty"`  // proxmox storage, libvirt storage pool (default "default") or vsphere datastore of the disks
+	Pool     string `json:"pool,omitempty"`     // proxmox or vsphere resource pool of the machines
+	Folder   string `json:"folder,omitempty"`   // vsphere: VM folder of the machines
+	Disk     string `json:"disk,omitempty"`     // proxmox: disk resized to disk_gb, default scsi0
+	Network  string `json:"network,omitempty"`  // libvirt: network of the machines, default "default"
+	Domain   string `json:"domain,omitempty"`   // vsphere: DNS domain set by guest customization, default "local"
+
+	// Static addressing of hosts with an address, which requires the prefix
+	// length. Other hosts use DHCP and their address is read from the QEMU
+	// guest agent or the DHCP leases of the network.
+	PrefixLength int      `json:"prefix_length,omitempty"` // e.g. 24
+	Gateway      string   `json:"gateway,omitempty"`
+	Nameservers  []string `json:"nameservers,omitempty"`
+}
+
+// MachineSpec sizes the machine created for a host
+type MachineSpec struct {
+	CPU      int `json:"cpu,omitempty"`       // cores, default those of the template
+	MemoryMB int `json:"memory_mb,omitempty"` // default that of the template
+	DiskGB   int `json:"disk_gb,omitempty"`   // grows the disk of the template, never shrinks it
+}
+
+// MachineRequest is a machine to create for a host of a cluster
+type MachineRequest struct {
+	Name         string      // VM and host name
+	Spec         Spec        // provider settings of the cluster
+	Machine      MachineSpec // size of the machine
+	Address      string      // static address, empty for DHCP
+	User         string      // user cloud-init creates for SSH
+	SSHPublicKey string      // authorized key of the user
+}
+
+// Machine is a created machine
+type Machine struct {
+	ID      string `json:"id"` // provider specific, e.g. node/vmid
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// Common errors
+var (
+	ErrDriverNotFound = errors.New("infrastructure provider not found")
+	ErrNotConfigured  = errors.New("infrastructure provider is not configured")
+)
+
+// DriverFactory creates a driver from the server configuration
+type DriverFactory func() (Driver, error)
+
+var driverRegistry = make(map[string]DriverFactory)
+
+// RegisterDriver registers a new driver factory
+func RegisterDriver(name string, factory DriverFactory) {
+	driverRegistry[name] = factory
+}
+
+// GetDriver returns the driver of a provider
+func GetDriver(name string) (Driver, error) {
+	factory, ok := driverRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDriverNotFound, name)
+	}
+	return factory()
+}
+
+// ListDrivers returns all registered provider names
+func ListDrivers() []string {
+	names := make([]string, 0, len(driverRegistry))
+	for name := range driverRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFields checks the provider settings. Field paths are relative to
+// prefix.
+func (s *Spec) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	if _, ok := driverRegistry[s.Provider]; !ok {
+		errs.Add(validation.Path(prefix, "provider"), validation.CodeUnsupported,
+			fmt.Sprintf("unsupported infrastructure provider %q, expected one of %s", s.Provider, strings.Join(ListDrivers(), ", ")))
+	}
+	switch s.Provider {
+	case "proxmox":
+		if s.Node == "" {
+			errs.Add(validation.Path(prefix, "node"), validation.CodeRequired, "node is required")
+		}
+		if s.Template <= 0 {
+			errs.Add(validation.Path(prefix, "template"), validation.CodeRequired, "VM ID of the template is required")
+		}
+	case "libvirt":
+		if s.Image == "" {
+			errs.Add(validation.Path(prefix, "image"), validation.CodeRequired, "base image volume is required")
+		}
+	case "vsphere":
+		if s.Image == "" {
+			errs.Add(validation.Path(prefix, "image"), validation.CodeRequired, "name of the VM template is required")
+		}
+	}
+	if s.PrefixLength < 0 || s.PrefixLength > 32 {
+		errs.Add(validation.Path(prefix, "prefix_length"), validation.CodeOutOfRange, "prefix length must be between 0 and 32")
+	}
+	if s.Gateway != "" && net.ParseIP(s.Gateway) == nil {
+		errs.Add(validation.Path(prefix, "gateway"), validation.CodeInvalid, fmt.Sprintf("%q is not a valid IP address", s.Gateway))
+	}
+	for i, server := range s.Nameservers {
+		if net.ParseIP(server) == nil {
+			errs.Add(validation.Index(validation.Path(prefix, "nameservers"), i), validation.CodeInvalid, fmt.Sprintf("%q is not a valid IP address", server))
+		}
+	}
+	return errs
+}
+
+// ValidateFields checks the size of a machine. Field paths are relative to
+// prefix.
+func (m *MachineSpec) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	if m.CPU < 0 {
+		errs.Add(validation.Path(prefix, "cpu"), validation.CodeOutOfRange, "cpu must not be negative")
+	}
+	if m.MemoryMB < 0 {
+		errs.Add(validation.Path(prefix, "memory_mb"), validation.CodeOutOfRange, "memory_mb must not be negative")
+	}
+	if m.DiskGB < 0 {
+		errs.Add(validation.Path(prefix, "disk_gb"), validation.CodeOutOfRange, "disk_gb must not be negative")
+	}
+	return errs
+}
+
+// ValidateMachine checks that the provider supports the size of a machine.
+// Field paths are relative to prefix.
+func (s *Spec) ValidateMachine(m *MachineSpec, prefix string) validation.Errors {
+	var errs validation.Errors
+	// The vCenter REST API cannot grow the disks of a clone
+	if s.Provider == "vsphere" && m.DiskGB > 0 {
+		errs.Add(validation.Path(prefix, "disk_gb"), validation.CodeUnsupported, "disk_gb is not supported by vsphere, size the disk of the template instead")
+	}
+	return errs
+}
@@ -0,0 +1,429 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+)
+
+// LibvirtConfig holds the connection to a libvirt daemon
+type LibvirtConfig struct {
+	URI string // e.g. qemu:///system or qemu+ssh://root@kvm1/system
+}
+
+var (
+	libvirtMu     sync.RWMutex
+	libvirtConfig = LibvirtConfig{URI: "qemu:///system"}
+)
+
+// SetLibvirtConfig configures the libvirt daemon used by the libvirt driver
+func SetLibvirtConfig(config LibvirtConfig) {
+	libvirtMu.Lock()
+	if config.URI != "" {
+		libvirtConfig = config
+	}
+	libvirtMu.Unlock()
+}
+
+// Defaults of machines created without a size, libvirt has no template to
+// take them from
+const (
+	libvirtDefaultCPU      = 2
+	libvirtDefaultMemoryMB = 2048
+)
+
+// libvirtPollInterval is how often the address of a new machine is polled
+const libvirtPollInterval = 3 * time.Second
+
+// Libvirt creates machines on a KVM hypervisor managed by libvirt. Disks are
+// copy-on-write overlays of a base qcow2 volume and cloud-init reads the SSH
+// key and address from a seed ISO attached to the machine. It speaks the
+// libvirt RPC protocol with go-libvirt, which reaches local and remote
+// daemons with the same URIs as virsh.
+type Libvirt struct {
+	uri *url.URL
+}
+
+// NewLibvirt creates a libvirt driver from the configured connection
+func NewLibvirt() (Driver, error) {
+	libvirtMu.RLock()
+	uri := libvirtConfig.URI
+	libvirtMu.RUnlock()
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LIBVIRT_URI: %w", err)
+	}
+	return &Libvirt{uri: u}, nil
+}
+
+func init() {
+	RegisterDriver("libvirt", NewLibvirt)
+}
+
+// Name returns the provider name
+func (l *Libvirt) Name() string {
+	return "libvirt"
+}
+
+// CreateMachine creates the disk and seed volumes of a machine, defines and
+// starts it and waits until it has an address
+func (l *Libvirt) CreateMachine(ctx context.Context, req MachineRequest) (*Machine, error) {
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Disconnect()
+
+	poolName := req.Spec.Storage
+	if poolName == "" {
+		poolName = "default"
+	}
+	pool, err := conn.StoragePoolLookupByName(poolName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find storage pool %s: %w", poolName, err)
+	}
+	disk := req.Name + ".qcow2"
+	seed := req.Name + "-seed.iso"
+
+	if err := l.createDisk(conn, pool, disk, req.Spec.Image, req.Machine.DiskGB); err != nil {
+		return nil, fmt.Errorf("failed to create disk of %s: %w", req.Name, err)
+	}
+	if err := l.uploadSeed(conn, pool, seed, seedFiles(req)); err != nil {
+		l.deleteVolumes(conn, pool, disk, seed)
+		return nil, fmt.Errorf("failed to create cloud-init seed of %s: %w", req.Name, err)
+	}
+
+	domainDef, err := domainXML(req, poolName, disk, seed)
+	if err != nil {
+		l.deleteVolumes(conn, pool, disk, seed)
+		return nil, err
+	}
+	domain, err := conn.DomainDefineXML(string(domainDef))
+	if err != nil {
+		l.deleteVolumes(conn, pool, disk, seed)
+		return nil, fmt.Errorf("failed to define machine %s: %w", req.Name, err)
+	}
+	machine := &Machine{ID: uuid.UUID(domain.UUID).String(), Name: req.Name}
+
+	if err := conn.DomainCreate(domain); err != nil {
+		return machine, fmt.Errorf("failed to start machine %s: %w", req.Name, err)
+	}
+
+	machine.Address = req.Address
+	if machine.Address == "" {
+		machine.Address, err = l.waitForAddress(ctx, conn, domain)
+		if err != nil {
+			return machine, err
+		}
+	}
+	return machine, nil
+}
+
+// DeleteMachine stops a machine and removes it with its volumes
+func (l *Libvirt) DeleteMachine(ctx context.Context, id string) error {
+	domainUUID, err := uuid.Parse(id)
+	if err != nil {
+		return fmt.Errorf("invalid machine id %s: %w", id, err)
+	}
+	conn, err := l.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Disconnect()
+
+	domain, err := conn.DomainLookupByUUID(libvirt.UUID(domainUUID))
+	if err != nil {
+		return err
+	}
+	state, _, err := conn.DomainGetState(domain, 0)
+	if err != nil {
+		return err
+	}
+	if libvirt.DomainState(state) != libvirt.DomainShutoff {
+		if err := conn.DomainDestroy(domain); err != nil {
+			return fmt.Errorf("failed to stop machine %s: %w", id, err)
+		}
+	}
+
+	// Read the volumes first, libvirt forgets them with the definition
+	definition, err := conn.DomainGetXMLDesc(domain, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read machine %s: %w", id, err)
+	}
+	var current libvirtDomain
+	if err := xml.Unmarshal([]byte(definition), &current); err != nil {
+		return fmt.Errorf("failed to read machine %s: %w", id, err)
+	}
+	if err := conn.DomainUndefineFlags(domain, 0); err != nil {
+		return fmt.Errorf("failed to delete machine %s: %w", id, err)
+	}
+	for _, disk := range current.Devices.Disks {
+		if disk.Type != "volume" {
+			continue
+		}
+		pool, err := conn.StoragePoolLookupByName(disk.Source.Pool)
+		if err == nil {
+			var volume libvirt.StorageVol
+			volume, err = conn.StorageVolLookupByName(pool, disk.Source.Volume)
+			if err == nil {
+				err = conn.StorageVolDelete(volume, 0)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete volume %s of machine %s: %w", disk.Source.Volume, id, err)
+		}
+	}
+	return nil
+}
+
+// createDisk creates the disk of a machine as a qcow2 overlay of the base
+// image, of the requested size but never less than the image
+func (l *Libvirt) createDisk(conn *libvirt.Libvirt, pool libvirt.StoragePool, name, image string, diskGB int) error {
+	base, err := conn.StorageVolLookupByName(pool, image)
+	if err != nil {
+		return fmt.Errorf("failed to find base image %s: %w", image, err)
+	}
+	_, capacity, _, err := conn.StorageVolGetInfo(base)
+	if err != nil {
+		return fmt.Errorf("failed to read base image %s: %w", image, err)
+	}
+	if requested := uint64(diskGB) << 30; requested > capacity {
+		capacity = requested
+	}
+	path, err := conn.StorageVolGetPath(base)
+	if err != nil {
+		return fmt.Errorf("failed to read base image %s: %w", image, err)
+	}
+
+	volume := libvirtVolume{Name: name}
+	volume.Capacity.Unit, volume.Capacity.Value = "bytes", capacity
+	volume.Target.Format.Type = "qcow2"
+	volume.BackingStore = &libvirtBackingStore{Path: path}
+	volume.BackingStore.Format.Type = "qcow2"
+	definition, err := xml.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	_, err = conn.StorageVolCreateXML(pool, string(definition), 0)
+	return err
+}
+
+// uploadSeed creates the cloud-init seed volume of a machine
+func (l *Libvirt) uploadSeed(conn *libvirt.Libvirt, pool libvirt.StoragePool, name string, files map[string][]byte) error {
+	image := seedISO(files)
+	volume := libvirtVolume{Name: name}
+	volume.Capacity.Unit, volume.Capacity.Value = "bytes", uint64(len(image))
+	volume.Target.Format.Type = "raw"
+	definition, err := xml.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	created, err := conn.StorageVolCreateXML(pool, string(definition), 0)
+	if err != nil {
+		return err
+	}
+	return conn.StorageVolUpload(created, bytes.NewReader(image), 0, uint64(len(image)), 0)
+}
+
+// deleteVolumes removes the volumes of a machine that was not defined
+func (l *Libvirt) deleteVolumes(conn *libvirt.Libvirt, pool libvirt.StoragePool, names ...string) {
+	for _, name := range names {
+		if volume, err := conn.StorageVolLookupByName(pool, name); err == nil {
+			conn.StorageVolDelete(volume, 0)
+		}
+	}
+}
+
+// waitForAddress polls the DHCP leases of the network and the QEMU guest
+// agent until the machine has an IPv4 address
+func (l *Libvirt) waitForAddress(ctx context.Context, conn *libvirt.Libvirt, domain libvirt.Domain) (string, error) {
+	sources := []libvirt.DomainInterfaceAddressesSource{
+		libvirt.DomainInterfaceAddressesSrcLease,
+		libvirt.DomainInterfaceAddressesSrcAgent,
+	}
+	for {
+		for _, source := range sources {
+			ifaces, err := conn.DomainInterfaceAddresses(domain, uint32(source), 0)
+			if err != nil {
+				continue
+			}
+			for _, iface := range ifaces {
+				for _, addr := range iface.Addrs {
+					if libvirt.IPAddrType(addr.Type) != libvirt.IPAddrTypeIpv4 {
+						continue
+					}
+					ip := net.ParseIP(addr.Addr)
+					if ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+						return ip.String(), nil
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("machine %s reported no address, is it on a libvirt network or running the QEMU guest agent: %w", domain.Name, ctx.Err())
+		case <-time.After(libvirtPollInterval):
+		}
+	}
+}
+
+// connect opens a connection to the configured daemon
+func (l *Libvirt) connect() (*libvirt.Libvirt, error) {
+	conn, err := libvirt.ConnectToURI(l.uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to libvirt at %s: %w", l.uri.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// libvirtVolume is the part of the libvirt storage volume XML KubeForge sets
+type libvirtVolume struct {
+	XMLName  xml.Name `xml:"volume"`
+	Name     string   `xml:"name"`
+	Capacity struct {
+		Unit  string `xml:"unit,attr"`
+		Value uint64 `xml:",chardata"`
+	} `xml:"capacity"`
+	Target struct {
+		Format struct {
+			Type string `xml:"type,attr"`
+		} `xml:"format"`
+	} `xml:"target"`
+	BackingStore *libvirtBackingStore `xml:"backingStore"`
+}
+
+type libvirtBackingStore struct {
+	Path   string `xml:"path"`
+	Format struct {
+		Type string `xml:"type,attr"`
+	} `xml:"format"`
+}
+
+// libvirtDomain is the part of the libvirt domain XML KubeForge sets
+type libvirtDomain struct {
+	XMLName xml.Name `xml:"domain"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name"`
+	Memory  struct {
+		Unit  string `xml:"unit,attr"`
+		Value int    `xml:",chardata"`
+	} `xml:"memory"`
+	VCPU int `xml:"vcpu"`
+	OS   struct {
+		Type string `xml:"type"`
+		Boot struct {
+			Dev string `xml:"dev,attr"`
+		} `xml:"boot"`
+	} `xml:"os"`
+	Features struct {
+		ACPI struct{} `xml:"acpi"`
+		APIC struct{} `xml:"apic"`
+	} `xml:"features"`
+	CPU struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"cpu"`
+	Devices struct {
+		Disks      []libvirtDisk      `xml:"disk"`
+		Interfaces []libvirtInterface `xml:"interface"`
+		Serial     struct {
+			Type string `xml:"type,attr"`
+		} `xml:"serial"`
+		Console struct {
+			Type string `xml:"type,attr"`
+		} `xml:"console"`
+		Channel struct {
+			Type   string `xml:"type,attr"`
+			Target struct {
+				Type string `xml:"type,attr"`
+				Name string `xml:"name,attr"`
+			} `xml:"target"`
+		} `xml:"channel"`
+	} `xml:"devices"`
+}
+
+type libvirtDisk struct {
+	Type   string `xml:"type,attr"`
+	Device string `xml:"device,attr"`
+	Driver struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"driver"`
+	Source struct {
+		Pool   string `xml:"pool,attr"`
+		Volume string `xml:"volume,attr"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly"`
+}
+
+type libvirtInterface struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Network string `xml:"network,attr"`
+	} `xml:"source"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+// domainXML renders the definition of a machine booting from its disk with
+// the seed attached as a CD-ROM and a guest agent channel
+func domainXML(req MachineRequest, pool, disk, seed string) ([]byte, error) {
+	var domain libvirtDomain
+	domain.Type = "kvm"
+	domain.Name = req.Name
+	domain.Memory.Unit = "MiB"
+	domain.Memory.Value = req.Machine.MemoryMB
+	if domain.Memory.Value == 0 {
+		domain.Memory.Value = libvirtDefaultMemoryMB
+	}
+	domain.VCPU = req.Machine.CPU
+	if domain.VCPU == 0 {
+		domain.VCPU = libvirtDefaultCPU
+	}
+	domain.OS.Type = "hvm"
+	domain.OS.Boot.Dev = "hd"
+	domain.CPU.Mode = "host-passthrough"
+
+	root := libvirtDisk{Type: "volume", Device: "disk"}
+	root.Driver.Name, root.Driver.Type = "qemu", "qcow2"
+	root.Source.Pool, root.Source.Volume = pool, disk
+	root.Target.Dev, root.Target.Bus = "vda", "virtio"
+	cdrom := libvirtDisk{Type: "volume", Device: "cdrom", ReadOnly: &struct{}{}}
+	cdrom.Driver.Name, cdrom.Driver.Type = "qemu", "raw"
+	cdrom.Source.Pool, cdrom.Source.Volume = pool, seed
+	cdrom.Target.Dev, cdrom.Target.Bus = "sda", "sata"
+	domain.Devices.Disks = []libvirtDisk{root, cdrom}
+
+	iface := libvirtInterface{Type: "network"}
+	iface.Source.Network = req.Spec.Network
+	if iface.Source.Network == "" {
+		iface.Source.Network = "default"
+	}
+	iface.Model.Type = "virtio"
+	domain.Devices.Interfaces = []libvirtInterface{iface}
+
+	domain.Devices.Serial.Type = "pty"
+	domain.Devices.Console.Type = "pty"
+	domain.Devices.Channel.Type = "unix"
+	domain.Devices.Channel.Target.Type = "virtio"
+	domain.Devices.Channel.Target.Name = "org.qemu.guest_agent.0"
+
+	out, err := xml.MarshalIndent(domain, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render domain of %s: %w", req.Name, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,290 @@
+package infra
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxmoxConfig holds the API address and token of a Proxmox VE cluster
+type ProxmoxConfig struct {
+	URL                   string // e.g. https://pve.example.com:8006
+	TokenID               string // USER@REALM!TOKENID
+	TokenSecret           string
+	InsecureSkipTLSVerify bool // accept the self-signed certificate of a default install
+}
+
+var (
+	proxmoxMu     sync.RWMutex
+	proxmoxConfig ProxmoxConfig
+)
+
+// SetProxmoxConfig configures the Proxmox VE API used by the proxmox driver
+func SetProxmoxConfig(config ProxmoxConfig) {
+	proxmoxMu.Lock()
+	proxmoxConfig = config
+	proxmoxMu.Unlock()
+}
+
+// proxmoxRequestTimeout bounds a single API request. Long operations are
+// tasks polled with separate requests.
+const proxmoxRequestTimeout = 30 * time.Second
+
+// proxmoxPollInterval is how often tasks and the guest agent are polled
+const proxmoxPollInterval = 3 * time.Second
+
+// Proxmox creates machines by cloning a cloud-init VM template of a Proxmox
+// VE cluster
+type Proxmox struct {
+	config ProxmoxConfig
+	client *http.Client
+}
+
+// NewProxmox creates a Proxmox driver from the configured API settings
+func NewProxmox() (Driver, error) {
+	proxmoxMu.RLock()
+	config := proxmoxConfig
+	proxmoxMu.RUnlock()
+	if config.URL == "" || config.TokenID == "" || config.TokenSecret == "" {
+		return nil, fmt.Errorf("%w: set PROXMOX_URL, PROXMOX_TOKEN_ID and PROXMOX_TOKEN_SECRET", ErrNotConfigured)
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.InsecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Proxmox{
+		config: config,
+		client: &http.Client{Timeout: proxmoxRequestTimeout, Transport: transport},
+	}, nil
+}
+
+func init() {
+	RegisterDriver("proxmox", NewProxmox)
+}
+
+// Name returns the provider name
+func (p *Proxmox) Name() string {
+	return "proxmox"
+}
+
+// CreateMachine clones the template into a new VM, sizes it, configures
+// cloud-init with the SSH user and key and the address, starts it and waits
+// until it has an address
+func (p *Proxmox) CreateMachine(ctx context.Context, req MachineRequest) (*Machine, error) {
+	spec := req.Spec
+	node := url.PathEscape(spec.Node)
+
+	var nextID string
+	if err := p.call(ctx, http.MethodGet, "/cluster/nextid", nil, &nextID); err != nil {
+		return nil, err
+	}
+	vmid, err := strconv.Atoi(nextID)
+	if err != nil {
+		return nil, fmt.Errorf("proxmox returned an invalid VM ID %q", nextID)
+	}
+	machine := &Machine{ID: fmt.Sprintf("%s/%d", spec.Node, vmid), Name: req.Name}
+
+	clone := url.Values{"newid": {nextID}, "name": {req.Name}, "full": {"1"}}
+	if spec.Storage != "" {
+		clone.Set("storage", spec.Storage)
+	}
+	if spec.Pool != "" {
+		clone.Set("pool", spec.Pool)
+	}
+	if err := p.runTask(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/clone", node, spec.Template), clone); err != nil {
+		return nil, fmt.Errorf("failed to clone template %d: %w", spec.Template, err)
+	}
+
+	config := url.Values{
+		"ciuser": {req.User},
+		"agent":  {"1"},
+		// Proxmox expects the keys URL encoded within the form value
+		"sshkeys":   {strings.ReplaceAll(url.QueryEscape(strings.TrimSpace(req.SSHPublicKey)), "+", "%20")},
+		"ipconfig0": {"ip=dhcp"},
+	}
+	if req.Address != "" && spec.PrefixLength > 0 {
+		ipconfig := fmt.Sprintf("ip=%s/%d", req.Address, spec.PrefixLength)
+		if spec.Gateway != "" {
+			ipconfig += ",gw=" + spec.Gateway
+		}
+		config.Set("ipconfig0", ipconfig)
+	}
+	if len(spec.Nameservers) > 0 {
+		config.Set("nameserver", strings.Join(spec.Nameservers, " "))
+	}
+	if req.Machine.CPU > 0 {
+		config.Set("cores", strconv.Itoa(req.Machine.CPU))
+	}
+	if req.Machine.MemoryMB > 0 {
+		config.Set("memory", strconv.Itoa(req.Machine.MemoryMB))
+	}
+	if err := p.call(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid), config, nil); err != nil {
+		return machine, fmt.Errorf("failed to configure VM %d: %w", vmid, err)
+	}
+
+	if req.Machine.DiskGB > 0 {
+		disk := spec.Disk
+		if disk == "" {
+			disk = "scsi0"
+		}
+		resize := url.Values{"disk": {disk}, "size": {fmt.Sprintf("%dG", req.Machine.DiskGB)}}
+		if err := p.call(ctx, http.MethodPut, fmt.Sprintf("/nodes/%s/qemu/%d/resize", node, vmid), resize, nil); err != nil {
+			return machine, fmt.Errorf("failed to resize disk of VM %d: %w", vmid, err)
+		}
+	}
+
+	if err := p.runTask(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/status/start", node, vmid), url.Values{}); err != nil {
+		return machine, fmt.Errorf("failed to start VM %d: %w", vmid, err)
+	}
+
+	machine.Address = req.Address
+	if machine.Address == "" {
+		machine.Address, err = p.waitForAddress(ctx, node, vmid)
+		if err != nil {
+			return machine, err
+		}
+	}
+	return machine, nil
+}
+
+// DeleteMachine stops a VM and deletes it with its disks
+func (p *Proxmox) DeleteMachine(ctx context.Context, id string) error {
+	nodeName, vmidValue, ok := strings.Cut(id, "/")
+	vmid, err := strconv.Atoi(vmidValue)
+	if !ok || err != nil {
+		return fmt.Errorf("invalid proxmox machine ID %q", id)
+	}
+	node := url.PathEscape(nodeName)
+
+	if err := p.runTask(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/status/stop", node, vmid), url.Values{}); err != nil {
+		return fmt.Errorf("failed to stop VM %d: %w", vmid, err)
+	}
+	if err := p.runTask(ctx, http.MethodDelete, fmt.Sprintf("/nodes/%s/qemu/%d?purge=1", node, vmid), nil); err != nil {
+		return fmt.Errorf("failed to delete VM %d: %w", vmid, err)
+	}
+	return nil
+}
+
+// waitForAddress polls the QEMU guest agent of a VM until it reports an
+// IPv4 address other than loopback
+func (p *Proxmox) waitForAddress(ctx context.Context, node string, vmid int) (string, error) {
+	var result struct {
+		Result []struct {
+			Name        string `json:"name"`
+			IPAddresses []struct {
+				Type    string `json:"ip-address-type"`
+				Address string `json:"ip-address"`
+			} `json:"ip-addresses"`
+		} `json:"result"`
+	}
+
+	for {
+		// The agent fails until the VM has booted and started it
+		err := p.call(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", node, vmid), nil, &result)
+		if err == nil {
+			for _, iface := range result.Result {
+				for _, addr := range iface.IPAddresses {
+					if ip := net.ParseIP(addr.Address); addr.Type == "ipv4" && ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+						return addr.Address, nil
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("VM %d reported no address, is the QEMU guest agent installed in the template: %w", vmid, ctx.Err())
+		case <-time.After(proxmoxPollInterval):
+		}
+	}
+}
+
+// runTask starts an asynchronous operation and waits for its task to finish
+func (p *Proxmox) runTask(ctx context.Context, method, path string, form url.Values) error {
+	var upid string
+	if err := p.call(ctx, method, path, form, &upid); err != nil {
+		return err
+	}
+	node, _, _ := strings.Cut(strings.TrimPrefix(upid, "UPID:"), ":")
+
+	for {
+		var status struct {
+			Status     string `json:"status"`
+			ExitStatus string `json:"exitstatus"`
+		}
+		err := p.call(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/tasks/%s/status", url.PathEscape(node), url.PathEscape(upid)), nil, &status)
+		if err != nil {
+			return err
+		}
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return fmt.Errorf("task %s failed: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(proxmoxPollInterval):
+		}
+	}
+}
+
+// call sends a request to the Proxmox VE API with form parameters and
+// decodes the data of the response into out
+func (p *Proxmox) call(ctx context.Context, method, path string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.URL+"/api2/json"+path, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+p.config.TokenID+"="+p.config.TokenSecret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("proxmox request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("proxmox request failed: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		// Proxmox puts the reason in the status line, parameter errors in
+		// the body
+		return fmt.Errorf("proxmox %s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data[:min(len(data), 512)])))
+	}
+	if out == nil {
+		return nil
+	}
+
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("proxmox returned an invalid response: %w", err)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("proxmox returned an invalid response: %w", err)
+	}
+	return nil
+}
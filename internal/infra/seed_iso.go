@@ -0,0 +1,173 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isoSectorSize is the logical block size of an ISO 9660 image
+const isoSectorSize = 2048
+
+// seedISO builds the ISO 9660 image of a cloud-init NoCloud data source: a
+// volume labelled "cidata" with the files in its root directory. Linux
+// mounts the upper case names the format requires as the lower case
+// user-data and meta-data cloud-init reads.
+func seedISO(files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Layout: system area, volume descriptors, path tables, root directory,
+	// then the contents of the files
+	const (
+		pvdSector    = 16
+		lPathSector  = 18
+		mPathSector  = 19
+		rootSector   = 20
+		firstFileSec = 21
+	)
+	now := time.Now().UTC()
+
+	var records []byte
+	sector := uint32(firstFileSec)
+	extents := make([]uint32, len(names))
+	for i, name := range names {
+		extents[i] = sector
+		sector += uint32((len(files[name]) + isoSectorSize - 1) / isoSectorSize)
+	}
+	totalSectors := sector
+
+	records = append(records, isoDirRecord([]byte{0}, rootSector, isoSectorSize, true, now)...)
+	records = append(records, isoDirRecord([]byte{1}, rootSector, isoSectorSize, true, now)...)
+	for i, name := range names {
+		id := strings.ToUpper(name) + ".;1"
+		records = append(records, isoDirRecord([]byte(id), extents[i], uint32(len(files[name])), false, now)...)
+	}
+
+	image := make([]byte, int(totalSectors)*isoSectorSize)
+
+	pvd := image[pvdSector*isoSectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	copy(pvd[8:40], isoPad("", 32))
+	copy(pvd[40:72], isoPad("cidata", 32))
+	putBothUint32(pvd[80:], totalSectors)
+	putBothUint16(pvd[120:], 1)
+	putBothUint16(pvd[124:], 1)
+	putBothUint16(pvd[128:], isoSectorSize)
+	putBothUint32(pvd[132:], 10) // one path table record
+	binary.LittleEndian.PutUint32(pvd[140:], lPathSector)
+	binary.BigEndian.PutUint32(pvd[148:], mPathSector)
+	copy(pvd[156:190], isoDirRecord([]byte{0}, rootSector, isoSectorSize, true, now))
+	for _, field := range [][2]int{{190, 128}, {318, 128}, {446, 128}, {574, 128}, {702, 37}, {739, 37}, {776, 37}} {
+		copy(pvd[field[0]:field[0]+field[1]], isoPad("", field[1]))
+	}
+	created := []byte(now.Format("20060102150405") + "00\x00")
+	never := []byte("0000000000000000\x00")
+	copy(pvd[813:], created)
+	copy(pvd[830:], created)
+	copy(pvd[847:], never)
+	copy(pvd[864:], never)
+	pvd[881] = 1
+
+	terminator := image[(pvdSector+1)*isoSectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	// The root directory is the only entry of the path tables
+	lPath := image[lPathSector*isoSectorSize:]
+	lPath[0] = 1
+	binary.LittleEndian.PutUint32(lPath[2:], rootSector)
+	binary.LittleEndian.PutUint16(lPath[6:], 1)
+	mPath := image[mPathSector*isoSectorSize:]
+	mPath[0] = 1
+	binary.BigEndian.PutUint32(mPath[2:], rootSector)
+	binary.BigEndian.PutUint16(mPath[6:], 1)
+
+	copy(image[rootSector*isoSectorSize:(rootSector+1)*isoSectorSize], records)
+	for i, name := range names {
+		copy(image[int(extents[i])*isoSectorSize:], files[name])
+	}
+	return image
+}
+
+// isoDirRecord encodes a directory record
+func isoDirRecord(id []byte, extent, size uint32, dir bool, t time.Time) []byte {
+	length := 33 + len(id)
+	if length%2 == 1 {
+		length++
+	}
+	record := make([]byte, length)
+	record[0] = byte(length)
+	putBothUint32(record[2:], extent)
+	putBothUint32(record[10:], size)
+	record[18] = byte(t.Year() - 1900)
+	record[19] = byte(t.Month())
+	record[20] = byte(t.Day())
+	record[21] = byte(t.Hour())
+	record[22] = byte(t.Minute())
+	record[23] = byte(t.Second())
+	if dir {
+		record[25] = 2
+	}
+	putBothUint16(record[28:], 1)
+	record[32] = byte(len(id))
+	copy(record[33:], id)
+	return record
+}
+
+// isoPad pads a string field with spaces
+func isoPad(s string, n int) []byte {
+	return []byte(fmt.Sprintf("%-*s", n, s))[:n]
+}
+
+// putBothUint32 writes v in both byte orders, as ISO 9660 requires
+func putBothUint32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// putBothUint16 writes v in both byte orders
+func putBothUint16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+// seedFiles renders the cloud-init NoCloud files of a machine
+func seedFiles(req MachineRequest) map[string][]byte {
+	var userData bytes.Buffer
+	userData.WriteString("#cloud-config\n")
+	fmt.Fprintf(&userData, "hostname: %q\n", req.Name)
+	key := fmt.Sprintf("%q", strings.TrimSpace(req.SSHPublicKey))
+	if req.User == "root" || req.User == "" {
+		fmt.Fprintf(&userData, "disable_root: false\nssh_authorized_keys:\n  - %s\n", key)
+	} else {
+		fmt.Fprintf(&userData, "users:\n  - default\n  - name: %q\n    sudo: \"ALL=(ALL) NOPASSWD:ALL\"\n    shell: /bin/bash\n    ssh_authorized_keys:\n      - %s\n", req.User, key)
+	}
+
+	files := map[string][]byte{
+		"user-data": userData.Bytes(),
+		"meta-data": []byte(fmt.Sprintf("instance-id: %q\nlocal-hostname: %q\n", req.Name, req.Name)),
+	}
+
+	if req.Address != "" && req.Spec.PrefixLength > 0 {
+		var network bytes.Buffer
+		fmt.Fprintf(&network, "version: 2\nethernets:\n  primary:\n    match:\n      name: \"e*\"\n    addresses: [\"%s/%d\"]\n", req.Address, req.Spec.PrefixLength)
+		if req.Spec.Gateway != "" {
+			fmt.Fprintf(&network, "    routes:\n      - to: default\n        via: %q\n", req.Spec.Gateway)
+		}
+		if len(req.Spec.Nameservers) > 0 {
+			fmt.Fprintf(&network, "    nameservers:\n      addresses: [\"%s\"]\n", strings.Join(req.Spec.Nameservers, "\", \""))
+		}
+		files["network-config"] = network.Bytes()
+	}
+	return files
+}
@@ -0,0 +1,309 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/fault"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// VSphereConfig holds the address and credentials of a vCenter server
+type VSphereConfig struct {
+	URL                   string // e.g. https://vcenter.example.com
+	Username              string // e.g. kubeforge@vsphere.local
+	Password              string
+	InsecureSkipTLSVerify bool // accept a self-signed certificate
+}
+
+var (
+	vsphereMu     sync.RWMutex
+	vsphereConfig VSphereConfig
+)
+
+// SetVSphereConfig configures the vCenter server used by the vsphere driver
+func SetVSphereConfig(config VSphereConfig) {
+	vsphereMu.Lock()
+	vsphereConfig = config
+	vsphereMu.Unlock()
+}
+
+// vsphereLogoutTimeout bounds ending a session once a request is done
+const vsphereLogoutTimeout = 10 * time.Second
+
+// VSphere creates machines by cloning a VM template through the vSphere API
+// with govmomi. The hostname, address and SSH key are set by guest
+// customization, which needs VMware Tools in the template.
+type VSphere struct {
+	config VSphereConfig
+	url    *url.URL
+}
+
+// NewVSphere creates a vSphere driver from the configured vCenter settings
+func NewVSphere() (Driver, error) {
+	vsphereMu.RLock()
+	config := vsphereConfig
+	vsphereMu.RUnlock()
+	if config.URL == "" || config.Username == "" || config.Password == "" {
+		return nil, fmt.Errorf("%w: set VSPHERE_URL, VSPHERE_USERNAME and VSPHERE_PASSWORD", ErrNotConfigured)
+	}
+
+	u, err := soap.ParseURL(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VSPHERE_URL: %w", err)
+	}
+	u.User = url.UserPassword(config.Username, config.Password)
+	return &VSphere{config: config, url: u}, nil
+}
+
+func init() {
+	RegisterDriver("vsphere", NewVSphere)
+}
+
+// Name returns the provider name
+func (v *VSphere) Name() string {
+	return "vsphere"
+}
+
+// CreateMachine clones the template into the configured folder, resource
+// pool and datastore with the size and guest customization of the machine,
+// powers it on and waits until VMware Tools report an address
+func (v *VSphere) CreateMachine(ctx context.Context, req MachineRequest) (*Machine, error) {
+	client, err := v.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer v.logout(client)
+	spec := req.Spec
+
+	templateRef, err := v.lookup(ctx, client.Client, "VirtualMachine", spec.Image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find template %s: %w", spec.Image, err)
+	}
+	template := object.NewVirtualMachine(client.Client, templateRef)
+	var source mo.VirtualMachine
+	if err := template.Properties(ctx, templateRef, []string{"parent", "resourcePool", "runtime.host"}, &source); err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", spec.Image, err)
+	}
+
+	// Machines go next to the template unless placed elsewhere
+	location := types.VirtualMachineRelocateSpec{Pool: source.ResourcePool}
+	folderRef := source.Parent
+	for _, item := range []struct {
+		name, kind string
+		ref        **types.ManagedObjectReference
+	}{
+		{spec.Pool, "ResourcePool", &location.Pool},
+		{spec.Storage, "Datastore", &location.Datastore},
+		{spec.Folder, "Folder", &folderRef},
+	} {
+		if item.name == "" {
+			continue
+		}
+		ref, err := v.lookup(ctx, client.Client, item.kind, item.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find %s %s: %w", strings.ToLower(item.kind), item.name, err)
+		}
+		*item.ref = &ref
+	}
+	if location.Pool == nil && source.Runtime.Host != nil {
+		// Templates belong to no resource pool, use that of their host
+		pool, err := object.NewHostSystem(client.Client, *source.Runtime.Host).ResourcePool(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a resource pool for %s: %w", req.Name, err)
+		}
+		ref := pool.Reference()
+		location.Pool = &ref
+	}
+	if folderRef == nil {
+		return nil, fmt.Errorf("failed to find a folder for %s", req.Name)
+	}
+
+	clone := types.VirtualMachineCloneSpec{
+		Location:      location,
+		Config:        &types.VirtualMachineConfigSpec{NumCPUs: int32(req.Machine.CPU), MemoryMB: int64(req.Machine.MemoryMB)},
+		Customization: vsphereCustomization(req),
+		PowerOn:       true,
+	}
+	task, err := template.Clone(ctx, object.NewFolder(client.Client, *folderRef), req.Name, clone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone template %s: %w", spec.Image, err)
+	}
+	info, err := task.WaitForResult(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone template %s: %w", spec.Image, err)
+	}
+	vmRef, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return nil, fmt.Errorf("clone of template %s returned no VM", spec.Image)
+	}
+	machine := &Machine{ID: vmRef.Value, Name: req.Name}
+
+	machine.Address = req.Address
+	if machine.Address == "" {
+		machine.Address, err = v.waitForAddress(ctx, client.Client, vmRef)
+		if err != nil {
+			return machine, err
+		}
+	}
+	return machine, nil
+}
+
+// DeleteMachine powers off a VM and deletes it with its disks
+func (v *VSphere) DeleteMachine(ctx context.Context, id string) error {
+	client, err := v.login(ctx)
+	if err != nil {
+		return err
+	}
+	defer v.logout(client)
+	vm := object.NewVirtualMachine(client.Client, types.ManagedObjectReference{Type: "VirtualMachine", Value: id})
+
+	state, err := vm.PowerState(ctx)
+	if err != nil {
+		if fault.Is(err, &types.ManagedObjectNotFound{}) {
+			return nil
+		}
+		return err
+	}
+	if state != types.VirtualMachinePowerStatePoweredOff {
+		task, err := vm.PowerOff(ctx)
+		if err == nil {
+			err = task.Wait(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to power off VM %s: %w", id, err)
+		}
+	}
+	task, err := vm.Destroy(ctx)
+	if err == nil {
+		err = task.Wait(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete VM %s: %w", id, err)
+	}
+	return nil
+}
+
+// vsphereCustomization returns the guest customization of a machine: its
+// hostname, DHCP or a static address, and a script authorizing the SSH key
+// of its user
+func vsphereCustomization(req MachineRequest) *types.CustomizationSpec {
+	domain := req.Spec.Domain
+	if domain == "" {
+		domain = "local"
+	}
+	adapter := types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}}
+	if req.Address != "" && req.Spec.PrefixLength > 0 {
+		adapter = types.CustomizationIPSettings{
+			Ip:         &types.CustomizationFixedIp{IpAddress: req.Address},
+			SubnetMask: net.IP(net.CIDRMask(req.Spec.PrefixLength, 32)).String(),
+		}
+		if req.Spec.Gateway != "" {
+			adapter.Gateway = []string{req.Spec.Gateway}
+		}
+	}
+
+	return &types.CustomizationSpec{
+		Identity: &types.CustomizationLinuxPrep{
+			HostName:   &types.CustomizationFixedName{Name: req.Name},
+			Domain:     domain,
+			ScriptText: authorizeKeyScript(req.User, req.SSHPublicKey),
+		},
+		GlobalIPSettings: types.CustomizationGlobalIPSettings{DnsServerList: req.Spec.Nameservers},
+		NicSettingMap:    []types.CustomizationAdapterMapping{{Adapter: adapter}},
+	}
+}
+
+// authorizeKeyScript returns a customization script that adds an SSH key to
+// the authorized keys of a user once customization has finished
+func authorizeKeyScript(user, key string) string {
+	if user == "" {
+		user = "root"
+	}
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return fmt.Sprintf(`#!/bin/sh
+[ "$1" = "postcustomization" ] || exit 0
+user=%s
+home=$(getent passwd "$user" | cut -d: -f6)
+mkdir -p "$home/.ssh"
+echo %s >> "$home/.ssh/authorized_keys"
+chmod 700 "$home/.ssh"
+chmod 600 "$home/.ssh/authorized_keys"
+chown -R "$user" "$home/.ssh"
+`, quote(user), quote(strings.TrimSpace(key)))
+}
+
+// waitForAddress waits until VMware Tools report an IPv4 address of the guest
+// that is not link-local
+func (v *VSphere) waitForAddress(ctx context.Context, client *vim25.Client, vm types.ManagedObjectReference) (string, error) {
+	var address string
+	err := property.Wait(ctx, property.DefaultCollector(client), vm, []string{"guest.ipAddress"}, func(changes []types.PropertyChange) bool {
+		for _, change := range changes {
+			value, _ := change.Val.(string)
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil && !ip.IsLinkLocalUnicast() {
+				address = value
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("VM reported no address, are VMware Tools installed in the template: %w", ctx.Err())
+		}
+		return "", err
+	}
+	return address, nil
+}
+
+// lookup returns the single object of a type with a name in the inventory
+func (v *VSphere) lookup(ctx context.Context, client *vim25.Client, kind, name string) (types.ManagedObjectReference, error) {
+	container, err := view.NewManager(client).CreateContainerView(ctx, client.ServiceContent.RootFolder, []string{kind}, true)
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+	defer container.Destroy(ctx)
+
+	refs, err := container.Find(ctx, []string{kind}, property.Match{"name": name})
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+	switch len(refs) {
+	case 0:
+		return types.ManagedObjectReference{}, fmt.Errorf("not found")
+	case 1:
+		return refs[0], nil
+	default:
+		return types.ManagedObjectReference{}, fmt.Errorf("the name matches %d objects", len(refs))
+	}
+}
+
+// login creates an API session
+func (v *VSphere) login(ctx context.Context) (*govmomi.Client, error) {
+	client, err := govmomi.NewClient(ctx, v.url, v.config.InsecureSkipTLSVerify)
+	if err != nil {
+		return nil, fmt.Errorf("vsphere login failed: %w", err)
+	}
+	return client, nil
+}
+
+// logout ends the API session
+func (v *VSphere) logout(client *govmomi.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), vsphereLogoutTimeout)
+	defer cancel()
+	client.Logout(ctx)
+}
@@ -0,0 +1,667 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/lock"
+	"kubeforge/internal/logging"
+	"kubeforge/internal/tracing"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Common errors
+var (
+	ErrDuplicateJob = db.ErrActiveJob
+	ErrNoHandler    = errors.New("no handler registered for job type")
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobFinished  = errors.New("job has already finished")
+	ErrJobCancelled = errors.New("job cancelled")
+	ErrInterrupted  = errors.New("job interrupted by shutdown")
+
+	// errLeaseLost cancels a job that another replica has taken over
+	errLeaseLost = errors.New("job lease lost")
+)
+
+// Config holds job queue settings
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+	InstanceID   string        // identifies this replica as the owner of the jobs it runs
+	LeaseTTL     time.Duration // running jobs without a heartbeat for this long are re-queued
+
+	// How long running jobs may take to finish their current step on
+	// shutdown before they are cancelled
+	ShutdownTimeout time.Duration
+}
+
+// Handler executes a job. When the queue stops, the steps of the job started
+// through its StepGate are allowed to finish and the context is cancelled
+// once none is running, or at the shutdown deadline; the job is returned to
+// pending and resumed on the next start. Handlers should record their
+// progress with SaveCheckpoint so that a resumed job can skip the work
+// already done.
+type Handler func(ctx context.Context, job *db.Job) error
+
+// Queue is a persistent job queue processed by a bounded pool of workers.
+// Several server replicas may share the same database: each job is owned by
+// the replica that claimed it, which keeps it alive with heartbeats, and jobs
+// of the same cluster never run concurrently.
+type Queue struct {
+	store           db.JobStore
+	conn            *gorm.DB // holds the cluster locks
+	workers         int
+	pollInterval    time.Duration
+	instanceID      string
+	leaseTTL        time.Duration
+	shutdownTimeout time.Duration
+	handlers        map[string]Handler
+	wake            chan struct{}
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping chan struct{} // closed when the queue starts shutting down
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[uint]*activeJob
+	drained chan struct{} // closed once no job runs while stopping
+	alive   int           // workers that have not exited
+	beat    time.Time     // last heartbeat pass
+}
+
+// Health describes the worker pool for readiness checks
+type Health struct {
+	Workers       int       `json:"workers"`
+	Alive         int       `json:"alive"`
+	Running       int       `json:"running"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+// activeJob is a job running in this process
+type activeJob struct {
+	cancel context.CancelCauseFunc
+	lock   string // name of the cluster lock held, if any
+	owner  string // owner of the cluster lock, see lockOwner
+	gate   *StepGate
+}
+
+// StepGate lets a job stop between two steps on shutdown rather than in the
+// middle of one, e.g. of kubeadm init, which would leave a host half
+// configured. Steps are bracketed by StartStep and EndStep.
+type StepGate struct {
+	cancel context.CancelCauseFunc
+
+	mu       sync.Mutex
+	steps    int // steps in progress
+	stopping bool
+}
+
+// StartStep reports whether a step may start and counts it as in progress
+// until EndStep. Once the job is stopping no more steps start; the context
+// of the job is cancelled when the steps in progress have ended.
+func (g *StepGate) StartStep() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopping {
+		return false
+	}
+	g.steps++
+	return true
+}
+
+// EndStep marks a step started by StartStep as finished
+func (g *StepGate) EndStep() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.steps--
+	if g.stopping && g.steps == 0 {
+		g.cancel(ErrInterrupted)
+	}
+}
+
+// stop lets the steps in progress finish and then interrupts the job
+func (g *StepGate) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopping = true
+	if g.steps == 0 {
+		g.cancel(ErrInterrupted)
+	}
+}
+
+// gateKey is the context key of the step gate of a job
+type gateKey struct{}
+
+// Gate returns the step gate of the job running with ctx, nil outside jobs
+func Gate(ctx context.Context) *StepGate {
+	gate, _ := ctx.Value(gateKey{}).(*StepGate)
+	return gate
+}
+
+// NewQueue creates a new job queue keeping its jobs in store and the locks of
+// their clusters in conn
+func NewQueue(store db.JobStore, conn *gorm.DB, config Config) *Queue {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.InstanceID == "" {
+		config.InstanceID = "kubeforge"
+	}
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = time.Minute
+	}
+	return &Queue{
+		store:           store,
+		conn:            conn,
+		workers:         config.Workers,
+		pollInterval:    config.PollInterval,
+		instanceID:      config.InstanceID,
+		leaseTTL:        config.LeaseTTL,
+		shutdownTimeout: config.ShutdownTimeout,
+		handlers:        make(map[string]Handler),
+		wake:            make(chan struct{}, 1),
+		running:         make(map[uint]*activeJob),
+	}
+}
+
+// RegisterHandler registers the handler for a job type
+func (q *Queue) RegisterHandler(jobType string, handler Handler) {
+	q.handlers[jobType] = handler
+}
+
+// Enqueue persists a new pending job. If an active job of the same type and
+// target already exists for the cluster, it is loaded into job and
+// ErrDuplicateJob is returned.
+func (q *Queue) Enqueue(job *db.Job) error {
+	if err := q.EnqueueTx(q.store, job); err != nil {
+		return err
+	}
+	q.Notify()
+	return nil
+}
+
+// EnqueueTx persists a new pending job like Enqueue, in the job store of a
+// transaction. Workers find the job once it commits; call Notify then to
+// start it right away.
+func (q *Queue) EnqueueTx(tx db.JobStore, job *db.Job) error {
+	if _, ok := q.handlers[job.Type]; !ok {
+		return fmt.Errorf("%w: %s", ErrNoHandler, job.Type)
+	}
+	return tx.Create(job)
+}
+
+// Start re-queues jobs interrupted by a previous shutdown and launches the worker pool
+func (q *Queue) Start() {
+	if err := q.recover(); err != nil {
+		slog.Error("Failed to re-queue interrupted jobs", "error", err)
+	}
+
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.stopping = make(chan struct{})
+	q.mu.Lock()
+	q.alive = q.workers
+	q.beat = time.Now()
+	q.mu.Unlock()
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(i)
+	}
+	q.wg.Add(1)
+	go q.heartbeat()
+	slog.Info("Job queue started", "workers", q.workers, "instance", q.instanceID)
+}
+
+// Stop shuts the queue down: workers stop claiming jobs, running jobs finish
+// their current step and are returned to pending, to be resumed from their
+// checkpoint on the next start. Jobs still running after the shutdown
+// timeout are cancelled. Stop waits for the workers to exit.
+func (q *Queue) Stop() {
+	if q.cancel == nil {
+		return
+	}
+	close(q.stopping)
+
+	q.mu.Lock()
+	for _, job := range q.running {
+		job.gate.stop()
+	}
+	running := len(q.running)
+	if running > 0 {
+		q.drained = make(chan struct{})
+	}
+	drained := q.drained
+	q.mu.Unlock()
+
+	if running > 0 {
+		slog.Info("Waiting for running jobs to finish their current step", "jobs", running, "timeout", q.shutdownTimeout)
+		select {
+		case <-drained:
+		case <-time.After(q.shutdownTimeout):
+			slog.Warn("Cancelling jobs still running at the shutdown deadline")
+		}
+	}
+
+	q.cancel()
+	q.wg.Wait()
+	slog.Info("Job queue stopped")
+}
+
+// Health reports the state of the worker pool. The error is set when the
+// queue is not processing jobs: it was never started or has stopped, a
+// worker exited, or the heartbeat loop has stalled for longer than a lease.
+func (q *Queue) Health() (Health, error) {
+	q.mu.Lock()
+	health := Health{
+		Workers:       q.workers,
+		Alive:         q.alive,
+		Running:       len(q.running),
+		LastHeartbeat: q.beat,
+	}
+	q.mu.Unlock()
+
+	switch {
+	case q.ctx == nil:
+		return health, errors.New("job queue not started")
+	case q.ctx.Err() != nil || q.isStopping():
+		return health, errors.New("job queue stopped")
+	case health.Alive < health.Workers:
+		return health, fmt.Errorf("%d of %d workers exited", health.Workers-health.Alive, health.Workers)
+	case time.Since(health.LastHeartbeat) > q.leaseTTL:
+		return health, fmt.Errorf("no heartbeat since %s", health.LastHeartbeat.Format(time.RFC3339))
+	}
+	return health, nil
+}
+
+// recover returns jobs left running by a previous process of this replica, or
+// by replicas that stopped sending heartbeats, to pending so they are resumed
+// from their last checkpoint. It runs once at startup, before this process
+// runs any job of its own.
+func (q *Queue) recover() error {
+	count, err := q.store.RequeueStale(q.instanceID, time.Now().Add(-q.leaseTTL))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		slog.Info("Re-queued interrupted jobs", "count", count)
+		q.Notify()
+	}
+	return nil
+}
+
+// heartbeat periodically renews the leases of running jobs and takes over
+// jobs abandoned by other replicas
+func (q *Queue) heartbeat() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		q.beat = time.Now()
+		active := make(map[uint]*activeJob, len(q.running))
+		for id, job := range q.running {
+			active[id] = job
+		}
+		q.mu.Unlock()
+
+		for id, job := range active {
+			q.renew(id, job)
+		}
+
+		if err := q.takeOver(); err != nil {
+			slog.Error("Failed to re-queue abandoned jobs", "error", err)
+		}
+	}
+}
+
+// takeOver returns jobs of replicas that stopped sending heartbeats to
+// pending. Jobs of this replica are left alone: they are still running here
+// and renewed by the heartbeat.
+func (q *Queue) takeOver() error {
+	count, err := q.store.RequeueAbandoned(q.instanceID, time.Now().Add(-q.leaseTTL))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		slog.Info("Re-queued abandoned jobs", "count", count)
+		q.Notify()
+	}
+	return nil
+}
+
+// renew extends the lease of a running job, cancelling it if it was cancelled
+// through another replica or taken over
+func (q *Queue) renew(jobID uint, job *activeJob) {
+	owned, err := q.store.Renew(jobID, q.instanceID, time.Now())
+	if err != nil {
+		slog.Error("Failed to renew job", "job_id", jobID, "error", err)
+		return
+	}
+	if !owned {
+		if current, err := q.store.Get(jobID); err == nil && current.Status == StatusCancelled {
+			job.cancel(ErrJobCancelled)
+		} else {
+			job.cancel(errLeaseLost)
+		}
+		return
+	}
+
+	if job.lock != "" {
+		if err := lock.Refresh(q.conn, job.lock, job.owner, q.leaseTTL); err != nil {
+			slog.Error("Failed to refresh lock", "lock", job.lock, "job_id", jobID, "error", err)
+			if errors.Is(err, lock.ErrNotHeld) {
+				job.cancel(errLeaseLost)
+			}
+		}
+	}
+}
+
+// isStopping reports whether the queue is shutting down
+func (q *Queue) isStopping() bool {
+	select {
+	case <-q.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Notify wakes up an idle worker
+func (q *Queue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker claims and runs pending jobs until the queue stops
+func (q *Queue) worker(id int) {
+	defer q.wg.Done()
+	defer func() {
+		q.mu.Lock()
+		q.alive--
+		q.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for !q.isStopping() {
+			job, err := q.claim()
+			if err != nil {
+				slog.Error("Worker failed to claim job", "worker", id, "error", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			if !q.run(job) {
+				// The cluster is busy on another replica, retry on the next poll
+				break
+			}
+		}
+
+		select {
+		case <-q.stopping:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim atomically moves the oldest pending job to running. Jobs of clusters
+// that already have a running job are skipped.
+func (q *Queue) claim() (*db.Job, error) {
+	for {
+		job, err := q.store.FirstClaimable()
+		if job == nil || err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		claimed, err := q.store.Claim(job.ID, q.instanceID, now)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			job.Status = StatusRunning
+			job.StartedAt = &now
+			job.WorkerID = q.instanceID
+			job.HeartbeatAt = &now
+			return job, nil
+		}
+		// Another worker claimed it first, try the next one
+	}
+}
+
+// run executes a claimed job and records its outcome. It returns false if the
+// job could not start because its cluster is locked by another replica.
+func (q *Queue) run(job *db.Job) bool {
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		q.finish(job, fmt.Errorf("%w: %s", ErrNoHandler, job.Type))
+		return true
+	}
+
+	// Logs and spans of the job continue the request that created it
+	jobCtx := logging.WithRequestID(q.ctx, job.RequestID)
+	jobCtx = tracing.Extract(jobCtx, job.TraceParent)
+
+	var lockName string
+	lockOwner := q.lockOwner(job.ID)
+	if job.ClusterID != 0 {
+		lockName = lock.ClusterLock(job.ClusterID)
+		acquired, err := lock.Acquire(q.conn, lockName, lockOwner, q.leaseTTL)
+		if err != nil || !acquired {
+			if err != nil {
+				slog.ErrorContext(jobCtx, "Failed to lock cluster", "cluster_id", job.ClusterID, "job_id", job.ID, "error", err)
+			}
+			q.requeue(job)
+			return false
+		}
+		defer func() {
+			if err := lock.Release(q.conn, lockName, lockOwner); err != nil {
+				slog.ErrorContext(jobCtx, "Failed to release lock", "lock", lockName, "error", err)
+			}
+		}()
+	}
+
+	jobCtx, span := tracing.Start(jobCtx, "job "+job.Type,
+		attribute.Int("job.id", int(job.ID)),
+		attribute.Int("cluster.id", int(job.ClusterID)),
+	)
+	defer span.End()
+
+	ctx, cancel := context.WithCancelCause(jobCtx)
+	gate := &StepGate{cancel: cancel}
+	ctx = context.WithValue(ctx, gateKey{}, gate)
+	q.mu.Lock()
+	q.running[job.ID] = &activeJob{cancel: cancel, lock: lockName, owner: lockOwner, gate: gate}
+	// Started while the queue began stopping
+	if q.isStopping() {
+		gate.stop()
+	}
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		if q.drained != nil && len(q.running) == 0 {
+			close(q.drained)
+			q.drained = nil
+		}
+		q.mu.Unlock()
+		cancel(nil)
+	}()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+		return handler(ctx, job)
+	}()
+
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrJobCancelled):
+		err = ErrJobCancelled
+	case errors.Is(cause, errLeaseLost):
+		// Another replica owns the job now
+		slog.WarnContext(ctx, "Job was taken over by another replica", "job_id", job.ID)
+		return true
+	case errors.Is(cause, ErrInterrupted), q.ctx.Err() != nil:
+		// Interrupted by shutdown, leave it to be resumed on the next start
+		q.interrupt(job)
+		slog.InfoContext(ctx, "Job interrupted, it will resume on next start", "job_id", job.ID)
+		return true
+	}
+	tracing.RecordError(span, err)
+	q.finish(job, err)
+	return true
+}
+
+// lockOwner returns the owner of the cluster lock of a job. It is the job
+// rather than the replica, so two workers of a replica claiming jobs of the
+// same cluster do not both get the lock; a job resumed after a restart takes
+// its lock back.
+func (q *Queue) lockOwner(jobID uint) string {
+	return fmt.Sprintf("%s/%d", q.instanceID, jobID)
+}
+
+// requeue returns a running job owned by this replica to pending, keeping its checkpoint
+func (q *Queue) requeue(job *db.Job) {
+	if err := q.store.Requeue(job.ID, q.instanceID); err != nil {
+		slog.Error("Failed to re-queue job", "job_id", job.ID, "error", err)
+	}
+}
+
+// interrupt returns a running job stopped by shutdown to pending, keeping its
+// checkpoint and recording why it stopped
+func (q *Queue) interrupt(job *db.Job) {
+	if err := q.store.Interrupt(job.ID, q.instanceID, "Interrupted by shutdown, resumes on next start"); err != nil {
+		slog.Error("Failed to re-queue interrupted job", "job_id", job.ID, "error", err)
+	}
+}
+
+// Cancel cancels a pending or running job. Running jobs have their context
+// cancelled, which aborts in-flight SSH commands.
+func (q *Queue) Cancel(jobID uint) error {
+	job, err := q.store.Get(jobID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrJobNotFound
+		}
+		return err
+	}
+
+	switch job.Status {
+	case StatusPending:
+		cancelled, err := q.store.CancelPending(jobID, time.Now())
+		if err != nil {
+			return err
+		}
+		if !cancelled {
+			// Claimed by a worker in the meantime
+			return q.Cancel(jobID)
+		}
+		return nil
+
+	case StatusRunning:
+		q.mu.Lock()
+		active, ok := q.running[jobID]
+		q.mu.Unlock()
+		if ok {
+			active.cancel(ErrJobCancelled)
+			return nil
+		}
+		// Not running in this process, mark it directly. The owning replica,
+		// if still alive, notices on its next heartbeat and stops the job.
+		return q.store.MarkCancelled(jobID, time.Now())
+
+	default:
+		return ErrJobFinished
+	}
+}
+
+// finish records the final status of a job
+func (q *Queue) finish(job *db.Job, err error) {
+	status, message := StatusCompleted, ""
+	if errors.Is(err, ErrJobCancelled) {
+		status = StatusCancelled
+	} else if err != nil {
+		status, message = StatusFailed, err.Error()
+	}
+
+	if dbErr := q.store.Finish(job.ID, q.instanceID, status, message, time.Now()); dbErr != nil {
+		slog.Error("Failed to update job", "job_id", job.ID, "error", dbErr)
+	}
+}
+
+// UpdateProgress records the current phase and progress (0-100) of a running job
+func (q *Queue) UpdateProgress(jobID uint, phase string, progress int) error {
+	if progress < 0 {
+		progress = 0
+	}
+	if progress > 100 {
+		progress = 100
+	}
+	return q.store.UpdateProgress(jobID, phase, progress)
+}
+
+// SaveCheckpoint persists the resume state of a running job
+func (q *Queue) SaveCheckpoint(jobID uint, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return q.store.SaveCheckpoint(jobID, string(data))
+}
+
+// SaveMetadata records the result of a job, shown with the job
+func (q *Queue) SaveMetadata(jobID uint, metadata interface{}) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return q.store.SaveMetadata(jobID, string(data))
+}
+
+// LoadCheckpoint decodes the resume state of a job into state. It leaves
+// state untouched if the job has no checkpoint yet.
+func LoadCheckpoint(job *db.Job, state interface{}) error {
+	if job.Checkpoint == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(job.Checkpoint), state)
+}
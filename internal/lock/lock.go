@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubeforge/internal/db"
+)
+
+// ErrNotHeld is returned when refreshing a lock that is owned by someone else
+var ErrNotHeld = errors.New("lock is not held")
+
+// ClusterLock returns the name of the advisory lock guarding a cluster
+func ClusterLock(clusterID uint) string {
+	return fmt.Sprintf("cluster:%d", clusterID)
+}
+
+// Acquire takes the named lock for owner until ttl elapses. It succeeds if the
+// lock is free, expired or already held by owner, and returns false if another
+// owner holds it.
+func Acquire(conn *gorm.DB, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	lock := db.Lock{
+		Name:      name,
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	result := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	// Take over the lock if it is ours or has expired
+	result = conn.Model(&db.Lock{}).
+		Where("name = ? AND (owner = ? OR expires_at < ?)", name, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": now.Add(ttl)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Refresh extends a lock held by owner
+func Refresh(conn *gorm.DB, name, owner string, ttl time.Duration) error {
+	result := conn.Model(&db.Lock{}).
+		Where("name = ? AND owner = ?", name, owner).
+		Update("expires_at", time.Now().Add(ttl))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Release frees a lock held by owner
+func Release(conn *gorm.DB, name, owner string) error {
+	return conn.Where("name = ? AND owner = ?", name, owner).Delete(&db.Lock{}).Error
+}
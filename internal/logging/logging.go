@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// level is shared by every logger Setup creates so SetLevel applies at once
+var level slog.LevelVar
+
+// Setup configures the default slog logger, which the stdlib log package
+// also writes through. level is debug, info, warn or error, format is json
+// or console.
+func Setup(w io.Writer, lvl, format string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "console", "text", "":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected json or console", format)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	return nil
+}
+
+// SetLevel changes the minimum level of the loggers created by Setup
+func SetLevel(lvl string) error {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(lvl)); err != nil {
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", lvl)
+	}
+	level.Set(parsed)
+	return nil
+}
+
+// Fatal logs an error and exits the process
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// NewRequestID returns a random correlation ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context carrying a request ID. Log records written
+// with the context are tagged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the request ID of a context, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request and trace IDs of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SMTPConfig holds the server used to send email notifications
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // PLAIN auth is used when set
+	Password string
+	From     string
+}
+
+var (
+	smtpMu     sync.RWMutex
+	smtpConfig *SMTPConfig
+)
+
+// SetSMTP configures the mail server for email channels
+func SetSMTP(config SMTPConfig) {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	smtpMu.Lock()
+	defer smtpMu.Unlock()
+	smtpConfig = &config
+}
+
+// currentSMTP returns the configured mail server, or nil
+func currentSMTP() *SMTPConfig {
+	smtpMu.RLock()
+	defer smtpMu.RUnlock()
+	return smtpConfig
+}
+
+// EmailNotifier sends messages by email
+type EmailNotifier struct {
+	SMTP SMTPConfig
+	To   []string
+}
+
+// Send mails the message to all recipients
+func (n *EmailNotifier) Send(ctx context.Context, subject, message string) error {
+	var auth smtp.Auth
+	if n.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", n.SMTP.Username, n.SMTP.Password, n.SMTP.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.SMTP.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(n.SMTP.Host, strconv.Itoa(n.SMTP.Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, n.SMTP.From, n.To, []byte(msg.String()))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	}
+}
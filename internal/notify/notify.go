@@ -0,0 +1,270 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/secrets"
+)
+
+// Channel types
+const (
+	TypeSlack = "slack"
+	TypeTeams = "teams"
+	TypeEmail = "email"
+)
+
+// Types are the supported channel types
+var Types = []string{TypeSlack, TypeTeams, TypeEmail}
+
+// sendTimeout bounds the delivery of a single notification
+const sendTimeout = 30 * time.Second
+
+// Common errors
+var (
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrUnknownType     = errors.New("unknown notification channel type")
+)
+
+// DefaultTemplate renders a notification when a channel has no template of its own
+const DefaultTemplate = `KubeForge: {{.Operation}} of cluster {{.Cluster}} {{.Status}}{{if .Duration}} after {{.Duration}}{{end}}{{if .Error}}
+Error: {{.Error}}{{end}}`
+
+// Event is the outcome of a cluster operation. Its fields are available to
+// message templates.
+type Event struct {
+	ClusterID uint          `json:"cluster_id"`
+	Cluster   string        `json:"cluster"`
+	Operation string        `json:"operation"` // provisioning, alert <rule> [subject]
+	Status    string        `json:"status"`    // succeeded, failed, or firing, resolved for alerts
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Time      time.Time     `json:"time"`
+}
+
+// Subject returns a one line summary of the event, used as the email subject
+func (e Event) Subject() string {
+	return fmt.Sprintf("[KubeForge] %s of cluster %s %s", e.Operation, e.Cluster, e.Status)
+}
+
+// Notifier delivers a rendered message to one destination
+type Notifier interface {
+	Send(ctx context.Context, subject, message string) error
+}
+
+// ChannelConfig holds the destination of a channel. Webhook URLs embed their
+// credentials and are stored encrypted.
+type ChannelConfig struct {
+	WebhookURL string   `json:"webhook_url,omitempty"` // slack, teams
+	To         []string `json:"to,omitempty"`          // email recipients
+}
+
+// Validate checks that the config fits the channel type
+func (c ChannelConfig) Validate(channelType string) error {
+	switch channelType {
+	case TypeSlack, TypeTeams:
+		if !strings.HasPrefix(c.WebhookURL, "https://") && !strings.HasPrefix(c.WebhookURL, "http://") {
+			return fmt.Errorf("%s channel requires an http(s) webhook_url", channelType)
+		}
+	case TypeEmail:
+		if len(c.To) == 0 {
+			return fmt.Errorf("email channel requires at least one recipient in to")
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownType, channelType)
+	}
+	return nil
+}
+
+// New creates the notifier for a channel type
+func New(channelType string, config ChannelConfig) (Notifier, error) {
+	if err := config.Validate(channelType); err != nil {
+		return nil, err
+	}
+	switch channelType {
+	case TypeSlack:
+		return &SlackNotifier{WebhookURL: config.WebhookURL}, nil
+	case TypeTeams:
+		return &TeamsNotifier{WebhookURL: config.WebhookURL}, nil
+	default:
+		mailer := currentSMTP()
+		if mailer == nil {
+			return nil, fmt.Errorf("email channels require SMTP_HOST to be configured")
+		}
+		return &EmailNotifier{SMTP: *mailer, To: config.To}, nil
+	}
+}
+
+// ValidateTemplate checks that a message template parses
+func ValidateTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	_, err := template.New("message").Parse(text)
+	return err
+}
+
+// Render executes a message template for an event, using DefaultTemplate
+// when text is empty
+func Render(text string, event Event) (string, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid message template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to render message: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// staticChannel is a channel configured from the environment
+type staticChannel struct {
+	channelType string
+	notifier    Notifier
+	template    string
+}
+
+// StaticChannel describes a channel configured from the environment
+type StaticChannel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var (
+	mu             sync.RWMutex
+	staticChannels = make(map[string]staticChannel)
+)
+
+// Register adds a channel configured from the environment. Clusters opt in
+// to it by name, like channels created through the API.
+func Register(name, channelType string, notifier Notifier, template string) {
+	mu.Lock()
+	defer mu.Unlock()
+	staticChannels[name] = staticChannel{channelType: channelType, notifier: notifier, template: template}
+}
+
+// StaticChannels returns the channels configured from the environment sorted by name
+func StaticChannels() []StaticChannel {
+	mu.RLock()
+	defer mu.RUnlock()
+	channels := make([]StaticChannel, 0, len(staticChannels))
+	for name, channel := range staticChannels {
+		channels = append(channels, StaticChannel{Name: name, Type: channel.channelType})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+// IsStatic reports whether a channel is configured from the environment
+func IsStatic(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := staticChannels[name]
+	return ok
+}
+
+// Exists reports whether a channel with the given name is configured
+func Exists(conn *gorm.DB, name string) bool {
+	if IsStatic(name) {
+		return true
+	}
+	var count int64
+	conn.Model(&db.NotificationChannel{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
+
+// SealConfig encrypts a channel config for storage
+func SealConfig(config ChannelConfig) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := secrets.Encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot store channel settings: %w", err)
+	}
+	return sealed, nil
+}
+
+// resolve returns the notifier and template of a channel
+func resolve(conn *gorm.DB, name string) (Notifier, string, error) {
+	mu.RLock()
+	static, ok := staticChannels[name]
+	mu.RUnlock()
+	if ok {
+		return static.notifier, static.template, nil
+	}
+
+	var channel db.NotificationChannel
+	if err := conn.Where("name = ?", name).First(&channel).Error; err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrChannelNotFound, name)
+	}
+	notifier, err := Open(&channel)
+	if err != nil {
+		return nil, "", err
+	}
+	return notifier, channel.Template, nil
+}
+
+// Open decrypts a stored channel and creates its notifier
+func Open(channel *db.NotificationChannel) (Notifier, error) {
+	data, err := secrets.Decrypt(channel.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings of channel %s: %w", channel.Name, err)
+	}
+	var config ChannelConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid settings of channel %s: %w", channel.Name, err)
+	}
+	return New(channel.Type, config)
+}
+
+// SendTo renders and delivers an event to a single channel
+func SendTo(ctx context.Context, conn *gorm.DB, name string, event Event) error {
+	notifier, text, err := resolve(conn, name)
+	if err != nil {
+		return err
+	}
+	message, err := Render(text, event)
+	if err != nil {
+		return err
+	}
+	return notifier.Send(ctx, event.Subject(), message)
+}
+
+// Dispatch delivers an event to the named channels in the background.
+// Delivery failures are logged and never affect the operation itself.
+func Dispatch(conn *gorm.DB, event Event, channels []string) {
+	if len(channels) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, name := range channels {
+		go func(name string) {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			if err := SendTo(ctx, conn, name, event); err != nil {
+				slog.Error("Failed to send notification", "channel", name, "cluster", event.Cluster, "error", err)
+			}
+		}(name)
+	}
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+)
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// SlackNotifier posts messages to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+// Send posts the message as the text of a Slack message
+func (n *SlackNotifier) Send(ctx context.Context, subject, message string) error {
+	return postJSON(ctx, n.WebhookURL, map[string]string{"text": message})
+}
+
+// TeamsNotifier posts messages to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	WebhookURL string
+}
+
+// Send posts the message as a Teams message card
+func (n *TeamsNotifier) Send(ctx context.Context, subject, message string) error {
+	return postJSON(ctx, n.WebhookURL, map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  subject,
+		"title":    subject,
+		"text":     message,
+	})
+}
+
+// postJSON posts a JSON payload to a webhook. The URL is left out of errors
+// since webhook URLs contain their credentials.
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package provision
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// APIServerTransport returns the API server URL of a kubeconfig and a
+// transport to it that trusts the certificate authority of the kubeconfig
+// and authenticates as its user, with a client certificate or a token
+func APIServerTransport(data []byte) (*url.URL, http.RoundTripper, error) {
+	cluster, user, err := parseKubeconfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	server, err := url.Parse(cluster.Cluster.Server)
+	if err != nil || server.Host == "" {
+		return nil, nil, fmt.Errorf("%w: invalid server %q", ErrInvalidKubeconfig, cluster.Cluster.Server)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cluster.Cluster.CertificateAuthorityData != "" {
+		ca, err := base64.StdEncoding.DecodeString(cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: certificate authority: %v", ErrInvalidKubeconfig, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, nil, fmt.Errorf("%w: no certificate in the certificate authority", ErrInvalidKubeconfig)
+		}
+	}
+	if user.User.ClientCertificateData != "" {
+		cert, err := base64.StdEncoding.DecodeString(user.User.ClientCertificateData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: client certificate: %v", ErrInvalidKubeconfig, err)
+		}
+		key, err := base64.StdEncoding.DecodeString(user.User.ClientKeyData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: client key: %v", ErrInvalidKubeconfig, err)
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: client certificate: %v", ErrInvalidKubeconfig, err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	transport.ResponseHeaderTimeout = time.Minute
+	if user.User.Token != "" {
+		return server, &bearerTransport{token: user.User.Token, next: transport}, nil
+	}
+	return server, transport, nil
+}
+
+// bearerTransport adds a bearer token to requests
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
+
+// KubeconfigClientCertificate returns the client certificate the user of a
+// kubeconfig authenticates with, nil for a user with a token
+func KubeconfigClientCertificate(data []byte) (*x509.Certificate, error) {
+	_, user, err := parseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if user.User.ClientCertificateData == "" {
+		return nil, nil
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(user.User.ClientCertificateData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client certificate: %v", ErrInvalidKubeconfig, err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no client certificate", ErrInvalidKubeconfig)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,53 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// renewCertificatesCommand renews the certificates kubeadm manages on a
+// control plane, including the one of admin.conf, and stops the containers
+// of the control plane components so the kubelet starts them again with
+// the new certificates
+const renewCertificatesCommand = `kubeadm certs renew all
+for component in kube-apiserver kube-controller-manager kube-scheduler etcd; do
+  crictl ps --name "^$component\$" -q | xargs -r crictl stop
+done`
+
+// waitAPIServerCommand waits up to five minutes for the API server of the
+// control plane to be ready again
+const waitAPIServerCommand = `for i in $(seq 60); do
+  kubectl --kubeconfig ` + adminKubeconfigPath + ` get --raw /readyz >/dev/null 2>&1 && exit 0
+  sleep 5
+done
+echo "API server not ready after certificate renewal" >&2
+exit 1`
+
+// RenewCertificates renews the control plane certificates of a control
+// plane node, waits for its API server to come back and returns its new
+// admin kubeconfig
+func (p *KubeadmProvisioner) RenewCertificates(ctx context.Context, host HostSpec) ([]byte, error) {
+	client, err := p.connect(ctx, host, StepRenewCerts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", host.Address, err)
+	}
+	defer client.Close()
+
+	p.emitEvent("info", host.Address, StepRenewCerts, "Renewing control plane certificates")
+	if _, stderr, err := client.RunCommand(ctx, renewCertificatesCommand); err != nil {
+		return nil, fmt.Errorf("kubeadm certs renew failed: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	if _, stderr, err := client.RunCommand(ctx, waitAPIServerCommand); err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.TrimSpace(stderr), err)
+	}
+
+	// The copy of admin.conf made for kubectl on the host is renewed too
+	client.RunCommand(ctx, "test -f $HOME/.kube/config && cp "+adminKubeconfigPath+" $HOME/.kube/config")
+	kubeconfig, _, err := client.RunCommand(ctx, "cat "+adminKubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve kubeconfig: %w", err)
+	}
+	p.emitEvent("info", host.Address, StepRenewCerts, "Control plane certificates renewed")
+	return []byte(kubeconfig), nil
+}
@@ -0,0 +1,136 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hostPollInterval is how often WaitForHost tries to connect to a machine
+// that is still booting
+const hostPollInterval = 5 * time.Second
+
+// cloudInitWait waits for cloud-init where it is installed. Exit status 2
+// means it finished with recoverable errors.
+const cloudInitWait = `if command -v cloud-init >/dev/null 2>&1; then cloud-init status --wait >/dev/null; rc=$?; [ $rc -eq 0 ] || [ $rc -eq 2 ]; fi`
+
+// WaitForHost waits until a new machine accepts SSH connections and
+// cloud-init has finished configuring it
+func WaitForHost(ctx context.Context, host HostSpec) error {
+	var lastErr error
+	for {
+		client, err := NewSSHClient(host)
+		if err == nil {
+			_, stderr, err := client.RunCommand(ctx, cloudInitWait)
+			client.Close()
+			if err != nil {
+				return fmt.Errorf("cloud-init failed on %s: %s: %w", host.Address, stderr, err)
+			}
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("host %s is not reachable over SSH: %w", host.Address, lastErr)
+		case <-time.After(hostPollInterval):
+		}
+	}
+}
+
+// cloudInitDir is where user-data writes the scripts preparing a host
+const cloudInitDir = "/var/lib/kubeforge/cloud-init"
+
+// CloudInitParams are the values of a cloud-init document joining a host to
+// a cluster on first boot
+type CloudInitParams struct {
+	Platform    Platform
+	Runtime     string
+	K8sVersion  string
+	JoinCommand string // kubeadm join, with the control plane flags for control planes
+
+	// The host reports its address and the outcome to KubeForge after
+	// joining, authenticated by the registration token
+	RegisterURL   string
+	RegisterToken string
+}
+
+// cloudConfig is the part of a cloud-config document KubeForge writes
+type cloudConfig struct {
+	WriteFiles []cloudConfigFile `yaml:"write_files"`
+	RunCmd     [][]string        `yaml:"runcmd"`
+}
+
+type cloudConfigFile struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Content     string `yaml:"content"`
+}
+
+// RenderCloudInit renders cloud-config user-data that prepares a host with
+// the scripts KubeForge runs over SSH, joins it to the cluster and registers
+// it with KubeForge
+func RenderCloudInit(params CloudInitParams) ([]byte, error) {
+	if params.Runtime != "containerd" {
+		return nil, fmt.Errorf("unsupported runtime: %s", params.Runtime)
+	}
+	scriptParams, err := newScriptParams(params.Platform, params.K8sVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var config cloudConfig
+	steps := []string{"disable-swap", "kernel-modules", "sysctl", params.Runtime, "kubernetes-tools"}
+	for _, name := range steps {
+		script, err := RenderScript(name, scriptParams)
+		if err != nil {
+			return nil, err
+		}
+		config.WriteFiles = append(config.WriteFiles, cloudConfigFile{
+			Path:        path.Join(cloudInitDir, name+".sh"),
+			Permissions: "0700",
+			Content:     string(script),
+		})
+	}
+
+	join := fmt.Sprintf(`#!/bin/sh
+# Prepares the host and joins it to the cluster, rendered by KubeForge
+set -eu
+for script in %s; do
+	echo "kubeforge: running $script"
+	sh %s/$script.sh
+done
+echo "kubeforge: joining the cluster"
+%s
+`, strings.Join(steps, " "), cloudInitDir, params.JoinCommand)
+
+	register := fmt.Sprintf(`#!/bin/sh
+# Runs join.sh and reports the address of the host and the outcome to KubeForge
+if sh %[1]s/join.sh; then status=ready; else status=failed; fi
+address=$(ip -4 route get 1.1.1.1 2>/dev/null | sed -n 's/.* src \([0-9.]*\).*/\1/p')
+[ -n "$address" ] || address=$(hostname -I | awk '{print $1}')
+curl -fsS --retry 5 -X POST -H %[2]s -H 'Content-Type: application/json' \
+	-d "{\"hostname\": \"$(hostname)\", \"address\": \"$address\", \"status\": \"$status\"}" %[3]s
+[ "$status" = ready ]
+`, cloudInitDir, ShellQuote("Authorization: Bearer "+params.RegisterToken), ShellQuote(params.RegisterURL))
+
+	config.WriteFiles = append(config.WriteFiles,
+		cloudConfigFile{Path: path.Join(cloudInitDir, "join.sh"), Permissions: "0700", Content: join},
+		cloudConfigFile{Path: path.Join(cloudInitDir, "register.sh"), Permissions: "0700", Content: register},
+	)
+	config.RunCmd = [][]string{{"sh", path.Join(cloudInitDir, "register.sh")}}
+
+	var buf bytes.Buffer
+	buf.WriteString("#cloud-config\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
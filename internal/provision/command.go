@@ -0,0 +1,143 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// terminateGracePeriod is how long a cancelled command may take to exit
+// after SIGTERM before it is killed
+const terminateGracePeriod = 10 * time.Second
+
+// CommandOption configures how a command is run
+type CommandOption func(*commandOptions)
+
+type commandOptions struct {
+	timeout time.Duration
+	stdin   io.Reader
+}
+
+// commandStdin returns the stdin set by WithStdin, nil if none
+func commandStdin(opts []CommandOption) io.Reader {
+	var options commandOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options.stdin
+}
+
+// WithTimeout bounds the duration of a command. A command running out of
+// time is terminated like a cancelled one.
+func WithTimeout(timeout time.Duration) CommandOption {
+	return func(o *commandOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithStdin passes r to the command as its stdin, e.g. a manifest for
+// kubectl apply -f -, which is safer than embedding it in the command
+func WithStdin(r io.Reader) CommandOption {
+	return func(o *commandOptions) {
+		o.stdin = r
+	}
+}
+
+// CommandError is returned when a command ran on the host but did not exit
+// successfully. It matches ErrCommandFailed, while a lost connection matches
+// ErrConnectionFailed.
+type CommandError struct {
+	ExitCode int
+	Signal   string // set when the command was killed by a signal, e.g. KILL
+	Stdout   string
+	Stderr   string
+}
+
+func (e *CommandError) Error() string {
+	if e.Signal != "" {
+		return "command killed by signal " + e.Signal
+	}
+	return fmt.Sprintf("command exited with status %d", e.ExitCode)
+}
+
+// Is makes errors.Is(err, ErrCommandFailed) report true
+func (e *CommandError) Is(target error) bool {
+	return target == ErrCommandFailed
+}
+
+// runSession runs command on session, which writes to stdout and stderr.
+// When ctx is done or the command times out, the command gets SIGTERM and,
+// if it does not exit within the grace period, SIGKILL.
+func runSession(ctx context.Context, session *ssh.Session, command string, stdout, stderr *bytes.Buffer, opts []CommandOption) error {
+	var options commandOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	runCtx := ctx
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return commandError(err, stdout, stderr)
+	case <-runCtx.Done():
+	}
+
+	terminate(session, done)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("command timed out after %s: %w", options.timeout, context.DeadlineExceeded)
+}
+
+// terminate stops a running command, first asking it to exit
+func terminate(session *ssh.Session, done <-chan error) {
+	session.Signal(ssh.SIGTERM)
+	select {
+	case <-done:
+		return
+	case <-time.After(terminateGracePeriod):
+	}
+
+	session.Signal(ssh.SIGKILL)
+	session.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// commandError tells a command that failed on the host from a connection
+// that died while it ran
+func commandError(err error, stdout, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &CommandError{
+			ExitCode: exitErr.ExitStatus(),
+			Signal:   exitErr.Signal(),
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+}
@@ -0,0 +1,191 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CredentialNamespace holds the service accounts of short-lived credentials
+const CredentialNamespace = "kubeforge-credentials"
+
+// CreateCredential creates a service account bound to a cluster role and
+// returns a token of it valid for ttl. Deleting the service account with
+// RevokeCredential invalidates the token before it expires.
+func (p *KubeadmProvisioner) CreateCredential(ctx context.Context, controlPlane HostSpec, name, clusterRole string, ttl time.Duration) (string, error) {
+	client, err := p.connect(ctx, controlPlane, "credentials")
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	kubectl := "kubectl --kubeconfig " + adminKubeconfigPath
+	command := strings.Join([]string{
+		fmt.Sprintf("%s create namespace %s --dry-run=client -o yaml | %s apply -f - >/dev/null", kubectl, CredentialNamespace, kubectl),
+		fmt.Sprintf("%s -n %s create serviceaccount %s >/dev/null", kubectl, CredentialNamespace, ShellQuote(name)),
+		fmt.Sprintf("%s create clusterrolebinding %s --clusterrole=%s --serviceaccount=%s:%s >/dev/null",
+			kubectl, ShellQuote(name), ShellQuote(clusterRole), CredentialNamespace, ShellQuote(name)),
+		fmt.Sprintf("%s -n %s create token %s --duration=%ds", kubectl, CredentialNamespace, ShellQuote(name), int(ttl.Seconds())),
+	}, " && ")
+	stdout, stderr, err := client.RunCommand(ctx, command)
+	if err != nil {
+		return "", fmt.Errorf("failed to create credential %s: %s: %w", name, strings.TrimSpace(stderr), err)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// RevokeCredential deletes the service account and cluster role binding of
+// a credential, invalidating its tokens
+func (p *KubeadmProvisioner) RevokeCredential(ctx context.Context, controlPlane HostSpec, name string) error {
+	client, err := p.connect(ctx, controlPlane, "credentials")
+	if err != nil {
+		return fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	kubectl := "kubectl --kubeconfig " + adminKubeconfigPath
+	command := fmt.Sprintf("%s delete clusterrolebinding %s --ignore-not-found && %s -n %s delete serviceaccount %s --ignore-not-found",
+		kubectl, ShellQuote(name), kubectl, CredentialNamespace, ShellQuote(name))
+	if _, stderr, err := client.RunCommand(ctx, command); err != nil {
+		return fmt.Errorf("failed to revoke credential %s: %s: %w", name, strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// kubeconfig is the part of a kubeconfig file read and written here
+type kubeconfig struct {
+	APIVersion     string              `yaml:"apiVersion"`
+	Kind           string              `yaml:"kind"`
+	Clusters       []kubeconfigCluster `yaml:"clusters"`
+	Contexts       []kubeconfigContext `yaml:"contexts"`
+	CurrentContext string              `yaml:"current-context"`
+	Users          []kubeconfigUser    `yaml:"users"`
+}
+
+type kubeconfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+	} `yaml:"cluster"`
+}
+
+type kubeconfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token                 string `yaml:"token,omitempty"`
+		ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+		ClientKeyData         string `yaml:"client-key-data,omitempty"`
+	} `yaml:"user"`
+}
+
+// parseKubeconfig reads a kubeconfig and returns the cluster and user of its
+// current context, falling back to the first ones
+func parseKubeconfig(data []byte) (kubeconfigCluster, kubeconfigUser, error) {
+	var source kubeconfig
+	if err := yaml.Unmarshal(data, &source); err != nil {
+		return kubeconfigCluster{}, kubeconfigUser{}, fmt.Errorf("%w: %v", ErrInvalidKubeconfig, err)
+	}
+	if len(source.Clusters) == 0 {
+		return kubeconfigCluster{}, kubeconfigUser{}, fmt.Errorf("%w: no cluster", ErrInvalidKubeconfig)
+	}
+	cluster := source.Clusters[0]
+	var user kubeconfigUser
+	if len(source.Users) > 0 {
+		user = source.Users[0]
+	}
+	for _, c := range source.Contexts {
+		if c.Name != source.CurrentContext {
+			continue
+		}
+		for _, candidate := range source.Clusters {
+			if candidate.Name == c.Context.Cluster {
+				cluster = candidate
+			}
+		}
+		for _, candidate := range source.Users {
+			if candidate.Name == c.Context.User {
+				user = candidate
+			}
+		}
+	}
+	return cluster, user, nil
+}
+
+// TokenKubeconfig returns a kubeconfig authenticating with token to the API
+// server and certificate authority of an admin kubeconfig
+func TokenKubeconfig(admin []byte, user, token string) ([]byte, error) {
+	cluster, _, err := parseKubeconfig(admin)
+	if err != nil {
+		return nil, err
+	}
+
+	config := kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeconfigCluster{cluster},
+		Contexts:       []kubeconfigContext{{Name: user + "@" + cluster.Name}},
+		CurrentContext: user + "@" + cluster.Name,
+		Users:          []kubeconfigUser{{Name: user}},
+	}
+	config.Contexts[0].Context.Cluster = cluster.Name
+	config.Contexts[0].Context.User = user
+	config.Users[0].User.Token = token
+	return encodeKubeconfig(&config)
+}
+
+// KubeconfigEntry is the kubeconfig of a cluster merged by MergeKubeconfigs
+type KubeconfigEntry struct {
+	Name       string // of the cluster, user and context in the merged file
+	Kubeconfig []byte
+}
+
+// MergeKubeconfigs merges the current context of each kubeconfig into one
+// file, naming its cluster, user and context after the entry. The first
+// entry is the current context. Names must be unique.
+func MergeKubeconfigs(entries []KubeconfigEntry) ([]byte, error) {
+	config := kubeconfig{APIVersion: "v1", Kind: "Config"}
+	for _, entry := range entries {
+		cluster, user, err := parseKubeconfig(entry.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig of %s: %w", entry.Name, err)
+		}
+		cluster.Name, user.Name = entry.Name, entry.Name
+		current := kubeconfigContext{Name: entry.Name}
+		current.Context.Cluster = entry.Name
+		current.Context.User = entry.Name
+
+		config.Clusters = append(config.Clusters, cluster)
+		config.Users = append(config.Users, user)
+		config.Contexts = append(config.Contexts, current)
+		if config.CurrentContext == "" {
+			config.CurrentContext = entry.Name
+		}
+	}
+	return encodeKubeconfig(&config)
+}
+
+func encodeKubeconfig(config *kubeconfig) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
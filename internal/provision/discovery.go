@@ -0,0 +1,91 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// MaxScanAddresses bounds the addresses of a discovery scan, a /16 network
+const MaxScanAddresses = 1 << 16
+
+// scanDialTimeout bounds connecting to the SSH port of a scanned address
+const scanDialTimeout = 2 * time.Second
+
+// scanConcurrency is how many addresses are probed at once
+const scanConcurrency = 64
+
+// ScanAddresses returns the host addresses of a CIDR range. The network and
+// broadcast addresses of IPv4 ranges are left out.
+func ScanAddresses(cidr string) ([]string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("CIDR %s has more than %d addresses", cidr, MaxScanAddresses)
+	}
+
+	var addresses []string
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		addresses = append(addresses, addr.String())
+		if !addr.Next().IsValid() {
+			break
+		}
+	}
+	if prefix.Addr().Is4() && hostBits >= 2 {
+		addresses = addresses[1 : len(addresses)-1]
+	}
+	return addresses, nil
+}
+
+// ScanSSH probes port on every address and returns the addresses accepting
+// TCP connections, in the order given. progress, if set, is called with the
+// number of addresses probed so far.
+func ScanSSH(ctx context.Context, addresses []string, port int, progress func(done int)) ([]string, error) {
+	open := make([]bool, len(addresses))
+	var mu sync.Mutex
+	done := 0
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(scanConcurrency)
+	dialer := net.Dialer{Timeout: scanDialTimeout}
+	for i, address := range addresses {
+		i, address := i, address
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port))); err == nil {
+				conn.Close()
+				open[i] = true
+			}
+			mu.Lock()
+			done++
+			if progress != nil {
+				progress(done)
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var reachable []string
+	for i, address := range addresses {
+		if open[i] {
+			reachable = append(reachable, address)
+		}
+	}
+	return reachable, nil
+}
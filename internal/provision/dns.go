@@ -0,0 +1,64 @@
+package provision
+
+import (
+	"encoding/json"
+)
+
+// NodeLocalDNSAddon is the addon deploying NodeLocal DNSCache
+const NodeLocalDNSAddon = "node-local-dns"
+
+// DefaultNodeLocalDNSIP is the link-local address NodeLocal DNSCache listens
+// on by default
+const DefaultNodeLocalDNSIP = "169.254.20.10"
+
+// NodeLocalDNSIP returns the address NodeLocal DNSCache listens on with an
+// addon config
+func NodeLocalDNSIP(config json.RawMessage) string {
+	var c struct {
+		LocalIP string `json:"local_ip"`
+	}
+	json.Unmarshal(config, &c)
+	if c.LocalIP == "" {
+		return DefaultNodeLocalDNSIP
+	}
+	return c.LocalIP
+}
+
+// applyNodeLocalDNS points the kubelets at NodeLocal DNSCache when the spec
+// installs it and kube-proxy runs in IPVS mode. In iptables mode the cache
+// also answers on the address of the kube-dns service, so kubelets keep it.
+func applyNodeLocalDNS(docs []map[string]interface{}, spec ClusterSpec) []map[string]interface{} {
+	var localIP string
+	for _, addon := range spec.Addons {
+		if addon.Name == NodeLocalDNSAddon {
+			localIP = NodeLocalDNSIP(addon.Config)
+		}
+	}
+	if localIP == "" {
+		return docs
+	}
+	ipvs := false
+	for _, doc := range docs {
+		if doc["kind"] == "KubeProxyConfiguration" {
+			ipvs = doc["mode"] == "ipvs"
+		}
+	}
+	if !ipvs {
+		return docs
+	}
+	docs, kubelet := kubeletConfiguration(docs)
+	setDefault(kubelet, "clusterDNS", []string{localIP})
+	return docs
+}
+
+// kubeletConfiguration returns the KubeletConfiguration of a kubeadm
+// configuration, adding an empty one when it has none
+func kubeletConfiguration(docs []map[string]interface{}) ([]map[string]interface{}, map[string]interface{}) {
+	for _, doc := range docs {
+		if doc["kind"] == "KubeletConfiguration" {
+			return docs, doc
+		}
+	}
+	kubelet := map[string]interface{}{"apiVersion": "kubelet.config.k8s.io/v1beta1", "kind": "KubeletConfiguration"}
+	return append(docs, kubelet), kubelet
+}
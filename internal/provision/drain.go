@@ -0,0 +1,272 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"kubeforge/internal/kube"
+	"kubeforge/internal/validation"
+)
+
+// DrainOptions controls evicting the pods of a node. Pods are evicted
+// through the eviction API, so PodDisruptionBudgets are respected.
+type DrainOptions struct {
+	GracePeriod        *int     `json:"grace_period,omitempty"`         // seconds pods get to terminate, unset uses their own terminationGracePeriodSeconds
+	Timeout            Duration `json:"timeout,omitempty"`              // time to wait for the evictions, 5m when unset
+	IgnoreDaemonSets   *bool    `json:"ignore_daemonsets,omitempty"`    // skip DaemonSet pods instead of failing, true when unset
+	DeleteEmptyDirData *bool    `json:"delete_emptydir_data,omitempty"` // evict pods with emptyDir volumes, losing their data, true when unset
+	Force              bool     `json:"force,omitempty"`                // also delete pods without a controller
+}
+
+// defaultDrainTimeout bounds waiting for the evictions of a drain
+const defaultDrainTimeout = 5 * time.Minute
+
+// SetDefaults fills in the unset timeout
+func (o *DrainOptions) SetDefaults() {
+	if o.Timeout == 0 {
+		o.Timeout = Duration(defaultDrainTimeout)
+	}
+}
+
+// ValidateFields checks the options. Field paths are relative to prefix.
+func (o *DrainOptions) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	if o.GracePeriod != nil && *o.GracePeriod < 0 {
+		errs.Add(validation.Path(prefix, "grace_period"), validation.CodeOutOfRange, "grace_period must not be negative")
+	}
+	if o.Timeout < 0 {
+		errs.Add(validation.Path(prefix, "timeout"), validation.CodeOutOfRange, "timeout must not be negative")
+	}
+	return errs
+}
+
+// drainPollInterval is how often an eviction blocked by a
+// PodDisruptionBudget is retried and an evicted pod is checked for deletion
+const drainPollInterval = 5 * time.Second
+
+// mirrorPodAnnotation marks the API server copies of static pods, which
+// cannot be evicted
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// CordonNode marks a node unschedulable, or schedulable again when cordon is
+// false. Running pods are left alone.
+func (p *KubeadmProvisioner) CordonNode(ctx context.Context, kubeconfig []byte, host HostSpec, cordon bool) error {
+	client, err := kube.NewClientset(kubeconfig)
+	if err != nil {
+		return err
+	}
+	nodeName, err := kubeNodeName(ctx, client, host)
+	if err != nil {
+		return err
+	}
+
+	verb := "cordon"
+	if !cordon {
+		verb = "uncordon"
+	}
+	if err := setUnschedulable(ctx, client, nodeName, cordon); err != nil {
+		return fmt.Errorf("failed to %s node %s: %w", verb, nodeName, err)
+	}
+	p.emitEvent("info", host.Address, StepCordon, fmt.Sprintf("Node %s %sed", nodeName, verb))
+	return nil
+}
+
+// DrainNode cordons a node and evicts its pods through the eviction API,
+// reporting the evictions as output events. The node stays cordoned until
+// it is uncordoned.
+func (p *KubeadmProvisioner) DrainNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts DrainOptions) error {
+	opts.SetDefaults()
+	client, err := kube.NewClientset(kubeconfig)
+	if err != nil {
+		return err
+	}
+	nodeName, err := kubeNodeName(ctx, client, host)
+	if err != nil {
+		return err
+	}
+
+	p.emitEvent("info", host.Address, StepDrain, "Draining node "+nodeName)
+	if err := setUnschedulable(ctx, client, nodeName, true); err != nil {
+		return fmt.Errorf("failed to cordon node %s: %w", nodeName, err)
+	}
+
+	list, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of node %s: %w", nodeName, err)
+	}
+	pods, err := p.podsToEvict(host, list.Items, opts)
+	if err != nil {
+		return fmt.Errorf("cannot drain node %s: %w", nodeName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout))
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+	for _, pod := range pods {
+		g.Go(func() error {
+			return p.evictPod(ctx, client, host, pod, opts.GracePeriod)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+	}
+	p.emitEvent("info", host.Address, StepDrain, "Node "+nodeName+" drained")
+	return nil
+}
+
+// podsToEvict selects the pods of a node a drain evicts, as kubectl drain
+// does: mirror pods are skipped, and DaemonSet pods, pods without a
+// controller and pods with emptyDir volumes fail the drain unless the
+// options allow them. Finished pods are always evicted.
+func (p *KubeadmProvisioner) podsToEvict(host HostSpec, pods []corev1.Pod, opts DrainOptions) ([]corev1.Pod, error) {
+	ignoreDaemonSets := opts.IgnoreDaemonSets == nil || *opts.IgnoreDaemonSets
+	deleteEmptyDirData := opts.DeleteEmptyDirData == nil || *opts.DeleteEmptyDirData
+
+	var selected []corev1.Pod
+	var blocking []string
+	for _, pod := range pods {
+		name := pod.Namespace + "/" + pod.Name
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		finished := pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+		controller := metav1.GetControllerOf(&pod)
+		switch {
+		case controller != nil && controller.Kind == "DaemonSet" && !finished:
+			if !ignoreDaemonSets {
+				blocking = append(blocking, name+" is managed by a DaemonSet")
+			} else {
+				p.emitEvent("warn", host.Address, StepDrain, "Ignoring DaemonSet pod "+name)
+			}
+			continue
+		case controller == nil && !finished && !opts.Force:
+			blocking = append(blocking, name+" has no controller")
+			continue
+		case hasEmptyDir(pod) && !finished && !deleteEmptyDirData:
+			blocking = append(blocking, name+" uses emptyDir data")
+			continue
+		}
+		selected = append(selected, pod)
+	}
+	if len(blocking) > 0 {
+		return nil, errors.New(strings.Join(blocking, ", "))
+	}
+	return selected, nil
+}
+
+// evictPod evicts a pod, retrying while a PodDisruptionBudget forbids it,
+// and waits until the pod is deleted
+func (p *KubeadmProvisioner) evictPod(ctx context.Context, client kubernetes.Interface, host HostSpec, pod corev1.Pod, gracePeriod *int) error {
+	name := pod.Namespace + "/" + pod.Name
+	eviction := &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{},
+	}
+	if gracePeriod != nil {
+		seconds := int64(*gracePeriod)
+		eviction.DeleteOptions.GracePeriodSeconds = &seconds
+	}
+
+	p.emitEvent("info", host.Address, StepDrain, "Evicting pod "+name)
+	err := wait.PollUntilContextCancel(ctx, drainPollInterval, true, func(ctx context.Context) (bool, error) {
+		err := client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+			return true, nil
+		case apierrors.IsTooManyRequests(err):
+			// A PodDisruptionBudget allows no disruption right now
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to evict pod %s: %w", name, err)
+	}
+
+	err = wait.PollUntilContextCancel(ctx, drainPollInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// A pod of the same name may already be recreated by its controller
+		return current.UID != pod.UID, nil
+	})
+	if err != nil {
+		return fmt.Errorf("pod %s was not deleted: %w", name, err)
+	}
+	p.emitEvent("info", host.Address, StepDrain, "Pod "+name+" evicted")
+	return nil
+}
+
+// hasEmptyDir reports whether a pod mounts an emptyDir volume
+func hasEmptyDir(pod corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setUnschedulable cordons or uncordons a node
+func setUnschedulable(ctx context.Context, client kubernetes.Interface, nodeName string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := client.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// kubeNodeName returns the Kubernetes node name of a host: its hostname, or
+// the node with its address if the spec has none
+func kubeNodeName(ctx context.Context, client kubernetes.Interface, host HostSpec) (string, error) {
+	if host.Hostname != "" {
+		return strings.ToLower(host.Hostname), nil
+	}
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP && address.Address == host.Address {
+				return node.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no node has address %s", host.Address)
+}
+
+// nodeName returns the Kubernetes node name of a host: its hostname, read
+// from the host if the spec has none
+func (p *KubeadmProvisioner) nodeName(ctx context.Context, host HostSpec, step Step) (string, error) {
+	if host.Hostname != "" {
+		return strings.ToLower(host.Hostname), nil
+	}
+	client, err := p.connect(ctx, host, step)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+	stdout, _, err := client.RunCommand(ctx, "hostname")
+	if err != nil {
+		return "", fmt.Errorf("failed to read node name: %w", err)
+	}
+	return strings.ToLower(strings.TrimSpace(stdout)), nil
+}
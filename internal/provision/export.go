@@ -0,0 +1,276 @@
+package provision
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Export formats of a cluster
+const (
+	ExportCAPI    = "capi"    // Cluster API manifests
+	ExportKubeadm = "kubeadm" // kubeadm configuration and host inventory
+)
+
+// ExportFormats lists the supported export formats
+var ExportFormats = []string{ExportCAPI, ExportKubeadm}
+
+// criSockets are the CRI sockets of the container runtimes, by runtime
+var criSockets = map[string]string{
+	"containerd": "unix:///var/run/containerd/containerd.sock",
+	"cri-o":      "unix:///var/run/crio/crio.sock",
+}
+
+// Export renders a cluster in an export format. Only addresses, users and
+// ports of the hosts are exported, never their credentials.
+func Export(spec ClusterSpec, format string) ([]byte, error) {
+	switch format {
+	case ExportCAPI:
+		return exportCAPI(spec)
+	case ExportKubeadm:
+		return exportKubeadm(spec)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// exportCAPI renders a cluster as Cluster API manifests: the Cluster, a
+// KubeadmControlPlane for the control planes and a MachineDeployment for the
+// workers. The hosts exist already, so the machines are bring-your-own-host
+// (BYOH) machines.
+func exportCAPI(spec ClusterSpec) ([]byte, error) {
+	clusterConfig, err := kubeadmClusterConfiguration(spec)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := controlPlaneEndpoint(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	name := spec.Name
+	version := "v" + strings.TrimPrefix(spec.K8sVersion, "v")
+	endpoint := map[string]interface{}{"host": host, "port": port}
+	nodeRegistration := map[string]interface{}{}
+	if socket, ok := criSockets[spec.ContainerRuntime]; ok {
+		nodeRegistration["criSocket"] = socket
+	}
+
+	docs := []map[string]interface{}{
+		{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Cluster",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"clusterNetwork": map[string]interface{}{
+					"pods":     map[string]interface{}{"cidrBlocks": []string{spec.PodNetworkCIDR}},
+					"services": map[string]interface{}{"cidrBlocks": []string{spec.ServiceCIDR}},
+				},
+				"controlPlaneEndpoint": endpoint,
+				"controlPlaneRef":      objectRef("controlplane.cluster.x-k8s.io/v1beta1", "KubeadmControlPlane", name+"-control-plane"),
+				"infrastructureRef":    objectRef("infrastructure.cluster.x-k8s.io/v1beta1", "ByoCluster", name),
+			},
+		},
+		{
+			"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+			"kind":       "ByoCluster",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       map[string]interface{}{"controlPlaneEndpoint": endpoint},
+		},
+		{
+			"apiVersion": "controlplane.cluster.x-k8s.io/v1beta1",
+			"kind":       "KubeadmControlPlane",
+			"metadata":   map[string]interface{}{"name": name + "-control-plane"},
+			"spec": map[string]interface{}{
+				"replicas": len(spec.ControlPlanes),
+				"version":  version,
+				"machineTemplate": map[string]interface{}{
+					"infrastructureRef": objectRef("infrastructure.cluster.x-k8s.io/v1beta1", "ByoMachineTemplate", name+"-control-plane"),
+				},
+				"kubeadmConfigSpec": map[string]interface{}{
+					"clusterConfiguration": clusterConfig,
+					"initConfiguration":    map[string]interface{}{"nodeRegistration": nodeRegistration},
+					"joinConfiguration":    map[string]interface{}{"nodeRegistration": nodeRegistration},
+				},
+			},
+		},
+		byoMachineTemplate(name + "-control-plane"),
+	}
+
+	if len(spec.Workers) > 0 {
+		labels := map[string]interface{}{"cluster.x-k8s.io/deployment-name": name + "-md-0"}
+		docs = append(docs,
+			map[string]interface{}{
+				"apiVersion": "cluster.x-k8s.io/v1beta1",
+				"kind":       "MachineDeployment",
+				"metadata":   map[string]interface{}{"name": name + "-md-0"},
+				"spec": map[string]interface{}{
+					"clusterName": name,
+					"replicas":    len(spec.Workers),
+					"selector":    map[string]interface{}{"matchLabels": labels},
+					"template": map[string]interface{}{
+						"metadata": map[string]interface{}{"labels": labels},
+						"spec": map[string]interface{}{
+							"clusterName": name,
+							"version":     version,
+							"bootstrap": map[string]interface{}{
+								"configRef": objectRef("bootstrap.cluster.x-k8s.io/v1beta1", "KubeadmConfigTemplate", name+"-md-0"),
+							},
+							"infrastructureRef": objectRef("infrastructure.cluster.x-k8s.io/v1beta1", "ByoMachineTemplate", name+"-md-0"),
+						},
+					},
+				},
+			},
+			map[string]interface{}{
+				"apiVersion": "bootstrap.cluster.x-k8s.io/v1beta1",
+				"kind":       "KubeadmConfigTemplate",
+				"metadata":   map[string]interface{}{"name": name + "-md-0"},
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"joinConfiguration": map[string]interface{}{"nodeRegistration": nodeRegistration},
+						},
+					},
+				},
+			},
+			byoMachineTemplate(name+"-md-0"),
+		)
+	}
+
+	return encodeDocuments(docs)
+}
+
+// exportKubeadm renders the kubeadm configuration of a cluster followed by
+// an inventory of its hosts, grouped by role like an Ansible inventory
+func exportKubeadm(spec ClusterSpec) ([]byte, error) {
+	config, err := renderKubeadmConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	inventoryHosts := func(hosts []HostSpec) map[string]interface{} {
+		entries := map[string]interface{}{}
+		for _, host := range hosts {
+			name := host.Hostname
+			if name == "" {
+				name = host.Address
+			}
+			port := host.Port
+			if port == 0 {
+				port = 22
+			}
+			entries[name] = map[string]interface{}{
+				"ansible_host": host.Address,
+				"ansible_user": host.User,
+				"ansible_port": port,
+			}
+		}
+		return map[string]interface{}{"hosts": entries}
+	}
+	inventory, err := encodeDocuments([]map[string]interface{}{{
+		"all": map[string]interface{}{
+			"vars": map[string]interface{}{
+				"cluster_name":      spec.Name,
+				"kube_version":      "v" + strings.TrimPrefix(spec.K8sVersion, "v"),
+				"cni":               spec.CNI,
+				"container_runtime": spec.ContainerRuntime,
+			},
+			"children": map[string]interface{}{
+				"control_plane": inventoryHosts(spec.ControlPlanes),
+				"workers":       inventoryHosts(spec.Workers),
+			},
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(config)
+	buf.WriteString("---\n# Inventory of the cluster hosts\n")
+	buf.Write(inventory)
+	return buf.Bytes(), nil
+}
+
+// kubeadmClusterConfiguration returns the ClusterConfiguration kubeadm init
+// runs with, without its apiVersion and kind
+func kubeadmClusterConfiguration(spec ClusterSpec) (map[string]interface{}, error) {
+	config, err := renderKubeadmConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := parseKubeadmConfig(string(config))
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if doc["kind"] == "ClusterConfiguration" {
+			delete(doc, "apiVersion")
+			delete(doc, "kind")
+			// The KubeadmControlPlane sets the version and the Cluster the
+			// networks and endpoint
+			delete(doc, "kubernetesVersion")
+			delete(doc, "controlPlaneEndpoint")
+			delete(doc, "networking")
+			return doc, nil
+		}
+	}
+	return map[string]interface{}{}, nil
+}
+
+// controlPlaneEndpoint returns the host and port of the API server of a
+// cluster, by default the first control plane on port 6443
+func controlPlaneEndpoint(spec ClusterSpec) (string, int, error) {
+	endpoint := spec.APIServerEndpoint
+	if endpoint == "" {
+		if len(spec.ControlPlanes) == 0 {
+			return "", 0, fmt.Errorf("cluster has no control plane")
+		}
+		return spec.ControlPlanes[0].Address, 6443, nil
+	}
+
+	host, portValue, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint, 6443, nil
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid API server endpoint %q", endpoint)
+	}
+	return host, port, nil
+}
+
+// objectRef returns a reference to another object of the exported cluster
+func objectRef(apiVersion, kind, name string) map[string]interface{} {
+	return map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "name": name}
+}
+
+// byoMachineTemplate returns a ByoMachineTemplate selecting any registered
+// host
+func byoMachineTemplate(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "ByoMachineTemplate",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{}}},
+	}
+}
+
+// encodeDocuments encodes documents as a YAML stream
+func encodeDocuments(docs []map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
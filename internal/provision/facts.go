@@ -0,0 +1,109 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// factsCommand prints the facts of a host, one per line, the block devices
+// as name:GB pairs. It runs as the SSH user, root is not needed.
+const factsCommand = `. /etc/os-release && echo "$ID" && echo "${ID_LIKE:-}" && echo "${VERSION_ID:-}" && uname -r && uname -m && nproc && ` +
+	`awk '/^MemTotal:/ {print int($2 / 1024)}' /proc/meminfo && df -Pk / | awk 'NR == 2 {print int($2 / 1048576)}' && hostname && ` +
+	`{ lsblk -dbn -o NAME,SIZE,TYPE 2>/dev/null | awk '$3 == "disk" {printf "%s:%d ", $1, $2 / 1073741824}'; echo; }`
+
+// factsLines is the number of lines factsCommand prints
+const factsLines = 10
+
+// Minimum resources checked by the kubeadm preflight checks
+const (
+	preflightMinCPUs     = 2
+	preflightMinMemoryMB = 1700
+)
+
+// HostFacts are the operating system and hardware of a host
+type HostFacts struct {
+	Hostname  string
+	OS        string   // ID of /etc/os-release, e.g. ubuntu
+	OSLike    []string // ID_LIKE of /etc/os-release
+	OSVersion string   // VERSION_ID of /etc/os-release, e.g. 22.04
+	Kernel    string
+	Arch      string // amd64 or arm64
+	CPUs      int
+	MemoryMB  int
+	DiskGB    int            // size of the root file system
+	Disks     map[string]int // size in GB of the block devices by name
+}
+
+// Preflight returns why the host cannot run a Kubernetes node, nothing if
+// it can: an operating system or architecture KubeForge cannot prepare, or
+// less CPUs or memory than kubeadm requires
+func (f HostFacts) Preflight() []string {
+	var failed []string
+	if _, err := NewPlatform(f.OS, f.OSLike, f.Arch); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if f.CPUs < preflightMinCPUs {
+		failed = append(failed, fmt.Sprintf("%d CPUs, at least %d are required", f.CPUs, preflightMinCPUs))
+	}
+	if f.MemoryMB < preflightMinMemoryMB {
+		failed = append(failed, fmt.Sprintf("%d MB of memory, at least %d MB are required", f.MemoryMB, preflightMinMemoryMB))
+	}
+	return failed
+}
+
+// CollectFacts connects to a host and reads its facts
+func CollectFacts(ctx context.Context, host HostSpec) (HostFacts, error) {
+	client, err := NewSSHClient(host)
+	if err != nil {
+		return HostFacts{}, err
+	}
+	defer client.Close()
+	return client.CollectFacts(ctx)
+}
+
+// CollectFacts reads the operating system and hardware of the host
+func (c *SSHClient) CollectFacts(ctx context.Context) (HostFacts, error) {
+	stdout, stderr, err := c.runAsUser(ctx, factsCommand, nil)
+	if err != nil {
+		return HostFacts{}, fmt.Errorf("failed to collect host facts: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != factsLines {
+		return HostFacts{}, fmt.Errorf("failed to collect host facts: unexpected output %q", stdout)
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	facts := HostFacts{
+		OS:        lines[0],
+		OSLike:    strings.Fields(lines[1]),
+		OSVersion: lines[2],
+		Kernel:    lines[3],
+		Arch:      lines[4],
+		Hostname:  lines[8],
+		Disks:     map[string]int{},
+	}
+	switch facts.Arch {
+	case "x86_64":
+		facts.Arch = "amd64"
+	case "aarch64":
+		facts.Arch = "arm64"
+	}
+	for i, field := range []*int{&facts.CPUs, &facts.MemoryMB, &facts.DiskGB} {
+		value, err := strconv.Atoi(lines[5+i])
+		if err != nil {
+			return HostFacts{}, fmt.Errorf("failed to collect host facts: unexpected output %q", stdout)
+		}
+		*field = value
+	}
+	for _, disk := range strings.Fields(lines[9]) {
+		name, size, _ := strings.Cut(disk, ":")
+		if gb, err := strconv.Atoi(size); err == nil {
+			facts.Disks[name] = gb
+		}
+	}
+	return facts, nil
+}
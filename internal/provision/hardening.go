@@ -0,0 +1,287 @@
+package provision
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Hardening profiles of a cluster
+const (
+	// HardeningBaseline turns off the kubelet read-only port, restricts the
+	// TLS cipher suites and tightens the permissions of /etc/kubernetes
+	HardeningBaseline = "baseline"
+	// HardeningCIS also disables anonymous requests to the API server except
+	// to its health checks, enables audit logging and encrypts secrets at
+	// rest, following the CIS Kubernetes Benchmark
+	HardeningCIS = "cis"
+)
+
+// HardeningProfiles lists the supported hardening profiles
+var HardeningProfiles = []string{HardeningBaseline, HardeningCIS}
+
+// hardeningMinMinor is the oldest minor release the CIS profile supports:
+// the API server only allows anonymous requests to its health checks alone
+// from 1.32
+const hardeningMinMinor = 32
+
+// Files of the CIS profile, shared by every control plane
+const (
+	hardeningDir             = "/etc/kubernetes/hardening"
+	auditPolicyPath          = hardeningDir + "/audit-policy.yaml"
+	encryptionConfigPath     = hardeningDir + "/encryption-config.yaml"
+	authenticationConfigPath = hardeningDir + "/authentication-config.yaml"
+	auditLogDir              = "/var/log/kubernetes/audit"
+)
+
+// strongCipherSuites are the TLS cipher suites the API server and kubelets
+// accept with a hardening profile
+var strongCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// auditPolicy logs the metadata of every request, and the body of changes
+// except to secrets and config maps, which may hold credentials
+const auditPolicy = `apiVersion: audit.k8s.io/v1
+kind: Policy
+omitStages: [RequestReceived]
+rules:
+  - level: None
+    nonResourceURLs: ["/healthz*", "/livez*", "/readyz*", "/version"]
+  - level: None
+    users: ["system:kube-proxy"]
+    verbs: ["watch"]
+  - level: Metadata
+    resources:
+      - group: ""
+        resources: ["secrets", "configmaps"]
+      - group: authentication.k8s.io
+        resources: ["tokenreviews"]
+  - level: Request
+    verbs: ["create", "update", "patch", "delete", "deletecollection"]
+  - level: Metadata
+`
+
+// authenticationConfig allows anonymous requests to the health checks
+// only, which the kubelet probes the API server with
+const authenticationConfig = `apiVersion: apiserver.config.k8s.io/v1beta1
+kind: AuthenticationConfiguration
+anonymous:
+  enabled: true
+  conditions:
+    - path: /livez
+    - path: /readyz
+    - path: /healthz
+`
+
+// ValidateHardeningProfile checks that a cluster of a version can be
+// hardened with a profile
+func ValidateHardeningProfile(version, profile string) error {
+	if profile != HardeningCIS {
+		return nil
+	}
+	if v, err := ParseVersion(version); err == nil && v.Minor < hardeningMinMinor {
+		return fmt.Errorf("the cis hardening profile needs k8s 1.%d or later", hardeningMinMinor)
+	}
+	return nil
+}
+
+// applyHardening adds the settings of the hardening profile of a spec to
+// the ClusterConfiguration and KubeletConfiguration of a kubeadm
+// configuration, leaving the settings it sets itself
+func applyHardening(docs []map[string]interface{}, cluster map[string]interface{}, profile string) []map[string]interface{} {
+	if profile == "" {
+		return docs
+	}
+	ciphers := strings.Join(strongCipherSuites, ",")
+
+	docs, kubelet := kubeletConfiguration(docs)
+	setDefault(kubelet, "readOnlyPort", 0)
+	setDefault(kubelet, "tlsCipherSuites", strongCipherSuites)
+	authentication := mapping(kubelet, "authentication")
+	setDefault(mapping(authentication, "anonymous"), "enabled", false)
+
+	// kubeadm.k8s.io/v1beta4 lists extra arguments, older versions map them
+	listArgs := strings.HasSuffix(fmt.Sprint(cluster["apiVersion"]), "/v1beta4")
+	apiServer := mapping(cluster, "apiServer")
+	setExtraArg(apiServer, listArgs, "tls-cipher-suites", ciphers)
+	setExtraArg(apiServer, listArgs, "tls-min-version", "VersionTLS12")
+	if profile != HardeningCIS {
+		return docs
+	}
+
+	setExtraArg(apiServer, listArgs, "authentication-config", authenticationConfigPath)
+	setExtraArg(apiServer, listArgs, "profiling", "false")
+	setExtraArg(apiServer, listArgs, "audit-policy-file", auditPolicyPath)
+	setExtraArg(apiServer, listArgs, "audit-log-path", path.Join(auditLogDir, "audit.log"))
+	setExtraArg(apiServer, listArgs, "audit-log-maxage", "30")
+	setExtraArg(apiServer, listArgs, "audit-log-maxbackup", "10")
+	setExtraArg(apiServer, listArgs, "audit-log-maxsize", "100")
+	setExtraArg(apiServer, listArgs, "encryption-provider-config", encryptionConfigPath)
+	addExtraVolume(apiServer, "kubeforge-hardening", hardeningDir, true)
+	addExtraVolume(apiServer, "kubeforge-audit-log", auditLogDir, false)
+	setExtraArg(mapping(cluster, "controllerManager"), listArgs, "profiling", "false")
+	setExtraArg(mapping(cluster, "scheduler"), listArgs, "profiling", "false")
+	return docs
+}
+
+// mapping returns the YAML mapping under a key, adding an empty one when
+// the key is not set
+func mapping(m map[string]interface{}, key string) map[string]interface{} {
+	child, _ := m[key].(map[string]interface{})
+	if child == nil {
+		child = map[string]interface{}{}
+		m[key] = child
+	}
+	return child
+}
+
+// setExtraArg sets an extra argument of a control plane component unless
+// it is set already
+func setExtraArg(component map[string]interface{}, list bool, name, value string) {
+	if !list {
+		setDefault(mapping(component, "extraArgs"), name, value)
+		return
+	}
+	args, _ := component["extraArgs"].([]interface{})
+	for _, arg := range args {
+		if a, ok := arg.(map[string]interface{}); ok && a["name"] == name {
+			return
+		}
+	}
+	component["extraArgs"] = append(args, map[string]interface{}{"name": name, "value": value})
+}
+
+// addExtraVolume mounts a host directory into a control plane component
+// unless a volume of the same name is mounted already
+func addExtraVolume(component map[string]interface{}, name, hostPath string, readOnly bool) {
+	volumes, _ := component["extraVolumes"].([]interface{})
+	for _, volume := range volumes {
+		if v, ok := volume.(map[string]interface{}); ok && v["name"] == name {
+			return
+		}
+	}
+	component["extraVolumes"] = append(volumes, map[string]interface{}{
+		"name":      name,
+		"hostPath":  hostPath,
+		"mountPath": hostPath,
+		"readOnly":  readOnly,
+		"pathType":  "DirectoryOrCreate",
+	})
+}
+
+// encryptionConfig returns an EncryptionConfiguration encrypting secrets
+// with a new key
+func encryptionConfig() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - secretbox:
+          keys:
+            - name: key1
+              secret: %s
+      - identity: {}
+`, base64.StdEncoding.EncodeToString(key))), nil
+}
+
+// installHardeningFiles writes the files the API server reads with the CIS
+// profile to the first control plane. An encryption configuration left by
+// an earlier attempt is kept, so secrets written with its key stay readable.
+func installHardeningFiles(ctx context.Context, client *SSHClient) error {
+	for file, content := range map[string]string{auditPolicyPath: auditPolicy, authenticationConfigPath: authenticationConfig} {
+		if err := client.UploadContent(ctx, []byte(content), file, 0600); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file, err)
+		}
+	}
+	if _, _, err := client.RunCommand(ctx, "test -s "+encryptionConfigPath); err == nil {
+		return nil
+	}
+	config, err := encryptionConfig()
+	if err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if err := client.UploadContent(ctx, config, encryptionConfigPath, 0600); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", encryptionConfigPath, err)
+	}
+	return nil
+}
+
+// CopyHardeningFiles copies the files of the CIS profile from a control
+// plane to a control plane about to join, which must encrypt secrets with
+// the same key
+func CopyHardeningFiles(ctx context.Context, from, to HostSpec) error {
+	source, err := NewSSHClient(from)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", from.Address, err)
+	}
+	defer source.Close()
+	archive, stderr, err := source.RunCommand(ctx, "tar -C /etc/kubernetes -czf - hardening | base64 -w0")
+	if err != nil {
+		return fmt.Errorf("failed to read hardening files of %s: %s: %w", from.Address, strings.TrimSpace(stderr), err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(archive))
+	if err != nil {
+		return fmt.Errorf("failed to read hardening files of %s: %w", from.Address, err)
+	}
+
+	target, err := NewSSHClient(to)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", to.Address, err)
+	}
+	defer target.Close()
+	const staging = "/var/tmp/kubeforge-hardening.tar.gz"
+	if err := target.UploadContent(ctx, data, staging, 0600); err != nil {
+		return fmt.Errorf("failed to upload hardening files: %w", err)
+	}
+	if _, stderr, err := target.RunCommand(ctx, "mkdir -p /etc/kubernetes && tar -C /etc/kubernetes -xzf "+staging+" && rm -f "+staging); err != nil {
+		return fmt.Errorf("failed to install hardening files: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// securePermissionsCommand makes the configuration, manifests and keys of
+// a node readable by root only, then lists files still readable by others
+const securePermissionsCommand = `chown -R root:root /etc/kubernetes
+find /etc/kubernetes -maxdepth 1 -name '*.conf' -type f -exec chmod 600 {} +
+find /etc/kubernetes/manifests /etc/kubernetes/hardening -type f -exec chmod 600 {} + 2>/dev/null
+find /etc/kubernetes/pki -name '*.key' -type f -exec chmod 600 {} + 2>/dev/null
+find /etc/kubernetes/pki -name '*.crt' -type f -exec chmod 644 {} + 2>/dev/null
+chmod 600 /var/lib/kubelet/config.yaml 2>/dev/null
+find /etc/kubernetes \( -name '*.conf' -o -name '*.key' -o -path '*/manifests/*' -o -path '*/hardening/*' \) -type f \( -perm /077 -o ! -user root \) 2>/dev/null
+true`
+
+// SecureNodeFiles restricts the permissions of the Kubernetes files of a
+// node and verifies them
+func SecureNodeFiles(ctx context.Context, host HostSpec) error {
+	client, err := NewSSHClient(host)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+	return secureNodeFiles(ctx, client)
+}
+
+func secureNodeFiles(ctx context.Context, client *SSHClient) error {
+	stdout, stderr, err := client.RunCommand(ctx, securePermissionsCommand)
+	if err != nil {
+		return fmt.Errorf("failed to set file permissions: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	if open := strings.Fields(stdout); len(open) > 0 {
+		return fmt.Errorf("files readable by other users than root: %s", strings.Join(open, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"kubeforge/internal/validation"
+)
+
+// Provisioning stages hooks run at
+const (
+	HookPrePrepare    = "pre-prepare"    // on each host before it is prepared
+	HookPostBootstrap = "post-bootstrap" // on the first control plane after kubeadm init
+	HookPostJoin      = "post-join"      // on each host after it joined the cluster
+	HookPostProvision = "post-provision" // on every host once the addons are installed
+)
+
+// HookStages lists the stages hooks can run at, in order
+var HookStages = []string{HookPrePrepare, HookPostBootstrap, HookPostJoin, HookPostProvision}
+
+// DefaultHookTimeout bounds a hook without a timeout
+const DefaultHookTimeout = 5 * time.Minute
+
+// maxHookTimeout is the longest timeout of a hook
+const maxHookTimeout = time.Hour
+
+// maxHookOutput is how much of the output of a hook is kept
+const maxHookOutput = 4096
+
+// hookNamePattern restricts hook names to what is safe in a file name
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// HookSpec is a site specific step of provisioning: a script run as root on
+// the hosts of its stage over SSH, or a webhook the server posts each host
+// of the stage to
+type HookSpec struct {
+	Name            string   `json:"name"`
+	Stage           string   `json:"stage"`                       // pre-prepare, post-bootstrap, post-join, post-provision
+	Script          string   `json:"script,omitempty"`            // shell script, with KUBEFORGE_* variables describing the host
+	Webhook         string   `json:"webhook,omitempty"`           // http or https URL
+	Roles           []string `json:"roles,omitempty"`             // control-plane, worker; default: both
+	Timeout         string   `json:"timeout,omitempty"`           // per host, default: 5m
+	ContinueOnError bool     `json:"continue_on_error,omitempty"` // report a failure instead of failing provisioning
+}
+
+// HookTarget is the host a hook runs for
+type HookTarget struct {
+	Cluster string `json:"cluster"`
+	Stage   string `json:"stage"`
+	Hook    string `json:"hook"`
+	Host    string `json:"hostname"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+}
+
+// ValidateHooks checks the hooks of a spec
+func ValidateHooks(field string, hooks []HookSpec) validation.Errors {
+	var errs validation.Errors
+	names := map[string]bool{}
+	for i, hook := range hooks {
+		path := validation.Index(field, i)
+		if !hookNamePattern.MatchString(hook.Name) {
+			errs.Add(validation.Path(path, "name"), validation.CodeInvalid, "name must be lowercase letters, digits and dashes")
+		} else if names[hook.Name] {
+			errs.Add(validation.Path(path, "name"), validation.CodeDuplicate, fmt.Sprintf("hook %s is defined twice", hook.Name))
+		}
+		names[hook.Name] = true
+
+		if !validation.OneOf(hook.Stage, HookStages) {
+			errs.Add(validation.Path(path, "stage"), validation.CodeUnsupported, fmt.Sprintf("unsupported stage %q, expected one of %s", hook.Stage, strings.Join(HookStages, ", ")))
+		}
+		switch {
+		case hook.Script == "" && hook.Webhook == "":
+			errs.Add(path, validation.CodeRequired, "a script or a webhook is required")
+		case hook.Script != "" && hook.Webhook != "":
+			errs.Add(validation.Path(path, "webhook"), validation.CodeInvalid, "a hook runs a script or calls a webhook, not both")
+		case hook.Webhook != "":
+			if u, err := neturl.Parse(hook.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs.Add(validation.Path(path, "webhook"), validation.CodeInvalid, "webhook must be an http or https URL")
+			}
+		}
+		for j, role := range hook.Roles {
+			if role != "control-plane" && role != "worker" {
+				errs.Add(validation.Index(validation.Path(path, "roles"), j), validation.CodeUnsupported, fmt.Sprintf("unsupported role %q, expected control-plane or worker", role))
+			}
+		}
+		if hook.Timeout != "" {
+			if timeout, err := time.ParseDuration(hook.Timeout); err != nil {
+				errs.Add(validation.Path(path, "timeout"), validation.CodeInvalid, "timeout must be a duration such as 5m")
+			} else if timeout <= 0 || timeout > maxHookTimeout {
+				errs.Add(validation.Path(path, "timeout"), validation.CodeOutOfRange, "timeout must be positive and at most 1h")
+			}
+		}
+	}
+	return errs
+}
+
+// RunsOn reports whether a hook runs on hosts of a role
+func (h HookSpec) RunsOn(role string) bool {
+	return len(h.Roles) == 0 || validation.OneOf(role, h.Roles)
+}
+
+// TimeoutDuration returns how long the hook may run on one host
+func (h HookSpec) TimeoutDuration() time.Duration {
+	if timeout, err := time.ParseDuration(h.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return DefaultHookTimeout
+}
+
+// RunHook runs a hook for a host and returns its output, at most the last
+// 4 KiB of it
+func RunHook(ctx context.Context, hook HookSpec, target HookTarget, host HostSpec) (string, error) {
+	if hook.Webhook != "" {
+		return callHookWebhook(ctx, hook.Webhook, target)
+	}
+
+	client, err := NewSSHClient(host)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	var script strings.Builder
+	for _, env := range [][2]string{
+		{"KUBEFORGE_CLUSTER", target.Cluster},
+		{"KUBEFORGE_STAGE", target.Stage},
+		{"KUBEFORGE_HOSTNAME", target.Host},
+		{"KUBEFORGE_ADDRESS", target.Address},
+		{"KUBEFORGE_ROLE", target.Role},
+	} {
+		fmt.Fprintf(&script, "export %s=%s\n", env[0], ShellQuote(env[1]))
+	}
+	script.WriteString(hook.Script)
+
+	command, err := client.UploadScript(ctx, "hook-"+hook.Name, []byte(script.String()))
+	if err != nil {
+		return "", err
+	}
+	stdout, stderr, err := client.RunCommand(ctx, command)
+	output := hookOutput(strings.TrimSpace(stdout + "\n" + stderr))
+	if err != nil {
+		return output, fmt.Errorf("hook script failed: %w", err)
+	}
+	return output, nil
+}
+
+// callHookWebhook posts a hook target to a webhook and returns its response.
+// The URL is left out of errors since webhook URLs may contain credentials.
+func callHookWebhook(ctx context.Context, url string, target HookTarget) (string, error) {
+	body, err := json.Marshal(target)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	output := strings.TrimSpace(string(response))
+	if resp.StatusCode >= 300 {
+		return output, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return output, nil
+}
+
+// hookOutput keeps the end of the output of a hook, where failures are
+// reported
+func hookOutput(output string) string {
+	if len(output) <= maxHookOutput {
+		return output
+	}
+	return "..." + output[len(output)-maxHookOutput:]
+}
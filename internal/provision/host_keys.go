@@ -0,0 +1,75 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUntrustedHostKey is returned when a host presents a key other than the
+// one trusted for it, e.g. after it was reinstalled or when the connection
+// is intercepted
+var ErrUntrustedHostKey = errors.New("host key is not trusted")
+
+// HostKey is a public key presented by an SSH server
+type HostKey struct {
+	Type        string // e.g. ssh-ed25519
+	Fingerprint string // SHA256 fingerprint as printed by ssh-keygen -l
+	PublicKey   string // authorized_keys format
+}
+
+// HostKeyStore keeps the host keys trusted for SSH connections
+type HostKeyStore interface {
+	// CheckHostKey reports whether key is trusted for host (address:port).
+	// The key of a host seen for the first time is trusted, a key not
+	// matching the trusted ones is recorded for review.
+	CheckHostKey(host string, key HostKey) (bool, error)
+}
+
+var (
+	hostKeysMu sync.RWMutex
+	hostKeys   HostKeyStore
+)
+
+// SetHostKeyStore configures where host keys are verified. Without a store
+// only hosts skipping verification can be connected to.
+func SetHostKeyStore(store HostKeyStore) {
+	hostKeysMu.Lock()
+	hostKeys = store
+	hostKeysMu.Unlock()
+}
+
+// hostKeyCallback verifies the key presented by a host against the store,
+// unless the host skips verification
+func hostKeyCallback(host HostSpec) (ssh.HostKeyCallback, error) {
+	if host.InsecureSkipHostKeyCheck {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	hostKeysMu.RLock()
+	store := hostKeys
+	hostKeysMu.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("host key verification is not configured for host %s", host.Address)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := HostKey{
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		}
+		trusted, err := store.CheckHostKey(hostname, presented)
+		if err != nil {
+			return fmt.Errorf("failed to verify host key of %s: %w", hostname, err)
+		}
+		if !trusted {
+			return fmt.Errorf("%w: %s presented %s key %s, approve it to connect", ErrUntrustedHostKey, hostname, presented.Type, presented.Fingerprint)
+		}
+		return nil
+	}, nil
+}
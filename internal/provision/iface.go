@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 )
 
 // IProvisioner defines the interface for Kubernetes cluster provisioning
@@ -29,6 +31,12 @@ type IProvisioner interface {
 	// InstallCNI installs the CNI plugin (Calico, Flannel, Weave, Cilium)
 	InstallCNI(ctx context.Context, kubeconfig []byte, cni string, controlPlane HostSpec) error
 
+	// CheckNetwork tests the pod network once the nodes joined
+	// - Pod-to-pod connections across nodes, DNS and NodePort services
+	// - Returns the checks and, when one failed, an ErrNetworkBroken
+	//   diagnosis
+	CheckNetwork(ctx context.Context, controlPlane HostSpec) ([]NetworkCheck, error)
+
 	// JoinControlPlane joins additional control plane nodes to the cluster
 	// - Requires certificate key from bootstrap
 	JoinControlPlane(ctx context.Context, host HostSpec, joinCommand string, certificateKey string) error
@@ -36,6 +44,11 @@ type IProvisioner interface {
 	// JoinWorker joins a worker node to the cluster
 	JoinWorker(ctx context.Context, host HostSpec, joinCommand string) error
 
+	// VerifyCluster smoke tests a provisioned cluster
+	// - Deploys a test deployment and service and checks DNS from a pod
+	// - Checks that the API server and control plane components are ready
+	VerifyCluster(ctx context.Context, controlPlane HostSpec) ([]SmokeCheck, error)
+
 	// GetClusterInfo retrieves current cluster information using kubectl
 	GetClusterInfo(ctx context.Context, kubeconfig []byte) (*ClusterInfo, error)
 
@@ -45,12 +58,53 @@ type IProvisioner interface {
 	DestroyCluster(ctx context.Context, spec ClusterSpec) error
 
 	// RemoveNode removes a single node from the cluster
-	// - Drains the node
+	// - Drains the node and deletes it from the control plane
 	// - Runs kubeadm reset
-	RemoveNode(ctx context.Context, host HostSpec, kubeconfig []byte) error
+	RemoveNode(ctx context.Context, host HostSpec, controlPlane HostSpec) error
+
+	// CordonNode marks a node unschedulable, or schedulable again when
+	// cordon is false, through the API server of the kubeconfig
+	CordonNode(ctx context.Context, kubeconfig []byte, host HostSpec, cordon bool) error
+
+	// DrainNode cordons a node and evicts its pods through the API server
+	// of the kubeconfig
+	// - Evictions respect PodDisruptionBudgets
+	// - The node stays cordoned afterwards
+	DrainNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts DrainOptions) error
 
-	// GenerateJoinToken generates a new join token for adding nodes
-	GenerateJoinToken(ctx context.Context, kubeconfig []byte, controlPlane bool) (string, error)
+	// PatchNode applies operating system updates to a node
+	// - Drains the node
+	// - Upgrades its packages, Kubernetes packages excepted, and reboots it
+	// - Uncordons it once it is Ready again
+	PatchNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts PatchOptions) error
+
+	// CreateCredential creates a service account bound to a cluster role
+	// and returns a token of it valid for ttl
+	CreateCredential(ctx context.Context, controlPlane HostSpec, name, clusterRole string, ttl time.Duration) (string, error)
+
+	// RevokeCredential deletes the service account of a credential,
+	// invalidating its tokens
+	RevokeCredential(ctx context.Context, controlPlane HostSpec, name string) error
+
+	// GenerateJoinToken creates a join token on the control plane and returns
+	// the kubeadm join command of a worker using it
+	GenerateJoinToken(ctx context.Context, controlPlane HostSpec) (string, error)
+
+	// UploadCertificates uploads the control plane certificates for joining
+	// control planes again and returns the new certificate key
+	UploadCertificates(ctx context.Context, controlPlane HostSpec) (string, error)
+
+	// RenewCertificates renews the certificates of a control plane node
+	// - Runs kubeadm certs renew all and restarts the control plane
+	//   components
+	// - Returns the renewed admin kubeconfig
+	RenewCertificates(ctx context.Context, host HostSpec) ([]byte, error)
+
+	// UpgradeNode upgrades Kubernetes on a single node
+	// - Upgrades the cluster control plane when first is true, the node
+	//   configuration otherwise
+	// - Upgrades kubelet and kubectl and restarts kubelet
+	UpgradeNode(ctx context.Context, host HostSpec, k8sVersion string, first bool) error
 }
 
 // ClusterInfo contains runtime information about a cluster
@@ -66,6 +120,12 @@ type ClusterInfo struct {
 // EventCallback is called for each provisioning event (for real-time streaming to UI)
 type EventCallback func(event ProvisionEvent)
 
+// EventEmitter is implemented by provisioners that report the progress of
+// each host and step as events
+type EventEmitter interface {
+	SetEventCallback(callback EventCallback)
+}
+
 // Common errors
 var (
 	ErrNotImplemented      = errors.New("not implemented")
@@ -89,6 +149,9 @@ type ProvisionerFactory func(config map[string]interface{}) (IProvisioner, error
 
 var provisionerRegistry = make(map[string]ProvisionerFactory)
 
+// DefaultProvisioner provisions the clusters that do not name a provisioner
+const DefaultProvisioner = "kubeadm"
+
 // RegisterProvisioner registers a new provisioner factory
 func RegisterProvisioner(name string, factory ProvisionerFactory) {
 	provisionerRegistry[name] = factory
@@ -103,11 +166,12 @@ func GetProvisioner(name string, config map[string]interface{}) (IProvisioner, e
 	return factory(config)
 }
 
-// ListProvisioners returns all registered provisioner names
+// ListProvisioners returns all registered provisioner names, sorted
 func ListProvisioners() []string {
 	names := make([]string, 0, len(provisionerRegistry))
 	for name := range provisionerRegistry {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
@@ -5,11 +5,23 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"kubeforge/internal/bmc"
+	"kubeforge/internal/tracing"
 )
 
+// kubeadmConfigPath is where the kubeadm configuration of a cluster is
+// uploaded on its first control plane
+const kubeadmConfigPath = "/etc/kubernetes/kubeadm-config.yaml"
+
+// adminKubeconfigPath is the kubeconfig kubeadm writes on control planes
+const adminKubeconfigPath = "/etc/kubernetes/admin.conf"
+
 // KubeadmProvisioner implements IProvisioner for kubeadm-based clusters
 type KubeadmProvisioner struct {
-	eventCallback EventCallback
+	eventCallback  EventCallback
+	outputCallback OutputCallback
 }
 
 // NewKubeadmProvisioner creates a new kubeadm provisioner
@@ -21,6 +33,17 @@ func init() {
 	RegisterProvisioner("kubeadm", NewKubeadmProvisioner)
 }
 
+// SetEventCallback reports the progress of each host and step to callback
+func (p *KubeadmProvisioner) SetEventCallback(callback EventCallback) {
+	p.eventCallback = callback
+}
+
+// SetOutputCallback streams the output of package installs, kubeadm init,
+// join and upgrade commands to callback
+func (p *KubeadmProvisioner) SetOutputCallback(callback OutputCallback) {
+	p.outputCallback = callback
+}
+
 // Name returns the provisioner name
 func (p *KubeadmProvisioner) Name() string {
 	return "kubeadm"
@@ -31,187 +54,159 @@ func (p *KubeadmProvisioner) ValidateSpec(spec *ClusterSpec) error {
 	return spec.Validate()
 }
 
-// PrepareHosts prepares all hosts for Kubernetes installation
+// PrepareHosts prepares all hosts for Kubernetes installation in parallel
 func (p *KubeadmProvisioner) PrepareHosts(ctx context.Context, hosts []HostSpec, runtime string, k8sVersion string) error {
-	for _, host := range hosts {
+	return ForEachHost(ctx, hosts, func(ctx context.Context, host HostSpec) error {
 		if err := p.prepareHost(ctx, host, runtime, k8sVersion); err != nil {
 			return fmt.Errorf("failed to prepare host %s: %w", host.Address, err)
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 // prepareHost prepares a single host
-func (p *KubeadmProvisioner) prepareHost(ctx context.Context, host HostSpec, runtime string, k8sVersion string) error {
-	client, err := NewSSHClient(host)
+func (p *KubeadmProvisioner) prepareHost(ctx context.Context, host HostSpec, runtime string, k8sVersion string) (err error) {
+	release, err := acquireHostSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p.startStep(host.Address, StepPrepare, "Preparing host")
+	defer p.failStep(host.Address, StepPrepare, &err)
+
+	client, err := p.connect(ctx, host, StepPrepare)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer client.Close()
 
-	p.emitEvent("info", host.Address, "prepare", "Connected to host")
+	p.emitEvent("info", host.Address, StepPrepare, "Connected to host")
 
 	// Test connection
 	if err := client.TestConnection(ctx); err != nil {
 		return fmt.Errorf("connection test failed: %w", err)
 	}
 
+	// Preflight: provisioning runs as root
+	if err := client.CheckRoot(ctx); err != nil {
+		return err
+	}
+
+	// Scripts are rendered for the operating system of the host
+	platform, err := client.DetectPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	params, err := newScriptParams(platform, k8sVersion)
+	if err != nil {
+		return err
+	}
+
 	// Get host info
 	info, _ := client.GetHostInfo(ctx)
 	if info["swap_enabled"] == "true" {
-		p.emitEvent("info", host.Address, "prepare", "Disabling swap")
-		if _, _, err := client.RunCommand(ctx, "swapoff -a && sed -i '/ swap / s/^/#/' /etc/fstab"); err != nil {
-			return fmt.Errorf("failed to disable swap: %w", err)
+		p.emitEvent("info", host.Address, StepPrepare, "Disabling swap")
+		if _, stderr, err := p.runScript(ctx, client, host.Address, StepPrepare, "disable swap", "disable-swap", params); err != nil {
+			return fmt.Errorf("failed to disable swap: %s: %w", stderr, err)
 		}
 	}
 
 	// Load kernel modules
-	p.emitEvent("info", host.Address, "prepare", "Loading kernel modules")
-	loadModules := `
-cat <<EOF | tee /etc/modules-load.d/k8s.conf
-overlay
-br_netfilter
-EOF
-modprobe overlay
-modprobe br_netfilter
-`
-	if _, _, err := client.RunCommand(ctx, loadModules); err != nil {
-		return fmt.Errorf("failed to load kernel modules: %w", err)
+	p.emitEvent("info", host.Address, StepPrepare, "Loading kernel modules")
+	if _, stderr, err := p.runScript(ctx, client, host.Address, StepPrepare, "load kernel modules", "kernel-modules", params); err != nil {
+		return fmt.Errorf("failed to load kernel modules: %s: %w", stderr, err)
 	}
 
 	// Configure sysctl
-	p.emitEvent("info", host.Address, "prepare", "Configuring sysctl parameters")
-	sysctl := `
-cat <<EOF | tee /etc/sysctl.d/k8s.conf
-net.bridge.bridge-nf-call-iptables  = 1
-net.bridge.bridge-nf-call-ip6tables = 1
-net.ipv4.ip_forward                 = 1
-EOF
-sysctl --system
-`
-	if _, _, err := client.RunCommand(ctx, sysctl); err != nil {
-		return fmt.Errorf("failed to configure sysctl: %w", err)
+	p.emitEvent("info", host.Address, StepPrepare, "Configuring sysctl parameters")
+	if _, stderr, err := p.runScript(ctx, client, host.Address, StepPrepare, "configure sysctl", "sysctl", params); err != nil {
+		return fmt.Errorf("failed to configure sysctl: %s: %w", stderr, err)
 	}
 
 	// Install container runtime
-	if err := p.installContainerRuntime(ctx, client, host, runtime); err != nil {
+	if err := p.installContainerRuntime(ctx, client, host, runtime, params); err != nil {
 		return fmt.Errorf("failed to install container runtime: %w", err)
 	}
 
 	// Install kubeadm, kubelet, kubectl
-	if err := p.installKubernetesTools(ctx, client, host, k8sVersion); err != nil {
+	if err := p.installKubernetesTools(ctx, client, host, params); err != nil {
 		return fmt.Errorf("failed to install kubernetes tools: %w", err)
 	}
 
-	p.emitEvent("info", host.Address, "prepare", "Host prepared successfully")
+	p.finishStep(host.Address, StepPrepare, "Host prepared successfully")
 	return nil
 }
 
 // installContainerRuntime installs the specified container runtime
-func (p *KubeadmProvisioner) installContainerRuntime(ctx context.Context, client *SSHClient, host HostSpec, runtime string) error {
-	p.emitEvent("info", host.Address, "install-runtime", fmt.Sprintf("Installing %s", runtime))
+func (p *KubeadmProvisioner) installContainerRuntime(ctx context.Context, client *SSHClient, host HostSpec, runtime string, params ScriptParams) (err error) {
+	p.startStep(host.Address, StepInstallRuntime, fmt.Sprintf("Installing %s", runtime))
+	defer p.failStep(host.Address, StepInstallRuntime, &err)
 
 	switch runtime {
 	case "containerd":
-		return p.installContainerd(ctx, client, host)
+		return p.installContainerd(ctx, client, host, params)
 	case "cri-o":
-		return p.installCRIO(ctx, client, host)
+		return p.installCRIO(ctx, client, host, params)
 	default:
 		return fmt.Errorf("unsupported runtime: %s", runtime)
 	}
 }
 
 // installContainerd installs containerd runtime
-func (p *KubeadmProvisioner) installContainerd(ctx context.Context, client *SSHClient, host HostSpec) error {
-	script := `
-# Install dependencies
-apt-get update
-apt-get install -y apt-transport-https ca-certificates curl gnupg lsb-release
-
-# Add Docker's official GPG key
-mkdir -p /etc/apt/keyrings
-curl -fsSL https://download.docker.com/linux/ubuntu/gpg | gpg --dearmor -o /etc/apt/keyrings/docker.gpg
-
-# Set up the repository
-echo "deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.gpg] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable" | tee /etc/apt/sources.list.d/docker.list > /dev/null
-
-# Install containerd
-apt-get update
-apt-get install -y containerd.io
-
-# Configure containerd
-mkdir -p /etc/containerd
-containerd config default | tee /etc/containerd/config.toml
-sed -i 's/SystemdCgroup = false/SystemdCgroup = true/g' /etc/containerd/config.toml
-
-# Restart containerd
-systemctl restart containerd
-systemctl enable containerd
-`
-	_, stderr, err := client.RunCommand(ctx, script)
+func (p *KubeadmProvisioner) installContainerd(ctx context.Context, client *SSHClient, host HostSpec, params ScriptParams) error {
+	err := p.retry(ctx, host.Address, StepInstallRuntime, func() error {
+		_, stderr, err := p.runScript(ctx, client, host.Address, StepInstallRuntime, "install containerd", "containerd", params)
+		if err != nil {
+			return fmt.Errorf("containerd installation failed: %s: %w", stderr, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("containerd installation failed: %s: %w", stderr, err)
+		return err
 	}
 
-	p.emitEvent("info", host.Address, "install-runtime", "Containerd installed successfully")
+	p.finishStep(host.Address, StepInstallRuntime, "Containerd installed successfully")
 	return nil
 }
 
 // installCRIO installs CRI-O runtime
-func (p *KubeadmProvisioner) installCRIO(ctx context.Context, client *SSHClient, host HostSpec) error {
+func (p *KubeadmProvisioner) installCRIO(ctx context.Context, client *SSHClient, host HostSpec, params ScriptParams) error {
 	// TODO: Implement CRI-O installation
 	return fmt.Errorf("CRI-O installation not yet implemented")
 }
 
 // installKubernetesTools installs kubeadm, kubelet, and kubectl
-func (p *KubeadmProvisioner) installKubernetesTools(ctx context.Context, client *SSHClient, host HostSpec, k8sVersion string) error {
-	p.emitEvent("info", host.Address, "install-k8s", fmt.Sprintf("Installing Kubernetes %s tools", k8sVersion))
-
-	// Determine version major.minor (e.g., 1.28)
-	versionParts := strings.Split(k8sVersion, ".")
-	if len(versionParts) < 2 {
-		return fmt.Errorf("invalid k8s version format: %s", k8sVersion)
-	}
-	majorMinor := fmt.Sprintf("%s.%s", versionParts[0], versionParts[1])
-
-	script := fmt.Sprintf(`
-# Add Kubernetes apt repository
-apt-get update
-apt-get install -y apt-transport-https ca-certificates curl gpg
-
-mkdir -p /etc/apt/keyrings
-curl -fsSL https://pkgs.k8s.io/core:/stable:/v%s/deb/Release.key | gpg --dearmor -o /etc/apt/keyrings/kubernetes-apt-keyring.gpg
-
-echo "deb [signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] https://pkgs.k8s.io/core:/stable:/v%s/deb/ /" | tee /etc/apt/sources.list.d/kubernetes.list
-
-# Install kubelet, kubeadm, kubectl
-apt-get update
-apt-get install -y kubelet kubeadm kubectl
-apt-mark hold kubelet kubeadm kubectl
-
-# Enable kubelet
-systemctl enable kubelet
-`, majorMinor, majorMinor)
-
-	_, stderr, err := client.RunCommand(ctx, script)
+func (p *KubeadmProvisioner) installKubernetesTools(ctx context.Context, client *SSHClient, host HostSpec, params ScriptParams) (err error) {
+	p.startStep(host.Address, StepInstallK8s, fmt.Sprintf("Installing Kubernetes %s tools", params.KubernetesVersion))
+	defer p.failStep(host.Address, StepInstallK8s, &err)
+
+	err = p.retry(ctx, host.Address, StepInstallK8s, func() error {
+		_, stderr, err := p.runScript(ctx, client, host.Address, StepInstallK8s, "install kubeadm, kubelet and kubectl", "kubernetes-tools", params)
+		if err != nil {
+			return fmt.Errorf("kubernetes tools installation failed: %s: %w", stderr, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("kubernetes tools installation failed: %s: %w", stderr, err)
+		return err
 	}
 
-	p.emitEvent("info", host.Address, "install-k8s", "Kubernetes tools installed successfully")
+	p.finishStep(host.Address, StepInstallK8s, "Kubernetes tools installed successfully")
 	return nil
 }
 
 // BootstrapControlPlane initializes the first control plane node
-func (p *KubeadmProvisioner) BootstrapControlPlane(ctx context.Context, host HostSpec, spec ClusterSpec) (*ProvisionResult, error) {
-	client, err := NewSSHClient(host)
+func (p *KubeadmProvisioner) BootstrapControlPlane(ctx context.Context, host HostSpec, spec ClusterSpec) (_ *ProvisionResult, err error) {
+	p.startStep(host.Address, StepBootstrap, "Initializing control plane")
+	defer p.failStep(host.Address, StepBootstrap, &err)
+
+	client, err := p.connect(ctx, host, StepBootstrap)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 	defer client.Close()
 
-	p.emitEvent("info", host.Address, "bootstrap", "Initializing control plane")
-
 	result := &ProvisionResult{
 		Nodes:    []NodeInfo{},
 		Events:   []ProvisionEvent{},
@@ -228,23 +223,42 @@ func (p *KubeadmProvisioner) BootstrapControlPlane(ctx context.Context, host Hos
 
 	initCmd += " --upload-certs" // For HA setup
 
-	p.emitEvent("info", host.Address, "bootstrap", "Running kubeadm init (this may take a few minutes)")
+	// A kubeadm configuration replaces the flags, which kubeadm does not
+	// accept along with it. Hardening settings need one too.
+	if spec.KubeadmConfig != "" || spec.HardeningProfile != "" {
+		config, err := renderKubeadmConfig(spec)
+		if err != nil {
+			return result, fmt.Errorf("invalid kubeadm config: %w", err)
+		}
+		if err := client.UploadContent(ctx, config, kubeadmConfigPath, 0600); err != nil {
+			return result, fmt.Errorf("failed to upload kubeadm config: %w", err)
+		}
+		initCmd = "kubeadm init --config " + kubeadmConfigPath + " --upload-certs"
+	}
+	if spec.HardeningProfile == HardeningCIS {
+		p.emitEvent("info", host.Address, StepBootstrap, "Installing audit policy and encryption configuration")
+		if err := installHardeningFiles(ctx, client); err != nil {
+			return result, err
+		}
+	}
+
+	p.emitEvent("info", host.Address, StepBootstrap, "Running kubeadm init (this may take a few minutes)")
 
 	// Run kubeadm init
-	stdout, stderr, err := client.RunCommand(ctx, initCmd)
+	stdout, stderr, err := p.runStreaming(ctx, client, host.Address, StepBootstrap, "kubeadm init", initCmd)
 	if err != nil {
-		result.AddEvent("error", host.Address, "bootstrap", fmt.Sprintf("kubeadm init failed: %s", stderr))
+		result.AddEvent("error", host.Address, StepBootstrap, fmt.Sprintf("kubeadm init failed: %s", stderr))
 		return result, fmt.Errorf("kubeadm init failed: %w", err)
 	}
 
-	result.AddEvent("info", host.Address, "bootstrap", "kubeadm init completed")
+	result.AddEvent("info", host.Address, StepBootstrap, "kubeadm init completed")
 
 	// Extract join commands and certificate key from output
 	result.JoinCommand = p.extractJoinCommand(stdout)
 	result.CertificateKey = p.extractCertificateKey(stdout)
 
 	// Copy kubeconfig
-	p.emitEvent("info", host.Address, "bootstrap", "Retrieving kubeconfig")
+	p.emitEvent("info", host.Address, StepBootstrap, "Retrieving kubeconfig")
 	_, _, err = client.RunCommand(ctx, "mkdir -p $HOME/.kube && cp -i /etc/kubernetes/admin.conf $HOME/.kube/config && chown $(id -u):$(id -g) $HOME/.kube/config")
 	if err != nil {
 		return result, fmt.Errorf("failed to setup kubeconfig: %w", err)
@@ -257,7 +271,17 @@ func (p *KubeadmProvisioner) BootstrapControlPlane(ctx context.Context, host Hos
 	}
 	result.Kubeconfig = []byte(kubeconfigContent)
 
-	p.emitEvent("info", host.Address, "bootstrap", "Control plane bootstrapped successfully")
+	// The control plane is up already, so files left readable are reported
+	// rather than failing the bootstrap
+	if spec.HardeningProfile != "" {
+		if err := secureNodeFiles(ctx, client); err != nil {
+			p.emitEvent("warn", host.Address, StepBootstrap, err.Error())
+		} else {
+			p.emitEvent("info", host.Address, StepBootstrap, fmt.Sprintf("Applied the %s hardening profile", spec.HardeningProfile))
+		}
+	}
+
+	p.finishStep(host.Address, StepBootstrap, "Control plane bootstrapped successfully")
 
 	// Add node info
 	result.Nodes = append(result.Nodes, NodeInfo{
@@ -272,27 +296,38 @@ func (p *KubeadmProvisioner) BootstrapControlPlane(ctx context.Context, host Hos
 	return result, nil
 }
 
+// cniManifest is the manifest InstallCNI applies for a CNI plugin
+type cniManifest struct {
+	URL        string
+	TestedUpTo Version // newest Kubernetes release the manifest is tested with, any when zero
+	PodCIDR    string  // pod network the manifest configures whatever the spec says
+	Archived   bool    // the project is no longer maintained
+}
+
+// cniManifests are the manifests of the CNI plugins installed with kubectl
+var cniManifests = map[string]cniManifest{
+	"calico":  {URL: "https://raw.githubusercontent.com/projectcalico/calico/v3.26.1/manifests/calico.yaml", TestedUpTo: Version{Major: 1, Minor: 28}},
+	"flannel": {URL: "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml", PodCIDR: "10.244.0.0/16"},
+	"weave":   {URL: "https://github.com/weaveworks/weave/releases/download/v2.8.1/weave-daemonset-k8s.yaml", PodCIDR: "10.32.0.0/12", Archived: true},
+}
+
 // InstallCNI installs the CNI plugin on the control plane
-func (p *KubeadmProvisioner) InstallCNI(ctx context.Context, kubeconfig []byte, cni string, controlPlane HostSpec) error {
-	p.emitEvent("info", controlPlane.Address, "install-cni", fmt.Sprintf("Installing %s CNI", cni))
-
-	var cniManifest string
-	switch cni {
-	case "calico":
-		cniManifest = "https://raw.githubusercontent.com/projectcalico/calico/v3.26.1/manifests/calico.yaml"
-	case "flannel":
-		cniManifest = "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml"
-	case "weave":
-		cniManifest = "https://github.com/weaveworks/weave/releases/download/v2.8.1/weave-daemonset-k8s.yaml"
-	case "cilium":
-		// Cilium requires Helm or cilium CLI
-		return fmt.Errorf("cilium installation requires Helm or CLI, not yet implemented")
-	default:
+func (p *KubeadmProvisioner) InstallCNI(ctx context.Context, kubeconfig []byte, cni string, controlPlane HostSpec) (err error) {
+	p.startStep(controlPlane.Address, StepInstallCNI, fmt.Sprintf("Installing %s CNI", cni))
+	defer p.failStep(controlPlane.Address, StepInstallCNI, &err)
+
+	manifest, ok := cniManifests[cni]
+	if !ok {
+		if cni == "cilium" {
+			// Cilium requires Helm or cilium CLI
+			return fmt.Errorf("cilium installation requires Helm or CLI, not yet implemented")
+		}
 		return fmt.Errorf("unsupported CNI: %s", cni)
 	}
+	cniManifest := manifest.URL
 
 	// Connect to control plane to apply CNI
-	client, err := NewSSHClient(controlPlane)
+	client, err := p.connect(ctx, controlPlane, StepInstallCNI)
 	if err != nil {
 		return fmt.Errorf("failed to connect to control plane: %w", err)
 	}
@@ -302,62 +337,73 @@ func (p *KubeadmProvisioner) InstallCNI(ctx context.Context, kubeconfig []byte,
 	applyCmd := fmt.Sprintf("kubectl apply -f %s", cniManifest)
 	stdout, stderr, err := client.RunCommand(ctx, applyCmd)
 	if err != nil {
-		p.emitEvent("error", controlPlane.Address, "install-cni", fmt.Sprintf("Failed to apply CNI: %s", stderr))
 		return fmt.Errorf("failed to apply CNI manifest: %s: %w", stderr, err)
 	}
 
-	p.emitEvent("info", controlPlane.Address, "install-cni", fmt.Sprintf("CNI applied successfully: %s", stdout))
+	p.emitEvent("info", controlPlane.Address, StepInstallCNI, fmt.Sprintf("CNI applied successfully: %s", stdout))
 
 	// Wait for CNI pods to be ready (optional but recommended)
 	waitCmd := "kubectl wait --for=condition=Ready pods --all -n kube-system --timeout=300s"
-	_, _, err = client.RunCommand(ctx, waitCmd)
-	if err != nil {
-		p.emitEvent("warn", controlPlane.Address, "install-cni", "CNI pods may not be fully ready yet")
+	// Pods still starting do not fail the step
+	if _, _, err := client.RunCommand(ctx, waitCmd); err != nil {
+		p.emitStepEvent("warn", controlPlane.Address, StepInstallCNI, StepSucceeded, "CNI pods may not be fully ready yet")
 	} else {
-		p.emitEvent("info", controlPlane.Address, "install-cni", "CNI pods are ready")
+		p.finishStep(controlPlane.Address, StepInstallCNI, "CNI pods are ready")
 	}
 
 	return nil
 }
 
 // JoinControlPlane joins an additional control plane node
-func (p *KubeadmProvisioner) JoinControlPlane(ctx context.Context, host HostSpec, joinCommand string, certificateKey string) error {
-	client, err := NewSSHClient(host)
+func (p *KubeadmProvisioner) JoinControlPlane(ctx context.Context, host HostSpec, joinCommand string, certificateKey string) (err error) {
+	release, err := acquireHostSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p.startStep(host.Address, StepJoinControlPlane, "Joining control plane")
+	defer p.failStep(host.Address, StepJoinControlPlane, &err)
+
+	client, err := p.connect(ctx, host, StepJoinControlPlane)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer client.Close()
 
-	p.emitEvent("info", host.Address, "join-cp", "Joining control plane")
-
 	// Add --control-plane and --certificate-key flags
 	fullJoinCmd := fmt.Sprintf("%s --control-plane --certificate-key %s", joinCommand, certificateKey)
 
-	_, stderr, err := client.RunCommand(ctx, fullJoinCmd)
-	if err != nil {
-		return fmt.Errorf("failed to join control plane: %s: %w", stderr, err)
+	if err := p.join(ctx, client, host, StepJoinControlPlane, fullJoinCmd); err != nil {
+		return fmt.Errorf("failed to join control plane: %w", err)
 	}
 
-	p.emitEvent("info", host.Address, "join-cp", "Control plane joined successfully")
+	p.finishStep(host.Address, StepJoinControlPlane, "Control plane joined successfully")
 	return nil
 }
 
 // JoinWorker joins a worker node to the cluster
-func (p *KubeadmProvisioner) JoinWorker(ctx context.Context, host HostSpec, joinCommand string) error {
-	client, err := NewSSHClient(host)
+func (p *KubeadmProvisioner) JoinWorker(ctx context.Context, host HostSpec, joinCommand string) (err error) {
+	release, err := acquireHostSlot(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return err
 	}
-	defer client.Close()
+	defer release()
 
-	p.emitEvent("info", host.Address, "join-worker", "Joining worker node")
+	p.startStep(host.Address, StepJoinWorker, "Joining worker node")
+	defer p.failStep(host.Address, StepJoinWorker, &err)
 
-	_, stderr, err := client.RunCommand(ctx, joinCommand)
+	client, err := p.connect(ctx, host, StepJoinWorker)
 	if err != nil {
-		return fmt.Errorf("failed to join worker: %s: %w", stderr, err)
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	if err := p.join(ctx, client, host, StepJoinWorker, joinCommand); err != nil {
+		return fmt.Errorf("failed to join worker: %w", err)
 	}
 
-	p.emitEvent("info", host.Address, "join-worker", "Worker node joined successfully")
+	p.finishStep(host.Address, StepJoinWorker, "Worker node joined successfully")
 	return nil
 }
 
@@ -374,31 +420,78 @@ func (p *KubeadmProvisioner) DestroyCluster(ctx context.Context, spec ClusterSpe
 	allHosts := append(spec.ControlPlanes, spec.Workers...)
 
 	for _, host := range allHosts {
+		p.startStep(host.Address, StepDestroy, "Destroying node")
 		if err := p.resetNode(ctx, host); err != nil {
-			p.emitEvent("warn", host.Address, "destroy", fmt.Sprintf("Failed to reset node: %v", err))
+			p.emitStepEvent("warn", host.Address, StepDestroy, StepSucceeded, fmt.Sprintf("Failed to reset node: %v", err))
+			continue
 		}
+		p.finishStep(host.Address, StepDestroy, "Node destroyed")
 	}
 
 	return nil
 }
 
-// RemoveNode removes a node from the cluster
-func (p *KubeadmProvisioner) RemoveNode(ctx context.Context, host HostSpec, kubeconfig []byte) error {
-	// TODO: Drain node using client-go
-	// kubectl drain <node> --ignore-daemonsets --delete-emptydir-data
+// RemoveNode drains a node, deletes it from the cluster and resets it. A
+// host that cannot be reached any more is only deleted from the cluster.
+func (p *KubeadmProvisioner) RemoveNode(ctx context.Context, host HostSpec, controlPlane HostSpec) (err error) {
+	p.startStep(host.Address, StepRemoveNode, "Removing node from the cluster")
+	defer p.failStep(host.Address, StepRemoveNode, &err)
+
+	nodeName, err := p.nodeName(ctx, host, StepRemoveNode)
+	if err != nil {
+		return err
+	}
+
+	client, err := p.connect(ctx, controlPlane, StepRemoveNode)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	p.emitEvent("info", host.Address, StepRemoveNode, "Draining node "+nodeName)
+	drainCmd := fmt.Sprintf("kubectl --kubeconfig %s drain %s --ignore-daemonsets --delete-emptydir-data --timeout=300s",
+		adminKubeconfigPath, ShellQuote(nodeName))
+	if _, stderr, err := p.runStreaming(ctx, client, host.Address, StepRemoveNode, "kubectl drain", drainCmd); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.emitEvent("warn", host.Address, StepRemoveNode, fmt.Sprintf("Failed to drain node, deleting it anyway: %s", strings.TrimSpace(stderr)))
+	}
+
+	deleteCmd := fmt.Sprintf("kubectl --kubeconfig %s delete node %s --ignore-not-found", adminKubeconfigPath, ShellQuote(nodeName))
+	if _, stderr, err := client.RunCommand(ctx, deleteCmd); err != nil {
+		return fmt.Errorf("failed to delete node %s: %s: %w", nodeName, strings.TrimSpace(stderr), err)
+	}
+
+	if err := p.resetNode(ctx, host); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.emitEvent("warn", host.Address, StepRemoveNode, fmt.Sprintf("Failed to reset node: %v", err))
+	}
 
-	return p.resetNode(ctx, host)
+	p.finishStep(host.Address, StepRemoveNode, "Node removed from the cluster")
+	return nil
 }
 
 // resetNode runs kubeadm reset on a node
-func (p *KubeadmProvisioner) resetNode(ctx context.Context, host HostSpec) error {
-	client, err := NewSSHClient(host)
+func (p *KubeadmProvisioner) resetNode(ctx context.Context, host HostSpec) (err error) {
+	release, err := acquireHostSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p.startStep(host.Address, StepReset, "Resetting node")
+	defer p.failStep(host.Address, StepReset, &err)
+
+	client, err := p.connect(ctx, host, StepReset)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	p.emitEvent("info", host.Address, "reset", "Running kubeadm reset")
+	p.emitEvent("info", host.Address, StepReset, "Running kubeadm reset")
 
 	_, _, err = client.RunCommand(ctx, "kubeadm reset -f")
 	if err != nil {
@@ -408,23 +501,249 @@ func (p *KubeadmProvisioner) resetNode(ctx context.Context, host HostSpec) error
 	// Clean up
 	_, _, _ = client.RunCommand(ctx, "rm -rf /etc/cni/net.d && rm -rf $HOME/.kube/config")
 
+	p.finishStep(host.Address, StepReset, "Node reset")
 	return nil
 }
 
-// GenerateJoinToken generates a new join token
-func (p *KubeadmProvisioner) GenerateJoinToken(ctx context.Context, kubeconfig []byte, controlPlane bool) (string, error) {
-	// TODO: Use client-go or execute kubeadm token create
-	return "", ErrNotImplemented
+// GenerateJoinToken creates a join token with the default TTL of kubeadm
+// and returns the join command of a worker
+func (p *KubeadmProvisioner) GenerateJoinToken(ctx context.Context, controlPlane HostSpec) (string, error) {
+	client, err := p.connect(ctx, controlPlane, StepJoinToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	stdout, stderr, err := client.RunCommand(ctx, "kubeadm token create --print-join-command")
+	if err != nil {
+		return "", fmt.Errorf("failed to create join token: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	joinCommand := strings.TrimSpace(stdout)
+	if !strings.HasPrefix(joinCommand, "kubeadm join") {
+		return "", fmt.Errorf("failed to create join token: unexpected output")
+	}
+	return joinCommand, nil
+}
+
+// UploadCertificates uploads the control plane certificates encrypted with
+// a new certificate key, which kubeadm keeps for two hours
+func (p *KubeadmProvisioner) UploadCertificates(ctx context.Context, controlPlane HostSpec) (string, error) {
+	client, err := p.connect(ctx, controlPlane, StepUploadCerts)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	stdout, stderr, err := client.RunCommand(ctx, "kubeadm init phase upload-certs --upload-certs")
+	if err != nil {
+		return "", fmt.Errorf("failed to upload certificates: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	// The key is the last word of the output
+	words := strings.Fields(stdout)
+	if len(words) == 0 || len(words[len(words)-1]) != 64 {
+		return "", fmt.Errorf("failed to upload certificates: unexpected output")
+	}
+	return words[len(words)-1], nil
+}
+
+// UpgradeNode upgrades kubeadm, the node and its kubelet to a new version.
+// The first control plane upgraded runs kubeadm upgrade apply, every other
+// node kubeadm upgrade node.
+func (p *KubeadmProvisioner) UpgradeNode(ctx context.Context, host HostSpec, k8sVersion string, first bool) (err error) {
+	version, err := ParseVersion(k8sVersion)
+	if err != nil {
+		return err
+	}
+
+	release, err := acquireHostSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p.startStep(host.Address, StepUpgrade, fmt.Sprintf("Upgrading node to %s", version))
+	defer p.failStep(host.Address, StepUpgrade, &err)
+
+	client, err := p.connect(ctx, host, StepUpgrade)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	p.emitEvent("info", host.Address, StepUpgrade, fmt.Sprintf("Upgrading kubeadm to %s", version))
+
+	// Point the repository at the target minor release and install the
+	// matching kubeadm package
+	platform, err := client.DetectPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	params, err := newScriptParams(platform, version.String())
+	if err != nil {
+		return err
+	}
+	err = p.retry(ctx, host.Address, StepUpgrade, func() error {
+		_, stderr, err := p.runScript(ctx, client, host.Address, StepUpgrade, "install kubeadm", "upgrade-kubeadm", params)
+		if err != nil {
+			return fmt.Errorf("kubeadm installation failed: %s: %w", stderr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	upgradeCmd := "kubeadm upgrade node"
+	if first {
+		upgradeCmd = fmt.Sprintf("kubeadm upgrade apply -y v%s", version)
+	}
+	p.emitEvent("info", host.Address, StepUpgrade, "Running "+upgradeCmd)
+	if _, stderr, err := p.runStreaming(ctx, client, host.Address, StepUpgrade, upgradeCmd, upgradeCmd); err != nil {
+		return fmt.Errorf("%s failed: %s: %w", upgradeCmd, stderr, err)
+	}
+
+	p.emitEvent("info", host.Address, StepUpgrade, "Upgrading kubelet and kubectl")
+	err = p.retry(ctx, host.Address, StepUpgrade, func() error {
+		_, stderr, err := p.runScript(ctx, client, host.Address, StepUpgrade, "upgrade kubelet and kubectl", "upgrade-kubelet", params)
+		if err != nil {
+			return fmt.Errorf("kubelet upgrade failed: %s: %w", stderr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.finishStep(host.Address, StepUpgrade, fmt.Sprintf("Node upgraded to %s", version))
+	return nil
 }
 
 // Helper methods
 
-func (p *KubeadmProvisioner) emitEvent(level, host, step, message string) {
+// connect opens an SSH connection to host, retrying transient failures
+func (p *KubeadmProvisioner) connect(ctx context.Context, host HostSpec, step Step) (*SSHClient, error) {
+	ctx, span := tracing.Start(ctx, "ssh connect", attribute.String("host", host.Address))
+	var client *SSHClient
+	err := p.retry(ctx, host.Address, step, func() error {
+		var err error
+		client, err = NewSSHClient(host)
+		return err
+	})
+	// A bare-metal host that stopped responding is power cycled once
+	// through its BMC
+	if err != nil && host.BMC != nil && IsRetryable(err) && ctx.Err() == nil {
+		p.emitEvent("warn", host.Address, step, fmt.Sprintf("Host is not responding, power cycling it through its BMC: %v", err))
+		if err = p.powerCycle(ctx, host); err == nil {
+			p.emitEvent("info", host.Address, step, "Host is back after power cycle")
+			client, err = NewSSHClient(host)
+		}
+	}
+	tracing.End(span, err)
+	return client, err
+}
+
+// powerCycleTimeout bounds waiting for a power cycled host to boot
+const powerCycleTimeout = 15 * time.Minute
+
+// powerCycle power cycles a host through its BMC and waits until it accepts
+// SSH connections again
+func (p *KubeadmProvisioner) powerCycle(ctx context.Context, host HostSpec) error {
+	controller, err := bmc.New(*host.BMC)
+	if err != nil {
+		return err
+	}
+	if err := controller.Power(ctx, bmc.ActionCycle); err != nil {
+		return fmt.Errorf("power cycle through BMC failed: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, powerCycleTimeout)
+	defer cancel()
+	return WaitForHost(ctx, host)
+}
+
+// join runs a kubeadm join command, resetting the node before each retry so
+// that files left behind by a failed attempt do not block the next one
+func (p *KubeadmProvisioner) join(ctx context.Context, client *SSHClient, host HostSpec, step Step, command string) error {
+	attempt := 0
+	return p.retry(ctx, host.Address, step, func() error {
+		attempt++
+		if attempt > 1 {
+			client.RunCommand(ctx, "kubeadm reset -f")
+		}
+		_, stderr, err := p.runStreaming(ctx, client, host.Address, step, "kubeadm join", command)
+		if err != nil {
+			return fmt.Errorf("%s: %w", stderr, err)
+		}
+		return nil
+	})
+}
+
+// runStreaming runs a long command, streaming its output through the output
+// callback when one is set. description names the command in the stream and
+// must not contain secrets such as join tokens.
+func (p *KubeadmProvisioner) runStreaming(ctx context.Context, client *SSHClient, host string, step Step, description, command string) (stdout, stderr string, err error) {
+	if p.outputCallback == nil {
+		return client.RunCommand(ctx, command)
+	}
+	stream := newOutputStream(host, string(step), description, p.outputCallback)
+	stdout, stderr, err = client.RunCommandWithCallback(ctx, command, stream.Write)
+	stream.Close(err)
+	return stdout, stderr, err
+}
+
+// runScript renders a host script, uploads it and runs it like runStreaming
+func (p *KubeadmProvisioner) runScript(ctx context.Context, client *SSHClient, host string, step Step, description, name string, params ScriptParams) (stdout, stderr string, err error) {
+	script, err := RenderScript(name, params)
+	if err != nil {
+		return "", "", err
+	}
+	command, err := client.UploadScript(ctx, name, script)
+	if err != nil {
+		return "", "", err
+	}
+	return p.runStreaming(ctx, client, host, step, description, command)
+}
+
+// retry runs fn with the configured retry policy, emitting an event for each retry
+func (p *KubeadmProvisioner) retry(ctx context.Context, host string, step Step, fn func() error) error {
+	policy := currentRetryPolicy()
+	return Retry(ctx, policy, func(attempt int, err error, wait time.Duration) {
+		p.emitEvent("warn", host, step, fmt.Sprintf("Attempt %d/%d failed, retrying in %s: %v", attempt, policy.Attempts, wait, err))
+	}, fn)
+}
+
+func (p *KubeadmProvisioner) emitEvent(level, host string, step Step, message string) {
 	if p.eventCallback != nil {
 		p.eventCallback(NewProvisionEvent(level, host, step, message))
 	}
 }
 
+// emitStepEvent emits an event marking a change of the status of a step
+func (p *KubeadmProvisioner) emitStepEvent(level, host string, step Step, status StepStatus, message string) {
+	if p.eventCallback != nil {
+		event := NewProvisionEvent(level, host, step, message)
+		event.Status = status
+		p.eventCallback(event)
+	}
+}
+
+// startStep marks a step running on a host
+func (p *KubeadmProvisioner) startStep(host string, step Step, message string) {
+	p.emitStepEvent("info", host, step, StepRunning, message)
+}
+
+// finishStep marks a step succeeded on a host
+func (p *KubeadmProvisioner) finishStep(host string, step Step, message string) {
+	p.emitStepEvent("info", host, step, StepSucceeded, message)
+}
+
+// failStep marks a step failed on a host when *err is set, deferred by the
+// methods running a step so that every error return is recorded
+func (p *KubeadmProvisioner) failStep(host string, step Step, err *error) {
+	if *err != nil {
+		p.emitStepEvent("error", host, step, StepFailed, (*err).Error())
+	}
+}
+
 func (p *KubeadmProvisioner) extractJoinCommand(output string) string {
 	// Extract "kubeadm join ..." from output
 	lines := strings.Split(output, "\n")
@@ -0,0 +1,114 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"kubeforge/internal/validation"
+)
+
+// kubeadmKinds are the kinds of documents a kubeadm configuration may hold,
+// by API group
+var kubeadmKinds = map[string][]string{
+	"kubeadm.k8s.io":          {"InitConfiguration", "ClusterConfiguration", "JoinConfiguration"},
+	"kubelet.config.k8s.io":   {"KubeletConfiguration"},
+	"kubeproxy.config.k8s.io": {"KubeProxyConfiguration"},
+}
+
+// kubeadmAPIVersion is the version of ClusterConfiguration documents
+// KubeForge adds to a kubeadm configuration without one
+const kubeadmAPIVersion = "kubeadm.k8s.io/v1beta3"
+
+// parseKubeadmConfig splits a kubeadm configuration into its YAML documents
+func parseKubeadmConfig(config string) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	decoder := yaml.NewDecoder(strings.NewReader(config))
+	for {
+		var doc map[string]interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// ValidateKubeadmConfig checks that a kubeadm configuration only holds
+// documents kubeadm accepts
+func ValidateKubeadmConfig(field, config string) validation.Errors {
+	var errs validation.Errors
+	if config == "" {
+		return nil
+	}
+	docs, err := parseKubeadmConfig(config)
+	if err != nil {
+		errs.Add(field, validation.CodeInvalid, err.Error())
+		return errs
+	}
+
+	for i, doc := range docs {
+		apiVersion, _ := doc["apiVersion"].(string)
+		kind, _ := doc["kind"].(string)
+		group, _, _ := strings.Cut(apiVersion, "/")
+		kinds, ok := kubeadmKinds[group]
+		if !ok || !validation.OneOf(kind, kinds) {
+			errs.Add(field, validation.CodeUnsupported, fmt.Sprintf("document %d: unsupported kind %q of %q", i+1, kind, apiVersion))
+		}
+	}
+	return errs
+}
+
+// renderKubeadmConfig returns the configuration kubeadm init runs with: the
+// kubeadm configuration of the spec with the version, networks and control
+// plane endpoint of the spec filled into its ClusterConfiguration where it
+// does not set them, and the settings of its hardening profile and DNS
+// cache
+func renderKubeadmConfig(spec ClusterSpec) ([]byte, error) {
+	docs, err := parseKubeadmConfig(spec.KubeadmConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var cluster map[string]interface{}
+	for _, doc := range docs {
+		if doc["kind"] == "ClusterConfiguration" {
+			cluster = doc
+			break
+		}
+	}
+	if cluster == nil {
+		cluster = map[string]interface{}{"apiVersion": kubeadmAPIVersion, "kind": "ClusterConfiguration"}
+		docs = append(docs, cluster)
+	}
+
+	setDefault(cluster, "kubernetesVersion", "v"+strings.TrimPrefix(spec.K8sVersion, "v"))
+	if spec.APIServerEndpoint != "" {
+		setDefault(cluster, "controlPlaneEndpoint", spec.APIServerEndpoint)
+	}
+	networking, _ := cluster["networking"].(map[string]interface{})
+	if networking == nil {
+		networking = map[string]interface{}{}
+		cluster["networking"] = networking
+	}
+	setDefault(networking, "podSubnet", spec.PodNetworkCIDR)
+	setDefault(networking, "serviceSubnet", spec.ServiceCIDR)
+	docs = applyHardening(docs, cluster, spec.HardeningProfile)
+	docs = applyNodeLocalDNS(docs, spec)
+
+	return encodeDocuments(docs)
+}
+
+// setDefault sets a key of a YAML mapping unless it is set already
+func setDefault(m map[string]interface{}, key string, value interface{}) {
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
@@ -0,0 +1,134 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// KubeBenchImage is the kube-bench image scans run in-cluster
+const KubeBenchImage = "docker.io/aquasec/kube-bench:v0.10.1"
+
+// BenchCheck is the outcome of one CIS benchmark check on a node
+type BenchCheck struct {
+	ID          string `json:"id"`      // e.g. 1.1.1
+	Section     string `json:"section"` // e.g. 1.1 Control Plane Node Configuration Files
+	Description string `json:"description"`
+	Status      string `json:"status"` // PASS, FAIL, WARN, INFO
+	Scored      bool   `json:"scored"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// kubeBenchControls is a control group of the JSON output of kube-bench
+type kubeBenchControls struct {
+	Text  string `json:"text"`
+	Tests []struct {
+		Section string `json:"section"`
+		Desc    string `json:"desc"`
+		Results []struct {
+			TestNumber  string `json:"test_number"`
+			TestDesc    string `json:"test_desc"`
+			Status      string `json:"status"`
+			Scored      bool   `json:"scored"`
+			Remediation string `json:"remediation"`
+		} `json:"results"`
+	} `json:"tests"`
+}
+
+// ParseKubeBench reads the checks of kube-bench run --json. Newer releases
+// wrap the control groups in an object, older ones print them as a list.
+func ParseKubeBench(output []byte) ([]BenchCheck, error) {
+	output = bytes.TrimSpace(output)
+	var groups []kubeBenchControls
+	if bytes.HasPrefix(output, []byte("[")) {
+		if err := json.Unmarshal(output, &groups); err != nil {
+			return nil, fmt.Errorf("invalid kube-bench output: %w", err)
+		}
+	} else {
+		var wrapped struct {
+			Controls []kubeBenchControls `json:"Controls"`
+		}
+		if err := json.Unmarshal(output, &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid kube-bench output: %w", err)
+		}
+		groups = wrapped.Controls
+	}
+
+	var checks []BenchCheck
+	for _, group := range groups {
+		for _, test := range group.Tests {
+			for _, result := range test.Results {
+				checks = append(checks, BenchCheck{
+					ID:          result.TestNumber,
+					Section:     test.Section + " " + test.Desc,
+					Description: result.TestDesc,
+					Status:      result.Status,
+					Scored:      result.Scored,
+					Remediation: result.Remediation,
+				})
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("kube-bench reported no checks")
+	}
+	return checks, nil
+}
+
+// RunKubeBench runs kube-bench installed on a host over SSH. kube-bench
+// detects the components running on the host and checks those.
+func RunKubeBench(ctx context.Context, client *SSHClient) ([]BenchCheck, error) {
+	stdout, stderr, err := client.RunCommand(ctx, "command -v kube-bench >/dev/null || { echo 'kube-bench is not installed' >&2; exit 127; }; kube-bench run --json")
+	if err != nil {
+		return nil, fmt.Errorf("kube-bench failed: %s: %w", stderr, err)
+	}
+	return ParseKubeBench([]byte(stdout))
+}
+
+// KubeBenchJob returns the manifest of a Job running kube-bench on a node
+// with the host paths it inspects mounted read-only
+func KubeBenchJob(name, namespace, node string) string {
+	return fmt.Sprintf(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: %[1]s
+  namespace: %[2]s
+  labels:
+    app.kubernetes.io/name: kube-bench
+    app.kubernetes.io/managed-by: kubeforge
+spec:
+  backoffLimit: 0
+  ttlSecondsAfterFinished: 3600
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: kube-bench
+    spec:
+      hostPID: true
+      nodeName: %[3]q
+      restartPolicy: Never
+      tolerations:
+        - operator: Exists
+      containers:
+        - name: kube-bench
+          image: %[4]s
+          command: ["kube-bench", "run", "--json"]
+          volumeMounts:
+            - {name: var-lib-etcd, mountPath: /var/lib/etcd, readOnly: true}
+            - {name: var-lib-kubelet, mountPath: /var/lib/kubelet, readOnly: true}
+            - {name: etc-systemd, mountPath: /etc/systemd, readOnly: true}
+            - {name: lib-systemd, mountPath: /lib/systemd, readOnly: true}
+            - {name: etc-kubernetes, mountPath: /etc/kubernetes, readOnly: true}
+            - {name: etc-cni-netd, mountPath: /etc/cni/net.d, readOnly: true}
+            - {name: usr-bin, mountPath: /usr/local/mount-from-host/bin, readOnly: true}
+      volumes:
+        - {name: var-lib-etcd, hostPath: {path: /var/lib/etcd}}
+        - {name: var-lib-kubelet, hostPath: {path: /var/lib/kubelet}}
+        - {name: etc-systemd, hostPath: {path: /etc/systemd}}
+        - {name: lib-systemd, hostPath: {path: /lib/systemd}}
+        - {name: etc-kubernetes, hostPath: {path: /etc/kubernetes}}
+        - {name: etc-cni-netd, hostPath: {path: /etc/cni/net.d}}
+        - {name: usr-bin, hostPath: {path: /usr/bin}}
+`, name, namespace, node, KubeBenchImage)
+}
@@ -0,0 +1,67 @@
+package provision
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Concurrency limits for host operations. A limit of 0 means unlimited.
+var (
+	limitsMu        sync.RWMutex
+	perClusterLimit = 10
+	hostSlots       chan struct{} // bounds SSH host operations across all clusters
+)
+
+// SetConcurrencyLimits configures how many hosts of a single cluster are
+// processed in parallel and how many SSH host operations may run at once
+// across all clusters
+func SetConcurrencyLimits(perCluster, global int) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	perClusterLimit = perCluster
+	hostSlots = nil
+	if global > 0 {
+		hostSlots = make(chan struct{}, global)
+	}
+}
+
+// ForEachHost runs fn for every host in parallel, bounded by the per-cluster
+// limit. The first error cancels the remaining hosts and is returned.
+func ForEachHost(ctx context.Context, hosts []HostSpec, fn func(ctx context.Context, host HostSpec) error) error {
+	limitsMu.RLock()
+	limit := perClusterLimit
+	limitsMu.RUnlock()
+
+	g, ctx := errgroup.WithContext(ctx)
+	if limit > 0 {
+		g.SetLimit(limit)
+	}
+	for _, host := range hosts {
+		host := host
+		g.Go(func() error {
+			return fn(ctx, host)
+		})
+	}
+	return g.Wait()
+}
+
+// acquireHostSlot waits for a free global slot. The returned function
+// releases it.
+func acquireHostSlot(ctx context.Context) (func(), error) {
+	limitsMu.RLock()
+	slots := hostSlots
+	limitsMu.RUnlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
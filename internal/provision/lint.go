@@ -0,0 +1,92 @@
+package provision
+
+import (
+	"fmt"
+	"net"
+
+	"kubeforge/internal/validation"
+)
+
+// Size of the pod network kube-controller-manager gives each node by
+// default, by IP family
+const (
+	nodeCIDRMaskSizeIPv4 = 24
+	nodeCIDRMaskSizeIPv6 = 64
+)
+
+// Lint returns warnings about a spec with defaults applied that is valid
+// but likely not what was meant. Unlike the errors of ValidateFields they
+// do not stop the cluster from being created.
+func (cs *ClusterSpec) Lint() validation.Errors {
+	var warnings validation.Errors
+
+	if n := len(cs.ControlPlanes); n > 1 && n%2 == 0 {
+		warnings.Add("control_planes", validation.CodeQuorum, fmt.Sprintf("%d control planes tolerate no more failures than %d, etcd needs a majority of its members: use an odd number", n, n-1))
+	}
+
+	podNetwork, _ := validation.CIDR(cs.PodNetworkCIDR)
+	serviceNetwork, _ := validation.CIDR(cs.ServiceCIDR)
+	overlaps := func(field, address string) {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return
+		}
+		for _, network := range []struct {
+			name string
+			cidr *net.IPNet
+		}{{"pod network", podNetwork}, {"service", serviceNetwork}} {
+			if network.cidr != nil && network.cidr.Contains(ip) {
+				warnings.Add(field, validation.CodeOverlap, fmt.Sprintf("%s is in the %s CIDR %s, the host is unreachable from pods", address, network.name, network.cidr))
+			}
+		}
+	}
+	for _, group := range []struct {
+		field string
+		hosts []HostSpec
+	}{{"control_planes", cs.ControlPlanes}, {"workers", cs.Workers}} {
+		for i, host := range group.hosts {
+			overlaps(validation.Path(validation.Index(group.field, i), "address"), host.Address)
+		}
+	}
+	overlaps("load_balancer_ip", cs.LoadBalancerIP)
+	if host, _, err := net.SplitHostPort(cs.APIServerEndpoint); err == nil {
+		overlaps("api_server_endpoint", host)
+	} else {
+		overlaps("api_server_endpoint", cs.APIServerEndpoint)
+	}
+
+	if podNetwork != nil {
+		ones, bits := podNetwork.Mask.Size()
+		maskSize := nodeCIDRMaskSizeIPv4
+		if bits == 128 {
+			maskSize = nodeCIDRMaskSizeIPv6
+		}
+		nodes := len(cs.ControlPlanes) + len(cs.Workers)
+		switch {
+		case ones > maskSize:
+			warnings.Add("pod_network_cidr", validation.CodeOutOfRange, fmt.Sprintf("pod network CIDR %s is smaller than the /%d each node is given", podNetwork, maskSize))
+		case maskSize-ones < 31 && 1<<(maskSize-ones) < nodes:
+			warnings.Add("pod_network_cidr", validation.CodeOutOfRange, fmt.Sprintf("pod network CIDR %s has room for the /%d networks of %d nodes, the cluster has %d", podNetwork, maskSize, 1<<(maskSize-ones), nodes))
+		}
+	}
+
+	if cs.CNI == "cilium" {
+		warnings.Add("cni", validation.CodeUnsupported, "cilium is not installed by KubeForge yet, pods stay pending until it is installed by hand")
+	}
+	manifest, ok := cniManifests[cs.CNI]
+	if !ok {
+		return warnings
+	}
+	if manifest.Archived {
+		warnings.Add("cni", validation.CodeUnsupported, fmt.Sprintf("%s is no longer maintained, consider calico or flannel", cs.CNI))
+	}
+	if manifest.PodCIDR != "" && podNetwork != nil && podNetwork.String() != manifest.PodCIDR {
+		warnings.Add("pod_network_cidr", validation.CodeUnsupported, fmt.Sprintf("the %s manifest uses the pod network %s, not %s", cs.CNI, manifest.PodCIDR, podNetwork))
+	}
+	tested := manifest.TestedUpTo
+	if v, err := ParseVersion(cs.K8sVersion); err == nil && tested.Major != 0 &&
+		(v.Major > tested.Major || v.Major == tested.Major && v.Minor > tested.Minor) {
+		warnings.Add("cni", validation.CodeVersionSkew, fmt.Sprintf("the %s manifest is tested with k8s up to %d.%d, not %d.%d", cs.CNI, tested.Major, tested.Minor, v.Major, v.Minor))
+	}
+	return warnings
+}
@@ -0,0 +1,292 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultNetworkCheckImage is the image of the pods the network check runs
+// in: agnhost of the Kubernetes e2e tests, serving HTTP with netexec and
+// testing connections with connect
+const DefaultNetworkCheckImage = "registry.k8s.io/e2e-test-images/agnhost:2.47"
+
+// NetworkCheckNamespace holds the short-lived network check workload
+const NetworkCheckNamespace = "kubeforge-netcheck"
+
+const (
+	networkCheckName = "netcheck"
+	networkCheckPort = 8080
+	// networkCheckRollout bounds waiting for the check pods to run
+	networkCheckRollout = 300
+	// networkCheckConnectTimeout bounds a single connection attempt
+	networkCheckConnectTimeout = "5s"
+)
+
+// Network check names
+const (
+	NetworkCheckPodToPod = "pod-to-pod"
+	NetworkCheckDNS      = "dns"
+	NetworkCheckNodePort = "nodeport"
+)
+
+// networkCheckHints tell where to look when a kind of check fails
+var networkCheckHints = map[string]string{
+	NetworkCheckPodToPod: "pods on different nodes cannot reach each other, check that the CNI pods run on every node and that the firewall allows the CNI traffic between nodes (VXLAN UDP 4789 or 8472, BGP TCP 179, IP-in-IP)",
+	NetworkCheckDNS:      "pods cannot resolve or reach kubernetes.default.svc, check that CoreDNS and kube-proxy are running and that pods reach the service network",
+	NetworkCheckNodePort: "NodePort services are unreachable, check kube-proxy and that the firewall allows TCP 30000-32767 between nodes",
+}
+
+// NetworkCheckSettings configure the network check run after the nodes of a
+// new cluster joined
+type NetworkCheckSettings struct {
+	Enabled bool
+	Image   string // DefaultNetworkCheckImage when empty, e.g. for a mirror
+}
+
+var (
+	networkCheckMu sync.RWMutex
+	networkCheck   = NetworkCheckSettings{Enabled: true}
+)
+
+// SetNetworkCheck configures the network check of provisioning
+func SetNetworkCheck(settings NetworkCheckSettings) {
+	networkCheckMu.Lock()
+	networkCheck = settings
+	networkCheckMu.Unlock()
+}
+
+// CurrentNetworkCheck returns the network check settings
+func CurrentNetworkCheck() NetworkCheckSettings {
+	networkCheckMu.RLock()
+	defer networkCheckMu.RUnlock()
+	settings := networkCheck
+	if settings.Image == "" {
+		settings.Image = DefaultNetworkCheckImage
+	}
+	return settings
+}
+
+// NetworkCheck is the outcome of one connectivity test
+type NetworkCheck struct {
+	Name   string `json:"name"`   // pod-to-pod, dns or nodeport
+	Source string `json:"source"` // node the connection was made from
+	Target string `json:"target"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// String describes the check for events and errors
+func (c NetworkCheck) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Name, c.Source, c.Target)
+}
+
+// ErrNetworkBroken is returned when connectivity checks fail
+var ErrNetworkBroken = errors.New("pod network is broken")
+
+// NetworkDiagnosis returns an error naming the failed checks with a hint
+// for each kind of failure, nil when every check passed
+func NetworkDiagnosis(checks []NetworkCheck) error {
+	var failed []string
+	var hints []string
+	seen := map[string]bool{}
+	for _, check := range checks {
+		if check.Passed {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", check, check.Error))
+		if !seen[check.Name] {
+			seen[check.Name] = true
+			hints = append(hints, networkCheckHints[check.Name])
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d checks failed (%s); %s", ErrNetworkBroken, len(failed), len(checks), strings.Join(failed, "; "), strings.Join(hints, "; "))
+}
+
+// NetworkCheckManifest returns the namespace, DaemonSet and NodePort service
+// of the network check. The pods tolerate every taint so control planes are
+// checked too.
+func NetworkCheckManifest(image string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: %[1]s
+  labels:
+    app.kubernetes.io/managed-by: kubeforge
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: %[2]s
+  namespace: %[1]s
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: %[2]s
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: %[2]s
+    spec:
+      tolerations:
+        - operator: Exists
+      terminationGracePeriodSeconds: 1
+      containers:
+        - name: netexec
+          image: %[3]s
+          args: ["netexec", "--http-port=%[4]d"]
+          ports:
+            - containerPort: %[4]d
+          readinessProbe:
+            httpGet: {path: /healthz, port: %[4]d}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: %[2]s
+  namespace: %[1]s
+spec:
+  type: NodePort
+  selector:
+    app.kubernetes.io/name: %[2]s
+  ports:
+    - port: %[4]d
+      targetPort: %[4]d
+`, NetworkCheckNamespace, networkCheckName, image, networkCheckPort)
+}
+
+// networkEndpoint is a pod or node of the network check, by name and IP
+type networkEndpoint struct {
+	Name string
+	Node string
+	IP   string
+}
+
+// parseEndpoints reads "name node ip" lines, skipping incomplete ones, in
+// the order of the node names. Of several IPs the first is used.
+func parseEndpoints(output string) []networkEndpoint {
+	var endpoints []networkEndpoint
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		endpoints = append(endpoints, networkEndpoint{Name: fields[0], Node: fields[1], IP: fields[2]})
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Node < endpoints[j].Node })
+	return endpoints
+}
+
+// stderrError returns the error output of a failed command, or its error
+// when there is none
+func stderrError(stderr string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return errors.New(stderr)
+	}
+	return err
+}
+
+// CheckNetwork deploys a short-lived DaemonSet and tests the pod network
+// from the control plane: each pod connects to the pod of the next node,
+// every pod resolves and connects to kubernetes.default.svc, and the
+// control plane reaches a NodePort service on every node. The workload is
+// deleted afterwards. Failed checks are reported by NetworkDiagnosis.
+func (p *KubeadmProvisioner) CheckNetwork(ctx context.Context, controlPlane HostSpec) (_ []NetworkCheck, err error) {
+	address := controlPlane.Address
+	settings := CurrentNetworkCheck()
+	p.startStep(address, StepCheckNetwork, "Checking pod network connectivity")
+	defer p.failStep(address, StepCheckNetwork, &err)
+
+	client, err := p.connect(ctx, controlPlane, StepCheckNetwork)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	kubectl := func(args string) (string, error) {
+		stdout, stderr, err := client.RunCommand(ctx, "kubectl --kubeconfig "+adminKubeconfigPath+" "+args)
+		return stdout, stderrError(stderr, err)
+	}
+
+	apply := "kubectl --kubeconfig " + adminKubeconfigPath + " apply -f -"
+	if _, stderr, err := client.RunCommand(ctx, apply, WithStdin(strings.NewReader(NetworkCheckManifest(settings.Image)))); err != nil {
+		return nil, fmt.Errorf("failed to deploy the network check: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	defer client.RunCommand(context.Background(), fmt.Sprintf("kubectl --kubeconfig %s delete namespace %s --ignore-not-found --wait=false", adminKubeconfigPath, NetworkCheckNamespace))
+
+	rollout := fmt.Sprintf("rollout status daemonset/%s -n %s --timeout=%ds", networkCheckName, NetworkCheckNamespace, networkCheckRollout)
+	if _, err := kubectl(rollout); err != nil {
+		return nil, fmt.Errorf("%w: network check pods are not ready, check that the CNI pods are running and that %s can be pulled: %v", ErrNetworkBroken, settings.Image, err)
+	}
+
+	output, err := kubectl(fmt.Sprintf(`get pods -n %s -l app.kubernetes.io/name=%s -o jsonpath='{range .items[*]}{.metadata.name}{" "}{.spec.nodeName}{" "}{.status.podIP}{"\n"}{end}'`, NetworkCheckNamespace, networkCheckName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network check pods: %w", err)
+	}
+	pods := parseEndpoints(output)
+	output, err = kubectl(`get nodes -o jsonpath='{range .items[*]}{.metadata.name}{" "}{.metadata.name}{" "}{.status.addresses[?(@.type=="InternalIP")].address}{"\n"}{end}'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	nodes := parseEndpoints(output)
+	nodePort, err := kubectl(fmt.Sprintf("get service %s -n %s -o jsonpath='{.spec.ports[0].nodePort}'", networkCheckName, NetworkCheckNamespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the network check NodePort: %w", err)
+	}
+
+	var checks []NetworkCheck
+	record := func(check NetworkCheck, err error) {
+		check.Passed = err == nil
+		if err != nil {
+			check.Error = err.Error()
+			p.emitEvent("error", address, StepCheckNetwork, fmt.Sprintf("Check %s failed: %s", check, check.Error))
+		} else {
+			p.emitEvent("info", address, StepCheckNetwork, fmt.Sprintf("Check %s passed", check))
+		}
+		checks = append(checks, check)
+	}
+	connect := func(pod networkEndpoint, target string) error {
+		_, err := kubectl(fmt.Sprintf("exec -n %s %s -- /agnhost connect --timeout=%s %s", NetworkCheckNamespace, ShellQuote(pod.Name), networkCheckConnectTimeout, ShellQuote(target)))
+		return err
+	}
+
+	for i, pod := range pods {
+		if err := ctx.Err(); err != nil {
+			return checks, err
+		}
+		// A single node has no other pod to reach
+		if len(pods) > 1 {
+			next := pods[(i+1)%len(pods)]
+			record(NetworkCheck{Name: NetworkCheckPodToPod, Source: pod.Node, Target: next.Node},
+				connect(pod, fmt.Sprintf("%s:%d", next.IP, networkCheckPort)))
+		}
+		record(NetworkCheck{Name: NetworkCheckDNS, Source: pod.Node, Target: "kubernetes.default.svc"},
+			connect(pod, "kubernetes.default.svc:443"))
+	}
+	for _, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return checks, err
+		}
+		target := fmt.Sprintf("%s:%s", node.IP, strings.TrimSpace(nodePort))
+		_, stderr, err := client.RunCommand(ctx, "curl -sS -o /dev/null -m 5 http://"+target+"/hostname")
+		record(NetworkCheck{Name: NetworkCheckNodePort, Source: address, Target: node.Node + " " + target}, stderrError(stderr, err))
+	}
+	if len(pods) == 0 {
+		return checks, fmt.Errorf("%w: no network check pod is running", ErrNetworkBroken)
+	}
+
+	if err := NetworkDiagnosis(checks); err != nil {
+		return checks, err
+	}
+	p.finishStep(address, StepCheckNetwork, fmt.Sprintf("Pod network works, %d checks passed", len(checks)))
+	return checks, nil
+}
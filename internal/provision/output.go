@@ -0,0 +1,160 @@
+package provision
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Live output of long commands is sent in chunks at most every
+// outputInterval. A chunk holds at most maxOutputChunk bytes, the rest of a
+// burst is skipped in the stream but kept in the full output, which is
+// trimmed to its last maxOutputSize bytes to fit a text column on every
+// supported database.
+const (
+	outputInterval = 500 * time.Millisecond
+	maxOutputChunk = 8 << 10
+	maxOutputSize  = 60 << 10
+)
+
+// secretOutput matches the join token and certificate key printed by kubeadm
+var secretOutput = regexp.MustCompile(`(--token[ =]+|--certificate-key[ =]+|Using token:\s*)[^\s\\]+`)
+
+// redactOutput hides secrets in command output before it leaves the provisioner
+func redactOutput(output string) string {
+	return secretOutput.ReplaceAllString(output, "${1}<redacted>")
+}
+
+// CommandOutput is reported while a long command runs. Chunks carry new
+// output as it arrives; the last report of a command has Done set and
+// carries its full output.
+type CommandOutput struct {
+	Host    string `json:"host"`
+	Step    string `json:"step"`
+	Command string `json:"command"` // short description shown to users
+	Chunk   string `json:"chunk,omitempty"`
+	Done    bool   `json:"done,omitempty"`
+	Output  string `json:"output,omitempty"` // combined stdout and stderr, set when Done
+	Error   string `json:"error,omitempty"`  // set when Done and the command failed
+}
+
+// OutputCallback receives the live output of long running commands
+type OutputCallback func(output CommandOutput)
+
+// OutputStreamer is implemented by provisioners that can stream the output
+// of the commands they run
+type OutputStreamer interface {
+	SetOutputCallback(callback OutputCallback)
+}
+
+// outputStream batches the output of one command into rate limited chunks
+type outputStream struct {
+	mu       sync.Mutex
+	base     CommandOutput
+	callback OutputCallback
+	pending  strings.Builder
+	skipped  int
+	full     strings.Builder
+	trimmed  bool
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// newOutputStream starts streaming the output of a command to callback
+func newOutputStream(host, step, command string, callback OutputCallback) *outputStream {
+	s := &outputStream{
+		base:     CommandOutput{Host: host, Step: step, Command: command},
+		callback: callback,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write records output of the command. It is safe for concurrent use.
+func (s *outputStream) Write(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if room := maxOutputChunk - s.pending.Len(); room < len(data) {
+		s.pending.WriteString(data[:room])
+		s.skipped += len(data) - room
+	} else {
+		s.pending.WriteString(data)
+	}
+
+	s.full.WriteString(data)
+	if s.full.Len() > 2*maxOutputSize {
+		tail := s.full.String()[s.full.Len()-maxOutputSize:]
+		s.full.Reset()
+		s.full.WriteString(tail)
+		s.trimmed = true
+	}
+}
+
+func (s *outputStream) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(outputInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(false)
+		case <-s.stop:
+			s.flush(true)
+			return
+		}
+	}
+}
+
+// flush sends the output gathered since the last chunk. Unless final is set
+// or output was skipped, an unfinished last line is held back so secrets are
+// never split across chunks.
+func (s *outputStream) flush(final bool) {
+	s.mu.Lock()
+	chunk := s.pending.String()
+	s.pending.Reset()
+	if s.skipped > 0 {
+		chunk += fmt.Sprintf("\n... %d bytes of output skipped ...\n", s.skipped)
+		s.skipped = 0
+	} else if i := strings.LastIndexByte(chunk, '\n'); !final && i < len(chunk)-1 {
+		s.pending.WriteString(chunk[i+1:])
+		chunk = chunk[:i+1]
+	}
+	s.mu.Unlock()
+
+	if chunk != "" {
+		output := s.base
+		output.Chunk = redactOutput(chunk)
+		s.callback(output)
+	}
+}
+
+// Close sends the remaining output and the final report of the command
+func (s *outputStream) Close(err error) {
+	close(s.stop)
+	<-s.stopped
+
+	s.mu.Lock()
+	full := s.full.String()
+	trimmed := s.trimmed || len(full) > maxOutputSize
+	s.mu.Unlock()
+	if len(full) > maxOutputSize {
+		full = full[len(full)-maxOutputSize:]
+	}
+	if trimmed {
+		full = "... earlier output trimmed ...\n" + full
+	}
+
+	output := s.base
+	output.Done = true
+	output.Output = redactOutput(full)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	s.callback(output)
+}
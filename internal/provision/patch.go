@@ -0,0 +1,161 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"kubeforge/internal/kube"
+	"kubeforge/internal/validation"
+)
+
+// bootIDCommand prints an ID that changes with every boot
+const bootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+
+// rebootCommand reboots the host shortly after the SSH command returns
+const rebootCommand = "systemd-run --on-active=3 --timer-property=AccuracySec=1s systemctl reboot"
+
+// nodeReadyTimeout bounds waiting for a patched node to be Ready again
+const nodeReadyTimeout = 10 * time.Minute
+
+// PatchOptions controls applying operating system updates to a node
+type PatchOptions struct {
+	Packages []string     `json:"packages,omitempty"` // upgrade only these packages, all packages when empty
+	Reboot   *bool        `json:"reboot,omitempty"`   // reboot after updating, true when unset
+	Drain    DrainOptions `json:"drain"`
+}
+
+// ValidateFields checks the options. Field paths are relative to prefix.
+func (o *PatchOptions) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	for i, pkg := range o.Packages {
+		if pkg == "" || strings.HasPrefix(pkg, "-") || strings.ContainsAny(pkg, " \t\n'\"") {
+			errs.Add(validation.Index(validation.Path(prefix, "packages"), i), validation.CodeInvalid, fmt.Sprintf("invalid package name %q", pkg))
+		}
+	}
+	errs = append(errs, o.Drain.ValidateFields(validation.Path(prefix, "drain"))...)
+	return errs
+}
+
+// PatchNode takes a node out of service, updates its operating system
+// packages, reboots it and returns it to service once kubelet reports it
+// Ready. Kubernetes packages are held and stay at their version.
+func (p *KubeadmProvisioner) PatchNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts PatchOptions) error {
+	if err := p.DrainNode(ctx, kubeconfig, host, opts.Drain); err != nil {
+		return err
+	}
+	client, err := kube.NewClientset(kubeconfig)
+	if err != nil {
+		return err
+	}
+	nodeName, err := kubeNodeName(ctx, client, host)
+	if err != nil {
+		return err
+	}
+
+	if err := p.updatePackages(ctx, host, opts.Packages); err != nil {
+		return err
+	}
+	if opts.Reboot == nil || *opts.Reboot {
+		if err := p.reboot(ctx, host); err != nil {
+			return err
+		}
+	}
+
+	p.emitEvent("info", host.Address, StepPatch, "Waiting for node "+nodeName+" to be Ready")
+	// The API server may be unreachable while a control plane node reboots
+	err = wait.PollUntilContextTimeout(ctx, hostPollInterval, nodeReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				return condition.Status == corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("node %s is not Ready: %w", nodeName, err)
+	}
+
+	return p.CordonNode(ctx, kubeconfig, host, false)
+}
+
+// updatePackages upgrades the packages of a host, all of them when packages
+// is empty
+func (p *KubeadmProvisioner) updatePackages(ctx context.Context, host HostSpec, packages []string) error {
+	release, err := acquireHostSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	client, err := p.connect(ctx, host, StepPatch)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	platform, err := client.DetectPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	params, err := newScriptParams(platform, "")
+	if err != nil {
+		return err
+	}
+	params.Packages = packages
+
+	p.emitEvent("info", host.Address, StepPatch, "Updating operating system packages")
+	return p.retry(ctx, host.Address, StepPatch, func() error {
+		_, stderr, err := p.runScript(ctx, client, host.Address, StepPatch, "update packages", "os-update", params)
+		if err != nil {
+			return fmt.Errorf("package update failed: %s: %w", strings.TrimSpace(stderr), err)
+		}
+		return nil
+	})
+}
+
+// reboot reboots a host and waits until it accepts SSH connections after
+// booting again
+func (p *KubeadmProvisioner) reboot(ctx context.Context, host HostSpec) error {
+	client, err := p.connect(ctx, host, StepPatch)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	bootID, _, err := client.RunCommand(ctx, bootIDCommand)
+	if err == nil {
+		_, _, err = client.RunCommand(ctx, rebootCommand)
+	}
+	client.Close()
+	if err != nil {
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+
+	p.emitEvent("info", host.Address, StepPatch, "Rebooting host")
+	ctx, cancel := context.WithTimeout(ctx, powerCycleTimeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("host %s did not come back after reboot: %w", host.Address, ctx.Err())
+		case <-time.After(hostPollInterval):
+		}
+		client, err := NewSSHClient(host)
+		if err != nil {
+			continue
+		}
+		current, _, err := client.RunCommand(ctx, bootIDCommand)
+		client.Close()
+		if err == nil && current != bootID {
+			p.emitEvent("info", host.Address, StepPatch, "Host is back after reboot")
+			return nil
+		}
+	}
+}
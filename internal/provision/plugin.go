@@ -0,0 +1,673 @@
+package provision
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Out-of-tree provisioners are executables named kubeforge-provisioner-<name>
+// in the plugin directory, registered as the provisioner <name>. Each
+// provisioner call runs the executable once: it reads a JSON-RPC 2.0 request
+// from stdin, may write "event" and "output" notifications to stdout, one
+// JSON object per line, and ends with the response to the request. Method
+// names and results are those of IProvisioner, parameters are named after
+// its arguments in snake case. Plugins answer methods they do not support
+// with the JSON-RPC error -32601, which callers see as ErrNotImplemented.
+const pluginPrefix = "kubeforge-provisioner-"
+
+// PluginProtocolVersion is the version of the protocol plugins must speak,
+// reported by their Describe method
+const PluginProtocolVersion = 1
+
+// describeTimeout bounds the Describe call made when a plugin is loaded and
+// the ValidateSpec call
+const describeTimeout = 10 * time.Second
+
+// maxPluginMessage bounds a line a plugin writes to stdout, e.g. a
+// kubeconfig in a result
+const maxPluginMessage = 16 << 20
+
+// maxPluginStderr is how much of the stderr of a failed plugin is kept for
+// the error
+const maxPluginStderr = 4 << 10
+
+// pluginEnv are the variables of the server environment passed to plugins,
+// besides those starting with KUBEFORGE_PLUGIN_. Secrets of the server, like
+// ENCRYPTION_KEY or DB_DSN, are not.
+var pluginEnv = []string{"PATH", "HOME", "TMPDIR", "LANG", "TZ", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"}
+
+// JSON-RPC error codes with a meaning to the server
+const (
+	pluginMethodNotFound = -32601
+	pluginInvalidParams  = -32602 // ValidateSpec rejected the spec
+)
+
+// PluginInfo describes a loaded provisioner plugin
+type PluginInfo struct {
+	Name            string `json:"name"`
+	Version         string `json:"version,omitempty"`
+	Description     string `json:"description,omitempty"`
+	ProtocolVersion int    `json:"protocol_version"`
+	Path            string `json:"-"`
+}
+
+var (
+	pluginMu sync.RWMutex
+	plugins  = make(map[string]PluginInfo)
+)
+
+// LoadPlugins registers the provisioner plugins of dir. Plugins that fail
+// their Describe call, speak another protocol version or are named like a
+// registered provisioner are skipped and reported in the error; the others
+// are still loaded.
+func LoadPlugins(dir string) ([]PluginInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	var loaded []PluginInfo
+	var errs []error
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+		if !ok || name == "" || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := loadPlugin(name, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("provisioner plugin %s: %w", entry.Name(), err))
+			continue
+		}
+		loaded = append(loaded, info)
+	}
+	return loaded, errors.Join(errs...)
+}
+
+// loadPlugin describes the plugin at path and registers it as name
+func loadPlugin(name, path string) (PluginInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return PluginInfo{}, err
+	}
+	if !stat.Mode().IsRegular() || stat.Mode().Perm()&0o111 == 0 {
+		return PluginInfo{}, errors.New("not an executable file")
+	}
+	if _, err := GetProvisioner(name, nil); err == nil {
+		return PluginInfo{}, fmt.Errorf("provisioner %s is already registered", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	p := &PluginProvisioner{name: name, path: path}
+	var info PluginInfo
+	if err := p.call(ctx, "Describe", struct{}{}, &info); err != nil {
+		return PluginInfo{}, err
+	}
+	if info.ProtocolVersion != PluginProtocolVersion {
+		return PluginInfo{}, fmt.Errorf("protocol version %d is not supported, expected %d", info.ProtocolVersion, PluginProtocolVersion)
+	}
+	info.Name, info.Path = name, path
+
+	pluginMu.Lock()
+	plugins[name] = info
+	pluginMu.Unlock()
+	RegisterProvisioner(name, func(config map[string]interface{}) (IProvisioner, error) {
+		return &PluginProvisioner{name: name, path: path, config: config}, nil
+	})
+	return info, nil
+}
+
+// Plugins returns the loaded provisioner plugins sorted by name
+func Plugins() []PluginInfo {
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+	list := make([]PluginInfo, 0, len(plugins))
+	for _, info := range plugins {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// PluginProvisioner implements IProvisioner by running an out-of-tree
+// provisioner executable
+type PluginProvisioner struct {
+	name   string
+	path   string
+	config map[string]interface{} // sent with each request
+
+	eventCallback  EventCallback
+	outputCallback OutputCallback
+}
+
+// SetEventCallback reports the events the plugin sends to callback
+func (p *PluginProvisioner) SetEventCallback(callback EventCallback) {
+	p.eventCallback = callback
+}
+
+// SetOutputCallback streams the command output the plugin sends to callback
+func (p *PluginProvisioner) SetOutputCallback(callback OutputCallback) {
+	p.outputCallback = callback
+}
+
+// Name returns the provisioner name
+func (p *PluginProvisioner) Name() string {
+	return p.name
+}
+
+// pluginRequest is the JSON-RPC request written to the stdin of a plugin
+type pluginRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// pluginMessage is a line a plugin writes: a notification or the response
+type pluginMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *pluginError    `json:"error,omitempty"`
+}
+
+// pluginError is the error of a JSON-RPC response
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// call runs the plugin for one method and decodes the result into result,
+// unless it is nil. Notifications are passed to the callbacks as they
+// arrive.
+func (p *PluginProvisioner) call(ctx context.Context, method string, params, result interface{}) error {
+	if p.config != nil {
+		params = withConfig(params, p.config)
+	}
+	request, err := json.Marshal(pluginRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(append(request, '\n'))
+	cmd.Env = p.environ()
+	cmd.WaitDelay = 5 * time.Second
+	stderr := &tailBuffer{max: maxPluginStderr}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: failed to run plugin: %w", p.name, err)
+	}
+
+	var response *pluginMessage
+	var protocolErr error
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), maxPluginMessage)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg pluginMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			protocolErr = fmt.Errorf("invalid message: %w", err)
+			continue
+		}
+		if msg.Method != "" && len(msg.ID) == 0 {
+			p.notify(msg)
+			continue
+		}
+		response = &msg
+	}
+	if err := scanner.Err(); err != nil {
+		// Keep the plugin from blocking on a full pipe
+		protocolErr = err
+		io.Copy(io.Discard, stdout)
+	}
+	waitErr := cmd.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if response == nil {
+		if protocolErr == nil {
+			protocolErr = errors.New("no response")
+		}
+		if waitErr != nil {
+			protocolErr = fmt.Errorf("%w, %v", protocolErr, waitErr)
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			protocolErr = fmt.Errorf("%w: %s", protocolErr, output)
+		}
+		return fmt.Errorf("%s: %s failed: %w", p.name, method, protocolErr)
+	}
+	if response.Error != nil {
+		switch response.Error.Code {
+		case pluginMethodNotFound:
+			return fmt.Errorf("%w: %s does not support %s", ErrNotImplemented, p.name, method)
+		case pluginInvalidParams:
+			return ErrInvalidSpec(response.Error.Message)
+		}
+		return fmt.Errorf("%s: %s", p.name, response.Error.Message)
+	}
+	if result != nil && len(response.Result) > 0 && string(response.Result) != "null" {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("%s: invalid result of %s: %w", p.name, method, err)
+		}
+	}
+	return nil
+}
+
+// notify passes a notification of the plugin to the callbacks
+func (p *PluginProvisioner) notify(msg pluginMessage) {
+	switch msg.Method {
+	case "event":
+		var event ProvisionEvent
+		if p.eventCallback == nil || json.Unmarshal(msg.Params, &event) != nil {
+			return
+		}
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		if event.Level == "" {
+			event.Level = "info"
+		}
+		p.eventCallback(event)
+	case "output":
+		var output CommandOutput
+		if p.outputCallback == nil || json.Unmarshal(msg.Params, &output) != nil {
+			return
+		}
+		output.Chunk, output.Output = redactOutput(output.Chunk), redactOutput(output.Output)
+		p.outputCallback(output)
+	}
+}
+
+// environ returns the environment of the plugin
+func (p *PluginProvisioner) environ() []string {
+	var env []string
+	for _, name := range pluginEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "KUBEFORGE_PLUGIN_") {
+			env = append(env, variable)
+		}
+	}
+	authMu.RLock()
+	if agentSocket != "" {
+		env = append(env, "SSH_AUTH_SOCK="+agentSocket)
+	}
+	authMu.RUnlock()
+	return append(env, "KUBEFORGE_PROVISIONER="+p.name)
+}
+
+// withConfig adds the provisioner config to the parameters of a request
+func withConfig(params interface{}, config map[string]interface{}) interface{} {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return params
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		return params
+	}
+	fields["config"] = config
+	return fields
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, data...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(data), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// pluginHost is a host as sent to plugins: its private key inlined, as
+// plugins cannot read stored keys or key files of the server, and whether
+// its host key is checked
+type pluginHost struct {
+	HostSpec
+	Bastion                  *pluginHost `json:"bastion,omitempty"`
+	InsecureSkipHostKeyCheck bool        `json:"insecure_skip_host_key_check,omitempty"`
+}
+
+// newPluginHost converts a host and its bastions for a plugin
+func newPluginHost(host HostSpec) (*pluginHost, error) {
+	key, err := privateKey(host)
+	if err != nil {
+		return nil, err
+	}
+	ph := &pluginHost{HostSpec: host, InsecureSkipHostKeyCheck: host.InsecureSkipHostKeyCheck}
+	ph.SSHKey, ph.SSHKeyPath, ph.SSHKeyID, ph.SSHKeyName = string(key), "", 0, ""
+	ph.HostSpec.Bastion = nil
+	if host.Bastion != nil {
+		if ph.Bastion, err = newPluginHost(*host.Bastion); err != nil {
+			return nil, err
+		}
+	}
+	return ph, nil
+}
+
+// newPluginHosts converts hosts for a plugin
+func newPluginHosts(hosts []HostSpec) ([]*pluginHost, error) {
+	converted := make([]*pluginHost, len(hosts))
+	for i, host := range hosts {
+		var err error
+		if converted[i], err = newPluginHost(host); err != nil {
+			return nil, err
+		}
+	}
+	return converted, nil
+}
+
+// pluginSpec is a cluster spec as sent to plugins
+type pluginSpec struct {
+	ClusterSpec
+	ControlPlanes []*pluginHost `json:"control_planes"`
+	Workers       []*pluginHost `json:"workers"`
+}
+
+// newPluginSpec converts a cluster spec for a plugin
+func newPluginSpec(spec ClusterSpec) (*pluginSpec, error) {
+	ps := &pluginSpec{ClusterSpec: spec}
+	var err error
+	if ps.ControlPlanes, err = newPluginHosts(spec.ControlPlanes); err != nil {
+		return nil, err
+	}
+	if ps.Workers, err = newPluginHosts(spec.Workers); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// ValidateSpec lets the plugin check the cluster specification. Plugins
+// without the method get the checks of the built-in provisioner.
+func (p *PluginProvisioner) ValidateSpec(spec *ClusterSpec) error {
+	ps, err := newPluginSpec(*spec)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	err = p.call(ctx, "ValidateSpec", map[string]interface{}{"spec": ps}, nil)
+	if errors.Is(err, ErrNotImplemented) {
+		return spec.Validate()
+	}
+	return err
+}
+
+// PrepareHosts prepares hosts for cluster installation
+func (p *PluginProvisioner) PrepareHosts(ctx context.Context, hosts []HostSpec, runtime string, k8sVersion string) error {
+	converted, err := newPluginHosts(hosts)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "PrepareHosts", map[string]interface{}{"hosts": converted, "runtime": runtime, "k8s_version": k8sVersion}, nil)
+}
+
+// BootstrapControlPlane initializes the first control plane node
+func (p *PluginProvisioner) BootstrapControlPlane(ctx context.Context, host HostSpec, spec ClusterSpec) (*ProvisionResult, error) {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := newPluginSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Kubeconfig     []byte            `json:"kubeconfig"`
+		JoinCommand    string            `json:"join_command"`
+		JoinToken      string            `json:"join_token"`
+		CertificateKey string            `json:"certificate_key"`
+		Nodes          []NodeInfo        `json:"nodes"`
+		Metadata       map[string]string `json:"metadata"`
+	}
+	if err := p.call(ctx, "BootstrapControlPlane", map[string]interface{}{"host": ph, "spec": ps}, &result); err != nil {
+		return nil, err
+	}
+	return &ProvisionResult{
+		Kubeconfig:     result.Kubeconfig,
+		JoinCommand:    result.JoinCommand,
+		JoinToken:      result.JoinToken,
+		CertificateKey: result.CertificateKey,
+		Nodes:          result.Nodes,
+		Metadata:       result.Metadata,
+	}, nil
+}
+
+// InstallCNI installs the CNI plugin
+func (p *PluginProvisioner) InstallCNI(ctx context.Context, kubeconfig []byte, cni string, controlPlane HostSpec) error {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "InstallCNI", map[string]interface{}{"kubeconfig": kubeconfig, "cni": cni, "control_plane": cp}, nil)
+}
+
+// CheckNetwork tests the pod network. Failed checks are diagnosed like
+// those of the built-in provisioner.
+func (p *PluginProvisioner) CheckNetwork(ctx context.Context, controlPlane HostSpec) ([]NetworkCheck, error) {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Checks []NetworkCheck `json:"checks"`
+	}
+	if err := p.call(ctx, "CheckNetwork", map[string]interface{}{"control_plane": cp}, &result); err != nil {
+		return nil, err
+	}
+	return result.Checks, NetworkDiagnosis(result.Checks)
+}
+
+// JoinControlPlane joins an additional control plane node
+func (p *PluginProvisioner) JoinControlPlane(ctx context.Context, host HostSpec, joinCommand string, certificateKey string) error {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "JoinControlPlane", map[string]interface{}{"host": ph, "join_command": joinCommand, "certificate_key": certificateKey}, nil)
+}
+
+// JoinWorker joins a worker node
+func (p *PluginProvisioner) JoinWorker(ctx context.Context, host HostSpec, joinCommand string) error {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "JoinWorker", map[string]interface{}{"host": ph, "join_command": joinCommand}, nil)
+}
+
+// VerifyCluster smoke tests a provisioned cluster
+func (p *PluginProvisioner) VerifyCluster(ctx context.Context, controlPlane HostSpec) ([]SmokeCheck, error) {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Checks []SmokeCheck `json:"checks"`
+	}
+	if err := p.call(ctx, "VerifyCluster", map[string]interface{}{"control_plane": cp}, &result); err != nil {
+		return nil, err
+	}
+	return result.Checks, nil
+}
+
+// GetClusterInfo retrieves current cluster information
+func (p *PluginProvisioner) GetClusterInfo(ctx context.Context, kubeconfig []byte) (*ClusterInfo, error) {
+	var info ClusterInfo
+	if err := p.call(ctx, "GetClusterInfo", map[string]interface{}{"kubeconfig": kubeconfig}, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// DestroyCluster removes the cluster from all hosts
+func (p *PluginProvisioner) DestroyCluster(ctx context.Context, spec ClusterSpec) error {
+	ps, err := newPluginSpec(spec)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "DestroyCluster", map[string]interface{}{"spec": ps}, nil)
+}
+
+// RemoveNode removes a single node from the cluster
+func (p *PluginProvisioner) RemoveNode(ctx context.Context, host HostSpec, controlPlane HostSpec) error {
+	return p.callNode(ctx, "RemoveNode", host, controlPlane, nil)
+}
+
+// CordonNode marks a node unschedulable, or schedulable again
+func (p *PluginProvisioner) CordonNode(ctx context.Context, kubeconfig []byte, host HostSpec, cordon bool) error {
+	return p.callKubeNode(ctx, "CordonNode", kubeconfig, host, map[string]interface{}{"cordon": cordon})
+}
+
+// DrainNode cordons a node and evicts its pods
+func (p *PluginProvisioner) DrainNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts DrainOptions) error {
+	return p.callKubeNode(ctx, "DrainNode", kubeconfig, host, map[string]interface{}{"options": opts})
+}
+
+// PatchNode applies operating system updates to a node
+func (p *PluginProvisioner) PatchNode(ctx context.Context, kubeconfig []byte, host HostSpec, opts PatchOptions) error {
+	return p.callKubeNode(ctx, "PatchNode", kubeconfig, host, map[string]interface{}{"options": opts})
+}
+
+// callKubeNode runs a method acting on a node through the API server of
+// the kubeconfig
+func (p *PluginProvisioner) callKubeNode(ctx context.Context, method string, kubeconfig []byte, host HostSpec, params map[string]interface{}) error {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return err
+	}
+	params["host"], params["kubeconfig"] = ph, kubeconfig
+	return p.call(ctx, method, params, nil)
+}
+
+// callNode runs a method acting on a node through the control plane
+func (p *PluginProvisioner) callNode(ctx context.Context, method string, host, controlPlane HostSpec, params map[string]interface{}) error {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return err
+	}
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["host"], params["control_plane"] = ph, cp
+	return p.call(ctx, method, params, nil)
+}
+
+// CreateCredential creates a service account bound to a cluster role and
+// returns a token of it
+func (p *PluginProvisioner) CreateCredential(ctx context.Context, controlPlane HostSpec, name, clusterRole string, ttl time.Duration) (string, error) {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	err = p.call(ctx, "CreateCredential", map[string]interface{}{"control_plane": cp, "name": name, "cluster_role": clusterRole, "ttl": Duration(ttl)}, &result)
+	return result.Token, err
+}
+
+// RevokeCredential deletes the service account of a credential
+func (p *PluginProvisioner) RevokeCredential(ctx context.Context, controlPlane HostSpec, name string) error {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "RevokeCredential", map[string]interface{}{"control_plane": cp, "name": name}, nil)
+}
+
+// GenerateJoinToken returns the join command of a worker
+func (p *PluginProvisioner) GenerateJoinToken(ctx context.Context, controlPlane HostSpec) (string, error) {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		JoinCommand string `json:"join_command"`
+	}
+	err = p.call(ctx, "GenerateJoinToken", map[string]interface{}{"control_plane": cp}, &result)
+	return result.JoinCommand, err
+}
+
+// UploadCertificates uploads the control plane certificates and returns
+// the new certificate key
+func (p *PluginProvisioner) UploadCertificates(ctx context.Context, controlPlane HostSpec) (string, error) {
+	cp, err := newPluginHost(controlPlane)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		CertificateKey string `json:"certificate_key"`
+	}
+	err = p.call(ctx, "UploadCertificates", map[string]interface{}{"control_plane": cp}, &result)
+	return result.CertificateKey, err
+}
+
+// RenewCertificates renews the certificates of a control plane node and
+// returns the renewed admin kubeconfig
+func (p *PluginProvisioner) RenewCertificates(ctx context.Context, host HostSpec) ([]byte, error) {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Kubeconfig []byte `json:"kubeconfig"`
+	}
+	if err := p.call(ctx, "RenewCertificates", map[string]interface{}{"host": ph}, &result); err != nil {
+		return nil, err
+	}
+	return result.Kubeconfig, nil
+}
+
+// UpgradeNode upgrades Kubernetes on a single node
+func (p *PluginProvisioner) UpgradeNode(ctx context.Context, host HostSpec, k8sVersion string, first bool) error {
+	ph, err := newPluginHost(host)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, "UpgradeNode", map[string]interface{}{"host": ph, "k8s_version": k8sVersion, "first": first}, nil)
+}
@@ -0,0 +1,132 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how transient failures of SSH connects, package
+// installs and joins are retried
+type RetryPolicy struct {
+	Attempts       int           // total attempts, 1 disables retries
+	InitialBackoff time.Duration // wait before the first retry
+	MaxBackoff     time.Duration // upper bound of the exponential backoff
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       3,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+var (
+	retryMu     sync.RWMutex
+	retryPolicy = DefaultRetryPolicy
+)
+
+// SetRetryPolicy configures the retry policy used by provisioners
+func SetRetryPolicy(policy RetryPolicy) {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+
+	retryMu.Lock()
+	retryPolicy = policy
+	retryMu.Unlock()
+}
+
+// currentRetryPolicy returns the configured retry policy
+func currentRetryPolicy() RetryPolicy {
+	retryMu.RLock()
+	defer retryMu.RUnlock()
+	return retryPolicy
+}
+
+// transientMessages are error output fragments of failures worth retrying:
+// network blips, package manager lock contention and unreachable mirrors
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"i/o timeout",
+	"no route to host",
+	"network is unreachable",
+	"broken pipe",
+	"handshake failed: eof",
+	"temporary failure resolving",
+	"temporary failure in name resolution",
+	"could not resolve host",
+	"could not get lock",
+	"unable to acquire the dpkg frontend lock",
+	"is another process using it",
+	"another app is currently holding the yum lock",
+	"failed to fetch",
+	"hash sum mismatch",
+	"context deadline exceeded",
+	"tls handshake timeout",
+}
+
+// IsRetryable reports whether err looks like a transient failure. Cancelled
+// operations, authentication failures, untrusted host keys and missing root
+// rights are never retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrUntrustedHostKey) || errors.Is(err, ErrNotRoot) {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "failed to parse ssh key") {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, ErrConnectionFailed) {
+		return true
+	}
+
+	for _, fragment := range transientMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry runs fn until it succeeds, fails with a non-retryable error or the
+// policy runs out of attempts. onRetry, if set, is called before each wait.
+func Retry(ctx context.Context, policy RetryPolicy, onRetry func(attempt int, err error, wait time.Duration), fn func() error) error {
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err, backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// scriptFS holds the host scripts. A script is looked up as
+// scripts/<family>/<name>.sh.tmpl, then scripts/common/<name>.sh.tmpl, and
+// rendered after scripts/header.sh.tmpl. The partials directories define
+// templates shared by the common scripts and by the scripts of a family.
+//
+//go:embed scripts
+var scriptFS embed.FS
+
+// remoteScriptDir is where scripts are uploaded before they run
+const remoteScriptDir = "/var/lib/kubeforge/scripts"
+
+// OS families scripts are written for
+const (
+	OSFamilyDebian = "debian"
+	OSFamilyRHEL   = "rhel"
+)
+
+// Platform describes the operating system of a host
+type Platform struct {
+	OS       string // ID of /etc/os-release, e.g. ubuntu or rocky
+	OSFamily string // debian or rhel
+	Arch     string // amd64 or arm64
+}
+
+// ProxySettings are the proxies hosts use to reach package repositories and
+// image registries
+type ProxySettings struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string
+}
+
+var (
+	proxyMu sync.RWMutex
+	proxy   ProxySettings
+)
+
+// SetProxy configures the proxies passed to host scripts
+func SetProxy(settings ProxySettings) {
+	proxyMu.Lock()
+	proxy = settings
+	proxyMu.Unlock()
+}
+
+// ScriptParams are the values host scripts are rendered with
+type ScriptParams struct {
+	Platform
+	Name              string   // name of the script
+	KubernetesVersion string   // e.g. 1.28.3
+	KubernetesMinor   string   // e.g. 1.28
+	Packages          []string // packages to upgrade, all when empty
+	Proxy             ProxySettings
+}
+
+// newScriptParams returns the parameters of scripts for a host platform and
+// Kubernetes version (major.minor or major.minor.patch)
+func newScriptParams(platform Platform, k8sVersion string) (ScriptParams, error) {
+	params := ScriptParams{Platform: platform, KubernetesVersion: strings.TrimPrefix(k8sVersion, "v")}
+	if k8sVersion != "" {
+		parts := strings.Split(params.KubernetesVersion, ".")
+		if len(parts) < 2 {
+			return params, fmt.Errorf("invalid k8s version format: %s", k8sVersion)
+		}
+		params.KubernetesMinor = parts[0] + "." + parts[1]
+	}
+
+	proxyMu.RLock()
+	params.Proxy = proxy
+	proxyMu.RUnlock()
+	return params, nil
+}
+
+// RenderScript renders the script name for the OS family of params
+func RenderScript(name string, params ScriptParams) ([]byte, error) {
+	file := path.Join("scripts", params.OSFamily, name+".sh.tmpl")
+	if _, err := fs.Stat(scriptFS, file); err != nil {
+		file = path.Join("scripts", "common", name+".sh.tmpl")
+		if _, err := fs.Stat(scriptFS, file); err != nil {
+			return nil, fmt.Errorf("no %s script for OS family %q", name, params.OSFamily)
+		}
+	}
+
+	files := []string{"scripts/header.sh.tmpl"}
+	for _, dir := range []string{"scripts/common", path.Join("scripts", params.OSFamily)} {
+		partials, err := fs.Glob(scriptFS, dir+"/partials/*.sh.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, partials...)
+	}
+	files = append(files, file)
+
+	tmpl, err := template.New("").Funcs(template.FuncMap{"quote": ShellQuote}).Option("missingkey=error").ParseFS(scriptFS, files...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s script: %w", name, err)
+	}
+
+	params.Name = name
+	var buf bytes.Buffer
+	for _, t := range []string{"header.sh.tmpl", path.Base(file)} {
+		if err := tmpl.ExecuteTemplate(&buf, t, params); err != nil {
+			return nil, fmt.Errorf("failed to render %s script: %w", name, err)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// DetectPlatform reads the operating system and architecture of the host
+func (c *SSHClient) DetectPlatform(ctx context.Context) (Platform, error) {
+	stdout, stderr, err := c.runAsUser(ctx, `. /etc/os-release && echo "$ID" && echo "${ID_LIKE:-}" && uname -m`, nil)
+	if err != nil {
+		return Platform{}, fmt.Errorf("failed to detect operating system: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 {
+		return Platform{}, fmt.Errorf("failed to detect operating system: unexpected output %q", stdout)
+	}
+
+	return NewPlatform(strings.TrimSpace(lines[0]), strings.Fields(lines[1]), strings.TrimSpace(lines[2]))
+}
+
+// NewPlatform returns the platform of an operating system by its ID and
+// ID_LIKE in /etc/os-release and a machine name as printed by uname -m
+func NewPlatform(osID string, like []string, machine string) (Platform, error) {
+	platform := Platform{OS: osID}
+	for _, id := range append([]string{platform.OS}, like...) {
+		switch id {
+		case "debian", "ubuntu":
+			platform.OSFamily = OSFamilyDebian
+		case "rhel", "centos", "fedora", "rocky", "almalinux":
+			platform.OSFamily = OSFamilyRHEL
+		}
+		if platform.OSFamily != "" {
+			break
+		}
+	}
+	if platform.OSFamily == "" {
+		return platform, fmt.Errorf("unsupported operating system %q", platform.OS)
+	}
+
+	switch machine {
+	case "x86_64", "amd64":
+		platform.Arch = "amd64"
+	case "aarch64", "arm64":
+		platform.Arch = "arm64"
+	default:
+		return platform, fmt.Errorf("unsupported architecture %q", machine)
+	}
+	return platform, nil
+}
+
+// UploadScript uploads a rendered script and returns the command running
+// it. The command checks the checksum of the script before running it and
+// removes it afterwards, exiting with the status of the script.
+func (c *SSHClient) UploadScript(ctx context.Context, name string, script []byte) (string, error) {
+	sum := sha256.Sum256(script)
+	checksum := hex.EncodeToString(sum[:])
+	remotePath := path.Join(remoteScriptDir, name+"-"+checksum[:12]+".sh")
+
+	if err := c.UploadContent(ctx, script, remotePath, 0700); err != nil {
+		return "", fmt.Errorf("failed to upload %s script: %w", name, err)
+	}
+
+	quoted := ShellQuote(remotePath)
+	return fmt.Sprintf("echo %s | sha256sum -c --status || { echo 'checksum of %s script does not match' >&2; exit 1; }; sh %s; status=$?; rm -f %s; exit $status",
+		ShellQuote(checksum+"  "+remotePath), name, quoted, quoted), nil
+}
@@ -0,0 +1,335 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrChecksumMismatch is returned when a transferred file differs from its
+// source
+var ErrChecksumMismatch = errors.New("checksum mismatch after transfer")
+
+// partSuffix marks files being uploaded next to their destination
+const partSuffix = ".kubeforge-part"
+
+// UploadFile uploads a file or a directory tree to the remote host over
+// SFTP. Files keep their permissions, are owned by root and are verified
+// with SHA-256 before they are moved into place. An upload interrupted
+// earlier resumes from the data already on the host.
+func (c *SSHClient) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+
+	client, done, err := c.sftpClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if !info.IsDir() {
+		return c.uploadFile(ctx, client, localPath, remotePath, info)
+	}
+
+	return filepath.WalkDir(localPath, func(local string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, local)
+		if err != nil {
+			return err
+		}
+		remote := path.Join(remotePath, filepath.ToSlash(rel))
+
+		// Symlinks are uploaded as the files they point to
+		info, err := os.Stat(local)
+		if err != nil {
+			return fmt.Errorf("failed to read local file: %w", err)
+		}
+		switch {
+		case info.IsDir():
+			_, stderr, err := c.RunCommand(ctx, fmt.Sprintf("install -d -m %o %s", info.Mode().Perm(), ShellQuote(remote)))
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %s: %w", remote, strings.TrimSpace(stderr), err)
+			}
+			return nil
+		case info.Mode().IsRegular():
+			return c.uploadFile(ctx, client, local, remote, info)
+		default:
+			return fmt.Errorf("cannot upload %s: not a regular file or directory", local)
+		}
+	})
+}
+
+// UploadContent uploads content as a file with the given permissions, like
+// UploadFile
+func (c *SSHClient) UploadContent(ctx context.Context, content []byte, remotePath string, mode os.FileMode) error {
+	local, err := os.CreateTemp("", "kubeforge-upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(local.Name())
+	if _, err := local.Write(content); err != nil {
+		local.Close()
+		return err
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(local.Name(), mode); err != nil {
+		return err
+	}
+	return c.UploadFile(ctx, local.Name(), remotePath)
+}
+
+// uploadFile uploads a regular file to a staging path, verifies it and moves
+// it into place as root
+func (c *SSHClient) uploadFile(ctx context.Context, client *sftp.Client, localPath, remotePath string, info os.FileInfo) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	defer local.Close()
+
+	sum, err := sha256Prefix(local, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+
+	staging := c.stagingPath(remotePath)
+	if !c.host.UseSudo {
+		if _, stderr, err := c.RunCommand(ctx, "mkdir -p "+ShellQuote(path.Dir(remotePath))); err != nil {
+			return fmt.Errorf("failed to create %s: %s: %w", path.Dir(remotePath), strings.TrimSpace(stderr), err)
+		}
+	}
+
+	offset := c.resumeOffset(ctx, client, local, staging, info.Size())
+	if err := c.copyToRemote(ctx, client, local, staging, offset); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+
+	remoteSum, err := c.remoteSHA256(ctx, staging, -1)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", remotePath, err)
+	}
+	if remoteSum != sum {
+		client.Remove(staging)
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, remotePath)
+	}
+
+	if err := client.Chmod(staging, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", remotePath, err)
+	}
+	dst := ShellQuote(remotePath)
+	_, stderr, err := c.RunCommand(ctx, fmt.Sprintf("mkdir -p %s && mv -f %s %s && chown root:root %s",
+		ShellQuote(path.Dir(remotePath)), ShellQuote(staging), dst, dst))
+	if err != nil {
+		return fmt.Errorf("failed to install %s: %s: %w", remotePath, strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// stagingPath returns where a file is uploaded before it is moved into
+// place. It is stable, so a later attempt finds the data already sent. Hosts
+// using sudo stage files where the SSH user can write.
+func (c *SSHClient) stagingPath(remotePath string) string {
+	if !c.host.UseSudo {
+		return remotePath + partSuffix
+	}
+	id := sha256.Sum256([]byte(remotePath))
+	return "/var/tmp/kubeforge-upload-" + hex.EncodeToString(id[:8]) + partSuffix
+}
+
+// resumeOffset returns how much of the local file the staging file already
+// holds, or 0 when it is missing or differs from the local file
+func (c *SSHClient) resumeOffset(ctx context.Context, client *sftp.Client, local *os.File, staging string, size int64) int64 {
+	st, err := client.Stat(staging)
+	if err != nil || st.Size() == 0 || st.Size() > size {
+		return 0
+	}
+	offset := st.Size()
+
+	localSum, err := sha256Prefix(local, offset)
+	if err != nil {
+		return 0
+	}
+	remoteSum, err := c.remoteSHA256(ctx, staging, offset)
+	if err != nil || remoteSum != localSum {
+		return 0
+	}
+
+	slog.Info("Resuming upload", "host", c.host.Address, "path", staging, "offset", offset, "size", size)
+	return offset
+}
+
+// copyToRemote writes the local file from offset on to the remote path
+func (c *SSHClient) copyToRemote(ctx context.Context, client *sftp.Client, local *os.File, remotePath string, offset int64) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	remote, err := client.OpenFile(remotePath, flags)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := remote.ReadFrom(contextReader{ctx: ctx, r: local}); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return remote.Close()
+}
+
+// remoteSHA256 hashes a remote file as the SSH user, only its first n bytes
+// when n is not negative
+func (c *SSHClient) remoteSHA256(ctx context.Context, remotePath string, n int64) (string, error) {
+	command := "sha256sum " + ShellQuote(remotePath)
+	if n >= 0 {
+		command = fmt.Sprintf("head -c %d %s | sha256sum", n, ShellQuote(remotePath))
+	}
+	stdout, stderr, err := c.runAsUser(ctx, command, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(stderr), err)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("sha256sum returned no output")
+	}
+	return fields[0], nil
+}
+
+// DownloadFile downloads a file from the remote host and verifies it with
+// SHA-256. Hosts using sudo read the file as root, as it may not be readable
+// by the SSH user.
+func (c *SSHClient) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	part := localPath + partSuffix
+	local, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer os.Remove(part)
+	defer local.Close()
+
+	hash := sha256.New()
+	if err := c.copyFromRemote(ctx, remotePath, io.MultiWriter(local, hash)); err != nil {
+		return fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+
+	stdout, stderr, err := c.RunCommand(ctx, "sha256sum "+ShellQuote(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %s: %w", remotePath, strings.TrimSpace(stderr), err)
+	}
+	if fields := strings.Fields(stdout); len(fields) == 0 || fields[0] != hex.EncodeToString(hash.Sum(nil)) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, remotePath)
+	}
+
+	if err := os.Chmod(part, 0644); err != nil {
+		return err
+	}
+	return os.Rename(part, localPath)
+}
+
+// copyFromRemote writes a remote file to w
+func (c *SSHClient) copyFromRemote(ctx context.Context, remotePath string, w io.Writer) error {
+	if c.host.UseSudo {
+		session, err := c.client.NewSession()
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		defer session.Close()
+
+		var stdout, stderr bytes.Buffer
+		session.Stdout = w
+		session.Stderr = &stderr
+		command := c.asRoot(session, "cat "+ShellQuote(remotePath), nil)
+		if err := runSession(ctx, session, command, &stdout, &stderr, nil); err != nil {
+			return fmt.Errorf("%s: %w", strings.TrimSpace(stderr.String()), err)
+		}
+		return nil
+	}
+
+	client, done, err := c.sftpClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	remote, err := client.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	if _, err := remote.WriteTo(w); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// sftpClient opens an SFTP session, which is closed when ctx is done. The
+// returned function closes it.
+func (c *SSHClient) sftpClient(ctx context.Context) (*sftp.Client, func(), error) {
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	return client, func() {
+		stop()
+		client.Close()
+	}, nil
+}
+
+// sha256Prefix hashes the first n bytes of a file
+func sha256Prefix(f *os.File, n int64) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contextReader stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
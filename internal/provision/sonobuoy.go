@@ -0,0 +1,204 @@
+package provision
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SonobuoyVersion is the Sonobuoy release installed on the control plane to
+// run conformance tests when it is not installed there already
+const SonobuoyVersion = "0.57.3"
+
+// Sonobuoy modes of running the e2e conformance tests
+const (
+	SonobuoyModeQuick     = "quick"                 // a single test, checks that Sonobuoy works
+	SonobuoyModeCertified = "certified-conformance" // the full conformance suite, takes hours
+)
+
+// sonobuoyResultsDir is where the results tarball is retrieved to on the
+// control plane before it is downloaded
+const sonobuoyResultsDir = "/var/tmp/kubeforge-sonobuoy"
+
+// Aggregate statuses of a Sonobuoy run
+const (
+	SonobuoyRunning  = "running"
+	SonobuoyComplete = "complete"
+	SonobuoyFailed   = "failed"
+)
+
+// SonobuoyStatus is the progress of a Sonobuoy run in the cluster
+type SonobuoyStatus struct {
+	Status    string // pending, running, post-processing, complete or failed
+	Completed int    // e2e tests run so far
+	Total     int    // e2e tests to run, 0 until the plugin reports it
+	Failures  int
+}
+
+// ConformanceSummary is the outcome of the e2e plugin of a Sonobuoy run
+type ConformanceSummary struct {
+	Status      string   `json:"status"` // passed or failed
+	Total       int      `json:"total"`
+	Passed      int      `json:"passed"`
+	Failed      int      `json:"failed"`
+	Skipped     int      `json:"skipped"`
+	FailedTests []string `json:"failed_tests,omitempty"`
+}
+
+// sonobuoyCommand returns a sonobuoy command against the admin kubeconfig
+func sonobuoyCommand(args string) string {
+	return "sonobuoy --kubeconfig " + adminKubeconfigPath + " " + args
+}
+
+// InstallSonobuoy installs the Sonobuoy CLI on a control plane unless it is
+// installed already
+func InstallSonobuoy(ctx context.Context, client *SSHClient) error {
+	if _, _, err := client.RunCommand(ctx, "command -v sonobuoy"); err == nil {
+		return nil
+	}
+	platform, err := client.DetectPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://github.com/vmware-tanzu/sonobuoy/releases/download/v%[1]s/sonobuoy_%[1]s_linux_%[2]s.tar.gz", SonobuoyVersion, platform.Arch)
+	install := "curl -fsSL " + ShellQuote(url) + " | tar -xz -C /usr/local/bin sonobuoy && chmod 755 /usr/local/bin/sonobuoy"
+	if _, stderr, err := client.RunCommand(ctx, install); err != nil {
+		return fmt.Errorf("failed to install sonobuoy %s: %w", SonobuoyVersion, stderrError(stderr, err))
+	}
+	return nil
+}
+
+// StartSonobuoy starts the e2e conformance tests in a mode without waiting
+// for them, removing what an earlier run left first
+func StartSonobuoy(ctx context.Context, client *SSHClient, mode string) error {
+	if _, stderr, err := client.RunCommand(ctx, sonobuoyCommand("delete --wait")); err != nil {
+		return fmt.Errorf("failed to clean up sonobuoy: %w", stderrError(stderr, err))
+	}
+	if _, stderr, err := client.RunCommand(ctx, sonobuoyCommand("run --mode "+ShellQuote(mode))); err != nil {
+		return fmt.Errorf("failed to start sonobuoy: %w", stderrError(stderr, err))
+	}
+	return nil
+}
+
+// GetSonobuoyStatus reads the progress of the Sonobuoy run in the cluster.
+// It fails when Sonobuoy is not running there.
+func GetSonobuoyStatus(ctx context.Context, client *SSHClient) (SonobuoyStatus, error) {
+	stdout, stderr, err := client.RunCommand(ctx, sonobuoyCommand("status --json"))
+	if err != nil {
+		return SonobuoyStatus{}, fmt.Errorf("failed to read sonobuoy status: %w", stderrError(stderr, err))
+	}
+	return ParseSonobuoyStatus([]byte(stdout))
+}
+
+// ParseSonobuoyStatus reads the output of sonobuoy status --json, with the
+// progress of the e2e plugin
+func ParseSonobuoyStatus(output []byte) (SonobuoyStatus, error) {
+	var report struct {
+		Status  string `json:"status"`
+		Plugins []struct {
+			Plugin   string `json:"plugin"`
+			Progress *struct {
+				Total     int      `json:"total"`
+				Completed int      `json:"completed"`
+				Failures  []string `json:"failures"`
+			} `json:"progress"`
+		} `json:"plugins"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return SonobuoyStatus{}, fmt.Errorf("invalid sonobuoy status: %w", err)
+	}
+	status := SonobuoyStatus{Status: report.Status}
+	for _, plugin := range report.Plugins {
+		if plugin.Plugin != "e2e" || plugin.Progress == nil {
+			continue
+		}
+		status.Completed = plugin.Progress.Completed
+		status.Total = plugin.Progress.Total
+		status.Failures = len(plugin.Progress.Failures)
+	}
+	return status, nil
+}
+
+// RetrieveSonobuoy downloads the results tarball of a finished run and
+// summarizes the results of its e2e plugin
+func RetrieveSonobuoy(ctx context.Context, client *SSHClient) ([]byte, ConformanceSummary, error) {
+	defer client.RunCommand(context.Background(), "rm -rf "+sonobuoyResultsDir)
+
+	path, stderr, err := client.RunCommand(ctx, "rm -rf "+sonobuoyResultsDir+" && mkdir -p "+sonobuoyResultsDir+" && "+sonobuoyCommand("retrieve "+sonobuoyResultsDir))
+	if err != nil {
+		return nil, ConformanceSummary{}, fmt.Errorf("failed to retrieve sonobuoy results: %w", stderrError(stderr, err))
+	}
+	path = ShellQuote(strings.TrimSpace(path))
+
+	report, stderr, err := client.RunCommand(ctx, "sonobuoy results --plugin e2e "+path)
+	if err != nil {
+		return nil, ConformanceSummary{}, fmt.Errorf("failed to read sonobuoy results: %w", stderrError(stderr, err))
+	}
+	summary := ParseSonobuoyResults(report)
+
+	encoded, stderr, err := client.RunCommand(ctx, "base64 -w0 "+path)
+	if err != nil {
+		return nil, summary, fmt.Errorf("failed to download sonobuoy results: %w", stderrError(stderr, err))
+	}
+	tarball, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, summary, fmt.Errorf("failed to download sonobuoy results: %w", err)
+	}
+	return tarball, summary, nil
+}
+
+// ParseSonobuoyResults reads the report of sonobuoy results of a plugin:
+// "Key: value" counters followed by the names of the failed tests
+func ParseSonobuoyResults(report string) ConformanceSummary {
+	var summary ConformanceSummary
+	failedTests := false
+	scanner := bufio.NewScanner(strings.NewReader(report))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if failedTests {
+			if line == "" || strings.HasSuffix(line, ":") {
+				failedTests = false
+			} else {
+				summary.FailedTests = append(summary.FailedTests, line)
+			}
+			continue
+		}
+		if line == "Failed tests:" {
+			failedTests = true
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		count, _ := strconv.Atoi(value)
+		switch key {
+		case "Status":
+			if summary.Status == "" {
+				summary.Status = value
+			}
+		case "Total":
+			summary.Total = count
+		case "Passed":
+			summary.Passed = count
+		case "Failed":
+			summary.Failed = count
+		case "Skipped":
+			summary.Skipped = count
+		}
+	}
+	return summary
+}
+
+// DeleteSonobuoy removes Sonobuoy and its namespaces from the cluster
+func DeleteSonobuoy(ctx context.Context, client *SSHClient) error {
+	if _, stderr, err := client.RunCommand(ctx, sonobuoyCommand("delete --wait")); err != nil {
+		return fmt.Errorf("failed to delete sonobuoy: %w", stderrError(stderr, err))
+	}
+	return nil
+}
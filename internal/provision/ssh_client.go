@@ -3,208 +3,317 @@ package provision
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"kubeforge/internal/tracing"
 )
 
 // SSHClient wraps an SSH connection to a remote host
 type SSHClient struct {
 	client *ssh.Client
 	host   HostSpec
+	jump   *SSHClient // bastion the connection is tunneled through
 }
 
-// NewSSHClient creates a new SSH client connection
-func NewSSHClient(host HostSpec) (*SSHClient, error) {
-	// Read SSH key
-	var key []byte
-	var err error
+// KeyResolver returns the private key of a stored SSH key referenced by ID
+// or, when id is 0, by name
+type KeyResolver func(id uint, name string) ([]byte, error)
 
-	if host.SSHKey != "" {
-		key = []byte(host.SSHKey)
-	} else if host.SSHKeyPath != "" {
-		key, err = os.ReadFile(host.SSHKeyPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read SSH key from %s: %w", host.SSHKeyPath, err)
-		}
-	} else {
-		return nil, fmt.Errorf("no SSH key provided for host %s", host.Address)
-	}
+var (
+	authMu      sync.RWMutex
+	keyResolver KeyResolver
+	agentSocket = os.Getenv("SSH_AUTH_SOCK")
+)
 
-	// Parse SSH private key
+// SetKeyResolver configures how the keys of hosts referencing stored SSH
+// keys are looked up
+func SetKeyResolver(resolver KeyResolver) {
+	authMu.Lock()
+	keyResolver = resolver
+	authMu.Unlock()
+}
+
+// SetAgentSocket configures the socket of the SSH agent used by hosts with
+// ssh_agent set, SSH_AUTH_SOCK by default
+func SetAgentSocket(path string) {
+	authMu.Lock()
+	agentSocket = path
+	authMu.Unlock()
+}
+
+// Errors parsing encrypted SSH keys
+var (
+	ErrPassphraseRequired = errors.New("SSH key is encrypted, set ssh_key_passphrase")
+	ErrWrongPassphrase    = errors.New("SSH key passphrase is incorrect")
+)
+
+// ParsePrivateKey parses a PEM or OpenSSH private key, decrypting it with
+// passphrase when it is encrypted. The passphrase of an unencrypted key is
+// ignored.
+func ParsePrivateKey(key []byte, passphrase string) (ssh.Signer, error) {
 	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, ErrWrongPassphrase
+	}
+	return signer, err
+}
+
+// NewSSHClient creates a new SSH client connection, through the bastion of
+// the host if it has one
+func NewSSHClient(host HostSpec) (*SSHClient, error) {
+	auth, closeAgent, err := authMethods(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+		return nil, err
+	}
+	// The agent is only needed to sign during the handshake
+	defer closeAgent()
+
+	verifyHostKey, err := hostKeyCallback(host)
+	if err != nil {
+		return nil, err
 	}
 
 	// Configure SSH client
 	config := &ssh.ClientConfig{
-		User: host.User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Add proper host key verification
+		User:            host.User,
+		Auth:            auth,
+		HostKeyCallback: verifyHostKey,
 		Timeout:         30 * time.Second,
 	}
 
 	// Connect to the remote host
 	addr := fmt.Sprintf("%s:%d", host.Address, host.Port)
-	client, err := ssh.Dial("tcp", addr, config)
+	if host.Bastion == nil {
+		client, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		return &SSHClient{client: client, host: host}, nil
+	}
+
+	jump, err := NewSSHClient(*host.Bastion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bastion of %s: %w", host.Address, err)
+	}
+	conn, err := jump.client.Dial("tcp", addr)
+	if err != nil {
+		jump.Close()
+		return nil, fmt.Errorf("failed to connect to %s through bastion %s: %w", addr, host.Bastion.Address, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		conn.Close()
+		jump.Close()
+		return nil, fmt.Errorf("failed to connect to %s through bastion %s: %w", addr, host.Bastion.Address, err)
 	}
 
 	return &SSHClient{
-		client: client,
+		client: ssh.NewClient(c, chans, reqs),
 		host:   host,
+		jump:   jump,
 	}, nil
 }
 
-// Close closes the SSH connection
-func (c *SSHClient) Close() error {
-	if c.client != nil {
-		return c.client.Close()
-	}
-	return nil
-}
+// authMethods returns the ways to authenticate to a host: its private key,
+// the keys of the SSH agent, or both. The returned function closes the
+// connection to the agent.
+func authMethods(host HostSpec) ([]ssh.AuthMethod, func(), error) {
+	var auth []ssh.AuthMethod
+	closeAgent := func() {}
 
-// RunCommand executes a command on the remote host and returns stdout, stderr, and error
-func (c *SSHClient) RunCommand(ctx context.Context, command string) (stdout, stderr string, err error) {
-	session, err := c.client.NewSession()
+	// Read SSH key
+	key, err := privateKey(host)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create session: %w", err)
+		return nil, nil, err
+	}
+	if key == nil && !host.SSHAgent {
+		return nil, nil, fmt.Errorf("no SSH key provided for host %s", host.Address)
 	}
-	defer session.Close()
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-	session.Stdout = &stdoutBuf
-	session.Stderr = &stderrBuf
 
-	// Run command with context
-	done := make(chan error, 1)
-	go func() {
-		done <- session.Run(command)
-	}()
+	// Parse SSH private key
+	if key != nil {
+		signer, err := ParsePrivateKey(key, host.SSHKeyPassphrase)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse SSH key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
 
-	select {
-	case <-ctx.Done():
-		session.Signal(ssh.SIGKILL)
-		return stdoutBuf.String(), stderrBuf.String(), ctx.Err()
-	case err := <-done:
-		return stdoutBuf.String(), stderrBuf.String(), err
+	if host.SSHAgent {
+		authMu.RLock()
+		socket := agentSocket
+		authMu.RUnlock()
+		if socket == "" {
+			return nil, nil, fmt.Errorf("host %s uses the SSH agent but no agent socket is configured", host.Address)
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		closeAgent = func() { conn.Close() }
 	}
+
+	return auth, closeAgent, nil
 }
 
-// RunCommandWithCallback executes a command and streams output via callback
-func (c *SSHClient) RunCommandWithCallback(ctx context.Context, command string, callback func(line string)) error {
-	session, err := c.client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+// privateKey reads the private key of a host: its inline key, a stored key
+// or a key file. Hosts only using the SSH agent have none.
+func privateKey(host HostSpec) ([]byte, error) {
+	var key []byte
+	var err error
+
+	if host.SSHKey != "" {
+		key = []byte(host.SSHKey)
+	} else if host.StoredKey() {
+		authMu.RLock()
+		resolve := keyResolver
+		authMu.RUnlock()
+		if resolve == nil {
+			return nil, fmt.Errorf("stored SSH keys are not available for host %s", host.Address)
+		}
+		key, err = resolve(host.SSHKeyID, host.SSHKeyName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key for host %s: %w", host.Address, err)
+		}
+	} else if host.SSHKeyPath != "" {
+		key, err = os.ReadFile(host.SSHKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key from %s: %w", host.SSHKeyPath, err)
+		}
 	}
-	defer session.Close()
+	return key, nil
+}
 
-	stdout, err := session.StdoutPipe()
+// AuthorizedKey returns the public key of the private key of a host in
+// authorized_keys format, to authorize it on a new machine
+func AuthorizedKey(host HostSpec) (string, error) {
+	key, err := privateKey(host)
 	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return "", err
 	}
-
-	stderr, err := session.StderrPipe()
+	if key == nil {
+		return "", fmt.Errorf("host %s has no SSH key to authorize", host.Address)
+	}
+	signer, err := ParsePrivateKey(key, host.SSHKeyPassphrase)
 	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
-	}
-
-	// Start command
-	if err := session.Start(command); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
-	}
-
-	// Stream output
-	done := make(chan error, 1)
-	go func() {
-		multiReader := io.MultiReader(stdout, stderr)
-		buf := make([]byte, 1024)
-		for {
-			n, err := multiReader.Read(buf)
-			if n > 0 && callback != nil {
-				callback(string(buf[:n]))
-			}
-			if err != nil {
-				break
-			}
-		}
-		done <- session.Wait()
-	}()
+		return "", fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
 
-	// Wait for completion or cancellation
-	select {
-	case <-ctx.Done():
-		session.Signal(ssh.SIGKILL)
-		return ctx.Err()
-	case err := <-done:
-		return err
+// Close closes the SSH connection and the bastion connection it uses
+func (c *SSHClient) Close() error {
+	var err error
+	if c.client != nil {
+		err = c.client.Close()
 	}
+	if c.jump != nil {
+		c.jump.Close()
+	}
+	return err
 }
 
-// UploadFile uploads a file to the remote host using SCP-like logic
-func (c *SSHClient) UploadFile(ctx context.Context, localPath, remotePath string) error {
-	// Read local file
-	content, err := os.ReadFile(localPath)
+// RunCommand executes a command on the remote host and returns stdout, stderr, and error.
+// A command exiting unsuccessfully returns a *CommandError.
+func (c *SSHClient) RunCommand(ctx context.Context, command string, opts ...CommandOption) (stdout, stderr string, err error) {
+	_, span := c.startSpan(ctx)
+	defer func() { tracing.End(span, err) }()
+
+	session, err := c.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to read local file: %w", err)
+		return "", "", fmt.Errorf("failed to create session: %w", err)
 	}
+	defer session.Close()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	session.Stdout = &stdoutBuf
+	session.Stderr = &stderrBuf
+	command = c.asRoot(session, command, commandStdin(opts))
+
+	err = runSession(ctx, session, command, &stdoutBuf, &stderrBuf, opts)
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
+
+// RunCommandWithCallback executes a command like RunCommand and also passes
+// its combined stdout and stderr to callback as it arrives. Calls to callback
+// are serialized.
+func (c *SSHClient) RunCommandWithCallback(ctx context.Context, command string, callback func(chunk string), opts ...CommandOption) (stdout, stderr string, err error) {
+	_, span := c.startSpan(ctx)
+	defer func() { tracing.End(span, err) }()
 
-	// Create remote file using a simple approach (write via echo or heredoc)
-	// For production, consider using proper SCP or SFTP
 	session, err := c.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return "", "", fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	session.Stdin = bytes.NewReader(content)
-	command := fmt.Sprintf("cat > %s", remotePath)
+	var stdoutBuf, stderrBuf bytes.Buffer
+	live := &callbackWriter{callback: callback}
+	session.Stdout = io.MultiWriter(&stdoutBuf, live)
+	session.Stderr = io.MultiWriter(&stderrBuf, live)
+	command = c.asRoot(session, command, commandStdin(opts))
 
-	done := make(chan error, 1)
-	go func() {
-		done <- session.Run(command)
-	}()
+	err = runSession(ctx, session, command, &stdoutBuf, &stderrBuf, opts)
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-done:
-		return err
-	}
+// startSpan records a command as a trace span. Commands may embed secrets
+// such as join tokens and are not added to it.
+func (c *SSHClient) startSpan(ctx context.Context) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "ssh exec", attribute.String("host", c.host.Address))
+}
+
+// callbackWriter passes everything written to it to a callback. The SSH
+// session copies stdout and stderr from separate goroutines.
+type callbackWriter struct {
+	mu       sync.Mutex
+	callback func(chunk string)
+}
+
+func (w *callbackWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback(string(p))
+	return len(p), nil
 }
 
-// DownloadFile downloads a file from the remote host
-func (c *SSHClient) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+// runAsUser runs a command as the SSH user, without sudo
+func (c *SSHClient) runAsUser(ctx context.Context, command string, stdin io.Reader) (stdout, stderr string, err error) {
 	session, err := c.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return "", "", fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	var stdoutBuf bytes.Buffer
+	var stdoutBuf, stderrBuf bytes.Buffer
 	session.Stdout = &stdoutBuf
+	session.Stderr = &stderrBuf
+	session.Stdin = stdin
 
-	done := make(chan error, 1)
-	go func() {
-		done <- session.Run(fmt.Sprintf("cat %s", remotePath))
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-done:
-		if err != nil {
-			return err
-		}
-		return os.WriteFile(localPath, stdoutBuf.Bytes(), 0644)
-	}
+	err = runSession(ctx, session, command, &stdoutBuf, &stderrBuf, nil)
+	return stdoutBuf.String(), stderrBuf.String(), err
 }
 
 // TestConnection tests if the SSH connection is working
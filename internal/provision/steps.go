@@ -0,0 +1,81 @@
+package provision
+
+// Step identifies a step of an operation on a host, recorded with its events
+type Step string
+
+const (
+	StepPrepare          Step = "prepare"
+	StepInstallRuntime   Step = "install-runtime"
+	StepInstallK8s       Step = "install-k8s"
+	StepBootstrap        Step = "bootstrap"
+	StepInstallCNI       Step = "install-cni"
+	StepCheckNetwork     Step = "check-network"
+	StepJoinControlPlane Step = "join-cp"
+	StepJoinWorker       Step = "join-worker"
+	StepVerify           Step = "verify"
+	StepJoinToken        Step = "join-token"
+	StepUploadCerts      Step = "upload-certs"
+	StepRemoveNode       Step = "remove-node"
+	StepReset            Step = "reset"
+	StepDestroy          Step = "destroy"
+	StepUpgrade          Step = "upgrade"
+	StepCordon           Step = "cordon"
+	StepDrain            Step = "drain"
+	StepPatch            Step = "patch"
+	StepRenewCerts       Step = "renew-certs"
+
+	// Steps of the provisioning job, around the calls of the provisioner
+	StepCNI  Step = "cni"
+	StepJoin Step = "join"
+)
+
+// Phase groups the steps of an operation for progress reporting
+type Phase string
+
+const (
+	PhasePrepare   Phase = "prepare"
+	PhaseBootstrap Phase = "bootstrap"
+	PhaseCNI       Phase = "cni"
+	PhaseJoin      Phase = "join"
+	PhaseVerify    Phase = "verify"
+	PhaseDestroy   Phase = "destroy"
+	PhaseUpgrade   Phase = "upgrade"
+)
+
+// Phases lists the phases in the order a cluster goes through them
+var Phases = []Phase{PhasePrepare, PhaseBootstrap, PhaseCNI, PhaseJoin, PhaseVerify, PhaseUpgrade, PhaseDestroy}
+
+var stepPhases = map[Step]Phase{
+	StepPrepare:          PhasePrepare,
+	StepInstallRuntime:   PhasePrepare,
+	StepInstallK8s:       PhasePrepare,
+	StepBootstrap:        PhaseBootstrap,
+	StepInstallCNI:       PhaseCNI,
+	StepCNI:              PhaseCNI,
+	StepCheckNetwork:     PhaseCNI,
+	StepJoinControlPlane: PhaseJoin,
+	StepJoinWorker:       PhaseJoin,
+	StepJoinToken:        PhaseJoin,
+	StepJoin:             PhaseJoin,
+	StepVerify:           PhaseVerify,
+	StepUpgrade:          PhaseUpgrade,
+	StepRemoveNode:       PhaseDestroy,
+	StepReset:            PhaseDestroy,
+	StepDestroy:          PhaseDestroy,
+}
+
+// Phase returns the phase of the step, empty for steps outside the
+// provisioning phases such as maintenance
+func (s Step) Phase() Phase {
+	return stepPhases[s]
+}
+
+// StepStatus is the state of a step on a host
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+)
@@ -0,0 +1,70 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotRoot is returned by the preflight check when commands on a host would
+// not run as root
+var ErrNotRoot = errors.New("commands do not run as root")
+
+// asRoot prepares a command to run as root: hosts with use_sudo run it with
+// sudo, non-interactively or with the sudo password on stdin. stdin, if
+// set, is passed to the command.
+func (c *SSHClient) asRoot(session *ssh.Session, command string, stdin io.Reader) string {
+	if !c.host.UseSudo {
+		if stdin != nil {
+			session.Stdin = stdin
+		}
+		return command
+	}
+	if c.host.SudoPassword == "" {
+		if stdin != nil {
+			session.Stdin = stdin
+		}
+		return "sudo -n -H sh -c " + ShellQuote(command)
+	}
+
+	// -k ignores cached credentials, so sudo always reads the password
+	password := strings.NewReader(c.host.SudoPassword + "\n")
+	session.Stdin = password
+	if stdin != nil {
+		session.Stdin = io.MultiReader(password, stdin)
+	}
+	return "sudo -S -k -p '' -H sh -c " + ShellQuote(command)
+}
+
+// CheckRoot verifies that commands run as root on the host: the user is root
+// or may use sudo as configured
+func (c *SSHClient) CheckRoot(ctx context.Context) error {
+	stdout, stderr, err := c.RunCommand(ctx, "id -u")
+	if err == nil && strings.TrimSpace(stdout) == "0" {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if !c.host.UseSudo {
+		return fmt.Errorf("%w: user %s is not root, set use_sudo", ErrNotRoot, c.host.User)
+	}
+	reason := strings.TrimSpace(stderr)
+	if reason == "" && err != nil {
+		reason = err.Error()
+	}
+	if c.host.SudoPassword == "" {
+		return fmt.Errorf("%w: user %s cannot use sudo without a password, allow NOPASSWD or set sudo_password: %s", ErrNotRoot, c.host.User, reason)
+	}
+	return fmt.Errorf("%w: user %s cannot use sudo: %s", ErrNotRoot, c.host.User, reason)
+}
+
+// ShellQuote quotes s as a single word for sh
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
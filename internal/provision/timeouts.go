@@ -0,0 +1,177 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"kubeforge/internal/tracing"
+	"kubeforge/internal/validation"
+)
+
+// Duration is a time.Duration encoded in JSON as a string such as "15m"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string ("15m") or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(time.Duration(seconds * float64(time.Second)))
+	return nil
+}
+
+// StepTimeouts bounds the duration of provisioning steps. Zero values fall
+// back to the server defaults.
+type StepTimeouts struct {
+	Prepare   Duration `json:"prepare,omitempty"`   // per host: runtime and Kubernetes packages
+	Bootstrap Duration `json:"bootstrap,omitempty"` // kubeadm init on the first control plane
+	CNI       Duration `json:"cni,omitempty"`       // CNI install and rollout wait
+	Join      Duration `json:"join,omitempty"`      // per host: kubeadm join
+	Upgrade   Duration `json:"upgrade,omitempty"`   // per host: Kubernetes version upgrade
+	Machine   Duration `json:"machine,omitempty"`   // per host: machine creation, boot and cloud-init
+}
+
+// Validate checks that no timeout is negative
+func (t *StepTimeouts) Validate() error {
+	return t.ValidateFields("timeouts").Err()
+}
+
+// ValidateFields checks that no timeout is negative. Field paths are
+// relative to prefix.
+func (t *StepTimeouts) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+	for _, step := range []struct {
+		name string
+		d    Duration
+	}{
+		{"prepare", t.Prepare},
+		{"bootstrap", t.Bootstrap},
+		{"cni", t.CNI},
+		{"join", t.Join},
+		{"upgrade", t.Upgrade},
+		{"machine", t.Machine},
+	} {
+		if step.d < 0 {
+			errs.Add(validation.Path(prefix, step.name), validation.CodeOutOfRange, step.name+" timeout must not be negative")
+		}
+	}
+	return errs
+}
+
+// Merge returns t with unset timeouts taken from defaults
+func (t StepTimeouts) Merge(defaults StepTimeouts) StepTimeouts {
+	if t.Prepare == 0 {
+		t.Prepare = defaults.Prepare
+	}
+	if t.Bootstrap == 0 {
+		t.Bootstrap = defaults.Bootstrap
+	}
+	if t.CNI == 0 {
+		t.CNI = defaults.CNI
+	}
+	if t.Join == 0 {
+		t.Join = defaults.Join
+	}
+	if t.Upgrade == 0 {
+		t.Upgrade = defaults.Upgrade
+	}
+	if t.Machine == 0 {
+		t.Machine = defaults.Machine
+	}
+	return t
+}
+
+var (
+	timeoutsMu      sync.RWMutex
+	defaultTimeouts = StepTimeouts{
+		Prepare:   Duration(30 * time.Minute),
+		Bootstrap: Duration(15 * time.Minute),
+		CNI:       Duration(10 * time.Minute),
+		Join:      Duration(10 * time.Minute),
+		Upgrade:   Duration(20 * time.Minute),
+		Machine:   Duration(15 * time.Minute),
+	}
+)
+
+// SetDefaultStepTimeouts configures the step timeouts used when a cluster
+// spec does not set its own
+func SetDefaultStepTimeouts(timeouts StepTimeouts) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	defaultTimeouts = timeouts.Merge(defaultTimeouts)
+}
+
+// DefaultStepTimeouts returns the server wide step timeouts
+func DefaultStepTimeouts() StepTimeouts {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	return defaultTimeouts
+}
+
+// StepGate decides whether steps may start, so a job can stop between steps
+// rather than in the middle of one
+type StepGate interface {
+	// StartStep reports whether a step may start, counting it as running
+	// until EndStep. When it may not, ctx is cancelled once the running
+	// steps have ended.
+	StartStep() bool
+	EndStep()
+}
+
+// stepGateKey is the context key of the step gate
+type stepGateKey struct{}
+
+// WithStepGate returns a context in which RunStep asks gate before each step
+func WithStepGate(ctx context.Context, gate StepGate) context.Context {
+	return context.WithValue(ctx, stepGateKey{}, gate)
+}
+
+// RunStep runs fn with a context bounded by timeout. If the step runs out of
+// time the returned error says so, rather than a bare context error. Each
+// step is recorded as a trace span. When the step gate of ctx refuses the
+// step, RunStep waits for ctx to be cancelled and returns its cause.
+func RunStep(ctx context.Context, step string, timeout Duration, fn func(ctx context.Context) error) (err error) {
+	if gate, ok := ctx.Value(stepGateKey{}).(StepGate); ok {
+		if !gate.StartStep() {
+			<-ctx.Done()
+			return context.Cause(ctx)
+		}
+		defer gate.EndStep()
+	}
+
+	ctx, span := tracing.Start(ctx, step)
+	defer func() { tracing.End(span, err) }()
+
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout))
+	defer cancel()
+
+	err = fn(stepCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s: %w", step, time.Duration(timeout), err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// trivyRootfsSkipDirs are not scanned on nodes: pseudo filesystems and the
+// layers of containers, whose images are scanned on their own
+const trivyRootfsSkipDirs = "/proc,/sys,/dev,/run,/var/lib/containerd,/var/lib/kubelet/pods"
+
+// VulnerabilityFinding is a vulnerable package found by Trivy
+type VulnerabilityFinding struct {
+	ID               string `json:"id"` // CVE or advisory ID
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version,omitempty"`
+	Severity         string `json:"severity"` // CRITICAL, HIGH, MEDIUM, LOW, UNKNOWN
+	Title            string `json:"title,omitempty"`
+}
+
+// ParseTrivy reads the vulnerabilities of trivy --format json
+func ParseTrivy(output []byte) ([]VulnerabilityFinding, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string `json:"VulnerabilityID"`
+				PkgName          string `json:"PkgName"`
+				InstalledVersion string `json:"InstalledVersion"`
+				FixedVersion     string `json:"FixedVersion"`
+				Severity         string `json:"Severity"`
+				Title            string `json:"Title"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("invalid trivy output: %w", err)
+	}
+	findings := []VulnerabilityFinding{}
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			findings = append(findings, VulnerabilityFinding{
+				ID:               v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         v.Severity,
+				Title:            v.Title,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// TrivyRootfs scans the operating system packages of a host with Trivy
+// installed on it
+func TrivyRootfs(ctx context.Context, client *SSHClient) ([]VulnerabilityFinding, error) {
+	return runTrivy(ctx, client, "rootfs --scanners vuln --skip-dirs "+ShellQuote(trivyRootfsSkipDirs)+" /")
+}
+
+// TrivyImage scans a container image with Trivy installed on a host, which
+// pulls the image from its registry
+func TrivyImage(ctx context.Context, client *SSHClient, image string) ([]VulnerabilityFinding, error) {
+	return runTrivy(ctx, client, "image --scanners vuln "+ShellQuote(image))
+}
+
+func runTrivy(ctx context.Context, client *SSHClient, args string) ([]VulnerabilityFinding, error) {
+	command := "command -v trivy >/dev/null || { echo 'trivy is not installed' >&2; exit 127; }; trivy --quiet " + args + " --format json"
+	stdout, stderr, err := client.RunCommand(ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("trivy failed: %s: %w", stderr, err)
+	}
+	return ParseTrivy([]byte(stdout))
+}
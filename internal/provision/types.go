@@ -1,13 +1,24 @@
 package provision
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"kubeforge/internal/bmc"
+	"kubeforge/internal/infra"
+	"kubeforge/internal/validation"
+)
 
 // ClusterSpec defines the desired state of a Kubernetes cluster using kubeadm
 type ClusterSpec struct {
 	Name          string     `json:"name"`
 	ControlPlanes []HostSpec `json:"control_planes"`
 	Workers       []HostSpec `json:"workers"`
-	K8sVersion    string     `json:"k8s_version"` // e.g., "1.28.0"
+	K8sVersion    string     `json:"k8s_version"` // e.g., "1.34.1"
 	PodNetworkCIDR string    `json:"pod_network_cidr"` // default: "10.244.0.0/16"
 	ServiceCIDR    string    `json:"service_cidr"` // default: "10.96.0.0/12"
 	CNI           string     `json:"cni"` // calico, flannel, weave, cilium
@@ -15,6 +26,19 @@ type ClusterSpec struct {
 	APIServerEndpoint string `json:"api_server_endpoint,omitempty"` // for HA setup
 	LoadBalancerIP   string `json:"load_balancer_ip,omitempty"` // for HA control plane
 	CertificateKey   string `json:"certificate_key,omitempty"` // for joining additional control planes
+	Addons           []AddonSpec `json:"addons,omitempty"` // installed after the cluster is provisioned
+	Timeouts         *StepTimeouts `json:"timeouts,omitempty"` // overrides the server step timeouts
+	KubeadmConfig    string `json:"kubeadm_config,omitempty"` // kubeadm configuration YAML kubeadm init runs with
+	HardeningProfile string `json:"hardening_profile,omitempty"` // baseline or cis settings applied during provisioning
+	Hooks            []HookSpec `json:"hooks,omitempty"` // site specific scripts and webhooks run during provisioning
+	Infrastructure   *infra.Spec `json:"infrastructure,omitempty"` // provider creating the machines of hosts with a machine
+}
+
+// AddonSpec requests an addon to be installed once the cluster is provisioned
+type AddonSpec struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"` // addon specific settings
 }
 
 // HostSpec defines a single host/node in the cluster
@@ -24,10 +48,21 @@ type HostSpec struct {
 	User       string            `json:"user"` // SSH user
 	SSHKey     string            `json:"ssh_key,omitempty"` // SSH private key content
 	SSHKeyPath string            `json:"ssh_key_path,omitempty"` // or path to key file
+	SSHKeyID   uint              `json:"ssh_key_id,omitempty"` // or a stored SSH key by ID
+	SSHKeyName string            `json:"ssh_key_name,omitempty"` // or by name
+	SSHKeyPassphrase string      `json:"ssh_key_passphrase,omitempty"` // decrypts an encrypted key
+	SSHAgent   bool              `json:"ssh_agent,omitempty"` // also authenticate with the keys of the server's SSH agent
+	Bastion    *HostSpec         `json:"bastion,omitempty"` // jump host the host is reached through
+	UseSudo    bool              `json:"use_sudo,omitempty"` // run commands as root with sudo, for non-root users
+	SudoPassword string          `json:"sudo_password,omitempty"` // when sudo is not allowed without a password
+	InsecureSkipHostKeyCheck bool `json:"-"` // set from the cluster, accepts any host key
 	Port       int               `json:"port"` // SSH port, default 22
 	Role       string            `json:"role"` // control-plane, worker
 	Labels     map[string]string `json:"labels,omitempty"`
 	Taints     []string          `json:"taints,omitempty"`
+	Machine    *infra.MachineSpec `json:"machine,omitempty"` // create the host on the infrastructure of the cluster
+	BMC        *bmc.Spec         `json:"bmc,omitempty"` // baseboard management controller of a bare-metal host, power cycles it when it hangs
+	HostID     uint              `json:"host_id,omitempty"` // inventory host the connection settings are taken from
 }
 
 // ProvisionResult contains the result of a provision operation
@@ -58,7 +93,9 @@ type ProvisionEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 	Level     string    `json:"level"` // info, warn, error
 	Host      string    `json:"host"`
-	Step      string    `json:"step"`
+	Step      Step      `json:"step"`
+	Phase     Phase     `json:"phase,omitempty"`
+	Status    StepStatus `json:"status,omitempty"` // set on the events starting and ending a step
 	Message   string    `json:"message"`
 	Output    string    `json:"output,omitempty"` // command output
 }
@@ -77,18 +114,23 @@ const (
 	StatusCancelled  ProvisionStatus = "cancelled"
 )
 
-// Validate checks if the ClusterSpec is valid
+// SupportedCNIs are the CNI plugins InstallCNI can deploy
+var SupportedCNIs = []string{"calico", "flannel", "weave", "cilium"}
+
+// SupportedRuntimes are the container runtimes PrepareHosts can install
+var SupportedRuntimes = []string{"containerd"}
+
+// Validate applies defaults and checks if the ClusterSpec is valid. The
+// returned error is a validation.Errors listing every invalid field.
 func (cs *ClusterSpec) Validate() error {
-	if cs.Name == "" {
-		return ErrInvalidSpec("cluster name is required")
-	}
-	if len(cs.ControlPlanes) == 0 {
-		return ErrInvalidSpec("at least one control plane is required")
-	}
+	cs.SetDefaults()
+	return cs.ValidateFields().Err()
+}
 
-	// Set defaults
+// SetDefaults fills in the optional fields of the spec and its hosts
+func (cs *ClusterSpec) SetDefaults() {
 	if cs.K8sVersion == "" {
-		cs.K8sVersion = "1.28.0" // default version
+		cs.K8sVersion = DefaultVersion()
 	}
 	if cs.PodNetworkCIDR == "" {
 		cs.PodNetworkCIDR = "10.244.0.0/16"
@@ -102,49 +144,246 @@ func (cs *ClusterSpec) Validate() error {
 	if cs.ContainerRuntime == "" {
 		cs.ContainerRuntime = "containerd"
 	}
+	for i := range cs.ControlPlanes {
+		cs.ControlPlanes[i].SetDefaults()
+	}
+	for i := range cs.Workers {
+		cs.Workers[i].SetDefaults()
+	}
+}
+
+// ValidateFields checks every field of a spec with defaults applied
+func (cs *ClusterSpec) ValidateFields() validation.Errors {
+	var errs validation.Errors
+
+	if cs.Name == "" {
+		errs.Add("name", validation.CodeRequired, "cluster name is required")
+	}
+	if _, err := ParseVersion(cs.K8sVersion); err != nil {
+		errs.Add("k8s_version", validation.CodeInvalid, err.Error())
+	}
+
+	podNetwork, err := validation.CIDR(cs.PodNetworkCIDR)
+	if err != nil {
+		errs.Add("pod_network_cidr", validation.CodeInvalid, err.Error())
+	}
+	serviceNetwork, err := validation.CIDR(cs.ServiceCIDR)
+	if err != nil {
+		errs.Add("service_cidr", validation.CodeInvalid, err.Error())
+	}
+	if podNetwork != nil && serviceNetwork != nil && validation.Overlaps(podNetwork, serviceNetwork) {
+		errs.Add("service_cidr", validation.CodeOverlap, fmt.Sprintf("service CIDR %s overlaps pod network CIDR %s", serviceNetwork, podNetwork))
+	}
+
+	if !validation.OneOf(cs.CNI, SupportedCNIs) {
+		errs.Add("cni", validation.CodeUnsupported, fmt.Sprintf("unsupported CNI %q, expected one of %s", cs.CNI, strings.Join(SupportedCNIs, ", ")))
+	} else if err := ValidateCNI(cs.K8sVersion, cs.CNI); err != nil {
+		errs.Add("cni", validation.CodeUnsupported, err.Error())
+	}
+	if cs.HardeningProfile != "" && !validation.OneOf(cs.HardeningProfile, HardeningProfiles) {
+		errs.Add("hardening_profile", validation.CodeUnsupported, fmt.Sprintf("unsupported hardening profile %q, expected one of %s", cs.HardeningProfile, strings.Join(HardeningProfiles, ", ")))
+	} else if err := ValidateHardeningProfile(cs.K8sVersion, cs.HardeningProfile); err != nil {
+		errs.Add("hardening_profile", validation.CodeUnsupported, err.Error())
+	}
+	if !validation.OneOf(cs.ContainerRuntime, SupportedRuntimes) {
+		errs.Add("container_runtime", validation.CodeUnsupported, fmt.Sprintf("unsupported container runtime %q, expected one of %s", cs.ContainerRuntime, strings.Join(SupportedRuntimes, ", ")))
+	}
+	if cs.APIServerEndpoint != "" && !validation.Endpoint(cs.APIServerEndpoint) {
+		errs.Add("api_server_endpoint", validation.CodeInvalid, "API server endpoint must be a host or host:port")
+	}
+	if cs.LoadBalancerIP != "" && net.ParseIP(cs.LoadBalancerIP) == nil {
+		errs.Add("load_balancer_ip", validation.CodeInvalid, fmt.Sprintf("%q is not a valid IP address", cs.LoadBalancerIP))
+	}
 
-	// Validate all hosts
-	for _, host := range append(cs.ControlPlanes, cs.Workers...) {
-		if err := host.Validate(); err != nil {
-			return err
+	if len(cs.ControlPlanes) == 0 {
+		errs.Add("control_planes", validation.CodeRequired, "at least one control plane is required")
+	}
+	seen := make(map[string]string)
+	machines := make(map[string]string) // machines are named after their hosts
+	check := func(field string, hosts []HostSpec) {
+		for i := range hosts {
+			path := validation.Index(field, i)
+			errs = append(errs, hosts[i].ValidateFields(path)...)
+			if address := hosts[i].Address; address != "" {
+				if other, ok := seen[address]; ok {
+					errs.Add(validation.Path(path, "address"), validation.CodeDuplicate, fmt.Sprintf("address %s is already used by %s", address, other))
+				} else {
+					seen[address] = path
+				}
+			}
+			if hosts[i].Machine == nil {
+				continue
+			}
+			if cs.Infrastructure == nil {
+				errs.Add(validation.Path(path, "machine"), validation.CodeRequired, "infrastructure is required to create machines")
+			} else {
+				errs = append(errs, cs.Infrastructure.ValidateMachine(hosts[i].Machine, validation.Path(path, "machine"))...)
+			}
+			if name := hosts[i].Hostname; name != "" {
+				if other, ok := machines[name]; ok {
+					errs.Add(validation.Path(path, "hostname"), validation.CodeDuplicate, fmt.Sprintf("hostname %s is already used by %s", name, other))
+				} else {
+					machines[name] = path
+				}
+			}
 		}
 	}
+	check("control_planes", cs.ControlPlanes)
+	check("workers", cs.Workers)
 
-	return nil
+	if cs.Infrastructure != nil {
+		errs = append(errs, cs.Infrastructure.ValidateFields("infrastructure")...)
+	}
+
+	if cs.Timeouts != nil {
+		errs = append(errs, cs.Timeouts.ValidateFields("timeouts")...)
+	}
+	errs = append(errs, ValidateKubeadmConfig("kubeadm_config", cs.KubeadmConfig)...)
+	errs = append(errs, ValidateHooks("hooks", cs.Hooks)...)
+
+	return errs
 }
 
-// Validate checks if the HostSpec is valid
+// Validate applies defaults and checks if the HostSpec is valid
 func (hs *HostSpec) Validate() error {
-	if hs.Address == "" {
-		return ErrInvalidSpec("host address is required")
-	}
+	hs.SetDefaults()
+	return hs.ValidateFields("").Err()
+}
+
+// SetDefaults fills in the optional fields of the host
+func (hs *HostSpec) SetDefaults() {
 	if hs.User == "" {
 		hs.User = "root" // default user
 	}
 	if hs.Port == 0 {
 		hs.Port = 22 // default SSH port
 	}
-	if hs.SSHKey == "" && hs.SSHKeyPath == "" {
-		return ErrInvalidSpec("SSH key or key path is required for host " + hs.Address)
-	}
 	if hs.Hostname == "" {
 		hs.Hostname = hs.Address // use address as hostname if not specified
 	}
-	return nil
+	if hs.Bastion != nil {
+		hs.Bastion.SetDefaults()
+	}
+	if hs.BMC != nil {
+		hs.BMC.SetDefaults()
+	}
+}
+
+// SetInsecureSkipHostKeyCheck sets whether the host and its bastions accept
+// any SSH host key
+func (hs *HostSpec) SetInsecureSkipHostKeyCheck(skip bool) {
+	for host := hs; host != nil; host = host.Bastion {
+		host.InsecureSkipHostKeyCheck = skip
+	}
+}
+
+// maxBastionHops bounds the chain of bastions a host is reached through
+const maxBastionHops = 3
+
+// validateBastion checks a bastion and the bastions it is reached through
+func (hs *HostSpec) validateBastion(prefix string, hops int) validation.Errors {
+	if hops == 0 {
+		var errs validation.Errors
+		errs.Add(prefix, validation.CodeOutOfRange, fmt.Sprintf("at most %d chained bastions are supported", maxBastionHops))
+		return errs
+	}
+	bastion := *hs
+	bastion.Role = ""
+	bastion.Bastion = nil
+	bastion.UseSudo, bastion.SudoPassword = false, "" // commands only run on the host itself
+	errs := bastion.ValidateFields(prefix)
+	if hs.BMC != nil {
+		errs.Add(validation.Path(prefix, "bmc"), validation.CodeInvalid, "bastions are not power managed")
+	}
+	if hs.SudoPassword != "" && !hs.UseSudo {
+		errs.Add(validation.Path(prefix, "sudo_password"), validation.CodeInvalid, "sudo_password requires use_sudo")
+	}
+	if hs.Bastion != nil {
+		errs = append(errs, hs.Bastion.validateBastion(validation.Path(prefix, "bastion"), hops-1)...)
+	}
+	return errs
+}
+
+// StoredKey reports whether the host references a stored SSH key
+func (hs *HostSpec) StoredKey() bool {
+	return hs.SSHKeyID != 0 || hs.SSHKeyName != ""
+}
+
+// ValidateFields checks every field of a host with defaults applied. Field
+// paths are relative to prefix.
+func (hs *HostSpec) ValidateFields(prefix string) validation.Errors {
+	var errs validation.Errors
+
+	if hs.Address == "" && hs.Machine == nil {
+		errs.Add(validation.Path(prefix, "address"), validation.CodeRequired, "host address is required")
+	} else if hs.Address != "" && !validation.Host(hs.Address) {
+		errs.Add(validation.Path(prefix, "address"), validation.CodeInvalid, fmt.Sprintf("%q is not a valid IP address or DNS name", hs.Address))
+	}
+	if hs.Port < 1 || hs.Port > 65535 {
+		errs.Add(validation.Path(prefix, "port"), validation.CodeOutOfRange, "port must be between 1 and 65535")
+	}
+	if hs.SSHKey == "" && hs.SSHKeyPath == "" && !hs.StoredKey() && !hs.SSHAgent {
+		errs.Add(validation.Path(prefix, "ssh_key"), validation.CodeRequired, "SSH key, key path, stored key ID or name, or ssh_agent is required")
+	}
+	if hs.SSHKey != "" {
+		errs = append(errs, ValidatePrivateKey(prefix, []byte(hs.SSHKey), hs.SSHKeyPassphrase)...)
+	}
+	if hs.Role != "" && hs.Role != "control-plane" && hs.Role != "worker" {
+		errs.Add(validation.Path(prefix, "role"), validation.CodeInvalid, "role must be control-plane or worker")
+	}
+	if hs.Bastion != nil {
+		errs = append(errs, hs.Bastion.validateBastion(validation.Path(prefix, "bastion"), maxBastionHops)...)
+	}
+	if hs.Machine != nil {
+		// The machine is named after the host and authorizes its key
+		if hs.Hostname == "" {
+			errs.Add(validation.Path(prefix, "hostname"), validation.CodeRequired, "hostname is required to name the machine")
+		}
+		if hs.SSHKey == "" && hs.SSHKeyPath == "" && !hs.StoredKey() {
+			errs.Add(validation.Path(prefix, "ssh_key"), validation.CodeRequired, "an SSH key is required to authorize it on the machine")
+		}
+		errs = append(errs, hs.Machine.ValidateFields(validation.Path(prefix, "machine"))...)
+	}
+	if hs.BMC != nil {
+		if hs.Machine != nil {
+			errs.Add(validation.Path(prefix, "bmc"), validation.CodeInvalid, "bmc is only supported for bare-metal hosts, not with machine")
+		}
+		errs = append(errs, hs.BMC.ValidateFields(validation.Path(prefix, "bmc"))...)
+	}
+
+	return errs
+}
+
+// ValidatePrivateKey checks that the SSH key of a host can be parsed with
+// its passphrase. Field paths are relative to prefix.
+func ValidatePrivateKey(prefix string, key []byte, passphrase string) validation.Errors {
+	var errs validation.Errors
+	_, err := ParsePrivateKey(key, passphrase)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrPassphraseRequired):
+		errs.Add(validation.Path(prefix, "ssh_key_passphrase"), validation.CodeRequired, "SSH key is encrypted, a passphrase is required")
+	case errors.Is(err, ErrWrongPassphrase):
+		errs.Add(validation.Path(prefix, "ssh_key_passphrase"), validation.CodeInvalid, err.Error())
+	default:
+		errs.Add(validation.Path(prefix, "ssh_key"), validation.CodeInvalid, "invalid SSH private key: "+err.Error())
+	}
+	return errs
 }
 
 // NewProvisionEvent creates a new provision event
-func NewProvisionEvent(level, host, step, message string) ProvisionEvent {
+func NewProvisionEvent(level, host string, step Step, message string) ProvisionEvent {
 	return ProvisionEvent{
 		Timestamp: time.Now(),
 		Level:     level,
 		Host:      host,
 		Step:      step,
+		Phase:     step.Phase(),
 		Message:   message,
 	}
 }
 
 // AddEvent adds an event to the provision result
-func (pr *ProvisionResult) AddEvent(level, host, step, message string) {
+func (pr *ProvisionResult) AddEvent(level, host string, step Step, message string) {
 	pr.Events = append(pr.Events, NewProvisionEvent(level, host, step, message))
 }
@@ -0,0 +1,73 @@
+package provision
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Kubernetes version
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses a version such as "1.28.3" or "v1.28.3"
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid k8s version %q, expected major.minor.patch", s)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid k8s version %q, expected major.minor.patch", s)
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// String returns the version without a "v" prefix
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less reports whether v is older than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+// ValidateUpgrade checks that a cluster can be upgraded from one version to
+// another. kubeadm only supports upgrades to a newer patch release or the
+// next minor release, and KubeForge only to releases of its catalog.
+func ValidateUpgrade(from, to string) error {
+	current, err := ParseVersion(from)
+	if err != nil {
+		return err
+	}
+	target, err := ParseVersion(to)
+	if err != nil {
+		return err
+	}
+
+	if !current.Less(target) {
+		return fmt.Errorf("cannot upgrade from %s to %s: target version must be newer", current, target)
+	}
+	if target.Major != current.Major || target.Minor > current.Minor+1 {
+		return fmt.Errorf("cannot upgrade from %s to %s: upgrades must not skip minor versions", current, target)
+	}
+	if _, ok := findRelease(target); !ok {
+		return fmt.Errorf("cannot upgrade from %s to %s: %d.%d is not a validated release", current, target, target.Major, target.Minor)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// VerifyNamespace holds the short-lived smoke test workload
+const VerifyNamespace = "kubeforge-smoke"
+
+const (
+	verifyName = "smoke"
+	// verifyRollout bounds waiting for the test deployment to be available
+	verifyRollout = 180
+)
+
+// Smoke test names; control plane components are checked as
+// component:<pod>
+const (
+	SmokeCheckDeployment = "deployment"
+	SmokeCheckService    = "service"
+	SmokeCheckDNS        = "dns"
+	SmokeCheckAPIServer  = "apiserver"
+)
+
+var (
+	verifyMu      sync.RWMutex
+	verifyEnabled = true
+)
+
+// SetVerify enables or disables the smoke tests run once a cluster is
+// provisioned
+func SetVerify(enabled bool) {
+	verifyMu.Lock()
+	verifyEnabled = enabled
+	verifyMu.Unlock()
+}
+
+// VerifyEnabled reports whether provisioned clusters are smoke tested
+func VerifyEnabled() bool {
+	verifyMu.RLock()
+	defer verifyMu.RUnlock()
+	return verifyEnabled
+}
+
+// SmokeCheck is the outcome of one smoke test of a cluster
+type SmokeCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"` // why the check failed
+}
+
+// SmokeTestManifest returns the namespace, deployment and service of the
+// smoke test. The deployment runs two replicas of image serving HTTP.
+func SmokeTestManifest(image string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: %[1]s
+  labels:
+    app.kubernetes.io/managed-by: kubeforge
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %[2]s
+  namespace: %[1]s
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: %[2]s
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: %[2]s
+    spec:
+      terminationGracePeriodSeconds: 1
+      containers:
+        - name: netexec
+          image: %[3]s
+          args: ["netexec", "--http-port=%[4]d"]
+          ports:
+            - containerPort: %[4]d
+          readinessProbe:
+            httpGet: {path: /healthz, port: %[4]d}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: %[2]s
+  namespace: %[1]s
+spec:
+  selector:
+    app.kubernetes.io/name: %[2]s
+  ports:
+    - port: %[4]d
+      targetPort: %[4]d
+`, VerifyNamespace, verifyName, image, networkCheckPort)
+}
+
+// VerifyCluster smoke tests a provisioned cluster from the control plane: a
+// test deployment becomes available, its service answers and its name
+// resolves from a pod, the API server is ready and every control plane
+// component pod is Ready. The workload is deleted afterwards. Failed checks
+// are returned without an error, which is kept for failures to run them.
+func (p *KubeadmProvisioner) VerifyCluster(ctx context.Context, controlPlane HostSpec) (_ []SmokeCheck, err error) {
+	address := controlPlane.Address
+	p.startStep(address, StepVerify, "Running smoke tests")
+	defer p.failStep(address, StepVerify, &err)
+
+	client, err := p.connect(ctx, controlPlane, StepVerify)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	kubectl := func(args string) (string, error) {
+		stdout, stderr, err := client.RunCommand(ctx, "kubectl --kubeconfig "+adminKubeconfigPath+" "+args)
+		return stdout, stderrError(stderr, err)
+	}
+
+	var checks []SmokeCheck
+	record := func(name string, err error) bool {
+		check := SmokeCheck{Name: name, Passed: err == nil}
+		if err != nil {
+			check.Message = err.Error()
+			p.emitEvent("error", address, StepVerify, fmt.Sprintf("Smoke test %s failed: %s", name, check.Message))
+		} else {
+			p.emitEvent("info", address, StepVerify, fmt.Sprintf("Smoke test %s passed", name))
+		}
+		checks = append(checks, check)
+		return check.Passed
+	}
+
+	_, err = kubectl("get --raw /readyz")
+	record(SmokeCheckAPIServer, err)
+
+	output, err := kubectl(`get pods -n kube-system -l tier=control-plane -o jsonpath='{range .items[*]}{.metadata.name}{" "}{.status.conditions[?(@.type=="Ready")].status}{"\n"}{end}'`)
+	if err != nil {
+		return checks, fmt.Errorf("failed to list control plane pods: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var notReady error
+		if len(fields) < 2 || fields[1] != "True" {
+			notReady = fmt.Errorf("pod is not Ready")
+		}
+		record("component:"+fields[0], notReady)
+	}
+
+	apply := "kubectl --kubeconfig " + adminKubeconfigPath + " apply -f -"
+	if _, stderr, err := client.RunCommand(ctx, apply, WithStdin(strings.NewReader(SmokeTestManifest(CurrentNetworkCheck().Image)))); err != nil {
+		return checks, fmt.Errorf("failed to deploy the smoke test: %s: %w", strings.TrimSpace(stderr), err)
+	}
+	defer client.RunCommand(context.Background(), fmt.Sprintf("kubectl --kubeconfig %s delete namespace %s --ignore-not-found --wait=false", adminKubeconfigPath, VerifyNamespace))
+
+	_, err = kubectl(fmt.Sprintf("rollout status deployment/%s -n %s --timeout=%ds", verifyName, VerifyNamespace, verifyRollout))
+	if record(SmokeCheckDeployment, err) {
+		service := fmt.Sprintf("%s.%s.svc", verifyName, VerifyNamespace)
+		exec := fmt.Sprintf("exec -n %s deployment/%s -- ", VerifyNamespace, verifyName)
+
+		// dig prints the addresses of the name, nothing when it does not
+		// resolve
+		output, err := kubectl(exec + "dig +short +search " + service + " A")
+		if err == nil && strings.TrimSpace(output) == "" {
+			err = fmt.Errorf("%s does not resolve", service)
+		}
+		record(SmokeCheckDNS, err)
+
+		_, err = kubectl(fmt.Sprintf("%s/agnhost connect --timeout=%s %s:%d", exec, networkCheckConnectTimeout, service, networkCheckPort))
+		record(SmokeCheckService, err)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if !check.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		p.emitStepEvent("error", address, StepVerify, StepFailed, fmt.Sprintf("%d of %d smoke tests failed", failed, len(checks)))
+	} else {
+		p.finishStep(address, StepVerify, fmt.Sprintf("Cluster verified, %d smoke tests passed", len(checks)))
+	}
+	return checks, nil
+}
@@ -0,0 +1,220 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Release is a minor Kubernetes release KubeForge provisions
+type Release struct {
+	Minor     string    `json:"minor"`       // e.g. 1.31
+	Latest    string    `json:"latest"`      // latest patch release, e.g. 1.31.13
+	EndOfLife time.Time `json:"end_of_life"` // end of upstream patch support
+	CNIs      []string  `json:"cnis"`        // CNI plugins validated with the release
+}
+
+// ReleaseInfo is a release with its support state at a point in time
+type ReleaseInfo struct {
+	Release
+	Supported bool `json:"supported"` // new clusters may be created with it
+	Default   bool `json:"default"`   // used when a cluster has no version
+}
+
+var (
+	releasesMu sync.RWMutex
+	// releases are the validated minor releases, oldest first. Releases past
+	// their end of life are kept so existing clusters can be upgraded
+	// through them.
+	releases = []Release{
+		{Minor: "1.28", Latest: "1.28.15", EndOfLife: date(2024, 10, 28), CNIs: []string{"calico", "flannel", "weave", "cilium"}},
+		{Minor: "1.29", Latest: "1.29.15", EndOfLife: date(2025, 2, 28), CNIs: []string{"calico", "flannel", "weave", "cilium"}},
+		{Minor: "1.30", Latest: "1.30.14", EndOfLife: date(2025, 6, 28), CNIs: []string{"calico", "flannel", "weave", "cilium"}},
+		{Minor: "1.31", Latest: "1.31.13", EndOfLife: date(2025, 10, 28), CNIs: []string{"calico", "flannel", "cilium"}},
+		{Minor: "1.32", Latest: "1.32.9", EndOfLife: date(2026, 2, 28), CNIs: []string{"calico", "flannel", "cilium"}},
+		{Minor: "1.33", Latest: "1.33.5", EndOfLife: date(2026, 6, 28), CNIs: []string{"calico", "flannel", "cilium"}},
+		{Minor: "1.34", Latest: "1.34.1", EndOfLife: date(2026, 10, 27), CNIs: []string{"calico", "flannel", "cilium"}},
+		{Minor: "1.35", Latest: "1.35.0", EndOfLife: date(2027, 2, 28), CNIs: []string{"calico", "flannel", "cilium"}},
+	}
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Releases returns the validated releases, newest first, with their support
+// state at now
+func Releases(now time.Time) []ReleaseInfo {
+	releasesMu.RLock()
+	defer releasesMu.RUnlock()
+
+	infos := make([]ReleaseInfo, 0, len(releases))
+	defaulted := false
+	for i := len(releases) - 1; i >= 0; i-- {
+		info := ReleaseInfo{Release: releases[i], Supported: now.Before(releases[i].EndOfLife)}
+		info.CNIs = append([]string(nil), releases[i].CNIs...)
+		if info.Supported && !defaulted {
+			info.Default, defaulted = true, true
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// DefaultVersion returns the latest patch of the newest supported release
+func DefaultVersion() string {
+	infos := Releases(time.Now())
+	for _, info := range infos {
+		if info.Default {
+			return info.Latest
+		}
+	}
+	// every release is past its end of life, the catalog needs updating
+	return infos[0].Latest
+}
+
+// findRelease returns the release of a version
+func findRelease(v Version) (Release, bool) {
+	minor := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	releasesMu.RLock()
+	defer releasesMu.RUnlock()
+	for _, release := range releases {
+		if release.Minor == minor {
+			return release, true
+		}
+	}
+	return Release{}, false
+}
+
+// LatestPatch returns the latest patch release of the release of a version
+func LatestPatch(version string) (string, bool) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return "", false
+	}
+	release, ok := findRelease(v)
+	if !ok {
+		return "", false
+	}
+	return release.Latest, true
+}
+
+// ValidateVersion checks that new clusters can be created with a version: it
+// must be a patch of a validated release that has not reached its end of life
+func ValidateVersion(version string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	release, ok := findRelease(v)
+	if !ok {
+		return fmt.Errorf("unsupported k8s version %s, expected one of %s", v, strings.Join(supportedMinors(), ", "))
+	}
+	if !time.Now().Before(release.EndOfLife) {
+		return fmt.Errorf("k8s %s reached its end of life on %s, expected one of %s", release.Minor, release.EndOfLife.Format(time.DateOnly), strings.Join(supportedMinors(), ", "))
+	}
+	return nil
+}
+
+// ValidateCNI checks that a CNI plugin is validated with a version. Versions
+// outside the catalog are left to ValidateVersion.
+func ValidateCNI(version, cni string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return nil
+	}
+	release, ok := findRelease(v)
+	if !ok {
+		return nil
+	}
+	for _, compatible := range release.CNIs {
+		if compatible == cni {
+			return nil
+		}
+	}
+	return fmt.Errorf("CNI %q is not validated with k8s %s, expected one of %s", cni, release.Minor, strings.Join(release.CNIs, ", "))
+}
+
+// supportedMinors returns the minor releases new clusters can be created with
+func supportedMinors() []string {
+	var minors []string
+	for _, info := range Releases(time.Now()) {
+		if info.Supported {
+			minors = append(minors, info.Minor)
+		}
+	}
+	sort.Strings(minors)
+	return minors
+}
+
+// RefreshReleases updates the latest patch of every release from the
+// upstream release feed, which serves the latest version of a minor release
+// at <feed>/stable-<minor>.txt. Releases the feed cannot be read for are
+// left unchanged and reported in the returned error.
+func RefreshReleases(ctx context.Context, client *http.Client, feed string) error {
+	releasesMu.RLock()
+	minors := make([]string, len(releases))
+	for i, release := range releases {
+		minors[i] = release.Minor
+	}
+	releasesMu.RUnlock()
+
+	latest := make(map[string]string, len(minors))
+	var failed []string
+	for _, minor := range minors {
+		version, err := fetchStableVersion(ctx, client, strings.TrimSuffix(feed, "/")+"/stable-"+minor+".txt")
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", minor, err))
+			continue
+		}
+		if fmt.Sprintf("%d.%d", version.Major, version.Minor) != minor {
+			failed = append(failed, fmt.Sprintf("%s: feed returned %s", minor, version))
+			continue
+		}
+		latest[minor] = version.String()
+	}
+
+	releasesMu.Lock()
+	for i := range releases {
+		version, ok := latest[releases[i].Minor]
+		if !ok {
+			continue
+		}
+		current, err := ParseVersion(releases[i].Latest)
+		if next, _ := ParseVersion(version); err != nil || current.Less(next) {
+			releases[i].Latest = version
+		}
+	}
+	releasesMu.Unlock()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to refresh releases: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// fetchStableVersion reads the version served by a stable-<minor>.txt file
+func fetchStableVersion(ctx context.Context, client *http.Client, url string) (Version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Version{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Version{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Version{}, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(strings.TrimSpace(string(body)))
+}
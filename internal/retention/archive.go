@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubeforge/internal/sigv4"
+)
+
+// FileArchiver writes archives into a local directory
+type FileArchiver struct {
+	Dir string
+}
+
+// NewFileArchiver creates the archive directory if needed
+func NewFileArchiver(dir string) (*FileArchiver, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &FileArchiver{Dir: dir}, nil
+}
+
+// Archive writes data to a new file, never replacing an existing archive
+func (a *FileArchiver) Archive(ctx context.Context, name string, data []byte) error {
+	file, err := os.OpenFile(filepath.Join(a.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// S3Archiver uploads archives to an S3 compatible object store. Requests are
+// signed with AWS Signature Version 4 and use path-style URLs, which MinIO
+// and other S3 compatible stores accept as well.
+type S3Archiver struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com
+	Region    string
+	Bucket    string
+	Prefix    string // key prefix, e.g. kubeforge/events/
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+}
+
+// NewS3Archiver checks the settings of an S3 archiver
+func NewS3Archiver(a S3Archiver) (*S3Archiver, error) {
+	if a.Bucket == "" || a.AccessKey == "" || a.SecretKey == "" {
+		return nil, fmt.Errorf("S3 archive requires a bucket, access key and secret key")
+	}
+	if a.Region == "" {
+		a.Region = "us-east-1"
+	}
+	if a.Endpoint == "" {
+		a.Endpoint = "https://s3." + a.Region + ".amazonaws.com"
+	}
+	if _, err := url.Parse(a.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	a.Endpoint = strings.TrimSuffix(a.Endpoint, "/")
+	a.client = &http.Client{Timeout: 5 * time.Minute}
+	return &a, nil
+}
+
+// Archive uploads data as an object under the configured prefix
+func (a *S3Archiver) Archive(ctx context.Context, name string, data []byte) error {
+	key := strings.TrimPrefix(a.Prefix+name, "/")
+	target, err := url.Parse(a.Endpoint + "/" + a.Bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/gzip")
+	sigv4.Sign(req, data, sigv4.Credentials{AccessKey: a.AccessKey, SecretKey: a.SecretKey}, a.Region, "s3", time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"kubeforge/internal/db"
+	"kubeforge/internal/lock"
+)
+
+// batchSize is the number of events archived and deleted at once
+const batchSize = 1000
+
+// lockName guards pruning so only one replica archives each event
+const lockName = "retention:events"
+
+// Policy limits how long and how many events are kept, and how long deleted
+// records are. Zero values disable the corresponding limit.
+type Policy struct {
+	MaxAge        time.Duration // events older than this are pruned
+	MaxPerCluster int           // only the newest events of each cluster are kept
+	DeletedMaxAge time.Duration // clusters, hosts and SSH keys deleted longer ago are purged
+	Interval      time.Duration // how often pruning runs
+}
+
+// Enabled reports whether the policy prunes anything
+func (p Policy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxPerCluster > 0 || p.DeletedMaxAge > 0
+}
+
+// PurgeResult counts the records permanently removed by a purge
+type PurgeResult struct {
+	Clusters int   `json:"clusters"`
+	Events   int   `json:"events"` // events of the purged clusters
+	Hosts    int64 `json:"hosts"`
+	SSHKeys  int64 `json:"ssh_keys"`
+}
+
+// Archiver stores pruned events before they are deleted
+type Archiver interface {
+	Archive(ctx context.Context, name string, data []byte) error
+}
+
+// Pruner periodically deletes events outside the retention policy, handing
+// them to an archiver first when one is configured. Replicas sharing a
+// database take turns through an advisory lock.
+type Pruner struct {
+	conn       *gorm.DB
+	archiver   Archiver
+	instanceID string
+
+	mu     sync.Mutex
+	policy Policy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPruner creates a pruner of the events in conn. archiver may be nil to
+// delete without archiving.
+func NewPruner(conn *gorm.DB, policy Policy, archiver Archiver, instanceID string) *Pruner {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pruner{
+		conn:       conn,
+		policy:     policy,
+		archiver:   archiver,
+		instanceID: instanceID,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start runs pruning in the background, once right away and then every
+// interval. Nothing is pruned while the policy is disabled.
+func (p *Pruner) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// SetPolicy replaces the policy, taking effect from the next pass
+func (p *Pruner) SetPolicy(policy Policy) {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Hour
+	}
+	p.mu.Lock()
+	p.policy = policy
+	p.mu.Unlock()
+}
+
+// Policy returns the current policy
+func (p *Pruner) Policy() Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.policy
+}
+
+// Stop waits for a running pruning pass to finish its current batch
+func (p *Pruner) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *Pruner) run() {
+	defer p.wg.Done()
+
+	interval := p.Policy().Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		policy := p.Policy()
+		if policy.Interval != interval {
+			interval = policy.Interval
+			ticker.Reset(interval)
+		}
+
+		if policy.Enabled() {
+			p.pruneLocked(interval)
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneLocked prunes if no other replica did within the interval
+func (p *Pruner) pruneLocked(interval time.Duration) {
+	acquired, err := lock.Acquire(p.conn, lockName, p.instanceID, interval)
+	if err != nil {
+		slog.Error("Failed to acquire event retention lock", "error", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	pruned, err := p.Prune(p.ctx)
+	if err != nil {
+		slog.Error("Event pruning stopped", "pruned", pruned, "error", err)
+	} else if pruned > 0 {
+		slog.Info("Pruned events", "count", pruned)
+	}
+
+	if maxAge := p.Policy().DeletedMaxAge; maxAge > 0 && err == nil {
+		result, err := p.Purge(p.ctx, time.Now().Add(-maxAge))
+		if err != nil {
+			slog.Error("Purging deleted records stopped", "clusters", result.Clusters, "error", err)
+		} else if result != (PurgeResult{}) {
+			slog.Info("Purged deleted records", "clusters", result.Clusters, "events", result.Events, "hosts", result.Hosts, "ssh_keys", result.SSHKeys)
+		}
+	}
+	// Keep the lock until it expires so other replicas skip this interval
+}
+
+// Prune archives and deletes the events outside the policy and returns how
+// many were deleted
+func (p *Pruner) Prune(ctx context.Context) (int, error) {
+	policy := p.Policy()
+	total := 0
+
+	if policy.MaxAge > 0 {
+		cutoff := time.Now().Add(-policy.MaxAge)
+		n, err := p.pruneWhere(ctx, "timestamp < ?", cutoff)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if policy.MaxPerCluster > 0 {
+		var clusterIDs []uint
+		err := p.conn.Model(&db.Event{}).
+			Select("cluster_id").
+			Group("cluster_id").
+			Having("COUNT(*) > ?", policy.MaxPerCluster).
+			Pluck("cluster_id", &clusterIDs).Error
+		if err != nil {
+			return total, err
+		}
+
+		for _, clusterID := range clusterIDs {
+			// The newest event beyond the limit and everything before it go
+			var oldest db.Event
+			err := p.conn.Select("id").Where("cluster_id = ?", clusterID).
+				Order("id desc").Offset(policy.MaxPerCluster).Limit(1).
+				Take(&oldest).Error
+			if err != nil {
+				continue
+			}
+			n, err := p.pruneWhere(ctx, "cluster_id = ? AND id <= ?", clusterID, oldest.ID)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// Purge permanently removes the clusters, hosts and SSH keys deleted before
+// cutoff. The events of each cluster are archived like pruned events before
+// the cluster is removed with the rest of its records.
+func (p *Pruner) Purge(ctx context.Context, cutoff time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	clusterIDs, err := db.DeletedClusters(p.conn, cutoff)
+	if err != nil {
+		return result, err
+	}
+	for _, clusterID := range clusterIDs {
+		n, err := p.pruneWhere(ctx, "cluster_id = ?", clusterID)
+		result.Events += n
+		if err != nil {
+			return result, err
+		}
+		if err := db.PurgeCluster(p.conn, clusterID); err != nil {
+			return result, fmt.Errorf("failed to purge cluster %d: %w", clusterID, err)
+		}
+		result.Clusters++
+	}
+
+	result.Hosts, result.SSHKeys, err = db.PurgeInventory(p.conn, cutoff)
+	return result, err
+}
+
+// pruneWhere archives and deletes the events matching a condition in batches
+func (p *Pruner) pruneWhere(ctx context.Context, query string, args ...interface{}) (int, error) {
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		var events []db.Event
+		if err := p.conn.Where(query, args...).Order("id").Limit(batchSize).Find(&events).Error; err != nil {
+			return total, err
+		}
+		if len(events) == 0 {
+			return total, nil
+		}
+
+		// Events are only deleted once they are safely archived
+		if p.archiver != nil {
+			data, err := encode(events)
+			if err != nil {
+				return total, err
+			}
+			name := fmt.Sprintf("events-%s-%d-%d.jsonl.gz",
+				time.Now().UTC().Format("20060102T150405Z"), events[0].ID, events[len(events)-1].ID)
+			if err := p.archiver.Archive(ctx, name, data); err != nil {
+				return total, fmt.Errorf("failed to archive events: %w", err)
+			}
+		}
+
+		ids := make([]uint, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		if err := p.conn.Delete(&db.Event{}, ids).Error; err != nil {
+			return total, err
+		}
+		total += len(events)
+
+		if len(events) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// encode writes events as gzipped JSON lines
+func encode(events []db.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"crypto/cipher"
+	"sync"
+	"time"
+)
+
+// A data key seals new values for up to dataKeyTTL, so an external provider
+// is called a few times an hour rather than on every write. Unwrapped data
+// keys are kept for reads, up to maxCachedKeys.
+const (
+	dataKeyTTL    = 5 * time.Minute
+	maxCachedKeys = 1024
+)
+
+// dataKey is a data key ready to seal values, with its wrapped form
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+	id      string // master key it is wrapped with
+	created time.Time
+}
+
+// keyCache holds the data key used for new values and the data keys of
+// values read recently
+type keyCache struct {
+	activeMu sync.Mutex // held while a new data key is wrapped
+	active   *dataKey
+
+	mu        sync.Mutex
+	unwrapped map[string]cipher.AEAD // by master key ID and wrapped data key
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{unwrapped: make(map[string]cipher.AEAD)}
+}
+
+// reset forgets all data keys, e.g. after the master key changed
+func (c *keyCache) reset() {
+	c.activeMu.Lock()
+	c.active = nil
+	c.activeMu.Unlock()
+
+	c.mu.Lock()
+	c.unwrapped = make(map[string]cipher.AEAD)
+	c.mu.Unlock()
+}
+
+// current returns the data key for new values under master key id, creating
+// one with generate when there is none or it expired
+func (c *keyCache) current(id string, generate func() (plain, wrapped []byte, err error)) (*dataKey, error) {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	if c.active != nil && c.active.id == id && time.Since(c.active.created) < dataKeyTTL {
+		return c.active, nil
+	}
+	plain, wrapped, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return nil, err
+	}
+	c.active = &dataKey{aead: aead, wrapped: wrapped, id: id, created: time.Now()}
+	c.mu.Lock()
+	c.store(id, wrapped, aead)
+	c.mu.Unlock()
+	return c.active, nil
+}
+
+// unwrap returns the cipher of a wrapped data key, calling fn to unwrap it
+// when it is not cached
+func (c *keyCache) unwrap(id string, wrapped []byte, fn func() ([]byte, error)) (cipher.AEAD, error) {
+	c.mu.Lock()
+	aead := c.unwrapped[id+"\x00"+string(wrapped)]
+	c.mu.Unlock()
+	if aead != nil {
+		return aead, nil
+	}
+
+	plain, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = newAEAD(plain); err != nil {
+		return nil, ErrDecryptFailed
+	}
+	c.mu.Lock()
+	c.store(id, wrapped, aead)
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// store caches an unwrapped data key. The caller holds c.mu.
+func (c *keyCache) store(id string, wrapped []byte, aead cipher.AEAD) {
+	if len(c.unwrapped) >= maxCachedKeys {
+		c.unwrapped = make(map[string]cipher.AEAD)
+	}
+	c.unwrapped[id+"\x00"+string(wrapped)] = aead
+}
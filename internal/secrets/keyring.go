@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Keyring is a KeyProvider holding local AES master keys, e.g. from
+// ENCRYPTION_KEY
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring wrapping data keys with key. Previous keys are
+// only used to unwrap.
+func NewKeyring(key []byte, previous ...[]byte) (*Keyring, error) {
+	ring := &Keyring{current: KeyID(key), keys: make(map[string]cipher.AEAD, len(previous)+1)}
+	for _, k := range append([][]byte{key}, previous...) {
+		gcm, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[KeyID(k)] = gcm
+	}
+	return ring, nil
+}
+
+// NewKeyringBase64 creates a keyring from base64 encoded keys
+func NewKeyringBase64(encoded string, previous []string) (*Keyring, error) {
+	key, err := decodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var old [][]byte
+	for _, p := range previous {
+		k, err := decodeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		old = append(old, k)
+	}
+	return NewKeyring(key, old...)
+}
+
+// KeyID returns the short fingerprint identifying a local master key in
+// envelopes
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// KeyID implements KeyProvider
+func (r *Keyring) KeyID() string {
+	return r.current
+}
+
+// HasKey implements KeyProvider
+func (r *Keyring) HasKey(id string) bool {
+	return r.keys[id] != nil
+}
+
+// WrapKey seals a data key with the current master key
+func (r *Keyring) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return seal(r.keys[r.current], dataKey)
+}
+
+// UnwrapKey opens a data key sealed with the master key id
+func (r *Keyring) UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	kek := r.keys[id]
+	if kek == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	return open(kek, wrapped)
+}
+
+// openLegacy opens a value sealed directly with one of the master keys
+func (r *Keyring) openLegacy(ciphertext []byte) ([]byte, error) {
+	for _, kek := range r.keys {
+		if plaintext, err := open(kek, ciphertext); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrDecryptFailed
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 encryption key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,259 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"kubeforge/internal/sigv4"
+)
+
+// AWSKMS is a KeyProvider wrapping data keys with an AWS KMS key
+type AWSKMS struct {
+	Key         string // key ID, ARN or alias/name
+	Region      string
+	Endpoint    string // defaults to the KMS endpoint of Region
+	Credentials sigv4.Credentials
+
+	client *http.Client
+}
+
+// NewAWSKMS checks the settings of an AWS KMS provider
+func NewAWSKMS(k AWSKMS) (*AWSKMS, error) {
+	if k.Key == "" || k.Region == "" {
+		return nil, fmt.Errorf("awskms provider requires a key and a region")
+	}
+	if k.Credentials.AccessKey == "" || k.Credentials.SecretKey == "" {
+		return nil, fmt.Errorf("awskms provider requires AWS access keys")
+	}
+	if k.Endpoint == "" {
+		k.Endpoint = "https://kms." + k.Region + ".amazonaws.com"
+	}
+	k.Endpoint = strings.TrimSuffix(k.Endpoint, "/")
+	k.client = &http.Client{Timeout: providerTimeout}
+	return &k, nil
+}
+
+// KeyID implements KeyProvider
+func (k *AWSKMS) KeyID() string {
+	return "awskms:" + k.Key
+}
+
+// HasKey implements KeyProvider
+func (k *AWSKMS) HasKey(id string) bool {
+	return id == k.KeyID()
+}
+
+// WrapKey encrypts a data key with the KMS key
+func (k *AWSKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob []byte
+	}
+	err := k.call(ctx, "Encrypt", map[string]interface{}{"KeyId": k.Key, "Plaintext": dataKey}, &resp)
+	return resp.CiphertextBlob, err
+}
+
+// UnwrapKey decrypts a data key with the KMS key
+func (k *AWSKMS) UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	err := k.call(ctx, "Decrypt", map[string]interface{}{"KeyId": k.Key, "CiphertextBlob": wrapped}, &resp)
+	return resp.Plaintext, err
+}
+
+// call invokes a KMS action. Binary fields are base64 encoded in JSON, as
+// encoding/json does for []byte.
+func (k *AWSKMS) call(ctx context.Context, action string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.Endpoint+"/", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	sigv4.Sign(req, data, k.Credentials, k.Region, "kms", time.Now())
+	return doJSON(k.client, req, "kms "+action, out)
+}
+
+// GCPKMS is a KeyProvider wrapping data keys with a Google Cloud KMS key. It
+// authenticates with a service account key file, or else with the metadata
+// server of GCE and GKE (workload identity).
+type GCPKMS struct {
+	Key             string // projects/P/locations/L/keyRings/R/cryptoKeys/K
+	CredentialsFile string // service account JSON key
+	Endpoint        string // defaults to https://cloudkms.googleapis.com
+
+	client *http.Client
+	auth   *gcpToken
+}
+
+// gcpToken caches an OAuth access token
+type gcpToken struct {
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// gcpScope is the OAuth scope of Cloud KMS
+const gcpScope = "https://www.googleapis.com/auth/cloudkms"
+
+// gcpMetadataToken is the metadata server endpoint issuing access tokens
+const gcpMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// NewGCPKMS checks the settings of a Google Cloud KMS provider
+func NewGCPKMS(k GCPKMS) (*GCPKMS, error) {
+	if !strings.HasPrefix(k.Key, "projects/") || !strings.Contains(k.Key, "/cryptoKeys/") {
+		return nil, fmt.Errorf("gcpkms provider requires a key name like projects/P/locations/L/keyRings/R/cryptoKeys/K")
+	}
+	if k.Endpoint == "" {
+		k.Endpoint = "https://cloudkms.googleapis.com"
+	}
+	k.Endpoint = strings.TrimSuffix(k.Endpoint, "/")
+	return &GCPKMS{
+		Key:             k.Key,
+		CredentialsFile: k.CredentialsFile,
+		Endpoint:        k.Endpoint,
+		client:          &http.Client{Timeout: providerTimeout},
+		auth:            &gcpToken{},
+	}, nil
+}
+
+// KeyID implements KeyProvider
+func (k *GCPKMS) KeyID() string {
+	return "gcpkms:" + k.Key
+}
+
+// HasKey implements KeyProvider
+func (k *GCPKMS) HasKey(id string) bool {
+	return id == k.KeyID()
+}
+
+// WrapKey encrypts a data key with the KMS key
+func (k *GCPKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err := k.call(ctx, "encrypt", map[string][]byte{"plaintext": dataKey}, &resp)
+	return resp.Ciphertext, err
+}
+
+// UnwrapKey decrypts a data key with the KMS key
+func (k *GCPKMS) UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := k.call(ctx, "decrypt", map[string][]byte{"ciphertext": wrapped}, &resp)
+	return resp.Plaintext, err
+}
+
+func (k *GCPKMS) call(ctx context.Context, method string, body interface{}, out interface{}) error {
+	token, err := k.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.Endpoint+"/v1/"+k.Key+":"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doJSON(k.client, req, "cloud kms "+method, out)
+}
+
+// accessToken returns a cached OAuth access token, fetching a new one
+// shortly before it expires
+func (k *GCPKMS) accessToken(ctx context.Context) (string, error) {
+	k.auth.mu.Lock()
+	defer k.auth.mu.Unlock()
+	if k.auth.token != "" && time.Until(k.auth.expiry) > time.Minute {
+		return k.auth.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if k.CredentialsFile != "" {
+		req, err = k.serviceAccountTokenRequest(ctx)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataToken, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(k.client, req, "google token request", &resp); err != nil {
+		return "", err
+	}
+	k.auth.token = resp.AccessToken
+	k.auth.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return k.auth.token, nil
+}
+
+// serviceAccountTokenRequest builds the OAuth JWT bearer grant of a service
+// account key
+func (k *GCPKMS) serviceAccountTokenRequest(ctx context.Context) (*http.Request, error) {
+	data, err := os.ReadFile(k.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read google credentials: %w", err)
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid google credentials: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid google credentials: %w", err)
+	}
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   account.ClientEmail,
+		"scope": gcpScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// passphraseMagic starts every value sealed with a passphrase
+var passphraseMagic = []byte("KFP1")
+
+// passphraseSaltSize is the length of the random salt of the key derivation
+const passphraseSaltSize = 16
+
+// ErrNoPassphrase is returned when sealing or opening with an empty
+// passphrase
+var ErrNoPassphrase = errors.New("passphrase is required")
+
+// SealWithPassphrase encrypts plaintext with a key derived from passphrase,
+// for secrets leaving the server such as state exports. The value is
+//
+//	magic | salt | nonce | ciphertext
+func SealWithPassphrase(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(aead, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(passphraseMagic)+len(salt)+len(sealed))
+	out = append(out, passphraseMagic...)
+	out = append(out, salt...)
+	return append(out, sealed...), nil
+}
+
+// OpenWithPassphrase decrypts a value produced by SealWithPassphrase
+func OpenWithPassphrase(ciphertext []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, passphraseMagic) || len(ciphertext) < len(passphraseMagic)+passphraseSaltSize {
+		return nil, ErrDecryptFailed
+	}
+	rest := ciphertext[len(passphraseMagic):]
+	key, err := passphraseKey(passphrase, rest[:passphraseSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, rest[passphraseSaltSize:])
+}
+
+// passphraseKey derives an AES key from a passphrase with scrypt
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrNoPassphrase
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, KeySize)
+}
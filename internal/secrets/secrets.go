@@ -0,0 +1,280 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the required length of the encryption key (AES-256)
+const KeySize = 32
+
+// providerTimeout bounds a call to wrap or unwrap a data key
+const providerTimeout = 10 * time.Second
+
+// Common errors
+var (
+	ErrNoKey         = errors.New("encryption key not configured")
+	ErrInvalidKey    = errors.New("encryption key must be 32 bytes")
+	ErrDecryptFailed = errors.New("failed to decrypt secret")
+	ErrUnknownKey    = errors.New("secret was encrypted with an unknown key")
+)
+
+// magic starts every envelope. Values without it were sealed directly with
+// the master key by earlier versions and are still readable.
+var magic = []byte("KFE1")
+
+// KeyProvider holds master keys and wraps the data keys of envelopes with
+// them: a local keyring, or an external service such as Vault or a KMS.
+type KeyProvider interface {
+	// KeyID names the master key new data keys are wrapped with
+	KeyID() string
+	// HasKey reports whether data keys wrapped under id can be unwrapped
+	HasKey(id string) bool
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error)
+}
+
+// Values are sealed with envelope encryption: each gets a data key, which is
+// itself wrapped by the master key of a KeyProvider. An envelope is
+//
+//	magic | key ID length (1) | key ID | wrapped data key length (2) |
+//	wrapped data key | nonce | ciphertext
+//
+// The key ID names the master key, so values sealed before a rotation or a
+// change of provider stay readable while the previous one is configured.
+var (
+	mu        sync.RWMutex
+	providers []KeyProvider // the first wraps new data keys
+	dataKeys  = newKeyCache()
+)
+
+// SetProvider configures the provider new data keys are wrapped with and
+// previous providers still used to unwrap them
+func SetProvider(current KeyProvider, previous ...KeyProvider) {
+	mu.Lock()
+	providers = append([]KeyProvider{current}, previous...)
+	mu.Unlock()
+	dataKeys.reset()
+}
+
+// SetKey configures the key used to encrypt secrets at rest
+func SetKey(key []byte) error {
+	return SetKeys(key)
+}
+
+// SetKeys configures the master key new secrets are sealed with and
+// previous keys still accepted for reading them, e.g. during a rotation
+func SetKeys(key []byte, previous ...[]byte) error {
+	ring, err := NewKeyring(key, previous...)
+	if err != nil {
+		return err
+	}
+	SetProvider(ring)
+	return nil
+}
+
+// SetKeyBase64 configures the key from its base64 encoding
+func SetKeyBase64(encoded string) error {
+	return SetKeysBase64(encoded, nil)
+}
+
+// SetKeysBase64 configures the current and previous keys from their base64
+// encodings
+func SetKeysBase64(encoded string, previous []string) error {
+	ring, err := NewKeyringBase64(encoded, previous)
+	if err != nil {
+		return err
+	}
+	SetProvider(ring)
+	return nil
+}
+
+// ReadKeyFile reads base64 encoded keys, one per line, from a file such as
+// a mounted Kubernetes secret. The first key is the current one, the others
+// are previous keys. Empty lines and lines starting with # are skipped.
+func ReadKeyFile(path string) (string, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil, fmt.Errorf("key file %s contains no key", path)
+	}
+	return lines[0], lines[1:], nil
+}
+
+// Enabled reports whether an encryption key is configured
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(providers) > 0
+}
+
+// Encrypt seals plaintext in an envelope under the current master key
+func Encrypt(plaintext []byte) ([]byte, error) {
+	mu.RLock()
+	var provider KeyProvider
+	if len(providers) > 0 {
+		provider = providers[0]
+	}
+	mu.RUnlock()
+	if provider == nil {
+		return nil, ErrNoKey
+	}
+
+	id := provider.KeyID()
+	key, err := dataKeys.current(id, func() ([]byte, []byte, error) {
+		dataKey := make([]byte, KeySize)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+		defer cancel()
+		wrapped, err := provider.WrapKey(ctx, dataKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		return dataKey, wrapped, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(key.aead, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(magic)+1+len(id)+2+len(key.wrapped)+len(sealed))
+	out = append(out, magic...)
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(key.wrapped)))
+	out = append(out, key.wrapped...)
+	return append(out, sealed...), nil
+}
+
+// Decrypt opens a value produced by Encrypt with any configured provider
+func Decrypt(ciphertext []byte) ([]byte, error) {
+	mu.RLock()
+	configured := providers
+	mu.RUnlock()
+	if len(configured) == 0 {
+		return nil, ErrNoKey
+	}
+
+	if id, wrapped, sealed, ok := parseEnvelope(ciphertext); ok {
+		var provider KeyProvider
+		for _, p := range configured {
+			if p.HasKey(id) {
+				provider = p
+				break
+			}
+		}
+		if provider == nil {
+			return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+		}
+		aead, err := dataKeys.unwrap(id, wrapped, func() ([]byte, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+			defer cancel()
+			return provider.UnwrapKey(ctx, id, wrapped)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return open(aead, sealed)
+	}
+
+	// Sealed directly with a local master key by an earlier version
+	for _, p := range configured {
+		if ring, ok := p.(*Keyring); ok {
+			if plaintext, err := ring.openLegacy(ciphertext); err == nil {
+				return plaintext, nil
+			}
+		}
+	}
+	return nil, ErrDecryptFailed
+}
+
+// Current reports whether a value is an envelope sealed with the current
+// master key, i.e. whether it needs no re-encryption after a rotation
+func Current(ciphertext []byte) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	id, _, _, ok := parseEnvelope(ciphertext)
+	return ok && len(providers) > 0 && id == providers[0].KeyID()
+}
+
+// parseEnvelope splits an envelope into the key ID, the wrapped data key and
+// the sealed value
+func parseEnvelope(data []byte) (string, []byte, []byte, bool) {
+	if !bytes.HasPrefix(data, magic) {
+		return "", nil, nil, false
+	}
+	rest := data[len(magic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+		return "", nil, nil, false
+	}
+	id := string(rest[1 : 1+rest[0]])
+	rest = rest[1+int(rest[0]):]
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return "", nil, nil, false
+	}
+	return id, rest[:n], rest[n:], true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// seal encrypts with AES-GCM, prefixing the random nonce
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a value produced by seal
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
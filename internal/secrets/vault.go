@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// VaultTransit is a KeyProvider wrapping data keys with a key of the Vault
+// transit secrets engine, so the master key never leaves Vault. Key versions
+// are handled by Vault: rotating the transit key needs no change here.
+type VaultTransit struct {
+	Address   string // e.g. https://vault.example.com:8200
+	Token     string
+	TokenFile string // read on every call, e.g. the sink of a Vault agent
+	Namespace string // Vault Enterprise namespace
+	Mount     string // path of the transit engine, default transit
+	Key       string // name of the transit key
+
+	client *http.Client
+}
+
+// NewVaultTransit checks the settings of a Vault transit provider
+func NewVaultTransit(v VaultTransit) (*VaultTransit, error) {
+	if v.Address == "" || v.Key == "" {
+		return nil, fmt.Errorf("vault provider requires an address and a transit key")
+	}
+	if v.Token == "" && v.TokenFile == "" {
+		return nil, fmt.Errorf("vault provider requires a token or token file")
+	}
+	if v.Mount == "" {
+		v.Mount = "transit"
+	}
+	v.Address = strings.TrimSuffix(v.Address, "/")
+	v.Mount = strings.Trim(v.Mount, "/")
+	v.client = &http.Client{Timeout: providerTimeout}
+	return &v, nil
+}
+
+// KeyID implements KeyProvider
+func (v *VaultTransit) KeyID() string {
+	return "vault:" + v.Mount + "/" + v.Key
+}
+
+// HasKey implements KeyProvider
+func (v *VaultTransit) HasKey(id string) bool {
+	return id == v.KeyID()
+}
+
+// WrapKey encrypts a data key with the transit key
+func (v *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := v.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+// UnwrapKey decrypts a data key with the transit key
+func (v *VaultTransit) UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// call posts to an operation of the transit key
+func (v *VaultTransit) call(ctx context.Context, operation string, body interface{}, out interface{}) error {
+	token := v.Token
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", v.Address, v.Mount, operation, v.Key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	return doJSON(v.client, req, "vault "+operation, out)
+}
+
+// doJSON sends a request and decodes a JSON response, turning error statuses
+// into errors
+func doJSON(client *http.Client, req *http.Request, what string, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s failed: %s: %s", what, resp.Status, strings.TrimSpace(string(body[:min(len(body), 512)])))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%s returned an invalid response: %w", what, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Error codes of field errors
+const (
+	CodeRequired    = "required"
+	CodeInvalid     = "invalid"
+	CodeDuplicate   = "duplicate"
+	CodeOverlap     = "overlap"
+	CodeUnsupported = "unsupported"
+	CodeOutOfRange  = "out_of_range"
+	CodeImmutable   = "immutable"
+	CodeUnknown     = "unknown"
+	CodePolicy      = "policy" // allowed by the API but not by the cluster policy of the server
+)
+
+// Codes of warnings only, about specs that are valid but likely a mistake
+const (
+	CodeQuorum       = "quorum"
+	CodeVersionSkew  = "version_skew"
+	CodeArchitecture = "architecture"
+)
+
+// FieldError describes a problem with a single request field. Field is the
+// JSON path of the field, e.g. control_planes[0].address.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors is a list of field errors. A non-empty list is an error.
+type Errors []FieldError
+
+// Error joins the messages of all field errors
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return "invalid spec: " + strings.Join(messages, "; ")
+}
+
+// Add appends a field error
+func (e *Errors) Add(field, code, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns the errors as an error, or nil if there are none
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Path joins a parent field path and a child field name
+func Path(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
+
+// Index returns the path of an element of a list field
+func Index(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
+
+// CIDR parses a network in CIDR notation
+func CIDR(value string) (*net.IPNet, error) {
+	ip, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid CIDR", value)
+	}
+	if !ip.Equal(network.IP) {
+		return nil, fmt.Errorf("%q has host bits set, did you mean %s", value, network)
+	}
+	return network, nil
+}
+
+// Overlaps reports whether two networks share any address
+func Overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// Host reports whether value is an IP address or a DNS name
+func Host(value string) bool {
+	if net.ParseIP(value) != nil {
+		return true
+	}
+	return len(value) <= 253 && hostnamePattern.MatchString(value)
+}
+
+// Endpoint reports whether value is a host optionally followed by a port
+func Endpoint(value string) bool {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return Host(value)
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535 && Host(host)
+}
+
+// OneOf reports whether value is one of the allowed values
+func OneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?$`)
+
+// LabelKey reports whether value is a Kubernetes style label key: a name
+// of at most 63 characters with an optional DNS prefix, e.g.
+// example.com/team
+func LabelKey(value string) bool {
+	prefix, name, ok := strings.Cut(value, "/")
+	if !ok {
+		return labelNamePattern.MatchString(value)
+	}
+	return len(prefix) <= 253 && hostnamePattern.MatchString(prefix) && labelNamePattern.MatchString(name)
+}
+
+// LabelValue reports whether value is a Kubernetes style label value
+func LabelValue(value string) bool {
+	return value == "" || labelNamePattern.MatchString(value)
+}
@@ -0,0 +1,433 @@
+// Package client is a Go client of the KubeForge REST API. It logs in and
+// refreshes tokens, retries requests that failed on the way or were
+// rejected by an overloaded server, decodes the response envelope into
+// typed values and errors, and streams cluster events over WebSocket.
+//
+//	c, err := client.New("https://kubeforge.example.com", client.WithCredentials("admin", password))
+//	clusters, err := c.ListClusters(ctx, client.ListClustersOptions{Labels: []string{"env=prod"}})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userAgent identifies the client in the server's request log
+const userAgent = "kubeforge-go-client"
+
+// requestIDHeader carries the ID the server gives each request
+const requestIDHeader = "X-Request-ID"
+
+// RetryPolicy controls how often failed requests are tried again. Requests
+// are retried after network errors and 429, 502, 503 and 504 responses, when
+// repeating them is safe: reads, PUT and DELETE, and creations with an
+// idempotency key.
+type RetryPolicy struct {
+	Attempts       int           // tries of a request, 1 disables retries
+	InitialBackoff time.Duration // wait before the first retry, doubled for each next one
+	MaxBackoff     time.Duration // longest wait between tries
+}
+
+// DefaultRetryPolicy is the retry policy of new clients
+var DefaultRetryPolicy = RetryPolicy{Attempts: 4, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// Client calls the API of a KubeForge server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retry      RetryPolicy
+	userAgent  string
+
+	mu           sync.Mutex // guards the tokens
+	accessToken  string
+	refreshToken string
+	username     string // logs in again when the refresh token expired
+	password     string
+}
+
+// Option configures a client
+type Option func(*Client)
+
+// WithToken authenticates with an access token, e.g. one of a service
+// account issued elsewhere. It is not refreshed.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.accessToken = token
+	}
+}
+
+// WithCredentials logs in with a username and password on the first
+// request, and refreshes the tokens or logs in again when they expire
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username, c.password = username, password
+	}
+}
+
+// WithHTTPClient sends requests with an HTTP client, e.g. one trusting a
+// private CA
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetryPolicy replaces the default retry policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithUserAgent sets the User-Agent of the requests, e.g. the name of the
+// integrating tool
+func WithUserAgent(agent string) Option {
+	return func(c *Client) {
+		c.userAgent = agent
+	}
+}
+
+// New creates a client of the server at baseURL, e.g.
+// https://kubeforge.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server URL %q, expected http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		retry:      DefaultRetryPolicy,
+		userAgent:  userAgent,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.Attempts < 1 {
+		c.retry.Attempts = 1
+	}
+	return c, nil
+}
+
+// APIError is an error response of the API
+type APIError struct {
+	StatusCode int
+	Code       string // e.g. NOT_FOUND, VALIDATION_FAILED
+	Message    string
+	Details    []FieldError // invalid fields of a VALIDATION_FAILED error
+	Warnings   []FieldError // spec lint warnings found along with the errors
+	RequestID  string       // ID of the request in the server's log
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+	for _, detail := range e.Details {
+		message += "; " + detail.Field + ": " + detail.Message
+	}
+	return message
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 response, e.g. a cluster name in
+// use or a job that already finished
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+// request is an API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	header http.Header
+	noAuth bool // login and refresh
+}
+
+// envelope is the response body of the API
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   *struct {
+		Code     string       `json:"code"`
+		Message  string       `json:"message"`
+		Details  []FieldError `json:"details"`
+		Warnings []FieldError `json:"warnings"`
+	} `json:"error"`
+}
+
+// call runs an API call and decodes the data of the response into out,
+// unless it is nil
+func (c *Client) call(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return fmt.Errorf("invalid response of %s %s: %w", req.method, req.path, err)
+	}
+	if out == nil || len(env.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return fmt.Errorf("invalid response of %s %s: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+// send runs an API call, authenticating and retrying it as needed, and
+// returns the successful response. Error responses are returned as
+// *APIError.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		token := ""
+		if !req.noAuth {
+			var err error
+			if token, err = c.token(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.do(ctx, req, body, token)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+
+		// An expired access token is refreshed once
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !req.noAuth && !reauthenticated && c.canLogIn() {
+			resp.Body.Close()
+			if err := c.reauthenticate(ctx, token); err != nil {
+				return nil, err
+			}
+			reauthenticated = true
+			attempt--
+			continue
+		}
+
+		if attempt >= c.retry.Attempts || !c.retryable(req, resp, err) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, readError(resp)
+		}
+		wait := c.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// do sends a request once
+func (c *Client) do(ctx context.Context, req request, body []byte, token string) (*http.Response, error) {
+	u := c.url(req.path, req.query)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		r.Header[name] = values
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if r.Header.Get("Accept") == "" {
+		r.Header.Set("Accept", "application/json")
+	}
+	r.Header.Set("User-Agent", c.userAgent)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(r)
+}
+
+// url returns the URL of an API path
+func (c *Client) url(path string, query url.Values) *url.URL {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// retryable reports whether a failed request may be tried again
+func (c *Client) retryable(req request, resp *http.Response, err error) bool {
+	switch req.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		if req.header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait before the next try, the Retry-After of the
+// response if it has one
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, c.retry.MaxBackoff)
+		}
+	}
+	wait := c.retry.InitialBackoff << (attempt - 1)
+	if wait <= 0 || wait > c.retry.MaxBackoff {
+		wait = c.retry.MaxBackoff
+	}
+	// Jitter spreads the retries of many clients
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// readError reads the error of a response
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode), RequestID: resp.Header.Get(requestIDHeader)}
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err == nil && env.Error != nil {
+		apiErr.Code, apiErr.Message = env.Error.Code, env.Error.Message
+		apiErr.Details, apiErr.Warnings = env.Error.Details, env.Error.Warnings
+	}
+	return apiErr
+}
+
+// token returns the access token, logging in first when the client has
+// credentials but no token yet
+func (c *Client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token := c.accessToken
+	c.mu.Unlock()
+	if token != "" || !c.canLogIn() {
+		return token, nil
+	}
+	if err := c.reauthenticate(ctx, ""); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.accessToken, nil
+}
+
+// canLogIn reports whether the client can get new tokens by itself
+func (c *Client) canLogIn() bool {
+	return c.username != ""
+}
+
+// reauthenticate replaces an expired access token: with the refresh token,
+// or by logging in again. Concurrent callers with the same expired token
+// get new tokens once.
+func (c *Client) reauthenticate(ctx context.Context, expired string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != expired {
+		return nil
+	}
+	if c.refreshToken != "" {
+		var pair TokenPair
+		err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/refresh", body: map[string]string{"refresh_token": c.refreshToken}, noAuth: true}, &pair)
+		if err == nil {
+			c.accessToken, c.refreshToken = pair.AccessToken, pair.RefreshToken
+			return nil
+		}
+	}
+	pair, err := c.login(ctx, c.username, c.password)
+	if err != nil {
+		return err
+	}
+	c.accessToken, c.refreshToken = pair.AccessToken, pair.RefreshToken
+	return nil
+}
+
+// Login logs in with a username and password and uses the tokens for the
+// next requests
+func (c *Client) Login(ctx context.Context, username, password string) (*TokenPair, error) {
+	pair, err := c.login(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.accessToken, c.refreshToken = pair.AccessToken, pair.RefreshToken
+	c.mu.Unlock()
+	return pair, nil
+}
+
+// login runs POST /api/v1/auth/login
+func (c *Client) login(ctx context.Context, username, password string) (*TokenPair, error) {
+	var pair TokenPair
+	err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/login", body: map[string]string{"username": username, "password": password}, noAuth: true}, &pair)
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// Logout revokes the refresh token of the client
+func (c *Client) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	refreshToken := c.refreshToken
+	c.mu.Unlock()
+	if refreshToken == "" {
+		return nil
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/auth/logout", body: map[string]string{"refresh_token": refreshToken}}, nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.accessToken, c.refreshToken = "", ""
+	c.mu.Unlock()
+	return nil
+}
+
+// Me returns the authenticated user
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/auth/me"}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
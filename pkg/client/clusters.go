@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListClustersOptions filters the clusters of ListClusters
+type ListClustersOptions struct {
+	Labels         []string // key=value labels the clusters must all have
+	Query          string   // part of the name, matched regardless of case
+	IncludeDeleted bool
+}
+
+// ListClusters lists the clusters of the caller's projects
+func (c *Client) ListClusters(ctx context.Context, opts ListClustersOptions) ([]Cluster, error) {
+	query := url.Values{}
+	for _, label := range opts.Labels {
+		query.Add("label", label)
+	}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+	if opts.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	var clusters []Cluster
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/api/v1/clusters", query: query}, &clusters); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// GetCluster returns a cluster with its nodes and latest resource usage
+func (c *Client) GetCluster(ctx context.Context, id uint) (*Cluster, error) {
+	var cluster Cluster
+	if err := c.call(ctx, request{method: http.MethodGet, path: clusterPath(id)}, &cluster); err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// CreateCluster validates a cluster spec and starts provisioning it. With an
+// idempotency key the request is retried like a read, and a repeated request
+// returns the cluster created first.
+func (c *Client) CreateCluster(ctx context.Context, spec ClusterSpec, idempotencyKey string) (*CreateClusterResponse, error) {
+	req := request{method: http.MethodPost, path: "/api/v1/clusters", body: spec}
+	if idempotencyKey != "" {
+		req.header = http.Header{"Idempotency-Key": {idempotencyKey}}
+	}
+	var created CreateClusterResponse
+	if err := c.call(ctx, req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteCluster deletes a cluster and the machines created for it
+func (c *Client) DeleteCluster(ctx context.Context, id uint) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: clusterPath(id)}, nil)
+}
+
+// ListNodes lists the nodes of a cluster, with the given role unless it is
+// empty
+func (c *Client) ListNodes(ctx context.Context, clusterID uint, role string) ([]Node, error) {
+	query := url.Values{}
+	if role != "" {
+		query.Set("role", role)
+	}
+	var nodes []Node
+	if err := c.call(ctx, request{method: http.MethodGet, path: clusterPath(clusterID) + "/nodes", query: query}, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Kubeconfig returns the kubeconfig of a cluster. A role of view, edit or
+// admin gets a short-lived credential bound to that role instead of the
+// admin kubeconfig.
+func (c *Client) Kubeconfig(ctx context.Context, id uint, role string) ([]byte, error) {
+	query := url.Values{}
+	if role != "" {
+		query.Set("role", role)
+	}
+	// Errors come in the JSON envelope, the kubeconfig as YAML
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: clusterPath(id) + "/kubeconfig", query: query})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	kubeconfig, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	return kubeconfig, nil
+}
+
+// clusterPath returns the API path of a cluster
+func clusterPath(id uint) string {
+	return "/api/v1/clusters/" + strconv.FormatUint(uint64(id), 10)
+}
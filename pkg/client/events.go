@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrStreamClosed is returned by Recv once the stream was closed
+var ErrStreamClosed = errors.New("event stream closed")
+
+// EventFilter selects the events of ListEvents and StreamEvents. Zero
+// fields match everything.
+type EventFilter struct {
+	Level string // this level or more severe: debug, info, warn or error
+	Host  string
+	Step  string
+	Phase string
+	JobID uint
+}
+
+// query returns the query parameters of the filter
+func (f EventFilter) query() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{"level": f.Level, "host": f.Host, "step": f.Step, "phase": f.Phase} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if f.JobID != 0 {
+		query.Set("job_id", strconv.FormatUint(uint64(f.JobID), 10))
+	}
+	return query
+}
+
+// ListEvents returns the latest 100 events of a cluster, newest first
+func (c *Client) ListEvents(ctx context.Context, clusterID uint, filter EventFilter) ([]Event, error) {
+	var events []Event
+	if err := c.call(ctx, request{method: http.MethodGet, path: clusterPath(clusterID) + "/events", query: filter.query()}, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// StreamMessage is a message of an event stream. Exactly one field is set.
+type StreamMessage struct {
+	Event    *Event
+	Progress *JobProgress
+	Output   *CommandOutput
+}
+
+// EventStream receives the events, job progress and command output of
+// clusters as they happen
+type EventStream struct {
+	c     *Client
+	path  string
+	query url.Values
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	lastID uint // newest event received, older ones are skipped after reconnecting
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// StreamEvents opens a WebSocket stream of the events of a cluster, or of
+// all clusters of the caller's projects when clusterID is 0. A stream of a
+// cluster first receives its 50 most recent events. Dropped connections are
+// opened again as the retry policy allows; a stream of all clusters misses
+// the events recorded in between.
+func (c *Client) StreamEvents(ctx context.Context, clusterID uint, filter EventFilter) (*EventStream, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := &EventStream{c: c, path: "/api/v1/events/stream", query: filter.query(), ctx: ctx, cancel: cancel}
+	if clusterID != 0 {
+		s.path = "/ws/clusters/" + strconv.FormatUint(uint64(clusterID), 10) + "/events"
+	}
+	if err := s.connect(); err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv waits for the next message of the stream. It returns
+// ErrStreamClosed after Close, the error of the context once it is done, or
+// the error of the last try when the connection could not be opened again.
+func (s *EventStream) Recv() (*StreamMessage, error) {
+	for {
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+		if conn == nil {
+			if err := s.reconnect(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil, s.closedErr()
+			}
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+
+		msg, err := decodeStreamMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Event != nil {
+			// Events sent again after reconnecting
+			if msg.Event.ID <= s.lastID {
+				continue
+			}
+			s.lastID = msg.Event.ID
+		}
+		return msg, nil
+	}
+}
+
+// Close closes the stream, ending a pending Recv
+func (s *EventStream) Close() error {
+	s.cancel(ErrStreamClosed)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// closedErr returns the error of Recv once the context of the stream is
+// done
+func (s *EventStream) closedErr() error {
+	return context.Cause(s.ctx)
+}
+
+// reconnect opens the connection again, waiting between tries
+func (s *EventStream) reconnect() error {
+	var err error
+	for attempt := 1; attempt <= s.c.retry.Attempts; attempt++ {
+		select {
+		case <-time.After(s.c.backoff(attempt, nil)):
+		case <-s.ctx.Done():
+			return s.closedErr()
+		}
+		if err = s.connect(); err == nil {
+			return nil
+		}
+		if s.ctx.Err() != nil {
+			return s.closedErr()
+		}
+	}
+	return fmt.Errorf("event stream lost: %w", err)
+}
+
+// connect opens the WebSocket connection, refreshing an expired token once
+func (s *EventStream) connect() error {
+	u := s.c.url(s.path, s.query)
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 30 * time.Second}
+	if transport, ok := s.c.httpClient.Transport.(*http.Transport); ok {
+		dialer.Proxy, dialer.TLSClientConfig = transport.Proxy, transport.TLSClientConfig
+	}
+
+	for reauthenticated := false; ; reauthenticated = true {
+		token, err := s.c.token(s.ctx)
+		if err != nil {
+			return err
+		}
+		header := http.Header{"User-Agent": {s.c.userAgent}}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+
+		conn, resp, err := dialer.DialContext(s.ctx, u.String(), header)
+		if err == nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.ctx.Err() != nil {
+				conn.Close()
+				return s.closedErr()
+			}
+			s.conn = conn
+			return nil
+		}
+		if resp == nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !reauthenticated && s.c.canLogIn() {
+			resp.Body.Close()
+			if err := s.c.reauthenticate(s.ctx, token); err != nil {
+				return err
+			}
+			continue
+		}
+		return readError(resp)
+	}
+}
+
+// decodeStreamMessage decodes a message of the stream by its type
+func decodeStreamMessage(data []byte) (*StreamMessage, error) {
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return nil, fmt.Errorf("invalid stream message: %w", err)
+	}
+	msg := &StreamMessage{}
+	var err error
+	switch kind.Type {
+	case "progress":
+		msg.Progress = &JobProgress{}
+		err = json.Unmarshal(data, msg.Progress)
+	case "output":
+		msg.Output = &CommandOutput{}
+		err = json.Unmarshal(data, msg.Output)
+	default:
+		msg.Event = &Event{}
+		err = json.Unmarshal(data, msg.Event)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream message: %w", err)
+	}
+	return msg, nil
+}
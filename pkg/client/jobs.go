@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListJobsOptions filters the jobs of ListJobs
+type ListJobsOptions struct {
+	ClusterID uint   // jobs of a cluster, 0 for all clusters of the caller's projects
+	Status    string // pending, running, completed, failed or cancelled
+	Type      string // provision, upgrade, destroy, batch and others
+	ParentID  uint   // jobs started by a batch job
+	Limit     int    // at most 1000, 100 when 0
+}
+
+// ListJobs lists jobs of the caller's projects, newest first
+func (c *Client) ListJobs(ctx context.Context, opts ListJobsOptions) ([]Job, error) {
+	path := "/api/v1/jobs"
+	if opts.ClusterID != 0 {
+		path = clusterPath(opts.ClusterID) + "/jobs"
+	}
+	query := url.Values{}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.Type != "" {
+		query.Set("type", opts.Type)
+	}
+	if opts.ParentID != 0 {
+		query.Set("parent_id", strconv.FormatUint(uint64(opts.ParentID), 10))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var jobs []Job
+	if err := c.call(ctx, request{method: http.MethodGet, path: path, query: query}, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetJob returns a job
+func (c *Client) GetJob(ctx context.Context, id uint) (*Job, error) {
+	var job Job
+	if err := c.call(ctx, request{method: http.MethodGet, path: jobPath(id)}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob requests the cancellation of a pending or running job. It
+// returns a conflict error when the job already finished.
+func (c *Client) CancelJob(ctx context.Context, id uint) error {
+	return c.call(ctx, request{method: http.MethodPost, path: jobPath(id) + "/cancel"}, nil)
+}
+
+// WaitJob polls a job every interval until it finished and returns it. A
+// failed or cancelled job is returned without an error; check its status.
+func (c *Client) WaitJob(ctx context.Context, id uint, interval time.Duration) (*Job, error) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.GetJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Finished() {
+			return job, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// jobPath returns the API path of a job
+func jobPath(id uint) string {
+	return "/api/v1/jobs/" + strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TokenPair is the result of a login or a token refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// User is a user of the server
+type User struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"` // admin, operator, viewer
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Cluster statuses
+const (
+	ClusterPending      = "pending"
+	ClusterProvisioning = "provisioning"
+	ClusterReady        = "ready"
+	ClusterUpgrading    = "upgrading"
+	ClusterReconciling  = "reconciling"
+	ClusterFailed       = "failed"
+	ClusterDestroying   = "destroying"
+	ClusterDeleted      = "deleted"
+)
+
+// Cluster is a Kubernetes cluster managed by the server
+type Cluster struct {
+	ID                uint              `json:"id"`
+	ProjectID         uint              `json:"project_id"`
+	Name              string            `json:"name"`
+	K8sVersion        string            `json:"k8s_version"`
+	PodNetworkCIDR    string            `json:"pod_network_cidr"`
+	ServiceCIDR       string            `json:"service_cidr"`
+	CNI               string            `json:"cni"`
+	ContainerRuntime  string            `json:"container_runtime"`
+	APIServerEndpoint string            `json:"api_server_endpoint"`
+	LoadBalancerIP    string            `json:"load_balancer_ip,omitempty"`
+	IngressEndpoints  []string          `json:"ingress_endpoints,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Notifications     []string          `json:"notifications,omitempty"`
+	AutoUpgrade       string            `json:"auto_upgrade,omitempty"`
+	HardeningProfile  string            `json:"hardening_profile,omitempty"`
+	TemplateID        uint              `json:"template_id,omitempty"`
+	Provider          string            `json:"provider"`
+	Status            string            `json:"status"`
+	StatusMessage     string            `json:"status_message,omitempty"` // why the cluster failed
+	StatusChangedAt   *time.Time        `json:"status_changed_at,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+	Nodes             []Node            `json:"nodes,omitempty"`
+	Resources         *ClusterResources `json:"resources,omitempty"` // latest metrics sample, GetCluster only
+}
+
+// ClusterResources is the capacity and usage of the nodes of a cluster
+type ClusterResources struct {
+	CPUUsage       int64     `json:"cpu_usage_millicores"`
+	CPUCapacity    int64     `json:"cpu_capacity_millicores"`
+	MemoryUsage    int64     `json:"memory_usage_bytes"`
+	MemoryCapacity int64     `json:"memory_capacity_bytes"`
+	CollectedAt    time.Time `json:"collected_at"`
+}
+
+// Node is a node of a cluster
+type Node struct {
+	ID               uint              `json:"id"`
+	ClusterID        uint              `json:"cluster_id"`
+	Hostname         string            `json:"hostname"`
+	Address          string            `json:"address"`
+	User             string            `json:"user"`
+	Port             int               `json:"port"`
+	Role             string            `json:"role"`   // control-plane, worker
+	Status           string            `json:"status"` // ready, notready, unknown, provisioning, upgrading, maintenance, removing, failed
+	K8sVersion       string            `json:"k8s_version"`
+	ContainerRuntime string            `json:"container_runtime"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Cordoned         bool              `json:"cordoned,omitempty"`
+	JoinedAt         *time.Time        `json:"joined_at,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// Job statuses
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a background job of the server, e.g. provisioning a cluster
+type Job struct {
+	ID         uint       `json:"id"`
+	ClusterID  uint       `json:"cluster_id,omitempty"`
+	ParentID   uint       `json:"parent_id,omitempty"` // batch job that started the job
+	Type       string     `json:"type"`                // provision, upgrade, reconcile, destroy, add-node, remove-node, renew-certs, batch
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"` // 0-100
+	Phase      string     `json:"phase,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Metadata   string     `json:"metadata,omitempty"` // JSON encoded metadata
+	RequestID  string     `json:"request_id,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Finished reports whether the job completed, failed or was cancelled
+func (j *Job) Finished() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// Event is an event recorded for a cluster
+type Event struct {
+	ID         uint      `json:"id"`
+	ClusterID  uint      `json:"cluster_id"`
+	JobID      uint      `json:"job_id,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Level      string    `json:"level"` // debug, info, warn, error
+	Host       string    `json:"host"`
+	Step       string    `json:"step"`
+	Phase      string    `json:"phase,omitempty"`
+	StepStatus string    `json:"step_status,omitempty"` // running, succeeded or failed on the events starting and ending a step
+	Message    string    `json:"message"`
+	Output     string    `json:"output,omitempty"`
+}
+
+// JobProgress is a progress update of a running job
+type JobProgress struct {
+	JobID     uint   `json:"job_id"`
+	ClusterID uint   `json:"cluster_id"`
+	Phase     string `json:"phase"`
+	Progress  int    `json:"progress"`
+}
+
+// CommandOutput is a chunk of the output of a long command run on a host
+type CommandOutput struct {
+	ClusterID uint   `json:"cluster_id"`
+	JobID     uint   `json:"job_id,omitempty"`
+	Host      string `json:"host"`
+	Step      string `json:"step"`
+	Command   string `json:"command"`
+	Data      string `json:"data"`
+}
+
+// FieldError is a problem with a field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ClusterSpec is a cluster to create. Extra holds fields the type does not
+// model, e.g. hooks or infrastructure, by their JSON name; they are sent
+// with the other fields and kept when a spec is decoded from JSON.
+type ClusterSpec struct {
+	Name              string            `json:"name"`
+	ProjectID         uint              `json:"project_id,omitempty"`
+	TemplateID        uint              `json:"template_id,omitempty"` // template filling the fields left empty
+	K8sVersion        string            `json:"k8s_version,omitempty"`
+	PodNetworkCIDR    string            `json:"pod_network_cidr,omitempty"`
+	ServiceCIDR       string            `json:"service_cidr,omitempty"`
+	CNI               string            `json:"cni,omitempty"`
+	ContainerRuntime  string            `json:"container_runtime,omitempty"`
+	Provider          string            `json:"provider,omitempty"` // provisioner, kubeadm when empty
+	APIServerEndpoint string            `json:"api_server_endpoint,omitempty"`
+	ControlPlanes     []HostSpec        `json:"control_planes,omitempty"`
+	Workers           []HostSpec        `json:"workers,omitempty"`
+	Addons            []AddonSpec       `json:"addons,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Notifications     []string          `json:"notifications,omitempty"`
+	HardeningProfile  string            `json:"hardening_profile,omitempty"` // baseline or cis
+	KubeadmConfig     string            `json:"kubeadm_config,omitempty"`
+	Bastion           *HostSpec         `json:"bastion,omitempty"` // jump host of the hosts without their own
+
+	InsecureSkipHostKeyCheck bool `json:"insecure_skip_host_key_check,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON encodes the spec with its extra fields
+func (s ClusterSpec) MarshalJSON() ([]byte, error) {
+	type plain ClusterSpec
+	data, err := json.Marshal(plain(s))
+	if err != nil || len(s.Extra) == 0 {
+		return data, err
+	}
+	fields := make(map[string]interface{}, len(s.Extra))
+	for name, value := range s.Extra {
+		fields[name] = value
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes a spec, keeping the fields it does not model in
+// Extra
+func (s *ClusterSpec) UnmarshalJSON(data []byte) error {
+	type plain ClusterSpec
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	typ := reflect.TypeOf(*s)
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		delete(fields, name)
+	}
+	s.Extra = nil
+	if len(fields) > 0 {
+		s.Extra = fields
+	}
+	return nil
+}
+
+// HostSpec is a host of a cluster to create, reached over SSH
+type HostSpec struct {
+	Hostname         string            `json:"hostname,omitempty"`
+	Address          string            `json:"address,omitempty"` // IP or DNS
+	Port             int               `json:"port,omitempty"`    // SSH port, default 22
+	User             string            `json:"user,omitempty"`
+	SSHKey           string            `json:"ssh_key,omitempty"`      // private key content
+	SSHKeyPath       string            `json:"ssh_key_path,omitempty"` // or path of a key file on the server
+	SSHKeyID         uint              `json:"ssh_key_id,omitempty"`   // or a stored SSH key by ID
+	SSHKeyName       string            `json:"ssh_key_name,omitempty"` // or by name
+	SSHKeyPassphrase string            `json:"ssh_key_passphrase,omitempty"`
+	SSHAgent         bool              `json:"ssh_agent,omitempty"`
+	UseSudo          bool              `json:"use_sudo,omitempty"`
+	SudoPassword     string            `json:"sudo_password,omitempty"`
+	Bastion          *HostSpec         `json:"bastion,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Taints           []string          `json:"taints,omitempty"`
+	HostID           uint              `json:"host_id,omitempty"` // inventory host the connection settings are taken from
+}
+
+// AddonSpec is an addon installed with a cluster
+type AddonSpec struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"` // addon specific settings
+}
+
+// CreateClusterResponse is a cluster being provisioned
+type CreateClusterResponse struct {
+	Cluster
+	JobID    uint         `json:"job_id"`             // provisioning job
+	Warnings []FieldError `json:"warnings,omitempty"` // spec lint warnings
+}
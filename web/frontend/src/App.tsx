@@ -16,7 +16,7 @@ const queryClient = new QueryClient({
 function App() {
   return (
     <QueryClientProvider client={queryClient}>
-      <BrowserRouter>
+      <BrowserRouter basename="/ui">
         <div className="min-h-screen bg-gray-50">
           {/* Header */}
           <header className="bg-white border-b border-gray-200">
@@ -1,6 +1,13 @@
 import axios from 'axios';
 
-const API_BASE_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+// The UI is served by the API server, so it calls the API of its own origin
+const API_BASE_URL = import.meta.env.VITE_API_URL || '';
+
+// Tokens stored by the sign in page at /ui/login
+const ACCESS_TOKEN_KEY = 'kubeforge.access_token';
+const REFRESH_TOKEN_KEY = 'kubeforge.refresh_token';
+
+export const accessToken = () => localStorage.getItem(ACCESS_TOKEN_KEY);
 
 export const apiClient = axios.create({
   baseURL: API_BASE_URL,
@@ -9,6 +16,46 @@ export const apiClient = axios.create({
   },
 });
 
+apiClient.interceptors.request.use((config) => {
+  const token = accessToken();
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+// signIn sends the user to the sign in page, back to the current page after
+const signIn = () => {
+  const next = window.location.pathname + window.location.search;
+  window.location.assign(`/ui/login?next=${encodeURIComponent(next)}`);
+};
+
+// An expired access token is refreshed once, then the request is retried
+apiClient.interceptors.response.use(undefined, async (error) => {
+  const request = error.config;
+  if (error.response?.status !== 401 || !request || request._retried) {
+    throw error;
+  }
+  const refreshToken = localStorage.getItem(REFRESH_TOKEN_KEY);
+  if (!refreshToken) {
+    signIn();
+    throw error;
+  }
+  try {
+    const response = await axios.post(`${API_BASE_URL}/api/v1/auth/refresh`, {
+      refresh_token: refreshToken,
+    });
+    localStorage.setItem(ACCESS_TOKEN_KEY, response.data.data.access_token);
+    localStorage.setItem(REFRESH_TOKEN_KEY, response.data.data.refresh_token);
+  } catch {
+    localStorage.removeItem(REFRESH_TOKEN_KEY);
+    signIn();
+    throw error;
+  }
+  request._retried = true;
+  return apiClient(request);
+});
+
 export interface Cluster {
   id: number;
   name: string;
@@ -46,6 +93,37 @@ export interface ProvisionEvent {
   output?: string;
 }
 
+export interface Job {
+  id: number;
+  cluster_id?: number;
+  type: string;
+  status: string;
+  progress: number;
+  phase?: string;
+  error?: string;
+  started_at?: string;
+  finished_at?: string;
+  created_at: string;
+}
+
+export interface FailingNode extends Node {
+  cluster_name: string;
+}
+
+export interface Summary {
+  clusters: number;
+  by_status: Record<string, number>;
+  recent_jobs: Job[] | null;
+  failing_nodes: FailingNode[];
+}
+
+export interface ClusterOverview {
+  cluster: Cluster;
+  nodes: Node[];
+  last_job: Job | null;
+  events: ProvisionEvent[];
+}
+
 export interface CreateClusterRequest {
   name: string;
   k8s_version: string;
@@ -69,18 +147,25 @@ export interface HostSpec {
 
 // API functions
 export const clustersApi = {
-  list: () => apiClient.get<{ success: boolean; data: Cluster[] }>('/api/clusters'),
+  list: () => apiClient.get<{ success: boolean; data: Cluster[] }>('/api/v1/clusters'),
+
+  get: (id: number) => apiClient.get<{ success: boolean; data: Cluster }>(`/api/v1/clusters/${id}`),
 
-  get: (id: number) => apiClient.get<{ success: boolean; data: Cluster }>(`/api/clusters/${id}`),
+  overview: (id: number) =>
+    apiClient.get<{ success: boolean; data: ClusterOverview }>(`/api/v1/clusters/${id}/overview`),
 
   create: (data: CreateClusterRequest) =>
-    apiClient.post<{ success: boolean; data: Cluster }>('/api/clusters', data),
+    apiClient.post<{ success: boolean; data: Cluster }>('/api/v1/clusters', data),
 
-  delete: (id: number) => apiClient.delete(`/api/clusters/${id}`),
+  delete: (id: number) => apiClient.delete(`/api/v1/clusters/${id}`),
 
   getKubeconfig: (id: number) =>
-    apiClient.get(`/api/clusters/${id}/kubeconfig`, { responseType: 'blob' }),
+    apiClient.get(`/api/v1/clusters/${id}/kubeconfig`, { responseType: 'blob' }),
 
   getEvents: (id: number) =>
-    apiClient.get<{ success: boolean; data: ProvisionEvent[] }>(`/api/clusters/${id}/events`),
+    apiClient.get<{ success: boolean; data: ProvisionEvent[] }>(`/api/v1/clusters/${id}/events`),
+};
+
+export const summaryApi = {
+  get: () => apiClient.get<{ success: boolean; data: Summary }>('/api/v1/summary'),
 };
@@ -10,15 +10,17 @@ export const ClusterDetail = () => {
   const logsEndRef = useRef<HTMLDivElement>(null);
   const clusterId = id ? parseInt(id) : null;
 
-  const { data: cluster, isLoading } = useQuery({
-    queryKey: ['cluster', clusterId],
+  const { data: overview, isLoading } = useQuery({
+    queryKey: ['cluster-overview', clusterId],
     queryFn: async () => {
-      const response = await clustersApi.get(clusterId!);
+      const response = await clustersApi.overview(clusterId!);
       return response.data.data;
     },
     enabled: !!clusterId,
     refetchInterval: 3000,
   });
+  const cluster = overview?.cluster;
+  const lastJob = overview?.last_job;
 
   const { data: eventsData } = useQuery({
     queryKey: ['events', clusterId],
@@ -91,7 +93,7 @@ export const ClusterDetail = () => {
     );
   }
 
-  if (!cluster) {
+  if (!overview || !cluster) {
     return (
       <div className="bg-red-50 border border-red-200 rounded-lg p-4">
         <p className="text-red-800">Cluster not found</p>
@@ -178,12 +180,31 @@ export const ClusterDetail = () => {
             </dl>
           </div>
 
+          {/* Last Job */}
+          {lastJob && (
+            <div className="bg-white border border-gray-200 rounded-lg p-6 mt-6">
+              <h2 className="text-lg font-semibold text-gray-900 mb-4">Last Job</h2>
+              <div className="flex justify-between text-sm mb-2">
+                <span className="font-medium text-gray-900">{lastJob.type}</span>
+                <span className="text-gray-600">{lastJob.status}</span>
+              </div>
+              <div className="w-full bg-gray-200 rounded-full h-2">
+                <div
+                  className="bg-blue-600 h-2 rounded-full"
+                  style={{ width: `${lastJob.progress}%` }}
+                ></div>
+              </div>
+              {lastJob.phase && <div className="text-xs text-gray-500 mt-2">{lastJob.phase}</div>}
+              {lastJob.error && <div className="text-xs text-red-600 mt-2">{lastJob.error}</div>}
+            </div>
+          )}
+
           {/* Nodes */}
           <div className="bg-white border border-gray-200 rounded-lg p-6 mt-6">
             <h2 className="text-lg font-semibold text-gray-900 mb-4">Nodes</h2>
-            {cluster.nodes && cluster.nodes.length > 0 ? (
+            {overview.nodes.length > 0 ? (
               <div className="space-y-3">
-                {cluster.nodes.map((node) => (
+                {overview.nodes.map((node) => (
                   <div key={node.id} className="border border-gray-200 rounded-md p-3">
                     <div className="flex justify-between items-start mb-2">
                       <span className="font-medium text-gray-900">{node.hostname}</span>
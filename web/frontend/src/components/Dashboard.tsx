@@ -1,5 +1,5 @@
 import { useQuery } from '@tanstack/react-query';
-import { clustersApi, type Cluster } from '../api/client';
+import { clustersApi, summaryApi, type Cluster } from '../api/client';
 import { Link } from 'react-router-dom';
 
 export const Dashboard = () => {
@@ -12,6 +12,15 @@ export const Dashboard = () => {
     refetchInterval: 5000, // Refresh every 5 seconds
   });
 
+  const { data: summary } = useQuery({
+    queryKey: ['summary'],
+    queryFn: async () => {
+      const response = await summaryApi.get();
+      return response.data.data;
+    },
+    refetchInterval: 5000,
+  });
+
   const handleDelete = async (id: number) => {
     if (!confirm('Are you sure you want to delete this cluster?')) return;
     try {
@@ -66,6 +75,63 @@ export const Dashboard = () => {
         </Link>
       </div>
 
+      {summary && summary.clusters > 0 && (
+        <div className="grid gap-4 md:grid-cols-3 mb-6">
+          <div className="bg-white border border-gray-200 rounded-lg p-6">
+            <h2 className="text-sm font-medium text-gray-600 mb-3">
+              Clusters ({summary.clusters})
+            </h2>
+            <div className="flex flex-wrap gap-2">
+              {Object.entries(summary.by_status).map(([status, count]) => (
+                <span
+                  key={status}
+                  className={`px-2 py-1 text-xs font-medium rounded-full ${getStatusColor(status)}`}
+                >
+                  {status}: {count}
+                </span>
+              ))}
+            </div>
+          </div>
+
+          <div className="bg-white border border-gray-200 rounded-lg p-6">
+            <h2 className="text-sm font-medium text-gray-600 mb-3">Recent Jobs</h2>
+            {summary.recent_jobs && summary.recent_jobs.length > 0 ? (
+              <ul className="space-y-1 text-sm">
+                {summary.recent_jobs.slice(0, 5).map((job) => (
+                  <li key={job.id} className="flex justify-between">
+                    <span className="text-gray-900">
+                      {job.type}
+                      {job.cluster_id ? ` #${job.cluster_id}` : ''}
+                    </span>
+                    <span className="text-gray-500">{job.status}</span>
+                  </li>
+                ))}
+              </ul>
+            ) : (
+              <p className="text-sm text-gray-500">No jobs yet</p>
+            )}
+          </div>
+
+          <div className="bg-white border border-gray-200 rounded-lg p-6">
+            <h2 className="text-sm font-medium text-gray-600 mb-3">Failing Nodes</h2>
+            {summary.failing_nodes.length > 0 ? (
+              <ul className="space-y-1 text-sm">
+                {summary.failing_nodes.map((node) => (
+                  <li key={node.id} className="flex justify-between">
+                    <Link to={`/clusters/${node.cluster_id}`} className="text-blue-600 hover:text-blue-700">
+                      {node.cluster_name}/{node.hostname}
+                    </Link>
+                    <span className="text-red-600">{node.status}</span>
+                  </li>
+                ))}
+              </ul>
+            ) : (
+              <p className="text-sm text-gray-500">All nodes are ready</p>
+            )}
+          </div>
+        </div>
+      )}
+
       {clusters.length === 0 ? (
         <div className="bg-gray-50 border-2 border-dashed border-gray-300 rounded-lg p-12 text-center">
           <svg
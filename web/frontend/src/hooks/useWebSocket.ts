@@ -1,7 +1,9 @@
 import { useEffect, useRef, useState, useCallback } from 'react';
-import type { ProvisionEvent } from '../api/client';
+import { accessToken, type ProvisionEvent } from '../api/client';
 
-const WS_BASE_URL = import.meta.env.VITE_WS_URL || 'ws://localhost:8080';
+const WS_BASE_URL =
+  import.meta.env.VITE_WS_URL ||
+  `${window.location.protocol === 'https:' ? 'wss:' : 'ws:'}//${window.location.host}`;
 
 interface UseWebSocketOptions {
   onMessage?: (event: ProvisionEvent) => void;
@@ -18,7 +20,9 @@ export const useWebSocket = (clusterId: number | null, options: UseWebSocketOpti
   const connect = useCallback(() => {
     if (!clusterId || wsRef.current) return;
 
-    const ws = new WebSocket(`${WS_BASE_URL}/ws/clusters/${clusterId}/events`);
+    // Browsers cannot set headers on WebSocket connections
+    const token = encodeURIComponent(accessToken() || '');
+    const ws = new WebSocket(`${WS_BASE_URL}/ws/clusters/${clusterId}/events?token=${token}`);
 
     ws.onopen = () => {
       console.log('WebSocket connected');
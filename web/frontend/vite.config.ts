@@ -3,6 +3,8 @@ import react from '@vitejs/plugin-react'
 
 // https://vite.dev/config/
 export default defineConfig({
+  // Served by the API server under /ui/
+  base: '/ui/',
   plugins: [react()],
   server: {
     port: 3000,
@@ -11,6 +13,11 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
       },
+      // The sign in page is served by the API server
+      '/ui/login': {
+        target: 'http://localhost:8080',
+        changeOrigin: true,
+      },
       '/ws': {
         target: 'ws://localhost:8080',
         ws: true,
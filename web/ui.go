@@ -0,0 +1,19 @@
+//go:build ui
+
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:frontend/dist
+var dist embed.FS
+
+func init() {
+	sub, err := fs.Sub(dist, "frontend/dist")
+	if err != nil {
+		panic(err)
+	}
+	Dist = sub
+}
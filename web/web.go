@@ -0,0 +1,18 @@
+// Package web holds the single-page UI the server serves under /ui/. The UI
+// is built by npm into frontend/dist and only embedded in binaries built
+// with the ui tag (make build-ui), so the server builds without node. The
+// login page is plain HTML and always embedded.
+package web
+
+import (
+	_ "embed"
+	"io/fs"
+)
+
+// Dist is the built UI, nil when the binary was built without it
+var Dist fs.FS
+
+// LoginPage signs in with the API and stores the tokens for the UI
+//
+//go:embed login.html
+var LoginPage []byte